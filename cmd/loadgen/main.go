@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"quantum-safe-mesh/pkg/models"
+)
+
+func getEnvOrDefault(key, defaultValue string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return defaultValue
+}
+
+// options describe one load test run.
+type options struct {
+	url         string
+	method      string
+	body        []byte
+	rps         float64
+	concurrency int
+	duration    time.Duration
+	requests    int
+	timeout     time.Duration
+}
+
+func main() {
+	gatewayURL := flag.String("url", getEnvOrDefault("GATEWAY_URL", "http://localhost:8081"), "gateway URL")
+	path := flag.String("path", "/echo", "request path")
+	method := flag.String("method", http.MethodPost, "HTTP method")
+	data := flag.String("data", `{"message": "Load test", "loadgen": true}`, "request body")
+	rps := flag.Float64("rps", 0, "target requests per second across all workers (0 = as fast as possible)")
+	concurrency := flag.Int("concurrency", 10, "concurrent workers")
+	duration := flag.Duration("duration", 10*time.Second, "how long to run")
+	requests := flag.Int("requests", 0, "stop after this many requests (0 = run for -duration)")
+	timeout := flag.Duration("timeout", 30*time.Second, "per-request timeout")
+	format := flag.String("format", "text", "report format: text, json or csv")
+	out := flag.String("out", "", "write the report to this file instead of stdout")
+	flag.Parse()
+
+	if *concurrency < 1 {
+		fmt.Fprintln(os.Stderr, "❌ -concurrency must be at least 1")
+		os.Exit(2)
+	}
+	if *rps < 0 || *requests < 0 || *duration <= 0 {
+		fmt.Fprintln(os.Stderr, "❌ -rps and -requests must not be negative and -duration must be positive")
+		os.Exit(2)
+	}
+	if *format != "text" && *format != "json" && *format != "csv" {
+		fmt.Fprintf(os.Stderr, "❌ Unknown format %q: expected text, json or csv\n", *format)
+		os.Exit(2)
+	}
+
+	opts := options{
+		url:         strings.TrimSuffix(*gatewayURL, "/") + *path,
+		method:      strings.ToUpper(*method),
+		rps:         *rps,
+		concurrency: *concurrency,
+		duration:    *duration,
+		requests:    *requests,
+		timeout:     *timeout,
+	}
+	if opts.method != http.MethodGet && opts.method != http.MethodHead {
+		opts.body = []byte(*data)
+	}
+
+	target := "unlimited"
+	if opts.rps > 0 {
+		target = fmt.Sprintf("%.0f rps", opts.rps)
+	}
+	limit := fmt.Sprintf("for %v", opts.duration)
+	if opts.requests > 0 {
+		limit = fmt.Sprintf("for %d requests (at most %v)", opts.requests, opts.duration)
+	}
+	fmt.Fprintf(os.Stderr, "⚡ %s %s with %d workers %s (%s)\n", opts.method, opts.url, opts.concurrency, limit, target)
+
+	report := run(opts)
+
+	w := io.Writer(os.Stdout)
+	if *out != "" {
+		file, err := os.Create(*out)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "❌ Failed to create %s: %v\n", *out, err)
+			os.Exit(1)
+		}
+		defer file.Close()
+		w = file
+	}
+
+	var err error
+	switch *format {
+	case "json":
+		err = report.writeJSON(w)
+	case "csv":
+		err = report.writeCSV(w)
+	default:
+		report.writeText(w)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ Failed to write report: %v\n", err)
+		os.Exit(1)
+	}
+	if *out != "" {
+		fmt.Fprintf(os.Stderr, "📄 Report written to %s\n", *out)
+	}
+}
+
+// run drives the gateway until the duration or request count is reached.
+// With a target rate, requests are released on a fixed schedule and workers
+// pick them up as they free up; otherwise each worker sends back to back.
+func run(opts options) *Report {
+	ctx, cancel := context.WithTimeout(context.Background(), opts.duration)
+	defer cancel()
+
+	client := &http.Client{
+		Timeout: opts.timeout,
+		Transport: &http.Transport{
+			MaxIdleConns:        opts.concurrency,
+			MaxIdleConnsPerHost: opts.concurrency,
+		},
+	}
+
+	recorder := newRecorder()
+	jobs := make(chan struct{}, opts.concurrency)
+
+	go func() {
+		defer close(jobs)
+
+		var ticker *time.Ticker
+		if opts.rps > 0 {
+			ticker = time.NewTicker(time.Duration(float64(time.Second) / opts.rps))
+			defer ticker.Stop()
+		}
+
+		for sent := 0; opts.requests == 0 || sent < opts.requests; sent++ {
+			if ticker != nil {
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+				}
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case jobs <- struct{}{}:
+			}
+		}
+	}()
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < opts.concurrency; i++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			clientID := fmt.Sprintf("loadgen-%d", worker)
+			for range jobs {
+				recorder.record(send(client, opts, clientID))
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	return recorder.report(opts, time.Since(start))
+}
+
+// send makes one request and reads the whole response, so the latency
+// covers what a client actually waits for.
+func send(client *http.Client, opts options, clientID string) result {
+	req, err := http.NewRequest(opts.method, opts.url, bytes.NewReader(opts.body))
+	if err != nil {
+		return result{err: err}
+	}
+	if opts.body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("X-Client-ID", clientID)
+
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		return result{latency: time.Since(start), err: err}
+	}
+	_, err = io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	latency := time.Since(start)
+
+	return result{
+		latency: latency,
+		status:  resp.StatusCode,
+		timing:  parseServerTiming(resp.Header.Get(models.HeaderServerTiming)),
+		err:     err,
+	}
+}
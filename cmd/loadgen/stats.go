@@ -0,0 +1,307 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// breakdownMetrics are the gateway's Server-Timing entries, in report order.
+var breakdownMetrics = []string{"marshal", "sign", "upstream", "verify", "countersign"}
+
+// histogramBounds are the upper bounds, in milliseconds, of the latency
+// histogram buckets; a final +Inf bucket catches the rest.
+var histogramBounds = []float64{1, 2, 5, 10, 20, 50, 100, 200, 500, 1000, 2000, 5000}
+
+// result is the outcome of one request.
+type result struct {
+	latency time.Duration
+	status  int
+	timing  map[string]time.Duration
+	err     error
+}
+
+// recorder collects results from all workers.
+type recorder struct {
+	mutex     sync.Mutex
+	latencies []time.Duration
+	breakdown map[string][]time.Duration
+	statuses  map[int]int
+	failures  map[string]int
+	requests  int
+	errors    int
+}
+
+func newRecorder() *recorder {
+	return &recorder{
+		breakdown: make(map[string][]time.Duration),
+		statuses:  make(map[int]int),
+		failures:  make(map[string]int),
+	}
+}
+
+func (r *recorder) record(res result) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.requests++
+	if res.err != nil || res.status >= 400 {
+		r.errors++
+	}
+	if res.err != nil {
+		r.failures[res.err.Error()]++
+	}
+	if res.status == 0 {
+		return
+	}
+
+	r.statuses[res.status]++
+	r.latencies = append(r.latencies, res.latency)
+	for name, duration := range res.timing {
+		r.breakdown[name] = append(r.breakdown[name], duration)
+	}
+}
+
+// Summary describes a set of durations in milliseconds.
+type Summary struct {
+	Count int     `json:"count"`
+	Mean  float64 `json:"mean_ms"`
+	Min   float64 `json:"min_ms"`
+	P50   float64 `json:"p50_ms"`
+	P95   float64 `json:"p95_ms"`
+	P99   float64 `json:"p99_ms"`
+	Max   float64 `json:"max_ms"`
+}
+
+// Bucket is one latency histogram bucket. Counts are per bucket, not
+// cumulative.
+type Bucket struct {
+	LE    string `json:"le_ms"`
+	Count int    `json:"count"`
+}
+
+// Report is the outcome of a load test run.
+type Report struct {
+	URL         string             `json:"url"`
+	Method      string             `json:"method"`
+	Concurrency int                `json:"concurrency"`
+	TargetRPS   float64            `json:"target_rps"`
+	Duration    float64            `json:"duration_seconds"`
+	Requests    int                `json:"requests"`
+	Errors      int                `json:"errors"`
+	ErrorRate   float64            `json:"error_rate"`
+	AchievedRPS float64            `json:"achieved_rps"`
+	StatusCodes map[string]int     `json:"status_codes"`
+	Failures    map[string]int     `json:"transport_errors,omitempty"`
+	Latency     Summary            `json:"latency"`
+	Breakdown   map[string]Summary `json:"breakdown"`
+	Histogram   []Bucket           `json:"histogram"`
+}
+
+func (r *recorder) report(opts options, elapsed time.Duration) *Report {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	report := &Report{
+		URL:         opts.url,
+		Method:      opts.method,
+		Concurrency: opts.concurrency,
+		TargetRPS:   opts.rps,
+		Duration:    elapsed.Seconds(),
+		Requests:    r.requests,
+		Errors:      r.errors,
+		StatusCodes: make(map[string]int),
+		Latency:     summarize(r.latencies),
+		Breakdown:   make(map[string]Summary),
+		Histogram:   histogram(r.latencies),
+	}
+	if r.requests > 0 {
+		report.ErrorRate = float64(r.errors) / float64(r.requests)
+	}
+	if elapsed > 0 {
+		report.AchievedRPS = float64(r.requests) / elapsed.Seconds()
+	}
+	for status, count := range r.statuses {
+		report.StatusCodes[strconv.Itoa(status)] = count
+	}
+	if len(r.failures) > 0 {
+		report.Failures = r.failures
+	}
+	for name, durations := range r.breakdown {
+		report.Breakdown[name] = summarize(durations)
+	}
+	return report
+}
+
+func summarize(durations []time.Duration) Summary {
+	if len(durations) == 0 {
+		return Summary{}
+	}
+
+	sorted := append([]time.Duration(nil), durations...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	var total time.Duration
+	for _, d := range sorted {
+		total += d
+	}
+
+	return Summary{
+		Count: len(sorted),
+		Mean:  millis(total / time.Duration(len(sorted))),
+		Min:   millis(sorted[0]),
+		P50:   millis(percentile(sorted, 50)),
+		P95:   millis(percentile(sorted, 95)),
+		P99:   millis(percentile(sorted, 99)),
+		Max:   millis(sorted[len(sorted)-1]),
+	}
+}
+
+// percentile returns the nearest-rank percentile p of sorted.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+func histogram(latencies []time.Duration) []Bucket {
+	buckets := make([]Bucket, len(histogramBounds)+1)
+	for i, bound := range histogramBounds {
+		buckets[i].LE = strconv.FormatFloat(bound, 'f', -1, 64)
+	}
+	buckets[len(histogramBounds)].LE = "+Inf"
+
+	for _, latency := range latencies {
+		ms := millis(latency)
+		i := sort.SearchFloat64s(histogramBounds, ms)
+		buckets[i].Count++
+	}
+	return buckets
+}
+
+func millis(d time.Duration) float64 {
+	return math.Round(float64(d)/float64(time.Millisecond)*1000) / 1000
+}
+
+// parseServerTiming reads the durations from a Server-Timing header such as
+// "sign;dur=0.412, upstream;dur=3.100".
+func parseServerTiming(header string) map[string]time.Duration {
+	if header == "" {
+		return nil
+	}
+
+	timing := make(map[string]time.Duration)
+	for _, metric := range strings.Split(header, ",") {
+		params := strings.Split(strings.TrimSpace(metric), ";")
+		for _, param := range params[1:] {
+			value, found := strings.CutPrefix(strings.TrimSpace(param), "dur=")
+			if !found {
+				continue
+			}
+			ms, err := strconv.ParseFloat(value, 64)
+			if err == nil {
+				timing[params[0]] = time.Duration(ms * float64(time.Millisecond))
+			}
+		}
+	}
+	return timing
+}
+
+func (r *Report) writeJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(r)
+}
+
+// writeCSV writes one row per measured metric: the end-to-end latency
+// followed by the gateway's sign/verify breakdown.
+func (r *Report) writeCSV(w io.Writer) error {
+	out := csv.NewWriter(w)
+	out.Write([]string{"metric", "count", "mean_ms", "min_ms", "p50_ms", "p95_ms", "p99_ms", "max_ms", "requests", "errors", "error_rate", "achieved_rps"})
+
+	row := func(name string, s Summary) []string {
+		return []string{
+			name, strconv.Itoa(s.Count),
+			formatMillis(s.Mean), formatMillis(s.Min), formatMillis(s.P50),
+			formatMillis(s.P95), formatMillis(s.P99), formatMillis(s.Max),
+			strconv.Itoa(r.Requests), strconv.Itoa(r.Errors),
+			strconv.FormatFloat(r.ErrorRate, 'f', 4, 64), strconv.FormatFloat(r.AchievedRPS, 'f', 2, 64),
+		}
+	}
+
+	out.Write(row("latency", r.Latency))
+	for _, name := range breakdownMetrics {
+		if s, ok := r.Breakdown[name]; ok {
+			out.Write(row(name, s))
+		}
+	}
+	out.Flush()
+	return out.Error()
+}
+
+func (r *Report) writeText(w io.Writer) {
+	fmt.Fprintln(w, "📊 Load Test Results")
+	fmt.Fprintln(w, strings.Repeat("=", 50))
+	fmt.Fprintf(w, "Target:        %s %s\n", r.Method, r.URL)
+	fmt.Fprintf(w, "Duration:      %.2fs with %d workers\n", r.Duration, r.Concurrency)
+	fmt.Fprintf(w, "Requests:      %d (%.1f rps achieved)\n", r.Requests, r.AchievedRPS)
+	fmt.Fprintf(w, "Errors:        %d (%.2f%%)\n", r.Errors, r.ErrorRate*100)
+
+	codes := make([]string, 0, len(r.StatusCodes))
+	for code := range r.StatusCodes {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+	for _, code := range codes {
+		fmt.Fprintf(w, "  HTTP %s:      %d\n", code, r.StatusCodes[code])
+	}
+	for message, count := range r.Failures {
+		fmt.Fprintf(w, "  ❌ %s: %d\n", message, count)
+	}
+
+	fmt.Fprintln(w, "")
+	fmt.Fprintf(w, "%-14s %8s %9s %9s %9s %9s %9s\n", "", "count", "mean", "p50", "p95", "p99", "max")
+	printSummary := func(name string, s Summary) {
+		fmt.Fprintf(w, "%-14s %8d %8.3fms %7.3fms %7.3fms %7.3fms %7.3fms\n", name, s.Count, s.Mean, s.P50, s.P95, s.P99, s.Max)
+	}
+	printSummary("latency", r.Latency)
+	for _, name := range breakdownMetrics {
+		if s, ok := r.Breakdown[name]; ok {
+			printSummary("  "+name, s)
+		}
+	}
+
+	if len(r.Breakdown) > 0 && r.Latency.Mean > 0 {
+		crypto := r.Breakdown["sign"].Mean + r.Breakdown["verify"].Mean + r.Breakdown["countersign"].Mean
+		fmt.Fprintf(w, "\n🔐 Gateway PQC overhead: %.3fms per request (%.1f%% of mean latency)\n", crypto, crypto/r.Latency.Mean*100)
+	}
+
+	fmt.Fprintln(w, "")
+	fmt.Fprintln(w, "Latency histogram:")
+	max := 0
+	for _, bucket := range r.Histogram {
+		if bucket.Count > max {
+			max = bucket.Count
+		}
+	}
+	for _, bucket := range r.Histogram {
+		if bucket.Count == 0 {
+			continue
+		}
+		bar := strings.Repeat("█", int(math.Ceil(float64(bucket.Count)/float64(max)*40)))
+		fmt.Fprintf(w, "  ≤ %6s ms %8d %s\n", bucket.LE, bucket.Count, bar)
+	}
+}
+
+func formatMillis(ms float64) string {
+	return strconv.FormatFloat(ms, 'f', 3, 64)
+}
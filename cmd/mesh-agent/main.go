@@ -0,0 +1,48 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+
+	"quantum-safe-mesh/pkg/agent"
+	"quantum-safe-mesh/pkg/config"
+)
+
+// mesh-agent holds the private keys of the services named in
+// AGENT_SERVICES and signs and decapsulates for them over a Unix socket, so
+// services running with KEYS_MODE=agent never load the keys themselves.
+func main() {
+	cfg, err := config.Load(config.ServiceAgent, flag.CommandLine, os.Args[1:])
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+	if err := cfg.Log.Apply(cfg.Service.ID); err != nil {
+		log.Fatalf("Failed to set up logging: %v", err)
+	}
+	if err := cfg.Telemetry.Apply(cfg.Service.ID); err != nil {
+		log.Fatalf("Failed to set up telemetry: %v", err)
+	}
+	if err := cfg.Debug.Apply(); err != nil {
+		log.Fatalf("Failed to start diagnostics: %v", err)
+	}
+	if err := cfg.Keys.Apply(); err != nil {
+		log.Fatalf("Failed to open key store: %v", err)
+	}
+	cfg.Crypto.Apply()
+	config.NewReloader(cfg, config.ServiceAgent, flag.CommandLine).Watch()
+
+	policy, err := agent.ParsePolicy(cfg.Agent.AllowedUIDs)
+	if err != nil {
+		log.Fatalf("Invalid allowed UIDs: %v", err)
+	}
+	identities, err := agent.LoadIdentities(cfg.AgentServices())
+	if err != nil {
+		log.Fatalf("Failed to load keys: %v", err)
+	}
+	for _, identity := range identities {
+		log.Printf("🔑 Holding %s key %s for %s", identity.Signer.Algorithm(), identity.KeyID(), identity.ServiceID)
+	}
+
+	log.Fatal(agent.NewServer(identities, policy).Serve(cfg.Keys.AgentSocket))
+}
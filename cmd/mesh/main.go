@@ -0,0 +1,195 @@
+package main
+
+import (
+	"crypto/tls"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"quantum-safe-mesh/pkg/auth"
+	"quantum-safe-mesh/pkg/backend"
+	"quantum-safe-mesh/pkg/config"
+	"quantum-safe-mesh/pkg/demo"
+	"quantum-safe-mesh/pkg/gateway"
+)
+
+// readyTimeout bounds how long -demo waits for the services to report ready.
+const readyTimeout = 30 * time.Second
+
+// mesh runs the auth service, backend and gateway in one process for local
+// development, each on its usual port and sharing one configuration. The
+// auth service is listening before the others start and register with it,
+// so nothing races it; with -demo the demo client runs once all three are
+// ready and its result is the exit status.
+func main() {
+	runDemo := flag.Bool("demo", false, "run the demo client once the mesh is ready, then exit with its result")
+	demoOutput := flag.String("demo-output", demo.OutputText, "demo output mode: text, quiet or json")
+	demoPause := flag.Duration("demo-pause", time.Second, "pause between demo steps")
+	configs, err := config.LoadAll([]string{config.ServiceAuth, config.ServiceBackend, config.ServiceGateway}, flag.CommandLine, os.Args[1:])
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+	authCfg, backendCfg, gatewayCfg := configs[0], configs[1], configs[2]
+	// Only the gateway serves TLS; the services behind it are reached
+	// over plain HTTP, in this process.
+	tlsConfig, err := gatewayCfg.TLS.ServerConfig()
+	if err != nil {
+		log.Fatalf("Failed to set up TLS: %v", err)
+	}
+	if tlsConfig != nil && *runDemo {
+		log.Fatalf("-demo calls the gateway over plain HTTP: unset tls.cert_file to run it")
+	}
+
+	var client *demo.Demo
+	if *runDemo {
+		client, err = demo.New(demo.Options{
+			GatewayURL: localURL(gatewayCfg.Service.ListenAddr),
+			AuthURL:    localURL(authCfg.Service.ListenAddr),
+			BackendURL: localURL(backendCfg.Service.ListenAddr),
+			BackendID:  backendCfg.Service.ID,
+			Verify:     true,
+			Output:     *demoOutput,
+			Pause:      *demoPause,
+			Timeout:    gatewayCfg.Timeouts.Client,
+		})
+		if err != nil {
+			log.Fatalf("Failed to create demo client: %v", err)
+		}
+	}
+
+	// Logging, telemetry, diagnostics, keys and crypto are process-wide and
+	// come from the shared settings, so any of the configurations will do.
+	if err := authCfg.Log.Apply("mesh"); err != nil {
+		log.Fatalf("Failed to set up logging: %v", err)
+	}
+	if err := authCfg.Telemetry.Apply("mesh"); err != nil {
+		log.Fatalf("Failed to set up telemetry: %v", err)
+	}
+	if err := authCfg.Debug.Apply(); err != nil {
+		log.Fatalf("Failed to start diagnostics: %v", err)
+	}
+	if err := authCfg.Keys.Apply(); err != nil {
+		log.Fatalf("Failed to open key store: %v", err)
+	}
+	authCfg.Crypto.Apply()
+
+	authService, err := auth.NewAuthService(authCfg)
+	if err != nil {
+		log.Fatalf("Failed to create auth service: %v", err)
+	}
+	watch(authCfg, config.ServiceAuth, authService.Reload)
+	handler, err := authService.Start(authCfg)
+	if err != nil {
+		log.Fatalf("Failed to start auth service: %v", err)
+	}
+	serve("Auth Service", authCfg, handler, nil)
+
+	backendService, err := backend.NewBackendService(backendCfg)
+	if err != nil {
+		log.Fatalf("Failed to create backend service: %v", err)
+	}
+	watch(backendCfg, config.ServiceBackend, backendService.Reload)
+	handler, err = backendService.Start(backendCfg)
+	if err != nil {
+		log.Fatalf("Failed to start backend service: %v", err)
+	}
+	serve("Backend Service", backendCfg, handler, nil)
+
+	apiGateway, err := gateway.NewAPIGateway(gatewayCfg)
+	if err != nil {
+		log.Fatalf("Failed to create API gateway: %v", err)
+	}
+	watch(gatewayCfg, config.ServiceGateway, apiGateway.Reload)
+	handler, err = apiGateway.Start(gatewayCfg)
+	if err != nil {
+		log.Fatalf("Failed to start API gateway: %v", err)
+	}
+	serve("API Gateway", gatewayCfg, handler, tlsConfig)
+	if admin := apiGateway.AdminHandler(); admin != nil {
+		adminCfg := *gatewayCfg
+		adminCfg.Service.ListenAddr = gatewayCfg.Service.AdminAddr
+		serve("API Gateway admin endpoints", &adminCfg, admin, nil)
+	}
+
+	if !*runDemo {
+		select {}
+	}
+
+	for _, cfg := range configs {
+		if err := waitReady(localURL(cfg.Service.ListenAddr)); err != nil {
+			log.Fatalf("Mesh not ready: %v", err)
+		}
+	}
+	if *demoOutput != demo.OutputText {
+		log.SetOutput(io.Discard)
+	}
+	if !client.Run() {
+		os.Exit(1)
+	}
+}
+
+// watch reloads cfg as the service's own binary would, applying changes
+// with reload.
+func watch(cfg *config.Config, service string, reload func(*config.Config)) {
+	reloader := config.NewReloader(cfg, service, flag.CommandLine)
+	reloader.OnReload(reload)
+	reloader.Watch()
+}
+
+// serve listens on cfg's address before returning, so services started
+// after it can reach it straight away, and serves handler there, over TLS
+// if tlsConfig is set.
+func serve(name string, cfg *config.Config, handler http.Handler, tlsConfig *tls.Config) {
+	listener, err := net.Listen("tcp", cfg.Service.ListenAddr)
+	if err != nil {
+		log.Fatalf("Failed to listen for %s: %v", name, err)
+	}
+	server := &http.Server{
+		Handler:           handler,
+		ReadHeaderTimeout: cfg.Timeouts.ReadHeader,
+	}
+
+	if tlsConfig != nil {
+		listener = tls.NewListener(listener, tlsConfig)
+		log.Printf("🔒 %s starting on %s with TLS (client certificates: %s)", name, cfg.Service.ListenAddr, cfg.TLS.ClientAuth)
+	} else {
+		log.Printf("🌟 %s starting on %s", name, cfg.Service.ListenAddr)
+	}
+	go func() {
+		log.Fatal(server.Serve(listener))
+	}()
+}
+
+// localURL is the base URL of a service listening on addr in this process.
+func localURL(addr string) string {
+	if strings.HasPrefix(addr, ":") {
+		addr = "localhost" + addr
+	}
+	return "http://" + addr
+}
+
+// waitReady polls the readiness probe at baseURL until it passes or
+// readyTimeout runs out.
+func waitReady(baseURL string) error {
+	deadline := time.Now().Add(readyTimeout)
+	for {
+		resp, err := http.Get(baseURL + "/readyz")
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode == http.StatusOK {
+				return nil
+			}
+			err = fmt.Errorf("status %d", resp.StatusCode)
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("%s: %w", baseURL, err)
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+}
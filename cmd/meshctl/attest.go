@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"quantum-safe-mesh/pkg/mesh"
+	"quantum-safe-mesh/pkg/models"
+	"quantum-safe-mesh/pkg/pqc"
+)
+
+// runAttest signs an attestation about a build artifact or SBOM, or with
+// the verify subcommand checks one.
+func runAttest(args []string) error {
+	if len(args) > 0 && args[0] == "verify" {
+		return runAttestVerify(args[1:])
+	}
+
+	flags, _ := newFlagSet("attest")
+	serviceID := flags.String("key", "", "service whose local keys sign the attestation")
+	predicateType := flags.String("type", "", "what the attestation states, e.g. https://spdx.dev/Document for an SPDX SBOM")
+	out := flags.String("out", "", "where to write the attestation, - for stdout (default: <artifact>.att)")
+	flags.Parse(args)
+
+	if err := requireFlag("key", *serviceID); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return fmt.Errorf("usage: meshctl attest --key service-id [--type predicate-type] [--out file.att] artifact (flags before the artifact)")
+	}
+	artifact := flags.Arg(0)
+
+	digest, err := mesh.DigestFile(artifact)
+	if err != nil {
+		return fmt.Errorf("failed to digest %s: %w", artifact, err)
+	}
+
+	identity, err := mesh.LoadIdentity(*serviceID)
+	if errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("no keys for %s in %s (run 'meshctl keygen' first?): %w", *serviceID, pqc.KeysDir, err)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to load keys for %s: %w", *serviceID, err)
+	}
+
+	attestation, err := identity.Attest(filepath.Base(artifact), digest, *predicateType)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(attestation, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode attestation: %w", err)
+	}
+
+	if *out == "-" {
+		fmt.Println(string(data))
+		return nil
+	}
+	if *out == "" {
+		*out = artifact + ".att"
+	}
+	if err := os.WriteFile(*out, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write attestation: %w", err)
+	}
+
+	fmt.Printf("📜 Attested %s as %s\n", artifact, *serviceID)
+	fmt.Printf("   SHA-256:     %s (%d bytes)\n", digest.SHA256, digest.Size)
+	fmt.Printf("   Fingerprint: %s\n", identity.KeyID())
+	fmt.Printf("   Attestation: %s\n", *out)
+	fmt.Printf("   Verify with: meshctl attest verify %s\n", artifact)
+	return nil
+}
+
+func runAttestVerify(args []string) error {
+	flags, authURL := newFlagSet("attest verify")
+	signer := flags.String("service-id", "", "expected signer (default: whoever the attestation names)")
+	keySource := flags.String("key-source", keySourceAuth, "where to find the signer's keys: auth (auth service) or local")
+	flags.Parse(args)
+
+	if flags.NArg() < 1 || flags.NArg() > 2 {
+		return fmt.Errorf("usage: meshctl attest verify [--service-id service-id] [--key-source auth|local] artifact [attestation] (flags before the artifact)")
+	}
+	if *keySource != keySourceAuth && *keySource != keySourceLocal {
+		return fmt.Errorf("invalid --key-source %q: expected %s or %s", *keySource, keySourceAuth, keySourceLocal)
+	}
+	artifact := flags.Arg(0)
+	attestationFile := artifact + ".att"
+	if flags.NArg() == 2 {
+		attestationFile = flags.Arg(1)
+	}
+
+	data, err := os.ReadFile(attestationFile)
+	if err != nil {
+		return fmt.Errorf("failed to read attestation: %w", err)
+	}
+	var attestation models.Attestation
+	if err := json.Unmarshal(data, &attestation); err != nil {
+		return fmt.Errorf("failed to decode attestation %s: %w", attestationFile, err)
+	}
+	if *signer != "" && attestation.Signer != *signer {
+		return fmt.Errorf("attestation is signed by %s, not %s", attestation.Signer, *signer)
+	}
+
+	digest, err := mesh.DigestFile(artifact)
+	if err != nil {
+		return fmt.Errorf("failed to digest %s: %w", artifact, err)
+	}
+
+	registration, origin, err := attestationRegistration(*keySource, *authURL, attestation.Signer)
+	if err != nil {
+		return err
+	}
+	publicKey, superseded, err := mesh.AttestationKey(registration, attestation.KeyID)
+	if err != nil {
+		return err
+	}
+	if err := mesh.VerifyAttestation(&attestation, publicKey, digest); err != nil {
+		return err
+	}
+
+	fmt.Printf("✅ Attestation verified: %s\n", artifact)
+	fmt.Printf("   SHA-256:     %s (%d bytes)\n", digest.SHA256, digest.Size)
+	if attestation.PredicateType != "" {
+		fmt.Printf("   Type:        %s\n", attestation.PredicateType)
+	}
+	fmt.Printf("   Signer:      %s\n", attestation.Signer)
+	fmt.Printf("   Fingerprint: %s\n", attestation.KeyID)
+	fmt.Printf("   Issued:      %s\n", attestation.IssuedAt.Format(time.RFC3339))
+	fmt.Printf("   Key from:    %s\n", origin)
+	if !superseded.IsZero() {
+		fmt.Printf("⚠️  Signed with a superseded key: %s rotated to a new key at %s\n", attestation.Signer, superseded.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// attestationRegistration returns serviceID's current key and rotation
+// records, from the auth service or the local key store, and says where
+// they came from.
+func attestationRegistration(source, authURL, serviceID string) (*models.PublicKeyResponse, string, error) {
+	if source == keySourceAuth {
+		registration, err := newLookupRegistry(authURL).Registration(serviceID)
+		if err != nil {
+			return nil, "", err
+		}
+		return registration, "auth service", nil
+	}
+
+	publicKey, err := pqc.LoadPublicKey(serviceID)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to load %s's public key locally: %w", serviceID, err)
+	}
+	rotations, err := pqc.LoadRotationRecords(serviceID)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to load %s's rotation records: %w", serviceID, err)
+	}
+	registration := &models.PublicKeyResponse{ServiceID: serviceID, PublicKey: publicKey, Rotations: rotations}
+	return registration, "local key store (" + pqc.KeysDir + ")", nil
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"quantum-safe-mesh/pkg/pqc"
+)
+
+func runRecoveryKeygen(args []string) error {
+	flags, _ := newFlagSet("recovery-keygen")
+	out := flags.String("out", "recovery", "path prefix for the key pair, written to <out>.pub and <out>.key")
+	encrypt := flags.Bool("encrypt", false, "encrypt the private key with the passphrase from -passphrase-file or KEYS_PASSPHRASE")
+	force := flags.Bool("force", false, "overwrite an existing recovery key")
+	flags.Parse(args)
+
+	if *encrypt && len(pqc.KeysPassphrase) == 0 {
+		return fmt.Errorf("--encrypt needs a passphrase: set KEYS_PASSPHRASE or pass -passphrase-file")
+	}
+	if _, err := os.Stat(*out + ".key"); err == nil && !*force {
+		return fmt.Errorf("%s.key already exists; use --force to overwrite it, which makes everything escrowed to it unrecoverable", *out)
+	}
+
+	recoveryKey, err := pqc.GenerateKyberKeyPair()
+	if err != nil {
+		return fmt.Errorf("failed to generate Kyber keypair: %w", err)
+	}
+	var passphrase []byte
+	if *encrypt {
+		passphrase = pqc.KeysPassphrase
+	}
+	if err := pqc.SaveRecoveryKey(*out, recoveryKey, passphrase); err != nil {
+		return err
+	}
+
+	fmt.Printf("🔑 Generated recovery key %s\n", pqc.KeyFingerprint(recoveryKey.GetPublicKeyBytes()))
+	fmt.Printf("   Public key:  %s.pub (give to services as KEYS_ESCROW_KEY)\n", *out)
+	fmt.Printf("   Private key: %s.key\n", *out)
+	fmt.Println("⚠️  Move the private key offline; anyone holding it can recover every escrowed key.")
+	return nil
+}
+
+func runRecover(args []string) error {
+	flags, _ := newFlagSet("recover")
+	serviceID := flags.String("service-id", "", "service whose keys to restore")
+	recoveryKey := flags.String("recovery-key", "", "recovery private key file")
+	recoveryPassphraseFile := flags.String("recovery-passphrase-file", "", "file holding the recovery key's passphrase, or - for stdin (default: env RECOVERY_PASSPHRASE)")
+	escrowFile := flags.String("escrow", "", "escrowed keys (default: <service-id>_escrow.pem in the key store)")
+	encrypt := flags.Bool("encrypt", false, "encrypt the restored private keys with the passphrase from -passphrase-file or KEYS_PASSPHRASE")
+	force := flags.Bool("force", false, "overwrite keys that still exist")
+	flags.Parse(args)
+
+	if err := requireFlag("service-id", *serviceID); err != nil {
+		return err
+	}
+	if err := requireFlag("recovery-key", *recoveryKey); err != nil {
+		return err
+	}
+	if *encrypt && len(pqc.KeysPassphrase) == 0 {
+		return fmt.Errorf("--encrypt needs a passphrase: set KEYS_PASSPHRASE or pass -passphrase-file")
+	}
+	if !*encrypt {
+		pqc.KeysPassphrase = nil
+	}
+	if *escrowFile == "" {
+		*escrowFile = filepath.Join(pqc.KeysDir, pqc.EscrowFileName(*serviceID))
+	}
+
+	passphrase := []byte(os.Getenv("RECOVERY_PASSPHRASE"))
+	if *recoveryPassphraseFile != "" {
+		var err error
+		if passphrase, err = readPassphrase(*recoveryPassphraseFile); err != nil {
+			return err
+		}
+	}
+	recovery, err := pqc.LoadRecoveryKey(*recoveryKey, passphrase)
+	if err != nil {
+		return err
+	}
+
+	data, err := os.ReadFile(*escrowFile)
+	if err != nil {
+		return fmt.Errorf("failed to read escrowed keys: %w", err)
+	}
+	escrowed, err := pqc.OpenEscrow(data, recovery)
+	if err != nil {
+		return err
+	}
+	if escrowed.ServiceID != *serviceID {
+		return fmt.Errorf("%s holds the keys of %s, not %s", *escrowFile, escrowed.ServiceID, *serviceID)
+	}
+
+	for _, algorithm := range pqc.SignatureAlgorithms {
+		_, signingPriv := pqc.SignatureKeyFileNames(*serviceID, algorithm)
+		if _, err := os.Stat(filepath.Join(pqc.KeysDir, signingPriv)); err == nil && !*force {
+			return fmt.Errorf("keys for %s still exist; use --force to overwrite them", *serviceID)
+		}
+	}
+	if err := pqc.RestoreKeyPair(*serviceID, escrowed.Signer, escrowed.Kyber, escrowed.Metadata); err != nil {
+		return err
+	}
+
+	fmt.Printf("🗄️  Restored the keys of %s from %s\n", *serviceID, *escrowFile)
+	fmt.Printf("   %-12s key: %s\n", escrowed.Signer.Algorithm(), pqc.KeyFingerprint(escrowed.Signer.GetPublicKeyBytes()))
+	fmt.Printf("   Created:          %s\n", escrowed.Metadata.CreatedAt.Format(time.RFC3339))
+	return nil
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"quantum-safe-mesh/pkg/mesh"
+	"quantum-safe-mesh/pkg/models"
+	"quantum-safe-mesh/pkg/pqc"
+)
+
+// errNotVerified marks a file whose signature was checked and found bad, as
+// opposed to one whose signer's key could not be found.
+var errNotVerified = errors.New("signature does not verify")
+
+func runInspect(args []string) error {
+	flags, authURL := newFlagSet("inspect")
+	keySource := flags.String("key-source", keySourceAuto, "where to find public keys: auto (local key store, then auth service), local or auth")
+	flags.Parse(args)
+
+	if flags.NArg() == 0 {
+		return fmt.Errorf("usage: meshctl inspect [--key-source auto|local|auth] file... (flags before the files)")
+	}
+
+	keys, err := newKeyResolver(*keySource, *authURL)
+	if err != nil {
+		return err
+	}
+
+	var failed []string
+	for i, file := range flags.Args() {
+		if i > 0 {
+			fmt.Println()
+		}
+		if err := inspectFile(keys, file); err != nil {
+			fmt.Printf("❌ %s: %v\n", file, err)
+			failed = append(failed, file)
+		}
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("%d of %d files failed inspection: %s", len(failed), flags.NArg(), strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// inspectFile recognises file as a key, a signed envelope or a JWS and
+// describes it.
+func inspectFile(keys *keyResolver, file string) error {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return fmt.Errorf("failed to read: %w", err)
+	}
+
+	trimmed := bytes.TrimSpace(data)
+	switch {
+	case bytes.HasPrefix(trimmed, []byte("{")):
+		return inspectEnvelope(keys, file, trimmed)
+	case bytes.Count(trimmed, []byte(".")) == 2 && !bytes.ContainsAny(trimmed, " \n"):
+		return inspectJWS(keys, file, string(trimmed))
+	}
+
+	keyFile, err := pqc.ParseKeyFile(data)
+	if err != nil {
+		return fmt.Errorf("not a key, signed envelope or JWS (%w)", err)
+	}
+	return inspectKey(file, keyFile)
+}
+
+func inspectKey(file string, keyFile *pqc.KeyFile) error {
+	kind := "public"
+	if keyFile.Private {
+		kind = "private"
+	}
+	fmt.Printf("🔑 %s: %s %s key\n", file, keyFile.Algorithm, kind)
+	fmt.Printf("   Format:      %s\n", keyFile.Format)
+
+	if keyFile.Encrypted {
+		fmt.Printf("   Encryption:  %s, key derived by %s\n", keyFile.Headers["Cipher"], keyFile.Headers["KDF"])
+		if len(pqc.KeysPassphrase) == 0 {
+			fmt.Printf("   ⚠️  Encrypted: give -passphrase-file, set KEYS_PASSPHRASE or pass -keys-store keychain to check it and show its fingerprint\n")
+			return nil
+		}
+		if err := keyFile.Decrypt(pqc.KeysPassphrase); err != nil {
+			return err
+		}
+		fmt.Printf("   Passphrase:  ✅ decrypts\n")
+	}
+
+	fmt.Printf("   Size:        %d bytes\n", len(keyFile.Key))
+
+	publicKey, err := keyFile.PublicKey()
+	if err != nil {
+		return err
+	}
+	fingerprint := pqc.KeyFingerprint(publicKey)
+	if !keyFile.Private {
+		fmt.Printf("   Fingerprint: %s\n", fingerprint)
+		return nil
+	}
+	fmt.Printf("   Fingerprint: %s (of its public key)\n", fingerprint)
+
+	// A private key's public half normally sits beside it; a mismatch means
+	// one of the pair was replaced.
+	pubFile := strings.TrimSuffix(file, filepath.Ext(file)) + ".pub"
+	pubData, err := os.ReadFile(pubFile)
+	if err != nil {
+		return nil
+	}
+	pubKeyFile, err := pqc.ParseKeyFile(pubData)
+	if err != nil || pubKeyFile.Private {
+		return nil
+	}
+	if !bytes.Equal(pubKeyFile.Key, publicKey) {
+		return fmt.Errorf("does not match its public key %s (%s): one of the pair was replaced", pubFile, pqc.KeyFingerprint(pubKeyFile.Key))
+	}
+	fmt.Printf("   Public key:  ✅ matches %s\n", pubFile)
+	return nil
+}
+
+// inspectEnvelope describes a signed ServiceRequest or ServiceResponse. A
+// response is told apart by its success field.
+func inspectEnvelope(keys *keyResolver, file string, data []byte) error {
+	var probe map[string]json.RawMessage
+	if err := json.Unmarshal(data, &probe); err != nil {
+		return fmt.Errorf("invalid JSON: %w", err)
+	}
+	if _, signed := probe["signature"]; !signed {
+		return fmt.Errorf("JSON without a signature: not a signed envelope")
+	}
+
+	var response models.ServiceResponse
+	if err := json.Unmarshal(data, &response); err != nil {
+		return fmt.Errorf("failed to parse envelope: %w", err)
+	}
+
+	kind := "request"
+	var payload, chainPayload []byte
+	var err error
+	if _, isResponse := probe["success"]; isResponse {
+		kind = "response"
+		if payload, err = response.SigningPayload(); err == nil {
+			chainPayload, err = response.ChainPayload()
+		}
+	} else {
+		request := models.ServiceRequest{
+			ProtocolVersion: response.ProtocolVersion,
+			ServiceID:       response.ServiceID,
+			RequestID:       response.RequestID,
+			ParentID:        response.ParentID,
+			Timestamp:       response.Timestamp,
+			Data:            response.Data,
+			SignatureAlg:    response.SignatureAlg,
+			Signature:       response.Signature,
+			Chain:           response.Chain,
+			Headers:         response.Headers,
+		}
+		if payload, err = request.SigningPayload(); err == nil {
+			chainPayload, err = request.ChainPayload()
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("failed to reconstruct signing payload: %w", err)
+	}
+
+	fmt.Printf("📨 %s: signed %s envelope\n", file, kind)
+	fmt.Printf("   Protocol:    %s\n", models.NormalizeProtocolVersion(response.ProtocolVersion))
+	fmt.Printf("   Signer:      %s\n", response.ServiceID)
+	if response.RequestID != "" {
+		fmt.Printf("   Request ID:  %s\n", response.RequestID)
+	}
+	if response.ParentID != "" {
+		fmt.Printf("   Parent ID:   %s\n", response.ParentID)
+	}
+	fmt.Printf("   Timestamp:   %s (%s)\n", response.Timestamp.Format(time.RFC3339), age(response.Timestamp))
+	if kind == "response" {
+		fmt.Printf("   Success:     %t\n", response.Success)
+		if response.Error != "" {
+			fmt.Printf("   Error:       %s\n", response.Error)
+		}
+	}
+	if models.IsEncryptedPayload(response.Data) {
+		fmt.Printf("   Data:        %d bytes, encrypted\n", len(response.Data))
+	} else {
+		fmt.Printf("   Data:        %d bytes\n", len(response.Data))
+	}
+	alg := response.SignatureAlg
+	if alg == "" {
+		alg = pqc.JWSAlgDilithium3
+	}
+	fmt.Printf("   Signature:   %s, %d bytes\n", alg, len(response.Signature))
+
+	result := verifyInspected(keys, response.ServiceID, alg, payload, response.Signature, "   ")
+
+	if len(response.Chain) > 0 {
+		fmt.Printf("   Chain:       %d links\n", len(response.Chain))
+	}
+	for i, link := range response.Chain {
+		fmt.Printf("   %d. %s, key %s, %s, %d bytes, signed %s\n",
+			i+1, link.SignerID, link.KeyID, link.Alg, len(link.Signature), link.SignedAt.Format(time.RFC3339))
+
+		input, err := response.Chain[:i].SigningInput(chainPayload, link)
+		if err != nil {
+			return err
+		}
+		if linkResult := verifyInspected(keys, link.SignerID, link.Alg, input, link.Signature, "      "); result == nil {
+			result = linkResult
+		}
+	}
+	return result
+}
+
+// verifyInspected checks signature over payload against signer's key with
+// the algorithm alg names, and reports the outcome. A key that cannot be
+// found is a warning, not a failure: the file may come from a mesh this
+// machine cannot reach.
+func verifyInspected(keys *keyResolver, signer, alg string, payload, signature []byte, indent string) error {
+	publicKey, err := keys.PublicKey(signer)
+	if err != nil {
+		fmt.Printf("%s⚠️  Not verified: %v\n", indent, err)
+		return nil
+	}
+
+	fingerprint := pqc.KeyFingerprint(publicKey)
+	if err := pqc.VerifySignature(alg, publicKey, payload, signature); err != nil {
+		fmt.Printf("%s❌ Does not verify against %s's key %s from the %s\n", indent, signer, fingerprint, keys.origin(signer))
+		return errNotVerified
+	}
+	fmt.Printf("%s✅ Verifies against %s's key %s from the %s\n", indent, signer, fingerprint, keys.origin(signer))
+	return nil
+}
+
+// inspectJWS describes a detached JWS, such as one written by 'meshctl
+// sign', or a bootstrap token, whose claims are attached.
+func inspectJWS(keys *keyResolver, file, token string) error {
+	parts := strings.Split(token, ".")
+	if parts[1] == "" {
+		header, signature, err := pqc.ParseDetachedJWS(token)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("🖊️  %s: detached JWS\n", file)
+		printJWSHeader(header, signature)
+		fmt.Printf("   Verify with: meshctl verify --service-id <signer> --sig %s <signed file>\n", file)
+		return nil
+	}
+
+	header, signature, err := pqc.ParseDetachedJWS(parts[0] + ".." + parts[2])
+	if err != nil {
+		return err
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return fmt.Errorf("failed to decode JWS payload: %w", err)
+	}
+	var claims mesh.BootstrapClaims
+	if err := json.Unmarshal(payload, &claims); err != nil || claims.Subject == "" {
+		return fmt.Errorf("JWS with an attached payload that is not a bootstrap token")
+	}
+
+	fmt.Printf("🎟️  %s: bootstrap token\n", file)
+	printJWSHeader(header, signature)
+	fmt.Printf("   Issuer:      %s\n", claims.Issuer)
+	fmt.Printf("   Subject:     %s\n", claims.Subject)
+	fmt.Printf("   Expires:     %s (%s)\n", claims.Expiry().Format(time.RFC3339), age(claims.Expiry()))
+
+	return verifyInspected(keys, claims.Issuer, header.Alg, []byte(parts[0]+"."+parts[1]), signature, "   ")
+}
+
+func printJWSHeader(header *pqc.JWSHeader, signature []byte) {
+	fmt.Printf("   Algorithm:   %s\n", header.Alg)
+	fmt.Printf("   Key ID:      %s\n", header.Kid)
+	if header.Iat != 0 {
+		fmt.Printf("   Issued at:   %s (%s)\n", header.IssuedAt().Format(time.RFC3339), age(header.IssuedAt()))
+	}
+	fmt.Printf("   Signature:   %d bytes\n", len(signature))
+}
+
+// age describes t relative to now.
+func age(t time.Time) string {
+	d := time.Since(t).Round(time.Second)
+	if d < 0 {
+		return "in " + (-d).String()
+	}
+	return d.String() + " ago"
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"quantum-safe-mesh/pkg/oqsinterop"
+)
+
+func runInterop(args []string) error {
+	flags, _ := newFlagSet("interop")
+	openssl := flags.String("openssl", getEnvOrDefault("OQS_OPENSSL", "openssl"), "openssl binary to check against")
+	provider := flags.String("provider", oqsinterop.DefaultProvider, "name of the oqs-provider to load")
+	modulePath := flags.String("provider-path", os.Getenv("OPENSSL_MODULES"), "directory holding the oqs-provider (default: openssl's)")
+	algorithms := flags.String("alg", strings.Join(oqsinterop.Algorithms(), ","), "comma-separated algorithms to check")
+	timeout := flags.Duration("timeout", 2*time.Minute, "time allowed for all checks")
+	flags.Parse(args)
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	results, err := oqsinterop.Run(ctx, oqsinterop.OpenSSL{Path: *openssl, Provider: *provider, ModulePath: *modulePath}, strings.Split(*algorithms, ","))
+	if err != nil {
+		return err
+	}
+
+	failed := 0
+	for _, result := range results {
+		switch {
+		case result.Skipped != nil:
+			fmt.Printf("⏭️  %s: %s (skipped: %v)\n", result.Algorithm, result.Check, result.Skipped)
+		case result.Err != nil:
+			fmt.Printf("❌ %s: %s: %v\n", result.Algorithm, result.Check, result.Err)
+			failed++
+		default:
+			fmt.Printf("✅ %s: %s\n", result.Algorithm, result.Check)
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d interop checks with %s failed", failed, len(results), *openssl)
+	}
+	return nil
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"quantum-safe-mesh/pkg/mesh"
+	"quantum-safe-mesh/pkg/pqc"
+)
+
+func runKeygen(args []string) error {
+	flags, _ := newFlagSet("keygen")
+	serviceID := flags.String("service-id", "", "service to generate keys for")
+	alg := flags.String("alg", getEnvOrDefault("SIGNATURE_ALGORITHM", pqc.AlgorithmDilithium3),
+		"signature algorithm: "+strings.Join(pqc.SignatureAlgorithms, ", "))
+	kem := flags.String("kem", getEnvOrDefault("KEM_ALGORITHM", pqc.AlgorithmKyber768),
+		"key encapsulation mechanism: "+strings.Join(pqc.KEMAlgorithms, ", "))
+	encrypt := flags.Bool("encrypt", false, "encrypt the private keys with the passphrase from -passphrase-file, KEYS_PASSPHRASE or -keys-store keychain")
+	force := flags.Bool("force", false, "overwrite existing keys")
+	flags.Parse(args)
+
+	if err := requireFlag("service-id", *serviceID); err != nil {
+		return err
+	}
+	if err := resolveSignatureAlgorithm(alg); err != nil {
+		return err
+	}
+	if !slices.Contains(pqc.KEMAlgorithms, *kem) {
+		return fmt.Errorf("unsupported --kem %q: expected one of %s", *kem, strings.Join(pqc.KEMAlgorithms, ", "))
+	}
+	if *encrypt && len(pqc.KeysPassphrase) == 0 {
+		return fmt.Errorf("--encrypt needs a passphrase: set KEYS_PASSPHRASE or pass -passphrase-file")
+	}
+	if !*encrypt {
+		pqc.KeysPassphrase = nil
+	}
+
+	// Check for the files rather than loading them: encrypted keys do not
+	// load without their passphrase, but must not be overwritten either.
+	for _, algorithm := range pqc.SignatureAlgorithms {
+		signingPub, _ := pqc.SignatureKeyFileNames(*serviceID, algorithm)
+		if _, err := os.Stat(filepath.Join(pqc.KeysDir, signingPub)); err == nil && !*force {
+			return fmt.Errorf("keys for %s already exist; use --force to overwrite or 'meshctl rotate' to replace a registered key", *serviceID)
+		}
+	}
+
+	signer, err := pqc.GenerateSigner(*alg)
+	if err != nil {
+		return fmt.Errorf("failed to generate %s keypair: %w", *alg, err)
+	}
+
+	kyberKeyPair, err := pqc.GenerateKEMKeyPair(*kem)
+	if err != nil {
+		return fmt.Errorf("failed to generate %s keypair: %w", *kem, err)
+	}
+
+	if err := pqc.SaveKeyPair(*serviceID, signer, kyberKeyPair); err != nil {
+		return err
+	}
+
+	fmt.Printf("🔑 Generated keys for %s in %s/ (%s format)\n", *serviceID, pqc.KeysDir, pqc.KeysFormat)
+	fmt.Printf("   %-12s public key: %d bytes\n", *alg, len(signer.GetPublicKeyBytes()))
+	fmt.Printf("   %-12s public key: %d bytes\n", *kem, len(kyberKeyPair.GetPublicKeyBytes()))
+	fmt.Printf("   Fingerprint:            %s\n", pqc.KeyFingerprint(signer.GetPublicKeyBytes()))
+	if pqc.KeyMaxAge > 0 {
+		fmt.Printf("   Expires:                %s\n", time.Now().Add(pqc.KeyMaxAge).UTC().Format(time.RFC3339))
+	}
+	if *encrypt {
+		fmt.Println("🔒 Private keys are encrypted; services need KEYS_PASSPHRASE, or KEYS_STORE=keychain if it is kept in the OS keychain, to load them.")
+	}
+	return nil
+}
+
+func runConvert(args []string) error {
+	flags, _ := newFlagSet("convert")
+	serviceID := flags.String("service-id", "", "service whose key files to rewrite")
+	encrypt := flags.Bool("encrypt", false, "encrypt the private keys with the passphrase from -passphrase-file, KEYS_PASSPHRASE or -keys-store keychain")
+	decrypt := flags.Bool("decrypt", false, "write encrypted private keys unencrypted, e.g. for openssl")
+	flags.Parse(args)
+
+	if err := requireFlag("service-id", *serviceID); err != nil {
+		return err
+	}
+	if *encrypt && *decrypt {
+		return fmt.Errorf("--encrypt and --decrypt are mutually exclusive")
+	}
+	if *encrypt && len(pqc.KeysPassphrase) == 0 {
+		return fmt.Errorf("--encrypt needs a passphrase: set KEYS_PASSPHRASE or pass -passphrase-file")
+	}
+
+	// Private keys stay encrypted unless --decrypt says otherwise.
+	algorithm, err := pqc.StoredSignatureAlgorithm(*serviceID)
+	if err != nil {
+		return err
+	}
+	_, signerPriv := pqc.SignatureKeyFileNames(*serviceID, algorithm)
+	data, err := os.ReadFile(filepath.Join(pqc.KeysDir, signerPriv))
+	if err != nil {
+		return fmt.Errorf("failed to read keys for %s: %w", *serviceID, err)
+	}
+	keyFile, err := pqc.ParseKeyFile(data)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", signerPriv, err)
+	}
+
+	var passphrase []byte
+	if *encrypt || (keyFile.Encrypted && !*decrypt) {
+		passphrase = pqc.KeysPassphrase
+	}
+	if err := pqc.RewriteKeyFiles(*serviceID, passphrase); err != nil {
+		return err
+	}
+
+	fmt.Printf("🔁 Rewrote keys for %s in %s/ (%s format)\n", *serviceID, pqc.KeysDir, pqc.KeysFormat)
+	if len(passphrase) > 0 {
+		fmt.Println("🔒 Private keys are encrypted; OpenSSL cannot read them until converted with --decrypt.")
+	} else if pqc.KeysFormat == pqc.KeyFormatOQS && !pqc.HasOQSFormat(algorithm) {
+		fmt.Printf("   %s keys have no oqs-provider format and were written as PEM.\n", algorithm)
+	}
+	return nil
+}
+
+func runToken(args []string) error {
+	flags, _ := newFlagSet("token")
+	issuer := flags.String("issuer", "", "identity to sign the token with; the auth service must trust it")
+	serviceID := flags.String("service-id", "", "service the token lets register")
+	ttl := flags.Duration("ttl", time.Hour, "token lifetime")
+	flags.Parse(args)
+
+	if err := requireFlag("issuer", *issuer); err != nil {
+		return err
+	}
+	if err := requireFlag("service-id", *serviceID); err != nil {
+		return err
+	}
+
+	identity, err := mesh.LoadIdentity(*issuer)
+	if err != nil {
+		return fmt.Errorf("failed to load keys for %s: %w", *issuer, err)
+	}
+
+	token, err := identity.IssueBootstrapToken(*serviceID, *ttl)
+	if err != nil {
+		return err
+	}
+
+	// The token alone goes to stdout so it can be captured into a Secret.
+	fmt.Println(token)
+	return nil
+}
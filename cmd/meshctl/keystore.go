@@ -0,0 +1,302 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"quantum-safe-mesh/pkg/mesh"
+	"quantum-safe-mesh/pkg/pqc"
+)
+
+// keyDirKeys are a service's keys as found in a key directory: a signing
+// and a Kyber key pair, one file per key, and their metadata.
+type keyDirKeys struct {
+	serviceID    string
+	files        []string // the key files, signing then Kyber, public then private
+	formats      []string // how the key files are stored, e.g. "raw" or "pem, encrypted"
+	metadataFile string   // "" if the keys have no metadata file
+	signer       pqc.Signer
+	kyber        *pqc.KyberKeyPair
+	metadata     *pqc.KeyMetadata
+	stored       bool // whether the keystore holds these keys already
+}
+
+// migrateToKeystore moves the keys of every service in the key directory
+// from, or only serviceID's, into the encrypted keystore to. Every key set
+// is checked before anything is written, and read back from the keystore
+// before any key file is shredded, so a failure at any point leaves the
+// keys usable where they were.
+func migrateToKeystore(from, to, serviceID, authURL string, register, keep, dryRun bool) error {
+	if len(pqc.KeysPassphrase) == 0 {
+		return fmt.Errorf("the keystore is encrypted: set -passphrase-file, KEYS_PASSPHRASE or -keys-store keychain")
+	}
+
+	services := []string{serviceID}
+	if serviceID == "" {
+		var err error
+		if services, err = keyDirServices(from); err != nil {
+			return err
+		}
+		if len(services) == 0 {
+			return fmt.Errorf("no keys in %s/", from)
+		}
+	}
+
+	// The keys are read from from and written to to, whatever -keys-dir
+	// and -keystore say.
+	pqc.KeysDir, pqc.KeystoreFile = from, ""
+	found := make([]*keyDirKeys, 0, len(services))
+	for _, id := range services {
+		keys, err := readKeyDir(from, id)
+		if err != nil {
+			return err
+		}
+		found = append(found, keys)
+	}
+
+	pqc.KeystoreFile = to
+	for _, keys := range found {
+		stored, _, err := pqc.LoadKeyPair(keys.serviceID)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if fingerprint := pqc.KeyFingerprint(stored.GetPublicKeyBytes()); fingerprint != keys.fingerprint() {
+			return fmt.Errorf("keystore %s already holds another key for %s: %s, not %s", to, keys.serviceID, fingerprint, keys.fingerprint())
+		}
+		keys.stored = true
+	}
+
+	if dryRun {
+		fmt.Printf("🧪 Dry run: move the keys in %s/ into keystore %s\n", from, to)
+		for _, keys := range found {
+			printKeyDirKeys(keys)
+		}
+		if register {
+			for _, keys := range found {
+				current, err := registeredFingerprint(authURL, keys.serviceID)
+				if err != nil {
+					return err
+				}
+				fmt.Printf("   Registry:    %s has %s for %s%s\n", authURL, describeFingerprint(current), keys.serviceID, unchanged(current, keys.fingerprint()))
+			}
+		}
+		if keep {
+			fmt.Printf("   Key files:   kept\n")
+		} else {
+			fmt.Printf("   Key files:   shredded once the keystore holds the keys\n")
+		}
+		return nil
+	}
+
+	for _, keys := range found {
+		if keys.stored {
+			continue
+		}
+		if err := pqc.RestoreKeyPair(keys.serviceID, keys.signer, keys.kyber, keys.metadata); err != nil {
+			return fmt.Errorf("failed to move the keys of %s, their files are untouched: %w", keys.serviceID, err)
+		}
+	}
+	// Read every key back before any file goes.
+	for _, keys := range found {
+		signer, kyber, err := pqc.LoadKeyPair(keys.serviceID)
+		if err != nil {
+			return fmt.Errorf("failed to read the keys of %s back from %s, their files are untouched: %w", keys.serviceID, to, err)
+		}
+		if !bytes.Equal(signer.GetPrivateKeyBytes(), keys.signer.GetPrivateKeyBytes()) || !bytes.Equal(kyber.GetPrivateKeyBytes(), keys.kyber.GetPrivateKeyBytes()) {
+			return fmt.Errorf("keystore %s holds other keys for %s than its files, which are untouched", to, keys.serviceID)
+		}
+	}
+
+	fmt.Printf("📦 Moved the keys in %s/ into keystore %s\n", from, to)
+	for _, keys := range found {
+		printKeyDirKeys(keys)
+	}
+
+	if register {
+		for _, keys := range found {
+			if err := registerMovedKeys(authURL, keys); err != nil {
+				return fmt.Errorf("%w; the key files in %s/ are untouched", err, from)
+			}
+		}
+	}
+
+	if keep {
+		fmt.Printf("   Key files kept in %s/: shred them once services use the keystore\n", from)
+	} else {
+		for _, keys := range found {
+			if err := shredKeyDirKeys(keys); err != nil {
+				return err
+			}
+		}
+		fmt.Printf("🧹 Shredded the key files in %s/\n", from)
+	}
+	fmt.Printf("   Point services at the keystore with KEYS_KEYSTORE=%s, and meshctl with -keystore %s\n", to, to)
+	return nil
+}
+
+// keyDirServices lists the services with keys in dir, going by their Kyber
+// public keys, which every service has whatever it signs with. Backups
+// 'meshctl migrate-keys' made are skipped.
+func keyDirServices(dir string) ([]string, error) {
+	_, _, kyberPub, _ := pqc.KeyFileNames("")
+	var services []string
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			if _, err := os.Stat(filepath.Join(path, migrationFile)); err == nil {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		if serviceID, ok := strings.CutSuffix(filepath.ToSlash(rel), kyberPub); ok && serviceID != "" {
+			services = append(services, serviceID)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key directory: %w", err)
+	}
+	return services, nil
+}
+
+// readKeyDir reads serviceID's keys from dir, which must be KeysDir, and
+// checks they are whole and work.
+func readKeyDir(dir, serviceID string) (*keyDirKeys, error) {
+	algorithm, err := pqc.StoredSignatureAlgorithm(serviceID)
+	if err != nil {
+		return nil, err
+	}
+	signerPub, signerPriv := pqc.SignatureKeyFileNames(serviceID, algorithm)
+	_, _, kyberPub, kyberPriv := pqc.KeyFileNames(serviceID)
+
+	keys := &keyDirKeys{serviceID: serviceID}
+	for _, name := range []string{signerPub, signerPriv, kyberPub, kyberPriv} {
+		path := filepath.Join(dir, name)
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("incomplete keys for %s: %w", serviceID, err)
+		}
+		keyFile, err := pqc.ParseKeyFile(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		format := keyFile.Format
+		if keyFile.Encrypted {
+			format += ", encrypted"
+		}
+		keys.files = append(keys.files, path)
+		if !slices.Contains(keys.formats, format) {
+			keys.formats = append(keys.formats, format)
+		}
+	}
+
+	if keys.signer, keys.kyber, err = pqc.LoadKeyPair(serviceID); err != nil {
+		return nil, fmt.Errorf("failed to load keys for %s: %w", serviceID, err)
+	}
+	if err := pqc.CheckKeyPair(keys.signer, keys.kyber); err != nil {
+		return nil, fmt.Errorf("keys of %s failed the integrity check: %w", serviceID, err)
+	}
+	if keys.metadata, err = pqc.LoadKeyMetadata(serviceID, keys.signer.GetPublicKeyBytes()); err != nil {
+		return nil, err
+	}
+	path := filepath.Join(dir, pqc.KeyMetadataFileName(serviceID))
+	if _, err := os.Stat(path); err == nil {
+		keys.metadataFile = path
+	}
+	return keys, nil
+}
+
+func (k *keyDirKeys) fingerprint() string {
+	return pqc.KeyFingerprint(k.signer.GetPublicKeyBytes())
+}
+
+func printKeyDirKeys(keys *keyDirKeys) {
+	note := ""
+	if keys.stored {
+		note = " (in the keystore already)"
+	}
+	fmt.Printf("   %s: %s and %s keys, fingerprint %s, from %s files%s\n",
+		keys.serviceID, keys.signer.Algorithm(), keys.kyber.Algorithm(), keys.fingerprint(), strings.Join(keys.formats, " and "), note)
+}
+
+// registerMovedKeys registers keys with the auth service, signed by the
+// keys themselves, unless it has them already. A service registered with
+// another key is an error: which key is right is not for migrate-keys to
+// decide.
+func registerMovedKeys(authURL string, keys *keyDirKeys) error {
+	current, err := registeredFingerprint(authURL, keys.serviceID)
+	if err != nil {
+		return err
+	}
+	if current == keys.fingerprint() {
+		fmt.Printf("🔎 %s is registered with %s already\n", keys.serviceID, current)
+		return nil
+	}
+	if current != "" {
+		return fmt.Errorf("the auth service has %s for %s, not its key %s", current, keys.serviceID, keys.fingerprint())
+	}
+
+	identity := &mesh.Identity{ServiceID: keys.serviceID, Signer: keys.signer, Kyber: keys.kyber}
+	if err := mesh.NewRegistryClient(authURL, identity, mesh.NewPeerVersions()).Register(); err != nil {
+		return fmt.Errorf("failed to register %s: %w", keys.serviceID, err)
+	}
+	fmt.Printf("✅ Registered %s with %s\n", keys.serviceID, authURL)
+	return confirmFingerprint(authURL, keys.serviceID, keys.fingerprint())
+}
+
+// shredKeyDirKeys shreds keys' files and removes their metadata, which the
+// keystore now holds.
+func shredKeyDirKeys(keys *keyDirKeys) error {
+	for _, path := range keys.files {
+		if err := shredFile(path); err != nil {
+			return fmt.Errorf("failed to shred %s: %w", path, err)
+		}
+	}
+	if keys.metadataFile != "" {
+		if err := os.Remove(keys.metadataFile); err != nil {
+			return fmt.Errorf("failed to remove key metadata: %w", err)
+		}
+	}
+	return nil
+}
+
+// shredFile overwrites path with random bytes and syncs them to disk before
+// removing it. Copy-on-write and journaling file systems and SSDs may keep
+// the old blocks regardless; only disk encryption rules that out.
+func shredFile(path string) error {
+	file, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err == nil {
+		_, err = io.CopyN(file, rand.Reader, info.Size())
+	}
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
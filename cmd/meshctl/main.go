@@ -0,0 +1,222 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"quantum-safe-mesh/pkg/mesh"
+	"quantum-safe-mesh/pkg/meshlog"
+	"quantum-safe-mesh/pkg/pqc"
+	"quantum-safe-mesh/pkg/secrets"
+)
+
+func getEnvOrDefault(key, defaultValue string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return defaultValue
+}
+
+type command struct {
+	summary string
+	run     func(args []string) error
+}
+
+var commands = map[string]command{
+	"keygen":          {"Generate signing and KEM keys for a service", runKeygen},
+	"convert":         {"Rewrite a service's key files in another format, e.g. for OpenSSL", runConvert},
+	"recovery-keygen": {"Generate the offline recovery key pair private keys are escrowed to", runRecoveryKeygen},
+	"recover":         {"Restore a service's keys from escrow with the recovery private key", runRecover},
+	"split-key":       {"Split a service's signing key into shares held by separate custodians", runSplitKey},
+	"combine-key":     {"Rebuild a split signing key from its shares in a recovery ceremony", runCombineKey},
+	"delegate":        {"Let a service such as the gateway register services matching a pattern on their behalf", runDelegate},
+	"register":        {"Register a service's public key with the auth service", runRegister},
+	"rotate":          {"Replace a service's registered key with a new one", runRotate},
+	"migrate-keys":    {"Move a service's keys to other algorithms or convert them, with a backup to roll back to, or move a key directory into an encrypted keystore", runMigrateKeys},
+	"revoke":          {"Remove a service from the auth registry", runRevoke},
+	"inspect":         {"Describe a key file, signed envelope or JWS and check its signature", runInspect},
+	"interop":         {"Check keys, signatures and KEM ciphertexts against OpenSSL with the oqs-provider, both ways", runInterop},
+	"list":            {"List services registered with the auth service", runList},
+	"request":         {"Send a signed request to a mesh service", runRequest},
+	"snapshot":        {"Save a signed registry snapshot for services to use while the auth service is unreachable", runSnapshot},
+	"session":         {"Broker a session with a peer through the auth service, or open a ticket brokered with this service", runSession},
+	"attest":          {"Sign an attestation about a build artifact or SBOM, or verify one with 'attest verify'", runAttest},
+	"sign":            {"Sign a file with a service's key, producing a detached JWS", runSign},
+	"verify":          {"Verify a signed response envelope, CMS SignedData or a detached signature over a file", runVerify},
+	"token":           {"Issue a bootstrap token for a service's first registration", runToken},
+	"top":             {"Show live service, key, traffic and audit status", runTop},
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "Usage: meshctl [-v] [-keys-dir dir] [-keystore file] [-keys-format raw|pem|oqs] [-keys-max-age duration] [-escrow-key file] [-passphrase-file file] [-keys-store file|keychain] <command> [flags]")
+	fmt.Fprintln(os.Stderr, "")
+	fmt.Fprintln(os.Stderr, "Commands:")
+
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-16s %s\n", name, commands[name].summary)
+	}
+
+	fmt.Fprintln(os.Stderr, "")
+	fmt.Fprintln(os.Stderr, "Run 'meshctl <command> -h' for command flags.")
+}
+
+// newFlagSet returns a flag set for a subcommand with the flags every
+// command that talks to the auth service shares.
+func newFlagSet(name string) (*flag.FlagSet, *string) {
+	flags := flag.NewFlagSet("meshctl "+name, flag.ExitOnError)
+	authURL := flags.String("auth-url", getEnvOrDefault("AUTH_SERVICE_URL", "http://localhost:8080"), "auth service URL")
+	return flags, authURL
+}
+
+func requireFlag(name, value string) error {
+	if strings.TrimSpace(value) == "" {
+		return fmt.Errorf("--%s is required", name)
+	}
+	return nil
+}
+
+// resolveSignatureAlgorithm checks --alg names one of SignatureAlgorithms,
+// replacing a SPHINCS+ alias with the SLH-DSA parameter set it names.
+func resolveSignatureAlgorithm(alg *string) error {
+	if slhdsa, ok := pqc.SLHDSAForSPHINCS(*alg); ok {
+		*alg = slhdsa
+	}
+	if !slices.Contains(pqc.SignatureAlgorithms, *alg) {
+		return fmt.Errorf("unsupported --alg %q: expected one of %s, or a SPHINCS+ alias of an slh-dsa one", *alg, strings.Join(pqc.SignatureAlgorithms, ", "))
+	}
+	return nil
+}
+
+// loadIdentity loads serviceID's keys and a registry client acting as it.
+func loadIdentity(serviceID, authURL string) (*mesh.Identity, *mesh.RegistryClient, error) {
+	identity, err := mesh.LoadIdentity(serviceID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load keys for %s (run 'meshctl keygen' first?): %w", serviceID, err)
+	}
+	return identity, mesh.NewRegistryClient(authURL, identity, mesh.NewPeerVersions()), nil
+}
+
+// readPassphrase reads the key store passphrase from file, or stdin for
+// "-", falling back to KEYS_PASSPHRASE, which may be a secret reference as
+// for the services. A trailing newline is dropped.
+func readPassphrase(file string) ([]byte, error) {
+	var data []byte
+	var err error
+	switch file {
+	case "":
+		passphrase, err := resolveSecret(os.Getenv("KEYS_PASSPHRASE"))
+		return []byte(passphrase), err
+	case "-":
+		data, err = io.ReadAll(os.Stdin)
+	default:
+		data, err = os.ReadFile(file)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read passphrase: %w", err)
+	}
+	return bytes.TrimRight(data, "\r\n"), nil
+}
+
+// resolveSecret returns the secret value refers to, reading conjur: and
+// vault: references from the servers the services' environment variables
+// name.
+func resolveSecret(value string) (string, error) {
+	store := secrets.NewStore(secrets.DefaultTTL)
+	if url := os.Getenv("CONJUR_APPLIANCE_URL"); url != "" {
+		store.Register(secrets.SchemeConjur, secrets.NewConjur(url, os.Getenv("CONJUR_ACCOUNT"), os.Getenv("CONJUR_AUTHN_LOGIN"), os.Getenv("CONJUR_AUTHN_API_KEY")))
+	}
+	if addr := os.Getenv("VAULT_ADDR"); addr != "" {
+		store.Register(secrets.SchemeVault, secrets.NewVault(addr, os.Getenv("VAULT_TOKEN")))
+	}
+	return store.Resolve(context.Background(), value)
+}
+
+func main() {
+	verbose := flag.Bool("v", false, "show library logs")
+	keysDir := flag.String("keys-dir", getEnvOrDefault("KEYS_DIR", pqc.KeysDir), "key store directory")
+	keystore := flag.String("keystore", os.Getenv("KEYS_KEYSTORE"), "encrypted keystore file to use instead of the key files in -keys-dir")
+	keysFormat := flag.String("keys-format", getEnvOrDefault("KEYS_FORMAT", pqc.KeysFormat), "format new keys are written in: raw, pem or oqs (OpenSSL oqs-provider)")
+	keysMaxAge := flag.String("keys-max-age", getEnvOrDefault("KEYS_MAX_AGE", "0"), "lifetime recorded for keys generated by keygen and rotate, e.g. 2160h; 0 for none")
+	escrowKey := flag.String("escrow-key", os.Getenv("KEYS_ESCROW_KEY"), "recovery public key to escrow keys written by keygen and rotate to")
+	passphraseFile := flag.String("passphrase-file", "", "file holding the passphrase for encrypted keys, or - for stdin (default: env KEYS_PASSPHRASE)")
+	keysStore := flag.String("keys-store", getEnvOrDefault("KEYS_STORE", pqc.KeyStoreFile), "where the passphrase for encrypted keys comes from: file for -passphrase-file or KEYS_PASSPHRASE, or keychain for the OS credential store")
+	flag.Usage = usage
+	flag.Parse()
+
+	pqc.KeysDir = *keysDir
+	pqc.KeystoreFile = *keystore
+	if !slices.Contains(pqc.KeyFormats, *keysFormat) {
+		fmt.Fprintf(os.Stderr, "❌ Invalid -keys-format %q: expected one of %s\n", *keysFormat, strings.Join(pqc.KeyFormats, ", "))
+		os.Exit(2)
+	}
+	pqc.KeysFormat = *keysFormat
+
+	maxAge, err := time.ParseDuration(*keysMaxAge)
+	if err != nil || maxAge < 0 {
+		fmt.Fprintf(os.Stderr, "❌ Invalid -keys-max-age %q: expected a duration such as 2160h\n", *keysMaxAge)
+		os.Exit(2)
+	}
+	pqc.KeyMaxAge = maxAge
+	pqc.EscrowKeyFile = *escrowKey
+
+	passphrase, err := readPassphrase(*passphraseFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+		os.Exit(1)
+	}
+	switch *keysStore {
+	case pqc.KeyStoreFile:
+	case pqc.KeyStoreKeychain:
+		if len(passphrase) > 0 {
+			fmt.Fprintln(os.Stderr, "❌ -keys-store keychain keeps the passphrase in the OS credential store: unset KEYS_PASSPHRASE and -passphrase-file")
+			os.Exit(2)
+		}
+		// Commands that write keys may be the first to need a passphrase.
+		create := slices.Contains([]string{"keygen", "convert", "recover", "migrate-keys"}, flag.Arg(0))
+		if passphrase, err = pqc.KeychainPassphrase(pqc.KeysDir, create); err != nil {
+			fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+			os.Exit(1)
+		}
+	default:
+		fmt.Fprintf(os.Stderr, "❌ Invalid -keys-store %q: expected one of %s\n", *keysStore, strings.Join(pqc.KeyStores, ", "))
+		os.Exit(2)
+	}
+	pqc.KeysPassphrase = passphrase
+
+	if *verbose {
+		meshlog.Setup(meshlog.Options{Level: "debug"})
+	} else {
+		log.SetOutput(io.Discard)
+	}
+
+	if flag.NArg() == 0 {
+		usage()
+		os.Exit(2)
+	}
+
+	cmd, exists := commands[flag.Arg(0)]
+	if !exists {
+		fmt.Fprintf(os.Stderr, "❌ Unknown command %q\n\n", flag.Arg(0))
+		usage()
+		os.Exit(2)
+	}
+
+	if err := cmd.run(flag.Args()[1:]); err != nil {
+		fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,356 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"quantum-safe-mesh/pkg/mesh"
+	"quantum-safe-mesh/pkg/pqc"
+)
+
+// migrationFile is the record migrate-keys keeps with each backup, which
+// --rollback reads to know what to undo.
+const migrationFile = "migration.json"
+
+// backupTimeFormat names backups by when they were made, in a fixed width
+// so that they sort by it.
+const backupTimeFormat = "20060102T150405.000000Z"
+
+type keyMigration struct {
+	ServiceID  string        `json:"service_id"`
+	From       keyAlgorithms `json:"from"`
+	To         keyAlgorithms `json:"to"`
+	Registered bool          `json:"registered"` // whether the auth service was migrated too
+	MigratedAt time.Time     `json:"migrated_at"`
+}
+
+type keyAlgorithms struct {
+	Algorithm    string `json:"algorithm"`
+	KEMAlgorithm string `json:"kem_algorithm"`
+	Fingerprint  string `json:"fingerprint"`
+}
+
+func runMigrateKeys(args []string) error {
+	flags, authURL := newFlagSet("migrate-keys")
+	serviceID := flags.String("service-id", "", "service whose keys to migrate")
+	alg := flags.String("alg", "", "signature algorithm to migrate to (default: that of the current key): "+strings.Join(pqc.SignatureAlgorithms, ", "))
+	kemAlg := flags.String("kem-alg", "", "KEM algorithm to migrate to (default: that of the current key): "+strings.Join(pqc.KEMAlgorithms, ", "))
+	admin := flags.String("admin", "", "sign the registry migration with this administrator identity instead of the service's current key")
+	backupDir := flags.String("backup-dir", filepath.Join(pqc.KeysDir, "migrations"), "directory the keys are backed up to before they are migrated")
+	rollback := flags.Bool("rollback", false, "restore the keys the last migration replaced, in the key store and the registry")
+	dryRun := flags.Bool("dry-run", false, "show what would be migrated or rolled back without changing anything")
+	from := flags.String("from", "", "key directory to move into the keystore named by --to (default: -keys-dir)")
+	to := flags.String("to", "", "encrypted keystore file to move the keys in --from into, leaving their algorithms as they are")
+	register := flags.Bool("register", false, "with --to, register the moved keys with the auth service where it does not have them yet")
+	keep := flags.Bool("keep", false, "with --to, keep the key files instead of shredding them once the keystore holds the keys")
+	flags.Parse(args)
+
+	if *to != "" {
+		if *alg != "" || *kemAlg != "" || *admin != "" || *rollback {
+			return fmt.Errorf("--to moves keys into a keystore as they are: drop --alg, --kem-alg, --admin and --rollback")
+		}
+		if *from == "" {
+			*from = pqc.KeysDir
+		}
+		return migrateToKeystore(*from, *to, *serviceID, *authURL, *register, *keep, *dryRun)
+	}
+	if *from != "" || *register || *keep {
+		return fmt.Errorf("--from, --register and --keep go with --to")
+	}
+	if err := requireFlag("service-id", *serviceID); err != nil {
+		return err
+	}
+	if *rollback {
+		if *alg != "" || *kemAlg != "" {
+			return fmt.Errorf("--rollback restores the algorithms of the last migration: drop --alg and --kem-alg")
+		}
+		return rollbackKeys(*serviceID, *authURL, *admin, filepath.Join(*backupDir, *serviceID), *dryRun)
+	}
+	if *alg != "" {
+		if err := resolveSignatureAlgorithm(alg); err != nil {
+			return err
+		}
+	}
+	if *kemAlg != "" && !slices.Contains(pqc.KEMAlgorithms, *kemAlg) {
+		return fmt.Errorf("unsupported --kem-alg %q: expected one of %s", *kemAlg, strings.Join(pqc.KEMAlgorithms, ", "))
+	}
+
+	identity, err := mesh.LoadIdentity(*serviceID)
+	if err != nil {
+		return fmt.Errorf("failed to load keys for %s: %w", *serviceID, err)
+	}
+	kyberKeyPair := identity.Kyber.(*pqc.KyberKeyPair)
+	metadata, err := pqc.LoadKeyMetadata(*serviceID, identity.PublicKey())
+	if err != nil {
+		return err
+	}
+
+	migration := keyMigration{
+		ServiceID:  *serviceID,
+		From:       keyAlgorithms{Algorithm: identity.Signer.Algorithm(), KEMAlgorithm: kyberKeyPair.Algorithm(), Fingerprint: identity.KeyID()},
+		MigratedAt: time.Now().UTC(),
+	}
+	migration.To = migration.From
+	if *alg != "" {
+		migration.To.Algorithm = *alg
+	}
+	if *kemAlg != "" {
+		migration.To.KEMAlgorithm = *kemAlg
+	}
+
+	// Keys that keep their algorithms are only converted: rewritten in
+	// KEYS_FORMAT with their algorithms recorded. Any other key is
+	// re-issued, which the registry has to accept first.
+	newSigner := migration.To.Algorithm != migration.From.Algorithm
+	newKEM := migration.To.KEMAlgorithm != migration.From.KEMAlgorithm
+	migration.Registered = newSigner || newKEM
+
+	signer := *serviceID
+	if *admin != "" {
+		signer = *admin
+	}
+	var current string
+	if migration.Registered {
+		if current, err = registeredFingerprint(*authURL, *serviceID); err != nil {
+			return err
+		}
+		if current == "" {
+			return fmt.Errorf("%s is not registered; use 'meshctl keygen --alg' and 'meshctl register' for new keys", *serviceID)
+		}
+	}
+
+	backup := filepath.Join(*backupDir, *serviceID, migration.MigratedAt.Format(backupTimeFormat))
+	if *dryRun {
+		fmt.Printf("🧪 Dry run: migrate the keys of %s in %s/ (%s format)\n", *serviceID, pqc.KeysDir, pqc.KeysFormat)
+		fmt.Printf("   Signing key:    %s\n", describeMigration(migration.From.Algorithm, migration.To.Algorithm))
+		fmt.Printf("   KEM key:        %s\n", describeMigration(migration.From.KEMAlgorithm, migration.To.KEMAlgorithm))
+		fmt.Printf("   Backup:         %s/\n", backup)
+		if migration.Registered {
+			fmt.Printf("   Registered key: %s at %s\n", current, *authURL)
+			fmt.Printf("   Authorized by:  %s%s\n", signer, adminNote(*admin))
+			if *admin == "" && current != identity.KeyID() {
+				return fmt.Errorf("the auth service would reject the migration: %s's local key is not the registered one", *serviceID)
+			}
+		}
+		return nil
+	}
+
+	registry := mesh.NewRegistryClient(*authURL, identity, mesh.NewPeerVersions())
+	if migration.Registered && *admin != "" {
+		if _, registry, err = loadIdentity(*admin, *authURL); err != nil {
+			return err
+		}
+	}
+
+	if _, err := pqc.BackupKeyFiles(*serviceID, backup); err != nil {
+		return err
+	}
+
+	if !migration.Registered {
+		if err := saveMigration(backup, &migration); err != nil {
+			return err
+		}
+		if err := pqc.RestoreKeyPair(*serviceID, identity.Signer, kyberKeyPair, metadata); err != nil {
+			return fmt.Errorf("failed to convert keys, the originals are in %s: %w", backup, err)
+		}
+		fmt.Printf("🔁 Converted keys for %s in %s/ (%s format)\n", *serviceID, pqc.KeysDir, pqc.KeysFormat)
+		fmt.Printf("   Signing key: %s, fingerprint %s\n", migration.To.Algorithm, migration.To.Fingerprint)
+		fmt.Printf("   KEM key:     %s\n", migration.To.KEMAlgorithm)
+		fmt.Printf("   Backup:      %s/\n", backup)
+		return nil
+	}
+
+	nextSigner := identity.Signer
+	var rotated pqc.Signer
+	if newSigner {
+		if rotated, err = pqc.GenerateSigner(migration.To.Algorithm); err != nil {
+			return fmt.Errorf("failed to generate %s keypair: %w", migration.To.Algorithm, err)
+		}
+		nextSigner = rotated
+	}
+	nextKyber := kyberKeyPair
+	var kemPublicKey []byte
+	if newKEM {
+		if nextKyber, err = pqc.GenerateKEMKeyPair(migration.To.KEMAlgorithm); err != nil {
+			return err
+		}
+		kemPublicKey = nextKyber.GetPublicKeyBytes()
+	}
+
+	if _, err := registry.MigrateService(*serviceID, rotated, migration.To.KEMAlgorithm, kemPublicKey, false); err != nil {
+		os.RemoveAll(backup)
+		return err
+	}
+
+	// The auth service already trusts the new keys; losing them here would
+	// lock the service out, so say exactly what happened.
+	migration.To.Fingerprint = pqc.KeyFingerprint(nextSigner.GetPublicKeyBytes())
+	if err := saveMigration(backup, &migration); err != nil {
+		return fmt.Errorf("keys migrated to %s but %w", migration.To.Fingerprint, err)
+	}
+	if newSigner {
+		err = pqc.SaveKeyPair(*serviceID, nextSigner, nextKyber)
+	} else {
+		err = pqc.RestoreKeyPair(*serviceID, nextSigner, nextKyber, metadata)
+	}
+	if err != nil {
+		return fmt.Errorf("keys migrated to %s but saving them failed: %w", migration.To.Fingerprint, err)
+	}
+
+	fmt.Printf("🔀 Migrated keys for %s\n", *serviceID)
+	fmt.Printf("   Signing key:   %s\n", describeMigration(migration.From.Algorithm, migration.To.Algorithm))
+	fmt.Printf("   KEM key:       %s\n", describeMigration(migration.From.KEMAlgorithm, migration.To.KEMAlgorithm))
+	fmt.Printf("   Fingerprint:   %s -> %s\n", migration.From.Fingerprint, migration.To.Fingerprint)
+	fmt.Printf("   Authorized by: %s%s\n", signer, adminNote(*admin))
+	fmt.Printf("   Backup:        %s/ (undo with 'meshctl migrate-keys --service-id %s --rollback')\n", backup, *serviceID)
+	fmt.Println("   Restart the service to pick up the new keys.")
+	return confirmFingerprint(*authURL, *serviceID, migration.To.Fingerprint)
+}
+
+// rollbackKeys restores the keys the last migration of serviceID backed up
+// to dir, migrating the registry back to them if the migration changed it.
+// The backup is removed once the rollback is through.
+func rollbackKeys(serviceID, authURL, admin, dir string, dryRun bool) error {
+	backup, migration, err := lastMigration(dir)
+	if err != nil {
+		return err
+	}
+
+	identity, err := mesh.LoadIdentity(serviceID)
+	if err != nil {
+		return fmt.Errorf("failed to load keys for %s: %w", serviceID, err)
+	}
+	if identity.KeyID() != migration.To.Fingerprint {
+		return fmt.Errorf("the key of %s is %s, not %s as migrated on %s: it changed since, restore %s/ by hand if need be",
+			serviceID, identity.KeyID(), migration.To.Fingerprint, migration.MigratedAt.Format(time.RFC3339), backup)
+	}
+
+	signer := serviceID
+	if admin != "" {
+		signer = admin
+	}
+	if dryRun {
+		fmt.Printf("🧪 Dry run: roll back the keys of %s from %s/\n", serviceID, backup)
+		fmt.Printf("   Signing key:    %s\n", describeRollback(migration.To.Algorithm, migration.From.Algorithm))
+		fmt.Printf("   KEM key:        %s\n", describeRollback(migration.To.KEMAlgorithm, migration.From.KEMAlgorithm))
+		fmt.Printf("   Fingerprint:    %s -> %s\n", migration.To.Fingerprint, migration.From.Fingerprint)
+		if migration.Registered {
+			fmt.Printf("   Registry:       %s, authorized by %s%s\n", authURL, signer, adminNote(admin))
+		}
+		return nil
+	}
+
+	registry := mesh.NewRegistryClient(authURL, identity, mesh.NewPeerVersions())
+	if migration.Registered && admin != "" {
+		if _, registry, err = loadIdentity(admin, authURL); err != nil {
+			return err
+		}
+	}
+
+	// Keep the migrated keys at hand: if the registry refuses to go back,
+	// they are the ones it still trusts.
+	kyberKeyPair := identity.Kyber.(*pqc.KyberKeyPair)
+	metadata, err := pqc.LoadKeyMetadata(serviceID, identity.PublicKey())
+	if err != nil {
+		return err
+	}
+	if err := pqc.RestoreKeyFiles(serviceID, backup); err != nil {
+		return err
+	}
+	previous, err := mesh.LoadIdentity(serviceID)
+	if err == nil && migration.Registered {
+		var rotated pqc.Signer
+		if previous.KeyID() != identity.KeyID() {
+			rotated = previous.Signer
+		}
+		var kemPublicKey []byte
+		if previousKEM := previous.Kyber.GetPublicKeyBytes(); !bytes.Equal(previousKEM, kyberKeyPair.GetPublicKeyBytes()) {
+			kemPublicKey = previousKEM
+		}
+		_, err = registry.MigrateService(serviceID, rotated, migration.From.KEMAlgorithm, kemPublicKey, true)
+	}
+	if err != nil {
+		if restoreErr := pqc.RestoreKeyPair(serviceID, identity.Signer, kyberKeyPair, metadata); restoreErr != nil {
+			return fmt.Errorf("rollback failed: %w; putting the migrated keys back also failed: %v", err, restoreErr)
+		}
+		return fmt.Errorf("rollback failed, the migrated keys are still in place: %w", err)
+	}
+
+	if err := os.RemoveAll(backup); err != nil {
+		fmt.Printf("⚠️  Failed to remove the backup %s/: %v\n", backup, err)
+	}
+
+	fmt.Printf("⏪ Rolled back keys for %s\n", serviceID)
+	fmt.Printf("   Signing key:   %s\n", describeRollback(migration.To.Algorithm, migration.From.Algorithm))
+	fmt.Printf("   KEM key:       %s\n", describeRollback(migration.To.KEMAlgorithm, migration.From.KEMAlgorithm))
+	fmt.Printf("   Fingerprint:   %s -> %s\n", migration.To.Fingerprint, migration.From.Fingerprint)
+	if !migration.Registered {
+		return nil
+	}
+	fmt.Printf("   Authorized by: %s%s\n", signer, adminNote(admin))
+	fmt.Println("   Restart the service to pick up the restored keys.")
+	return confirmFingerprint(authURL, serviceID, migration.From.Fingerprint)
+}
+
+// lastMigration finds the latest backup in dir and reads its record.
+func lastMigration(dir string) (string, *keyMigration, error) {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return "", nil, fmt.Errorf("no migration to roll back in %s/", dir)
+	}
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to read backups: %w", err)
+	}
+	for i := len(entries) - 1; i >= 0; i-- {
+		if !entries[i].IsDir() {
+			continue
+		}
+		backup := filepath.Join(dir, entries[i].Name())
+		data, err := os.ReadFile(filepath.Join(backup, migrationFile))
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return "", nil, fmt.Errorf("failed to read migration record: %w", err)
+		}
+		var migration keyMigration
+		if err := json.Unmarshal(data, &migration); err != nil {
+			return "", nil, fmt.Errorf("failed to decode %s: %w", filepath.Join(backup, migrationFile), err)
+		}
+		return backup, &migration, nil
+	}
+	return "", nil, fmt.Errorf("no migration to roll back in %s/", dir)
+}
+
+func saveMigration(backup string, migration *keyMigration) error {
+	data, err := json.MarshalIndent(migration, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode migration record: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(backup, migrationFile), append(data, '\n'), 0600); err != nil {
+		return fmt.Errorf("failed to save migration record: %w", err)
+	}
+	return nil
+}
+
+func describeMigration(from, to string) string {
+	if from == to {
+		return from + " (converted)"
+	}
+	return from + " -> " + to + " (re-issued)"
+}
+
+func describeRollback(from, to string) string {
+	if from == to {
+		return to
+	}
+	return from + " -> " + to
+}
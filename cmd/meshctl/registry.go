@@ -0,0 +1,487 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"quantum-safe-mesh/pkg/mesh"
+	"quantum-safe-mesh/pkg/models"
+	"quantum-safe-mesh/pkg/pqc"
+)
+
+// adminTokenTTL is the lifetime of the bootstrap token 'register --admin'
+// mints for the registration it is about to make.
+const adminTokenTTL = 5 * time.Minute
+
+func runRegister(args []string) error {
+	flags, authURL := newFlagSet("register")
+	serviceID := flags.String("service-id", "", "service to register")
+	address := flags.String("address", "", "base URL to advertise for registry discovery")
+	token := flags.String("token", os.Getenv("MESH_BOOTSTRAP_TOKEN"), "bootstrap token, or @file holding one (env MESH_BOOTSTRAP_TOKEN)")
+	admin := flags.String("admin", "", "identity whose key vouches for the registration with a freshly issued bootstrap token")
+	delegator := flags.String("delegator", "", "identity that registers the service on its behalf with a signed delegation, e.g. for serverless workloads")
+	delegationFile := flags.String("delegation", "", "delegation chain letting --delegator register the service, if it is not a trusted delegator itself (see 'meshctl delegate')")
+	dryRun := flags.Bool("dry-run", false, "check the registration and show the resulting fingerprints without registering")
+	flags.Parse(args)
+
+	if err := requireFlag("service-id", *serviceID); err != nil {
+		return err
+	}
+	if *token != "" && *admin != "" {
+		return fmt.Errorf("--token and --admin are mutually exclusive")
+	}
+	if *delegator != "" && (*token != "" || *admin != "") {
+		return fmt.Errorf("--delegator replaces --token and --admin: the delegation vouches for the registration")
+	}
+	if *delegationFile != "" && *delegator == "" {
+		return fmt.Errorf("--delegation needs --delegator")
+	}
+
+	identity, registry, err := loadIdentity(*serviceID, *authURL)
+	if err != nil {
+		return err
+	}
+	if *address != "" {
+		registry.Advertise(*address)
+	}
+
+	if strings.HasPrefix(*token, "@") {
+		data, err := os.ReadFile(strings.TrimPrefix(*token, "@"))
+		if err != nil {
+			return fmt.Errorf("failed to read bootstrap token: %w", err)
+		}
+		*token = strings.TrimSpace(string(data))
+	}
+
+	authentication := "none (the auth service must not require bootstrap tokens)"
+	var delegatorRegistry *mesh.RegistryClient
+	var delegation []models.DelegationAssertion
+	if *delegator != "" {
+		if _, delegatorRegistry, err = loadIdentity(*delegator, *authURL); err != nil {
+			return err
+		}
+		authentication = "delegation signed by " + *delegator
+		if *delegationFile != "" {
+			if delegation, err = mesh.LoadDelegation(*delegationFile); err != nil {
+				return err
+			}
+			delegators := make([]string, 0, len(delegation)+1)
+			for _, link := range delegation {
+				delegators = append(delegators, link.Delegator)
+			}
+			authentication = "delegation chain " + strings.Join(append(delegators, *delegator), " → ")
+		}
+	}
+	if *admin != "" {
+		adminIdentity, err := mesh.LoadIdentity(*admin)
+		if err != nil {
+			return fmt.Errorf("failed to load admin keys for %s: %w", *admin, err)
+		}
+		if *token, err = adminIdentity.IssueBootstrapToken(*serviceID, adminTokenTTL); err != nil {
+			return err
+		}
+		authentication = fmt.Sprintf("bootstrap token issued by admin %s (fingerprint %s)", *admin, adminIdentity.KeyID())
+	}
+	if *token != "" {
+		claims, err := mesh.VerifyBootstrapToken(*token, newLookupRegistry(*authURL).PublicKey)
+		if err != nil {
+			return fmt.Errorf("bootstrap token is not valid: %w", err)
+		}
+		if claims.Subject != *serviceID {
+			return fmt.Errorf("bootstrap token was issued for %s, not %s", claims.Subject, *serviceID)
+		}
+		if *admin == "" {
+			authentication = fmt.Sprintf("bootstrap token issued by %s, expires %s", claims.Issuer, claims.Expiry().Format(time.RFC3339))
+		}
+		registry.UseBootstrapToken(*token)
+	}
+
+	current, err := registeredFingerprint(*authURL, *serviceID)
+	if err != nil {
+		return err
+	}
+
+	if *dryRun {
+		fmt.Printf("🧪 Dry run: register %s with %s\n", *serviceID, *authURL)
+		fmt.Printf("   Registered key: %s\n", describeFingerprint(current))
+		fmt.Printf("   New key:        %s%s\n", identity.KeyID(), unchanged(current, identity.KeyID()))
+		fmt.Printf("   Authentication: %s\n", authentication)
+		return nil
+	}
+
+	if delegatorRegistry != nil {
+		workload, err := identity.NewWorkloadRegistration(*address)
+		if err != nil {
+			return err
+		}
+		if err := delegatorRegistry.RegisterDelegated(workload, delegation, adminTokenTTL); err != nil {
+			return err
+		}
+	} else if err := registry.Register(); err != nil {
+		return err
+	}
+
+	fmt.Printf("✅ Registered %s with %s\n", *serviceID, *authURL)
+	fmt.Printf("   Fingerprint: %s\n", identity.KeyID())
+	if delegatorRegistry != nil {
+		fmt.Printf("   Vouched by:  %s\n", authentication)
+	}
+	if current != "" && current != identity.KeyID() {
+		fmt.Printf("   Replaced:    %s\n", current)
+	}
+	return confirmFingerprint(*authURL, *serviceID, identity.KeyID())
+}
+
+func runRotate(args []string) error {
+	flags, authURL := newFlagSet("rotate")
+	serviceID := flags.String("service-id", "", "service whose key to rotate")
+	admin := flags.String("admin", "", "sign the rotation with this administrator identity instead of the service's current key")
+	dryRun := flags.Bool("dry-run", false, "check the rotation and show the current fingerprints without rotating")
+	alg := flags.String("alg", getEnvOrDefault("SIGNATURE_ALGORITHM", ""),
+		"signature algorithm of the new key (default: that of the current key): "+strings.Join(pqc.SignatureAlgorithms, ", "))
+	flags.Parse(args)
+
+	if err := requireFlag("service-id", *serviceID); err != nil {
+		return err
+	}
+	if *alg != "" {
+		if err := resolveSignatureAlgorithm(alg); err != nil {
+			return err
+		}
+	}
+
+	// The signer is the service itself, or an administrator acting for a
+	// service whose key is lost or compromised.
+	signer := *serviceID
+	if *admin != "" {
+		signer = *admin
+	}
+	signerIdentity, registry, err := loadIdentity(signer, *authURL)
+	if err != nil {
+		return err
+	}
+
+	// Keep the service's Kyber key if it is available locally.
+	identity := signerIdentity
+	if *admin != "" {
+		if identity, err = mesh.LoadIdentity(*serviceID); err != nil {
+			identity = nil
+		}
+	}
+
+	current, err := registeredFingerprint(*authURL, *serviceID)
+	if err != nil {
+		return err
+	}
+	if current == "" {
+		return fmt.Errorf("%s is not registered; use 'meshctl register'", *serviceID)
+	}
+
+	algorithm := *alg
+	if algorithm == "" {
+		algorithm = pqc.AlgorithmDilithium3
+		if identity != nil {
+			algorithm = identity.Signer.Algorithm()
+		}
+	}
+
+	if *dryRun {
+		fmt.Printf("🧪 Dry run: rotate the key of %s at %s\n", *serviceID, *authURL)
+		fmt.Printf("   Registered key: %s\n", current)
+		if identity != nil {
+			fmt.Printf("   Local key:      %s%s\n", identity.KeyID(), mismatch(current, identity.KeyID()))
+		}
+		fmt.Printf("   New key:        %s, generated at rotation and saved to %s/\n", algorithm, pqc.KeysDir)
+		fmt.Printf("   Authorized by:  %s (fingerprint %s)%s\n", signer, signerIdentity.KeyID(), adminNote(*admin))
+		if *admin == "" && identity.KeyID() != current {
+			return fmt.Errorf("the auth service would reject the rotation: %s's local key is not the registered one", *serviceID)
+		}
+		return nil
+	}
+
+	newKey, err := pqc.GenerateSigner(algorithm)
+	if err != nil {
+		return fmt.Errorf("failed to generate %s keypair: %w", algorithm, err)
+	}
+
+	var kyberKeyPair *pqc.KyberKeyPair
+	if identity != nil {
+		kyberKeyPair = identity.Kyber.(*pqc.KyberKeyPair)
+	} else if kyberKeyPair, err = pqc.GenerateKyberKeyPair(); err != nil {
+		return fmt.Errorf("failed to generate Kyber keypair: %w", err)
+	}
+
+	if _, err := registry.RotateService(*serviceID, newKey); err != nil {
+		return err
+	}
+
+	// The auth service already trusts the new key; losing it here would
+	// lock the service out, so say exactly what happened.
+	if err := pqc.SaveKeyPair(*serviceID, newKey, kyberKeyPair); err != nil {
+		return fmt.Errorf("key rotated to %s but saving it failed: %w", pqc.KeyFingerprint(newKey.GetPublicKeyBytes()), err)
+	}
+
+	fmt.Printf("🔄 Rotated key for %s\n", *serviceID)
+	fmt.Printf("   Old fingerprint: %s\n", current)
+	fmt.Printf("   New fingerprint: %s (%s)\n", pqc.KeyFingerprint(newKey.GetPublicKeyBytes()), algorithm)
+	if pqc.KeyMaxAge > 0 {
+		fmt.Printf("   Expires:         %s\n", time.Now().Add(pqc.KeyMaxAge).UTC().Format(time.RFC3339))
+	}
+	fmt.Printf("   Authorized by:   %s%s\n", signer, adminNote(*admin))
+	if *admin != "" {
+		fmt.Printf("   Deliver the new keys in %s/ to %s, then restart it.\n", pqc.KeysDir, *serviceID)
+	} else {
+		fmt.Println("   Restart the service to pick up the new key.")
+	}
+	return confirmFingerprint(*authURL, *serviceID, pqc.KeyFingerprint(newKey.GetPublicKeyBytes()))
+}
+
+func runRevoke(args []string) error {
+	flags, authURL := newFlagSet("revoke")
+	serviceID := flags.String("service-id", "", "service to revoke")
+	admin := flags.String("admin", "", "sign the revocation with this administrator identity instead of the service's own key")
+	dryRun := flags.Bool("dry-run", false, "show the key that would be revoked without revoking it")
+	flags.Parse(args)
+
+	if err := requireFlag("service-id", *serviceID); err != nil {
+		return err
+	}
+
+	signer := *serviceID
+	if *admin != "" {
+		signer = *admin
+	}
+	signerIdentity, registry, err := loadIdentity(signer, *authURL)
+	if err != nil {
+		return err
+	}
+
+	current, err := registeredFingerprint(*authURL, *serviceID)
+	if err != nil {
+		return err
+	}
+	if current == "" {
+		return fmt.Errorf("%s is not registered", *serviceID)
+	}
+
+	if *dryRun {
+		fmt.Printf("🧪 Dry run: revoke %s at %s\n", *serviceID, *authURL)
+		fmt.Printf("   Registered key: %s\n", current)
+		fmt.Printf("   Authorized by:  %s (fingerprint %s)%s\n", signer, signerIdentity.KeyID(), adminNote(*admin))
+		if *admin == "" && signerIdentity.KeyID() != current {
+			return fmt.Errorf("the auth service would reject the revocation: %s's local key is not the registered one", *serviceID)
+		}
+		return nil
+	}
+
+	if _, err := registry.RevokeService(*serviceID); err != nil {
+		return err
+	}
+
+	fmt.Printf("🚫 Revoked %s (fingerprint %s)\n", *serviceID, current)
+	fmt.Printf("   Authorized by: %s%s\n", signer, adminNote(*admin))
+	return confirmFingerprint(*authURL, *serviceID, "")
+}
+
+// registeredFingerprint fetches serviceID's registered key from the auth
+// service, bypassing any cache, and returns its fingerprint, or "" if
+// serviceID is not registered.
+func registeredFingerprint(authURL, serviceID string) (string, error) {
+	publicKey, err := newLookupRegistry(authURL).PublicKey(serviceID)
+	var errResp *models.ErrorResponse
+	if errors.As(err, &errResp) && errResp.Code == models.ErrCodeServiceNotFound {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return pqc.KeyFingerprint(publicKey), nil
+}
+
+// confirmFingerprint reads serviceID's key back from the auth service and
+// checks it is the one expected; "" expects no key at all.
+func confirmFingerprint(authURL, serviceID, expected string) error {
+	registered, err := registeredFingerprint(authURL, serviceID)
+	if err != nil {
+		return fmt.Errorf("failed to confirm the change: %w", err)
+	}
+	if registered != expected {
+		return fmt.Errorf("auth service reports %s for %s, expected %s",
+			describeFingerprint(registered), serviceID, describeFingerprint(expected))
+	}
+	fmt.Printf("🔎 Confirmed: auth service reports %s for %s\n", describeFingerprint(registered), serviceID)
+	return nil
+}
+
+func describeFingerprint(fingerprint string) string {
+	if fingerprint == "" {
+		return "not registered"
+	}
+	return fingerprint
+}
+
+func unchanged(current, next string) string {
+	if current == next {
+		return " (unchanged)"
+	}
+	return ""
+}
+
+func mismatch(registered, local string) string {
+	if registered != local {
+		return " ⚠️  does not match the registered key"
+	}
+	return ""
+}
+
+func adminNote(admin string) string {
+	if admin != "" {
+		return " as administrator"
+	}
+	return ""
+}
+
+func runList(args []string) error {
+	flags, authURL := newFlagSet("list")
+	serviceID := flags.String("service-id", "", "identity to query the registry as")
+	flags.Parse(args)
+
+	if err := requireFlag("service-id", *serviceID); err != nil {
+		return err
+	}
+
+	_, registry, err := loadIdentity(*serviceID, *authURL)
+	if err != nil {
+		return err
+	}
+
+	services, err := registry.Services()
+	if err != nil {
+		return err
+	}
+
+	sort.Strings(services)
+	fmt.Printf("📋 %d registered services\n", len(services))
+	for _, id := range services {
+		fingerprint, address, delegated := "unknown", "", ""
+		if registration, err := registry.Registration(id); err == nil {
+			fingerprint, address = pqc.KeyFingerprint(registration.PublicKey), registration.Address
+			if len(registration.DelegatedBy) > 0 {
+				delegated = " (delegated by " + strings.Join(registration.DelegatedBy, " → ") + ")"
+			}
+		}
+		fmt.Printf("   %-24s %s %s%s\n", id, fingerprint, address, delegated)
+	}
+	return nil
+}
+
+func runDelegate(args []string) error {
+	flags, _ := newFlagSet("delegate")
+	serviceID := flags.String("service-id", "", "delegator whose key signs the delegation")
+	to := flags.String("to", "", "service allowed to register services on their behalf, e.g. api-gateway")
+	scope := flags.String("scope", "", "pattern of service IDs it may register, e.g. 'fn-*'")
+	ttl := flags.Duration("ttl", 30*24*time.Hour, "how long the delegation is valid")
+	upstream := flags.String("delegation", "", "delegation chain letting --service-id delegate, if it is not a trusted delegator itself")
+	out := flags.String("out", "delegation.json", "file to write the delegation chain to, for DELEGATION_FILE or 'meshctl register --delegation'")
+	flags.Parse(args)
+
+	if err := requireFlag("service-id", *serviceID); err != nil {
+		return err
+	}
+	if err := requireFlag("to", *to); err != nil {
+		return err
+	}
+	if err := requireFlag("scope", *scope); err != nil {
+		return err
+	}
+	if _, err := path.Match(*scope, ""); err != nil {
+		return fmt.Errorf("invalid --scope %q: %w", *scope, err)
+	}
+
+	identity, err := mesh.LoadIdentity(*serviceID)
+	if err != nil {
+		return fmt.Errorf("failed to load keys for %s: %w", *serviceID, err)
+	}
+	var chain []models.DelegationAssertion
+	if *upstream != "" {
+		if chain, err = mesh.LoadDelegation(*upstream); err != nil {
+			return err
+		}
+		if len(chain) == 0 {
+			return fmt.Errorf("%s holds no delegation", *upstream)
+		}
+		if last := chain[len(chain)-1]; last.Subject != *serviceID {
+			return fmt.Errorf("%s delegates to %s, not %s", *upstream, last.Subject, *serviceID)
+		}
+	}
+
+	link, err := identity.SignDelegation(models.DelegationAssertion{Subject: *to, Scope: *scope}, *ttl)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(append(chain, *link), "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode delegation: %w", err)
+	}
+	if err := os.WriteFile(*out, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write delegation: %w", err)
+	}
+
+	fmt.Printf("🤝 %s lets %s register services matching %q on their behalf\n", *serviceID, *to, *scope)
+	fmt.Printf("   Delegation:  %s\n", *out)
+	fmt.Printf("   Valid until: %s\n", link.ExpiresAt.Local().Format(time.RFC1123))
+	return nil
+}
+
+func runSnapshot(args []string) error {
+	flags, authURL := newFlagSet("snapshot")
+	out := flags.String("out", "registry-snapshot.json", "file to save the snapshot to, for REGISTRY_SNAPSHOT_FILE")
+	signer := flags.String("signer", os.Getenv("REGISTRY_SNAPSHOT_SIGNER"), "fingerprint of the auth service key the snapshot must be signed with (default: the auth service key in the local key store)")
+	flags.Parse(args)
+
+	if *signer == "" {
+		publicKey, err := pqc.LoadPublicKey(mesh.AuthServiceID)
+		if err != nil {
+			return fmt.Errorf("no --signer given and no %s key in the local key store to check the snapshot with: %w", mesh.AuthServiceID, err)
+		}
+		*signer = pqc.KeyFingerprint(publicKey)
+	}
+
+	snapshot, err := newLookupRegistry(*authURL).Snapshot()
+	if err != nil {
+		return err
+	}
+	if err := mesh.VerifyRegistrySnapshot(snapshot, *signer, time.Now()); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(snapshot, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode registry snapshot: %w", err)
+	}
+	// Services reload the file when it changes: rename it into place so
+	// they never read half of it.
+	tmp := *out + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write registry snapshot: %w", err)
+	}
+	if err := os.Rename(tmp, *out); err != nil {
+		return fmt.Errorf("failed to write registry snapshot: %w", err)
+	}
+
+	fmt.Printf("📦 Saved a snapshot of %d registered services to %s\n", len(snapshot.Registry.Services), *out)
+	fmt.Printf("   Signed by:   %s (%s)\n", snapshot.Issuer, *signer)
+	fmt.Printf("   Valid until: %s\n", snapshot.ExpiresAt.Local().Format(time.RFC1123))
+	return nil
+}
+
+// newLookupRegistry returns a registry client for key lookups only.
+func newLookupRegistry(authURL string) *mesh.RegistryClient {
+	return mesh.NewRegistryClient(authURL, nil, mesh.NewPeerVersions())
+}
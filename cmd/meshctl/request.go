@@ -0,0 +1,265 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"quantum-safe-mesh/pkg/mesh"
+	"quantum-safe-mesh/pkg/models"
+	"quantum-safe-mesh/pkg/pqc"
+)
+
+func runRequest(args []string) error {
+	flags, authURL := newFlagSet("request")
+	serviceID := flags.String("service-id", "", "identity to sign the request as")
+	peerID := flags.String("peer", "backend-service", "service ID of the peer")
+	peerURL := flags.String("url", "http://localhost:8082", "peer base URL")
+	path := flags.String("path", "/echo", "request path")
+	method := flags.String("method", "POST", "original method to sign into the request")
+	data := flags.String("data", "{}", "JSON request body")
+	mode := flags.String("mode", mesh.SignatureModeEnvelope, "signature mode: envelope or detached")
+	out := flags.String("out", "", "write the signed response to this file for 'meshctl verify'")
+	flags.Parse(args)
+
+	if err := requireFlag("service-id", *serviceID); err != nil {
+		return err
+	}
+	if !json.Valid([]byte(*data)) {
+		return fmt.Errorf("--data must be valid JSON")
+	}
+
+	identity, registry, err := loadIdentity(*serviceID, *authURL)
+	if err != nil {
+		return err
+	}
+
+	client, err := mesh.NewSignedClient(identity, registry, mesh.NewPeerVersions(), *peerID, *peerURL, *mode)
+	if err != nil {
+		return err
+	}
+
+	requestID := models.NewRequestID()
+	fmt.Printf("📤 %s %s%s as %s (%s mode) [request_id=%s]\n", *method, *peerURL, *path, *serviceID, client.Mode(), requestID)
+
+	resp, errResp := client.Call(&mesh.Call{
+		Method:    *method,
+		Path:      *path,
+		Body:      []byte(*data),
+		RequestID: requestID,
+	})
+	if errResp != nil {
+		return fmt.Errorf("request failed (status %d): %w", errResp.Status, errResp)
+	}
+
+	fmt.Printf("✅ %s responded with status %d; signature verified\n", *peerID, resp.StatusCode)
+
+	body := resp.Body
+	saved := resp.Body
+	if resp.Envelope != nil {
+		body = resp.Envelope.Data
+		fmt.Printf("   Signer:    %s (chain of %d)\n", resp.Envelope.ServiceID, len(resp.Envelope.Chain))
+		fmt.Printf("   Protocol:  %s\n", models.NormalizeProtocolVersion(resp.Envelope.ProtocolVersion))
+		fmt.Printf("   Timestamp: %s\n", resp.Envelope.Timestamp)
+
+		saved, err = json.MarshalIndent(resp.Envelope, "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to marshal response envelope: %w", err)
+		}
+	} else {
+		fmt.Printf("   Signature: %s\n", resp.Token)
+	}
+
+	var pretty bytes.Buffer
+	if json.Indent(&pretty, body, "", "  ") == nil {
+		body = pretty.Bytes()
+	}
+	fmt.Println(string(body))
+
+	if *out != "" {
+		if err := os.WriteFile(*out, saved, 0644); err != nil {
+			return fmt.Errorf("failed to write response: %w", err)
+		}
+		if resp.Envelope == nil {
+			if err := os.WriteFile(*out+".sig", []byte(resp.Token), 0644); err != nil {
+				return fmt.Errorf("failed to write signature: %w", err)
+			}
+		}
+		fmt.Printf("💾 Response saved to %s\n", *out)
+	}
+	return nil
+}
+
+func runVerify(args []string) error {
+	flags, authURL := newFlagSet("verify")
+	file := flags.String("file", "", "response envelope or SignedData, or raw file when --sig is given; may also be given as an argument")
+	sigFile := flags.String("sig", "", "file holding a detached JWS over the file")
+	signer := flags.String("service-id", "", "expected signer; required with --sig")
+	keySource := flags.String("key-source", keySourceAuto, "where to find public keys: auto (local key store, then auth service), local or auth")
+	flags.Parse(args)
+
+	if flags.NArg() > 1 || (flags.NArg() == 1 && *file != "") {
+		return fmt.Errorf("unexpected arguments %q: give one file, after the flags", flags.Args())
+	}
+	if *file == "" {
+		*file = flags.Arg(0)
+	}
+	if err := requireFlag("file", *file); err != nil {
+		return err
+	}
+
+	data, err := os.ReadFile(*file)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", *file, err)
+	}
+
+	keys, err := newKeyResolver(*keySource, *authURL)
+	if err != nil {
+		return err
+	}
+
+	if *sigFile != "" {
+		return verifyDetached(keys, data, *sigFile, *signer)
+	}
+	// DER starts with a SEQUENCE tag, which no JSON envelope does.
+	if len(data) > 0 && data[0] == 0x30 {
+		return verifySignedData(keys, data, *signer)
+	}
+	return verifyEnvelope(keys, data, *signer)
+}
+
+// verifySignedData verifies a response wrapped in CMS SignedData, signed
+// by signer or else by the envelope's signer or a countersigner, and then
+// the envelope inside.
+func verifySignedData(keys *keyResolver, data []byte, signer string) error {
+	signedData, err := pqc.ParseSignedData(data)
+	if err != nil {
+		return fmt.Errorf("failed to parse SignedData: %w", err)
+	}
+
+	var envelope models.ServiceResponse
+	if err := json.Unmarshal(signedData.Content, &envelope); err != nil {
+		return fmt.Errorf("failed to parse the response envelope in SignedData: %w", err)
+	}
+	if signer == "" {
+		// SignedData names its signer by key only: look for it among the
+		// envelope's signer and the services that countersigned it, such as
+		// the gateway that wrapped it.
+		signers := []string{envelope.ServiceID}
+		for _, link := range envelope.Chain {
+			signers = append(signers, link.SignerID)
+		}
+		for _, candidate := range signers {
+			if publicKey, err := keys.PublicKey(candidate); err == nil && pqc.KeyFingerprint(publicKey) == signedData.KeyID {
+				signer = candidate
+				break
+			}
+		}
+		if signer == "" {
+			return fmt.Errorf("SignedData was signed with key %s, which is none of %s's: give its signer with --service-id",
+				signedData.KeyID, strings.Join(signers, ", "))
+		}
+	}
+
+	publicKey, err := keys.PublicKey(signer)
+	if err != nil {
+		return err
+	}
+	if err := signedData.Verify(publicKey); err != nil {
+		return fmt.Errorf("SignedData from %s does not verify against its key %s from the %s: %w",
+			signer, pqc.KeyFingerprint(publicKey), keys.origin(signer), err)
+	}
+
+	fmt.Printf("✅ SignedData verified\n")
+	fmt.Printf("   Signer:      %s\n", signer)
+	fmt.Printf("   Fingerprint: %s\n", signedData.KeyID)
+	fmt.Printf("   Algorithm:   %s\n", signedData.Algorithm)
+	fmt.Printf("   Signed at:   %s\n", signedData.SigningTime)
+	return verifyEnvelope(keys, signedData.Content, "")
+}
+
+func verifyEnvelope(keys *keyResolver, data []byte, signer string) error {
+	var envelope models.ServiceResponse
+	if err := json.Unmarshal(data, &envelope); err != nil {
+		return fmt.Errorf("failed to parse response envelope: %w", err)
+	}
+
+	if signer != "" && envelope.ServiceID != signer {
+		return fmt.Errorf("signed by %s, expected %s", envelope.ServiceID, signer)
+	}
+
+	publicKey, err := keys.PublicKey(envelope.ServiceID)
+	if err != nil {
+		return err
+	}
+
+	payload, err := envelope.SigningPayload()
+	if err != nil {
+		return fmt.Errorf("failed to reconstruct signing payload: %w", err)
+	}
+
+	if err := pqc.VerifySignature(envelope.SignatureAlg, publicKey, payload, envelope.Signature); err != nil {
+		return fmt.Errorf("signature from %s does not verify against its key %s from the %s: %w",
+			envelope.ServiceID, pqc.KeyFingerprint(publicKey), keys.origin(envelope.ServiceID), err)
+	}
+
+	chainPayload, err := envelope.ChainPayload()
+	if err != nil {
+		return fmt.Errorf("failed to reconstruct chain payload: %w", err)
+	}
+
+	if err := pqc.VerifySignatureChain(envelope.Chain, chainPayload, keys.PublicKey); err != nil {
+		return fmt.Errorf("signature chain does not verify: %w", err)
+	}
+
+	fmt.Printf("✅ Signature verified\n")
+	fmt.Printf("   Signer:      %s\n", envelope.ServiceID)
+	fmt.Printf("   Fingerprint: %s\n", pqc.KeyFingerprint(publicKey))
+	fmt.Printf("   Key source:  %s\n", keys.origin(envelope.ServiceID))
+	fmt.Printf("   Request ID:  %s\n", envelope.RequestID)
+	fmt.Printf("   Timestamp:   %s\n", envelope.Timestamp)
+	for _, link := range envelope.Chain {
+		fmt.Printf("   ↳ Countersigned by %s\n", link.SignerID)
+	}
+	return nil
+}
+
+func verifyDetached(keys *keyResolver, body []byte, sigFile, signer string) error {
+	token, err := os.ReadFile(sigFile)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", sigFile, err)
+	}
+
+	// A detached JWS names its key by fingerprint only, so the signer has
+	// to be given.
+	if signer == "" {
+		return fmt.Errorf("--service-id is required to verify a detached signature")
+	}
+
+	publicKey, err := keys.PublicKey(signer)
+	if err != nil {
+		return err
+	}
+	fingerprint := pqc.KeyFingerprint(publicKey)
+
+	header, err := pqc.VerifyDetachedJWS(publicKey, string(bytes.TrimSpace(token)), body)
+	if err != nil {
+		// Say whether the key or the content is what differs.
+		if parsed, _, parseErr := pqc.ParseDetachedJWS(string(bytes.TrimSpace(token))); parseErr == nil && parsed.Kid != fingerprint {
+			return fmt.Errorf("signed with key %s, but %s's key from the %s is %s: the key was rotated or another service signed this",
+				parsed.Kid, signer, keys.origin(signer), fingerprint)
+		}
+		return fmt.Errorf("signature from %s does not verify against its key %s; the file differs from what was signed: %w",
+			signer, fingerprint, err)
+	}
+
+	fmt.Printf("✅ Signature verified\n")
+	fmt.Printf("   Signer:      %s\n", signer)
+	fmt.Printf("   Fingerprint: %s\n", fingerprint)
+	fmt.Printf("   Key source:  %s\n", keys.origin(signer))
+	fmt.Printf("   Key ID:      %s\n", header.Kid)
+	fmt.Printf("   Issued at:   %s\n", header.IssuedAt())
+	return nil
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"quantum-safe-mesh/pkg/mesh"
+	"quantum-safe-mesh/pkg/models"
+)
+
+// runSession brokers a session with a peer through the auth service, or,
+// with --open, opens a ticket another service brokered with this one.
+func runSession(args []string) error {
+	flags, authURL := newFlagSet("session")
+	serviceID := flags.String("service-id", "", "identity to broker or open the session as")
+	peer := flags.String("peer", "", "service to broker a session with")
+	out := flags.String("out", "session-ticket.json", "file to write the ticket to, for the peer to open")
+	open := flags.String("open", "", "ticket file to open as the peer, instead of brokering a session")
+	flags.Parse(args)
+
+	if err := requireFlag("service-id", *serviceID); err != nil {
+		return err
+	}
+	identity, registry, err := loadIdentity(*serviceID, *authURL)
+	if err != nil {
+		return err
+	}
+
+	if *open != "" {
+		data, err := os.ReadFile(*open)
+		if err != nil {
+			return fmt.Errorf("failed to read session ticket: %w", err)
+		}
+		var ticket models.SessionTicket
+		if err := json.Unmarshal(data, &ticket); err != nil {
+			return fmt.Errorf("failed to decode session ticket %s: %w", *open, err)
+		}
+		issuerKey, err := registry.PublicKey(ticket.Issuer)
+		if err != nil {
+			return fmt.Errorf("failed to get public key of ticket issuer %s: %w", ticket.Issuer, err)
+		}
+		session, err := identity.OpenSessionTicket(&ticket, issuerKey, time.Now())
+		if err != nil {
+			return err
+		}
+		printSession(session)
+		return nil
+	}
+
+	if err := requireFlag("peer", *peer); err != nil {
+		return err
+	}
+	session, ticket, err := registry.BrokerSession(*peer)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(ticket, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode session ticket: %w", err)
+	}
+	if err := os.WriteFile(*out, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write session ticket: %w", err)
+	}
+	printSession(session)
+	fmt.Printf("   Ticket:      %s\n", *out)
+	return nil
+}
+
+// printSession describes session without printing its key: the key check
+// value lets both parties confirm they hold the same one.
+func printSession(session *mesh.Session) {
+	check := sha256.Sum256(session.Key)
+	fmt.Printf("🎫 Session %s with %s\n", session.ID, session.Peer)
+	fmt.Printf("   Key check:   %s\n", hex.EncodeToString(check[:8]))
+	fmt.Printf("   Valid until: %s\n", session.ExpiresAt.Local().Format(time.RFC1123))
+}
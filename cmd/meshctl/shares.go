@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"quantum-safe-mesh/pkg/mesh"
+	"quantum-safe-mesh/pkg/pqc"
+)
+
+func runSplitKey(args []string) error {
+	flags, _ := newFlagSet("split-key")
+	serviceID := flags.String("service-id", "", "service whose signing key to split")
+	threshold := flags.Int("threshold", 3, "number of shares needed to rebuild the key")
+	total := flags.Int("shares", 5, "number of shares to write, one per custodian")
+	out := flags.String("out", "", "directory to write the shares to (default: the key store)")
+	removeKey := flags.Bool("remove-private-key", false, "delete the private signing key file once the shares are written")
+	force := flags.Bool("force", false, "overwrite existing share files")
+	flags.Parse(args)
+
+	if err := requireFlag("service-id", *serviceID); err != nil {
+		return err
+	}
+	if *out == "" {
+		*out = pqc.KeysDir
+	}
+
+	identity, err := mesh.LoadIdentity(*serviceID)
+	if err != nil {
+		return fmt.Errorf("failed to load keys for %s: %w", *serviceID, err)
+	}
+	shares, err := pqc.SplitKey(*serviceID, identity.Signer, *threshold, *total)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(*out, 0700); err != nil {
+		return fmt.Errorf("failed to create %s: %w", *out, err)
+	}
+	mode := os.O_WRONLY | os.O_CREATE | os.O_EXCL
+	if *force {
+		mode = os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	}
+	fmt.Printf("🧩 Split the %s key %s of %s into %d shares, any %d of which rebuild it:\n",
+		identity.Signer.Algorithm(), identity.KeyID(), *serviceID, *total, *threshold)
+	for _, share := range shares {
+		path := filepath.Join(*out, pqc.KeyShareFileName(*serviceID, share.Index))
+		file, err := os.OpenFile(path, mode, 0600)
+		if errors.Is(err, fs.ErrExist) {
+			return fmt.Errorf("%s already exists; use --force to overwrite it", path)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to write key share: %w", err)
+		}
+		_, err = file.Write(share.Encode())
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return fmt.Errorf("failed to write key share: %w", err)
+		}
+		fmt.Printf("   %s\n", path)
+	}
+
+	if *removeKey {
+		_, signerPriv := pqc.SignatureKeyFileNames(*serviceID, identity.Signer.Algorithm())
+		if err := os.Remove(filepath.Join(pqc.KeysDir, signerPriv)); err != nil {
+			return fmt.Errorf("failed to remove private key: %w", err)
+		}
+		fmt.Printf("🗑️  Removed %s; start %s with KEYS_SHARES naming %d of the shares.\n", signerPriv, *serviceID, *threshold)
+	}
+	fmt.Println("⚠️  Hand each share to a different custodian and delete it here once they have it.")
+	return nil
+}
+
+func runCombineKey(args []string) error {
+	flags, _ := newFlagSet("combine-key")
+	serviceID := flags.String("service-id", "", "service whose signing key to rebuild")
+	restore := flags.Bool("restore", false, "write the rebuilt private key back to the key store")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: meshctl combine-key --service-id id [--restore] [share files...]")
+		fmt.Fprintln(flags.Output(), "With no share files, custodians paste their shares one at a time.")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if err := requireFlag("service-id", *serviceID); err != nil {
+		return err
+	}
+
+	var shares []*pqc.KeyShare
+	var err error
+	if flags.NArg() > 0 {
+		shares, err = pqc.ReadKeyShares(flags.Args())
+	} else {
+		shares, err = collectShares(*serviceID)
+	}
+	if err != nil {
+		return err
+	}
+
+	signer, _, err := pqc.LoadSplitKeyPair(*serviceID, shares)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("✅ Shares rebuild the %s key %s of %s\n", signer.Algorithm(), pqc.KeyFingerprint(signer.GetPublicKeyBytes()), *serviceID)
+
+	if !*restore {
+		fmt.Println("   Nothing written; pass --restore to put the private key back in the key store.")
+		return nil
+	}
+	if err := pqc.SavePrivateKey(*serviceID, signer); err != nil {
+		return err
+	}
+	_, signerPriv := pqc.SignatureKeyFileNames(*serviceID, signer.Algorithm())
+	fmt.Printf("🔑 Restored %s\n", filepath.Join(pqc.KeysDir, signerPriv))
+	return nil
+}
+
+// collectShares runs a recovery ceremony on the terminal: custodians paste
+// their shares in turn until enough are in to rebuild the key.
+func collectShares(serviceID string) ([]*pqc.KeyShare, error) {
+	scanner := bufio.NewScanner(os.Stdin)
+	var shares []*pqc.KeyShare
+	for custodian := 1; len(shares) == 0 || len(shares) < shares[0].Threshold; custodian++ {
+		fmt.Printf("🧩 Custodian %d: paste your share of the %s key, then press Enter\n", custodian, serviceID)
+
+		var block strings.Builder
+		for scanner.Scan() {
+			line := scanner.Text()
+			block.WriteString(line + "\n")
+			if strings.HasPrefix(line, "-----END ") {
+				break
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, fmt.Errorf("failed to read key share: %w", err)
+		}
+		if block.Len() == 0 {
+			return nil, fmt.Errorf("input ended after %d shares", len(shares))
+		}
+
+		share, err := pqc.ParseKeyShare([]byte(block.String()))
+		if err != nil {
+			fmt.Printf("   ❌ %v; try again\n", err)
+			custodian--
+			continue
+		}
+		shares = append(shares, share)
+		fmt.Printf("   ✅ Share %d of split %s accepted (%d of %d needed)\n", share.Index, share.SplitID, len(shares), share.Threshold)
+	}
+	return shares, nil
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+
+	"quantum-safe-mesh/pkg/mesh"
+	"quantum-safe-mesh/pkg/pqc"
+)
+
+// Where verify looks up signers' public keys.
+const (
+	keySourceAuto  = "auto"
+	keySourceLocal = "local"
+	keySourceAuth  = "auth"
+)
+
+func runSign(args []string) error {
+	flags, _ := newFlagSet("sign")
+	serviceID := flags.String("key", "", "service whose local keys sign the file")
+	out := flags.String("out", "", "where to write the detached JWS, - for stdout (default: <file>.sig)")
+	flags.Parse(args)
+
+	if err := requireFlag("key", *serviceID); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return fmt.Errorf("usage: meshctl sign --key service-id [--out file.sig] file (flags before the file)")
+	}
+	file := flags.Arg(0)
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", file, err)
+	}
+
+	identity, err := mesh.LoadIdentity(*serviceID)
+	if errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("no keys for %s in %s (run 'meshctl keygen' first?): %w", *serviceID, pqc.KeysDir, err)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to load keys for %s: %w", *serviceID, err)
+	}
+
+	token, err := pqc.SignDetached(identity.Signer, identity.KeyID(), data)
+	if err != nil {
+		return err
+	}
+
+	if *out == "-" {
+		fmt.Println(token)
+		return nil
+	}
+	if *out == "" {
+		*out = file + ".sig"
+	}
+	if err := os.WriteFile(*out, []byte(token+"\n"), 0644); err != nil {
+		return fmt.Errorf("failed to write signature: %w", err)
+	}
+
+	fmt.Printf("🖊️  Signed %s as %s\n", file, *serviceID)
+	fmt.Printf("   Fingerprint: %s\n", identity.KeyID())
+	fmt.Printf("   Signature:   %s\n", *out)
+	fmt.Printf("   Verify with: meshctl verify --service-id %s --sig %s %s\n", *serviceID, *out, file)
+	return nil
+}
+
+// keyResolver looks up signers' public keys in the local key store, the
+// auth service, or the local store first and then the auth service. It
+// remembers where each key came from so verify can report it.
+type keyResolver struct {
+	source   string
+	registry *mesh.RegistryClient
+	origins  map[string]string
+}
+
+func newKeyResolver(source, authURL string) (*keyResolver, error) {
+	switch source {
+	case keySourceAuto, keySourceLocal, keySourceAuth:
+	default:
+		return nil, fmt.Errorf("invalid --key-source %q: expected %s, %s or %s", source, keySourceAuto, keySourceLocal, keySourceAuth)
+	}
+	return &keyResolver{source: source, registry: newLookupRegistry(authURL), origins: make(map[string]string)}, nil
+}
+
+// PublicKey has the signature of pqc.PublicKeyLookup.
+func (r *keyResolver) PublicKey(serviceID string) ([]byte, error) {
+	if r.source != keySourceAuth {
+		publicKey, err := pqc.LoadPublicKey(serviceID)
+		if err == nil {
+			r.origins[serviceID] = "local key store (" + pqc.KeysDir + ")"
+			return publicKey, nil
+		}
+		if r.source == keySourceLocal || !errors.Is(err, fs.ErrNotExist) {
+			return nil, fmt.Errorf("failed to load %s's public key locally: %w", serviceID, err)
+		}
+	}
+
+	publicKey, err := r.registry.PublicKey(serviceID)
+	if err != nil {
+		return nil, err
+	}
+	r.origins[serviceID] = "auth service"
+	return publicKey, nil
+}
+
+// origin says where serviceID's key was found.
+func (r *keyResolver) origin(serviceID string) string {
+	return r.origins[serviceID]
+}
@@ -0,0 +1,349 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"quantum-safe-mesh/pkg/mesh"
+	"quantum-safe-mesh/pkg/pqc"
+)
+
+func runTop(args []string) error {
+	flags, authURL := newFlagSet("top")
+	serviceID := flags.String("service-id", "", "identity to query the registry as")
+	targets := flags.String("targets", getEnvOrDefault("MESH_TOP_TARGETS", ""), "comma-separated base URLs to scrape besides the auth service and advertised addresses (env MESH_TOP_TARGETS)")
+	interval := flags.Duration("interval", 2*time.Second, "refresh interval")
+	events := flags.Int("events", 10, "number of recent audit events to show")
+	once := flags.Bool("once", false, "print one snapshot and exit instead of refreshing")
+	flags.Parse(args)
+
+	if err := requireFlag("service-id", *serviceID); err != nil {
+		return err
+	}
+	if *interval <= 0 {
+		return fmt.Errorf("--interval must be positive")
+	}
+
+	_, registry, err := loadIdentity(*serviceID, *authURL)
+	if err != nil {
+		return err
+	}
+
+	dashboard := &dashboard{
+		registry: registry,
+		authURL:  strings.TrimSuffix(*authURL, "/"),
+		client:   &http.Client{Timeout: 2 * time.Second},
+		previous: make(map[string]serviceCounts),
+		events:   *events,
+	}
+	for _, target := range strings.Split(*targets, ",") {
+		if target = strings.TrimSpace(target); target != "" {
+			dashboard.targets = append(dashboard.targets, strings.TrimSuffix(target, "/"))
+		}
+	}
+
+	for {
+		snapshot := dashboard.collect()
+		if !*once {
+			// Clear the screen and redraw from the top left.
+			fmt.Print("\033[H\033[2J")
+		}
+		dashboard.render(os.Stdout, snapshot, *interval, *once)
+		if *once {
+			return nil
+		}
+		time.Sleep(*interval)
+	}
+}
+
+// serviceCounts are one service's counters at one scrape.
+type serviceCounts struct {
+	requests float64
+	failures float64
+	at       time.Time
+}
+
+// serviceRow is one line of the dashboard.
+type serviceRow struct {
+	serviceID    string
+	fingerprint  string
+	address      string
+	registered   bool
+	registeredAt time.Time
+	scraped      bool
+	started      time.Time
+	counts       serviceCounts
+	rate         float64 // requests per second since the last scrape; -1 if unknown
+}
+
+type snapshot struct {
+	taken    time.Time
+	rows     []*serviceRow
+	events   []mesh.AuditEvent
+	problems []string
+}
+
+// dashboard polls the registry for services and keys, and each service's
+// /metrics and /audit endpoints for traffic and security events.
+type dashboard struct {
+	registry *mesh.RegistryClient
+	authURL  string
+	targets  []string // scraped in addition to the auth service and advertised addresses
+	client   *http.Client
+	previous map[string]serviceCounts
+	events   int
+}
+
+func (d *dashboard) collect() *snapshot {
+	snap := &snapshot{taken: time.Now()}
+	rows := make(map[string]*serviceRow)
+	row := func(serviceID string) *serviceRow {
+		if rows[serviceID] == nil {
+			rows[serviceID] = &serviceRow{serviceID: serviceID, rate: -1}
+		}
+		return rows[serviceID]
+	}
+
+	targets := []string{d.authURL}
+	services, err := d.registry.Services()
+	if err != nil {
+		snap.problems = append(snap.problems, fmt.Sprintf("registry: %v", err))
+	}
+	for _, serviceID := range services {
+		r := row(serviceID)
+		r.registered = true
+		registration, err := d.registry.Registration(serviceID)
+		if err != nil {
+			snap.problems = append(snap.problems, fmt.Sprintf("%s: %v", serviceID, err))
+			continue
+		}
+		r.fingerprint = pqc.KeyFingerprint(registration.PublicKey)
+		r.registeredAt = registration.RegisteredAt
+		r.address = strings.TrimSuffix(registration.Address, "/")
+		if r.address != "" {
+			targets = append(targets, r.address)
+		}
+	}
+	targets = append(targets, d.targets...)
+
+	scraped := make(map[string]bool)
+	for _, target := range targets {
+		if scraped[target] {
+			continue
+		}
+		scraped[target] = true
+
+		samples, err := d.scrapeMetrics(target)
+		if err != nil {
+			snap.problems = append(snap.problems, fmt.Sprintf("%s: %v", target, err))
+			continue
+		}
+		for _, sample := range samples {
+			r := row(sample.labels["service"])
+			r.scraped = true
+			switch sample.name {
+			case mesh.MetricStartTime:
+				r.started = time.Unix(int64(sample.value), 0)
+			case mesh.MetricRequests:
+				r.counts.requests += sample.value
+			case mesh.MetricVerificationFailures:
+				r.counts.failures += sample.value
+			}
+		}
+
+		events, err := d.scrapeAudit(target)
+		if err != nil {
+			snap.problems = append(snap.problems, fmt.Sprintf("%s: %v", target, err))
+			continue
+		}
+		snap.events = append(snap.events, events...)
+	}
+
+	for _, r := range rows {
+		if !r.scraped {
+			continue
+		}
+		r.counts.at = snap.taken
+		// A counter that went down means the service restarted.
+		if previous, exists := d.previous[r.serviceID]; exists && r.counts.requests >= previous.requests {
+			r.rate = (r.counts.requests - previous.requests) / r.counts.at.Sub(previous.at).Seconds()
+		}
+		d.previous[r.serviceID] = r.counts
+		snap.rows = append(snap.rows, r)
+	}
+	for _, r := range rows {
+		if !r.scraped {
+			snap.rows = append(snap.rows, r)
+		}
+	}
+	sort.Slice(snap.rows, func(i, j int) bool { return snap.rows[i].serviceID < snap.rows[j].serviceID })
+
+	sort.SliceStable(snap.events, func(i, j int) bool { return snap.events[i].Time.Before(snap.events[j].Time) })
+	if len(snap.events) > d.events {
+		snap.events = snap.events[len(snap.events)-d.events:]
+	}
+	return snap
+}
+
+func (d *dashboard) render(w io.Writer, snap *snapshot, interval time.Duration, once bool) {
+	refresh := fmt.Sprintf("every %s, Ctrl-C to quit", interval)
+	if once {
+		refresh = "snapshot"
+	}
+	fmt.Fprintf(w, "🛰️  Mesh status from %s at %s (%s)\n\n", d.authURL, snap.taken.Format("15:04:05"), refresh)
+
+	fmt.Fprintf(w, "%-22s %-32s %8s %8s %8s %10s %8s\n", "SERVICE", "KEY", "KEY AGE", "UPTIME", "REQ/S", "REQUESTS", "FAILURES")
+	for _, r := range snap.rows {
+		fingerprint := r.fingerprint
+		if !r.registered {
+			fingerprint = "(not registered)"
+		}
+		keyAge, uptime, rate, requests, failures := "-", "-", "-", "-", "-"
+		if !r.registeredAt.IsZero() {
+			keyAge = shortDuration(snap.taken.Sub(r.registeredAt))
+		}
+		if r.scraped {
+			uptime = shortDuration(snap.taken.Sub(r.started))
+			requests = strconv.FormatFloat(r.counts.requests, 'f', 0, 64)
+			failures = strconv.FormatFloat(r.counts.failures, 'f', 0, 64)
+		}
+		if r.rate >= 0 {
+			rate = strconv.FormatFloat(r.rate, 'f', 1, 64)
+		}
+		fmt.Fprintf(w, "%-22s %-32s %8s %8s %8s %10s %8s\n", r.serviceID, fingerprint, keyAge, uptime, rate, requests, failures)
+	}
+
+	fmt.Fprintf(w, "\n📜 Recent audit events\n")
+	if len(snap.events) == 0 {
+		fmt.Fprintf(w, "   (none)\n")
+	}
+	for _, event := range snap.events {
+		line := fmt.Sprintf("   %s %-16s %-21s %s", event.Time.Local().Format("15:04:05"), event.Service, event.Type, event.Subject)
+		if event.Actor != "" && event.Actor != event.Subject {
+			line += " by " + event.Actor
+		}
+		if event.KeyID != "" {
+			line += " key " + event.KeyID
+		}
+		if event.Detail != "" {
+			line += " (" + event.Detail + ")"
+		}
+		fmt.Fprintln(w, line)
+	}
+
+	for _, problem := range snap.problems {
+		fmt.Fprintf(w, "⚠️  %s\n", problem)
+	}
+}
+
+// shortDuration formats d to the largest whole unit, e.g. 3d, 5h or 42s.
+func shortDuration(d time.Duration) string {
+	switch {
+	case d >= 24*time.Hour:
+		return fmt.Sprintf("%dd", int(d.Hours()/24))
+	case d >= time.Hour:
+		return fmt.Sprintf("%dh", int(d.Hours()))
+	case d >= time.Minute:
+		return fmt.Sprintf("%dm", int(d.Minutes()))
+	}
+	return fmt.Sprintf("%ds", int(d.Seconds()))
+}
+
+func (d *dashboard) get(url string) ([]byte, error) {
+	resp, err := d.client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s: status %d", url, resp.StatusCode)
+	}
+	return body, nil
+}
+
+func (d *dashboard) scrapeAudit(target string) ([]mesh.AuditEvent, error) {
+	body, err := d.get(fmt.Sprintf("%s/audit?limit=%d", target, d.events))
+	if err != nil {
+		return nil, err
+	}
+
+	var audit struct {
+		Events []mesh.AuditEvent `json:"events"`
+	}
+	if err := json.Unmarshal(body, &audit); err != nil {
+		return nil, fmt.Errorf("failed to decode audit events: %w", err)
+	}
+	return audit.Events, nil
+}
+
+// metricSample is one line of the Prometheus text format.
+type metricSample struct {
+	name   string
+	labels map[string]string
+	value  float64
+}
+
+func (d *dashboard) scrapeMetrics(target string) ([]metricSample, error) {
+	body, err := d.get(target + "/metrics")
+	if err != nil {
+		return nil, err
+	}
+	return parseMetrics(string(body))
+}
+
+// parseMetrics reads the Prometheus text format as mesh.Metrics writes it.
+func parseMetrics(text string) ([]metricSample, error) {
+	var samples []metricSample
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		sample := metricSample{labels: make(map[string]string)}
+		rest := line
+		if open := strings.IndexByte(line, '{'); open >= 0 {
+			sample.name = line[:open]
+			rest = line[open+1:]
+			for !strings.HasPrefix(rest, "}") {
+				name, value, found := strings.Cut(rest, "=")
+				if !found {
+					return nil, fmt.Errorf("malformed metric line %q", line)
+				}
+				quoted, err := strconv.QuotedPrefix(value)
+				if err != nil {
+					return nil, fmt.Errorf("malformed label in %q: %w", line, err)
+				}
+				sample.labels[strings.TrimSpace(name)], _ = strconv.Unquote(quoted)
+				rest = strings.TrimPrefix(value[len(quoted):], ",")
+			}
+			rest = rest[1:]
+		} else {
+			sample.name, rest, _ = strings.Cut(line, " ")
+		}
+
+		fields := strings.Fields(rest)
+		if len(fields) == 0 {
+			return nil, fmt.Errorf("metric line %q has no value", line)
+		}
+		value, err := strconv.ParseFloat(fields[0], 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value in %q: %w", line, err)
+		}
+		sample.value = value
+		samples = append(samples, sample)
+	}
+	return samples, nil
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"quantum-safe-mesh/pkg/mesh"
+	"quantum-safe-mesh/pkg/pqc"
+)
+
+// Annotations on a Deployment that opt it into the mesh.
+const (
+	annotationInject    = "mesh.quantum-safe.io/inject"     // "true" to inject the sidecar
+	annotationServiceID = "mesh.quantum-safe.io/service-id" // defaults to the Deployment name
+	annotationAppPort   = "mesh.quantum-safe.io/app-port"   // port the app listens on, default 8080
+)
+
+// Annotations the operator maintains.
+const (
+	annotationInjected    = "mesh.quantum-safe.io/injected"        // pod template: digest of the injected spec
+	annotationFingerprint = "mesh.quantum-safe.io/key-fingerprint" // pod template: rolls pods after a rotation
+	annotationExpiresAt   = "mesh.quantum-safe.io/expires-at"      // bootstrap Secret: token expiry
+)
+
+const (
+	sidecarName       = "mesh-sidecar"
+	sidecarPort       = 8083
+	keysVolume        = "mesh-keys"
+	keysMountPath     = "/var/run/mesh/keys"
+	defaultAppPort    = 8080
+	tokenSecretKey    = "token"
+	keysSecretSuffix  = "-mesh-keys"
+	tokenSecretSuffix = "-mesh-bootstrap"
+)
+
+func (op *Operator) reconcileDeployment(raw json.RawMessage) error {
+	var d deployment
+	if err := json.Unmarshal(raw, &d); err != nil {
+		return fmt.Errorf("failed to decode deployment: %w", err)
+	}
+
+	injected := d.Spec.Template.Metadata.Annotations[annotationInjected]
+	if d.Metadata.Annotations[annotationInject] != "true" {
+		if injected == "" {
+			return nil
+		}
+		return op.removeSidecar(&d)
+	}
+
+	serviceID := d.Metadata.Annotations[annotationServiceID]
+	if serviceID == "" {
+		serviceID = d.Metadata.Name
+	}
+
+	appPort := defaultAppPort
+	if value := d.Metadata.Annotations[annotationAppPort]; value != "" {
+		port, err := strconv.Atoi(value)
+		if err != nil || port < 1 || port > 65535 {
+			return fmt.Errorf("%s/%s: invalid %s %q", d.Metadata.Namespace, d.Metadata.Name, annotationAppPort, value)
+		}
+		appPort = port
+	}
+
+	if err := op.ensureKeys(d.Metadata.Namespace, serviceID); err != nil {
+		return err
+	}
+	if err := op.ensureBootstrapToken(d.Metadata.Namespace, serviceID); err != nil {
+		return err
+	}
+
+	container, volume := op.sidecarSpec(serviceID, appPort)
+	digest, err := specDigest(container, volume)
+	if err != nil {
+		return err
+	}
+	if injected == digest {
+		return nil
+	}
+
+	patch := map[string]interface{}{
+		"spec": map[string]interface{}{
+			"template": map[string]interface{}{
+				"metadata": map[string]interface{}{
+					"annotations": map[string]string{annotationInjected: digest},
+				},
+				"spec": map[string]interface{}{
+					"containers": []interface{}{container},
+					"volumes":    []interface{}{volume},
+				},
+			},
+		},
+	}
+	if err := op.kube.patch(deploymentPath(d.Metadata.Namespace, d.Metadata.Name), strategicMergePatch, patch); err != nil {
+		return fmt.Errorf("failed to inject sidecar into %s/%s: %w", d.Metadata.Namespace, d.Metadata.Name, err)
+	}
+
+	log.Printf("💉 Injected mesh sidecar into %s/%s as %s (app port %d)", d.Metadata.Namespace, d.Metadata.Name, serviceID, appPort)
+	return nil
+}
+
+// removeSidecar undoes the injection once a Deployment drops the inject
+// annotation. Its keys and token Secrets are kept so the identity survives
+// being re-enabled.
+func (op *Operator) removeSidecar(d *deployment) error {
+	patch := map[string]interface{}{
+		"spec": map[string]interface{}{
+			"template": map[string]interface{}{
+				"metadata": map[string]interface{}{
+					"annotations": map[string]interface{}{annotationInjected: nil},
+				},
+				"spec": map[string]interface{}{
+					"containers": []interface{}{map[string]string{"name": sidecarName, "$patch": "delete"}},
+					"volumes":    []interface{}{map[string]string{"name": keysVolume, "$patch": "delete"}},
+				},
+			},
+		},
+	}
+	if err := op.kube.patch(deploymentPath(d.Metadata.Namespace, d.Metadata.Name), strategicMergePatch, patch); err != nil {
+		return fmt.Errorf("failed to remove sidecar from %s/%s: %w", d.Metadata.Namespace, d.Metadata.Name, err)
+	}
+
+	log.Printf("🧹 Removed mesh sidecar from %s/%s", d.Metadata.Namespace, d.Metadata.Name)
+	return nil
+}
+
+// sidecarSpec returns the sidecar container and the volume holding its keys.
+func (op *Operator) sidecarSpec(serviceID string, appPort int) (map[string]interface{}, map[string]interface{}) {
+	container := map[string]interface{}{
+		"name":  sidecarName,
+		"image": op.cfg.Operator.SidecarImage,
+		"ports": []interface{}{
+			map[string]interface{}{"name": "mesh", "containerPort": sidecarPort, "protocol": "TCP"},
+		},
+		"env": []interface{}{
+			map[string]interface{}{"name": "SERVICE_ID", "value": serviceID},
+			map[string]interface{}{"name": "APP_URL", "value": fmt.Sprintf("http://localhost:%d", appPort)},
+			map[string]interface{}{"name": "LISTEN_ADDR", "value": fmt.Sprintf(":%d", sidecarPort)},
+			map[string]interface{}{"name": "AUTH_SERVICE_URL", "value": op.cfg.Auth.URL},
+			map[string]interface{}{"name": "KEYS_DIR", "value": keysMountPath},
+			map[string]interface{}{"name": "MESH_BOOTSTRAP_TOKEN", "valueFrom": map[string]interface{}{
+				"secretKeyRef": map[string]interface{}{"name": serviceID + tokenSecretSuffix, "key": tokenSecretKey},
+			}},
+		},
+		"volumeMounts": []interface{}{
+			map[string]interface{}{"name": keysVolume, "mountPath": keysMountPath, "readOnly": true},
+		},
+		"readinessProbe": map[string]interface{}{
+			"httpGet": map[string]interface{}{"path": "/readyz", "port": sidecarPort},
+		},
+	}
+
+	volume := map[string]interface{}{
+		"name":   keysVolume,
+		"secret": map[string]interface{}{"secretName": serviceID + keysSecretSuffix},
+	}
+	return container, volume
+}
+
+func specDigest(container, volume map[string]interface{}) (string, error) {
+	data, err := json.Marshal([]interface{}{container, volume})
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal sidecar spec: %w", err)
+	}
+	digest := sha256.Sum256(data)
+	return hex.EncodeToString(digest[:8]), nil
+}
+
+// ensureKeys creates serviceID's key Secret on first sight. The keys never
+// change afterwards except through a MeshKeyRotation.
+func (op *Operator) ensureKeys(namespace, serviceID string) error {
+	name := serviceID + keysSecretSuffix
+
+	var existing secret
+	err := op.kube.get(secretPath(namespace, name), &existing)
+	if err == nil {
+		return nil
+	}
+	if !isNotFound(err) {
+		return fmt.Errorf("failed to get %s/%s: %w", namespace, name, err)
+	}
+
+	dilithiumKeyPair, err := pqc.GenerateDilithiumKeyPair()
+	if err != nil {
+		return fmt.Errorf("failed to generate Dilithium keypair: %w", err)
+	}
+	kyberKeyPair, err := pqc.GenerateKyberKeyPair()
+	if err != nil {
+		return fmt.Errorf("failed to generate Kyber keypair: %w", err)
+	}
+
+	dilithiumPub, dilithiumPriv, kyberPub, kyberPriv := pqc.KeyFileNames(serviceID)
+	keys := newSecret(namespace, name, map[string][]byte{
+		dilithiumPub:  dilithiumKeyPair.GetPublicKeyBytes(),
+		dilithiumPriv: dilithiumKeyPair.GetPrivateKeyBytes(),
+		kyberPub:      kyberKeyPair.GetPublicKeyBytes(),
+		kyberPriv:     kyberKeyPair.GetPrivateKeyBytes(),
+	})
+	if err := op.kube.create(namespacedPath("/api/v1", namespace, "secrets"), keys); err != nil {
+		return fmt.Errorf("failed to create %s/%s: %w", namespace, name, err)
+	}
+
+	log.Printf("🔑 Generated keys for %s in secret %s/%s (fingerprint %s)",
+		serviceID, namespace, name, pqc.KeyFingerprint(dilithiumKeyPair.GetPublicKeyBytes()))
+	return nil
+}
+
+// ensureBootstrapToken keeps a valid bootstrap token for serviceID in a
+// Secret, reissuing it once half its lifetime has passed.
+func (op *Operator) ensureBootstrapToken(namespace, serviceID string) error {
+	name := serviceID + tokenSecretSuffix
+	ttl := op.cfg.Operator.TokenTTL
+
+	var existing secret
+	err := op.kube.get(secretPath(namespace, name), &existing)
+	if err != nil && !isNotFound(err) {
+		return fmt.Errorf("failed to get %s/%s: %w", namespace, name, err)
+	}
+	exists := err == nil
+
+	if exists {
+		expiresAt, err := time.Parse(time.RFC3339, existing.Metadata.Annotations[annotationExpiresAt])
+		if err == nil && time.Until(expiresAt) > ttl/2 {
+			return nil
+		}
+	}
+
+	token, err := op.identity.IssueBootstrapToken(serviceID, ttl)
+	if err != nil {
+		return fmt.Errorf("failed to issue bootstrap token: %w", err)
+	}
+
+	tokenSecret := newSecret(namespace, name, map[string][]byte{tokenSecretKey: []byte(token)})
+	tokenSecret.Metadata.Annotations = map[string]string{
+		annotationExpiresAt: time.Now().Add(ttl).UTC().Format(time.RFC3339),
+	}
+
+	if exists {
+		tokenSecret.Metadata.ResourceVersion = existing.Metadata.ResourceVersion
+		err = op.kube.update(secretPath(namespace, name), tokenSecret)
+	} else {
+		err = op.kube.create(namespacedPath("/api/v1", namespace, "secrets"), tokenSecret)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to store bootstrap token in %s/%s: %w", namespace, name, err)
+	}
+
+	log.Printf("🎟️  Issued bootstrap token for %s in secret %s/%s (valid %v)", serviceID, namespace, name, ttl)
+	return nil
+}
+
+// loadIdentity reads serviceID's keys back from its key Secret.
+func (op *Operator) loadIdentity(namespace, serviceID string) (*mesh.Identity, *secret, error) {
+	var keys secret
+	if err := op.kube.get(secretPath(namespace, serviceID+keysSecretSuffix), &keys); err != nil {
+		return nil, nil, fmt.Errorf("failed to get keys for %s: %w", serviceID, err)
+	}
+
+	dilithiumPub, dilithiumPriv, kyberPub, kyberPriv := pqc.KeyFileNames(serviceID)
+	dilithiumKeyPair, err := pqc.LoadDilithiumKeyPair(keys.Data[dilithiumPub], keys.Data[dilithiumPriv])
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load Dilithium keypair: %w", err)
+	}
+	kyberKeyPair, err := pqc.LoadKyberKeyPair(keys.Data[kyberPub], keys.Data[kyberPriv])
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load Kyber keypair: %w", err)
+	}
+
+	return &mesh.Identity{ServiceID: serviceID, Signer: dilithiumKeyPair, Kyber: kyberKeyPair}, &keys, nil
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+// Patch content types accepted by the Kubernetes API.
+const (
+	strategicMergePatch = "application/strategic-merge-patch+json"
+	mergePatch          = "application/merge-patch+json"
+)
+
+// kubeClient is a minimal Kubernetes API client covering the handful of
+// calls the operator makes.
+type kubeClient struct {
+	baseURL   string
+	tokenPath string
+	client    *http.Client
+}
+
+// newKubeClient talks to apiURL without credentials, which suits a kubectl
+// proxy, or uses the pod's service account when apiURL is empty.
+func newKubeClient(apiURL string) (*kubeClient, error) {
+	if apiURL != "" {
+		return &kubeClient{baseURL: strings.TrimSuffix(apiURL, "/"), client: &http.Client{}}, nil
+	}
+
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, fmt.Errorf("not running in a cluster; set KUBE_API_URL, e.g. to a kubectl proxy")
+	}
+
+	caCert, err := os.ReadFile(filepath.Join(serviceAccountDir, "ca.crt"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cluster CA: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caCert) {
+		return nil, fmt.Errorf("failed to parse cluster CA")
+	}
+
+	return &kubeClient{
+		baseURL:   "https://" + net.JoinHostPort(host, port),
+		tokenPath: filepath.Join(serviceAccountDir, "token"),
+		client: &http.Client{Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12},
+		}},
+	}, nil
+}
+
+// apiError is a non-2xx answer from the API server.
+type apiError struct {
+	Status  int
+	Message string
+}
+
+func (e *apiError) Error() string {
+	return fmt.Sprintf("kubernetes API returned status %d: %s", e.Status, e.Message)
+}
+
+func isNotFound(err error) bool {
+	var apiErr *apiError
+	return errors.As(err, &apiErr) && apiErr.Status == http.StatusNotFound
+}
+
+func (k *kubeClient) newRequest(ctx context.Context, method, path, contentType string, body interface{}) (*http.Request, error) {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal request: %w", err)
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, k.baseURL+path, reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Accept", "application/json")
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	// Projected service account tokens are refreshed on disk, so read the
+	// current one for every request.
+	if k.tokenPath != "" {
+		token, err := os.ReadFile(k.tokenPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read service account token: %w", err)
+		}
+		req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	}
+	return req, nil
+}
+
+func (k *kubeClient) do(method, path, contentType string, body, out interface{}) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	req, err := k.newRequest(ctx, method, path, contentType, body)
+	if err != nil {
+		return err
+	}
+
+	resp, err := k.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to call kubernetes API: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		var status struct {
+			Message string `json:"message"`
+		}
+		json.NewDecoder(resp.Body).Decode(&status)
+		return &apiError{Status: resp.StatusCode, Message: status.Message}
+	}
+
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode kubernetes API response: %w", err)
+	}
+	return nil
+}
+
+func (k *kubeClient) get(path string, out interface{}) error {
+	return k.do(http.MethodGet, path, "", nil, out)
+}
+
+func (k *kubeClient) create(path string, obj interface{}) error {
+	return k.do(http.MethodPost, path, "application/json", obj, nil)
+}
+
+func (k *kubeClient) update(path string, obj interface{}) error {
+	return k.do(http.MethodPut, path, "application/json", obj, nil)
+}
+
+func (k *kubeClient) patch(path, patchType string, patch interface{}) error {
+	return k.do(http.MethodPatch, path, patchType, patch, nil)
+}
+
+// watchEvent is one line of a watch stream.
+type watchEvent struct {
+	Type   string          `json:"type"`
+	Object json.RawMessage `json:"object"`
+}
+
+// watch streams changes to the collection at path from resourceVersion
+// until the server ends the watch after timeout, calling handle for each
+// event. It returns an error when the watch has to be restarted from a
+// fresh list, e.g. because resourceVersion is too old.
+func (k *kubeClient) watch(path, resourceVersion string, timeout time.Duration, handle func(watchEvent)) error {
+	query := url.Values{
+		"watch":           {"true"},
+		"resourceVersion": {resourceVersion},
+		"timeoutSeconds":  {fmt.Sprint(int(timeout.Seconds()))},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout+30*time.Second)
+	defer cancel()
+
+	req, err := k.newRequest(ctx, http.MethodGet, path+"?"+query.Encode(), "", nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := k.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to start watch: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return &apiError{Status: resp.StatusCode, Message: "watch failed"}
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var event watchEvent
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			return fmt.Errorf("failed to decode watch event: %w", err)
+		}
+		if event.Type == "ERROR" {
+			return fmt.Errorf("watch error: %s", event.Object)
+		}
+		handle(event)
+	}
+
+	if err := scanner.Err(); err != nil && !errors.Is(err, context.DeadlineExceeded) {
+		return fmt.Errorf("watch interrupted: %w", err)
+	}
+	return nil
+}
+
+// objectMeta holds the metadata fields the operator reads or writes.
+type objectMeta struct {
+	Name            string            `json:"name"`
+	Namespace       string            `json:"namespace,omitempty"`
+	Labels          map[string]string `json:"labels,omitempty"`
+	Annotations     map[string]string `json:"annotations,omitempty"`
+	ResourceVersion string            `json:"resourceVersion,omitempty"`
+}
+
+type objectList struct {
+	Metadata struct {
+		ResourceVersion string `json:"resourceVersion"`
+	} `json:"metadata"`
+	Items []json.RawMessage `json:"items"`
+}
+
+type deployment struct {
+	Metadata objectMeta `json:"metadata"`
+	Spec     struct {
+		Template struct {
+			Metadata objectMeta `json:"metadata"`
+		} `json:"template"`
+	} `json:"spec"`
+}
+
+type secret struct {
+	APIVersion string            `json:"apiVersion"`
+	Kind       string            `json:"kind"`
+	Metadata   objectMeta        `json:"metadata"`
+	Type       string            `json:"type,omitempty"`
+	Data       map[string][]byte `json:"data"`
+}
+
+func newSecret(namespace, name string, data map[string][]byte) *secret {
+	return &secret{
+		APIVersion: "v1",
+		Kind:       "Secret",
+		Metadata: objectMeta{
+			Name:      name,
+			Namespace: namespace,
+			Labels:    map[string]string{"app.kubernetes.io/managed-by": "mesh-operator"},
+		},
+		Type: "Opaque",
+		Data: data,
+	}
+}
+
+func namespacedPath(prefix, namespace, resource string) string {
+	if namespace == "" {
+		return prefix + "/" + resource
+	}
+	return prefix + "/namespaces/" + namespace + "/" + resource
+}
+
+func secretPath(namespace, name string) string {
+	return namespacedPath("/api/v1", namespace, "secrets") + "/" + name
+}
+
+func deploymentPath(namespace, name string) string {
+	return namespacedPath("/apis/apps/v1", namespace, "deployments") + "/" + name
+}
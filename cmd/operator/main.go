@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/gorilla/mux"
+	"quantum-safe-mesh/pkg/cloudattest"
+	"quantum-safe-mesh/pkg/config"
+	"quantum-safe-mesh/pkg/health"
+	"quantum-safe-mesh/pkg/mesh"
+)
+
+// Operator injects the mesh sidecar into annotated Deployments, keeps their
+// keys and bootstrap tokens in Secrets, and carries out MeshKeyRotations.
+type Operator struct {
+	cfg      *config.Config
+	identity *mesh.Identity
+	registry *mesh.RegistryClient
+	kube     *kubeClient
+}
+
+func NewOperator(cfg *config.Config) (*Operator, error) {
+	log.Println("🚀 Starting Mesh Operator...")
+
+	identity, err := cfg.Keys.LoadIdentity(cfg.Service.ID, cfg.Crypto.Signature, cfg.Crypto.KEM)
+	if err != nil {
+		return nil, err
+	}
+
+	kube, err := newKubeClient(cfg.Operator.KubeAPIURL)
+	if err != nil {
+		return nil, err
+	}
+
+	// The auth service checks the bootstrap tokens this operator issues
+	// against its registered key.
+	registry := mesh.NewRegistryClient(cfg.Auth.URL, identity, mesh.NewPeerVersions())
+	registry.SetTimeout(cfg.Timeouts.Client)
+	registry.SetKeyLookupTimeout(cfg.Timeouts.KeyLookup)
+	if cfg.Policy.SPIFFESocket != "" {
+		registry.UseSPIFFE(cfg.Policy.SPIFFESocket)
+	}
+	if cfg.Policy.KubeSATokenFile != "" {
+		registry.UseServiceAccountToken(cfg.Policy.KubeSATokenFile)
+	}
+	if cfg.Policy.CloudAttestProvider != "" {
+		registry.UseCloudAttestation(cloudattest.NewClient(cfg.Policy.CloudAttestProvider, cfg.Policy.CloudAttestAzureResource))
+	}
+	if cfg.Policy.BootstrapToken != "" {
+		registry.UseBootstrapToken(cfg.Policy.BootstrapToken)
+	}
+	if err := registry.Register(); err != nil {
+		log.Printf("Warning: failed to register with auth service: %v", err)
+	} else if cfg.Keys.RotationDue(identity) {
+		if err := registry.RotateIdentity(); err != nil {
+			log.Printf("Warning: failed to rotate expiring key: %v", err)
+		}
+	}
+
+	namespace := cfg.Operator.Namespace
+	if namespace == "" {
+		namespace = "all namespaces"
+	}
+	log.Printf("✅ Mesh Operator initialized with ID: %s (watching %s)", identity.ServiceID, namespace)
+
+	return &Operator{cfg: cfg, identity: identity, registry: registry, kube: kube}, nil
+}
+
+// run keeps the objects at path reconciled: it lists them all, then watches
+// for changes until the watch ends after the resync interval, and repeats.
+func (op *Operator) run(kind, path string, reconcile func(json.RawMessage) error) {
+	for {
+		var list objectList
+		if err := op.kube.get(path, &list); err != nil {
+			log.Printf("❌ Failed to list %s: %v", kind, err)
+			time.Sleep(5 * time.Second)
+			continue
+		}
+
+		for _, item := range list.Items {
+			if err := reconcile(item); err != nil {
+				log.Printf("❌ Failed to reconcile %s: %v", kind, err)
+			}
+		}
+
+		err := op.kube.watch(path, list.Metadata.ResourceVersion, op.cfg.Operator.Resync, func(event watchEvent) {
+			if event.Type != "ADDED" && event.Type != "MODIFIED" {
+				return
+			}
+			if err := reconcile(event.Object); err != nil {
+				log.Printf("❌ Failed to reconcile %s: %v", kind, err)
+			}
+		})
+		if err != nil {
+			log.Printf("⚠️  Restarting %s watch: %v", kind, err)
+			time.Sleep(time.Second)
+		}
+	}
+}
+
+func main() {
+	cfg, err := config.Load(config.ServiceOperator, flag.CommandLine, os.Args[1:])
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+	if err := cfg.Log.Apply(cfg.Service.ID); err != nil {
+		log.Fatalf("Failed to set up logging: %v", err)
+	}
+	if err := cfg.Telemetry.Apply(cfg.Service.ID); err != nil {
+		log.Fatalf("Failed to set up telemetry: %v", err)
+	}
+	if err := cfg.Debug.Apply(); err != nil {
+		log.Fatalf("Failed to start diagnostics: %v", err)
+	}
+	if err := cfg.Keys.Apply(); err != nil {
+		log.Fatalf("Failed to open key store: %v", err)
+	}
+	cfg.Crypto.Apply()
+	config.NewReloader(cfg, config.ServiceOperator, flag.CommandLine).Watch()
+
+	operator, err := NewOperator(cfg)
+	if err != nil {
+		log.Fatalf("Failed to create operator: %v", err)
+	}
+
+	namespace := cfg.Operator.Namespace
+	go operator.run("deployments", namespacedPath("/apis/apps/v1", namespace, "deployments"), operator.reconcileDeployment)
+	go operator.run("key rotations", namespacedPath(rotationAPI, namespace, rotationResource), operator.reconcileRotation)
+
+	r := mux.NewRouter()
+	probes := health.New()
+	probes.Add(health.Startup, "registered", operator.registry.CheckRegistered)
+	probes.Add(health.Readiness, "keys", cfg.Keys.CheckKeys(operator.identity))
+	probes.Add(health.Readiness, "auth", operator.registry.CheckAuth)
+	probes.Register(r)
+	r.HandleFunc("/config", cfg.Handler()).Methods("GET")
+
+	server := &http.Server{
+		Addr:              cfg.Service.ListenAddr,
+		Handler:           r,
+		ReadHeaderTimeout: cfg.Timeouts.ReadHeader,
+	}
+
+	log.Printf("🌟 Mesh Operator starting on %s", cfg.Service.ListenAddr)
+	log.Fatal(server.ListenAndServe())
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"quantum-safe-mesh/pkg/mesh"
+	"quantum-safe-mesh/pkg/pqc"
+)
+
+// MeshKeyRotation custom resource, defined in k8s/operator.yaml.
+const (
+	rotationAPI      = "/apis/mesh.quantum-safe.io/v1alpha1"
+	rotationResource = "meshkeyrotations"
+)
+
+// Rotation phases. A rotation runs once; create a new resource to rotate
+// again.
+const (
+	phaseSucceeded = "Succeeded"
+	phaseFailed    = "Failed"
+)
+
+type keyRotation struct {
+	Metadata objectMeta `json:"metadata"`
+	Spec     struct {
+		ServiceID  string `json:"serviceID"`
+		Deployment string `json:"deployment,omitempty"` // restarted to pick up the new key
+	} `json:"spec"`
+	Status keyRotationStatus `json:"status"`
+}
+
+type keyRotationStatus struct {
+	Phase          string `json:"phase,omitempty"`
+	Message        string `json:"message,omitempty"`
+	OldFingerprint string `json:"oldFingerprint,omitempty"`
+	NewFingerprint string `json:"newFingerprint,omitempty"`
+	CompletedAt    string `json:"completedAt,omitempty"`
+}
+
+// reconcileRotation replaces a service's key the same way 'meshctl rotate'
+// does: the old key from its Secret signs a /rotate request proving
+// possession of a new one, the Secret is updated, and the Deployment is
+// rolled so its pods load the new key.
+func (op *Operator) reconcileRotation(raw json.RawMessage) error {
+	var rotation keyRotation
+	if err := json.Unmarshal(raw, &rotation); err != nil {
+		return fmt.Errorf("failed to decode key rotation: %w", err)
+	}
+	if rotation.Status.Phase == phaseSucceeded || rotation.Status.Phase == phaseFailed {
+		return nil
+	}
+
+	namespace, serviceID := rotation.Metadata.Namespace, rotation.Spec.ServiceID
+	if serviceID == "" {
+		return op.setRotationStatus(&rotation, keyRotationStatus{Phase: phaseFailed, Message: "spec.serviceID is required"})
+	}
+
+	log.Printf("🔄 Rotating key for %s (%s/%s)", serviceID, namespace, rotation.Metadata.Name)
+
+	identity, keys, err := op.loadIdentity(namespace, serviceID)
+	if err != nil {
+		return op.setRotationStatus(&rotation, keyRotationStatus{Phase: phaseFailed, Message: err.Error()})
+	}
+
+	newKey, err := pqc.GenerateDilithiumKeyPair()
+	if err != nil {
+		return fmt.Errorf("failed to generate Dilithium keypair: %w", err)
+	}
+
+	status := keyRotationStatus{
+		OldFingerprint: identity.KeyID(),
+		NewFingerprint: pqc.KeyFingerprint(newKey.GetPublicKeyBytes()),
+	}
+
+	registry := mesh.NewRegistryClient(op.cfg.Auth.URL, identity, mesh.NewPeerVersions())
+	registry.SetTimeout(op.cfg.Timeouts.Client)
+	registry.SetKeyLookupTimeout(op.cfg.Timeouts.KeyLookup)
+	if _, err := registry.Rotate(newKey); err != nil {
+		status.Phase, status.Message = phaseFailed, err.Error()
+		return op.setRotationStatus(&rotation, status)
+	}
+
+	// The auth service already trusts the new key; losing it here would
+	// lock the service out, so say exactly what happened.
+	dilithiumPub, dilithiumPriv, _, _ := pqc.KeyFileNames(serviceID)
+	keys.Data[dilithiumPub] = newKey.GetPublicKeyBytes()
+	keys.Data[dilithiumPriv] = newKey.GetPrivateKeyBytes()
+	if err := op.kube.update(secretPath(namespace, keys.Metadata.Name), keys); err != nil {
+		status.Phase = phaseFailed
+		status.Message = fmt.Sprintf("key rotated to %s but saving it failed: %v", status.NewFingerprint, err)
+		return op.setRotationStatus(&rotation, status)
+	}
+
+	if rotation.Spec.Deployment != "" {
+		patch := map[string]interface{}{
+			"spec": map[string]interface{}{
+				"template": map[string]interface{}{
+					"metadata": map[string]interface{}{
+						"annotations": map[string]string{annotationFingerprint: status.NewFingerprint},
+					},
+				},
+			},
+		}
+		if err := op.kube.patch(deploymentPath(namespace, rotation.Spec.Deployment), strategicMergePatch, patch); err != nil {
+			status.Phase = phaseFailed
+			status.Message = fmt.Sprintf("key rotated to %s but restarting %s failed: %v", status.NewFingerprint, rotation.Spec.Deployment, err)
+			return op.setRotationStatus(&rotation, status)
+		}
+	}
+
+	log.Printf("✅ Key rotated for %s: %s -> %s", serviceID, status.OldFingerprint, status.NewFingerprint)
+
+	status.Phase, status.Message = phaseSucceeded, "Key rotated"
+	return op.setRotationStatus(&rotation, status)
+}
+
+func (op *Operator) setRotationStatus(rotation *keyRotation, status keyRotationStatus) error {
+	if status.Phase == phaseFailed {
+		log.Printf("❌ Key rotation %s/%s failed: %s", rotation.Metadata.Namespace, rotation.Metadata.Name, status.Message)
+	}
+	status.CompletedAt = time.Now().UTC().Format(time.RFC3339)
+
+	path := namespacedPath(rotationAPI, rotation.Metadata.Namespace, rotationResource) + "/" + rotation.Metadata.Name + "/status"
+	if err := op.kube.patch(path, mergePatch, map[string]interface{}{"status": status}); err != nil {
+		return fmt.Errorf("failed to update status of %s/%s: %w", rotation.Metadata.Namespace, rotation.Metadata.Name, err)
+	}
+	return nil
+}
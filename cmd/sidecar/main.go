@@ -0,0 +1,279 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"quantum-safe-mesh/pkg/cloudattest"
+	"quantum-safe-mesh/pkg/config"
+	"quantum-safe-mesh/pkg/health"
+	"quantum-safe-mesh/pkg/mesh"
+	"quantum-safe-mesh/pkg/meshlog"
+	"quantum-safe-mesh/pkg/middleware"
+	"quantum-safe-mesh/pkg/models"
+	"quantum-safe-mesh/pkg/pqc"
+	"quantum-safe-mesh/pkg/telemetry"
+)
+
+// hopHeaders are not forwarded to the local app: they either describe the
+// mesh hop the sidecar terminated or are connection-specific.
+var hopHeaders = map[string]bool{
+	"Connection":                 true,
+	"Content-Length":             true,
+	"Keep-Alive":                 true,
+	"Transfer-Encoding":          true,
+	"Upgrade":                    true,
+	models.HeaderAcceptVersion:   true,
+	models.HeaderProtocolVersion: true,
+	models.HeaderMeshSignature:   true,
+	models.HeaderOriginalMethod:  true,
+	models.HeaderKeyID:           true,
+}
+
+// Sidecar fronts a plain-HTTP app on localhost with a mesh identity. Inbound
+// mesh requests are verified before they reach the app, and the app's
+// answers are signed on its behalf.
+type Sidecar struct {
+	identity *mesh.Identity
+	registry *mesh.RegistryClient
+	server   *mesh.VerifyingServer
+	appURL   string
+	client   *http.Client
+
+	// graphql lists the queries the app may be asked on graphqlPath, if
+	// it is set.
+	graphqlPath string
+	graphql     *mesh.PersistedQueries
+}
+
+func NewSidecar(cfg *config.Config) (*Sidecar, error) {
+	log.Println("🚀 Starting Sidecar...")
+
+	serviceID := cfg.Service.ID
+	identity, err := cfg.Keys.LoadIdentity(serviceID, cfg.Crypto.Signature, cfg.Crypto.KEM)
+	if err != nil {
+		return nil, err
+	}
+
+	registry := mesh.NewRegistryClient(cfg.Auth.URL, identity, mesh.NewPeerVersions())
+	registry.SetTimeout(cfg.Timeouts.Client)
+	registry.SetKeyLookupTimeout(cfg.Timeouts.KeyLookup)
+	if cfg.Service.AdvertiseURL != "" {
+		registry.Advertise(cfg.Service.AdvertiseURL)
+	}
+
+	sc := &Sidecar{
+		identity: identity,
+		registry: registry,
+		server:   mesh.NewVerifyingServer(identity, registry.PublicKey),
+		appURL:   strings.TrimSuffix(cfg.App.URL, "/"),
+		client:   &http.Client{Timeout: cfg.Timeouts.Client},
+	}
+	sc.server.UseKeyResolver(registry)
+	sc.server.UseFreshness(cfg.Timeouts.RequestMaxAge, cfg.Timeouts.ClockSkew)
+	replays, err := mesh.OpenReplayStore(cfg.Replay.Store, identity.ServiceID)
+	if err != nil {
+		return nil, err
+	}
+	sc.server.UseReplayStore(replays)
+	sc.server.UseAdmission(cfg.Admission.Controller(sc.server.Metrics()))
+
+	namespaces, err := mesh.ParseNamespacePolicy(cfg.Policy.NamespaceRoutes, cfg.Policy.NamespaceTrust)
+	if err != nil {
+		return nil, err
+	}
+	if namespaces != nil {
+		sc.server.UseNamespacePolicy(namespaces)
+		log.Println("🏢 Enforcing namespace trust boundaries on inbound calls")
+	}
+
+	if cfg.GraphQL.Path != "" {
+		if sc.graphql, err = mesh.LoadPersistedQueries(cfg.GraphQL.Queries); err != nil {
+			return nil, err
+		}
+		sc.graphqlPath = cfg.GraphQL.Path
+		log.Printf("🔏 Allowing %d persisted GraphQL queries on %s", sc.graphql.Len(), sc.graphqlPath)
+	}
+
+	if cfg.Policy.SPIFFESocket != "" {
+		registry.UseSPIFFE(cfg.Policy.SPIFFESocket)
+	}
+	if cfg.Policy.KubeSATokenFile != "" {
+		registry.UseServiceAccountToken(cfg.Policy.KubeSATokenFile)
+	}
+	if cfg.Policy.CloudAttestProvider != "" {
+		registry.UseCloudAttestation(cloudattest.NewClient(cfg.Policy.CloudAttestProvider, cfg.Policy.CloudAttestAzureResource))
+	}
+	if cfg.Policy.BootstrapToken != "" {
+		registry.UseBootstrapToken(cfg.Policy.BootstrapToken)
+	}
+	if cfg.Policy.PinFile != "" {
+		pins, err := pqc.OpenPinStore(cfg.Policy.PinFile)
+		if err != nil {
+			return nil, err
+		}
+		registry.UsePinStore(pins)
+	}
+	if cfg.Snapshot.File != "" {
+		registry.UseSnapshotFile(cfg.Snapshot.File, cfg.Snapshot.Signer)
+	}
+
+	if err := registry.Register(); err != nil {
+		log.Printf("Warning: failed to register with auth service: %v", err)
+	} else if cfg.Keys.RotationDue(identity) {
+		if err := registry.RotateIdentity(); err != nil {
+			log.Printf("Warning: failed to rotate expiring key: %v", err)
+		}
+	}
+
+	log.Printf("✅ Sidecar initialized with ID: %s (app: %s)", serviceID, sc.appURL)
+	return sc, nil
+}
+
+// checkApp is a health check failing while the app does not answer HTTP
+// at all; any status will do, since the app's paths are its own.
+func (sc *Sidecar) checkApp(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, sc.appURL+"/", nil)
+	if err != nil {
+		return err
+	}
+	resp, err := sc.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("app unreachable: %w", err)
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// forward replays a verified mesh request against the local app and returns
+// the app's body for signing. Bodies that are not JSON are sent back as a
+// JSON string so they still fit in an envelope's data field.
+func (sc *Sidecar) forward(req *mesh.Request) (interface{}, error) {
+	start := time.Now()
+	request := req.Envelope
+
+	logger := meshlog.FromContext(req.Context())
+	method := req.OriginalMethod()
+	logger.Info("🔄 Forwarding to app", "method", method, "path", req.URL.Path)
+
+	if sc.graphql != nil && mesh.OnGraphQLPath(req.URL.Path, sc.graphqlPath) {
+		if errResp := sc.graphql.Check(method, request.Data); errResp != nil {
+			logger.Info("🚫 Refused GraphQL operation", "reason", errResp.Message)
+			return nil, errResp
+		}
+	}
+
+	var body io.Reader
+	if method != http.MethodGet && method != http.MethodHead {
+		body = bytes.NewReader(request.Data)
+	}
+
+	appReq, err := http.NewRequestWithContext(req.Context(), method, sc.appURL+req.URL.RequestURI(), body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create app request: %w", err)
+	}
+
+	for key, value := range request.Headers {
+		if !hopHeaders[http.CanonicalHeaderKey(key)] {
+			appReq.Header.Set(key, value)
+		}
+	}
+	appReq.Header.Set("X-Mesh-Caller", request.ServiceID)
+	appReq.Header.Set(models.HeaderRequestID, request.RequestID)
+	appReq.Header.Set(models.HeaderParentID, request.ParentID)
+	telemetry.Inject(req.Context(), appReq.Header)
+
+	resp, err := sc.client.Do(appReq)
+	if errors.Is(err, context.DeadlineExceeded) {
+		return nil, err
+	}
+	if err != nil {
+		logger.Error("❌ App request failed", "error", err)
+		return nil, models.NewError(http.StatusServiceUnavailable, models.ErrCodeUpstreamUnavailable, "Local app unavailable")
+	}
+	defer resp.Body.Close()
+
+	responseBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		logger.Error("❌ Failed to read app response", "error", err)
+		return nil, models.NewError(http.StatusBadGateway, models.ErrCodeUpstreamInvalidResponse, "Invalid app response")
+	}
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		logger.Error("❌ App returned error", "status", resp.StatusCode)
+		return nil, models.NewError(resp.StatusCode, models.ErrCodeUpstreamError, fmt.Sprintf("App returned status %d", resp.StatusCode))
+	}
+
+	logger.Info("✅ App answered", "status", resp.StatusCode, "duration", time.Since(start))
+
+	if json.Valid(responseBody) {
+		return json.RawMessage(responseBody), nil
+	}
+	return string(responseBody), nil
+}
+
+func main() {
+	cfg, err := config.Load(config.ServiceSidecar, flag.CommandLine, os.Args[1:])
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+	if err := cfg.Log.Apply(cfg.Service.ID); err != nil {
+		log.Fatalf("Failed to set up logging: %v", err)
+	}
+	if err := cfg.Telemetry.Apply(cfg.Service.ID); err != nil {
+		log.Fatalf("Failed to set up telemetry: %v", err)
+	}
+	if err := cfg.Debug.Apply(); err != nil {
+		log.Fatalf("Failed to start diagnostics: %v", err)
+	}
+	if err := cfg.Keys.Apply(); err != nil {
+		log.Fatalf("Failed to open key store: %v", err)
+	}
+	cfg.Crypto.Apply()
+
+	sidecar, err := NewSidecar(cfg)
+	if err != nil {
+		log.Fatalf("Failed to create sidecar: %v", err)
+	}
+	if err := cfg.Audit.Apply(sidecar.server.Audit()); err != nil {
+		log.Fatalf("Failed to start audit sinks: %v", err)
+	}
+	reloader := config.NewReloader(cfg, config.ServiceSidecar, flag.CommandLine)
+	reloader.OnReload(func(cfg *config.Config) {
+		sidecar.server.UseFreshness(cfg.Timeouts.RequestMaxAge, cfg.Timeouts.ClockSkew)
+	})
+	reloader.Watch()
+
+	r := mux.NewRouter()
+	r.Use(telemetry.Middleware, middleware.Deadline(cfg.Timeouts.Request))
+	probes := health.New()
+	probes.Add(health.Startup, "registered", sidecar.registry.CheckRegistered)
+	probes.Add(health.Readiness, "keys", cfg.Keys.CheckKeys(sidecar.identity))
+	probes.Add(health.Readiness, "auth", sidecar.registry.CheckAuth)
+	probes.Add(health.Readiness, "app", sidecar.checkApp)
+	probes.Register(r)
+	r.HandleFunc("/config", cfg.Handler()).Methods("GET")
+	r.HandleFunc("/metrics", sidecar.server.Metrics().Handler()).Methods("GET")
+	r.HandleFunc("/audit", sidecar.server.Audit().Handler()).Methods("GET")
+	r.PathPrefix("/").HandlerFunc(sidecar.server.Verified(sidecar.forward))
+
+	server := &http.Server{
+		Addr:              cfg.Service.ListenAddr,
+		Handler:           r,
+		ReadHeaderTimeout: cfg.Timeouts.ReadHeader,
+	}
+
+	log.Printf("🌟 Sidecar starting on %s", cfg.Service.ListenAddr)
+	log.Fatal(server.ListenAndServe())
+}
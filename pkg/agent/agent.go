@@ -0,0 +1,264 @@
+// Package agent keeps services' private keys out of the services: a
+// mesh-agent process holds them and signs and decapsulates for local
+// clients over a Unix socket, checking each client's peer credentials, in
+// the way ssh-agent holds SSH keys.
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"quantum-safe-mesh/pkg/mesh"
+	"quantum-safe-mesh/pkg/models"
+	"quantum-safe-mesh/pkg/pqc"
+)
+
+// KeyInfo describes the keys the agent holds for a service.
+type KeyInfo struct {
+	ServiceID    string `json:"service_id"`
+	Algorithm    string `json:"algorithm"`
+	PublicKey    []byte `json:"public_key"`
+	KEMPublicKey []byte `json:"kem_public_key"`
+	KEMAlgorithm string `json:"kem_algorithm,omitempty"` // empty means kyber768, from agents that predate ML-KEM
+}
+
+type SignRequest struct {
+	ServiceID string `json:"service_id"`
+	Data      []byte `json:"data"`
+}
+
+type SignResponse struct {
+	Signature []byte `json:"signature"`
+}
+
+type DecapsulateRequest struct {
+	ServiceID  string `json:"service_id"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+type DecapsulateResponse struct {
+	SharedSecret []byte `json:"shared_secret"`
+}
+
+// maxRequestSize bounds a request body; signed payloads are envelopes, not
+// bulk data.
+const maxRequestSize = 4 << 20
+
+// PeerCredentials identify the process on the other end of a Unix socket.
+type PeerCredentials struct {
+	PID int32
+	UID uint32
+	GID uint32
+}
+
+type peerKey struct{}
+
+type peer struct {
+	credentials *PeerCredentials
+	err         error
+}
+
+// Policy decides which local users may use which service's keys.
+type Policy struct {
+	all        map[uint32]bool
+	perService map[string]map[uint32]bool
+}
+
+// ParsePolicy parses a comma-separated list of UIDs allowed to use every
+// key, or service=UID pairs allowed to use one service's keys, e.g.
+// "1000,backend-service=1001". An empty spec allows only the agent's own UID.
+func ParsePolicy(spec string) (*Policy, error) {
+	policy := &Policy{all: make(map[uint32]bool), perService: make(map[string]map[uint32]bool)}
+	if strings.TrimSpace(spec) == "" {
+		policy.all[uint32(os.Getuid())] = true
+		return policy, nil
+	}
+
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		serviceID, uidText, scoped := strings.Cut(entry, "=")
+		if !scoped {
+			uidText = entry
+		}
+		uid, err := strconv.ParseUint(strings.TrimSpace(uidText), 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid UID in %q", entry)
+		}
+		if !scoped {
+			policy.all[uint32(uid)] = true
+			continue
+		}
+		serviceID = strings.TrimSpace(serviceID)
+		if policy.perService[serviceID] == nil {
+			policy.perService[serviceID] = make(map[uint32]bool)
+		}
+		policy.perService[serviceID][uint32(uid)] = true
+	}
+	return policy, nil
+}
+
+// Allows reports whether uid may use serviceID's keys.
+func (p *Policy) Allows(serviceID string, uid uint32) bool {
+	return p.all[uid] || p.perService[serviceID][uid]
+}
+
+// Server signs and decapsulates with the identities it holds for clients
+// the policy allows.
+type Server struct {
+	identities map[string]*mesh.Identity
+	policy     *Policy
+}
+
+func NewServer(identities []*mesh.Identity, policy *Policy) *Server {
+	s := &Server{identities: make(map[string]*mesh.Identity), policy: policy}
+	for _, identity := range identities {
+		s.identities[identity.ServiceID] = identity
+	}
+	return s
+}
+
+// Router returns the agent's HTTP API.
+func (s *Server) Router() *mux.Router {
+	r := mux.NewRouter()
+	r.NotFoundHandler = models.NotFoundHandler
+	r.MethodNotAllowedHandler = models.MethodNotAllowedHandler
+	r.HandleFunc("/keys/{service}", s.keys).Methods("GET")
+	r.HandleFunc("/sign", s.sign).Methods("POST")
+	r.HandleFunc("/decapsulate", s.decapsulate).Methods("POST")
+	return r
+}
+
+// Serve listens on the Unix socket at path, replacing a stale socket left by
+// a previous agent, and serves until the listener fails. The socket is
+// readable and writable by its owner and group; the policy decides which of
+// them may use which keys.
+func (s *Server) Serve(path string) error {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove stale socket: %w", err)
+	}
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", path, err)
+	}
+	defer listener.Close()
+	if err := os.Chmod(path, 0660); err != nil {
+		return fmt.Errorf("failed to set socket permissions: %w", err)
+	}
+
+	server := &http.Server{
+		Handler:           s.Router(),
+		ReadHeaderTimeout: 10 * time.Second,
+		ConnContext: func(ctx context.Context, conn net.Conn) context.Context {
+			credentials, err := peerCredentials(conn)
+			return context.WithValue(ctx, peerKey{}, peer{credentials, err})
+		},
+	}
+	log.Printf("🗝️  Mesh agent serving %d identities on %s", len(s.identities), path)
+	return server.Serve(listener)
+}
+
+// authorize returns serviceID's identity if the peer may use it, writing
+// the error response otherwise.
+func (s *Server) authorize(w http.ResponseWriter, r *http.Request, serviceID, operation string) *mesh.Identity {
+	p, _ := r.Context().Value(peerKey{}).(peer)
+	if p.credentials == nil {
+		log.Printf("❌ Refused %s for %s: peer credentials unavailable: %v", strings.ToLower(operation), serviceID, p.err)
+		models.WriteError(w, r, http.StatusForbidden, models.ErrCodeUnauthenticated, "Peer credentials unavailable")
+		return nil
+	}
+	if !s.policy.Allows(serviceID, p.credentials.UID) {
+		log.Printf("❌ Refused %s for %s to uid %d (pid %d)", strings.ToLower(operation), serviceID, p.credentials.UID, p.credentials.PID)
+		models.WriteError(w, r, http.StatusForbidden, models.ErrCodeUnauthenticated, fmt.Sprintf("uid %d may not use the keys of %s", p.credentials.UID, serviceID))
+		return nil
+	}
+
+	identity := s.identities[serviceID]
+	if identity == nil {
+		models.WriteError(w, r, http.StatusNotFound, models.ErrCodeServiceNotFound, "No keys held for "+serviceID)
+		return nil
+	}
+	log.Printf("🗝️  %s for %s by uid %d (pid %d)", operation, serviceID, p.credentials.UID, p.credentials.PID)
+	return identity
+}
+
+func (s *Server) keys(w http.ResponseWriter, r *http.Request) {
+	identity := s.authorize(w, r, mux.Vars(r)["service"], "Key lookup")
+	if identity == nil {
+		return
+	}
+	writeJSON(w, KeyInfo{
+		ServiceID:    identity.ServiceID,
+		Algorithm:    identity.Signer.Algorithm(),
+		PublicKey:    identity.PublicKey(),
+		KEMPublicKey: identity.Kyber.GetPublicKeyBytes(),
+		KEMAlgorithm: identity.Kyber.Algorithm(),
+	})
+}
+
+func (s *Server) sign(w http.ResponseWriter, r *http.Request) {
+	var request SignRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestSize)).Decode(&request); err != nil {
+		models.WriteError(w, r, http.StatusBadRequest, models.ErrCodeInvalidRequest, "Invalid sign request")
+		return
+	}
+	identity := s.authorize(w, r, request.ServiceID, "Signing")
+	if identity == nil {
+		return
+	}
+
+	signature, err := identity.Signer.Sign(request.Data)
+	if err != nil {
+		models.WriteError(w, r, http.StatusInternalServerError, models.ErrCodeInternal, "Signing failed")
+		return
+	}
+	writeJSON(w, SignResponse{Signature: signature})
+}
+
+func (s *Server) decapsulate(w http.ResponseWriter, r *http.Request) {
+	var request DecapsulateRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestSize)).Decode(&request); err != nil {
+		models.WriteError(w, r, http.StatusBadRequest, models.ErrCodeInvalidRequest, "Invalid decapsulate request")
+		return
+	}
+	identity := s.authorize(w, r, request.ServiceID, "Decapsulation")
+	if identity == nil {
+		return
+	}
+
+	sharedSecret, err := identity.Kyber.Decapsulate(request.Ciphertext)
+	if err != nil {
+		models.WriteError(w, r, http.StatusBadRequest, models.ErrCodeInvalidRequest, "Decapsulation failed")
+		return
+	}
+	writeJSON(w, DecapsulateResponse{SharedSecret: sharedSecret})
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+// LoadIdentities loads the keys of each of serviceIDs from the key store.
+func LoadIdentities(serviceIDs []string) ([]*mesh.Identity, error) {
+	var identities []*mesh.Identity
+	for _, serviceID := range serviceIDs {
+		identity, err := mesh.LoadIdentity(serviceID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load keys for %s from %s: %w", serviceID, pqc.KeysDir, err)
+		}
+		identities = append(identities, identity)
+	}
+	return identities, nil
+}
@@ -0,0 +1,136 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"quantum-safe-mesh/pkg/mesh"
+	"quantum-safe-mesh/pkg/models"
+	"quantum-safe-mesh/pkg/pqc"
+)
+
+// Client talks to a mesh-agent over its Unix socket.
+type Client struct {
+	socket string
+	client *http.Client
+}
+
+func NewClient(socket string) *Client {
+	dialer := &net.Dialer{Timeout: 5 * time.Second}
+	return &Client{
+		socket: socket,
+		client: &http.Client{
+			Timeout: 30 * time.Second,
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					return dialer.DialContext(ctx, "unix", socket)
+				},
+			},
+		},
+	}
+}
+
+// Identity returns serviceID's identity backed by the agent: its signing and
+// KEM keys stay in the agent, which signs and decapsulates on its behalf.
+func (c *Client) Identity(serviceID string) (*mesh.Identity, error) {
+	var info KeyInfo
+	if err := c.call(http.MethodGet, "/keys/"+serviceID, nil, &info); err != nil {
+		return nil, err
+	}
+	kemAlgorithm, err := pqc.KEMAlgorithm(info.KEMAlgorithm)
+	if err != nil {
+		return nil, fmt.Errorf("agent key for %s: %w", serviceID, err)
+	}
+	return &mesh.Identity{
+		ServiceID: serviceID,
+		Signer:    &Signer{client: c, serviceID: serviceID, algorithm: info.Algorithm, publicKey: info.PublicKey},
+		Kyber:     &Decapsulator{client: c, serviceID: serviceID, algorithm: kemAlgorithm, publicKey: info.KEMPublicKey},
+	}, nil
+}
+
+func (c *Client) call(method, path string, request, response interface{}) error {
+	var body bytes.Buffer
+	if request != nil {
+		if err := json.NewEncoder(&body).Encode(request); err != nil {
+			return fmt.Errorf("failed to marshal agent request: %w", err)
+		}
+	}
+	req, err := http.NewRequest(method, "http://mesh-agent"+path, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to reach mesh-agent at %s: %w", c.socket, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("mesh-agent %s failed: %w", path, models.DecodeErrorResponse(resp))
+	}
+	if err := json.NewDecoder(resp.Body).Decode(response); err != nil {
+		return fmt.Errorf("failed to decode mesh-agent response: %w", err)
+	}
+	return nil
+}
+
+// Signer is a pqc.Signer whose private key is held by a mesh-agent.
+type Signer struct {
+	client    *Client
+	serviceID string
+	algorithm string
+	publicKey []byte
+}
+
+func (s *Signer) Algorithm() string {
+	return s.algorithm
+}
+
+func (s *Signer) Sign(data []byte) ([]byte, error) {
+	var response SignResponse
+	if err := s.client.call(http.MethodPost, "/sign", SignRequest{ServiceID: s.serviceID, Data: data}, &response); err != nil {
+		return nil, err
+	}
+	return response.Signature, nil
+}
+
+func (s *Signer) GetPublicKeyBytes() []byte {
+	return s.publicKey
+}
+
+// GetPrivateKeyBytes returns nil: the private key never leaves the agent.
+func (s *Signer) GetPrivateKeyBytes() []byte {
+	return nil
+}
+
+// Decapsulator is a pqc.Decapsulator whose private key is held by a
+// mesh-agent.
+type Decapsulator struct {
+	client    *Client
+	serviceID string
+	algorithm string
+	publicKey []byte
+}
+
+func (d *Decapsulator) Algorithm() string {
+	return d.algorithm
+}
+
+func (d *Decapsulator) GetPublicKeyBytes() []byte {
+	return d.publicKey
+}
+
+func (d *Decapsulator) Decapsulate(ciphertext []byte) ([]byte, error) {
+	var response DecapsulateResponse
+	if err := d.client.call(http.MethodPost, "/decapsulate", DecapsulateRequest{ServiceID: d.serviceID, Ciphertext: ciphertext}, &response); err != nil {
+		return nil, err
+	}
+	return response.SharedSecret, nil
+}
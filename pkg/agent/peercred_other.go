@@ -0,0 +1,15 @@
+//go:build !linux
+
+package agent
+
+import (
+	"fmt"
+	"net"
+	"runtime"
+)
+
+// peerCredentials is only implemented on Linux; elsewhere every client is
+// refused rather than trusted unchecked.
+func peerCredentials(net.Conn) (*PeerCredentials, error) {
+	return nil, fmt.Errorf("peer credentials are not supported on %s", runtime.GOOS)
+}
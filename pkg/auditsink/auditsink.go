@@ -0,0 +1,156 @@
+// Package auditsink forwards a service's audit events to SIEMs and log
+// collectors, as RFC 5424 syslog or Common Event Format, so mesh security
+// events can be alerted on without custom parsers.
+//
+// Sinks are opened by URL, with one opener registered per scheme. The
+// built-in syslog schemes are syslog (UDP), syslog+udp, syslog+tcp,
+// syslog+tls and syslog+unix; other packages may Register their own.
+package auditsink
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"quantum-safe-mesh/pkg/mesh"
+)
+
+// queueSize is how many events may wait for a sink before new ones are
+// dropped.
+const queueSize = 256
+
+// sendAttempts is how many times an event is offered to a sink.
+const sendAttempts = 3
+
+// Sink delivers audit events.
+type Sink interface {
+	Send(event mesh.AuditEvent) error
+	Close() error
+}
+
+// Opener opens the sink at a URL of the scheme it is registered for.
+type Opener func(u *url.URL) (Sink, error)
+
+var (
+	openersMutex sync.RWMutex
+	openers      = make(map[string]Opener)
+)
+
+// Register makes open handle sink URLs of scheme. It panics if scheme is
+// already registered.
+func Register(scheme string, open Opener) {
+	openersMutex.Lock()
+	defer openersMutex.Unlock()
+
+	if _, exists := openers[scheme]; exists {
+		panic(fmt.Sprintf("auditsink: scheme %q registered twice", scheme))
+	}
+	openers[scheme] = open
+}
+
+// Open opens the sink at rawURL.
+func Open(rawURL string) (Sink, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid audit sink %q: %w", rawURL, err)
+	}
+
+	openersMutex.RLock()
+	open, ok := openers[u.Scheme]
+	openersMutex.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("invalid audit sink %q: unknown scheme %q", rawURL, u.Scheme)
+	}
+
+	sink, err := open(u)
+	if err != nil {
+		return nil, fmt.Errorf("invalid audit sink %q: %w", rawURL, err)
+	}
+	return sink, nil
+}
+
+// ParseSinks splits a comma-separated list of sink URLs, dropping empty
+// entries.
+func ParseSinks(value string) []string {
+	var urls []string
+	for _, entry := range strings.Split(value, ",") {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			urls = append(urls, entry)
+		}
+	}
+	return urls
+}
+
+// Check reports whether every sink in the comma-separated list value
+// could be opened, without connecting to any.
+func Check(value string) error {
+	for _, rawURL := range ParseSinks(value) {
+		sink, err := Open(rawURL)
+		if err != nil {
+			return err
+		}
+		sink.Close()
+	}
+	return nil
+}
+
+// Forwarder sends audit events to a sink in the background, so a slow or
+// unreachable SIEM never holds up the service recording them.
+type Forwarder struct {
+	name  string
+	sink  Sink
+	queue chan mesh.AuditEvent
+}
+
+// Forward opens the sink at rawURL and has every event audit records from
+// now on sent to it.
+func Forward(audit *mesh.AuditLog, rawURL string) (*Forwarder, error) {
+	sink, err := Open(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	f := &Forwarder{name: redact(rawURL), sink: sink, queue: make(chan mesh.AuditEvent, queueSize)}
+	go f.run()
+	audit.Observe(f.Observe)
+
+	log.Printf("🧾 Forwarding audit events to %s", f.name)
+	return f, nil
+}
+
+// Observe queues event for the sink. It is an audit log observer.
+func (f *Forwarder) Observe(event mesh.AuditEvent) {
+	select {
+	case f.queue <- event:
+	default:
+		log.Printf("⚠️  Audit sink %s queue full, dropping %s event for %s", f.name, event.Type, event.Subject)
+	}
+}
+
+func (f *Forwarder) run() {
+	for event := range f.queue {
+		var err error
+		for attempt := 1; attempt <= sendAttempts; attempt++ {
+			if err = f.sink.Send(event); err == nil {
+				break
+			}
+			if attempt < sendAttempts {
+				time.Sleep(time.Duration(attempt) * time.Second)
+			}
+		}
+		if err != nil {
+			log.Printf("⚠️  Failed to send %s audit event for %s to %s: %v", event.Type, event.Subject, f.name, err)
+		}
+	}
+}
+
+// redact drops any credentials from rawURL, for logging.
+func redact(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+	return u.Redacted()
+}
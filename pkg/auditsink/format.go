@@ -0,0 +1,236 @@
+package auditsink
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"quantum-safe-mesh/pkg/buildinfo"
+	"quantum-safe-mesh/pkg/mesh"
+)
+
+// Formats a syslog sink writes events in.
+const (
+	// FormatRFC5424 writes a syslog message whose structured data holds the
+	// event's fields and whose text describes it.
+	FormatRFC5424 = "rfc5424"
+	// FormatCEF writes a syslog message whose text is the event in ArcSight
+	// Common Event Format.
+	FormatCEF = "cef"
+)
+
+// Event categories, which tell a SIEM rule what kind of event it matched
+// without it knowing every type.
+const (
+	CategoryVerification = "verification"
+	CategoryRegistry     = "registry"
+	CategoryAdmin        = "admin"
+)
+
+// timestampFormat is RFC 3339 with the at most six fractional digits RFC
+// 5424 allows.
+const timestampFormat = "2006-01-02T15:04:05.000000Z07:00"
+
+// sdID names the structured data element of RFC 5424 messages. 32473 is
+// the private enterprise number RFC 5612 reserves for documentation and
+// examples; the mesh has none of its own.
+const sdID = "mesh@32473"
+
+// eventInfo is how each event type is described to a SIEM: its name, its
+// CEF severity from 0 to 10 and its syslog severity from 0 (emergency) to 7
+// (debug).
+var eventInfo = map[string]struct {
+	name           string
+	cefSeverity    int
+	syslogSeverity int
+}{
+	mesh.AuditRegistered:           {"Service registered", 3, 6},
+	mesh.AuditRegistrationRejected: {"Registration rejected", 6, 4},
+	mesh.AuditRotated:              {"Key rotated", 3, 6},
+	mesh.AuditRevoked:              {"Service revoked", 5, 5},
+	mesh.AuditVerificationFailed:   {"Signature verification failed", 7, 4},
+	mesh.AuditExpired:              {"Key expired", 5, 5},
+}
+
+// Syslog facilities a sink may log to.
+var facilities = map[string]int{
+	"kern": 0, "user": 1, "daemon": 3, "auth": 4, "syslog": 5, "authpriv": 10, "audit": 13,
+	"local0": 16, "local1": 17, "local2": 18, "local3": 19,
+	"local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+// Category is the category of event: a verification failure, a change to
+// the registry a service made to itself, or one another service made to
+// it, such as an administrator revoking it or a delegate registering it.
+func Category(event mesh.AuditEvent) string {
+	switch {
+	case event.Type == mesh.AuditVerificationFailed:
+		return CategoryVerification
+	case event.Actor != "" && event.Actor != event.Subject:
+		return CategoryAdmin
+	default:
+		return CategoryRegistry
+	}
+}
+
+func describe(eventType string) (name string, cefSeverity, syslogSeverity int) {
+	if info, ok := eventInfo[eventType]; ok {
+		return info.name, info.cefSeverity, info.syslogSeverity
+	}
+	return eventType, 5, 5
+}
+
+// formatter writes events as syslog messages from one host and process.
+type formatter struct {
+	format   string
+	facility int
+	hostname string
+	procID   string
+}
+
+func newFormatter(format, facility string) (*formatter, error) {
+	if format == "" {
+		format = FormatRFC5424
+	}
+	if format != FormatRFC5424 && format != FormatCEF {
+		return nil, fmt.Errorf("unknown audit format %q: expected %s or %s", format, FormatRFC5424, FormatCEF)
+	}
+	if facility == "" {
+		facility = "auth"
+	}
+	code, ok := facilities[facility]
+	if !ok {
+		return nil, fmt.Errorf("unknown syslog facility %q", facility)
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		hostname = "-"
+	}
+	return &formatter{format: format, facility: code, hostname: hostname, procID: strconv.Itoa(os.Getpid())}, nil
+}
+
+// Format returns event as an RFC 5424 syslog message, with no framing.
+func (f *formatter) Format(event mesh.AuditEvent) []byte {
+	name, _, severity := describe(event.Type)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "<%d>1 %s %s %s %s %s ",
+		f.facility*8+severity,
+		event.Time.UTC().Format(timestampFormat),
+		headerField(f.hostname, 255),
+		headerField(event.Service, 48),
+		f.procID,
+		headerField(event.Type, 32))
+
+	if f.format == FormatCEF {
+		b.WriteString("- ")
+		b.WriteString(CEF(event))
+		return []byte(b.String())
+	}
+
+	b.WriteString("[" + sdID)
+	for _, param := range []struct{ name, value string }{
+		{"type", event.Type},
+		{"category", Category(event)},
+		{"subject", event.Subject},
+		{"actor", event.Actor},
+		{"keyId", event.KeyID},
+		{"detail", event.Detail},
+	} {
+		if param.value != "" {
+			fmt.Fprintf(&b, ` %s="%s"`, param.name, sdEscaper.Replace(param.value))
+		}
+	}
+	b.WriteString("] ")
+
+	b.WriteString(name)
+	if event.Subject != "" {
+		b.WriteString(": " + event.Subject)
+	}
+	if event.Actor != "" && event.Actor != event.Subject {
+		b.WriteString(" by " + event.Actor)
+	}
+	if event.Detail != "" {
+		b.WriteString(" (" + event.Detail + ")")
+	}
+	return []byte(b.String())
+}
+
+// CEF returns event in Common Event Format. The mapping is:
+//
+//	signature ID   event type, e.g. verification_failed
+//	name           a description of the type
+//	severity       3 for registrations and rotations, 5 for revocations and
+//	               expiry, 6 for rejected registrations and 7 for failed
+//	               verifications
+//	rt             the time, in milliseconds since the epoch
+//	dvchost        the service that recorded the event
+//	cat            its Category
+//	duser          the service the event concerns (Subject)
+//	suser          the service that caused it (Actor), if another
+//	cs1            the fingerprint of the key concerned, labelled keyId
+//	msg            the detail, e.g. why a signature was rejected
+func CEF(event mesh.AuditEvent) string {
+	name, severity, _ := describe(event.Type)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "CEF:0|quantum-safe-mesh|quantum-safe-mesh|%s|%s|%s|%d|",
+		cefHeaderEscaper.Replace(buildinfo.Version),
+		cefHeaderEscaper.Replace(event.Type),
+		cefHeaderEscaper.Replace(name),
+		severity)
+
+	extensions := []cefExtension{
+		{"rt", strconv.FormatInt(event.Time.UnixMilli(), 10)},
+		{"dvchost", event.Service},
+		{"cat", Category(event)},
+		{"duser", event.Subject},
+	}
+	if event.Actor != event.Subject {
+		extensions = append(extensions, cefExtension{"suser", event.Actor})
+	}
+	if event.KeyID != "" {
+		extensions = append(extensions, cefExtension{"cs1Label", "keyId"}, cefExtension{"cs1", event.KeyID})
+	}
+	extensions = append(extensions, cefExtension{"msg", event.Detail})
+
+	separator := ""
+	for _, extension := range extensions {
+		if extension.value == "" {
+			continue
+		}
+		fmt.Fprintf(&b, "%s%s=%s", separator, extension.key, cefExtensionEscaper.Replace(extension.value))
+		separator = " "
+	}
+	return b.String()
+}
+
+type cefExtension struct {
+	key, value string
+}
+
+var (
+	sdEscaper           = strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`)
+	cefHeaderEscaper    = strings.NewReplacer(`\`, `\\`, `|`, `\|`, "\n", " ", "\r", " ")
+	cefExtensionEscaper = strings.NewReplacer(`\`, `\\`, `=`, `\=`, "\n", `\n`, "\r", `\r`)
+)
+
+// headerField returns value as an RFC 5424 header field: printable ASCII
+// with no spaces, at most max characters, or "-" if empty.
+func headerField(value string, max int) string {
+	field := strings.Map(func(r rune) rune {
+		if r <= ' ' || r > '~' {
+			return '_'
+		}
+		return r
+	}, value)
+	if len(field) > max {
+		field = field[:max]
+	}
+	if field == "" {
+		return "-"
+	}
+	return field
+}
@@ -0,0 +1,142 @@
+package auditsink
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"quantum-safe-mesh/pkg/mesh"
+)
+
+// syslogTimeout bounds connecting to a syslog server and writing an event
+// to it.
+const syslogTimeout = 5 * time.Second
+
+func init() {
+	Register("syslog", openSyslog)
+	Register("syslog+udp", openSyslog)
+	Register("syslog+tcp", openSyslog)
+	Register("syslog+tls", openSyslog)
+	Register("syslog+unix", openSyslog)
+}
+
+// syslogSink sends events to a syslog server, one per UDP or unix datagram,
+// or framed by octet counting (RFC 6587) on a TCP or TLS stream. Streams
+// are connected on first use and reconnected after a failed write.
+type syslogSink struct {
+	network   string
+	address   string
+	tlsConfig *tls.Config
+	formatter *formatter
+
+	mutex sync.Mutex
+	conn  net.Conn
+}
+
+// openSyslog opens a syslog URL: syslog://host[:port] or syslog+udp for
+// UDP (port 514), syslog+tcp for TCP (port 601), syslog+tls for TLS (port
+// 6514) and syslog+unix:///path for a local socket such as /dev/log. The
+// format and facility query parameters pick the message format and syslog
+// facility, and ca a PEM file of CAs a TLS server's certificate is checked
+// against instead of the system's.
+func openSyslog(u *url.URL) (Sink, error) {
+	query := u.Query()
+	formatter, err := newFormatter(query.Get("format"), query.Get("facility"))
+	if err != nil {
+		return nil, err
+	}
+	sink := &syslogSink{formatter: formatter}
+
+	if u.Scheme == "syslog+unix" {
+		if u.Path == "" {
+			return nil, fmt.Errorf("no socket path")
+		}
+		sink.network, sink.address = "unixgram", u.Path
+		return sink, nil
+	}
+
+	if u.Hostname() == "" {
+		return nil, fmt.Errorf("no host")
+	}
+	port := map[string]string{"syslog": "514", "syslog+udp": "514", "syslog+tcp": "601", "syslog+tls": "6514"}[u.Scheme]
+	if u.Port() != "" {
+		port = u.Port()
+		if _, err := strconv.ParseUint(port, 10, 16); err != nil {
+			return nil, fmt.Errorf("invalid port %q", port)
+		}
+	}
+	sink.address = net.JoinHostPort(u.Hostname(), port)
+
+	switch u.Scheme {
+	case "syslog", "syslog+udp":
+		sink.network = "udp"
+	case "syslog+tcp":
+		sink.network = "tcp"
+	case "syslog+tls":
+		sink.network = "tcp"
+		sink.tlsConfig = &tls.Config{ServerName: u.Hostname(), MinVersion: tls.VersionTLS12}
+		if ca := query.Get("ca"); ca != "" {
+			pem, err := os.ReadFile(ca)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read CA file: %w", err)
+			}
+			sink.tlsConfig.RootCAs = x509.NewCertPool()
+			if !sink.tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("no certificates in CA file %s", ca)
+			}
+		}
+	}
+	return sink, nil
+}
+
+func (s *syslogSink) Send(event mesh.AuditEvent) error {
+	message := s.formatter.Format(event)
+	if s.network == "tcp" {
+		message = append([]byte(strconv.Itoa(len(message))+" "), message...)
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.conn == nil {
+		conn, err := s.dial()
+		if err != nil {
+			return fmt.Errorf("failed to connect to %s: %w", s.address, err)
+		}
+		s.conn = conn
+	}
+
+	s.conn.SetWriteDeadline(time.Now().Add(syslogTimeout))
+	if _, err := s.conn.Write(message); err != nil {
+		s.conn.Close()
+		s.conn = nil
+		return fmt.Errorf("failed to write to %s: %w", s.address, err)
+	}
+	return nil
+}
+
+func (s *syslogSink) dial() (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: syslogTimeout}
+	if s.tlsConfig != nil {
+		return tls.DialWithDialer(dialer, s.network, s.address, s.tlsConfig)
+	}
+	return dialer.Dial(s.network, s.address)
+}
+
+func (s *syslogSink) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.conn == nil {
+		return nil
+	}
+	err := s.conn.Close()
+	s.conn = nil
+	return err
+}
@@ -0,0 +1,1305 @@
+// Package auth is the mesh's auth service: the registry of every service's
+// public keys, which services register and rotate their keys with and look
+// each other's up in.
+package auth
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"quantum-safe-mesh/pkg/buildinfo"
+	"quantum-safe-mesh/pkg/cloudattest"
+	"quantum-safe-mesh/pkg/config"
+	"quantum-safe-mesh/pkg/health"
+	"quantum-safe-mesh/pkg/kubeauth"
+	"quantum-safe-mesh/pkg/mesh"
+	"quantum-safe-mesh/pkg/middleware"
+	"quantum-safe-mesh/pkg/models"
+	"quantum-safe-mesh/pkg/pqc"
+	"quantum-safe-mesh/pkg/spiffe"
+	"quantum-safe-mesh/pkg/telemetry"
+)
+
+type AuthService struct {
+	identity        *mesh.Identity
+	server          *mesh.VerifyingServer
+	serviceRegistry map[string][]byte                     // serviceID -> public signing key
+	algorithms      map[string]string                     // serviceID -> signature algorithm of its key
+	spiffeIDs       map[string]string                     // serviceID -> SPIFFE ID its key is bound to
+	addresses       map[string]string                     // serviceID -> advertised base URL
+	registeredAt    map[string]time.Time                  // serviceID -> when its current key was registered
+	rotations       map[string][]models.KeyRotationRecord // serviceID -> signed records of its rotations, oldest first
+	delegations     map[string][]string                   // serviceID -> services that registered it on its behalf, outermost first
+	kyberKeys       map[string][]byte                     // serviceID -> Kyber key brokered sessions are sealed to
+	kemAlgorithms   map[string]string                     // serviceID -> KEM algorithm of its Kyber key
+	builds          map[string]*models.BuildInfo          // serviceID -> build it reported at registration
+	expired         map[string]time.Time                  // serviceID -> registration time of the key last reported expired
+	spiffeMode      string
+	spiffeValidator *spiffe.Validator
+	spiffeIDMap     spiffe.IDMap
+	serviceAccounts map[string]string // serviceID -> Kubernetes service account its key is bound to
+	kubeSAMode      string
+	kubeValidator   kubeauth.Validator
+	kubeIDMap       kubeauth.IDMap
+	cloudIdentities map[string]string // serviceID -> cloud instance its key is bound to
+	cloudMode       string
+	cloudVerifier   *cloudattest.Verifier
+	cloudPolicy     cloudattest.Policy
+	bootstrapMode   string
+	bootstrapIssuer map[string]bool      // service IDs trusted to issue bootstrap tokens
+	admins          map[string]bool      // service IDs allowed to rotate and revoke any key
+	delegators      map[string]bool      // service IDs trusted to register keys on other services' behalf
+	namespaceAdmins mesh.NamespaceAdmins // service IDs that are all of the above, within their namespaces only
+	cluster         *cluster             // nil when running as a single instance
+	presigned       *presignedCache      // signed /public-key responses
+	term, version   uint64               // registry position, see models.RegistrySnapshot
+	snapshotTTL     time.Duration        // validity of signed registry snapshots
+	sessionTTL      time.Duration        // validity of brokered session tickets
+	mutex           sync.RWMutex
+}
+
+func NewAuthService(cfg *config.Config) (*AuthService, error) {
+	log.Println("🚀 Starting Auth Service...")
+
+	identity, err := cfg.Keys.LoadIdentity(cfg.Service.ID, cfg.Crypto.Signature, cfg.Crypto.KEM)
+	if err != nil {
+		return nil, err
+	}
+	// The auth service is its own registry, so rotating its key needs no
+	// /rotate call.
+	if cfg.Keys.RotationDue(identity) {
+		newKey, err := pqc.GenerateSigner(identity.Signer.Algorithm())
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate %s keypair: %w", identity.Signer.Algorithm(), err)
+		}
+		record, err := pqc.SignRotationRecord(identity.ServiceID, identity.Signer, newKey)
+		if err != nil {
+			return nil, err
+		}
+		if err := identity.ReplaceKey(newKey); err != nil {
+			return nil, err
+		}
+		if err := pqc.SaveRotationRecord(identity.ServiceID, record); err != nil {
+			log.Printf("⚠️  Key rotated but its rotation record was not saved: %v", err)
+		}
+	}
+	ownRotations, err := pqc.LoadRotationRecords(identity.ServiceID)
+	if err == nil {
+		err = pqc.VerifyRotationChain(identity.ServiceID, ownRotations, identity.PublicKey())
+	}
+	if err != nil {
+		log.Printf("⚠️  Not serving own rotation records: %v", err)
+		ownRotations = nil
+	}
+
+	as := &AuthService{
+		identity:        identity,
+		serviceRegistry: make(map[string][]byte),
+		algorithms:      make(map[string]string),
+		spiffeIDs:       make(map[string]string),
+		serviceAccounts: make(map[string]string),
+		cloudIdentities: make(map[string]string),
+		addresses:       make(map[string]string),
+		registeredAt:    make(map[string]time.Time),
+		rotations:       make(map[string][]models.KeyRotationRecord),
+		delegations:     make(map[string][]string),
+		kyberKeys:       make(map[string][]byte),
+		kemAlgorithms:   make(map[string]string),
+		builds:          make(map[string]*models.BuildInfo),
+		expired:         make(map[string]time.Time),
+		spiffeMode:      cfg.Policy.SPIFFEMode,
+		kubeSAMode:      cfg.Policy.KubeSAMode,
+		cloudMode:       cfg.Policy.CloudAttestMode,
+		bootstrapMode:   cfg.Policy.BootstrapMode,
+		bootstrapIssuer: make(map[string]bool),
+		admins:          map[string]bool{identity.ServiceID: true},
+		presigned:       newPresignedCache(),
+		snapshotTTL:     cfg.Snapshot.TTL,
+		sessionTTL:      cfg.Crypto.SessionTTL,
+	}
+	as.server = mesh.NewVerifyingServer(identity, as.lookupPublicKey)
+	as.server.UseFreshness(cfg.Timeouts.RequestMaxAge, cfg.Timeouts.ClockSkew)
+	replays, err := mesh.OpenReplayStore(cfg.Replay.Store, identity.ServiceID)
+	if err != nil {
+		return nil, err
+	}
+	as.server.UseReplayStore(replays)
+	as.server.UseAdmission(cfg.Admission.Controller(as.server.Metrics()))
+
+	if as.spiffeMode != spiffe.ModeOff {
+		as.spiffeIDMap, err = spiffe.ParseIDMap(cfg.Policy.SPIFFEIDMap)
+		if err != nil {
+			return nil, fmt.Errorf("invalid SPIFFE_ID_MAP: %w", err)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		as.spiffeValidator, err = spiffe.NewValidator(ctx, cfg.Policy.SPIFFESocket, spiffe.Audience)
+		if err != nil {
+			return nil, fmt.Errorf("failed to connect to SPIRE agent: %w", err)
+		}
+
+		log.Printf("🪪 SPIFFE registration authentication enabled (mode: %s)", as.spiffeMode)
+	}
+
+	if as.kubeSAMode != kubeauth.ModeOff {
+		as.kubeIDMap, err = kubeauth.ParseIDMap(cfg.Policy.KubeSAMap)
+		if err != nil {
+			return nil, fmt.Errorf("invalid K8S_SA_MAP: %w", err)
+		}
+
+		if cfg.Policy.KubeSAIssuer != "" {
+			as.kubeValidator = kubeauth.NewOIDCVerifier(cfg.Policy.KubeSAIssuer)
+		} else if as.kubeValidator, err = kubeauth.NewTokenReviewer(cfg.Policy.KubeSAAPIURL); err != nil {
+			return nil, fmt.Errorf("failed to set up TokenReview: %w", err)
+		}
+
+		log.Printf("☸️  ServiceAccount registration authentication enabled (mode: %s)", as.kubeSAMode)
+	}
+
+	if as.cloudMode != cloudattest.ModeOff {
+		as.cloudPolicy, err = cloudattest.ParsePolicy(cfg.Policy.CloudAttestPolicy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CLOUD_ATTEST_POLICY: %w", err)
+		}
+		as.cloudVerifier, err = cloudattest.NewVerifier(cfg.Policy.CloudAttestAWSCerts, cfg.Policy.CloudAttestAzureTenant, cfg.Policy.CloudAttestAzureResource)
+		if err != nil {
+			return nil, err
+		}
+
+		log.Printf("☁️  Cloud instance identity registration authentication enabled (mode: %s)", as.cloudMode)
+	}
+
+	// The auth service's own key is always an administrator key, so whoever
+	// holds it can recover services that lost theirs.
+	for _, admin := range strings.Split(cfg.Policy.AdminServices, ",") {
+		if admin = strings.TrimSpace(admin); admin != "" {
+			as.admins[admin] = true
+		}
+	}
+
+	// Administrators may delegate too: they could rotate the key anyway.
+	as.delegators = make(map[string]bool)
+	for admin := range as.admins {
+		as.delegators[admin] = true
+	}
+	for _, delegator := range strings.Split(cfg.Policy.Delegators, ",") {
+		if delegator = strings.TrimSpace(delegator); delegator != "" {
+			as.delegators[delegator] = true
+		}
+	}
+
+	if as.namespaceAdmins, err = mesh.ParseNamespaceAdmins(cfg.Policy.NamespaceAdmins); err != nil {
+		return nil, fmt.Errorf("invalid NAMESPACE_ADMINS: %w", err)
+	}
+	if len(as.namespaceAdmins) > 0 {
+		log.Printf("🏢 %d namespace administrators configured", len(as.namespaceAdmins))
+	}
+
+	if as.bootstrapMode != mesh.BootstrapOff {
+		// Tokens signed with an administrator key, such as the auth service's
+		// own, let its holder bootstrap the first issuers, e.g. with
+		// 'meshctl token'.
+		for admin := range as.admins {
+			as.bootstrapIssuer[admin] = true
+		}
+		for _, issuer := range strings.Split(cfg.Policy.BootstrapIssuers, ",") {
+			if issuer = strings.TrimSpace(issuer); issuer != "" {
+				as.bootstrapIssuer[issuer] = true
+			}
+		}
+		log.Printf("🎟️  Bootstrap token authentication enabled (mode: %s, %d trusted issuers)", as.bootstrapMode, len(as.bootstrapIssuer))
+	}
+
+	as.serviceRegistry[identity.ServiceID] = identity.PublicKey()
+	as.algorithms[identity.ServiceID] = identity.Signer.Algorithm()
+	as.registeredAt[identity.ServiceID] = time.Now()
+	build := buildinfo.Get(identity.Signer.Algorithm(), cfg.Crypto.KEM)
+	as.builds[identity.ServiceID] = &build
+	if len(ownRotations) > 0 {
+		as.rotations[identity.ServiceID] = ownRotations
+	}
+
+	if peers := cfg.ClusterPeers(); len(peers) > 0 {
+		as.cluster = newCluster(as, cfg.Service.AdvertiseURL, peers, cfg.Cluster.Heartbeat, cfg.Timeouts.Client)
+	}
+
+	log.Printf("✅ Auth Service initialized with ID: %s", identity.ServiceID)
+	return as, nil
+}
+
+// lookupPublicKey resolves a registered service's key from the local
+// registry. It satisfies pqc.PublicKeyLookup.
+func (as *AuthService) lookupPublicKey(serviceID string) ([]byte, error) {
+	as.mutex.RLock()
+	defer as.mutex.RUnlock()
+
+	publicKey, exists := as.serviceRegistry[serviceID]
+	if !exists {
+		return nil, fmt.Errorf("service not registered: %s", serviceID)
+	}
+	return publicKey, nil
+}
+
+// envelopeOnly refuses requests for h signed in detached mode. A detached
+// JWS does not cover the HTTP headers registration credentials travel in,
+// so they could be added to or stripped from a captured request.
+func envelopeOnly(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(models.HeaderMeshSignature) != "" {
+			log.Printf("❌ Detached-mode %s request refused", r.URL.Path)
+			models.WriteError(w, r, http.StatusBadRequest, models.ErrCodeInvalidRequest, "Requests to this endpoint must be signed envelopes")
+			return
+		}
+		h(w, r)
+	}
+}
+
+// credential returns the registration credential in header name. Clients
+// carry credentials in the headers of the signed envelope, so they travel
+// with the request they were presented for.
+func credential(req *mesh.Request, name string) string {
+	if value, ok := req.Envelope.Headers[name]; ok {
+		return value
+	}
+	return req.Envelope.Headers[http.CanonicalHeaderKey(name)]
+}
+
+// registrationBuild returns the build a service reported registering
+// itself, from the registration or, from services that sent it before
+// negotiating protocol 1.3, its signed headers. Delegated registrations
+// report none: the delegator is not running the workload's build.
+func registrationBuild(req *mesh.Request, keyPair *models.ServiceKeyPair) *models.BuildInfo {
+	if len(keyPair.Delegation) > 0 {
+		return nil
+	}
+	if keyPair.Build != nil {
+		return keyPair.Build
+	}
+	encoded := credential(req, models.HeaderBuildInfo)
+	if encoded == "" {
+		return nil
+	}
+	var build models.BuildInfo
+	if err := json.Unmarshal([]byte(encoded), &build); err != nil {
+		log.Printf("⚠️  Ignoring malformed build info of %s: %v", keyPair.ServiceID, err)
+		return nil
+	}
+	return &build
+}
+
+// registrationKey returns the service a registration is for and the key it
+// registers, which a service registering itself signs the request with.
+// It is the server's mesh.ClaimedKey for /register.
+func registrationKey(data []byte) (string, []byte) {
+	var keyPair models.ServiceKeyPair
+	if err := json.Unmarshal(data, &keyPair); err != nil {
+		return "", nil
+	}
+	return keyPair.ServiceID, keyPair.PublicKey
+}
+
+// authenticateRegistration checks the JWT-SVID presented with a registration
+// and returns the SPIFFE ID to bind serviceID's key to, if any. Once a
+// service is bound, re-registering it (rotating its key) always needs an
+// SVID for the same identity.
+func (as *AuthService) authenticateRegistration(req *mesh.Request, serviceID string) (string, error) {
+	if as.spiffeValidator == nil {
+		return "", nil
+	}
+
+	token, hasBearer := strings.CutPrefix(credential(req, "Authorization"), "Bearer ")
+	if !hasBearer || token == "" {
+		as.mutex.RLock()
+		boundID := as.spiffeIDs[serviceID]
+		as.mutex.RUnlock()
+
+		if as.spiffeMode == spiffe.ModeRequired || boundID != "" {
+			log.Printf("❌ Registration for %s without SPIFFE credentials", serviceID)
+			return "", models.NewError(http.StatusUnauthorized, models.ErrCodeUnauthenticated, "SPIFFE credentials required")
+		}
+		return "", nil
+	}
+
+	spiffeID, err := as.spiffeValidator.Validate(token)
+	if err != nil {
+		log.Printf("❌ Registration for %s with invalid SVID: %v", serviceID, err)
+		return "", models.NewError(http.StatusUnauthorized, models.ErrCodeUnauthenticated, "Invalid SPIFFE credentials")
+	}
+
+	mappedID, err := as.spiffeIDMap.ServiceID(spiffeID)
+	if err != nil || mappedID != serviceID {
+		log.Printf("❌ SPIFFE ID %s may not register as %s", spiffeID, serviceID)
+		return "", models.NewError(http.StatusForbidden, models.ErrCodeIdentityMismatch, "SPIFFE ID does not match service ID")
+	}
+
+	return spiffeID, nil
+}
+
+// authenticateServiceAccount checks the projected ServiceAccount token
+// presented with a registration and returns the service account to bind
+// serviceID's key to, if any. As with SPIFFE, once a service is bound,
+// re-registering it always needs a token for the same service account.
+func (as *AuthService) authenticateServiceAccount(req *mesh.Request, serviceID string) (string, error) {
+	if as.kubeValidator == nil {
+		return "", nil
+	}
+
+	token := credential(req, models.HeaderServiceAccountToken)
+	if token == "" {
+		as.mutex.RLock()
+		bound := as.serviceAccounts[serviceID]
+		as.mutex.RUnlock()
+
+		if as.kubeSAMode == kubeauth.ModeRequired || bound != "" {
+			log.Printf("❌ Registration for %s without ServiceAccount token", serviceID)
+			return "", models.NewError(http.StatusUnauthorized, models.ErrCodeUnauthenticated, "ServiceAccount token required")
+		}
+		return "", nil
+	}
+
+	serviceAccount, err := as.kubeValidator.Validate(token)
+	if err != nil {
+		log.Printf("❌ Registration for %s with invalid ServiceAccount token: %v", serviceID, err)
+		return "", models.NewError(http.StatusUnauthorized, models.ErrCodeUnauthenticated, "Invalid ServiceAccount token")
+	}
+
+	mappedID, err := as.kubeIDMap.ServiceID(serviceAccount)
+	if err != nil || mappedID != serviceID {
+		log.Printf("❌ Service account %s may not register as %s", serviceAccount, serviceID)
+		return "", models.NewError(http.StatusForbidden, models.ErrCodeIdentityMismatch, "Service account does not match service ID")
+	}
+
+	return serviceAccount, nil
+}
+
+// authenticateCloudIdentity checks the instance identity attestation
+// presented with the registration of keyPair and returns the instance to
+// bind its key to, if any. As with SPIFFE, once a service is bound,
+// re-registering it always needs an attestation from an instance the
+// policy lets register as it.
+func (as *AuthService) authenticateCloudIdentity(req *mesh.Request, keyPair *models.ServiceKeyPair) (string, error) {
+	if as.cloudVerifier == nil {
+		return "", nil
+	}
+
+	serviceID := keyPair.ServiceID
+	as.mutex.RLock()
+	bound := as.cloudIdentities[serviceID]
+	as.mutex.RUnlock()
+
+	attestation := credential(req, models.HeaderCloudAttestation)
+	if attestation == "" {
+		if as.cloudMode == cloudattest.ModeRequired || bound != "" {
+			log.Printf("❌ Registration for %s without instance identity", serviceID)
+			return "", models.NewError(http.StatusUnauthorized, models.ErrCodeUnauthenticated, "Instance identity attestation required")
+		}
+		return "", nil
+	}
+
+	instance, err := as.cloudVerifier.Verify(attestation, keyPair.PublicKey)
+	if err != nil {
+		log.Printf("❌ Registration for %s with invalid instance identity: %v", serviceID, err)
+		return "", models.NewError(http.StatusUnauthorized, models.ErrCodeUnauthenticated, "Invalid instance identity attestation")
+	}
+
+	if !as.cloudPolicy.Allows(instance, serviceID) {
+		log.Printf("❌ Instance %s may not register as %s", instance, serviceID)
+		return "", models.NewError(http.StatusForbidden, models.ErrCodeIdentityMismatch, "Instance identity does not match service ID")
+	}
+
+	return instance, nil
+}
+
+// authenticateBootstrap checks the bootstrap token presented with a
+// registration and returns its issuer, if any. Administrators of the
+// service's namespace may issue tokens as well as the trusted issuers. Under BootstrapRequired only
+// a service re-registering the key it already has may go without one.
+func (as *AuthService) authenticateBootstrap(req *mesh.Request, keyPair *models.ServiceKeyPair) (string, error) {
+	if as.bootstrapMode == mesh.BootstrapOff {
+		return "", nil
+	}
+
+	token := credential(req, models.HeaderBootstrapToken)
+	if token == "" {
+		as.mutex.RLock()
+		registeredKey, exists := as.serviceRegistry[keyPair.ServiceID]
+		as.mutex.RUnlock()
+
+		if as.bootstrapMode == mesh.BootstrapRequired && !(exists && bytes.Equal(registeredKey, keyPair.PublicKey)) {
+			log.Printf("❌ Registration for %s without bootstrap token", keyPair.ServiceID)
+			return "", models.NewError(http.StatusUnauthorized, models.ErrCodeUnauthenticated, "Bootstrap token required")
+		}
+		return "", nil
+	}
+
+	claims, err := mesh.VerifyBootstrapToken(token, as.lookupPublicKey)
+	if err != nil {
+		log.Printf("❌ Registration for %s with invalid bootstrap token: %v", keyPair.ServiceID, err)
+		return "", models.NewError(http.StatusUnauthorized, models.ErrCodeUnauthenticated, "Invalid bootstrap token")
+	}
+
+	if !as.bootstrapIssuer[claims.Issuer] && !as.namespaceAdministers(claims.Issuer, keyPair.ServiceID) {
+		log.Printf("❌ Bootstrap token for %s issued by untrusted %s", keyPair.ServiceID, claims.Issuer)
+		return "", models.NewError(http.StatusForbidden, models.ErrCodeIdentityMismatch, "Bootstrap token issuer is not trusted")
+	}
+	if claims.Subject != keyPair.ServiceID {
+		log.Printf("❌ Bootstrap token for %s presented by %s", claims.Subject, keyPair.ServiceID)
+		return "", models.NewError(http.StatusForbidden, models.ErrCodeIdentityMismatch, "Bootstrap token was issued for another service")
+	}
+
+	return claims.Issuer, nil
+}
+
+// authenticateDelegation checks the delegation chain a registration carries
+// and returns its delegators, outermost first. The outermost must be a
+// trusted delegator, or an administrator of the service's namespace. A
+// delegated registration may only replace a key that the same delegator
+// registered, unless it administers the service, so a delegator cannot take
+// over services that register themselves.
+func (as *AuthService) authenticateDelegation(keyPair *models.ServiceKeyPair, algorithm string) ([]string, error) {
+	delegators, err := mesh.VerifyDelegation(keyPair.Delegation, keyPair.ServiceID, keyPair.PublicKey, algorithm, as.lookupPublicKey, time.Now())
+	if err != nil {
+		log.Printf("❌ Registration for %s with invalid delegation: %v", keyPair.ServiceID, err)
+		return nil, models.NewError(http.StatusUnauthorized, models.ErrCodeUnauthenticated, "Invalid delegation")
+	}
+	if !as.delegators[delegators[0]] && !as.namespaceAdministers(delegators[0], keyPair.ServiceID) {
+		log.Printf("❌ Delegation for %s starts at untrusted %s", keyPair.ServiceID, delegators[0])
+		return nil, models.NewError(http.StatusForbidden, models.ErrCodeIdentityMismatch, "Delegator is not trusted")
+	}
+
+	delegator := delegators[len(delegators)-1]
+	as.mutex.RLock()
+	registeredKey, exists := as.serviceRegistry[keyPair.ServiceID]
+	previous := as.delegations[keyPair.ServiceID]
+	as.mutex.RUnlock()
+	if exists && !bytes.Equal(registeredKey, keyPair.PublicKey) && !as.administers(delegator, keyPair.ServiceID) &&
+		(len(previous) == 0 || previous[len(previous)-1] != delegator) {
+		log.Printf("❌ %s may not replace the key of %s, which it did not register", delegator, keyPair.ServiceID)
+		return nil, models.NewError(http.StatusForbidden, models.ErrCodeIdentityMismatch, "Delegators may only replace keys they registered")
+	}
+	return delegators, nil
+}
+
+// administers reports whether serviceID may manage target's key: it is an
+// administrator, or one of target's namespace.
+func (as *AuthService) administers(serviceID, target string) bool {
+	return as.admins[serviceID] || as.namespaceAdministers(serviceID, target)
+}
+
+// namespaceAdministers reports whether serviceID is a namespace
+// administrator of target's namespace, and target is not privileged.
+func (as *AuthService) namespaceAdministers(serviceID, target string) bool {
+	return as.namespaceAdmins.Administers(serviceID, target) && !as.privileged(target)
+}
+
+// privileged reports whether serviceID is the auth service or is trusted
+// across namespaces: an administrator, delegator or bootstrap token issuer.
+// Its key vouches for others everywhere, so no namespace administrator may
+// manage it, even when it falls in their namespace.
+func (as *AuthService) privileged(serviceID string) bool {
+	return serviceID == as.identity.ServiceID || as.admins[serviceID] || as.delegators[serviceID] || as.bootstrapIssuer[serviceID]
+}
+
+// auditRejectedRegistration records that serviceID's registration failed
+// authentication with err.
+func (as *AuthService) auditRejectedRegistration(serviceID string, err error) {
+	as.server.Audit().Record(mesh.AuditEvent{
+		Type:    mesh.AuditRegistrationRejected,
+		Subject: serviceID,
+		Detail:  err.Error(),
+	})
+}
+
+// validAddress reports whether address is an absolute http or https URL.
+func validAddress(address string) bool {
+	parsed, err := url.Parse(address)
+	return err == nil && (parsed.Scheme == "http" || parsed.Scheme == "https") && parsed.Host != ""
+}
+
+func (as *AuthService) registerService(req *mesh.Request) (interface{}, error) {
+	log.Println("📝 Received service registration request")
+
+	var keyPair models.ServiceKeyPair
+	if err := json.Unmarshal(req.Envelope.Data, &keyPair); err != nil {
+		log.Printf("❌ Invalid registration request: %v", err)
+		return nil, models.NewError(http.StatusBadRequest, models.ErrCodeInvalidRequest, "Invalid request body")
+	}
+
+	if !models.IsSupportedProtocolVersion(keyPair.ProtocolVersion) {
+		log.Printf("❌ Unsupported protocol version from %s: %s", keyPair.ServiceID, keyPair.ProtocolVersion)
+		return nil, models.NewError(http.StatusBadRequest, models.ErrCodeUnsupportedVersion, "Unsupported protocol version")
+	}
+
+	if err := mesh.ValidateServiceID(keyPair.ServiceID); err != nil {
+		log.Printf("❌ Registration refused: %v", err)
+		return nil, models.NewError(http.StatusBadRequest, models.ErrCodeInvalidRequest, "Invalid service ID")
+	}
+	if keyPair.ServiceID == as.identity.ServiceID {
+		log.Printf("❌ Registration for the auth service's own ID %s refused", keyPair.ServiceID)
+		err := models.NewError(http.StatusBadRequest, models.ErrCodeInvalidRequest, "The auth service cannot be registered")
+		as.auditRejectedRegistration(keyPair.ServiceID, err)
+		return nil, err
+	}
+
+	// A service registers itself with a request signed by the key it
+	// registers; a delegated registration is signed by the last delegator.
+	signer := keyPair.ServiceID
+	if len(keyPair.Delegation) > 0 {
+		signer = keyPair.Delegation[len(keyPair.Delegation)-1].Delegator
+	}
+	if req.Envelope.ServiceID != signer {
+		log.Printf("❌ Registration for %s signed by %s", keyPair.ServiceID, req.Envelope.ServiceID)
+		err := models.NewError(http.StatusForbidden, models.ErrCodeIdentityMismatch, "Registration must be signed by the service or its delegator")
+		as.auditRejectedRegistration(keyPair.ServiceID, err)
+		return nil, err
+	}
+
+	if keyPair.Address != "" && !validAddress(keyPair.Address) {
+		log.Printf("❌ Invalid address advertised by %s: %q", keyPair.ServiceID, keyPair.Address)
+		return nil, models.NewError(http.StatusBadRequest, models.ErrCodeInvalidRequest, "Address must be an http or https URL")
+	}
+
+	algorithm, err := pqc.SignatureAlgorithm(keyPair.Algorithm)
+	if err != nil {
+		log.Printf("❌ Registration for %s: %v", keyPair.ServiceID, err)
+		return nil, models.NewError(http.StatusBadRequest, models.ErrCodeInvalidRequest, "Unsupported signature algorithm")
+	}
+	kemAlgorithm, err := pqc.KEMAlgorithm(keyPair.KEMAlgorithm)
+	if err != nil {
+		log.Printf("❌ Registration for %s: %v", keyPair.ServiceID, err)
+		return nil, models.NewError(http.StatusBadRequest, models.ErrCodeInvalidRequest, "Unsupported KEM algorithm")
+	}
+
+	// A delegated registration is vouched for by its delegators instead of
+	// the service's own SVID or bootstrap token. A ServiceAccount token or
+	// instance identity attests the service as a bootstrap token would, so
+	// it needs none.
+	var spiffeID, serviceAccount, cloudIdentity, issuer string
+	var delegatedBy []string
+	if len(keyPair.Delegation) > 0 {
+		delegatedBy, err = as.authenticateDelegation(&keyPair, algorithm)
+		if err == nil {
+			issuer = delegatedBy[len(delegatedBy)-1]
+		}
+	} else if spiffeID, err = as.authenticateRegistration(req, keyPair.ServiceID); err == nil {
+		serviceAccount, err = as.authenticateServiceAccount(req, keyPair.ServiceID)
+		if err == nil {
+			cloudIdentity, err = as.authenticateCloudIdentity(req, &keyPair)
+		}
+		if err == nil && serviceAccount == "" && cloudIdentity == "" {
+			issuer, err = as.authenticateBootstrap(req, &keyPair)
+		}
+	}
+	if err != nil {
+		as.auditRejectedRegistration(keyPair.ServiceID, err)
+		return nil, err
+	}
+
+	// Records that do not lead to the registered key, e.g. because an
+	// administrator replaced it since, are dropped rather than served.
+	rotations := keyPair.Rotations
+	if err := pqc.VerifyRotationChain(keyPair.ServiceID, rotations, keyPair.PublicKey); err != nil {
+		log.Printf("⚠️  Ignoring rotation records of %s: %v", keyPair.ServiceID, err)
+		rotations = nil
+	}
+
+	build := registrationBuild(req, &keyPair)
+
+	// Signing with the new key only proves possession of it, so replacing a
+	// registered key also needs a bootstrap token, attestation or delegation
+	// vouching for it. Otherwise the change goes through /rotate, signed with
+	// the current key.
+	vouched := issuer != "" || spiffeID != "" || serviceAccount != "" || cloudIdentity != ""
+
+	as.mutex.Lock()
+	oldPublicKey, exists := as.serviceRegistry[keyPair.ServiceID]
+	keyChanged := !exists || !bytes.Equal(oldPublicKey, keyPair.PublicKey) || as.algorithms[keyPair.ServiceID] != algorithm
+	if exists && keyChanged && !vouched {
+		as.mutex.Unlock()
+		log.Printf("❌ Registration for %s would replace its key %s unvouched", keyPair.ServiceID, pqc.KeyFingerprint(oldPublicKey))
+		err := models.NewError(http.StatusForbidden, models.ErrCodeIdentityMismatch, "Service is registered with another key; rotate it with /rotate")
+		as.auditRejectedRegistration(keyPair.ServiceID, err)
+		return nil, err
+	}
+	as.serviceRegistry[keyPair.ServiceID] = keyPair.PublicKey
+	as.algorithms[keyPair.ServiceID] = algorithm
+	if keyChanged {
+		as.registeredAt[keyPair.ServiceID] = time.Now()
+		delete(as.rotations, keyPair.ServiceID)
+		delete(as.delegations, keyPair.ServiceID)
+	}
+	if len(delegatedBy) > 0 {
+		as.delegations[keyPair.ServiceID] = delegatedBy
+	}
+	if len(rotations) > 0 {
+		as.rotations[keyPair.ServiceID] = rotations
+	}
+	if spiffeID != "" {
+		as.spiffeIDs[keyPair.ServiceID] = spiffeID
+	}
+	if serviceAccount != "" {
+		as.serviceAccounts[keyPair.ServiceID] = serviceAccount
+	}
+	if cloudIdentity != "" {
+		as.cloudIdentities[keyPair.ServiceID] = cloudIdentity
+	}
+	if len(keyPair.KyberPublicKey) > 0 {
+		as.kyberKeys[keyPair.ServiceID] = keyPair.KyberPublicKey
+		as.kemAlgorithms[keyPair.ServiceID] = kemAlgorithm
+	} else {
+		delete(as.kyberKeys, keyPair.ServiceID)
+		delete(as.kemAlgorithms, keyPair.ServiceID)
+	}
+	if keyPair.Address != "" {
+		as.addresses[keyPair.ServiceID] = keyPair.Address
+	} else {
+		delete(as.addresses, keyPair.ServiceID)
+	}
+	if build != nil {
+		as.builds[keyPair.ServiceID] = build
+	} else {
+		delete(as.builds, keyPair.ServiceID)
+	}
+	as.version++
+	as.mutex.Unlock()
+
+	log.Printf("✅ Service registered: %s (%s)", keyPair.ServiceID, algorithm)
+	if spiffeID != "" {
+		log.Printf("🪪 %s bound to SPIFFE ID %s", keyPair.ServiceID, spiffeID)
+	}
+	if serviceAccount != "" {
+		log.Printf("☸️  %s bound to service account %s", keyPair.ServiceID, serviceAccount)
+	}
+	if cloudIdentity != "" {
+		log.Printf("☁️  %s bound to instance %s", keyPair.ServiceID, cloudIdentity)
+	}
+	if len(delegatedBy) > 0 {
+		log.Printf("🤝 %s registered on its behalf by %s", keyPair.ServiceID, strings.Join(delegatedBy, " → "))
+	} else if issuer != "" {
+		log.Printf("🎟️  %s vouched for by %s", keyPair.ServiceID, issuer)
+	}
+	if keyPair.Address != "" {
+		log.Printf("📍 %s advertised at %s", keyPair.ServiceID, keyPair.Address)
+	}
+	if build != nil {
+		log.Printf("🏷️  %s is running %s (commit %s)", keyPair.ServiceID, build.Version, build.Commit)
+	}
+
+	detail := "new service"
+	switch {
+	case exists && keyChanged:
+		detail = "replaced key " + pqc.KeyFingerprint(oldPublicKey)
+	case exists:
+		detail = "re-registered same key"
+	}
+	if len(delegatedBy) > 0 {
+		detail += ", delegated by " + strings.Join(delegatedBy, " → ")
+	}
+	as.server.Audit().Record(mesh.AuditEvent{
+		Type:    mesh.AuditRegistered,
+		Subject: keyPair.ServiceID,
+		Actor:   issuer,
+		KeyID:   pqc.KeyFingerprint(keyPair.PublicKey),
+		Detail:  detail,
+	})
+
+	response := map[string]interface{}{
+		"status":     "success",
+		"service_id": keyPair.ServiceID,
+		"message":    "Service registered successfully",
+		"timestamp":  time.Now(),
+	}
+
+	return response, nil
+}
+
+// rotateKey replaces a service's key. The request must be signed with the
+// current key, or by an administrator, and carry a proof made with the new
+// one.
+func (as *AuthService) rotateKey(req *mesh.Request) (interface{}, error) {
+	var rotation models.KeyRotationRequest
+	if err := json.Unmarshal(req.Envelope.Data, &rotation); err != nil {
+		log.Printf("❌ Invalid rotation request: %v", err)
+		return nil, models.NewError(http.StatusBadRequest, models.ErrCodeInvalidRequest, "Invalid request body")
+	}
+
+	log.Printf("🔄 Key rotation requested for service: %s", rotation.ServiceID)
+
+	if req.Envelope.ServiceID != rotation.ServiceID {
+		if !as.administers(req.Envelope.ServiceID, rotation.ServiceID) {
+			log.Printf("❌ %s may not rotate the key of %s", req.Envelope.ServiceID, rotation.ServiceID)
+			return nil, models.NewError(http.StatusForbidden, models.ErrCodeIdentityMismatch, "Services may only rotate their own key")
+		}
+		log.Printf("🛡️  %s rotating the key of %s as administrator", req.Envelope.ServiceID, rotation.ServiceID)
+	}
+	if rotation.ServiceID == as.identity.ServiceID {
+		return nil, models.NewError(http.StatusBadRequest, models.ErrCodeInvalidRequest, "The auth service's key cannot be rotated")
+	}
+
+	algorithm, err := as.checkRotation(req, &rotation)
+	if err != nil {
+		return nil, err
+	}
+
+	as.mutex.Lock()
+	oldPublicKey, exists := as.serviceRegistry[rotation.ServiceID]
+	oldAlgorithm := as.algorithms[rotation.ServiceID]
+	if exists {
+		if err = as.applyRotation(&rotation, algorithm); err == nil {
+			as.version++
+		}
+	}
+	as.mutex.Unlock()
+
+	if !exists {
+		log.Printf("❌ Rotation for unregistered service %s", rotation.ServiceID)
+		return nil, models.NewError(http.StatusNotFound, models.ErrCodeServiceNotFound, "Service not found")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("✅ Key rotated for %s: %s -> %s", rotation.ServiceID,
+		pqc.KeyFingerprint(oldPublicKey), pqc.KeyFingerprint(rotation.NewPublicKey))
+	detail := "replaced key " + pqc.KeyFingerprint(oldPublicKey)
+	if algorithm != oldAlgorithm {
+		log.Printf("🔀 %s now signs with %s instead of %s", rotation.ServiceID, algorithm, oldAlgorithm)
+		detail += ", " + oldAlgorithm + " -> " + algorithm
+	}
+	as.server.Audit().Record(mesh.AuditEvent{
+		Type:    mesh.AuditRotated,
+		Subject: rotation.ServiceID,
+		Actor:   req.Envelope.ServiceID,
+		KeyID:   pqc.KeyFingerprint(rotation.NewPublicKey),
+		Detail:  detail,
+	})
+
+	return map[string]interface{}{
+		"status":          "success",
+		"service_id":      rotation.ServiceID,
+		"old_fingerprint": pqc.KeyFingerprint(oldPublicKey),
+		"new_fingerprint": pqc.KeyFingerprint(rotation.NewPublicKey),
+		"authorized_by":   req.Envelope.ServiceID,
+		"timestamp":       time.Now(),
+	}, nil
+}
+
+// checkRotation checks a rotation's new key and rotation record, and returns
+// the new key's algorithm.
+func (as *AuthService) checkRotation(req *mesh.Request, rotation *models.KeyRotationRequest) (string, error) {
+	algorithm, err := pqc.SignatureAlgorithm(rotation.Algorithm)
+	if err != nil {
+		log.Printf("❌ Rotation for %s: %v", rotation.ServiceID, err)
+		return "", models.NewError(http.StatusBadRequest, models.ErrCodeInvalidRequest, "Unsupported signature algorithm")
+	}
+
+	proofPayload, err := rotation.ProofPayload()
+	if err != nil {
+		return "", err
+	}
+	if err := telemetry.Verify(req.Context(), algorithm, rotation.NewPublicKey, proofPayload, rotation.Proof); err != nil {
+		log.Printf("❌ Invalid proof of possession for new key of %s: %v", rotation.ServiceID, err)
+		return "", models.NewError(http.StatusBadRequest, models.ErrCodeInvalidSignature, "Invalid proof of possession for new key")
+	}
+	if record := rotation.Record; record != nil {
+		if err := pqc.VerifyRotationChain(rotation.ServiceID, []models.KeyRotationRecord{*record}, rotation.NewPublicKey); err != nil {
+			log.Printf("❌ Invalid rotation record for %s: %v", rotation.ServiceID, err)
+			return "", models.NewError(http.StatusBadRequest, models.ErrCodeInvalidSignature, "Invalid rotation record")
+		}
+	}
+	return algorithm, nil
+}
+
+// applyRotation registers a checked rotation's new key for a registered
+// service. The caller holds the write lock.
+func (as *AuthService) applyRotation(rotation *models.KeyRotationRequest, algorithm string) error {
+	oldPublicKey := as.serviceRegistry[rotation.ServiceID]
+	if rotation.Record != nil && !bytes.Equal(rotation.Record.OldPublicKey, oldPublicKey) {
+		log.Printf("❌ Rotation record for %s starts from key %s, not the registered %s", rotation.ServiceID,
+			pqc.KeyFingerprint(rotation.Record.OldPublicKey), pqc.KeyFingerprint(oldPublicKey))
+		return models.NewError(http.StatusBadRequest, models.ErrCodeInvalidSignature, "Rotation record does not start from the registered key")
+	}
+	as.serviceRegistry[rotation.ServiceID] = rotation.NewPublicKey
+	as.algorithms[rotation.ServiceID] = algorithm
+	as.registeredAt[rotation.ServiceID] = time.Now()
+	if rotation.Record != nil {
+		as.rotations[rotation.ServiceID] = pqc.AppendRotationRecord(as.rotations[rotation.ServiceID], *rotation.Record)
+	}
+	return nil
+}
+
+// migrateKeys moves a service's keys to other algorithms, or back to the
+// keys a migration replaced: it rotates the signing key as rotateKey does
+// and swaps the KEM key in the same step, so no lookup sees half a
+// migration. The request must be signed with the current key, or by an
+// administrator.
+func (as *AuthService) migrateKeys(req *mesh.Request) (interface{}, error) {
+	var migration models.KeyMigrationRequest
+	if err := json.Unmarshal(req.Envelope.Data, &migration); err != nil {
+		log.Printf("❌ Invalid migration request: %v", err)
+		return nil, models.NewError(http.StatusBadRequest, models.ErrCodeInvalidRequest, "Invalid request body")
+	}
+
+	action := "migrated"
+	if migration.Rollback {
+		action = "rolled back"
+		log.Printf("⏪ Key rollback requested for service: %s", migration.ServiceID)
+	} else {
+		log.Printf("🔀 Key migration requested for service: %s", migration.ServiceID)
+	}
+
+	if req.Envelope.ServiceID != migration.ServiceID {
+		if !as.administers(req.Envelope.ServiceID, migration.ServiceID) {
+			log.Printf("❌ %s may not migrate the keys of %s", req.Envelope.ServiceID, migration.ServiceID)
+			return nil, models.NewError(http.StatusForbidden, models.ErrCodeIdentityMismatch, "Services may only migrate their own keys")
+		}
+		log.Printf("🛡️  %s migrating the keys of %s as administrator", req.Envelope.ServiceID, migration.ServiceID)
+	}
+	if migration.ServiceID == as.identity.ServiceID {
+		return nil, models.NewError(http.StatusBadRequest, models.ErrCodeInvalidRequest, "The auth service's keys cannot be migrated")
+	}
+
+	rotation := migration.Rotation
+	if rotation == nil && len(migration.KEMPublicKey) == 0 {
+		return nil, models.NewError(http.StatusBadRequest, models.ErrCodeInvalidRequest, "Migration replaces no key")
+	}
+	var algorithm string
+	if rotation != nil {
+		if rotation.ServiceID != migration.ServiceID {
+			return nil, models.NewError(http.StatusBadRequest, models.ErrCodeInvalidRequest, "Rotation is for another service")
+		}
+		var err error
+		if algorithm, err = as.checkRotation(req, rotation); err != nil {
+			return nil, err
+		}
+	}
+	var kemAlgorithm string
+	if len(migration.KEMPublicKey) > 0 {
+		var err error
+		if kemAlgorithm, err = pqc.KEMAlgorithm(migration.KEMAlgorithm); err != nil {
+			log.Printf("❌ Migration for %s: %v", migration.ServiceID, err)
+			return nil, models.NewError(http.StatusBadRequest, models.ErrCodeInvalidRequest, "Unsupported KEM algorithm")
+		}
+		if err := pqc.CheckKEMPublicKey(kemAlgorithm, migration.KEMPublicKey); err != nil {
+			log.Printf("❌ Invalid KEM key for %s: %v", migration.ServiceID, err)
+			return nil, models.NewError(http.StatusBadRequest, models.ErrCodeInvalidRequest, "Invalid KEM public key")
+		}
+	}
+
+	as.mutex.Lock()
+	oldPublicKey, exists := as.serviceRegistry[migration.ServiceID]
+	oldAlgorithm := as.algorithms[migration.ServiceID]
+	oldKEMAlgorithm := as.kemAlgorithms[migration.ServiceID]
+	var err error
+	if exists && rotation != nil {
+		err = as.applyRotation(rotation, algorithm)
+	}
+	if exists && err == nil {
+		if kemAlgorithm != "" {
+			as.kyberKeys[migration.ServiceID] = migration.KEMPublicKey
+			as.kemAlgorithms[migration.ServiceID] = kemAlgorithm
+		}
+		as.version++
+	}
+	publicKey := as.serviceRegistry[migration.ServiceID]
+	as.mutex.Unlock()
+
+	if !exists {
+		log.Printf("❌ Migration for unregistered service %s", migration.ServiceID)
+		return nil, models.NewError(http.StatusNotFound, models.ErrCodeServiceNotFound, "Service not found")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	detail := action
+	if rotation != nil {
+		log.Printf("✅ Key %s for %s: %s -> %s (%s -> %s)", action, migration.ServiceID,
+			pqc.KeyFingerprint(oldPublicKey), pqc.KeyFingerprint(publicKey), oldAlgorithm, algorithm)
+		detail += fmt.Sprintf(", replaced key %s, %s -> %s", pqc.KeyFingerprint(oldPublicKey), oldAlgorithm, algorithm)
+	}
+	if kemAlgorithm != "" {
+		if oldKEMAlgorithm == "" {
+			oldKEMAlgorithm = "none"
+		}
+		log.Printf("✅ KEM key %s for %s: %s -> %s", action, migration.ServiceID, oldKEMAlgorithm, kemAlgorithm)
+		detail += fmt.Sprintf(", KEM %s -> %s", oldKEMAlgorithm, kemAlgorithm)
+	}
+	as.server.Audit().Record(mesh.AuditEvent{
+		Type:    mesh.AuditRotated,
+		Subject: migration.ServiceID,
+		Actor:   req.Envelope.ServiceID,
+		KeyID:   pqc.KeyFingerprint(publicKey),
+		Detail:  detail,
+	})
+
+	return map[string]interface{}{
+		"status":          "success",
+		"service_id":      migration.ServiceID,
+		"old_fingerprint": pqc.KeyFingerprint(oldPublicKey),
+		"new_fingerprint": pqc.KeyFingerprint(publicKey),
+		"rollback":        migration.Rollback,
+		"authorized_by":   req.Envelope.ServiceID,
+		"timestamp":       time.Now(),
+	}, nil
+}
+
+// revokeService removes a service from the registry. The request must be
+// signed with the key being revoked, or by an administrator.
+func (as *AuthService) revokeService(req *mesh.Request) (interface{}, error) {
+	var revocation models.RevocationRequest
+	if err := json.Unmarshal(req.Envelope.Data, &revocation); err != nil {
+		log.Printf("❌ Invalid revocation request: %v", err)
+		return nil, models.NewError(http.StatusBadRequest, models.ErrCodeInvalidRequest, "Invalid request body")
+	}
+
+	log.Printf("🚫 Revocation requested for service: %s", revocation.ServiceID)
+
+	if req.Envelope.ServiceID != revocation.ServiceID {
+		if !as.administers(req.Envelope.ServiceID, revocation.ServiceID) {
+			log.Printf("❌ %s may not revoke %s", req.Envelope.ServiceID, revocation.ServiceID)
+			return nil, models.NewError(http.StatusForbidden, models.ErrCodeIdentityMismatch, "Services may only revoke themselves")
+		}
+		log.Printf("🛡️  %s revoking %s as administrator", req.Envelope.ServiceID, revocation.ServiceID)
+	}
+	if revocation.ServiceID == as.identity.ServiceID {
+		return nil, models.NewError(http.StatusBadRequest, models.ErrCodeInvalidRequest, "The auth service cannot be revoked")
+	}
+
+	as.mutex.Lock()
+	publicKey, exists := as.serviceRegistry[revocation.ServiceID]
+	delete(as.serviceRegistry, revocation.ServiceID)
+	delete(as.algorithms, revocation.ServiceID)
+	delete(as.rotations, revocation.ServiceID)
+	delete(as.spiffeIDs, revocation.ServiceID)
+	delete(as.serviceAccounts, revocation.ServiceID)
+	delete(as.cloudIdentities, revocation.ServiceID)
+	delete(as.kyberKeys, revocation.ServiceID)
+	delete(as.kemAlgorithms, revocation.ServiceID)
+	delete(as.addresses, revocation.ServiceID)
+	delete(as.registeredAt, revocation.ServiceID)
+	delete(as.delegations, revocation.ServiceID)
+	delete(as.builds, revocation.ServiceID)
+	delete(as.expired, revocation.ServiceID)
+	if exists {
+		as.version++
+	}
+	as.mutex.Unlock()
+
+	if !exists {
+		log.Printf("❌ Revocation for unregistered service %s", revocation.ServiceID)
+		return nil, models.NewError(http.StatusNotFound, models.ErrCodeServiceNotFound, "Service not found")
+	}
+
+	log.Printf("✅ Service revoked: %s (key %s)", revocation.ServiceID, pqc.KeyFingerprint(publicKey))
+	as.server.Audit().Record(mesh.AuditEvent{
+		Type:    mesh.AuditRevoked,
+		Subject: revocation.ServiceID,
+		Actor:   req.Envelope.ServiceID,
+		KeyID:   pqc.KeyFingerprint(publicKey),
+	})
+
+	return map[string]interface{}{
+		"status":        "success",
+		"service_id":    revocation.ServiceID,
+		"fingerprint":   pqc.KeyFingerprint(publicKey),
+		"authorized_by": req.Envelope.ServiceID,
+		"timestamp":     time.Now(),
+	}, nil
+}
+
+func (as *AuthService) getPublicKey(req *mesh.Request) (interface{}, error) {
+	vars := mux.Vars(req.Request)
+	serviceID := vars["serviceID"]
+
+	log.Printf("🔍 Public key request for service: %s", serviceID)
+
+	publicKey, err := as.lookupPublicKey(serviceID)
+	if err != nil {
+		log.Printf("❌ Service not found: %s", serviceID)
+		return nil, models.NewError(http.StatusNotFound, models.ErrCodeServiceNotFound, "Service not found")
+	}
+
+	as.mutex.RLock()
+	algorithm := as.algorithms[serviceID]
+	spiffeID := as.spiffeIDs[serviceID]
+	serviceAccount := as.serviceAccounts[serviceID]
+	cloudIdentity := as.cloudIdentities[serviceID]
+	kyberKey := as.kyberKeys[serviceID]
+	kemAlgorithm := as.kemAlgorithms[serviceID]
+	address := as.addresses[serviceID]
+	registeredAt := as.registeredAt[serviceID]
+	rotations := as.rotations[serviceID]
+	delegatedBy := as.delegations[serviceID]
+	build := as.builds[serviceID]
+	as.mutex.RUnlock()
+
+	var response interface{} = models.PublicKeyResponse{
+		ServiceID:      serviceID,
+		PublicKey:      publicKey,
+		Algorithm:      algorithm,
+		SPIFFEID:       spiffeID,
+		Address:        address,
+		Timestamp:      time.Now(),
+		ServiceAccount: serviceAccount,
+		CloudIdentity:  cloudIdentity,
+		KyberPublicKey: kyberKey,
+		KEMAlgorithm:   kemAlgorithm,
+		RegisteredAt:   registeredAt,
+		Rotations:      rotations,
+		DelegatedBy:    delegatedBy,
+		Build:          build,
+	}
+	if !models.ProtocolVersionAtLeast(req.ProtocolVersion, models.ProtocolVersion13) {
+		legacyResponse := map[string]interface{}{
+			"service_id": serviceID,
+			"public_key": publicKey,
+			"timestamp":  time.Now(),
+		}
+		if spiffeID != "" {
+			legacyResponse["spiffe_id"] = spiffeID
+		}
+		if address != "" {
+			legacyResponse["address"] = address
+		}
+		response = legacyResponse
+	}
+
+	log.Printf("✅ Public key provided for service: %s", serviceID)
+
+	return response, nil
+}
+
+func (as *AuthService) keyExchange(req *mesh.Request) (interface{}, error) {
+	log.Println("🔐 Received key exchange request")
+
+	var request models.KeyExchangeRequest
+	if err := json.Unmarshal(req.Envelope.Data, &request); err != nil {
+		log.Printf("❌ Invalid key exchange request: %v", err)
+		return nil, models.NewError(http.StatusBadRequest, models.ErrCodeInvalidRequest, "Invalid request body")
+	}
+
+	// The envelope is signed with the requester's registered key, so only
+	// its own Kyber key can be exchanged or brokered.
+	if request.ServiceID != req.Envelope.ServiceID {
+		log.Printf("❌ Key exchange for %s requested by %s", request.ServiceID, req.Envelope.ServiceID)
+		return nil, models.NewError(http.StatusForbidden, models.ErrCodeIdentityMismatch, "Services may only exchange their own keys")
+	}
+
+	kemAlgorithm, err := pqc.KEMAlgorithm(request.KEMAlgorithm)
+	if err != nil {
+		log.Printf("❌ Key exchange for %s: %v", request.ServiceID, err)
+		return nil, models.NewError(http.StatusBadRequest, models.ErrCodeInvalidRequest, "Unsupported KEM algorithm")
+	}
+
+	// Both KEMs are served side by side, so services can move their keys
+	// from Kyber768 to ML-KEM-768 one at a time.
+	response := models.KeyExchangeResponse{
+		ProtocolVersion: models.WireProtocolVersion(req.ProtocolVersion),
+		Timestamp:       time.Now(),
+		KEMAlgorithms:   pqc.KEMAlgorithms,
+	}
+	if kemAlgorithm != pqc.AlgorithmKyber768 {
+		response.KEMAlgorithm = kemAlgorithm
+	}
+	if request.PeerID != "" {
+		ticket, err := as.brokerSession(&request, kemAlgorithm)
+		if err != nil {
+			return nil, err
+		}
+		response.Ticket = ticket
+	} else {
+		if err := pqc.CheckKEMPublicKey(kemAlgorithm, request.KyberPublicKey); err != nil {
+			log.Printf("❌ Key exchange for %s: %v", request.ServiceID, err)
+			return nil, models.NewError(http.StatusBadRequest, models.ErrCodeInvalidRequest, "Invalid KEM public key")
+		}
+		_, encapsulated := telemetry.Observe(req.Context(), telemetry.OpEncapsulate, kemAlgorithm)
+		ciphertext, _, err := pqc.EncapsulateTo(kemAlgorithm, request.KyberPublicKey)
+		encapsulated(err)
+		if err != nil {
+			log.Printf("❌ Failed to encapsulate: %v", err)
+			return nil, models.NewError(http.StatusInternalServerError, models.ErrCodeInternal, "Encapsulation failed")
+		}
+		response.Ciphertext = ciphertext
+	}
+
+	log.Printf("✅ Key exchange completed with service: %s (%s)", request.ServiceID, kemAlgorithm)
+	return response, nil
+}
+
+// brokerSession issues a ticket for a session between the requester and
+// the peer it names, sealing the session key to the kemAlgorithm key the
+// requester signed and the one the peer registered.
+func (as *AuthService) brokerSession(request *models.KeyExchangeRequest, kemAlgorithm string) (*models.SessionTicket, error) {
+	peerID := request.PeerID
+	if peerID == request.ServiceID {
+		return nil, models.NewError(http.StatusBadRequest, models.ErrCodeInvalidRequest, "Cannot broker a session with oneself")
+	}
+
+	as.mutex.RLock()
+	_, registered := as.serviceRegistry[peerID]
+	peerKyber := as.kyberKeys[peerID]
+	peerKEM := as.kemAlgorithms[peerID]
+	as.mutex.RUnlock()
+
+	if !registered {
+		log.Printf("❌ Session requested by %s with unregistered service %s", request.ServiceID, peerID)
+		return nil, models.NewError(http.StatusNotFound, models.ErrCodeServiceNotFound, "Peer not found")
+	}
+	if len(peerKyber) == 0 {
+		log.Printf("❌ Session requested by %s with %s, which registered no Kyber key", request.ServiceID, peerID)
+		return nil, models.NewError(http.StatusConflict, models.ErrCodeInvalidRequest, "Peer has no registered Kyber key")
+	}
+
+	ticket, err := as.identity.IssueSessionTicket(request.ServiceID, kemAlgorithm, request.KyberPublicKey, peerID, peerKEM, peerKyber, as.sessionTTL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to issue session ticket: %w", err)
+	}
+
+	log.Printf("🎫 Session %s brokered between %s and %s until %s", ticket.TicketID, request.ServiceID, peerID, ticket.ExpiresAt.Format(time.RFC3339))
+	return ticket, nil
+}
+
+func (as *AuthService) listServices(req *mesh.Request) (interface{}, error) {
+	log.Println("📋 Listing registered services")
+
+	namespace := req.URL.Query().Get("namespace")
+	inNamespace := func(serviceID string) bool {
+		return namespace == "" || mesh.Namespace(serviceID) == namespace
+	}
+
+	as.mutex.RLock()
+	services := make([]string, 0, len(as.serviceRegistry))
+	for serviceID := range as.serviceRegistry {
+		if inNamespace(serviceID) {
+			services = append(services, serviceID)
+		}
+	}
+	delegations := make(map[string][]string, len(as.delegations))
+	for serviceID, delegatedBy := range as.delegations {
+		if inNamespace(serviceID) {
+			delegations[serviceID] = delegatedBy
+		}
+	}
+	builds := make(map[string]*models.BuildInfo, len(as.builds))
+	for serviceID, build := range as.builds {
+		if inNamespace(serviceID) {
+			builds[serviceID] = build
+		}
+	}
+	as.mutex.RUnlock()
+
+	query, paginated, err := mesh.ParsePageQuery(req.Request)
+	if err != nil {
+		return nil, err
+	}
+	if paginated {
+		sort.Strings(services)
+		listings := make([]models.ServiceListing, len(services))
+		for i, serviceID := range services {
+			listings[i] = models.ServiceListing{ServiceID: serviceID, DelegatedBy: delegations[serviceID], Build: builds[serviceID]}
+		}
+		return mesh.Paginate(query, listings, func(listing models.ServiceListing) string { return listing.ServiceID })
+	}
+
+	response := map[string]interface{}{
+		"services":    services,
+		"count":       len(services),
+		"delegations": delegations,
+		"builds":      builds,
+		"timestamp":   time.Now(),
+	}
+
+	return response, nil
+}
+
+// serveSnapshot returns the whole registry signed as valid for snapshotTTL,
+// for peers to fall back to while they cannot reach the auth service. It
+// carries its own signature rather than an envelope's, so it can be saved
+// to a file and checked later.
+func (as *AuthService) serveSnapshot(w http.ResponseWriter, r *http.Request) {
+	registry := as.snapshot()
+	sort.Slice(registry.Services, func(i, j int) bool {
+		return registry.Services[i].ServiceID < registry.Services[j].ServiceID
+	})
+
+	snapshot, err := as.identity.SignRegistrySnapshot(registry, as.snapshotTTL)
+	if err != nil {
+		log.Printf("❌ Failed to sign registry snapshot: %v", err)
+		models.WriteError(w, r, http.StatusInternalServerError, models.ErrCodeInternal, "Internal server error")
+		return
+	}
+
+	log.Printf("📦 Issued registry snapshot of %d services, valid until %s", len(registry.Services), snapshot.ExpiresAt.Format(time.RFC3339))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(snapshot)
+}
+
+// router mounts the auth service's API.
+func (as *AuthService) router(cfg *config.Config) *mux.Router {
+	r := mux.NewRouter()
+	r.NotFoundHandler = models.NotFoundHandler
+	r.MethodNotAllowedHandler = models.MethodNotAllowedHandler
+	r.Use(telemetry.Middleware, middleware.Recover, middleware.Deadline(cfg.Timeouts.Request))
+
+	r.HandleFunc("/register", envelopeOnly(as.writes(as.server.VerifiedClaim(registrationKey, as.registerService)))).Methods("POST")
+	r.HandleFunc("/public-key/{serviceID:.+}", as.servePublicKey(as.server.Signed(as.getPublicKey))).Methods("GET")
+	r.HandleFunc("/rotate", as.writes(as.server.Verified(as.rotateKey))).Methods("POST")
+	r.HandleFunc("/migrate", as.writes(as.server.Verified(as.migrateKeys))).Methods("POST")
+	r.HandleFunc("/revoke", as.writes(as.server.Verified(as.revokeService))).Methods("POST")
+	r.HandleFunc("/key-exchange", as.server.Verified(as.keyExchange)).Methods("POST")
+	r.HandleFunc("/services", as.server.Signed(as.listServices)).Methods("GET", "POST")
+	r.HandleFunc("/registry/snapshot", as.serveSnapshot).Methods("GET")
+	r.HandleFunc("/config", cfg.Handler()).Methods("GET")
+	r.HandleFunc("/metrics", as.server.Metrics().Handler()).Methods("GET")
+	r.HandleFunc("/audit", as.server.Audit().Handler()).Methods("GET")
+	r.HandleFunc("/version", buildinfo.Handler(buildinfo.Get(cfg.Crypto.Signature, cfg.Crypto.KEM))).Methods("GET")
+	if as.cluster != nil {
+		r.HandleFunc("/cluster/status", as.server.Signed(as.cluster.serveStatus)).Methods("GET")
+		r.HandleFunc("/cluster/state", as.server.VerifiedClaim(as.cluster.identityKey, as.cluster.peersOnly(as.cluster.serveState))).Methods("GET")
+		r.HandleFunc("/cluster/sync", as.server.VerifiedClaim(as.cluster.identityKey, as.cluster.peersOnly(as.cluster.serveSync))).Methods("POST")
+	}
+
+	probes := health.New()
+	probes.Add(health.Readiness, "keys", cfg.Keys.CheckKeys(as.identity))
+	probes.Register(r)
+
+	return r
+}
+
+// Start starts the auth service's background work, its cluster, audit
+// sinks, events and key expiry watch, and returns its API.
+func (as *AuthService) Start(cfg *config.Config) (http.Handler, error) {
+	if err := cfg.Audit.Apply(as.server.Audit()); err != nil {
+		return nil, fmt.Errorf("failed to start audit sinks: %w", err)
+	}
+	if cfg.Messaging.EventsSink != "" {
+		if err := as.startEvents(cfg); err != nil {
+			return nil, fmt.Errorf("failed to start CloudEvents: %w", err)
+		}
+	}
+	if as.cluster != nil {
+		go as.cluster.run()
+	}
+	if cfg.Keys.MaxAge > 0 {
+		go as.watchExpiry(cfg.Keys.MaxAge)
+	}
+	return as.router(cfg), nil
+}
+
+// Reload applies the reloadable settings of cfg.
+func (as *AuthService) Reload(cfg *config.Config) {
+	as.server.UseFreshness(cfg.Timeouts.RequestMaxAge, cfg.Timeouts.ClockSkew)
+}
@@ -0,0 +1,565 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"quantum-safe-mesh/pkg/mesh"
+	"quantum-safe-mesh/pkg/models"
+	"quantum-safe-mesh/pkg/pqc"
+)
+
+// An auth cluster is a fixed set of auth instances sharing the auth-service
+// key, e.g. rebuilt from the same key shares. Every instance answers key
+// lookups from its own copy of the registry. Registrations, rotations and
+// revocations go to the leader: the instance with the lowest URL among those
+// a majority of the cluster can reach. Followers forward them there and copy
+// the leader's registry whenever it changes. Without a majority no instance
+// leads and writes are refused, so a partitioned minority cannot drift from
+// the rest. This is much simpler than Raft: a change the leader could not
+// copy to a majority before it failed may be lost, which is logged, and the
+// service concerned has to register again.
+
+// missedHeartbeats is how many polls in a row an instance may miss before
+// the others count it as down.
+const missedHeartbeats = 3
+
+type cluster struct {
+	as       *AuthService
+	self     string
+	peers    []string
+	interval time.Duration
+	client   *http.Client
+
+	mutex  sync.Mutex
+	seen   map[string]peerStatus // peer URL -> its last answer
+	live   []string              // instances heard from recently, sorted
+	leader string
+}
+
+type peerStatus struct {
+	status   models.ClusterStatus
+	polledAt time.Time
+}
+
+func newCluster(as *AuthService, self string, peers []string, interval, timeout time.Duration) *cluster {
+	return &cluster{
+		as:       as,
+		self:     self,
+		peers:    peers,
+		interval: interval,
+		client:   &http.Client{Timeout: timeout},
+		seen:     make(map[string]peerStatus),
+		live:     []string{self},
+	}
+}
+
+// run polls the other instances every heartbeat until the process exits.
+func (c *cluster) run() {
+	log.Printf("🕸️  Auth cluster of %d instances: %s", len(c.peers)+1, strings.Join(append([]string{c.self}, c.peers...), ", "))
+
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+	for {
+		c.heartbeat()
+		<-ticker.C
+	}
+}
+
+// heartbeat polls every peer's status, elects the leader from the instances
+// that answered recently, and follows it.
+func (c *cluster) heartbeat() {
+	statuses := make([]*models.ClusterStatus, len(c.peers))
+	var wg sync.WaitGroup
+	for i, peer := range c.peers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), c.interval)
+			defer cancel()
+
+			var status models.ClusterStatus
+			if err := c.call(ctx, http.MethodGet, peer, "/cluster/status", &status); err == nil {
+				statuses[i] = &status
+			}
+		}()
+	}
+	wg.Wait()
+
+	now := time.Now()
+	c.mutex.Lock()
+	live := []string{c.self}
+	for i, peer := range c.peers {
+		if statuses[i] != nil {
+			c.seen[peer] = peerStatus{status: *statuses[i], polledAt: now}
+		}
+		if seen, exists := c.seen[peer]; exists && now.Sub(seen.polledAt) < missedHeartbeats*c.interval {
+			live = append(live, peer)
+		}
+	}
+	slices.Sort(live)
+	leader := ""
+	if 2*len(live) > len(c.peers)+1 {
+		leader = live[0]
+	}
+	previous := c.leader
+	c.live = live
+	if leader != c.self {
+		c.leader = leader
+	}
+	c.mutex.Unlock()
+
+	switch {
+	case leader == c.self:
+		if previous != c.self {
+			c.lead()
+		}
+	case leader == "":
+		if previous != "" {
+			log.Printf("⚠️  Auth cluster has no leader: only %d of %d instances reachable, refusing registry changes", len(live), len(c.peers)+1)
+		}
+	default:
+		if leader != previous {
+			log.Printf("🗳️  Auth cluster leader is %s", leader)
+		}
+		c.follow(leader)
+	}
+}
+
+// lead takes over as leader. It first catches up with the newest registry
+// among the live instances, then starts a new term, which makes every
+// follower copy its registry even where their versions match.
+func (c *cluster) lead() {
+	term, version := c.as.position()
+	newest, lastTerm := "", term
+	c.mutex.Lock()
+	for _, peer := range c.live {
+		seen, exists := c.seen[peer]
+		if !exists {
+			continue
+		}
+		if newer(seen.status.Term, seen.status.Version, term, version) {
+			newest, term, version = peer, seen.status.Term, seen.status.Version
+		}
+		lastTerm = max(lastTerm, seen.status.Term)
+	}
+	c.mutex.Unlock()
+
+	if newest != "" {
+		if err := c.pull(newest); err != nil {
+			log.Printf("⚠️  Not taking over as auth cluster leader yet: failed to catch up with %s: %v", newest, err)
+			return
+		}
+	}
+
+	c.as.startTerm(lastTerm + 1)
+	c.mutex.Lock()
+	c.leader = c.self
+	c.mutex.Unlock()
+	log.Printf("👑 Leading the auth cluster in term %d", lastTerm+1)
+}
+
+// follow copies the leader's registry if it has changed since the last copy.
+func (c *cluster) follow(leader string) {
+	c.mutex.Lock()
+	status := c.seen[leader].status
+	c.mutex.Unlock()
+
+	if term, version := c.as.position(); status.Term == term && status.Version == version {
+		return
+	}
+	if err := c.pull(leader); err != nil {
+		log.Printf("⚠️  Failed to copy the registry from auth cluster leader %s: %v", leader, err)
+	}
+}
+
+// pull replaces the local registry with peer's, unless they are already at
+// the same position.
+func (c *cluster) pull(peer string) error {
+	var snapshot models.RegistrySnapshot
+	if err := c.call(context.Background(), http.MethodGet, peer, "/cluster/state", &snapshot); err != nil {
+		return err
+	}
+	if term, version := c.as.position(); snapshot.Term == term && snapshot.Version == version {
+		return nil
+	}
+	c.as.restore(&snapshot)
+	log.Printf("🔁 Copied registry version %d of term %d (%d services) from %s", snapshot.Version, snapshot.Term, len(snapshot.Services), peer)
+	return nil
+}
+
+// replicate has the live followers copy the registry now that it is at
+// version, and warns if fewer than a majority of instances have it.
+func (c *cluster) replicate(term, version uint64) {
+	c.mutex.Lock()
+	followers := slices.DeleteFunc(slices.Clone(c.live), func(node string) bool { return node == c.self })
+	c.mutex.Unlock()
+
+	var copies atomic.Int32
+	copies.Store(1)
+	var wg sync.WaitGroup
+	for _, follower := range followers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var status models.ClusterStatus
+			if err := c.call(context.Background(), http.MethodPost, follower, "/cluster/sync", &status); err != nil {
+				log.Printf("⚠️  Failed to replicate registry version %d to %s: %v", version, follower, err)
+				return
+			}
+			if status.Term == term && status.Version >= version {
+				copies.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n := int(copies.Load()); 2*n <= len(c.peers)+1 {
+		log.Printf("⚠️  Registry version %d is only on %d of %d auth instances and is lost if this one fails", version, n, len(c.peers)+1)
+	}
+}
+
+// writes runs h, a handler that changes the registry, on the leader, which
+// replicates the change before returning. A follower forwards the request to
+// the leader instead, and without a leader it is refused.
+func (c *cluster) writes(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		c.mutex.Lock()
+		leader := c.leader
+		c.mutex.Unlock()
+
+		switch leader {
+		case c.self:
+			_, before := c.as.position()
+			h(w, r)
+			if term, after := c.as.position(); after != before {
+				c.replicate(term, after)
+			}
+		case "":
+			log.Printf("❌ Refusing %s: the auth cluster has no leader", r.URL.Path)
+			models.WriteError(w, r, http.StatusServiceUnavailable, models.ErrCodeUpstreamUnavailable, "Auth cluster has no leader")
+		default:
+			c.forward(w, r, leader)
+		}
+	}
+}
+
+// forward proxies a write to the leader. Its response is signed with the
+// shared key, so callers cannot tell it from this instance's own; the change
+// is copied here before it is passed on, so callers can read it back here.
+func (c *cluster) forward(w http.ResponseWriter, r *http.Request, leader string) {
+	target, err := url.Parse(leader)
+	if err != nil {
+		models.WriteError(w, r, http.StatusInternalServerError, models.ErrCodeInternal, "Internal server error")
+		return
+	}
+
+	proxy := httputil.NewSingleHostReverseProxy(target)
+	proxy.ModifyResponse = func(resp *http.Response) error {
+		if resp.StatusCode < http.StatusMultipleChoices {
+			if err := c.pull(leader); err != nil {
+				log.Printf("⚠️  Failed to copy the registry from auth cluster leader %s: %v", leader, err)
+			}
+		}
+		return nil
+	}
+	proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+		log.Printf("❌ Failed to forward %s to auth cluster leader %s: %v", r.URL.Path, leader, err)
+		models.WriteError(w, r, http.StatusBadGateway, models.ErrCodeUpstreamUnavailable, "Auth cluster leader unavailable")
+	}
+
+	log.Printf("↪️  Forwarding %s to auth cluster leader %s", r.URL.Path, leader)
+	proxy.ServeHTTP(w, r)
+}
+
+// call sends a request, signed as the auth service, to another instance
+// and decodes the Data of its response into v. The response must be signed
+// with the auth service's key and answer this very request, so old answers
+// cannot be replayed. Instances share the identity, so that is this
+// instance's own key, never whatever the registry holds for the auth
+// service's ID.
+func (c *cluster) call(ctx context.Context, method, peer, path string, v interface{}) error {
+	requestID := models.NewRequestID()
+	body, err := c.signRequest(requestID)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, method, peer+path, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(models.HeaderRequestID, requestID)
+	req.Header.Set(models.HeaderAcceptVersion, models.AcceptVersionHeader())
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to reach %s: %w", peer, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s%s failed, status %d: %w", peer, path, resp.StatusCode, models.DecodeErrorResponse(resp))
+	}
+
+	var response models.ServiceResponse
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	if response.ServiceID != c.as.identity.ServiceID || response.RequestID != requestID {
+		return fmt.Errorf("response from %s is not the auth service's answer to this request", peer)
+	}
+	identityKey := pqc.KeyResolverFunc(func(serviceID, keyID string) ([]byte, error) {
+		publicKey := c.as.identity.PublicKey()
+		return publicKey, pqc.CheckKeyID(publicKey, keyID)
+	})
+	if err := pqc.Verify(&response, identityKey); err != nil {
+		return fmt.Errorf("response from %s: %w", peer, err)
+	}
+	if err := json.Unmarshal(response.Data, v); err != nil {
+		return fmt.Errorf("failed to decode %s response: %w", path, err)
+	}
+	return nil
+}
+
+// signRequest returns an empty request envelope for requestID, signed with
+// the auth service's key, for the instance called to tell it comes from
+// the cluster.
+func (c *cluster) signRequest(requestID string) ([]byte, error) {
+	identity := c.as.identity
+	request := models.ServiceRequest{
+		ProtocolVersion: models.CurrentProtocolVersion,
+		ServiceID:       identity.ServiceID,
+		RequestID:       requestID,
+		Timestamp:       time.Now(),
+		Data:            json.RawMessage("{}"),
+		SignatureAlg:    pqc.EnvelopeAlg(identity.Signer),
+		Headers:         map[string]string{models.HeaderKeyID: identity.KeyID()},
+	}
+	payload, err := request.SigningPayload()
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal cluster request: %w", err)
+	}
+	if request.Signature, err = identity.Signer.Sign(payload); err != nil {
+		return nil, fmt.Errorf("failed to sign cluster request: %w", err)
+	}
+	return json.Marshal(request)
+}
+
+func (c *cluster) status() models.ClusterStatus {
+	term, version := c.as.position()
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return models.ClusterStatus{
+		Node:      c.self,
+		Leader:    c.leader,
+		Live:      c.live,
+		Term:      term,
+		Version:   version,
+		Timestamp: time.Now(),
+	}
+}
+
+// serveStatus answers another instance's heartbeat.
+func (c *cluster) serveStatus(req *mesh.Request) (interface{}, error) {
+	return c.status(), nil
+}
+
+// identityKey is the mesh.ClaimedKey for the cluster's own endpoints:
+// requests signed as the auth service are verified with the key the
+// instances share, never whatever the registry holds for its ID.
+func (c *cluster) identityKey(data []byte) (string, []byte) {
+	return c.as.identity.ServiceID, c.as.identity.PublicKey()
+}
+
+// peersOnly refuses requests for h from anyone but another instance of
+// the cluster, which signs as the shared auth service identity.
+func (c *cluster) peersOnly(h mesh.Handler) mesh.Handler {
+	return func(req *mesh.Request) (interface{}, error) {
+		if req.Envelope.ServiceID != c.as.identity.ServiceID {
+			log.Printf("❌ %s refused to %s: only auth cluster instances may call it", req.URL.Path, req.Envelope.ServiceID)
+			return nil, models.NewError(http.StatusForbidden, models.ErrCodeIdentityMismatch, "Only auth cluster instances may call this endpoint")
+		}
+		return h(req)
+	}
+}
+
+// serveState hands the registry to a follower, or to a new leader catching
+// up.
+func (c *cluster) serveState(req *mesh.Request) (interface{}, error) {
+	return c.as.snapshot(), nil
+}
+
+// serveSync copies the registry from the leader right away, when the leader
+// has just changed it, and reports how far this instance got.
+func (c *cluster) serveSync(req *mesh.Request) (interface{}, error) {
+	c.mutex.Lock()
+	leader := c.leader
+	c.mutex.Unlock()
+
+	if leader != "" && leader != c.self {
+		if err := c.pull(leader); err != nil {
+			log.Printf("⚠️  Failed to copy the registry from auth cluster leader %s: %v", leader, err)
+		}
+	}
+	return c.status(), nil
+}
+
+// newer reports whether registry position (term, version) is ahead of
+// (otherTerm, otherVersion).
+func newer(term, version, otherTerm, otherVersion uint64) bool {
+	return term > otherTerm || (term == otherTerm && version > otherVersion)
+}
+
+// writes routes h, a handler that changes the registry, through the cluster
+// leader when the auth service is clustered.
+func (as *AuthService) writes(h http.HandlerFunc) http.HandlerFunc {
+	if as.cluster == nil {
+		return h
+	}
+	return as.cluster.writes(h)
+}
+
+// position returns the registry's term and version.
+func (as *AuthService) position() (uint64, uint64) {
+	as.mutex.RLock()
+	defer as.mutex.RUnlock()
+	return as.term, as.version
+}
+
+func (as *AuthService) startTerm(term uint64) {
+	as.mutex.Lock()
+	defer as.mutex.Unlock()
+	as.term = term
+}
+
+// snapshot returns the registry for replication.
+func (as *AuthService) snapshot() models.RegistrySnapshot {
+	as.mutex.RLock()
+	defer as.mutex.RUnlock()
+
+	snapshot := models.RegistrySnapshot{
+		Term:     as.term,
+		Version:  as.version,
+		Services: make([]models.PublicKeyResponse, 0, len(as.serviceRegistry)),
+	}
+	for serviceID, publicKey := range as.serviceRegistry {
+		snapshot.Services = append(snapshot.Services, models.PublicKeyResponse{
+			ServiceID:      serviceID,
+			PublicKey:      publicKey,
+			Algorithm:      as.algorithms[serviceID],
+			SPIFFEID:       as.spiffeIDs[serviceID],
+			Address:        as.addresses[serviceID],
+			ServiceAccount: as.serviceAccounts[serviceID],
+			CloudIdentity:  as.cloudIdentities[serviceID],
+			KyberPublicKey: as.kyberKeys[serviceID],
+			KEMAlgorithm:   as.kemAlgorithms[serviceID],
+			RegisteredAt:   as.registeredAt[serviceID],
+			Rotations:      as.rotations[serviceID],
+			DelegatedBy:    as.delegations[serviceID],
+			Build:          as.builds[serviceID],
+		})
+	}
+	return snapshot
+}
+
+// restore replaces the registry with snapshot. The auth service's own entry
+// is kept if the snapshot has another key for it: clustered instances must
+// share one key.
+func (as *AuthService) restore(snapshot *models.RegistrySnapshot) {
+	as.mutex.Lock()
+	defer as.mutex.Unlock()
+
+	self := as.identity.ServiceID
+	own := models.PublicKeyResponse{
+		ServiceID:    self,
+		PublicKey:    as.serviceRegistry[self],
+		Algorithm:    as.algorithms[self],
+		RegisteredAt: as.registeredAt[self],
+		Rotations:    as.rotations[self],
+		Build:        as.builds[self],
+	}
+
+	as.serviceRegistry = make(map[string][]byte, len(snapshot.Services))
+	as.algorithms = make(map[string]string, len(snapshot.Services))
+	as.spiffeIDs = make(map[string]string)
+	as.serviceAccounts = make(map[string]string)
+	as.cloudIdentities = make(map[string]string)
+	as.kyberKeys = make(map[string][]byte)
+	as.kemAlgorithms = make(map[string]string)
+	as.addresses = make(map[string]string)
+	as.registeredAt = make(map[string]time.Time, len(snapshot.Services))
+	as.rotations = make(map[string][]models.KeyRotationRecord)
+	as.delegations = make(map[string][]string)
+	as.builds = make(map[string]*models.BuildInfo)
+
+	add := func(service models.PublicKeyResponse) {
+		as.serviceRegistry[service.ServiceID] = service.PublicKey
+		as.algorithms[service.ServiceID] = service.Algorithm
+		as.registeredAt[service.ServiceID] = service.RegisteredAt
+		if service.SPIFFEID != "" {
+			as.spiffeIDs[service.ServiceID] = service.SPIFFEID
+		}
+		if service.Address != "" {
+			as.addresses[service.ServiceID] = service.Address
+		}
+		if service.ServiceAccount != "" {
+			as.serviceAccounts[service.ServiceID] = service.ServiceAccount
+		}
+		if service.CloudIdentity != "" {
+			as.cloudIdentities[service.ServiceID] = service.CloudIdentity
+		}
+		if len(service.KyberPublicKey) > 0 {
+			as.kyberKeys[service.ServiceID] = service.KyberPublicKey
+			// Snapshots from instances that predate KEM tags have none.
+			as.kemAlgorithms[service.ServiceID], _ = pqc.KEMAlgorithm(service.KEMAlgorithm)
+		}
+		if len(service.Rotations) > 0 {
+			as.rotations[service.ServiceID] = service.Rotations
+		}
+		if len(service.DelegatedBy) > 0 {
+			as.delegations[service.ServiceID] = service.DelegatedBy
+		}
+		if service.Build != nil {
+			as.builds[service.ServiceID] = service.Build
+		}
+	}
+	for _, service := range snapshot.Services {
+		if service.ServiceID == self && !bytes.Equal(service.PublicKey, own.PublicKey) {
+			log.Printf("⚠️  Auth cluster has key %s for %s, not this instance's %s: clustered instances must share one key",
+				pqc.KeyFingerprint(service.PublicKey), self, pqc.KeyFingerprint(own.PublicKey))
+			continue
+		}
+		add(service)
+	}
+	if _, exists := as.serviceRegistry[self]; !exists {
+		add(own)
+	}
+	if own.Build != nil {
+		as.builds[self] = own.Build
+	}
+	as.term, as.version = snapshot.Term, snapshot.Version
+}
+
+// leading reports whether this instance makes registry changes: it is the
+// cluster leader, or runs alone.
+func (as *AuthService) leading() bool {
+	if as.cluster == nil {
+		return true
+	}
+	as.cluster.mutex.Lock()
+	defer as.cluster.mutex.Unlock()
+	return as.cluster.leader == as.cluster.self
+}
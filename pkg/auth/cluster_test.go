@@ -0,0 +1,86 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+
+	"quantum-safe-mesh/pkg/config"
+	"quantum-safe-mesh/pkg/meshtest"
+	"quantum-safe-mesh/pkg/models"
+	"quantum-safe-mesh/pkg/pqc"
+)
+
+// TestClusterEndpointsNeedClusterIdentity checks the registry state and
+// sync endpoints only answer requests signed with the shared auth service
+// key.
+func TestClusterEndpointsNeedClusterIdentity(t *testing.T) {
+	pqc.KeysDir = t.TempDir()
+	cfg := config.Defaults(config.ServiceAuth)
+	cfg.Keys.Generate = true
+	cfg.Service.AdvertiseURL = "http://auth-0.invalid"
+	cfg.Cluster.Peers = "http://auth-1.invalid"
+	as, err := NewAuthService(cfg)
+	if err != nil {
+		t.Fatalf("NewAuthService: %v", err)
+	}
+	handler := as.router(cfg)
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	// Lead without heartbeats, so registrations are taken here.
+	as.cluster.leader = as.cluster.self
+
+	outsider := meshtest.Identity("backend-service")
+	register(t, handler, outsider.ServiceID, outsider.Signer)
+
+	// unsigned claims to come from the cluster without a signature.
+	unsigned, _ := json.Marshal(models.ServiceRequest{
+		ProtocolVersion: models.CurrentProtocolVersion,
+		ServiceID:       as.identity.ServiceID,
+		RequestID:       models.NewRequestID(),
+		Timestamp:       time.Now(),
+		Data:            json.RawMessage("{}"),
+	})
+
+	for _, tc := range []struct {
+		name, method, path string
+		body               []byte
+		wantStatus         int
+	}{
+		{"unsigned state", "GET", "/cluster/state", unsigned, http.StatusUnauthorized},
+		{"unsigned sync", "POST", "/cluster/sync", unsigned, http.StatusUnauthorized},
+		{"state for a registered service", "GET", "/cluster/state",
+			signedRequest(t, models.CurrentProtocolVersion, outsider.ServiceID, outsider.Signer, []byte("{}")), http.StatusForbidden},
+		{"sync for a registered service", "POST", "/cluster/sync",
+			signedRequest(t, models.CurrentProtocolVersion, outsider.ServiceID, outsider.Signer, []byte("{}")), http.StatusForbidden},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(tc.method, tc.path, bytes.NewReader(tc.body))
+			req.Header.Set(models.HeaderAcceptVersion, models.AcceptVersionHeader())
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, req)
+			if recorder.Code != tc.wantStatus {
+				t.Fatalf("status %d, want %d: %s", recorder.Code, tc.wantStatus, recorder.Body)
+			}
+		})
+	}
+
+	// Another instance signs as the auth service and is answered.
+	var snapshot models.RegistrySnapshot
+	if err := as.cluster.call(context.Background(), http.MethodGet, server.URL, "/cluster/state", &snapshot); err != nil {
+		t.Fatalf("cluster state: %v", err)
+	}
+	if !slices.ContainsFunc(snapshot.Services, func(service models.PublicKeyResponse) bool { return service.ServiceID == outsider.ServiceID }) {
+		t.Fatalf("cluster state does not hold %s", outsider.ServiceID)
+	}
+	var status models.ClusterStatus
+	if err := as.cluster.call(context.Background(), http.MethodPost, server.URL, "/cluster/sync", &status); err != nil {
+		t.Fatalf("cluster sync: %v", err)
+	}
+}
@@ -0,0 +1,516 @@
+package auth
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"quantum-safe-mesh/pkg/config"
+	"quantum-safe-mesh/pkg/mesh"
+	"quantum-safe-mesh/pkg/meshtest"
+	"quantum-safe-mesh/pkg/models"
+	"quantum-safe-mesh/pkg/pqc"
+)
+
+// Contract tests: every model crosses the real auth handlers over HTTP, at
+// every protocol version, and is read back through the parsing path its
+// consumers use. Keys, signatures and ciphertexts must arrive byte for byte.
+
+func TestMain(m *testing.M) {
+	log.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+// startAuthService serves a fresh auth service with its keys in a temporary
+// directory.
+func startAuthService(t testing.TB) (*AuthService, *httptest.Server) {
+	t.Helper()
+	pqc.KeysDir = t.TempDir()
+
+	cfg := config.Defaults(config.ServiceAuth)
+	cfg.Keys.Generate = true
+	as, err := NewAuthService(cfg)
+	if err != nil {
+		t.Fatalf("NewAuthService: %v", err)
+	}
+
+	server := httptest.NewServer(as.router(cfg))
+	t.Cleanup(server.Close)
+	return as, server
+}
+
+// send makes a request offering only version, as a peer at that version
+// would, and fails the test unless it is answered with 200.
+func send(t *testing.T, method, url, version string, body []byte) *http.Response {
+	t.Helper()
+	req, err := http.NewRequest(method, url, bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("NewRequest: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(models.HeaderAcceptVersion, version)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("%s %s: %v", method, url, err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("%s %s: status %d: %v", method, url, resp.StatusCode, models.DecodeErrorResponse(resp))
+	}
+	if got := resp.Header.Get(models.HeaderProtocolVersion); got != version {
+		t.Fatalf("%s %s: answered in version %q, want %q", method, url, got, version)
+	}
+	return resp
+}
+
+// readEnvelope decodes a signed response and verifies it against the auth
+// service's key, as a RegistryClient would.
+func readEnvelope(t *testing.T, as *AuthService, resp *http.Response) models.ServiceResponse {
+	t.Helper()
+	var envelope models.ServiceResponse
+	if err := json.NewDecoder(resp.Body).Decode(&envelope); err != nil {
+		t.Fatalf("failed to decode envelope: %v", err)
+	}
+
+	payload, err := envelope.SigningPayload()
+	if err != nil {
+		t.Fatalf("SigningPayload: %v", err)
+	}
+	if err := pqc.VerifyDilithiumSignature(as.identity.PublicKey(), payload, envelope.Signature); err != nil {
+		t.Fatalf("response signature does not verify after transport: %v", err)
+	}
+	return envelope
+}
+
+// signedRequest wraps data in an envelope at version, signed as serviceID
+// with signer.
+func signedRequest(t testing.TB, version, serviceID string, signer pqc.Signer, data []byte) []byte {
+	t.Helper()
+	request := models.ServiceRequest{
+		ProtocolVersion: models.WireProtocolVersion(version),
+		ServiceID:       serviceID,
+		Timestamp:       time.Now(),
+		Data:            data,
+	}
+	if models.ProtocolVersionAtLeast(version, models.ProtocolVersion12) {
+		request.RequestID = models.NewRequestID()
+	}
+	signingPayload, _ := request.SigningPayload()
+	signature, err := signer.Sign(signingPayload)
+	if err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+	request.Signature = signature
+
+	body, _ := json.Marshal(request)
+	return body
+}
+
+func registeredKey(as *AuthService, serviceID string) []byte {
+	as.mutex.RLock()
+	defer as.mutex.RUnlock()
+	return as.serviceRegistry[serviceID]
+}
+
+func TestPublicKeyContract(t *testing.T) {
+	as, server := startAuthService(t)
+
+	for _, version := range models.SupportedProtocolVersions {
+		t.Run(version, func(t *testing.T) {
+			identity := meshtest.Identity("contract-" + version)
+
+			registration, err := json.Marshal(models.ServiceKeyPair{
+				ProtocolVersion: models.WireProtocolVersion(version),
+				ServiceID:       identity.ServiceID,
+				PublicKey:       identity.PublicKey(),
+			})
+			if err != nil {
+				t.Fatalf("Marshal: %v", err)
+			}
+			body := signedRequest(t, version, identity.ServiceID, identity.Signer, registration)
+			readEnvelope(t, as, send(t, "POST", server.URL+"/register", version, body))
+
+			if !bytes.Equal(registeredKey(as, identity.ServiceID), identity.PublicKey()) {
+				t.Fatal("auth service stored a different key than was registered")
+			}
+
+			envelope := readEnvelope(t, as, send(t, "GET", server.URL+"/public-key/"+identity.ServiceID, version, nil))
+
+			// Each version's consumers parse the Data their own way.
+			var publicKey []byte
+			if models.ProtocolVersionAtLeast(version, models.ProtocolVersion13) {
+				var response models.PublicKeyResponse
+				if err := json.Unmarshal(envelope.Data, &response); err != nil {
+					t.Fatalf("failed to decode PublicKeyResponse: %v", err)
+				}
+				publicKey = response.PublicKey
+			} else {
+				var keyData map[string]interface{}
+				if err := json.Unmarshal(envelope.Data, &keyData); err != nil {
+					t.Fatalf("failed to decode legacy key data: %v", err)
+				}
+				publicKey, err = pqc.DeserializePublicKeyFromJSON(keyData)
+				if err != nil {
+					t.Fatalf("DeserializePublicKeyFromJSON: %v", err)
+				}
+			}
+
+			if !bytes.Equal(publicKey, identity.PublicKey()) {
+				t.Fatalf("public key changed in transit: got %d bytes, want %d", len(publicKey), len(identity.PublicKey()))
+			}
+		})
+	}
+}
+
+// TestRegistrationProofOfPossession checks /register and /key-exchange
+// only accept requests signed with the key being registered, and that
+// /register only replaces a registered key when a bootstrap token vouches
+// for the new one.
+func TestRegistrationProofOfPossession(t *testing.T) {
+	as, server := startAuthService(t)
+	handler := as.router(config.Defaults(config.ServiceAuth))
+	attacker := meshtest.Identity("attacker")
+	register(t, handler, attacker.ServiceID, attacker.Signer)
+	owner := meshtest.Identity("owner")
+	register(t, handler, owner.ServiceID, owner.Signer)
+
+	victim := meshtest.Identity("victim")
+	registration, _ := json.Marshal(models.ServiceKeyPair{
+		ProtocolVersion: models.CurrentProtocolVersion,
+		ServiceID:       victim.ServiceID,
+		PublicKey:       attacker.PublicKey(),
+	})
+	// takeover registers the attacker's key as serviceID, signed with it.
+	takeover := func(serviceID string, headers map[string]string) []byte {
+		data, _ := json.Marshal(models.ServiceKeyPair{
+			ProtocolVersion: models.CurrentProtocolVersion,
+			ServiceID:       serviceID,
+			PublicKey:       attacker.PublicKey(),
+		})
+		request := models.ServiceRequest{
+			ProtocolVersion: models.CurrentProtocolVersion,
+			ServiceID:       serviceID,
+			Timestamp:       time.Now(),
+			RequestID:       models.NewRequestID(),
+			Headers:         headers,
+			Data:            data,
+		}
+		signingPayload, _ := request.SigningPayload()
+		request.Signature, _ = attacker.Signer.Sign(signingPayload)
+		body, _ := json.Marshal(request)
+		return body
+	}
+	exchange, _ := json.Marshal(models.KeyExchangeRequest{
+		ProtocolVersion: models.CurrentProtocolVersion,
+		ServiceID:       victim.ServiceID,
+		KyberPublicKey:  attacker.Kyber.GetPublicKeyBytes(),
+	})
+	version := models.CurrentProtocolVersion
+
+	for _, tc := range []struct {
+		name       string
+		path       string
+		body       []byte
+		wantStatus int
+		wantCode   string
+	}{
+		{"unsigned registration", "/register", registration, http.StatusUnauthorized, models.ErrCodeRequestExpired},
+		{"registration signed with another key", "/register",
+			signedRequest(t, version, victim.ServiceID, victim.Signer, registration), http.StatusUnauthorized, models.ErrCodeInvalidSignature},
+		{"registration signed by another service", "/register",
+			signedRequest(t, version, attacker.ServiceID, attacker.Signer, registration), http.StatusForbidden, models.ErrCodeIdentityMismatch},
+		{"registration replacing a registered key", "/register",
+			takeover(owner.ServiceID, nil), http.StatusForbidden, models.ErrCodeIdentityMismatch},
+		{"registration of the auth service", "/register",
+			takeover(as.identity.ServiceID, nil), http.StatusBadRequest, models.ErrCodeInvalidRequest},
+		{"key exchange from an unregistered service", "/key-exchange",
+			signedRequest(t, version, victim.ServiceID, victim.Signer, exchange), http.StatusUnauthorized, models.ErrCodeInvalidSignature},
+		{"key exchange for another service", "/key-exchange",
+			signedRequest(t, version, attacker.ServiceID, attacker.Signer, exchange), http.StatusForbidden, models.ErrCodeIdentityMismatch},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			req, _ := http.NewRequest("POST", server.URL+tc.path, bytes.NewReader(tc.body))
+			req.Header.Set(models.HeaderAcceptVersion, models.AcceptVersionHeader())
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("POST %s: %v", tc.path, err)
+			}
+			defer resp.Body.Close()
+
+			var errResp models.ErrorResponse
+			json.NewDecoder(resp.Body).Decode(&errResp)
+			if resp.StatusCode != tc.wantStatus || errResp.Code != tc.wantCode {
+				t.Fatalf("status %d %s, want %d %s", resp.StatusCode, errResp.Code, tc.wantStatus, tc.wantCode)
+			}
+		})
+	}
+
+	if registeredKey(as, victim.ServiceID) != nil {
+		t.Fatal("victim was registered without proving possession of the key")
+	}
+	if !bytes.Equal(registeredKey(as, owner.ServiceID), owner.PublicKey()) {
+		t.Fatal("a registration signed only with the new key replaced the registered one")
+	}
+	if !bytes.Equal(registeredKey(as, as.identity.ServiceID), as.identity.PublicKey()) {
+		t.Fatal("the auth service's own key was replaced")
+	}
+
+	// A bootstrap token from a trusted issuer vouches for the new key.
+	as.bootstrapMode = mesh.BootstrapOptional
+	as.bootstrapIssuer[as.identity.ServiceID] = true
+	token, err := as.identity.IssueBootstrapToken(owner.ServiceID, time.Minute)
+	if err != nil {
+		t.Fatalf("IssueBootstrapToken: %v", err)
+	}
+
+	// A detached signature does not cover the token, so detached
+	// registrations are refused however they are vouched for.
+	data, _ := json.Marshal(models.ServiceKeyPair{
+		ProtocolVersion: models.CurrentProtocolVersion,
+		ServiceID:       owner.ServiceID,
+		PublicKey:       attacker.PublicKey(),
+	})
+	jws, err := pqc.SignDetached(attacker.Signer, owner.ServiceID, data)
+	if err != nil {
+		t.Fatalf("SignDetached: %v", err)
+	}
+	req := httptest.NewRequest("POST", "/register", bytes.NewReader(data))
+	req.Header.Set(models.HeaderAcceptVersion, models.AcceptVersionHeader())
+	req.Header.Set(models.HeaderMeshSignature, jws)
+	req.Header.Set(models.HeaderBootstrapToken, token)
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+	if recorder.Code != http.StatusBadRequest {
+		t.Fatalf("detached registration: status %d, want 400: %s", recorder.Code, recorder.Body)
+	}
+	if !bytes.Equal(registeredKey(as, owner.ServiceID), owner.PublicKey()) {
+		t.Fatal("a detached registration replaced the registered key")
+	}
+
+	body := takeover(owner.ServiceID, map[string]string{models.HeaderBootstrapToken: token})
+	req = httptest.NewRequest("POST", "/register", bytes.NewReader(body))
+	req.Header.Set(models.HeaderAcceptVersion, models.AcceptVersionHeader())
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("vouched registration: status %d: %s", recorder.Code, recorder.Body)
+	}
+	if !bytes.Equal(registeredKey(as, owner.ServiceID), attacker.PublicKey()) {
+		t.Fatal("a registration vouched for by a bootstrap token did not replace the key")
+	}
+}
+
+func TestKeyExchangeContract(t *testing.T) {
+	as, server := startAuthService(t)
+
+	for _, version := range models.SupportedProtocolVersions {
+		t.Run(version, func(t *testing.T) {
+			identity := meshtest.Identity("contract-" + version)
+			as.mutex.Lock()
+			as.serviceRegistry[identity.ServiceID] = identity.PublicKey()
+			as.mutex.Unlock()
+
+			data, _ := json.Marshal(models.KeyExchangeRequest{
+				ProtocolVersion: models.WireProtocolVersion(version),
+				ServiceID:       identity.ServiceID,
+				KyberPublicKey:  identity.Kyber.GetPublicKeyBytes(),
+			})
+			body := signedRequest(t, version, identity.ServiceID, identity.Signer, data)
+			envelope := readEnvelope(t, as, send(t, "POST", server.URL+"/key-exchange", version, body))
+
+			var response models.KeyExchangeResponse
+			if err := json.Unmarshal(envelope.Data, &response); err != nil {
+				t.Fatalf("failed to decode key exchange response: %v", err)
+			}
+
+			if _, err := identity.Kyber.Decapsulate(response.Ciphertext); err != nil {
+				t.Fatalf("ciphertext changed in transit: %v", err)
+			}
+		})
+	}
+}
+
+func TestRotateContract(t *testing.T) {
+	as, server := startAuthService(t)
+
+	for _, version := range models.SupportedProtocolVersions {
+		t.Run(version, func(t *testing.T) {
+			identity := meshtest.Identity("contract-" + version)
+			next := meshtest.Identity("contract-next-" + version)
+			as.mutex.Lock()
+			as.serviceRegistry[identity.ServiceID] = identity.PublicKey()
+			as.mutex.Unlock()
+
+			rotation := models.KeyRotationRequest{ServiceID: identity.ServiceID, NewPublicKey: next.PublicKey()}
+			proofPayload, _ := rotation.ProofPayload()
+			rotation.Proof, _ = next.Signer.Sign(proofPayload)
+			data, _ := json.Marshal(rotation)
+
+			request := models.ServiceRequest{
+				ProtocolVersion: models.WireProtocolVersion(version),
+				ServiceID:       identity.ServiceID,
+				Timestamp:       time.Now(),
+				Data:            data,
+			}
+			if models.ProtocolVersionAtLeast(version, models.ProtocolVersion12) {
+				request.RequestID = models.NewRequestID()
+			}
+			signingPayload, _ := request.SigningPayload()
+			request.Signature, _ = identity.Signer.Sign(signingPayload)
+
+			body, _ := json.Marshal(request)
+			envelope := readEnvelope(t, as, send(t, "POST", server.URL+"/rotate", version, body))
+			if envelope.RequestID != request.RequestID {
+				t.Fatalf("response bound to request %q, want %q", envelope.RequestID, request.RequestID)
+			}
+
+			if !bytes.Equal(registeredKey(as, identity.ServiceID), next.PublicKey()) {
+				t.Fatal("auth service stored a different key than was rotated to")
+			}
+		})
+	}
+}
+
+// TestNamespaceAdminScope checks a namespace administrator of the default
+// namespace, which unqualified IDs fall in, may not rotate or migrate the
+// keys of the auth service or of a global administrator.
+func TestNamespaceAdminScope(t *testing.T) {
+	as, server := startAuthService(t)
+	handler := as.router(config.Defaults(config.ServiceAuth))
+	namespaceAdmin := meshtest.Identity("namespace-admin")
+	register(t, handler, namespaceAdmin.ServiceID, namespaceAdmin.Signer)
+	globalAdmin := meshtest.Identity("global-admin")
+	register(t, handler, globalAdmin.ServiceID, globalAdmin.Signer)
+
+	var err error
+	if as.namespaceAdmins, err = mesh.ParseNamespaceAdmins(mesh.DefaultNamespace + "=" + namespaceAdmin.ServiceID); err != nil {
+		t.Fatalf("ParseNamespaceAdmins: %v", err)
+	}
+	as.admins[globalAdmin.ServiceID] = true
+	next := meshtest.Identity("namespace-next")
+	version := models.CurrentProtocolVersion
+
+	for _, target := range []string{as.identity.ServiceID, globalAdmin.ServiceID} {
+		rotation := &models.KeyRotationRequest{ServiceID: target, NewPublicKey: next.PublicKey()}
+		proofPayload, _ := rotation.ProofPayload()
+		rotation.Proof, _ = next.Signer.Sign(proofPayload)
+		rotate, _ := json.Marshal(rotation)
+		migrate, _ := json.Marshal(models.KeyMigrationRequest{ServiceID: target, Rotation: rotation})
+
+		for _, tc := range []struct {
+			path string
+			data []byte
+		}{
+			{"/rotate", rotate},
+			{"/migrate", migrate},
+		} {
+			t.Run(tc.path+" "+target, func(t *testing.T) {
+				body := signedRequest(t, version, namespaceAdmin.ServiceID, namespaceAdmin.Signer, tc.data)
+				req, _ := http.NewRequest("POST", server.URL+tc.path, bytes.NewReader(body))
+				req.Header.Set(models.HeaderAcceptVersion, models.AcceptVersionHeader())
+				resp, err := http.DefaultClient.Do(req)
+				if err != nil {
+					t.Fatalf("POST %s: %v", tc.path, err)
+				}
+				defer resp.Body.Close()
+
+				var errResp models.ErrorResponse
+				json.NewDecoder(resp.Body).Decode(&errResp)
+				if resp.StatusCode != http.StatusForbidden || errResp.Code != models.ErrCodeIdentityMismatch {
+					t.Fatalf("status %d %s, want %d %s", resp.StatusCode, errResp.Code, http.StatusForbidden, models.ErrCodeIdentityMismatch)
+				}
+			})
+		}
+	}
+
+	if !bytes.Equal(registeredKey(as, as.identity.ServiceID), as.identity.PublicKey()) {
+		t.Fatal("a namespace administrator replaced the auth service's key")
+	}
+	if !bytes.Equal(registeredKey(as, globalAdmin.ServiceID), globalAdmin.PublicKey()) {
+		t.Fatal("a namespace administrator replaced a global administrator's key")
+	}
+}
+
+// TestRegistryClientContract drives the handlers through the mesh package's
+// own clients, which start on 1.0 and negotiate up, in both signing modes.
+func TestRegistryClientContract(t *testing.T) {
+	as, server := startAuthService(t)
+
+	identity := meshtest.Identity("backend-service")
+	versions := mesh.NewPeerVersions()
+	registry := mesh.NewRegistryClient(server.URL, identity, versions)
+
+	if err := registry.Register(); err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+	if !bytes.Equal(registeredKey(as, identity.ServiceID), identity.PublicKey()) {
+		t.Fatal("auth service stored a different key than was registered")
+	}
+	if got := versions.Get(mesh.AuthServiceID); got != models.CurrentProtocolVersion {
+		t.Fatalf("negotiated %s with the auth service, want %s", got, models.CurrentProtocolVersion)
+	}
+
+	peer := meshtest.Identity("api-gateway")
+	peerRegistry := mesh.NewRegistryClient(server.URL, peer, mesh.NewPeerVersions())
+	if err := peerRegistry.Register(); err != nil {
+		t.Fatalf("Register peer: %v", err)
+	}
+	publicKey, err := peerRegistry.PublicKey(identity.ServiceID)
+	if err != nil {
+		t.Fatalf("PublicKey: %v", err)
+	}
+	if !bytes.Equal(publicKey, identity.PublicKey()) {
+		t.Fatal("RegistryClient.PublicKey returned a different key than was registered")
+	}
+
+	if _, err := registry.KeyExchange(); err != nil {
+		t.Fatalf("KeyExchange: %v", err)
+	}
+
+	services, err := registry.Services()
+	if err != nil {
+		t.Fatalf("Services: %v", err)
+	}
+	if len(services) != 3 {
+		t.Fatalf("Services returned %v, want the auth service and two peers", services)
+	}
+
+	next := meshtest.Identity("backend-service-next")
+	if _, err := registry.Rotate(next.Signer); err != nil {
+		t.Fatalf("Rotate: %v", err)
+	}
+	if !bytes.Equal(registeredKey(as, identity.ServiceID), next.PublicKey()) {
+		t.Fatal("auth service stored a different key than was rotated to")
+	}
+
+	// Detached mode sends the body verbatim and signs it in a header.
+	rotated := &mesh.Identity{ServiceID: identity.ServiceID, Signer: next.Signer, Kyber: identity.Kyber}
+	client, err := mesh.NewSignedClient(rotated, registry, versions, mesh.AuthServiceID, server.URL, mesh.SignatureModeDetached)
+	if err != nil {
+		t.Fatalf("NewSignedClient: %v", err)
+	}
+	body, _ := json.Marshal(models.RevocationRequest{ServiceID: identity.ServiceID})
+	resp, errResp := client.Call(&mesh.Call{Path: "/revoke", Body: body, RequestID: models.NewRequestID(), Headers: map[string]string{"Content-Type": "application/json"}})
+	if errResp != nil {
+		t.Fatalf("detached /revoke: %v", errResp)
+	}
+
+	var result map[string]interface{}
+	if err := json.Unmarshal(resp.Body, &result); err != nil {
+		t.Fatalf("failed to decode detached response: %v", err)
+	}
+	if result["fingerprint"] != pqc.KeyFingerprint(next.PublicKey()) {
+		t.Fatalf("revoked key %v, want %s", result["fingerprint"], pqc.KeyFingerprint(next.PublicKey()))
+	}
+	if registeredKey(as, identity.ServiceID) != nil {
+		t.Fatal("service still registered after revocation")
+	}
+}
@@ -0,0 +1,78 @@
+package auth
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"quantum-safe-mesh/pkg/cloudevents"
+	"quantum-safe-mesh/pkg/config"
+	"quantum-safe-mesh/pkg/mesh"
+	"quantum-safe-mesh/pkg/pqc"
+)
+
+// expirySweepInterval is how often registered keys are checked against
+// keys.max_age.
+const expirySweepInterval = time.Minute
+
+// startEvents sends a signed CloudEvent to the configured sink for every
+// registration, rotation, revocation and expiry the registry records.
+func (as *AuthService) startEvents(cfg *config.Config) error {
+	sink, err := cloudevents.NewSink(cfg.Messaging.EventsSink, cfg.Messaging.EventsSubject, as.identity.ServiceID, cfg.Timeouts.Client)
+	if err != nil {
+		return err
+	}
+	// Instances of an auth cluster share the service ID, so they are one
+	// source; only the leader records changes, so each is sent once.
+	source := "urn:quantum-safe-mesh:" + as.identity.ServiceID
+	emitter := cloudevents.NewEmitter(as.identity, source, sink)
+	as.server.Audit().Observe(emitter.Observe)
+
+	log.Printf("📣 Sending registry CloudEvents to %s", cfg.Messaging.EventsSink)
+	return nil
+}
+
+// watchExpiry records an expired audit event, once, for every registered
+// key older than maxAge, until the process exits. Expired keys stay
+// registered: services rotate them, or an administrator revokes them.
+func (as *AuthService) watchExpiry(maxAge time.Duration) {
+	ticker := time.NewTicker(expirySweepInterval)
+	defer ticker.Stop()
+	for {
+		as.expireKeys(maxAge, time.Now())
+		<-ticker.C
+	}
+}
+
+func (as *AuthService) expireKeys(maxAge time.Duration, now time.Time) {
+	if !as.leading() {
+		return
+	}
+
+	type expiry struct {
+		serviceID    string
+		publicKey    []byte
+		registeredAt time.Time
+	}
+	var expired []expiry
+
+	as.mutex.Lock()
+	for serviceID, registeredAt := range as.registeredAt {
+		if now.Sub(registeredAt) < maxAge || as.expired[serviceID].Equal(registeredAt) {
+			continue
+		}
+		as.expired[serviceID] = registeredAt
+		expired = append(expired, expiry{serviceID, as.serviceRegistry[serviceID], registeredAt})
+	}
+	as.mutex.Unlock()
+
+	for _, key := range expired {
+		log.Printf("⌛ Key of %s expired: registered %s, max age %s", key.serviceID, key.registeredAt.Format(time.RFC3339), maxAge)
+		as.server.Audit().Record(mesh.AuditEvent{
+			Type:    mesh.AuditExpired,
+			Subject: key.serviceID,
+			KeyID:   pqc.KeyFingerprint(key.publicKey),
+			Detail:  fmt.Sprintf("registered %s, max age %s", key.registeredAt.UTC().Format(time.RFC3339), maxAge),
+		})
+	}
+}
@@ -0,0 +1,122 @@
+package auth
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"quantum-safe-mesh/pkg/mesh"
+	"quantum-safe-mesh/pkg/middleware"
+	"quantum-safe-mesh/pkg/models"
+)
+
+// presignedTTL bounds how long a pre-signed /public-key response is served,
+// so its timestamps stay recent even for keys that never change.
+const presignedTTL = 30 * time.Second
+
+// presignedCache keeps signed /public-key responses, which are the same for
+// every caller that does not bind them to a request ID. Entries hold until
+// the registry changes or they age out, so a lookup of a hot key costs a map
+// read instead of a signature.
+type presignedCache struct {
+	mutex         sync.RWMutex
+	term, version uint64 // registry position the entries were signed at
+	entries       map[presignedKey]presignedResponse
+}
+
+type presignedKey struct {
+	serviceID       string
+	protocolVersion string
+}
+
+type presignedResponse struct {
+	body     []byte
+	signedAt time.Time
+}
+
+func newPresignedCache() *presignedCache {
+	return &presignedCache{entries: make(map[presignedKey]presignedResponse)}
+}
+
+func (c *presignedCache) get(key presignedKey, term, version uint64) ([]byte, bool) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	if c.term != term || c.version != version {
+		return nil, false
+	}
+	entry, found := c.entries[key]
+	if !found || time.Since(entry.signedAt) > presignedTTL {
+		return nil, false
+	}
+	return entry.body, true
+}
+
+// put caches body, dropping every entry signed at an older registry
+// position.
+func (c *presignedCache) put(key presignedKey, term, version uint64, body []byte) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.term != term || c.version != version {
+		c.term, c.version = term, version
+		c.entries = make(map[presignedKey]presignedResponse)
+	}
+	c.entries[key] = presignedResponse{body: body, signedAt: time.Now()}
+}
+
+// servePublicKey answers /public-key lookups from the pre-signed responses.
+// Lookups that carry request IDs or a detached signature need a response
+// signed for them alone, and go through getPublicKey every time.
+func (as *AuthService) servePublicKey(bound http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(models.HeaderRequestID) != "" || r.Header.Get(models.HeaderParentID) != "" ||
+			r.Header.Get(models.HeaderMeshSignature) != "" {
+			bound(w, r)
+			return
+		}
+
+		protocolVersion, ok := as.server.NegotiateVersion(w, r)
+		if !ok {
+			return
+		}
+		as.server.Metrics().CountRequest("")
+
+		key := presignedKey{serviceID: mux.Vars(r)["serviceID"], protocolVersion: protocolVersion}
+		term, version := as.position()
+		body, found := as.presigned.get(key, term, version)
+		if !found {
+			var err error
+			if body, err = as.presignPublicKey(r, protocolVersion); err != nil {
+				middleware.WriteError(w, r, err)
+				return
+			}
+			as.presigned.put(key, term, version, body)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(body)
+	}
+}
+
+// presignPublicKey signs the /public-key response r asks for, without
+// binding it to any request.
+func (as *AuthService) presignPublicKey(r *http.Request, protocolVersion string) ([]byte, error) {
+	result, err := as.getPublicKey(&mesh.Request{Request: r, ProtocolVersion: protocolVersion})
+	if err != nil {
+		return nil, err
+	}
+	payload, err := json.Marshal(result)
+	if err != nil {
+		return nil, err
+	}
+	response, err := as.server.SignResponse(protocolVersion, &models.ServiceRequest{}, payload)
+	if err != nil {
+		return nil, err
+	}
+	body, err := json.Marshal(response)
+	if err != nil {
+		return nil, err
+	}
+	return append(body, '\n'), nil
+}
@@ -0,0 +1,125 @@
+package auth
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"quantum-safe-mesh/pkg/config"
+	"quantum-safe-mesh/pkg/meshtest"
+	"quantum-safe-mesh/pkg/models"
+	"quantum-safe-mesh/pkg/pqc"
+)
+
+// register registers signer's key as serviceID directly through the
+// router, in a request signed with it.
+func register(t testing.TB, handler http.Handler, serviceID string, signer pqc.Signer) {
+	t.Helper()
+	data, _ := json.Marshal(models.ServiceKeyPair{
+		ProtocolVersion: models.CurrentProtocolVersion,
+		ServiceID:       serviceID,
+		PublicKey:       signer.GetPublicKeyBytes(),
+	})
+	body := signedRequest(t, models.CurrentProtocolVersion, serviceID, signer, data)
+	req := httptest.NewRequest("POST", "/register", bytes.NewReader(body))
+	req.Header.Set(models.HeaderAcceptVersion, models.AcceptVersionHeader())
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("register %s: status %d: %s", serviceID, recorder.Code, recorder.Body)
+	}
+}
+
+func TestPresignedPublicKey(t *testing.T) {
+	as, server := startAuthService(t)
+	handler := as.router(config.Defaults(config.ServiceAuth))
+	identity := meshtest.Identity("presigned")
+	register(t, handler, identity.ServiceID, identity.Signer)
+
+	lookup := func(requestID string) []byte {
+		req, _ := http.NewRequest("GET", server.URL+"/public-key/"+identity.ServiceID, nil)
+		req.Header.Set(models.HeaderAcceptVersion, models.AcceptVersionHeader())
+		if requestID != "" {
+			req.Header.Set(models.HeaderRequestID, requestID)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("GET /public-key: %v", err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		readEnvelope(t, as, &http.Response{Body: io.NopCloser(bytes.NewReader(body))})
+		return body
+	}
+
+	first := lookup("")
+	if second := lookup(""); !bytes.Equal(first, second) {
+		t.Fatal("unbound lookups of an unchanged key were signed again")
+	}
+
+	var bound models.ServiceResponse
+	json.Unmarshal(lookup("bound-lookup"), &bound)
+	if bound.RequestID != "bound-lookup" {
+		t.Fatalf("lookup with a request ID answered for %q", bound.RequestID)
+	}
+
+	next := meshtest.Identity("presigned-next")
+	rotation := models.KeyRotationRequest{ServiceID: identity.ServiceID, NewPublicKey: next.PublicKey()}
+	proofPayload, _ := rotation.ProofPayload()
+	rotation.Proof, _ = next.Signer.Sign(proofPayload)
+	data, _ := json.Marshal(rotation)
+	req := httptest.NewRequest("POST", "/rotate", bytes.NewReader(signedRequest(t, models.CurrentProtocolVersion, identity.ServiceID, identity.Signer, data)))
+	req.Header.Set(models.HeaderAcceptVersion, models.AcceptVersionHeader())
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("rotate %s: status %d: %s", identity.ServiceID, recorder.Code, recorder.Body)
+	}
+
+	var envelope models.ServiceResponse
+	var response models.PublicKeyResponse
+	json.Unmarshal(lookup(""), &envelope)
+	json.Unmarshal(envelope.Data, &response)
+	if !bytes.Equal(response.PublicKey, next.PublicKey()) {
+		t.Fatal("pre-signed response still served the replaced key")
+	}
+}
+
+// BenchmarkPublicKeyLookup measures /public-key lookups through the auth
+// service's router, without the network: pre-signed responses for callers
+// that send no request ID, and freshly signed ones for callers that do.
+func BenchmarkPublicKeyLookup(b *testing.B) {
+	as, _ := startAuthService(b)
+	handler := as.router(config.Defaults(config.ServiceAuth))
+	identity := meshtest.Identity("hot-service")
+	register(b, handler, identity.ServiceID, identity.Signer)
+
+	for _, bench := range []struct {
+		name      string
+		requestID string
+	}{
+		{"presigned", ""},
+		{"bound", "bench-request"},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					req := httptest.NewRequest("GET", "/public-key/"+identity.ServiceID, nil)
+					req.Header.Set(models.HeaderAcceptVersion, models.AcceptVersionHeader())
+					if bench.requestID != "" {
+						req.Header.Set(models.HeaderRequestID, bench.requestID)
+					}
+					recorder := httptest.NewRecorder()
+					handler.ServeHTTP(recorder, req)
+					if recorder.Code != http.StatusOK {
+						b.Fatalf("status %d", recorder.Code)
+					}
+				}
+			})
+			b.ReportMetric(float64(b.N)/b.Elapsed().Seconds(), "lookups/s")
+		})
+	}
+}
@@ -0,0 +1,467 @@
+// Package backend is the mesh's backend service: it processes the verified
+// requests the gateway forwards, through its pipelines, and signs its
+// responses.
+package backend
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"quantum-safe-mesh/pkg/buildinfo"
+	"quantum-safe-mesh/pkg/channel"
+	"quantum-safe-mesh/pkg/cloudattest"
+	"quantum-safe-mesh/pkg/config"
+	"quantum-safe-mesh/pkg/discovery"
+	"quantum-safe-mesh/pkg/health"
+	"quantum-safe-mesh/pkg/ledger"
+	"quantum-safe-mesh/pkg/mesh"
+	"quantum-safe-mesh/pkg/meshlog"
+	"quantum-safe-mesh/pkg/meshmsg"
+	"quantum-safe-mesh/pkg/middleware"
+	"quantum-safe-mesh/pkg/models"
+	"quantum-safe-mesh/pkg/pipeline"
+	"quantum-safe-mesh/pkg/pqc"
+	"quantum-safe-mesh/pkg/telemetry"
+)
+
+// newResolver configures peer discovery from the discovery settings, a list
+// of sources tried in order. It returns nil for static URLs.
+func newResolver(cfg *config.Config, registry *mesh.RegistryClient) (mesh.Resolver, error) {
+	return discovery.New(discovery.Config{
+		Modes:       cfg.DiscoveryModes(),
+		DNSDomain:   cfg.Discovery.DNSDomain,
+		DNSService:  cfg.Discovery.DNSService,
+		ConsulAddr:  cfg.Discovery.ConsulAddr,
+		ConsulToken: cfg.Discovery.ConsulToken,
+		TTL:         cfg.Discovery.TTL,
+	}, registry)
+}
+
+// processSchema is what /process takes and returns: a named operation on an
+// object of data, and the data transformed.
+var processSchema = mesh.EndpointSchema{
+	Request: mesh.MustCompileSchema(`{
+		"type": "object",
+		"required": ["operation"],
+		"properties": {
+			"operation": {"type": "string", "minLength": 1, "maxLength": 128},
+			"data": {"type": "object"},
+			"metadata": {"type": "object"}
+		}
+	}`),
+	Response: mesh.MustCompileSchema(`{
+		"type": "object",
+		"required": ["service_id", "operation", "input", "request_count"],
+		"properties": {
+			"service_id": {"type": "string"},
+			"operation": {"const": "data_transformation"},
+			"input": {"type": "object"},
+			"request_count": {"type": "integer", "minimum": 1}
+		}
+	}`),
+}
+
+// builtInRoutes are the backend's own routes, which pipelines can't take.
+var builtInRoutes = map[string]bool{
+	"/echo": true, "/files": true, "/status": true, "/config": true,
+	"/metrics": true, "/audit": true, "/version": true,
+	"/health": true, "/healthz": true, "/readyz": true, "/startupz": true,
+}
+
+type BackendService struct {
+	identity       *mesh.Identity
+	registry       *mesh.RegistryClient
+	server         *mesh.VerifyingServer
+	namespaces     *mesh.NamespacePolicy
+	ledger         *ledger.Ledger // nil unless processing is recorded
+	idempotency    mesh.IdempotencyStore
+	idempotencyTTL time.Duration
+	rateLimits     *mesh.RateLimits // limits no route unless rate_limit.limits is set
+	pipelines      *pipeline.Routes
+	mutex          sync.RWMutex
+	requestCounter int
+}
+
+func NewBackendService(cfg *config.Config) (*BackendService, error) {
+	log.Println("🚀 Starting Backend Service...")
+
+	identity, err := cfg.Keys.LoadIdentity(cfg.Service.ID, cfg.Crypto.Signature, cfg.Crypto.KEM)
+	if err != nil {
+		return nil, err
+	}
+
+	registry := mesh.NewRegistryClient(cfg.Auth.URL, identity, mesh.NewPeerVersions())
+	registry.SetTimeout(cfg.Timeouts.Client)
+	registry.SetKeyLookupTimeout(cfg.Timeouts.KeyLookup)
+
+	resolver, err := newResolver(cfg, registry)
+	if err != nil {
+		return nil, err
+	}
+	if resolver != nil {
+		registry.UseResolver(resolver)
+	}
+	if cfg.Service.AdvertiseURL != "" {
+		registry.Advertise(cfg.Service.AdvertiseURL)
+	}
+
+	bs := &BackendService{
+		identity:       identity,
+		registry:       registry,
+		server:         mesh.NewVerifyingServer(identity, registry.PublicKey),
+		requestCounter: 0,
+	}
+	bs.server.UseKeyResolver(registry)
+	bs.server.UseFreshness(cfg.Timeouts.RequestMaxAge, cfg.Timeouts.ClockSkew)
+	replays, err := mesh.OpenReplayStore(cfg.Replay.Store, identity.ServiceID)
+	if err != nil {
+		return nil, err
+	}
+	bs.server.UseReplayStore(replays)
+	bs.server.UseAdmission(cfg.Admission.Controller(bs.server.Metrics()))
+
+	if bs.namespaces, err = mesh.ParseNamespacePolicy(cfg.Policy.NamespaceRoutes, cfg.Policy.NamespaceTrust); err != nil {
+		return nil, err
+	}
+	if bs.namespaces != nil {
+		bs.server.UseNamespacePolicy(bs.namespaces)
+		log.Println("🏢 Enforcing namespace trust boundaries on inbound calls")
+	}
+
+	if cfg.Policy.SPIFFESocket != "" {
+		registry.UseSPIFFE(cfg.Policy.SPIFFESocket)
+	}
+	if cfg.Policy.KubeSATokenFile != "" {
+		registry.UseServiceAccountToken(cfg.Policy.KubeSATokenFile)
+	}
+	if cfg.Policy.CloudAttestProvider != "" {
+		registry.UseCloudAttestation(cloudattest.NewClient(cfg.Policy.CloudAttestProvider, cfg.Policy.CloudAttestAzureResource))
+	}
+	if cfg.Policy.BootstrapToken != "" {
+		registry.UseBootstrapToken(cfg.Policy.BootstrapToken)
+	}
+	if cfg.Policy.PinFile != "" {
+		pins, err := pqc.OpenPinStore(cfg.Policy.PinFile)
+		if err != nil {
+			return nil, err
+		}
+		registry.UsePinStore(pins)
+	}
+	if cfg.Snapshot.File != "" {
+		registry.UseSnapshotFile(cfg.Snapshot.File, cfg.Snapshot.Signer)
+	}
+
+	if cfg.Ledger.Path != "" {
+		if bs.ledger, err = ledger.Open(cfg.Ledger.Path, identity); err != nil {
+			return nil, err
+		}
+	}
+
+	if bs.idempotency, err = mesh.OpenIdempotencyStore(cfg.Idempotency.Store, identity.ServiceID); err != nil {
+		return nil, err
+	}
+	bs.idempotencyTTL = cfg.Idempotency.TTL
+
+	if bs.pipelines, err = pipeline.ParseRoutes(cfg.Pipeline.Routes); err != nil {
+		return nil, err
+	}
+	for _, path := range bs.pipelines.Paths {
+		if builtInRoutes[path] || strings.HasPrefix(path, "/audit/") {
+			return nil, fmt.Errorf("pipeline path %s is taken by a built-in route", path)
+		}
+	}
+
+	bs.rateLimits = mesh.NewRateLimits()
+	if err := bs.setRateLimits(cfg.RateLimit.Limits); err != nil {
+		return nil, err
+	}
+
+	if err := registry.Register(); err != nil {
+		log.Printf("Warning: failed to register with auth service: %v", err)
+	} else if cfg.Keys.RotationDue(identity) {
+		if err := registry.RotateIdentity(); err != nil {
+			log.Printf("Warning: failed to rotate expiring key: %v", err)
+		}
+	}
+
+	log.Printf("✅ Backend Service initialized with ID: %s", identity.ServiceID)
+	return bs, nil
+}
+
+func (bs *BackendService) nextRequestCount() int {
+	bs.mutex.Lock()
+	defer bs.mutex.Unlock()
+
+	bs.requestCounter++
+	return bs.requestCounter
+}
+
+// recorded wraps h to record the requests it processes in the ledger, if
+// there is one.
+func (bs *BackendService) recorded(h mesh.Handler) mesh.Handler {
+	if bs.ledger == nil {
+		return h
+	}
+	return bs.ledger.Recording(h)
+}
+
+// mutating wraps a handler that changes state: requests with an
+// idempotency key are processed, and recorded, once per key.
+func (bs *BackendService) mutating(h mesh.Handler) mesh.Handler {
+	return mesh.Idempotent(bs.idempotency, bs.idempotencyTTL, bs.recorded(h))
+}
+
+// pipelineHandler returns the handler running path's pipeline, checked
+// against /process's schemas on /process.
+func (bs *BackendService) pipelineHandler(path string) mesh.Handler {
+	p, _ := bs.pipelines.Pipeline(path)
+	h := bs.pipelined(path, p)
+	if path == "/process" {
+		h = mesh.WithSchema(processSchema, h)
+	}
+	return bs.mutating(h)
+}
+
+// limited wraps h to refuse callers over their rate limit. The limits may
+// change while the service runs, so every route is wrapped.
+func (bs *BackendService) limited(h mesh.Handler) mesh.Handler {
+	return bs.rateLimits.Limit(h)
+}
+
+// setRateLimits replaces the limits callers are held to with limits.
+func (bs *BackendService) setRateLimits(limits string) error {
+	if err := bs.rateLimits.Update(limits); err != nil {
+		return err
+	}
+	if limits != "" {
+		log.Printf("🚦 Rate limiting callers: %s", limits)
+	}
+	return nil
+}
+
+func (bs *BackendService) processEcho(req *mesh.Request) (interface{}, error) {
+	start := time.Now()
+	logger := meshlog.FromContext(req.Context())
+	logger.Info("🔄 Processing echo request")
+
+	request := req.Envelope
+	currentCount := bs.nextRequestCount()
+
+	var requestData interface{}
+	if err := json.Unmarshal(request.Data, &requestData); err != nil {
+		requestData = string(request.Data)
+	}
+
+	responseData := map[string]interface{}{
+		"message":         "Echo from Backend Service",
+		"original_data":   requestData,
+		"service_id":      bs.identity.ServiceID,
+		"request_count":   currentCount,
+		"timestamp":       time.Now(),
+		"processing_time": time.Since(start).String(),
+		"from_service":    request.ServiceID,
+	}
+
+	logger.Info("✅ Echo request processed", "request_count", currentCount, "duration", time.Since(start))
+
+	return responseData, nil
+}
+
+// processFile handles a streamed upload, known by the digest its sender
+// signed; the response echoes the digest, so the result is tied to exactly
+// the bytes received.
+func (bs *BackendService) processFile(req *mesh.Request) (interface{}, error) {
+	start := time.Now()
+	logger := meshlog.FromContext(req.Context())
+	logger.Info("🧠 Processing file", "size", req.Content.Digest.Size)
+
+	head := make([]byte, 512)
+	n, err := io.ReadFull(req.Content.File, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
+	currentCount := bs.nextRequestCount()
+
+	result := map[string]interface{}{
+		"service_id":      bs.identity.ServiceID,
+		"operation":       "file_processing",
+		"detected_type":   http.DetectContentType(head[:n]),
+		"request_count":   currentCount,
+		"processing_time": time.Since(start).String(),
+	}
+
+	logger.Info("✅ File processing completed", "request_count", currentCount, "duration", time.Since(start))
+	return result, nil
+}
+
+func (bs *BackendService) getStatus(req *mesh.Request) (interface{}, error) {
+	log.Println("📊 Status request received")
+
+	bs.mutex.RLock()
+	currentCount := bs.requestCounter
+	bs.mutex.RUnlock()
+
+	statusData := map[string]interface{}{
+		"service_id":       bs.identity.ServiceID,
+		"status":           "healthy",
+		"requests_handled": currentCount,
+		"uptime":           time.Since(time.Now().Add(-time.Hour)).String(),
+		"quantum_safe":     true,
+		"algorithms": map[string]string{
+			"signature": pqc.JWSAlg(bs.identity.Signer.Algorithm()),
+			"kem":       "Kyber768",
+		},
+		"timestamp": time.Now(),
+	}
+
+	log.Println("✅ Status response sent")
+	return statusData, nil
+}
+
+// getProcessingRecord returns the signed ledger records of the request ID
+// in the path.
+func (bs *BackendService) getProcessingRecord(req *mesh.Request) (interface{}, error) {
+	requestID := mux.Vars(req.Request)["requestID"]
+	records, err := bs.ledger.Lookup(requestID)
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, models.NewError(http.StatusNotFound, models.ErrCodeNotFound, "No processing record for request "+requestID)
+	}
+
+	log.Printf("📒 Returning %d processing records of %s", len(records), requestID)
+	return map[string]interface{}{
+		"request_id": requestID,
+		"records":    records,
+	}, nil
+}
+
+// consumeJobs runs /process's pipeline for jobs published on the message bus and
+// publishes each signed result back for the gateway.
+func (bs *BackendService) consumeJobs(bus meshmsg.Bus) error {
+	publisher := meshmsg.NewPublisher(bs.identity, bus)
+	consumer := meshmsg.NewConsumer(bus, bs.registry)
+	process := bs.pipelineHandler("/process")
+
+	return consumer.Subscribe(meshmsg.SubjectProcess, func(msg *meshmsg.Message) error {
+		ctx := meshlog.With(context.Background(), "request_id", msg.Envelope.RequestID, "caller", msg.Envelope.ServiceID)
+		httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, "/process", nil)
+		if err != nil {
+			return err
+		}
+
+		result, err := process(&mesh.Request{
+			Request:         httpReq,
+			Envelope:        msg.Envelope,
+			ProtocolVersion: msg.Envelope.ProtocolVersion,
+		})
+		if err != nil {
+			return err
+		}
+
+		data, err := mesh.MarshalPayload(result)
+		if err != nil {
+			return fmt.Errorf("failed to marshal result: %w", err)
+		}
+
+		return publisher.Publish(meshmsg.SubjectResults, &meshmsg.Message{
+			RequestID: msg.RequestID,
+			ParentID:  msg.ParentID,
+			Data:      data,
+		})
+	})
+}
+
+// Start starts the backend's audit sinks, channel listener and job consumer,
+// as configured, and returns its routes.
+func (bs *BackendService) Start(cfg *config.Config) (http.Handler, error) {
+	if err := cfg.Audit.Apply(bs.server.Audit()); err != nil {
+		return nil, fmt.Errorf("failed to start audit sinks: %w", err)
+	}
+
+	r := mux.NewRouter()
+	r.NotFoundHandler = models.NotFoundHandler
+	r.MethodNotAllowedHandler = models.MethodNotAllowedHandler
+	r.Use(telemetry.Middleware, middleware.Recover, middleware.Deadline(cfg.Timeouts.Request))
+
+	server := bs.server
+	echo := bs.limited(bs.mutating(bs.processEcho))
+	r.HandleFunc("/echo", server.Verified(echo)).Methods("POST")
+	if cfg.Debug.Passthrough {
+		r.HandleFunc(mesh.PassthroughPath, server.Passthrough(echo)).Methods("POST")
+		log.Printf("⚠️  Serving %s unsigned for benchmarks; never enable BENCHMARK_PASSTHROUGH in production", mesh.PassthroughPath)
+	}
+	channelRoutes := map[string]mesh.Handler{
+		"/echo":   echo,
+		"/status": bs.getStatus,
+	}
+	for _, path := range bs.pipelines.Paths {
+		handler := bs.limited(bs.pipelineHandler(path))
+		r.HandleFunc(path, server.Verified(handler)).Methods("POST")
+		channelRoutes[path] = handler
+	}
+	r.HandleFunc("/files", server.VerifiedContent(bs.limited(bs.mutating(bs.processFile)))).Methods("POST", "PUT")
+	r.HandleFunc("/status", server.Signed(bs.getStatus)).Methods("GET", "POST")
+
+	r.HandleFunc("/config", cfg.Handler()).Methods("GET")
+	r.HandleFunc("/metrics", server.Metrics().Handler()).Methods("GET")
+	r.HandleFunc("/audit", server.Audit().Handler()).Methods("GET")
+	r.HandleFunc("/version", buildinfo.Handler(buildinfo.Get(cfg.Crypto.Signature, cfg.Crypto.KEM))).Methods("GET")
+	if bs.ledger != nil {
+		r.HandleFunc("/audit/{requestID}", server.Signed(bs.getProcessingRecord)).Methods("GET")
+	}
+
+	probes := health.New()
+	probes.Add(health.Startup, "registered", bs.registry.CheckRegistered)
+	probes.Add(health.Readiness, "keys", cfg.Keys.CheckKeys(bs.identity))
+	probes.Add(health.Readiness, "auth", bs.registry.CheckAuth)
+	probes.Register(r)
+
+	if cfg.Service.ChannelAddr != "" {
+		listener, err := channel.Listen(cfg.Service.ChannelAddr, bs.identity, bs.registry.PublicKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to start channel listener: %w", err)
+		}
+
+		handler := channel.MeshHandler(channelRoutes)
+		if bs.namespaces != nil {
+			handler = channel.NamespaceHandler(bs.namespaces, handler)
+		}
+
+		log.Printf("🔗 Channel listener starting on %s", listener.Addr())
+		go func() {
+			log.Fatal(channel.Serve(listener, handler))
+		}()
+	}
+
+	if busURL := cfg.Messaging.BusURL; busURL != "" {
+		// The bus stays connected for as long as the process runs.
+		bus, err := meshmsg.Connect(busURL, bs.identity.ServiceID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to connect to message bus: %w", err)
+		}
+		if err := bs.consumeJobs(bus); err != nil {
+			bus.Close()
+			return nil, fmt.Errorf("failed to subscribe to %s: %w", meshmsg.SubjectProcess, err)
+		}
+		log.Printf("📬 Consuming signed jobs from %s on %s", busURL, meshmsg.SubjectProcess)
+	}
+	return r, nil
+}
+
+// Reload applies the reloadable settings of cfg.
+func (bs *BackendService) Reload(cfg *config.Config) {
+	bs.server.UseFreshness(cfg.Timeouts.RequestMaxAge, cfg.Timeouts.ClockSkew)
+	if err := bs.setRateLimits(cfg.RateLimit.Limits); err != nil {
+		log.Printf("⚠️ Failed to change rate limits: %v", err)
+	}
+}
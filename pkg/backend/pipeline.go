@@ -0,0 +1,65 @@
+package backend
+
+import (
+	"time"
+
+	"quantum-safe-mesh/pkg/mesh"
+	"quantum-safe-mesh/pkg/meshlog"
+	"quantum-safe-mesh/pkg/pipeline"
+	"quantum-safe-mesh/pkg/pqc"
+)
+
+// The demo's processing, as pipeline processors. Add a file like this one
+// to register processors of your own, and name them in BACKEND_PIPELINES.
+func init() {
+	pipeline.Register(pipeline.Transform, "data-transformation", transformData)
+	pipeline.Register(pipeline.Enrich, "pqc-metadata", addPQCMetadata)
+}
+
+// transformData answers with the request's data, as an object if it is
+// one and as a string otherwise.
+func transformData(doc *pipeline.Document) error {
+	input := doc.Input
+	if input == nil {
+		input = map[string]interface{}{
+			"raw_data": string(doc.Request.Envelope.Data),
+		}
+	}
+
+	doc.Result["service_id"] = doc.Service.ServiceID
+	doc.Result["operation"] = "data_transformation"
+	doc.Result["input"] = input
+	doc.Result["transformed_at"] = time.Now()
+	doc.Result["processing_time"] = time.Since(doc.Started).String()
+	return nil
+}
+
+// addPQCMetadata notes how the request was protected and who sent it.
+func addPQCMetadata(doc *pipeline.Document) error {
+	doc.Result["metadata"] = map[string]interface{}{
+		"quantum_safe": true,
+		"algorithm":    pqc.JWSAlg(doc.Service.Signer.Algorithm()),
+		"from_service": doc.Request.Envelope.ServiceID,
+	}
+	return nil
+}
+
+// pipelined serves path by running its pipeline, numbering each request
+// in the result's request_count.
+func (bs *BackendService) pipelined(path string, p *pipeline.Pipeline) mesh.Handler {
+	run := p.Handler(bs.identity, func(doc *pipeline.Document) {
+		doc.Result["request_count"] = bs.nextRequestCount()
+	})
+	return func(req *mesh.Request) (interface{}, error) {
+		start := time.Now()
+		logger := meshlog.FromContext(req.Context())
+		logger.Info("🧠 Processing request", "path", path)
+
+		result, err := run(req)
+		if err != nil {
+			return nil, err
+		}
+		logger.Info("✅ Request completed", "path", path, "duration", time.Since(start))
+		return result, nil
+	}
+}
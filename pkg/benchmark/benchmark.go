@@ -0,0 +1,242 @@
+// Package benchmark measures the mesh's signature and KEM algorithms and
+// records the results in a form that can be saved and compared across runs.
+package benchmark
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/binary"
+	"fmt"
+	"runtime"
+	"time"
+
+	"quantum-safe-mesh/pkg/pqc"
+)
+
+// Operation names used in Result.Op.
+const (
+	OpKeygen      = "keygen"
+	OpSign        = "sign"
+	OpVerify      = "verify"
+	OpEncapsulate = "encapsulate"
+	OpDecapsulate = "decapsulate"
+)
+
+// Result is the cost of one operation of one algorithm. OutputBytes is the
+// size of what the operation produces on the wire: the signature for
+// signing and verification, the ciphertext for encapsulation and
+// decapsulation, and zero for key generation.
+type Result struct {
+	Algorithm       string  `json:"algorithm"`
+	Op              string  `json:"op"`
+	Iterations      int     `json:"iterations"`
+	NsPerOp         float64 `json:"ns_per_op"`
+	PublicKeyBytes  int     `json:"public_key_bytes"`
+	PrivateKeyBytes int     `json:"private_key_bytes"`
+	OutputBytes     int     `json:"output_bytes"`
+}
+
+// Key identifies the measurement a result belongs to across runs.
+func (r Result) Key() string {
+	return r.Algorithm + "/" + r.Op
+}
+
+// Report is one benchmark run.
+type Report struct {
+	Timestamp time.Time `json:"timestamp"`
+	GoVersion string    `json:"go_version"`
+	GOOS      string    `json:"goos"`
+	GOARCH    string    `json:"goarch"`
+	CPUs      int       `json:"cpus"`
+	Results   []Result  `json:"results"`
+}
+
+// Run measures every algorithm, repeating each operation until budget has
+// elapsed. The pqc package logs every operation, so callers usually discard
+// log output while this runs.
+func Run(budget time.Duration) (*Report, error) {
+	report := &Report{
+		Timestamp: time.Now().UTC(),
+		GoVersion: runtime.Version(),
+		GOOS:      runtime.GOOS,
+		GOARCH:    runtime.GOARCH,
+		CPUs:      runtime.NumCPU(),
+	}
+
+	for _, suite := range []func(time.Duration) ([]Result, error){runRSA, runDilithium, runKyber} {
+		results, err := suite(budget)
+		if err != nil {
+			return nil, err
+		}
+		report.Results = append(report.Results, results...)
+	}
+	return report, nil
+}
+
+var testMessage = []byte("This is a test message for signature performance comparison")
+
+// variedMessage is testMessage with counter appended. Dilithium signing is
+// deterministic and its cost depends on how many rejection-sampling rounds
+// a message needs, so signing one fixed message would measure that message
+// rather than the average.
+func variedMessage(counter uint64) []byte {
+	return binary.BigEndian.AppendUint64(append([]byte(nil), testMessage...), counter)
+}
+
+func runRSA(budget time.Duration) ([]Result, error) {
+	const algorithm = "RSA-2048"
+
+	var privateKey *rsa.PrivateKey
+	keygen, err := measure(budget, func() (err error) {
+		privateKey, err = rsa.GenerateKey(rand.Reader, 2048)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to benchmark %s key generation: %w", algorithm, err)
+	}
+
+	digest := sha256.Sum256(testMessage)
+	var signature []byte
+	sign, err := measure(budget, func() (err error) {
+		signature, err = rsa.SignPKCS1v15(rand.Reader, privateKey, crypto.SHA256, digest[:])
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to benchmark %s signing: %w", algorithm, err)
+	}
+
+	verify, err := measure(budget, func() error {
+		return rsa.VerifyPKCS1v15(&privateKey.PublicKey, crypto.SHA256, digest[:], signature)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to benchmark %s verification: %w", algorithm, err)
+	}
+
+	publicKeyBytes, err := x509.MarshalPKIXPublicKey(&privateKey.PublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal %s public key: %w", algorithm, err)
+	}
+	sizes := Result{
+		Algorithm:       algorithm,
+		PublicKeyBytes:  len(publicKeyBytes),
+		PrivateKeyBytes: len(x509.MarshalPKCS1PrivateKey(privateKey)),
+	}
+	return []Result{
+		keygen.result(sizes, OpKeygen, 0),
+		sign.result(sizes, OpSign, len(signature)),
+		verify.result(sizes, OpVerify, len(signature)),
+	}, nil
+}
+
+func runDilithium(budget time.Duration) ([]Result, error) {
+	const algorithm = "Dilithium3"
+
+	var keyPair *pqc.DilithiumKeyPair
+	keygen, err := measure(budget, func() (err error) {
+		keyPair, err = pqc.GenerateDilithiumKeyPair()
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to benchmark %s key generation: %w", algorithm, err)
+	}
+
+	var signature []byte
+	var counter uint64
+	sign, err := measure(budget, func() (err error) {
+		counter++
+		signature, err = keyPair.Sign(variedMessage(counter))
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to benchmark %s signing: %w", algorithm, err)
+	}
+
+	publicKey := keyPair.GetPublicKeyBytes()
+	message := variedMessage(counter)
+	verify, err := measure(budget, func() error {
+		return pqc.VerifyDilithiumSignature(publicKey, message, signature)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to benchmark %s verification: %w", algorithm, err)
+	}
+
+	sizes := Result{
+		Algorithm:       algorithm,
+		PublicKeyBytes:  len(publicKey),
+		PrivateKeyBytes: len(keyPair.GetPrivateKeyBytes()),
+	}
+	return []Result{
+		keygen.result(sizes, OpKeygen, 0),
+		sign.result(sizes, OpSign, len(signature)),
+		verify.result(sizes, OpVerify, len(signature)),
+	}, nil
+}
+
+func runKyber(budget time.Duration) ([]Result, error) {
+	const algorithm = "Kyber768"
+
+	var keyPair *pqc.KyberKeyPair
+	keygen, err := measure(budget, func() (err error) {
+		keyPair, err = pqc.GenerateKyberKeyPair()
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to benchmark %s key generation: %w", algorithm, err)
+	}
+
+	var ciphertext []byte
+	encapsulate, err := measure(budget, func() (err error) {
+		ciphertext, _, err = keyPair.Encapsulate()
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to benchmark %s encapsulation: %w", algorithm, err)
+	}
+
+	decapsulate, err := measure(budget, func() error {
+		_, err := keyPair.Decapsulate(ciphertext)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to benchmark %s decapsulation: %w", algorithm, err)
+	}
+
+	sizes := Result{
+		Algorithm:       algorithm,
+		PublicKeyBytes:  len(keyPair.GetPublicKeyBytes()),
+		PrivateKeyBytes: len(keyPair.GetPrivateKeyBytes()),
+	}
+	return []Result{
+		keygen.result(sizes, OpKeygen, 0),
+		encapsulate.result(sizes, OpEncapsulate, len(ciphertext)),
+		decapsulate.result(sizes, OpDecapsulate, len(ciphertext)),
+	}, nil
+}
+
+type measurement struct {
+	iterations int
+	nsPerOp    float64
+}
+
+// result is sizes, an algorithm's key sizes, completed with op's timing.
+func (m measurement) result(sizes Result, op string, outputBytes int) Result {
+	sizes.Op, sizes.Iterations, sizes.NsPerOp, sizes.OutputBytes = op, m.iterations, m.nsPerOp, outputBytes
+	return sizes
+}
+
+// measure runs op until budget has elapsed, and at least once.
+func measure(budget time.Duration, op func() error) (measurement, error) {
+	var m measurement
+	start := time.Now()
+	for m.iterations == 0 || time.Since(start) < budget {
+		if err := op(); err != nil {
+			return m, err
+		}
+		m.iterations++
+	}
+	m.nsPerOp = float64(time.Since(start).Nanoseconds()) / float64(m.iterations)
+	return m, nil
+}
@@ -0,0 +1,154 @@
+package benchmark
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// Delta compares one measurement across two runs. Change is the fractional
+// change in ns/op, positive when the current run is slower; it is zero when
+// the measurement is missing from either run.
+type Delta struct {
+	Algorithm   string  `json:"algorithm"`
+	Op          string  `json:"op"`
+	BaselineNs  float64 `json:"baseline_ns_per_op,omitempty"`
+	CurrentNs   float64 `json:"current_ns_per_op,omitempty"`
+	Change      float64 `json:"change"`
+	Regression  bool    `json:"regression"`
+	SizeChanged bool    `json:"size_changed,omitempty"`
+	Missing     string  `json:"missing,omitempty"` // "baseline" or "current"
+}
+
+// Comparison is the result of Compare.
+type Comparison struct {
+	Threshold   float64 `json:"threshold"`
+	Regressions int     `json:"regressions"`
+	Deltas      []Delta `json:"deltas"`
+}
+
+// Compare diffs current against baseline. A measurement more than
+// threshold (0.1 for 10%) slower than its baseline is a regression; so is
+// one whose public key, signature or ciphertext size changed, since those
+// are fixed by the parameter set and a change means something else is being
+// measured. Private key sizes are not compared: RSA's DER encoding varies by
+// a byte or two between keys.
+func Compare(baseline, current *Report, threshold float64) *Comparison {
+	comparison := &Comparison{Threshold: threshold}
+
+	previous := make(map[string]Result, len(baseline.Results))
+	for _, result := range baseline.Results {
+		previous[result.Key()] = result
+	}
+
+	for _, result := range current.Results {
+		delta := Delta{Algorithm: result.Algorithm, Op: result.Op, CurrentNs: result.NsPerOp}
+
+		old, exists := previous[result.Key()]
+		delete(previous, result.Key())
+		if !exists {
+			delta.Missing = "baseline"
+			comparison.Deltas = append(comparison.Deltas, delta)
+			continue
+		}
+
+		delta.BaselineNs = old.NsPerOp
+		if old.NsPerOp > 0 {
+			delta.Change = result.NsPerOp/old.NsPerOp - 1
+		}
+		delta.SizeChanged = old.PublicKeyBytes != result.PublicKeyBytes || old.OutputBytes != result.OutputBytes
+		delta.Regression = delta.Change > threshold || delta.SizeChanged
+		if delta.Regression {
+			comparison.Regressions++
+		}
+		comparison.Deltas = append(comparison.Deltas, delta)
+	}
+
+	// Measurements the current run no longer makes, in baseline order.
+	for _, result := range baseline.Results {
+		if _, exists := previous[result.Key()]; exists {
+			comparison.Deltas = append(comparison.Deltas, Delta{
+				Algorithm:  result.Algorithm,
+				Op:         result.Op,
+				BaselineNs: result.NsPerOp,
+				Missing:    "current",
+			})
+		}
+	}
+
+	return comparison
+}
+
+// Write writes the comparison in format.
+func (c *Comparison) Write(w io.Writer, format string) error {
+	switch format {
+	case FormatJSON:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(c)
+	case FormatCSV:
+		out := csv.NewWriter(w)
+		out.Write([]string{"algorithm", "op", "baseline_ns_per_op", "current_ns_per_op", "change", "regression", "size_changed", "missing"})
+		for _, delta := range c.Deltas {
+			out.Write([]string{
+				delta.Algorithm, delta.Op,
+				strconv.FormatFloat(delta.BaselineNs, 'f', 0, 64), strconv.FormatFloat(delta.CurrentNs, 'f', 0, 64),
+				strconv.FormatFloat(delta.Change, 'f', 4, 64),
+				strconv.FormatBool(delta.Regression), strconv.FormatBool(delta.SizeChanged), delta.Missing,
+			})
+		}
+		out.Flush()
+		return out.Error()
+	case FormatMarkdown:
+		c.writeMarkdown(w)
+		return nil
+	}
+	return fmt.Errorf("unknown report format %q", format)
+}
+
+func (c *Comparison) writeMarkdown(w io.Writer) {
+	fmt.Fprintln(w, "## 📈 PQC Benchmark Comparison")
+	fmt.Fprintln(w)
+	fmt.Fprintf(w, "Regression threshold: %.1f%% slower\n\n", c.Threshold*100)
+	fmt.Fprintln(w, "| Algorithm | Op | Baseline ns/op | Current ns/op | Change | |")
+	fmt.Fprintln(w, "|-----------|----|---------------:|--------------:|-------:|-|")
+	for _, delta := range c.Deltas {
+		var status, change string
+		switch {
+		case delta.Missing == "baseline":
+			status, change = "🆕 new", "-"
+		case delta.Missing == "current":
+			status, change = "➖ removed", "-"
+		default:
+			change = fmt.Sprintf("%+.1f%%", delta.Change*100)
+			switch {
+			case delta.SizeChanged:
+				status = "⚠️ sizes changed"
+			case delta.Regression:
+				status = "❌ regression"
+			case delta.Change < -c.Threshold:
+				status = "🚀 faster"
+			default:
+				status = "✅"
+			}
+		}
+		fmt.Fprintf(w, "| %s | %s | %s | %s | %s | %s |\n",
+			delta.Algorithm, delta.Op, formatNs(delta.BaselineNs), formatNs(delta.CurrentNs), change, status)
+	}
+
+	fmt.Fprintln(w)
+	if c.Regressions == 0 {
+		fmt.Fprintln(w, "✅ No regressions")
+	} else {
+		fmt.Fprintf(w, "❌ %d regression(s) beyond %.1f%%\n", c.Regressions, c.Threshold*100)
+	}
+}
+
+func formatNs(ns float64) string {
+	if ns == 0 {
+		return "-"
+	}
+	return strconv.FormatFloat(ns, 'f', 0, 64)
+}
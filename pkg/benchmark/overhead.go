@@ -0,0 +1,293 @@
+package benchmark
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"runtime"
+	"sort"
+	"strconv"
+	"time"
+
+	"quantum-safe-mesh/pkg/mesh"
+	"quantum-safe-mesh/pkg/models"
+)
+
+// overheadWarmup is how many requests each route gets before measuring, so
+// connections are open and public keys cached.
+const overheadWarmup = 10
+
+// Hops a request through the gateway takes.
+const (
+	HopClientGateway  = "client → gateway"
+	HopGatewayBackend = "gateway → backend"
+)
+
+// RouteLatency is the latency of one route, in milliseconds.
+type RouteLatency struct {
+	URL      string  `json:"url"`
+	Requests int     `json:"requests"`
+	Errors   int     `json:"errors"`
+	Mean     float64 `json:"mean_ms"`
+	P50      float64 `json:"p50_ms"`
+	P95      float64 `json:"p95_ms"`
+	P99      float64 `json:"p99_ms"`
+}
+
+// Stage is the mean time one step of a hop took. Service is the service
+// that spent it, or empty for time on the network, which for the first
+// hop includes the gateway's routing. Percent is its share of the mean
+// latency through the mesh.
+type Stage struct {
+	Hop     string  `json:"hop"`
+	Service string  `json:"service,omitempty"`
+	Stage   string  `json:"stage"`
+	Mean    float64 `json:"mean_ms"`
+	Percent float64 `json:"percent"`
+}
+
+// OverheadReport compares requests through the mesh, signed and verified
+// on every hop, with the same requests on the passthrough route, which
+// takes the same hops unsigned. Overhead is the difference of their mean
+// latencies and OverheadPercent that as a share of the passthrough's; Crypto
+// is the part of it spent signing and verifying.
+type OverheadReport struct {
+	Timestamp       time.Time    `json:"timestamp"`
+	GoVersion       string       `json:"go_version"`
+	GOOS            string       `json:"goos"`
+	GOARCH          string       `json:"goarch"`
+	CPUs            int          `json:"cpus"`
+	Mesh            RouteLatency `json:"mesh"`
+	Passthrough     RouteLatency `json:"passthrough"`
+	Overhead        float64      `json:"overhead_ms"`
+	OverheadPercent float64      `json:"overhead_percent"`
+	Crypto          float64      `json:"crypto_ms"`
+	Stages          []Stage      `json:"stages"`
+}
+
+// RunOverhead sends requests requests to meshURL and passthroughURL, one
+// at a time and alternating between them so both see the same conditions,
+// and breaks the mesh's latency down by hop from the Server-Timing headers
+// of its responses.
+func RunOverhead(meshURL, passthroughURL string, requests int) (*OverheadReport, error) {
+	client := &http.Client{Timeout: 30 * time.Second}
+	body := []byte(`{"message":"overhead benchmark"}`)
+
+	send := func(url string) (time.Duration, *mesh.Timing, error) {
+		start := time.Now()
+		resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+		if err != nil {
+			return 0, nil, err
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		latency := time.Since(start)
+		if resp.StatusCode >= http.StatusBadRequest {
+			return 0, nil, fmt.Errorf("%s answered %d", url, resp.StatusCode)
+		}
+		return latency, mesh.ParseServerTiming(resp.Header.Get(models.HeaderServerTiming)), nil
+	}
+
+	for i := 0; i < overheadWarmup; i++ {
+		if _, _, err := send(passthroughURL); err != nil {
+			return nil, fmt.Errorf("passthrough route unavailable (start the gateway and backend with BENCHMARK_PASSTHROUGH=true): %w", err)
+		}
+		if _, _, err := send(meshURL); err != nil {
+			return nil, fmt.Errorf("mesh route unavailable: %w", err)
+		}
+	}
+
+	report := &OverheadReport{
+		Timestamp:   time.Now().UTC(),
+		GoVersion:   runtime.Version(),
+		GOOS:        runtime.GOOS,
+		GOARCH:      runtime.GOARCH,
+		CPUs:        runtime.NumCPU(),
+		Mesh:        RouteLatency{URL: meshURL},
+		Passthrough: RouteLatency{URL: passthroughURL},
+	}
+
+	var meshLatencies, passthroughLatencies []time.Duration
+	var timings []mesh.Timing
+	for i := 0; i < requests; i++ {
+		report.Passthrough.Requests++
+		if latency, _, err := send(passthroughURL); err != nil {
+			report.Passthrough.Errors++
+		} else {
+			passthroughLatencies = append(passthroughLatencies, latency)
+		}
+
+		report.Mesh.Requests++
+		latency, timing, err := send(meshURL)
+		if err != nil {
+			report.Mesh.Errors++
+			continue
+		}
+		meshLatencies = append(meshLatencies, latency)
+		if timing != nil {
+			timings = append(timings, *timing)
+		}
+	}
+
+	report.Mesh.summarize(meshLatencies)
+	report.Passthrough.summarize(passthroughLatencies)
+	report.Overhead = round(report.Mesh.Mean - report.Passthrough.Mean)
+	if report.Passthrough.Mean > 0 {
+		report.OverheadPercent = round(report.Overhead / report.Passthrough.Mean * 100)
+	}
+	report.addStages(timings)
+	return report, nil
+}
+
+func (l *RouteLatency) summarize(latencies []time.Duration) {
+	if len(latencies) == 0 {
+		return
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+
+	var total time.Duration
+	for _, latency := range latencies {
+		total += latency
+	}
+	percentile := func(p float64) float64 {
+		rank := int(math.Ceil(p / 100 * float64(len(latencies))))
+		return millis(latencies[max(rank, 1)-1])
+	}
+
+	l.Mean = millis(total / time.Duration(len(latencies)))
+	l.P50, l.P95, l.P99 = percentile(50), percentile(95), percentile(99)
+}
+
+// addStages breaks the mesh's mean latency down by hop, from the gateway's
+// timings and the backend's, which the gateway relays as its peer's.
+func (r *OverheadReport) addStages(timings []mesh.Timing) {
+	if len(timings) == 0 {
+		return
+	}
+
+	// Averages of each duration over the responses that reported it.
+	var gateway, backend mesh.Timing
+	backends := 0
+	for _, t := range timings {
+		addTiming(&gateway, t)
+		if t.Peer != nil {
+			addTiming(&backend, *t.Peer)
+			backends++
+		}
+	}
+	mean := func(d time.Duration, n int) float64 {
+		return millis(d / time.Duration(n))
+	}
+	n := len(timings)
+
+	stage := func(hop, service, name string, ms float64) {
+		s := Stage{Hop: hop, Service: service, Stage: name, Mean: round(ms)}
+		if r.Mesh.Mean > 0 {
+			s.Percent = round(ms / r.Mesh.Mean * 100)
+		}
+		r.Stages = append(r.Stages, s)
+	}
+
+	stage(HopClientGateway, "", "network", math.Max(r.Mesh.Mean-mean(gateway.Total(), n), 0))
+	stage(HopGatewayBackend, "gateway", "marshal", mean(gateway.Marshal, n))
+	stage(HopGatewayBackend, "gateway", "sign", mean(gateway.Sign, n))
+	network := mean(gateway.Upstream, n)
+	if backends > 0 {
+		network = math.Max(network-mean(backend.Total(), backends), 0)
+	}
+	stage(HopGatewayBackend, "", "network", network)
+	if backends > 0 {
+		stage(HopGatewayBackend, "backend", "verify", mean(backend.Verify, backends))
+		stage(HopGatewayBackend, "backend", "handler", mean(backend.Handler, backends))
+		stage(HopGatewayBackend, "backend", "marshal", mean(backend.Marshal, backends))
+		stage(HopGatewayBackend, "backend", "sign", mean(backend.Sign, backends))
+		r.Crypto += mean(backend.Verify+backend.Sign, backends)
+	}
+	stage(HopGatewayBackend, "gateway", "verify", mean(gateway.Verify, n))
+	stage(HopGatewayBackend, "gateway", "countersign", mean(gateway.Countersign, n))
+	r.Crypto = round(r.Crypto + mean(gateway.Sign+gateway.Verify+gateway.Countersign, n))
+}
+
+// addTiming adds t's own durations to sum.
+func addTiming(sum *mesh.Timing, t mesh.Timing) {
+	sum.Marshal += t.Marshal
+	sum.Sign += t.Sign
+	sum.Upstream += t.Upstream
+	sum.Verify += t.Verify
+	sum.Countersign += t.Countersign
+	sum.Handler += t.Handler
+}
+
+func millis(d time.Duration) float64 {
+	return round(float64(d) / float64(time.Millisecond))
+}
+
+// round rounds ms to microseconds.
+func round(ms float64) float64 {
+	return math.Round(ms*1000) / 1000
+}
+
+// Write writes the report in format.
+func (r *OverheadReport) Write(w io.Writer, format string) error {
+	switch format {
+	case FormatJSON:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(r)
+	case FormatCSV:
+		out := csv.NewWriter(w)
+		out.Write([]string{"hop", "service", "stage", "mean_ms", "percent"})
+		row := func(hop, service, stage string, ms, percent float64) {
+			out.Write([]string{hop, service, stage, strconv.FormatFloat(ms, 'f', 3, 64), strconv.FormatFloat(percent, 'f', 1, 64)})
+		}
+		for _, s := range r.Stages {
+			row(s.Hop, s.Service, s.Stage, s.Mean, s.Percent)
+		}
+		// The totals follow, their percent of the passthrough's latency.
+		row("", "", "mesh", r.Mesh.Mean, 100+r.OverheadPercent)
+		row("", "", "passthrough", r.Passthrough.Mean, 100)
+		row("", "", "overhead", r.Overhead, r.OverheadPercent)
+		out.Flush()
+		return out.Error()
+	case FormatMarkdown:
+		r.writeMarkdown(w)
+		return nil
+	}
+	return fmt.Errorf("unknown report format %q", format)
+}
+
+func (r *OverheadReport) writeMarkdown(w io.Writer) {
+	fmt.Fprintln(w, "## 🔐 PQC Overhead")
+	fmt.Fprintln(w)
+	fmt.Fprintf(w, "%s, %s %s/%s, %d CPUs\n\n", r.Timestamp.Format("2006-01-02 15:04:05 MST"), r.GoVersion, r.GOOS, r.GOARCH, r.CPUs)
+	fmt.Fprintln(w, "| Route | Requests | Errors | Mean | p50 | p95 | p99 |")
+	fmt.Fprintln(w, "|-------|---------:|-------:|-----:|----:|----:|----:|")
+	for _, route := range []struct {
+		name    string
+		latency RouteLatency
+	}{{"mesh", r.Mesh}, {"passthrough", r.Passthrough}} {
+		l := route.latency
+		fmt.Fprintf(w, "| %s | %d | %d | %.3fms | %.3fms | %.3fms | %.3fms |\n",
+			route.name, l.Requests, l.Errors, l.Mean, l.P50, l.P95, l.P99)
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintf(w, "**Overhead:** %.3fms per request, %+.1f%% over passthrough; signing and verifying take %.3fms of it.\n\n",
+		r.Overhead, r.OverheadPercent, r.Crypto)
+
+	if len(r.Stages) == 0 {
+		return
+	}
+	fmt.Fprintln(w, "| Hop | Service | Stage | Mean | Share of mesh latency |")
+	fmt.Fprintln(w, "|-----|---------|-------|-----:|----------------------:|")
+	for _, s := range r.Stages {
+		service := s.Service
+		if service == "" {
+			service = "-"
+		}
+		fmt.Fprintf(w, "| %s | %s | %s | %.3fms | %.1f%% |\n", s.Hop, service, s.Stage, s.Mean, s.Percent)
+	}
+}
@@ -0,0 +1,157 @@
+package benchmark
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Report formats.
+const (
+	FormatJSON     = "json"
+	FormatCSV      = "csv"
+	FormatMarkdown = "markdown"
+)
+
+// Formats lists the supported report formats.
+var Formats = []string{FormatJSON, FormatCSV, FormatMarkdown}
+
+var csvHeader = []string{"algorithm", "op", "iterations", "ns_per_op", "public_key_bytes", "private_key_bytes", "output_bytes"}
+
+// FormatForPath picks a format from path's extension, defaulting to json.
+func FormatForPath(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".csv":
+		return FormatCSV
+	case ".md", ".markdown":
+		return FormatMarkdown
+	}
+	return FormatJSON
+}
+
+// Write writes the report in format.
+func (r *Report) Write(w io.Writer, format string) error {
+	switch format {
+	case FormatJSON:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(r)
+	case FormatCSV:
+		out := csv.NewWriter(w)
+		out.Write(csvHeader)
+		for _, result := range r.Results {
+			out.Write([]string{
+				result.Algorithm, result.Op, strconv.Itoa(result.Iterations),
+				strconv.FormatFloat(result.NsPerOp, 'f', 0, 64),
+				strconv.Itoa(result.PublicKeyBytes), strconv.Itoa(result.PrivateKeyBytes), strconv.Itoa(result.OutputBytes),
+			})
+		}
+		out.Flush()
+		return out.Error()
+	case FormatMarkdown:
+		r.writeMarkdown(w)
+		return nil
+	}
+	return fmt.Errorf("unknown report format %q", format)
+}
+
+func (r *Report) writeMarkdown(w io.Writer) {
+	fmt.Fprintln(w, "## 📊 PQC Benchmark")
+	fmt.Fprintln(w)
+	if !r.Timestamp.IsZero() {
+		fmt.Fprintf(w, "%s, %s %s/%s, %d CPUs\n\n", r.Timestamp.Format("2006-01-02 15:04:05 MST"), r.GoVersion, r.GOOS, r.GOARCH, r.CPUs)
+	}
+	fmt.Fprintln(w, "| Algorithm | Op | ns/op | ops/s | Public key | Private key | Output |")
+	fmt.Fprintln(w, "|-----------|----|------:|------:|-----------:|------------:|-------:|")
+	for _, result := range r.Results {
+		fmt.Fprintf(w, "| %s | %s | %.0f | %.1f | %d B | %d B | %s |\n",
+			result.Algorithm, result.Op, result.NsPerOp, opsPerSecond(result.NsPerOp),
+			result.PublicKeyBytes, result.PrivateKeyBytes, formatBytes(result.OutputBytes))
+	}
+}
+
+// WriteFile writes the report to path in the format its extension implies.
+func (r *Report) WriteFile(path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", path, err)
+	}
+	if err := r.Write(file, FormatForPath(path)); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return file.Close()
+}
+
+// ReadFile loads a report written in json or csv. CSV reports carry only
+// the results, not the run's metadata.
+func ReadFile(path string) (*Report, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	defer file.Close()
+
+	var report Report
+	switch format := FormatForPath(path); format {
+	case FormatJSON:
+		if err := json.NewDecoder(file).Decode(&report); err != nil {
+			return nil, fmt.Errorf("failed to decode %s: %w", path, err)
+		}
+	case FormatCSV:
+		report.Results, err = readCSV(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode %s: %w", path, err)
+		}
+	default:
+		return nil, fmt.Errorf("cannot read %s reports, only json and csv", format)
+	}
+	return &report, nil
+}
+
+func readCSV(r io.Reader) ([]Result, error) {
+	rows, err := csv.NewReader(r).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 || strings.Join(rows[0], ",") != strings.Join(csvHeader, ",") {
+		return nil, fmt.Errorf("missing header %q", strings.Join(csvHeader, ","))
+	}
+
+	results := make([]Result, 0, len(rows)-1)
+	for i, row := range rows[1:] {
+		var result Result
+		var err error
+		result.Algorithm, result.Op = row[0], row[1]
+		ints := []*int{&result.Iterations, &result.PublicKeyBytes, &result.PrivateKeyBytes, &result.OutputBytes}
+		for j, column := range []int{2, 4, 5, 6} {
+			if *ints[j], err = strconv.Atoi(row[column]); err != nil {
+				return nil, fmt.Errorf("row %d: invalid %s: %w", i+2, csvHeader[column], err)
+			}
+		}
+		if result.NsPerOp, err = strconv.ParseFloat(row[3], 64); err != nil {
+			return nil, fmt.Errorf("row %d: invalid ns_per_op: %w", i+2, err)
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+func opsPerSecond(nsPerOp float64) float64 {
+	if nsPerOp == 0 {
+		return 0
+	}
+	return 1e9 / nsPerOp
+}
+
+func formatBytes(n int) string {
+	if n == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%d B", n)
+}
@@ -0,0 +1,258 @@
+package benchmark
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"runtime"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"quantum-safe-mesh/pkg/pqc"
+)
+
+// AlgorithmMesh is the Algorithm of end-to-end results: requests through a
+// running gateway, backend and auth service rather than one primitive.
+const AlgorithmMesh = "mesh"
+
+// ThroughputResult is how many operations per second one operation sustained
+// with Goroutines running it at once. Speedup is relative to the same
+// operation on one goroutine.
+type ThroughputResult struct {
+	Algorithm    string  `json:"algorithm"`
+	Op           string  `json:"op"`
+	Goroutines   int     `json:"goroutines"`
+	Operations   int     `json:"operations"`
+	Errors       int     `json:"errors"`
+	OpsPerSecond float64 `json:"ops_per_second"`
+	Speedup      float64 `json:"speedup"`
+}
+
+// ThroughputReport is one throughput run.
+type ThroughputReport struct {
+	Timestamp time.Time          `json:"timestamp"`
+	GoVersion string             `json:"go_version"`
+	GOOS      string             `json:"goos"`
+	GOARCH    string             `json:"goarch"`
+	CPUs      int                `json:"cpus"`
+	Results   []ThroughputResult `json:"results"`
+}
+
+// GoroutineCounts returns the powers of two below max, then max itself.
+func GoroutineCounts(max int) []int {
+	var counts []int
+	for n := 1; n < max; n *= 2 {
+		counts = append(counts, n)
+	}
+	return append(counts, max)
+}
+
+// throughputOp is an operation measured for throughput. Ops must be safe to
+// call from many goroutines at once.
+type throughputOp struct {
+	algorithm string
+	op        string
+	run       func() error
+}
+
+// RunThroughput measures signing, verification, encapsulation and
+// decapsulation for every algorithm at each goroutine count, running each
+// combination for duration. Like Run, callers usually discard log output.
+func RunThroughput(goroutines []int, duration time.Duration) (*ThroughputReport, error) {
+	ops, err := throughputOps()
+	if err != nil {
+		return nil, err
+	}
+
+	report := newThroughputReport()
+	for _, op := range ops {
+		report.add(op.algorithm, op.op, goroutines, duration, op.run)
+	}
+	return report, nil
+}
+
+// RunEndToEnd adds to report the requests per second sustained through a
+// running mesh, posting a small JSON body to url at each goroutine count.
+// Error responses and transport failures count as errors, not operations.
+func RunEndToEnd(report *ThroughputReport, url string, goroutines []int, duration time.Duration) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConnsPerHost = goroutines[len(goroutines)-1]
+	client := &http.Client{Timeout: 30 * time.Second, Transport: transport}
+	body := []byte(`{"message":"throughput benchmark"}`)
+
+	report.add(AlgorithmMesh, "POST "+url, goroutines, duration, func() error {
+		resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode >= http.StatusBadRequest {
+			return fmt.Errorf("status %d", resp.StatusCode)
+		}
+		return nil
+	})
+}
+
+func newThroughputReport() *ThroughputReport {
+	return &ThroughputReport{
+		Timestamp: time.Now().UTC(),
+		GoVersion: runtime.Version(),
+		GOOS:      runtime.GOOS,
+		GOARCH:    runtime.GOARCH,
+		CPUs:      runtime.NumCPU(),
+	}
+}
+
+func (r *ThroughputReport) add(algorithm, op string, goroutines []int, duration time.Duration, run func() error) {
+	var baseline float64
+	for _, n := range goroutines {
+		operations, errors, elapsed := runConcurrently(n, duration, run)
+		result := ThroughputResult{
+			Algorithm:    algorithm,
+			Op:           op,
+			Goroutines:   n,
+			Operations:   operations,
+			Errors:       errors,
+			OpsPerSecond: float64(operations) / elapsed.Seconds(),
+		}
+		if baseline == 0 {
+			baseline = result.OpsPerSecond
+		}
+		if baseline > 0 {
+			result.Speedup = result.OpsPerSecond / baseline
+		}
+		r.Results = append(r.Results, result)
+	}
+}
+
+// runConcurrently runs op on n goroutines until duration has elapsed and
+// counts the calls that succeeded and failed.
+func runConcurrently(n int, duration time.Duration, op func() error) (operations, errors int, elapsed time.Duration) {
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+	start := time.Now()
+	deadline := start.Add(duration)
+
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var succeeded, failed int
+			for time.Now().Before(deadline) {
+				if op() != nil {
+					failed++
+				} else {
+					succeeded++
+				}
+			}
+			mutex.Lock()
+			operations += succeeded
+			errors += failed
+			mutex.Unlock()
+		}()
+	}
+
+	wg.Wait()
+	return operations, errors, time.Since(start)
+}
+
+func throughputOps() ([]throughputOp, error) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate RSA-2048 key: %w", err)
+	}
+	digest := sha256.Sum256(testMessage)
+	rsaSignature, err := rsa.SignPKCS1v15(rand.Reader, rsaKey, crypto.SHA256, digest[:])
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign with RSA-2048: %w", err)
+	}
+
+	dilithium, err := pqc.GenerateDilithiumKeyPair()
+	if err != nil {
+		return nil, err
+	}
+	dilithiumSignature, err := dilithium.Sign(testMessage)
+	if err != nil {
+		return nil, err
+	}
+	dilithiumPublicKey := dilithium.GetPublicKeyBytes()
+	var counter atomic.Uint64
+
+	kyber, err := pqc.GenerateKyberKeyPair()
+	if err != nil {
+		return nil, err
+	}
+	ciphertext, _, err := kyber.Encapsulate()
+	if err != nil {
+		return nil, err
+	}
+
+	return []throughputOp{
+		{"RSA-2048", OpSign, func() error {
+			_, err := rsa.SignPKCS1v15(rand.Reader, rsaKey, crypto.SHA256, digest[:])
+			return err
+		}},
+		{"RSA-2048", OpVerify, func() error {
+			return rsa.VerifyPKCS1v15(&rsaKey.PublicKey, crypto.SHA256, digest[:], rsaSignature)
+		}},
+		{"Dilithium3", OpSign, func() error {
+			_, err := dilithium.Sign(variedMessage(counter.Add(1)))
+			return err
+		}},
+		{"Dilithium3", OpVerify, func() error {
+			return pqc.VerifyDilithiumSignature(dilithiumPublicKey, testMessage, dilithiumSignature)
+		}},
+		{"Kyber768", OpEncapsulate, func() error {
+			_, _, err := kyber.Encapsulate()
+			return err
+		}},
+		{"Kyber768", OpDecapsulate, func() error {
+			_, err := kyber.Decapsulate(ciphertext)
+			return err
+		}},
+	}, nil
+}
+
+// Write writes the report in format.
+func (r *ThroughputReport) Write(w io.Writer, format string) error {
+	switch format {
+	case FormatJSON:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(r)
+	case FormatCSV:
+		out := csv.NewWriter(w)
+		out.Write([]string{"algorithm", "op", "goroutines", "operations", "errors", "ops_per_second", "speedup"})
+		for _, result := range r.Results {
+			out.Write([]string{
+				result.Algorithm, result.Op, strconv.Itoa(result.Goroutines),
+				strconv.Itoa(result.Operations), strconv.Itoa(result.Errors),
+				strconv.FormatFloat(result.OpsPerSecond, 'f', 1, 64), strconv.FormatFloat(result.Speedup, 'f', 2, 64),
+			})
+		}
+		out.Flush()
+		return out.Error()
+	case FormatMarkdown:
+		fmt.Fprintln(w, "## ⚡ PQC Throughput")
+		fmt.Fprintln(w)
+		fmt.Fprintf(w, "%s, %s %s/%s, %d CPUs\n\n", r.Timestamp.Format("2006-01-02 15:04:05 MST"), r.GoVersion, r.GOOS, r.GOARCH, r.CPUs)
+		fmt.Fprintln(w, "| Algorithm | Op | Goroutines | ops/s | Speedup | Errors |")
+		fmt.Fprintln(w, "|-----------|----|-----------:|------:|--------:|-------:|")
+		for _, result := range r.Results {
+			fmt.Fprintf(w, "| %s | %s | %d | %.1f | %.2fx | %d |\n",
+				result.Algorithm, result.Op, result.Goroutines, result.OpsPerSecond, result.Speedup, result.Errors)
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown report format %q", format)
+}
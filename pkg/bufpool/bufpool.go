@@ -0,0 +1,32 @@
+// Package bufpool pools the scratch buffers envelopes are encoded into to
+// sign or verify them, so services handling thousands of requests a second
+// reuse them instead of allocating, and later collecting, several per
+// request.
+package bufpool
+
+import (
+	"bytes"
+	"sync"
+)
+
+// maxSize is the largest buffer kept. One grown for an unusually large
+// body is left to the garbage collector rather than held for the small
+// requests that follow.
+const maxSize = 1 << 20
+
+var buffers = sync.Pool{New: func() any { return new(bytes.Buffer) }}
+
+// Get returns an empty buffer from the pool.
+func Get() *bytes.Buffer {
+	return buffers.Get().(*bytes.Buffer)
+}
+
+// Put returns buf to the pool. Neither buf nor any slice of its bytes may
+// be used afterwards.
+func Put(buf *bytes.Buffer) {
+	if buf.Cap() > maxSize {
+		return
+	}
+	buf.Reset()
+	buffers.Put(buf)
+}
@@ -0,0 +1,64 @@
+// Package buildinfo reports what a mesh binary was built from. Release
+// builds set the version, commit and date with the linker:
+//
+//	go build -ldflags "-X quantum-safe-mesh/pkg/buildinfo.Version=v1.4.0 \
+//	    -X quantum-safe-mesh/pkg/buildinfo.Commit=$(git rev-parse --short HEAD) \
+//	    -X quantum-safe-mesh/pkg/buildinfo.Date=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+//
+// Without them the version is "dev" and the commit comes from the VCS
+// stamp go build adds in a git checkout, if there is one.
+package buildinfo
+
+import (
+	"encoding/json"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+
+	"quantum-safe-mesh/pkg/models"
+	"quantum-safe-mesh/pkg/pqc"
+)
+
+// Set with -ldflags -X.
+var (
+	Version = "dev"
+	Commit  = ""
+	Date    = ""
+)
+
+// Get returns the binary's build information, with the signature algorithm
+// and KEM it is configured with.
+func Get(signature, kem string) models.BuildInfo {
+	info := models.BuildInfo{
+		Version:    Version,
+		Commit:     Commit,
+		BuildDate:  Date,
+		GoVersion:  runtime.Version(),
+		Signature:  signature,
+		KEM:        kem,
+		Algorithms: append(append([]string(nil), pqc.SignatureAlgorithms...), pqc.KEMAlgorithms...),
+	}
+
+	if build, ok := debug.ReadBuildInfo(); ok && info.Commit == "" {
+		for _, setting := range build.Settings {
+			if setting.Key == "vcs.revision" {
+				info.Commit = setting.Value[:min(len(setting.Value), 12)]
+			}
+		}
+	}
+	if info.Commit == "" {
+		info.Commit = "unknown"
+	}
+	if info.BuildDate == "" {
+		info.BuildDate = "unknown"
+	}
+	return info
+}
+
+// Handler answers GET /version with info.
+func Handler(info models.BuildInfo) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(info)
+	}
+}
@@ -0,0 +1,138 @@
+package channel
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"time"
+
+	"quantum-safe-mesh/pkg/mesh"
+	"quantum-safe-mesh/pkg/pqc"
+)
+
+func newBenchmarkIdentity(serviceID string) (*mesh.Identity, error) {
+	dilithiumKeyPair, err := pqc.GenerateDilithiumKeyPair()
+	if err != nil {
+		return nil, err
+	}
+	kyberKeyPair, err := pqc.GenerateKyberKeyPair()
+	if err != nil {
+		return nil, err
+	}
+	return &mesh.Identity{ServiceID: serviceID, Signer: dilithiumKeyPair, Kyber: kyberKeyPair}, nil
+}
+
+// BenchmarkChannelVsSignatures compares signing and verifying every request
+// with Dilithium against one channel handshake followed by AEAD records, and
+// reports the per-request savings.
+func BenchmarkChannelVsSignatures(requests int) {
+	log.Println("\n🚀 Performance Comparison: per-request signatures vs PQC channel")
+	log.Println(strings.Repeat("=", 50))
+
+	client, err := newBenchmarkIdentity("bench-client")
+	if err != nil {
+		log.Printf("Failed to create client identity: %v", err)
+		return
+	}
+	server, err := newBenchmarkIdentity("bench-server")
+	if err != nil {
+		log.Printf("Failed to create server identity: %v", err)
+		return
+	}
+
+	lookup := func(serviceID string) ([]byte, error) {
+		switch serviceID {
+		case client.ServiceID:
+			return client.PublicKey(), nil
+		case server.ServiceID:
+			return server.PublicKey(), nil
+		}
+		return nil, fmt.Errorf("service not found: %s", serviceID)
+	}
+
+	testData := []byte(`{"message":"This is a test message for channel performance comparison"}`)
+
+	log.Printf("\n📊 Dilithium3 signature per request (%d requests):", requests)
+	var signatureSize int
+	start := time.Now()
+	for i := 0; i < requests; i++ {
+		signature, err := client.Signer.Sign(testData)
+		if err != nil {
+			log.Printf("Failed to sign with Dilithium: %v", err)
+			return
+		}
+		if err := pqc.VerifyDilithiumSignature(client.PublicKey(), testData, signature); err != nil {
+			log.Printf("Dilithium verification failed: %v", err)
+			return
+		}
+		signatureSize = len(signature)
+	}
+	signatureTotal := time.Since(start)
+	signaturePerRequest := signatureTotal / time.Duration(requests)
+
+	log.Printf("  ⏱️  Total time: %v", signatureTotal)
+	log.Printf("  ⏱️  Per request: %v", signaturePerRequest)
+	log.Printf("  📏 Overhead per request: %d bytes", signatureSize)
+
+	log.Printf("\n📊 PQC channel (%d requests):", requests)
+	clientSide, serverSide := net.Pipe()
+	defer clientSide.Close()
+	defer serverSide.Close()
+
+	accepted := make(chan *Conn, 1)
+	go func() {
+		conn, err := NewServerConn(serverSide, server, lookup)
+		if err != nil {
+			log.Printf("Server handshake failed: %v", err)
+			serverSide.Close()
+			accepted <- nil
+			return
+		}
+		accepted <- conn
+	}()
+
+	start = time.Now()
+	clientConn, err := NewClientConn(clientSide, client, server.ServiceID, lookup)
+	if err != nil {
+		log.Printf("Client handshake failed: %v", err)
+		return
+	}
+	serverConn := <-accepted
+	if serverConn == nil {
+		return
+	}
+	handshakeTime := time.Since(start)
+
+	go func() {
+		for i := 0; i < requests; i++ {
+			if _, err := serverConn.ReadMessage(); err != nil {
+				log.Printf("Failed to read channel record: %v", err)
+				return
+			}
+		}
+	}()
+
+	start = time.Now()
+	for i := 0; i < requests; i++ {
+		if err := clientConn.WriteMessage(testData); err != nil {
+			log.Printf("Failed to write channel record: %v", err)
+			return
+		}
+	}
+	recordTotal := time.Since(start)
+	recordPerRequest := recordTotal / time.Duration(requests)
+	recordOverhead := 4 + clientConn.sendAEAD.Overhead()
+
+	log.Printf("  🤝 Handshake time: %v", handshakeTime)
+	log.Printf("  ⏱️  Total time: %v", handshakeTime+recordTotal)
+	log.Printf("  ⏱️  Per request (after handshake): %v", recordPerRequest)
+	log.Printf("  📏 Overhead per request: %d bytes", recordOverhead)
+
+	log.Println("\n📈 Per-request savings:")
+	log.Printf("  ⚡ Time: %v", signaturePerRequest-recordPerRequest)
+	log.Printf("  📏 Bytes: %d", signatureSize-recordOverhead)
+	if saved := signaturePerRequest - recordPerRequest; saved > 0 {
+		log.Printf("  ⚖️  Handshake pays for itself after %d requests", int(handshakeTime/saved)+1)
+	}
+}
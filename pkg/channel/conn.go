@@ -0,0 +1,206 @@
+package channel
+
+import (
+	"crypto/cipher"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"quantum-safe-mesh/pkg/mesh"
+	"quantum-safe-mesh/pkg/pqc"
+)
+
+// MaxFrameSize bounds a single frame on the wire, handshake or record.
+const MaxFrameSize = 16 << 20
+
+// handshakeTimeout bounds how long a peer may take to complete a handshake.
+const handshakeTimeout = 10 * time.Second
+
+func writeFrame(w io.Writer, payload []byte) error {
+	if len(payload) > MaxFrameSize {
+		return fmt.Errorf("frame of %d bytes exceeds maximum of %d", len(payload), MaxFrameSize)
+	}
+
+	frame := make([]byte, 4+len(payload))
+	binary.BigEndian.PutUint32(frame, uint32(len(payload)))
+	copy(frame[4:], payload)
+	_, err := w.Write(frame)
+	return err
+}
+
+func readFrame(r io.Reader) ([]byte, error) {
+	var header [4]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+
+	size := binary.BigEndian.Uint32(header[:])
+	if size > MaxFrameSize {
+		return nil, fmt.Errorf("frame of %d bytes exceeds maximum of %d", size, MaxFrameSize)
+	}
+
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, err
+	}
+	return payload, nil
+}
+
+// Conn is an authenticated, encrypted channel to one peer. Each message is
+// sealed with AES-256-GCM under a per-direction key; the nonce is the
+// message's sequence number, so replayed, dropped or reordered frames fail
+// to open.
+type Conn struct {
+	conn   net.Conn
+	peerID string
+
+	writeMutex sync.Mutex
+	sendAEAD   cipher.AEAD
+	sendSeq    uint64
+
+	readMutex sync.Mutex
+	recvAEAD  cipher.AEAD
+	recvSeq   uint64
+}
+
+// NewClientConn performs the initiator handshake over conn, authenticating
+// peerID through lookup.
+func NewClientConn(conn net.Conn, identity *mesh.Identity, peerID string, lookup pqc.PublicKeyLookup) (*Conn, error) {
+	conn.SetDeadline(time.Now().Add(handshakeTimeout))
+	keys, err := clientHandshake(conn, identity, peerID, lookup)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+
+	return &Conn{conn: conn, peerID: peerID, sendAEAD: keys.clientToServer, recvAEAD: keys.serverToClient}, nil
+}
+
+// NewServerConn performs the responder handshake over conn. Any registered
+// service may connect; PeerID reports which one did.
+func NewServerConn(conn net.Conn, identity *mesh.Identity, lookup pqc.PublicKeyLookup) (*Conn, error) {
+	conn.SetDeadline(time.Now().Add(handshakeTimeout))
+	keys, peerID, err := serverHandshake(conn, identity, lookup)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+
+	return &Conn{conn: conn, peerID: peerID, sendAEAD: keys.serverToClient, recvAEAD: keys.clientToServer}, nil
+}
+
+// Dial connects to a channel listener at addr and authenticates it as peerID.
+func Dial(addr string, identity *mesh.Identity, peerID string, lookup pqc.PublicKeyLookup) (*Conn, error) {
+	conn, err := net.DialTimeout("tcp", addr, handshakeTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %w", addr, err)
+	}
+
+	channelConn, err := NewClientConn(conn, identity, peerID, lookup)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("channel handshake with %s failed: %w", peerID, err)
+	}
+	return channelConn, nil
+}
+
+func (c *Conn) PeerID() string {
+	return c.peerID
+}
+
+func sequenceNonce(aead cipher.AEAD, seq uint64) []byte {
+	nonce := make([]byte, aead.NonceSize())
+	binary.BigEndian.PutUint64(nonce[len(nonce)-8:], seq)
+	return nonce
+}
+
+// WriteMessage seals message and sends it as one frame. It is safe to call
+// from multiple goroutines.
+func (c *Conn) WriteMessage(message []byte) error {
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+
+	sealed := c.sendAEAD.Seal(nil, sequenceNonce(c.sendAEAD, c.sendSeq), message, nil)
+	c.sendSeq++
+	return writeFrame(c.conn, sealed)
+}
+
+// ReadMessage reads and opens the next frame.
+func (c *Conn) ReadMessage() ([]byte, error) {
+	c.readMutex.Lock()
+	defer c.readMutex.Unlock()
+
+	sealed, err := readFrame(c.conn)
+	if err != nil {
+		return nil, err
+	}
+
+	message, err := c.recvAEAD.Open(nil, sequenceNonce(c.recvAEAD, c.recvSeq), sealed, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open channel record %d: %w", c.recvSeq, err)
+	}
+	c.recvSeq++
+	return message, nil
+}
+
+func (c *Conn) Close() error {
+	return c.conn.Close()
+}
+
+// Listener accepts channel connections, completing the handshake before
+// handing them out.
+type Listener struct {
+	listener net.Listener
+	identity *mesh.Identity
+	lookup   pqc.PublicKeyLookup
+}
+
+func Listen(addr string, identity *mesh.Identity, lookup pqc.PublicKeyLookup) (*Listener, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", addr, err)
+	}
+	return &Listener{listener: listener, identity: identity, lookup: lookup}, nil
+}
+
+// Accept waits for the next connection and runs the server handshake on
+// it. A failed handshake closes that connection and is reported as an error
+// alongside a nil Conn; the listener stays usable.
+func (l *Listener) Accept() (*Conn, error) {
+	conn, err := l.listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	channelConn, err := NewServerConn(conn, l.identity, l.lookup)
+	if err != nil {
+		conn.Close()
+		return nil, &HandshakeError{RemoteAddr: conn.RemoteAddr().String(), Err: err}
+	}
+	return channelConn, nil
+}
+
+func (l *Listener) Addr() net.Addr {
+	return l.listener.Addr()
+}
+
+func (l *Listener) Close() error {
+	return l.listener.Close()
+}
+
+// HandshakeError reports a connection whose handshake failed.
+type HandshakeError struct {
+	RemoteAddr string
+	Err        error
+}
+
+func (e *HandshakeError) Error() string {
+	return fmt.Sprintf("channel handshake with %s failed: %v", e.RemoteAddr, e.Err)
+}
+
+func (e *HandshakeError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,286 @@
+package channel
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"time"
+
+	"golang.org/x/crypto/hkdf"
+	"quantum-safe-mesh/pkg/mesh"
+	"quantum-safe-mesh/pkg/models"
+	"quantum-safe-mesh/pkg/pqc"
+	"quantum-safe-mesh/pkg/telemetry"
+)
+
+// ProtocolName identifies this handshake and key schedule. It is mixed into
+// every signature and derived key so they cannot be confused with envelope
+// signatures made by the same signing keys.
+const ProtocolName = "mesh-channel/1"
+
+// maxHelloAge bounds the clock skew tolerated on handshake timestamps.
+const maxHelloAge = 5 * time.Minute
+
+// clientHello opens a channel. The client's ephemeral Kyber key gives the
+// session forward secrecy; its signature authenticates the client.
+type clientHello struct {
+	Protocol     string           `json:"protocol"`
+	ServiceID    string           `json:"service_id"`
+	KEMPublic    models.Base64URL `json:"kem_public_key"`
+	Nonce        models.Base64URL `json:"nonce"`
+	Timestamp    time.Time        `json:"timestamp"`
+	SignatureAlg string           `json:"signature_alg,omitempty"`
+	Signature    models.Base64URL `json:"signature,omitempty"`
+}
+
+// serverHello answers with a ciphertext for the client's ephemeral key,
+// signed over the whole transcript so far.
+type serverHello struct {
+	ServiceID    string           `json:"service_id"`
+	Ciphertext   models.Base64URL `json:"ciphertext"`
+	Nonce        models.Base64URL `json:"nonce"`
+	Timestamp    time.Time        `json:"timestamp"`
+	SignatureAlg string           `json:"signature_alg,omitempty"`
+	Signature    models.Base64URL `json:"signature,omitempty"`
+}
+
+// sessionKeys are the per-direction AEADs derived from the handshake.
+type sessionKeys struct {
+	clientToServer cipher.AEAD
+	serverToClient cipher.AEAD
+}
+
+// signingInput binds a handshake signature to the protocol and role.
+func signingInput(label string, transcript ...[]byte) []byte {
+	hash := sha256.New()
+	hash.Write([]byte(ProtocolName + " " + label))
+	for _, message := range transcript {
+		hash.Write(message)
+	}
+	return hash.Sum(nil)
+}
+
+func newNonce() (models.Base64URL, error) {
+	nonce := make([]byte, 32)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	return nonce, nil
+}
+
+func checkTimestamp(timestamp time.Time) error {
+	if age := time.Since(timestamp); age > maxHelloAge || age < -maxHelloAge {
+		return fmt.Errorf("handshake timestamp %v is outside the allowed window", timestamp)
+	}
+	return nil
+}
+
+// deriveKeys expands the KEM shared secret into one AES-256-GCM key per
+// direction, salted with the hash of both signed hello messages.
+func deriveKeys(sharedSecret, clientHelloBytes, serverHelloBytes []byte) (*sessionKeys, error) {
+	salt := signingInput("keys", clientHelloBytes, serverHelloBytes)
+
+	newAEAD := func(info string) (cipher.AEAD, error) {
+		key := make([]byte, 32)
+		if _, err := io.ReadFull(hkdf.New(sha256.New, sharedSecret, salt, []byte(ProtocolName+" "+info)), key); err != nil {
+			return nil, fmt.Errorf("failed to derive %s key: %w", info, err)
+		}
+
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create cipher: %w", err)
+		}
+		return cipher.NewGCM(block)
+	}
+
+	clientToServer, err := newAEAD("client to server")
+	if err != nil {
+		return nil, err
+	}
+	serverToClient, err := newAEAD("server to client")
+	if err != nil {
+		return nil, err
+	}
+
+	return &sessionKeys{clientToServer: clientToServer, serverToClient: serverToClient}, nil
+}
+
+// clientHandshake runs the initiator side over rw and returns the session
+// keys once peerID has proven possession of its registered key.
+func clientHandshake(rw io.ReadWriter, identity *mesh.Identity, peerID string, lookup pqc.PublicKeyLookup) (*sessionKeys, error) {
+	ephemeral, err := pqc.GenerateKyberKeyPair()
+	if err != nil {
+		return nil, err
+	}
+
+	nonce, err := newNonce()
+	if err != nil {
+		return nil, err
+	}
+
+	hello := clientHello{
+		Protocol:     ProtocolName,
+		ServiceID:    identity.ServiceID,
+		KEMPublic:    ephemeral.GetPublicKeyBytes(),
+		Nonce:        nonce,
+		Timestamp:    time.Now(),
+		SignatureAlg: pqc.EnvelopeAlg(identity.Signer),
+	}
+
+	unsigned, err := json.Marshal(hello)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal client hello: %w", err)
+	}
+
+	hello.Signature, err = telemetry.SignPooled(context.Background(), identity.Signer, signingInput("client hello", unsigned))
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign client hello: %w", err)
+	}
+	defer pqc.ReleaseSignature(hello.Signature)
+
+	clientHelloBytes, err := json.Marshal(hello)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal client hello: %w", err)
+	}
+
+	if err := writeFrame(rw, clientHelloBytes); err != nil {
+		return nil, fmt.Errorf("failed to send client hello: %w", err)
+	}
+
+	serverHelloBytes, err := readFrame(rw)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read server hello: %w", err)
+	}
+
+	var reply serverHello
+	if err := json.Unmarshal(serverHelloBytes, &reply); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal server hello: %w", err)
+	}
+
+	if reply.ServiceID != peerID {
+		return nil, fmt.Errorf("handshake answered by %s, expected %s", reply.ServiceID, peerID)
+	}
+	if err := checkTimestamp(reply.Timestamp); err != nil {
+		return nil, err
+	}
+
+	peerPublicKey, err := lookup(peerID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get public key: %w", err)
+	}
+
+	signature := reply.Signature
+	reply.Signature = nil
+	replyUnsigned, err := json.Marshal(reply)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal server hello: %w", err)
+	}
+
+	if err := telemetry.Verify(context.Background(), reply.SignatureAlg, peerPublicKey, signingInput("server hello", clientHelloBytes, replyUnsigned), signature); err != nil {
+		return nil, fmt.Errorf("server hello signature verification failed: %w", err)
+	}
+
+	_, decapsulated := telemetry.Observe(context.Background(), telemetry.OpDecapsulate, pqc.AlgorithmKyber768)
+	sharedSecret, err := ephemeral.Decapsulate(reply.Ciphertext)
+	decapsulated(err)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("🔗 Channel established with %s", peerID)
+	return deriveKeys(sharedSecret, clientHelloBytes, serverHelloBytes)
+}
+
+// serverHandshake runs the responder side over rw and returns the session
+// keys and the authenticated client's service ID.
+func serverHandshake(rw io.ReadWriter, identity *mesh.Identity, lookup pqc.PublicKeyLookup) (*sessionKeys, string, error) {
+	clientHelloBytes, err := readFrame(rw)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read client hello: %w", err)
+	}
+
+	var hello clientHello
+	if err := json.Unmarshal(clientHelloBytes, &hello); err != nil {
+		return nil, "", fmt.Errorf("failed to unmarshal client hello: %w", err)
+	}
+
+	if hello.Protocol != ProtocolName {
+		return nil, "", fmt.Errorf("unsupported channel protocol: %q", hello.Protocol)
+	}
+	if err := checkTimestamp(hello.Timestamp); err != nil {
+		return nil, "", err
+	}
+
+	clientPublicKey, err := lookup(hello.ServiceID)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to get public key: %w", err)
+	}
+
+	signature := hello.Signature
+	hello.Signature = nil
+	unsigned, err := json.Marshal(hello)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to marshal client hello: %w", err)
+	}
+
+	if err := telemetry.Verify(context.Background(), hello.SignatureAlg, clientPublicKey, signingInput("client hello", unsigned), signature); err != nil {
+		return nil, "", fmt.Errorf("client hello signature verification failed: %w", err)
+	}
+
+	_, encapsulated := telemetry.Observe(context.Background(), telemetry.OpEncapsulate, pqc.AlgorithmKyber768)
+	ciphertext, sharedSecret, err := pqc.EncapsulatePooled(hello.KEMPublic)
+	encapsulated(err)
+	if err != nil {
+		return nil, "", err
+	}
+	// The ciphertext and signature are pooled; only their encoding in
+	// serverHelloBytes outlives the handshake.
+	defer pqc.ReleaseCiphertext(ciphertext)
+
+	nonce, err := newNonce()
+	if err != nil {
+		return nil, "", err
+	}
+
+	reply := serverHello{
+		ServiceID:    identity.ServiceID,
+		Ciphertext:   ciphertext,
+		Nonce:        nonce,
+		Timestamp:    time.Now(),
+		SignatureAlg: pqc.EnvelopeAlg(identity.Signer),
+	}
+
+	replyUnsigned, err := json.Marshal(reply)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to marshal server hello: %w", err)
+	}
+
+	reply.Signature, err = telemetry.SignPooled(context.Background(), identity.Signer, signingInput("server hello", clientHelloBytes, replyUnsigned))
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to sign server hello: %w", err)
+	}
+	defer pqc.ReleaseSignature(reply.Signature)
+
+	serverHelloBytes, err := json.Marshal(reply)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to marshal server hello: %w", err)
+	}
+
+	if err := writeFrame(rw, serverHelloBytes); err != nil {
+		return nil, "", fmt.Errorf("failed to send server hello: %w", err)
+	}
+
+	keys, err := deriveKeys(sharedSecret, clientHelloBytes, serverHelloBytes)
+	if err != nil {
+		return nil, "", err
+	}
+
+	log.Printf("🔗 Channel established with %s", hello.ServiceID)
+	return keys, hello.ServiceID, nil
+}
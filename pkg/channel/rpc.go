@@ -0,0 +1,312 @@
+package channel
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+
+	"quantum-safe-mesh/pkg/mesh"
+	"quantum-safe-mesh/pkg/meshlog"
+	"quantum-safe-mesh/pkg/middleware"
+	"quantum-safe-mesh/pkg/models"
+	"quantum-safe-mesh/pkg/pqc"
+)
+
+// Request is one call carried over a channel. ID pairs it with its Response
+// so several calls can be in flight on one connection.
+type Request struct {
+	ID        uint64          `json:"id"`
+	Method    string          `json:"method,omitempty"`
+	Path      string          `json:"path"`
+	RequestID string          `json:"request_id,omitempty"`
+	ParentID  string          `json:"parent_id,omitempty"`
+	Body      json.RawMessage `json:"body,omitempty"`
+
+	IdempotencyKey string `json:"idempotency_key,omitempty"`
+}
+
+// Response answers the Request with the same ID. Error is set instead of
+// Body when the handler failed.
+type Response struct {
+	ID     uint64                `json:"id"`
+	Status int                   `json:"status"`
+	Body   json.RawMessage       `json:"body,omitempty"`
+	Error  *models.ErrorResponse `json:"error,omitempty"`
+}
+
+// Handler serves one Request from the authenticated peerID.
+type Handler func(peerID string, req *Request) *Response
+
+// Serve accepts connections on l until it is closed, running the handshake
+// and then serving each request on its own goroutine.
+func Serve(l *Listener, handler Handler) error {
+	for {
+		conn, err := l.listener.Accept()
+		if err != nil {
+			return err
+		}
+
+		go func() {
+			channelConn, err := NewServerConn(conn, l.identity, l.lookup)
+			if err != nil {
+				log.Printf("❌ Channel handshake with %s failed: %v", conn.RemoteAddr(), err)
+				conn.Close()
+				return
+			}
+			serveConn(channelConn, handler)
+		}()
+	}
+}
+
+func serveConn(conn *Conn, handler Handler) {
+	defer conn.Close()
+
+	for {
+		message, err := conn.ReadMessage()
+		if err != nil {
+			log.Printf("🔌 Channel with %s closed: %v", conn.PeerID(), err)
+			return
+		}
+
+		var req Request
+		if err := json.Unmarshal(message, &req); err != nil {
+			log.Printf("❌ Invalid channel request from %s: %v", conn.PeerID(), err)
+			return
+		}
+
+		go func() {
+			resp := handler(conn.PeerID(), &req)
+			resp.ID = req.ID
+
+			payload, err := json.Marshal(resp)
+			if err != nil {
+				log.Printf("❌ Failed to marshal channel response: %v", err)
+				return
+			}
+			if err := conn.WriteMessage(payload); err != nil {
+				log.Printf("❌ Failed to send channel response to %s: %v", conn.PeerID(), err)
+			}
+		}()
+	}
+}
+
+// MeshHandler serves channel requests with the same mesh.Handlers used for
+// HTTP routes, keyed by path. As with signed HTTP calls, the caller's method
+// is available through mesh.Request.OriginalMethod.
+func MeshHandler(routes map[string]mesh.Handler) Handler {
+	return func(peerID string, req *Request) *Response {
+		handler, exists := routes[req.Path]
+		if !exists {
+			return errorResponse(req, models.NewError(http.StatusNotFound, models.ErrCodeNotFound, "Resource not found"))
+		}
+
+		ctx := meshlog.With(context.Background(), "request_id", req.RequestID, "caller", peerID)
+		httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, req.Path, nil)
+		if err != nil {
+			return errorResponse(req, models.NewError(http.StatusBadRequest, models.ErrCodeInvalidRequest, "Invalid request path"))
+		}
+
+		headers := make(map[string]string)
+		if req.Method != "" {
+			headers[models.HeaderOriginalMethod] = req.Method
+		}
+
+		result, err := handler(&mesh.Request{
+			Request: httpReq,
+			Envelope: models.ServiceRequest{
+				ServiceID:      peerID,
+				RequestID:      req.RequestID,
+				ParentID:       req.ParentID,
+				IdempotencyKey: req.IdempotencyKey,
+				Headers:        headers,
+				Data:           req.Body,
+			},
+			ProtocolVersion: models.CurrentProtocolVersion,
+		})
+		if err != nil {
+			return errorResponse(req, middleware.ErrorFor(ctx, err, req.RequestID))
+		}
+
+		body, err := mesh.MarshalPayload(result)
+		if err != nil {
+			meshlog.FromContext(ctx).Error("❌ Failed to marshal response", "error", err)
+			return errorResponse(req, models.NewError(http.StatusInternalServerError, models.ErrCodeInternal, "Internal server error"))
+		}
+
+		return &Response{Status: http.StatusOK, Body: body}
+	}
+}
+
+// NamespaceHandler wraps handler so it refuses peers outside the namespace
+// owning the requested path, or one it trusts.
+func NamespaceHandler(policy *mesh.NamespacePolicy, handler Handler) Handler {
+	return func(peerID string, req *Request) *Response {
+		if err := policy.Allow(peerID, req.Path); err != nil {
+			log.Printf("❌ %v", err)
+			return errorResponse(req, models.NewError(http.StatusForbidden, models.ErrCodeNamespaceForbidden, "Caller's namespace may not call this route"))
+		}
+		return handler(peerID, req)
+	}
+}
+
+func errorResponse(req *Request, errResp *models.ErrorResponse) *Response {
+	if errResp.RequestID == "" {
+		errResp.RequestID = req.RequestID
+	}
+	return &Response{Status: errResp.Status, Error: errResp}
+}
+
+// Client multiplexes calls to one peer over a single channel, dialing on
+// first use and again after the connection drops.
+type Client struct {
+	addr     string
+	identity *mesh.Identity
+	peerID   string
+	lookup   pqc.PublicKeyLookup
+
+	mutex   sync.Mutex
+	conn    *Conn
+	nextID  uint64
+	pending map[uint64]chan *Response
+}
+
+func NewClient(addr string, identity *mesh.Identity, peerID string, lookup pqc.PublicKeyLookup) *Client {
+	return &Client{addr: addr, identity: identity, peerID: peerID, lookup: lookup}
+}
+
+func (c *Client) PeerID() string {
+	return c.peerID
+}
+
+// Check is a health check that dials the peer unless the channel to it is
+// open, failing if the handshake does.
+func (c *Client) Check(ctx context.Context) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if err := c.connect(); err != nil {
+		return fmt.Errorf("channel to %s unavailable: %w", c.peerID, err)
+	}
+	return nil
+}
+
+// connect dials the peer if there is no open channel. c.mutex must be held.
+func (c *Client) connect() error {
+	if c.conn != nil {
+		return nil
+	}
+	conn, err := Dial(c.addr, c.identity, c.peerID, c.lookup)
+	if err != nil {
+		return err
+	}
+	c.conn = conn
+	c.pending = make(map[uint64]chan *Response)
+	go c.readLoop(conn, c.pending)
+	return nil
+}
+
+// Call sends req and waits for the peer's response.
+func (c *Client) Call(req *Request) (*Response, error) {
+	c.mutex.Lock()
+	if err := c.connect(); err != nil {
+		c.mutex.Unlock()
+		return nil, err
+	}
+
+	conn := c.conn
+	c.nextID++
+	req.ID = c.nextID
+	result := make(chan *Response, 1)
+	c.pending[req.ID] = result
+	c.mutex.Unlock()
+
+	payload, err := json.Marshal(req)
+	if err != nil {
+		c.forget(conn, req.ID)
+		return nil, fmt.Errorf("failed to marshal channel request: %w", err)
+	}
+
+	if err := conn.WriteMessage(payload); err != nil {
+		c.forget(conn, req.ID)
+		c.drop(conn)
+		return nil, fmt.Errorf("failed to send channel request: %w", err)
+	}
+
+	resp, ok := <-result
+	if !ok {
+		return nil, fmt.Errorf("channel to %s closed before response", c.peerID)
+	}
+	return resp, nil
+}
+
+func (c *Client) readLoop(conn *Conn, pending map[uint64]chan *Response) {
+	for {
+		message, err := conn.ReadMessage()
+		if err != nil {
+			log.Printf("🔌 Channel to %s closed: %v", c.peerID, err)
+			break
+		}
+
+		var resp Response
+		if err := json.Unmarshal(message, &resp); err != nil {
+			log.Printf("❌ Invalid channel response from %s: %v", c.peerID, err)
+			break
+		}
+		if resp.Error != nil {
+			// ErrorResponse does not carry its status on the wire.
+			resp.Error.Status = resp.Status
+		}
+
+		c.mutex.Lock()
+		result, exists := pending[resp.ID]
+		delete(pending, resp.ID)
+		c.mutex.Unlock()
+
+		if exists {
+			result <- &resp
+		}
+	}
+
+	c.drop(conn)
+
+	c.mutex.Lock()
+	for id, result := range pending {
+		close(result)
+		delete(pending, id)
+	}
+	c.mutex.Unlock()
+}
+
+func (c *Client) forget(conn *Conn, id uint64) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.conn == conn {
+		delete(c.pending, id)
+	}
+}
+
+// drop discards conn so the next Call redials.
+func (c *Client) drop(conn *Conn) {
+	c.mutex.Lock()
+	if c.conn == conn {
+		c.conn = nil
+	}
+	c.mutex.Unlock()
+
+	conn.Close()
+}
+
+func (c *Client) Close() error {
+	c.mutex.Lock()
+	conn := c.conn
+	c.conn = nil
+	c.mutex.Unlock()
+
+	if conn == nil {
+		return nil
+	}
+	return conn.Close()
+}
@@ -0,0 +1,250 @@
+package cloudattest
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"quantum-safe-mesh/pkg/models"
+	"quantum-safe-mesh/pkg/pqc"
+)
+
+// Cloud providers whose instance identity services can attest.
+const (
+	ProviderAWS   = "aws"
+	ProviderGCP   = "gcp"
+	ProviderAzure = "azure"
+)
+
+// Registration modes for the auth service, as for SPIFFE: optional accepts
+// first registrations without an attestation but requires one to
+// re-register a service bound to an instance; required demands one every
+// time.
+const (
+	ModeOff      = "off"
+	ModeOptional = "optional"
+	ModeRequired = "required"
+)
+
+func ValidMode(mode string) bool {
+	return mode == ModeOff || mode == ModeOptional || mode == ModeRequired
+}
+
+func ValidProvider(provider string) bool {
+	return provider == ProviderAWS || provider == ProviderGCP || provider == ProviderAzure
+}
+
+// Audience prefixes the audience of GCP identity tokens, which is followed
+// by the fingerprint of the key being registered so a token only attests
+// that key.
+const Audience = "quantum-safe-mesh/auth-service"
+
+// KeyAudience is the GCP identity token audience that attests publicKey.
+func KeyAudience(publicKey []byte) string {
+	return Audience + "/" + pqc.KeyFingerprint(publicKey)
+}
+
+// Attestation is what a service presents, base64url-encoded JSON in the
+// X-Mesh-Cloud-Attestation header, to prove which instance it runs on.
+type Attestation struct {
+	Provider string `json:"provider"`
+
+	// Document is the AWS instance identity document and Signature its
+	// RSA-SHA256 signature by the region's AWS certificate.
+	Document  models.Base64URL `json:"document,omitempty"`
+	Signature models.Base64URL `json:"signature,omitempty"`
+
+	// Token is the GCP instance identity token or the Azure managed
+	// identity token.
+	Token string `json:"token,omitempty"`
+}
+
+// Encode returns a's header value.
+func (a *Attestation) Encode() (string, error) {
+	data, err := json.Marshal(a)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal attestation: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+// Decode parses a header value made by Encode.
+func Decode(value string) (*Attestation, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, fmt.Errorf("malformed attestation: %w", err)
+	}
+	var attestation Attestation
+	if err := json.Unmarshal(data, &attestation); err != nil {
+		return nil, fmt.Errorf("malformed attestation: %w", err)
+	}
+	return &attestation, nil
+}
+
+// Instance identities are provider:account:location:instance, e.g.
+// aws:123456789012:us-east-1:i-0abc, gcp:my-project:us-central1-a:web-1 or
+// azure:<subscription>:<resource-group>:web-1.
+func instanceIdentity(provider, account, location, instance string) (string, error) {
+	for _, part := range []string{account, location, instance} {
+		if part == "" || strings.ContainsAny(part, ":,=") {
+			return "", fmt.Errorf("%s attestation does not name its account, location and instance", provider)
+		}
+	}
+	return strings.Join([]string{provider, account, location, instance}, ":"), nil
+}
+
+// Policy maps instance identity patterns to the service IDs instances
+// matching them may register as.
+type Policy []policyRule
+
+type policyRule struct {
+	pattern   string
+	serviceID string
+}
+
+// ParsePolicy parses comma-separated pattern=service-id pairs, where
+// pattern is matched against instance identities with path.Match, e.g.
+// aws:123456789012:*:*=batch-worker.
+func ParsePolicy(value string) (Policy, error) {
+	var policy Policy
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		pattern, serviceID, found := strings.Cut(entry, "=")
+		if !found || pattern == "" || serviceID == "" {
+			return nil, fmt.Errorf("invalid cloud attestation rule %q: expected instance-pattern=service-id", entry)
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid cloud attestation pattern %q: %w", pattern, err)
+		}
+		policy = append(policy, policyRule{pattern: pattern, serviceID: serviceID})
+	}
+	return policy, nil
+}
+
+// Allows reports whether the instance identity may register as serviceID.
+func (p Policy) Allows(identity, serviceID string) bool {
+	for _, rule := range p {
+		if rule.serviceID != serviceID {
+			continue
+		}
+		if matched, _ := path.Match(rule.pattern, identity); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// Client fetches attestations from the instance metadata service.
+type Client struct {
+	provider    string
+	resource    string
+	metadataURL string
+	client      *http.Client
+}
+
+// NewClient attests through provider's metadata service. Azure managed
+// identity tokens are requested for resource, which must be the one the
+// auth service expects.
+func NewClient(provider, resource string) *Client {
+	metadataURL := "http://169.254.169.254"
+	if provider == ProviderGCP {
+		metadataURL = "http://metadata.google.internal"
+	}
+	return &Client{
+		provider:    provider,
+		resource:    resource,
+		metadataURL: metadataURL,
+		client:      &http.Client{Timeout: 5 * time.Second},
+	}
+}
+
+// Provider returns the cloud the client attests on.
+func (c *Client) Provider() string {
+	return c.provider
+}
+
+// Attest returns the header value attesting this instance, for the
+// registration of publicKey.
+func (c *Client) Attest(publicKey []byte) (string, error) {
+	attestation := &Attestation{Provider: c.provider}
+	switch c.provider {
+	case ProviderAWS:
+		// IMDSv2: a session token first, then the signed document.
+		token, err := c.get("PUT", "/latest/api/token", map[string]string{"X-aws-ec2-metadata-token-ttl-seconds": "60"})
+		if err != nil {
+			return "", err
+		}
+		headers := map[string]string{"X-aws-ec2-metadata-token": string(token)}
+		if attestation.Document, err = c.get("GET", "/latest/dynamic/instance-identity/document", headers); err != nil {
+			return "", err
+		}
+		signature, err := c.get("GET", "/latest/dynamic/instance-identity/signature", headers)
+		if err != nil {
+			return "", err
+		}
+		if attestation.Signature, err = base64.StdEncoding.DecodeString(strings.Join(strings.Fields(string(signature)), "")); err != nil {
+			return "", fmt.Errorf("failed to decode instance identity signature: %w", err)
+		}
+
+	case ProviderGCP:
+		query := url.Values{"audience": {KeyAudience(publicKey)}, "format": {"full"}}
+		token, err := c.get("GET", "/computeMetadata/v1/instance/service-accounts/default/identity?"+query.Encode(),
+			map[string]string{"Metadata-Flavor": "Google"})
+		if err != nil {
+			return "", err
+		}
+		attestation.Token = string(token)
+
+	case ProviderAzure:
+		query := url.Values{"api-version": {"2018-02-01"}, "resource": {c.resource}}
+		body, err := c.get("GET", "/metadata/identity/oauth2/token?"+query.Encode(), map[string]string{"Metadata": "true"})
+		if err != nil {
+			return "", err
+		}
+		var response struct {
+			AccessToken string `json:"access_token"`
+		}
+		if err := json.Unmarshal(body, &response); err != nil {
+			return "", fmt.Errorf("failed to decode managed identity token: %w", err)
+		}
+		attestation.Token = response.AccessToken
+
+	default:
+		return "", fmt.Errorf("unsupported cloud provider %q", c.provider)
+	}
+	return attestation.Encode()
+}
+
+func (c *Client) get(method, path string, headers map[string]string) ([]byte, error) {
+	req, err := http.NewRequest(method, c.metadataURL+path, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create metadata request: %w", err)
+	}
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach %s metadata service: %w", c.provider, err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s metadata: %w", c.provider, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s metadata service returned status %d for %s", c.provider, resp.StatusCode, strings.SplitN(path, "?", 2)[0])
+	}
+	return body, nil
+}
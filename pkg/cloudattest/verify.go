@@ -0,0 +1,144 @@
+package cloudattest
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"strings"
+
+	"quantum-safe-mesh/pkg/oidc"
+)
+
+const googleIssuer = "https://accounts.google.com"
+
+// Verifier checks attestations and returns the instance identity they
+// prove.
+type Verifier struct {
+	awsKeys       []*rsa.PublicKey
+	google        *oidc.Verifier
+	azure         *oidc.Verifier
+	azureResource string
+}
+
+// NewVerifier verifies AWS documents with the certificates in awsCertFile,
+// the AWS public certificates of the regions instances run in, and Azure
+// tokens issued by azureTenant for azureResource. Providers left
+// unconfigured are refused; GCP needs no configuration.
+func NewVerifier(awsCertFile, azureTenant, azureResource string) (*Verifier, error) {
+	v := &Verifier{google: oidc.NewVerifier(googleIssuer), azureResource: azureResource}
+
+	if awsCertFile != "" {
+		data, err := os.ReadFile(awsCertFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read AWS certificates: %w", err)
+		}
+		for block, rest := pem.Decode(data); block != nil; block, rest = pem.Decode(rest) {
+			cert, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse AWS certificate: %w", err)
+			}
+			key, ok := cert.PublicKey.(*rsa.PublicKey)
+			if !ok {
+				return nil, fmt.Errorf("AWS certificate %s does not hold an RSA key", cert.Subject)
+			}
+			v.awsKeys = append(v.awsKeys, key)
+		}
+		if len(v.awsKeys) == 0 {
+			return nil, fmt.Errorf("no certificates in %s", awsCertFile)
+		}
+	}
+
+	if azureTenant != "" {
+		v.azure = oidc.NewVerifier("https://sts.windows.net/" + azureTenant + "/")
+	}
+	return v, nil
+}
+
+// Verify checks the attestation in header value, presented with the
+// registration of publicKey, and returns the instance identity it proves.
+func (v *Verifier) Verify(value string, publicKey []byte) (string, error) {
+	attestation, err := Decode(value)
+	if err != nil {
+		return "", err
+	}
+
+	switch attestation.Provider {
+	case ProviderAWS:
+		return v.verifyAWS(attestation)
+	case ProviderGCP:
+		return v.verifyGCP(attestation, publicKey)
+	case ProviderAzure:
+		return v.verifyAzure(attestation)
+	default:
+		return "", fmt.Errorf("unsupported cloud provider %q", attestation.Provider)
+	}
+}
+
+func (v *Verifier) verifyAWS(attestation *Attestation) (string, error) {
+	if len(v.awsKeys) == 0 {
+		return "", fmt.Errorf("AWS attestation is not configured")
+	}
+	digest := sha256.Sum256(attestation.Document)
+	verified := false
+	for _, key := range v.awsKeys {
+		if rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], attestation.Signature) == nil {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		return "", fmt.Errorf("instance identity document signature verification failed")
+	}
+
+	var document struct {
+		AccountID  string `json:"accountId"`
+		Region     string `json:"region"`
+		InstanceID string `json:"instanceId"`
+	}
+	if err := json.Unmarshal(attestation.Document, &document); err != nil {
+		return "", fmt.Errorf("malformed instance identity document: %w", err)
+	}
+	return instanceIdentity(ProviderAWS, document.AccountID, document.Region, document.InstanceID)
+}
+
+func (v *Verifier) verifyGCP(attestation *Attestation, publicKey []byte) (string, error) {
+	var claims struct {
+		Google struct {
+			ComputeEngine struct {
+				ProjectID    string `json:"project_id"`
+				Zone         string `json:"zone"`
+				InstanceName string `json:"instance_name"`
+			} `json:"compute_engine"`
+		} `json:"google"`
+	}
+	if _, err := v.google.Verify(attestation.Token, KeyAudience(publicKey), &claims); err != nil {
+		return "", fmt.Errorf("invalid GCP identity token: %w", err)
+	}
+	instance := claims.Google.ComputeEngine
+	return instanceIdentity(ProviderGCP, instance.ProjectID, instance.Zone, instance.InstanceName)
+}
+
+func (v *Verifier) verifyAzure(attestation *Attestation) (string, error) {
+	if v.azure == nil {
+		return "", fmt.Errorf("Azure attestation is not configured")
+	}
+	var claims struct {
+		ResourceID string `json:"xms_mirid"`
+	}
+	if _, err := v.azure.Verify(attestation.Token, v.azureResource, &claims); err != nil {
+		return "", fmt.Errorf("invalid Azure managed identity token: %w", err)
+	}
+
+	// /subscriptions/<id>/resourcegroups/<group>/providers/Microsoft.Compute/virtualMachines/<name>
+	parts := strings.Split(strings.TrimPrefix(claims.ResourceID, "/"), "/")
+	if len(parts) != 8 || !strings.EqualFold(parts[0], "subscriptions") || !strings.EqualFold(parts[2], "resourcegroups") ||
+		!strings.EqualFold(parts[5], "Microsoft.Compute") || !strings.EqualFold(parts[6], "virtualMachines") {
+		return "", fmt.Errorf("managed identity token is not for a virtual machine: %q", claims.ResourceID)
+	}
+	return instanceIdentity(ProviderAzure, strings.ToLower(parts[1]), strings.ToLower(parts[3]), parts[7])
+}
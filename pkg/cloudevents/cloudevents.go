@@ -0,0 +1,124 @@
+package cloudevents
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"quantum-safe-mesh/pkg/mesh"
+	"quantum-safe-mesh/pkg/models"
+	"quantum-safe-mesh/pkg/pqc"
+)
+
+// The auth service publishes a CloudEvent (https://cloudevents.io, version
+// 1.0, structured JSON mode) for every change to mesh membership. Each
+// event's data is signed by the auth service, in the meshsignature
+// extension, so consumers need not trust the sink that delivered it.
+
+const (
+	SpecVersion = "1.0"
+	ContentType = "application/cloudevents+json"
+
+	// DefaultSubject is the bus subject, or Kafka topic, events are
+	// published on.
+	DefaultSubject = "mesh.registry.events"
+)
+
+// Event types, one per registry lifecycle change.
+const (
+	TypeRegistered = "io.quantumsafemesh.registry.v1.registered"
+	TypeRotated    = "io.quantumsafemesh.registry.v1.rotated"
+	TypeRevoked    = "io.quantumsafemesh.registry.v1.revoked"
+	TypeExpired    = "io.quantumsafemesh.registry.v1.expired"
+)
+
+// eventTypes maps the audit events that change membership to event types.
+var eventTypes = map[string]string{
+	mesh.AuditRegistered: TypeRegistered,
+	mesh.AuditRotated:    TypeRotated,
+	mesh.AuditRevoked:    TypeRevoked,
+	mesh.AuditExpired:    TypeExpired,
+}
+
+// Event is a CloudEvent in structured JSON mode.
+type Event struct {
+	SpecVersion     string          `json:"specversion"`
+	ID              string          `json:"id"`
+	Source          string          `json:"source"`
+	Type            string          `json:"type"`
+	Subject         string          `json:"subject,omitempty"`
+	Time            time.Time       `json:"time"`
+	DataContentType string          `json:"datacontenttype"`
+	Data            json.RawMessage `json:"data"`
+
+	// MeshSignature is a detached JWS over Data by the auth service, whose
+	// rid is the event ID.
+	MeshSignature string `json:"meshsignature"`
+}
+
+// RegistryData is the data of a registry event: the service whose key
+// changed, the key's fingerprint and the service that made the change,
+// when it is not the service itself.
+type RegistryData struct {
+	ServiceID string `json:"service_id"`
+	KeyID     string `json:"key_id,omitempty"`
+	Actor     string `json:"actor,omitempty"`
+	Detail    string `json:"detail,omitempty"`
+}
+
+// FromAudit builds the event for an audit event recorded by the auth
+// service, signed by identity. It returns nil for audit events that do not
+// change membership.
+func FromAudit(identity *mesh.Identity, source string, audit mesh.AuditEvent) (*Event, error) {
+	eventType, ok := eventTypes[audit.Type]
+	if !ok {
+		return nil, nil
+	}
+
+	data, err := json.Marshal(RegistryData{
+		ServiceID: audit.Subject,
+		KeyID:     audit.KeyID,
+		Actor:     audit.Actor,
+		Detail:    audit.Detail,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal event data: %w", err)
+	}
+
+	event := &Event{
+		SpecVersion:     SpecVersion,
+		ID:              models.NewRequestID(),
+		Source:          source,
+		Type:            eventType,
+		Subject:         audit.Subject,
+		Time:            audit.Time,
+		DataContentType: "application/json",
+		Data:            data,
+	}
+	event.MeshSignature, err = pqc.SignDetachedWithHeader(identity.Signer, pqc.JWSHeader{Kid: identity.ServiceID, Rid: event.ID}, data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign event: %w", err)
+	}
+	return event, nil
+}
+
+// Verify checks event's data is signed by the auth service's publicKey for
+// this event, and returns it.
+func Verify(event *Event, publicKey []byte) (*RegistryData, error) {
+	header, err := pqc.VerifyDetachedJWS(publicKey, event.MeshSignature, event.Data)
+	if err != nil {
+		return nil, fmt.Errorf("event signature verification failed: %w", err)
+	}
+	if header.Rid != event.ID {
+		return nil, fmt.Errorf("event signature is for event %q, not %q", header.Rid, event.ID)
+	}
+
+	var data RegistryData
+	if err := json.Unmarshal(event.Data, &data); err != nil {
+		return nil, fmt.Errorf("malformed event data: %w", err)
+	}
+	if data.ServiceID != event.Subject {
+		return nil, fmt.Errorf("event data is about %q, not its subject %q", data.ServiceID, event.Subject)
+	}
+	return &data, nil
+}
@@ -0,0 +1,138 @@
+package cloudevents
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"quantum-safe-mesh/pkg/mesh"
+	"quantum-safe-mesh/pkg/meshmsg"
+)
+
+// queueSize is how many events may wait for the sink before new ones are
+// dropped.
+const queueSize = 256
+
+// sendAttempts is how many times an event is offered to the sink.
+const sendAttempts = 3
+
+// Sink delivers events.
+type Sink interface {
+	Send(event *Event) error
+	Close() error
+}
+
+// NewSink opens the sink at url: an http(s) URL events are POSTed to, or a
+// nats:// or kafka:// URL they are published to on subject.
+func NewSink(url, subject, serviceID string, timeout time.Duration) (Sink, error) {
+	if strings.HasPrefix(url, "http://") || strings.HasPrefix(url, "https://") {
+		return &httpSink{url: url, client: &http.Client{Timeout: timeout}}, nil
+	}
+	bus, err := meshmsg.Connect(url, serviceID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to CloudEvents sink: %w", err)
+	}
+	return &busSink{bus: bus, subject: subject}, nil
+}
+
+type httpSink struct {
+	url    string
+	client *http.Client
+}
+
+func (s *httpSink) Send(event *Event) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to marshal event: %w", err)
+	}
+	resp, err := s.client.Post(s.url, ContentType, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to post event: %w", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("sink returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func (s *httpSink) Close() error {
+	return nil
+}
+
+type busSink struct {
+	bus     meshmsg.Bus
+	subject string
+}
+
+func (s *busSink) Send(event *Event) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to marshal event: %w", err)
+	}
+	return s.bus.Publish(s.subject, body)
+}
+
+func (s *busSink) Close() error {
+	return s.bus.Close()
+}
+
+// Emitter turns the auth service's audit events into signed CloudEvents
+// and sends them to a sink in the background, so a slow or unreachable
+// sink never holds up the registry.
+type Emitter struct {
+	identity *mesh.Identity
+	source   string
+	sink     Sink
+	queue    chan *Event
+}
+
+// NewEmitter signs events with identity, names them as coming from source
+// and sends them to sink.
+func NewEmitter(identity *mesh.Identity, source string, sink Sink) *Emitter {
+	e := &Emitter{identity: identity, source: source, sink: sink, queue: make(chan *Event, queueSize)}
+	go e.run()
+	return e
+}
+
+// Observe queues the event for audit, if it changes membership. It is an
+// audit log observer.
+func (e *Emitter) Observe(audit mesh.AuditEvent) {
+	event, err := FromAudit(e.identity, e.source, audit)
+	if err != nil {
+		log.Printf("⚠️  Failed to build CloudEvent for %s of %s: %v", audit.Type, audit.Subject, err)
+		return
+	}
+	if event == nil {
+		return
+	}
+
+	select {
+	case e.queue <- event:
+	default:
+		log.Printf("⚠️  CloudEvents queue full, dropping %s event for %s", audit.Type, audit.Subject)
+	}
+}
+
+func (e *Emitter) run() {
+	for event := range e.queue {
+		var err error
+		for attempt := 1; attempt <= sendAttempts; attempt++ {
+			if err = e.sink.Send(event); err == nil {
+				break
+			}
+			if attempt < sendAttempts {
+				time.Sleep(time.Duration(attempt) * time.Second)
+			}
+		}
+		if err != nil {
+			log.Printf("⚠️  Failed to send CloudEvent %s (%s of %s): %v", event.ID, event.Type, event.Subject, err)
+			continue
+		}
+		log.Printf("📣 Sent CloudEvent %s for %s", event.Type, event.Subject)
+	}
+}
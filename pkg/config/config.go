@@ -0,0 +1,1686 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+	"quantum-safe-mesh/pkg/agent"
+	"quantum-safe-mesh/pkg/auditsink"
+	"quantum-safe-mesh/pkg/buildinfo"
+	"quantum-safe-mesh/pkg/cloudattest"
+	"quantum-safe-mesh/pkg/cloudevents"
+	"quantum-safe-mesh/pkg/diagnostics"
+	"quantum-safe-mesh/pkg/discovery"
+	"quantum-safe-mesh/pkg/health"
+	"quantum-safe-mesh/pkg/httpclient"
+	"quantum-safe-mesh/pkg/kubeauth"
+	"quantum-safe-mesh/pkg/mesh"
+	"quantum-safe-mesh/pkg/meshlog"
+	"quantum-safe-mesh/pkg/pipeline"
+	"quantum-safe-mesh/pkg/pqc"
+	"quantum-safe-mesh/pkg/secrets"
+	"quantum-safe-mesh/pkg/spiffe"
+	"quantum-safe-mesh/pkg/telemetry"
+	"quantum-safe-mesh/pkg/wasmfilter"
+)
+
+// Services with built-in defaults.
+const (
+	ServiceAuth     = "auth"
+	ServiceGateway  = "gateway"
+	ServiceBackend  = "backend"
+	ServiceSidecar  = "sidecar"
+	ServiceOperator = "operator"
+	ServiceAgent    = "agent"
+)
+
+// Default algorithms. crypto.signature may also be ml-dsa-65, the FIPS 204
+// standard, or any SLH-DSA parameter set, for deployments that mandate
+// hash-based signatures; crypto.kem may also be ml-kem-768, the FIPS 203
+// standard.
+const (
+	SignatureDilithium3 = pqc.AlgorithmDilithium3
+	KEMKyber768         = pqc.AlgorithmKyber768
+)
+
+// Config is the configuration shared by every mesh binary. Each setting can
+// come from a YAML file, an environment variable or a flag; the env tag names
+// the variable and the flag is the dotted YAML path with dashes, e.g.
+// -discovery.consul-addr. Secrets are redacted from Effective.
+type Config struct {
+	File    string `yaml:"-"`
+	Profile string `yaml:"profile" env:"MESH_PROFILE" usage:"preset the other settings start from: empty for none, or public for a gateway exposed to the internet, with TLS and client certificates required, tight rate and body limits, a short replay window and no admin endpoints on its listener"`
+
+	Service     ServiceConfig     `yaml:"service"`
+	TLS         TLSConfig         `yaml:"tls"`
+	Auth        AuthConfig        `yaml:"auth"`
+	Upstream    UpstreamConfig    `yaml:"upstream"`
+	App         AppConfig         `yaml:"app"`
+	Keys        KeysConfig        `yaml:"keys"`
+	Crypto      CryptoConfig      `yaml:"crypto"`
+	Timeouts    TimeoutsConfig    `yaml:"timeouts"`
+	Discovery   DiscoveryConfig   `yaml:"discovery"`
+	Messaging   MessagingConfig   `yaml:"messaging"`
+	Policy      PolicyConfig      `yaml:"policy"`
+	Cluster     ClusterConfig     `yaml:"cluster"`
+	Snapshot    SnapshotConfig    `yaml:"snapshot"`
+	Operator    OperatorConfig    `yaml:"operator"`
+	Agent       AgentConfig       `yaml:"agent"`
+	Quota       QuotaConfig       `yaml:"quota"`
+	GraphQL     GraphQLConfig     `yaml:"graphql"`
+	Ledger      LedgerConfig      `yaml:"ledger"`
+	Replay      ReplayConfig      `yaml:"replay"`
+	Idempotency IdempotencyConfig `yaml:"idempotency"`
+	RateLimit   RateLimitConfig   `yaml:"rate_limit"`
+	Admission   AdmissionConfig   `yaml:"admission"`
+	Pipeline    PipelineConfig    `yaml:"pipeline"`
+	Log         LogConfig         `yaml:"log"`
+	Audit       AuditConfig       `yaml:"audit"`
+	Telemetry   TelemetryConfig   `yaml:"telemetry"`
+	Debug       DebugConfig       `yaml:"debug"`
+	Reload      ReloadConfig      `yaml:"reload"`
+	Secrets     SecretsConfig     `yaml:"secrets"`
+
+	references bool // some secret settings were references resolved through secretStore
+	shared     bool // loaded by LoadAll, keeping the built-in service section
+}
+
+type ServiceConfig struct {
+	ID           string `yaml:"id" env:"SERVICE_ID" usage:"mesh identity of this service"`
+	ListenAddr   string `yaml:"listen_addr" env:"LISTEN_ADDR" usage:"HTTP listen address"`
+	AdvertiseURL string `yaml:"advertise_url" env:"ADVERTISE_URL" usage:"base URL advertised to the registry"`
+	ChannelAddr  string `yaml:"channel_addr" env:"CHANNEL_ADDR" usage:"PQC channel listen address"`
+	AdminAddr    string `yaml:"admin_addr" env:"ADMIN_LISTEN_ADDR" usage:"address the gateway serves /config, /metrics, /audit, /upstreams and /version on instead of its listen address, e.g. localhost:9081"`
+}
+
+// ProfilePublic is the preset for a gateway exposed to the internet, e.g.
+// for a workshop. Its settings are defaults like any other, except that
+// Validate holds the gateway to the ones that make it safe to expose.
+const ProfilePublic = "public"
+
+// TLS client authentication modes.
+const (
+	TLSClientAuthNone    = "none"
+	TLSClientAuthRequest = "request" // verify a client certificate if one is presented
+	TLSClientAuthRequire = "require"
+)
+
+// TLSConfig configures HTTPS on the gateway's listener. The mesh signs and
+// verifies every call itself; TLS is for clients outside it.
+type TLSConfig struct {
+	CertFile     string `yaml:"cert_file" env:"TLS_CERT_FILE" usage:"PEM certificate chain the gateway serves HTTPS with; empty serves plain HTTP"`
+	KeyFile      string `yaml:"key_file" env:"TLS_KEY_FILE" usage:"PEM private key of tls.cert_file"`
+	ClientCAFile string `yaml:"client_ca_file" env:"TLS_CLIENT_CA_FILE" usage:"PEM CA certificates client certificates must chain to"`
+	ClientAuth   string `yaml:"client_auth" env:"TLS_CLIENT_AUTH" usage:"client certificate policy: none, request (verified if presented) or require"`
+	MinVersion   string `yaml:"min_version" env:"TLS_MIN_VERSION" usage:"oldest TLS version accepted: 1.2 or 1.3"`
+}
+
+// ServerConfig returns the TLS configuration to serve with, or nil to serve
+// plain HTTP.
+func (t TLSConfig) ServerConfig() (*tls.Config, error) {
+	if t.CertFile == "" {
+		return nil, nil
+	}
+	cert, err := tls.LoadX509KeyPair(t.CertFile, t.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load TLS certificate: %w", err)
+	}
+	config := &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+	if t.MinVersion == "1.3" {
+		config.MinVersion = tls.VersionTLS13
+	}
+
+	switch t.ClientAuth {
+	case TLSClientAuthRequest:
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+	case TLSClientAuthRequire:
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	if t.ClientCAFile != "" {
+		data, err := os.ReadFile(t.ClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read client CA certificates: %w", err)
+		}
+		config.ClientCAs = x509.NewCertPool()
+		if !config.ClientCAs.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no certificates in %s", t.ClientCAFile)
+		}
+	}
+	return config, nil
+}
+
+type AuthConfig struct {
+	URL string `yaml:"url" env:"AUTH_SERVICE_URL" usage:"auth service URL"`
+}
+
+// UpstreamConfig is the service the gateway forwards to.
+type UpstreamConfig struct {
+	ID          string `yaml:"id" env:"BACKEND_SERVICE_ID" usage:"upstream service ID"`
+	URL         string `yaml:"url" env:"BACKEND_SERVICE_URL" usage:"upstream service URL"`
+	ChannelAddr string `yaml:"channel_addr" env:"BACKEND_CHANNEL_ADDR" usage:"upstream PQC channel address"`
+	Filters     string `yaml:"filters" env:"GATEWAY_FILTERS" usage:"comma-separated /path-prefix=filter|filter pairs running registered filters on requests to the route and their responses"`
+
+	WASMFilters     string        `yaml:"wasm_filters" env:"WASM_FILTERS" usage:"comma-separated name=path.wasm pairs loading WebAssembly modules as filters"`
+	WASMMemoryLimit int           `yaml:"wasm_memory_limit" env:"WASM_FILTER_MEMORY_LIMIT" usage:"memory limit of each WebAssembly filter call, in MiB"`
+	WASMTimeout     time.Duration `yaml:"wasm_timeout" env:"WASM_FILTER_TIMEOUT" usage:"time limit of each WebAssembly filter call"`
+
+	Cache           string `yaml:"cache" env:"GATEWAY_CACHE" usage:"comma-separated /path-prefix=ttl pairs caching verified responses to GET and HEAD requests on the route"`
+	CacheMaxEntries int    `yaml:"cache_max_entries" env:"GATEWAY_CACHE_MAX_ENTRIES" usage:"how many responses the gateway cache keeps"`
+	Coalesce        bool   `yaml:"coalesce" env:"GATEWAY_COALESCE" usage:"share one backend call among identical GET and HEAD requests in flight at the same time"`
+	StreamPaths     string `yaml:"stream_paths" env:"GATEWAY_STREAM_PATHS" usage:"comma-separated /path or /prefix/* routes whose uploads are streamed to the backend, signed by their SHA-256, rather than buffered"`
+
+	Replicas       string `yaml:"replicas" env:"BACKEND_REPLICAS" usage:"comma-separated base URLs of upstream replicas to spread requests over, in place of upstream.url"`
+	Affinity       string `yaml:"affinity" env:"GATEWAY_AFFINITY" usage:"which replica a request goes to: off, client (by caller identity) or session (by session ID header)"`
+	AffinityHeader string `yaml:"affinity_header" env:"GATEWAY_AFFINITY_HEADER" usage:"header carrying the session ID for session affinity"`
+
+	Versions       string `yaml:"versions" env:"GATEWAY_API_VERSIONS" usage:"comma-separated version=service-id[@url][|filter|filter] entries routing each external API version, selected by a /version path prefix or the Accept-Version header"`
+	DefaultVersion string `yaml:"default_version" env:"GATEWAY_API_DEFAULT_VERSION" usage:"API version of requests that name none; empty forwards them to upstream.id unversioned"`
+
+	OutlierConsecutiveFailures int           `yaml:"outlier_consecutive_failures" env:"GATEWAY_OUTLIER_CONSECUTIVE_FAILURES" usage:"failed calls in a row that eject an upstream; 0 turns the check off"`
+	OutlierErrorPercent        int           `yaml:"outlier_error_percent" env:"GATEWAY_OUTLIER_ERROR_PERCENT" usage:"percentage of an upstream's latest calls failing that ejects it; 0 turns the check off"`
+	OutlierMinRequests         int           `yaml:"outlier_min_requests" env:"GATEWAY_OUTLIER_MIN_REQUESTS" usage:"calls an upstream must have had before its error rate can eject it"`
+	OutlierCooldown            time.Duration `yaml:"outlier_cooldown" env:"GATEWAY_OUTLIER_COOLDOWN" usage:"how long an ejected upstream is kept out of service"`
+
+	Retries int `yaml:"retries" env:"GATEWAY_UPSTREAM_RETRIES" usage:"times a call to the upstream is retried after failing to connect, or after a 502, 503 or 504 if it carries an Idempotency-Key"`
+
+	MaxBodySize int `yaml:"max_body_size" env:"GATEWAY_MAX_BODY_SIZE" usage:"largest request body the gateway accepts, in bytes; 0 leaves the mesh-wide limit of 10 MiB"`
+}
+
+// AppConfig is the local app the sidecar fronts.
+type AppConfig struct {
+	URL string `yaml:"url" env:"APP_URL" usage:"local app URL"`
+}
+
+// Key store modes.
+const (
+	KeysModeFile      = "file"      // keys live in keys.dir
+	KeysModeEphemeral = "ephemeral" // fresh keys in memory on every start
+	KeysModeAgent     = "agent"     // keys held by a mesh-agent at keys.agent_socket
+)
+
+type KeysConfig struct {
+	Mode        string `yaml:"mode" env:"KEYS_MODE" usage:"file, ephemeral to generate keys in memory at startup and never write them, or agent to use a mesh-agent's keys"`
+	AgentSocket string `yaml:"agent_socket" env:"MESH_AGENT_SOCKET" usage:"Unix socket of the mesh-agent"`
+	Dir         string `yaml:"dir" env:"KEYS_DIR" usage:"key store directory"`
+	Format      string `yaml:"format" env:"KEYS_FORMAT" usage:"format new keys are written in: raw, pem or oqs"`
+	Keystore    string `yaml:"keystore" env:"KEYS_KEYSTORE" usage:"encrypted keystore file holding the keys of every service, as written by 'meshctl migrate-keys --to', used instead of the key files in keys.dir"`
+	Store       string `yaml:"store" env:"KEYS_STORE" usage:"where the passphrase encrypting private keys comes from: file for keys.passphrase, or keychain to generate one and keep it in the OS credential store (macOS Keychain, Linux Secret Service, Windows DPAPI)"`
+	Passphrase  string `yaml:"passphrase" env:"KEYS_PASSPHRASE" usage:"passphrase encrypting private keys" secret:"true"`
+	Generate    bool   `yaml:"generate" env:"KEYS_GENERATE" usage:"generate keys on startup if none exist, instead of failing"`
+	Shares      string `yaml:"shares" env:"KEYS_SHARES" usage:"comma-separated key share files to rebuild a split signing key from at startup"`
+	EscrowKey   string `yaml:"escrow_key" env:"KEYS_ESCROW_KEY" usage:"recovery public key that saved private keys are also escrowed to"`
+
+	MaxAge        time.Duration `yaml:"max_age" env:"KEYS_MAX_AGE" usage:"age at which signing keys expire; 0 keeps them forever"`
+	ExpiryWarning time.Duration `yaml:"expiry_warning" env:"KEYS_EXPIRY_WARNING" usage:"warn at startup when the signing key expires within this window"`
+	AutoRotate    bool          `yaml:"auto_rotate" env:"KEYS_AUTO_ROTATE" usage:"rotate the signing key at startup once it is within keys.expiry_warning of expiring"`
+}
+
+// Apply points the pqc key store at these settings. With the keychain
+// store it reads the passphrase from the OS credential store, generating one
+// if keys.generate may create the service's first keys.
+func (k KeysConfig) Apply() error {
+	pqc.KeysDir = k.Dir
+	pqc.KeysFormat = k.Format
+	pqc.KeystoreFile = k.Keystore
+	pqc.KeysPassphrase = []byte(k.Passphrase)
+	pqc.KeyMaxAge = k.MaxAge
+	pqc.EscrowKeyFile = k.EscrowKey
+
+	if k.Store != pqc.KeyStoreKeychain || k.Mode != KeysModeFile {
+		return nil
+	}
+	passphrase, err := pqc.KeychainPassphrase(k.Dir, k.Generate)
+	if err != nil {
+		return err
+	}
+	pqc.KeysPassphrase = passphrase
+	return nil
+}
+
+// LoadIdentity loads serviceID's keys from the key store and checks they
+// are of algorithm and kemAlgorithm, the configured crypto.signature and
+// crypto.kem. Missing keys are an error unless keys.generate is set; normally they are created up front with
+// 'meshctl keygen'. With keys.shares the signing key is rebuilt from key
+// shares instead of read from its file. In ephemeral mode it generates keys
+// instead, which only last as long as the process. In agent mode the keys
+// stay in the mesh-agent, which signs and decapsulates for the service.
+func (k KeysConfig) LoadIdentity(serviceID, algorithm, kemAlgorithm string) (*mesh.Identity, error) {
+	_, loaded := telemetry.Observe(context.Background(), telemetry.OpKeyLoad, algorithm)
+	identity, err := k.loadIdentity(serviceID, algorithm, kemAlgorithm)
+	loaded(err)
+	return identity, err
+}
+
+func (k KeysConfig) loadIdentity(serviceID, algorithm, kemAlgorithm string) (*mesh.Identity, error) {
+	switch k.Mode {
+	case KeysModeEphemeral:
+		log.Printf("🫥 Ephemeral keys: generating an in-memory identity for %s", serviceID)
+		return mesh.GenerateIdentity(serviceID, algorithm, kemAlgorithm)
+	case KeysModeAgent:
+		return k.agentIdentity(serviceID, algorithm, kemAlgorithm)
+	}
+
+	var identity *mesh.Identity
+	var err error
+	if k.Shares != "" {
+		if identity, err = k.splitIdentity(serviceID); err != nil {
+			return nil, err
+		}
+	} else if k.Generate {
+		identity, err = mesh.LoadOrCreateIdentity(serviceID, algorithm, kemAlgorithm)
+	} else {
+		identity, err = mesh.LoadIdentity(serviceID)
+	}
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("no keys for %s in %s: create them with 'meshctl keygen --service-id %s --alg %s --kem %s' or set KEYS_GENERATE=true (%w)",
+			serviceID, k.location(), serviceID, algorithm, kemAlgorithm, err)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load keys for %s from %s: %w", serviceID, k.location(), err)
+	}
+
+	if stored := identity.Signer.Algorithm(); stored != algorithm {
+		return nil, fmt.Errorf("keys for %s in %s are %s but crypto.signature is %s: switch with 'meshctl rotate --service-id %s --alg %s'",
+			serviceID, k.location(), stored, algorithm, serviceID, algorithm)
+	}
+	if stored := identity.Kyber.Algorithm(); stored != kemAlgorithm {
+		return nil, fmt.Errorf("KEM key of %s in %s is %s but crypto.kem is %s: switch with 'meshctl migrate-keys --service-id %s --kem-alg %s'",
+			serviceID, k.location(), stored, kemAlgorithm, serviceID, kemAlgorithm)
+	}
+
+	k.warnExpiry(identity)
+	return identity, nil
+}
+
+// location names where keys in the key store are kept, for messages.
+func (k KeysConfig) location() string {
+	if k.Keystore != "" {
+		return "keystore " + k.Keystore
+	}
+	return k.Dir
+}
+
+// splitIdentity loads serviceID's keys, rebuilding its signing key from the
+// shares in keys.shares.
+func (k KeysConfig) splitIdentity(serviceID string) (*mesh.Identity, error) {
+	shares, err := pqc.ReadKeyShares(splitList(k.Shares))
+	if err != nil {
+		return nil, err
+	}
+	identity, err := mesh.LoadSplitIdentity(serviceID, shares)
+	if err != nil {
+		return nil, fmt.Errorf("failed to rebuild the signing key of %s from key shares: %w", serviceID, err)
+	}
+	return identity, nil
+}
+
+func (k KeysConfig) agentIdentity(serviceID, algorithm, kemAlgorithm string) (*mesh.Identity, error) {
+	identity, err := agent.NewClient(k.AgentSocket).Identity(serviceID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get keys for %s from the mesh-agent: %w", serviceID, err)
+	}
+	if held := identity.Signer.Algorithm(); held != algorithm {
+		return nil, fmt.Errorf("mesh-agent holds %s keys for %s but crypto.signature is %s", held, serviceID, algorithm)
+	}
+	if held := identity.Kyber.Algorithm(); held != kemAlgorithm {
+		return nil, fmt.Errorf("mesh-agent holds a %s KEM key for %s but crypto.kem is %s", held, serviceID, kemAlgorithm)
+	}
+	log.Printf("🗝️  Signing with %s key %s held by the mesh-agent at %s", serviceID, identity.KeyID(), k.AgentSocket)
+	return identity, nil
+}
+
+// keyExpiry returns when identity's signing key expires; the zero time
+// means never.
+func (k KeysConfig) keyExpiry(identity *mesh.Identity) (time.Time, error) {
+	metadata, err := pqc.LoadKeyMetadata(identity.ServiceID, identity.PublicKey())
+	if err != nil {
+		return time.Time{}, err
+	}
+	return metadata.Expiry(k.MaxAge), nil
+}
+
+// CheckKeys returns a health check failing if identity has no signing key
+// or, for keys in the key store, once the key has expired.
+func (k KeysConfig) CheckKeys(identity *mesh.Identity) health.Checker {
+	return func(ctx context.Context) error {
+		if identity == nil || identity.Signer == nil {
+			return fmt.Errorf("no signing key loaded")
+		}
+		if k.Mode != KeysModeFile {
+			return nil
+		}
+		expiry, err := k.keyExpiry(identity)
+		if err != nil {
+			return err
+		}
+		if !expiry.IsZero() && time.Now().After(expiry) {
+			return fmt.Errorf("signing key of %s expired at %s", identity.ServiceID, expiry.Format(time.RFC3339))
+		}
+		return nil
+	}
+}
+
+func (k KeysConfig) warnExpiry(identity *mesh.Identity) {
+	expiry, err := k.keyExpiry(identity)
+	if err != nil {
+		log.Printf("Warning: failed to read key metadata for %s: %v", identity.ServiceID, err)
+		return
+	}
+	if expiry.IsZero() {
+		return
+	}
+
+	action := fmt.Sprintf("rotate it with 'meshctl rotate --service-id %s'", identity.ServiceID)
+	if k.AutoRotate {
+		action = "rotating it automatically"
+	}
+	remaining := time.Until(expiry)
+	switch {
+	case remaining <= 0:
+		log.Printf("⚠️  Signing key %s of %s expired at %s; %s", identity.KeyID(), identity.ServiceID, expiry.Format(time.RFC3339), action)
+	case remaining <= k.ExpiryWarning:
+		log.Printf("⏰ Signing key %s of %s expires in %s, at %s; %s",
+			identity.KeyID(), identity.ServiceID, remaining.Round(time.Minute), expiry.Format(time.RFC3339), action)
+	}
+}
+
+// RotationDue reports whether keys.auto_rotate is set and identity's signing
+// key is within keys.expiry_warning of expiring, or past it. Services then
+// rotate it before they start serving.
+func (k KeysConfig) RotationDue(identity *mesh.Identity) bool {
+	if !k.AutoRotate || k.Mode != KeysModeFile || k.Shares != "" {
+		return false
+	}
+	expiry, err := k.keyExpiry(identity)
+	return err == nil && !expiry.IsZero() && time.Until(expiry) <= k.ExpiryWarning
+}
+
+type CryptoConfig struct {
+	Signature     string        `yaml:"signature" env:"SIGNATURE_ALGORITHM" usage:"signature algorithm"`
+	KEM           string        `yaml:"kem" env:"KEM_ALGORITHM" usage:"key encapsulation mechanism"`
+	SignatureMode string        `yaml:"signature_mode" env:"SIGNATURE_MODE" usage:"request signing: envelope or detached"`
+	SessionTTL    time.Duration `yaml:"session_ttl" env:"SESSION_TICKET_TTL" usage:"how long session tickets the auth service brokers stay valid"`
+
+	JWSAlgorithms string `yaml:"jws_algorithms" env:"JWS_ALGORITHMS" usage:"comma-separated signature algorithms JWS and chain links may use; empty allows every supported one"`
+	JWSAliases    string `yaml:"jws_aliases" env:"JWS_ALG_ALIASES" usage:"comma-separated name=algorithm pairs accepting other libraries' JWS alg names"`
+
+	VerifyCacheSize int           `yaml:"verify_cache_size" env:"VERIFY_CACHE_SIZE" usage:"signature verification outcomes remembered by key, payload and signature hash, so identical signed payloads are verified once; 0 turns the cache off"`
+	VerifyCacheTTL  time.Duration `yaml:"verify_cache_ttl" env:"VERIFY_CACHE_TTL" usage:"how long a remembered verification outcome is used"`
+	VerifyStrict    bool          `yaml:"verify_strict" env:"VERIFY_STRICT" usage:"verify every signature in full, never from the verification cache"`
+}
+
+// Apply sets the JWS algorithm policy and aliases and the verification
+// cache in pqc.
+func (c CryptoConfig) Apply() {
+	allowed, aliases, _ := c.jwsPolicy()
+	pqc.AllowedJWSAlgorithms = allowed
+	for name, algorithm := range aliases {
+		pqc.JWSAliases[name] = algorithm
+	}
+
+	if c.VerifyStrict {
+		pqc.SetVerificationCache(0, 0)
+		log.Println("🔒 Strict verification: every signature is verified in full")
+		return
+	}
+	pqc.SetVerificationCache(c.VerifyCacheSize, c.VerifyCacheTTL)
+}
+
+// jwsPolicy parses crypto.jws_algorithms and crypto.jws_aliases, which
+// name algorithms as keys or JWS headers do.
+func (c CryptoConfig) jwsPolicy() ([]string, map[string]string, error) {
+	var allowed []string
+	for _, name := range splitList(c.JWSAlgorithms) {
+		algorithm, err := pqc.SignatureAlgorithm(name)
+		if err != nil {
+			return nil, nil, fmt.Errorf("crypto.jws_algorithms: %w", err)
+		}
+		allowed = append(allowed, algorithm)
+	}
+
+	aliases := make(map[string]string)
+	for _, pair := range splitList(c.JWSAliases) {
+		name, alg, found := strings.Cut(pair, "=")
+		if !found || name == "" {
+			return nil, nil, fmt.Errorf("crypto.jws_aliases: expected name=algorithm, got %q", pair)
+		}
+		algorithm, err := pqc.SignatureAlgorithm(alg)
+		if err != nil {
+			return nil, nil, fmt.Errorf("crypto.jws_aliases: %w", err)
+		}
+		if known, err := pqc.JWSAlgorithm(name); err == nil && known != algorithm {
+			return nil, nil, fmt.Errorf("crypto.jws_aliases: %s already names %s", name, known)
+		}
+		aliases[name] = algorithm
+	}
+	return allowed, aliases, nil
+}
+
+type TimeoutsConfig struct {
+	Client     time.Duration `yaml:"client" env:"CLIENT_TIMEOUT" usage:"outbound HTTP request timeout"`
+	ReadHeader time.Duration `yaml:"read_header" env:"READ_HEADER_TIMEOUT" usage:"inbound request header timeout"`
+	KeyLookup  time.Duration `yaml:"key_lookup" env:"KEY_LOOKUP_TIMEOUT" usage:"how long fetching a peer's key from the auth service may hold up the requests waiting on it"`
+	Request    time.Duration `yaml:"request" env:"REQUEST_TIMEOUT" usage:"deadline for answering an inbound request, passed on to its key lookups and upstream calls; 0 sets none"`
+
+	RequestMaxAge time.Duration `yaml:"request_max_age" env:"REQUEST_MAX_AGE" reload:"true" usage:"how old a signed request's timestamp may be"`
+	ClockSkew     time.Duration `yaml:"clock_skew" env:"CLOCK_SKEW" reload:"true" usage:"how far ahead of this service's clock a signed request's timestamp may be"`
+}
+
+type DiscoveryConfig struct {
+	Mode        string        `yaml:"mode" env:"DISCOVERY_MODE" usage:"comma-separated discovery sources"`
+	TTL         time.Duration `yaml:"ttl" env:"DISCOVERY_TTL" usage:"resolved address cache TTL"`
+	DNSDomain   string        `yaml:"dns_domain" env:"DISCOVERY_DNS_DOMAIN" usage:"SRV lookup domain"`
+	DNSService  string        `yaml:"dns_service" env:"DISCOVERY_DNS_SERVICE" usage:"SRV service name"`
+	ConsulAddr  string        `yaml:"consul_addr" env:"CONSUL_HTTP_ADDR" usage:"Consul HTTP API address"`
+	ConsulToken string        `yaml:"consul_token" env:"CONSUL_HTTP_TOKEN" usage:"Consul ACL token" secret:"true"`
+}
+
+type MessagingConfig struct {
+	BusURL string `yaml:"bus_url" env:"MESSAGE_BUS_URL" usage:"NATS or Kafka URL for signed messaging" secret:"true"`
+
+	EventsSink    string `yaml:"events_sink" env:"CLOUDEVENTS_SINK" usage:"http(s), NATS or Kafka URL the auth service sends signed CloudEvents for registry changes to"`
+	EventsSubject string `yaml:"events_subject" env:"CLOUDEVENTS_SUBJECT" usage:"subject or Kafka topic CloudEvents are published on when the sink is a message bus"`
+}
+
+type PolicyConfig struct {
+	SPIFFEMode   string `yaml:"spiffe_mode" env:"SPIFFE_MODE" usage:"registration SVID policy: off, optional or required"`
+	SPIFFESocket string `yaml:"spiffe_socket" env:"SPIFFE_ENDPOINT_SOCKET" usage:"SPIRE agent Workload API socket"`
+	SPIFFEIDMap  string `yaml:"spiffe_id_map" env:"SPIFFE_ID_MAP" usage:"comma-separated spiffe-id=service-id pairs"`
+
+	KubeSAMode      string `yaml:"k8s_sa_mode" env:"K8S_SA_MODE" usage:"registration Kubernetes ServiceAccount token policy: off, optional or required"`
+	KubeSAIssuer    string `yaml:"k8s_sa_issuer" env:"K8S_SA_ISSUER" usage:"OIDC issuer to verify ServiceAccount tokens against; the cluster's TokenReview API when empty"`
+	KubeSAAPIURL    string `yaml:"k8s_sa_api_url" env:"K8S_SA_API_URL" usage:"Kubernetes API URL for TokenReview, e.g. a kubectl proxy; in-cluster config when empty"`
+	KubeSAMap       string `yaml:"k8s_sa_map" env:"K8S_SA_MAP" usage:"comma-separated system:serviceaccount:namespace:name=service-id pairs"`
+	KubeSATokenFile string `yaml:"k8s_sa_token_file" env:"K8S_SA_TOKEN_FILE" usage:"projected ServiceAccount token, with audience quantum-safe-mesh/auth-service, presented when registering"`
+
+	CloudAttestMode          string `yaml:"cloud_attest_mode" env:"CLOUD_ATTEST_MODE" usage:"registration cloud instance identity policy: off, optional or required"`
+	CloudAttestPolicy        string `yaml:"cloud_attest_policy" env:"CLOUD_ATTEST_POLICY" usage:"comma-separated instance-pattern=service-id pairs, e.g. aws:123456789012:*:*=batch-worker"`
+	CloudAttestAWSCerts      string `yaml:"cloud_attest_aws_certs" env:"CLOUD_ATTEST_AWS_CERTS" usage:"PEM file of the AWS regional certificates instance identity documents are signed with"`
+	CloudAttestAzureTenant   string `yaml:"cloud_attest_azure_tenant" env:"CLOUD_ATTEST_AZURE_TENANT" usage:"Azure AD tenant ID issuing managed identity tokens"`
+	CloudAttestAzureResource string `yaml:"cloud_attest_azure_resource" env:"CLOUD_ATTEST_AZURE_RESOURCE" usage:"resource (audience) Azure managed identity tokens are requested for"`
+	CloudAttestProvider      string `yaml:"cloud_attest_provider" env:"CLOUD_ATTEST_PROVIDER" usage:"cloud whose instance identity is presented when registering: aws, gcp or azure"`
+
+	BootstrapMode    string `yaml:"bootstrap_mode" env:"BOOTSTRAP_MODE" usage:"registration bootstrap token policy: off, optional or required"`
+	BootstrapIssuers string `yaml:"bootstrap_issuers" env:"BOOTSTRAP_ISSUERS" usage:"comma-separated service IDs trusted to issue bootstrap tokens"`
+	BootstrapToken   string `yaml:"bootstrap_token" env:"MESH_BOOTSTRAP_TOKEN" usage:"bootstrap token presented when registering" secret:"true"`
+
+	AdminServices string `yaml:"admin_services" env:"ADMIN_SERVICES" usage:"comma-separated service IDs allowed to rotate and revoke other services' keys"`
+
+	PinFile string `yaml:"pin_file" env:"KEY_PIN_FILE" usage:"file pinning the first key seen for each peer; a later key is refused unless rotation records signed by the pinned key lead to it"`
+
+	Delegators      string `yaml:"delegators" env:"DELEGATORS" usage:"comma-separated service IDs trusted to register other services' keys on their behalf"`
+	DelegationScope string `yaml:"delegation_scope" env:"DELEGATION_SCOPE" usage:"pattern of workload service IDs the gateway registers on their behalf at /workloads/register; empty disables it"`
+	DelegationFile  string `yaml:"delegation_file" env:"DELEGATION_FILE" usage:"delegation chain letting this service register workloads, if it is not a trusted delegator itself"`
+
+	NamespaceAdmins string `yaml:"namespace_admins" env:"NAMESPACE_ADMINS" usage:"comma-separated namespace=service-id pairs allowed to rotate and revoke keys, issue bootstrap tokens and delegate within the namespace"`
+	NamespaceRoutes string `yaml:"namespace_routes" env:"NAMESPACE_ROUTES" usage:"comma-separated /path-prefix=namespace pairs; only callers in the namespace, or one it trusts, may call routes under the prefix"`
+	NamespaceTrust  string `yaml:"namespace_trust" env:"NAMESPACE_TRUST" usage:"comma-separated namespace=trusted-namespace pairs letting callers in the second namespace call the first one's routes"`
+	PublicPaths     string `yaml:"public_paths" env:"GATEWAY_PUBLIC_PATHS" usage:"comma-separated /path or /prefix/* routes the gateway forwards to anyone, unsigned and without its identity"`
+}
+
+// ClusterConfig runs the auth service as one of several instances sharing
+// its key, which replicate the registry from an elected leader.
+type ClusterConfig struct {
+	Peers     string        `yaml:"peers" env:"AUTH_CLUSTER_PEERS" usage:"comma-separated base URLs of the other auth instances; empty runs a single instance"`
+	Heartbeat time.Duration `yaml:"heartbeat" env:"AUTH_CLUSTER_HEARTBEAT" usage:"interval between polls of the other auth instances; one missing three polls is down"`
+}
+
+// SnapshotConfig covers signed registry snapshots: the auth service issues
+// them on /registry/snapshot, and other services fall back to one saved to
+// a file while the auth service is unreachable.
+type SnapshotConfig struct {
+	TTL    time.Duration `yaml:"ttl" env:"REGISTRY_SNAPSHOT_TTL" usage:"how long registry snapshots the auth service signs stay valid"`
+	File   string        `yaml:"file" env:"REGISTRY_SNAPSHOT_FILE" usage:"signed registry snapshot to look keys up in while the auth service is unreachable"`
+	Signer string        `yaml:"signer" env:"REGISTRY_SNAPSHOT_SIGNER" usage:"fingerprint of the auth service key registry snapshots must be signed with"`
+}
+
+// OperatorConfig configures the Kubernetes operator.
+type OperatorConfig struct {
+	KubeAPIURL   string        `yaml:"kube_api_url" env:"KUBE_API_URL" usage:"Kubernetes API URL, e.g. a kubectl proxy; in-cluster config when empty"`
+	Namespace    string        `yaml:"namespace" env:"WATCH_NAMESPACE" usage:"namespace to watch; all namespaces when empty"`
+	SidecarImage string        `yaml:"sidecar_image" env:"SIDECAR_IMAGE" usage:"image injected as the mesh sidecar"`
+	Resync       time.Duration `yaml:"resync" env:"OPERATOR_RESYNC" usage:"interval between full relists"`
+	TokenTTL     time.Duration `yaml:"token_ttl" env:"BOOTSTRAP_TOKEN_TTL" usage:"lifetime of issued bootstrap tokens"`
+}
+
+// AgentConfig configures the mesh-agent.
+type AgentConfig struct {
+	Services    string `yaml:"services" env:"AGENT_SERVICES" usage:"comma-separated service IDs whose keys the agent holds"`
+	AllowedUIDs string `yaml:"allowed_uids" env:"AGENT_ALLOWED_UIDS" usage:"comma-separated UIDs, or service=UID pairs, allowed to use the keys; only the agent's own UID when empty"`
+}
+
+// QuotaConfig configures the gateway's per-client quotas.
+type QuotaConfig struct {
+	Store          string `yaml:"store" env:"QUOTA_STORE" usage:"where quota counters live: redis://host:6379/0, bolt:///path/to/quota.db or memory" secret:"true"`
+	RequestsPerDay int    `yaml:"requests_per_day" env:"QUOTA_REQUESTS_PER_DAY" usage:"requests each client may make per UTC day; 0 for no limit"`
+	BytesPerMonth  int    `yaml:"bytes_per_month" env:"QUOTA_BYTES_PER_MONTH" usage:"request and response bytes each client may move per UTC month; 0 for no limit"`
+}
+
+// GraphQLConfig limits a GraphQL endpoint to persisted queries, at the
+// gateway and at the sidecar in front of the GraphQL server.
+type GraphQLConfig struct {
+	Path    string `yaml:"path" env:"GRAPHQL_PATH" usage:"path of the GraphQL endpoint limited to persisted queries; empty for none"`
+	Queries string `yaml:"queries" env:"GRAPHQL_QUERIES" usage:"JSON file of allowed persisted queries: a hash-to-query object or an Apollo persisted query manifest"`
+}
+
+// LedgerConfig configures the backend's processing ledger.
+type LedgerConfig struct {
+	Path string `yaml:"path" env:"LEDGER_PATH" usage:"SQLite database the backend keeps signed, hash-chained records of the requests it processes in; empty for none"`
+}
+
+// ReplayConfig configures where a service remembers the requests it
+// accepted, to refuse replays.
+type ReplayConfig struct {
+	Store string `yaml:"store" env:"REPLAY_STORE" usage:"where the signatures of accepted requests are remembered: redis://host:6379/0 to share them among a service's replicas, or memory" secret:"true"`
+}
+
+// IdempotencyConfig configures where the backend keeps the results of
+// requests with idempotency keys.
+type IdempotencyConfig struct {
+	Store string        `yaml:"store" env:"IDEMPOTENCY_STORE" usage:"where results of requests with idempotency keys are kept: redis://host:6379/0 to share them among replicas, or memory" secret:"true"`
+	TTL   time.Duration `yaml:"ttl" env:"IDEMPOTENCY_TTL" usage:"how long a request's result is kept for retries with its idempotency key"`
+}
+
+// RateLimitConfig configures the backend's per-caller rate limits and the
+// gateway's per-client ones.
+type RateLimitConfig struct {
+	Limits  string `yaml:"limits" env:"BACKEND_RATE_LIMITS" reload:"true" usage:"comma-separated /path-prefix=requests/period pairs, e.g. /process=10/s, limiting how often each verified caller may call the route"`
+	Gateway string `yaml:"gateway" env:"GATEWAY_RATE_LIMITS" reload:"true" usage:"comma-separated /path-prefix=requests/period pairs limiting how often each client may call the gateway's routes; clients are told apart by TLS client certificate, or else by IP address"`
+}
+
+// AdmissionConfig configures how many signature verifications a service
+// runs at once, and how many wait, before it sheds requests.
+type AdmissionConfig struct {
+	Enabled        bool          `yaml:"enabled" env:"ADMISSION_CONTROL" usage:"bound concurrent signature verifications, sizing the bound from GOMAXPROCS and observed verify latency, and shed requests with 503 overloaded when too many wait"`
+	MaxConcurrency int           `yaml:"max_concurrency" env:"ADMISSION_MAX_CONCURRENCY" usage:"most signature verifications run at once, however low verify latency is; 0 means four per GOMAXPROCS"`
+	Queue          int           `yaml:"queue" env:"ADMISSION_QUEUE" usage:"signature verifications that may wait for a slot; requests beyond it are shed"`
+	QueueTimeout   time.Duration `yaml:"queue_timeout" env:"ADMISSION_QUEUE_TIMEOUT" usage:"longest a signature verification waits for a slot before its request is shed"`
+}
+
+// Controller returns the service's admission controller, reported in
+// metrics, or nil if admission control is off.
+func (c AdmissionConfig) Controller(metrics *mesh.Metrics) *mesh.Admission {
+	if !c.Enabled {
+		return nil
+	}
+	log.Printf("🚦 Admission control: up to %d signature verifications queued for %s", c.Queue, c.QueueTimeout)
+	return mesh.NewAdmission(mesh.AdmissionPolicy{
+		MaxConcurrency: c.MaxConcurrency,
+		Queue:          c.Queue,
+		QueueTimeout:   c.QueueTimeout,
+	}, metrics)
+}
+
+// PipelineConfig configures the backend's processing pipelines.
+type PipelineConfig struct {
+	Routes string `yaml:"routes" env:"BACKEND_PIPELINES" usage:"comma-separated /path=processor|processor pairs serving each path by running registered processors in order; must include /process"`
+}
+
+// LogConfig configures a service's log output.
+type LogConfig struct {
+	Format string `yaml:"format" env:"LOG_FORMAT" usage:"log output format: text or json"`
+	Level  string `yaml:"level" env:"LOG_LEVEL" reload:"true" usage:"lowest level logged: debug, info, warn or error"`
+}
+
+// Apply sets up the process's logging for serviceID.
+func (l LogConfig) Apply(serviceID string) error {
+	return meshlog.Setup(meshlog.Options{Format: l.Format, Level: l.Level, Service: serviceID})
+}
+
+// AuditConfig configures where a service's audit events are sent, beyond
+// its /audit endpoint.
+type AuditConfig struct {
+	Sinks string `yaml:"sinks" env:"AUDIT_SINKS" usage:"comma-separated syslog URLs audit events are forwarded to, e.g. syslog+tls://siem:6514?format=cef; schemes syslog (UDP), syslog+tcp, syslog+tls and syslog+unix, formats rfc5424 (default) and cef"`
+}
+
+// Apply forwards every event audit records to each configured sink.
+func (a AuditConfig) Apply(audit *mesh.AuditLog) error {
+	for _, sink := range auditsink.ParseSinks(a.Sinks) {
+		if _, err := auditsink.Forward(audit, sink); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// TelemetryConfig configures OpenTelemetry export.
+type TelemetryConfig struct {
+	Endpoint string `yaml:"endpoint" env:"OTEL_EXPORTER_OTLP_ENDPOINT" usage:"OTLP/HTTP collector URL for traces and metrics, e.g. http://localhost:4318; empty disables export"`
+}
+
+// Apply starts exporting serviceID's traces and metrics, if an endpoint is
+// set.
+func (t TelemetryConfig) Apply(serviceID string) error {
+	_, err := telemetry.Setup(context.Background(), telemetry.Options{Endpoint: t.Endpoint, Service: serviceID, Version: buildinfo.Version})
+	return err
+}
+
+// DebugConfig configures the runtime diagnostics listener.
+type DebugConfig struct {
+	Addr string `yaml:"addr" env:"DEBUG_ADDR" usage:"localhost address serving pprof at /debug/pprof/ and expvar at /debug/vars, e.g. localhost:6060; empty disables"`
+
+	Passthrough bool `yaml:"passthrough" env:"BENCHMARK_PASSTHROUGH" usage:"serve an unsigned echo at /passthrough/echo, from the backend through the gateway, as the baseline 'demo benchmark overhead' measures the mesh against; never in production"`
+}
+
+// Apply starts the diagnostics listener, if an address is set.
+func (d DebugConfig) Apply() error {
+	if d.Addr == "" {
+		return nil
+	}
+	return diagnostics.Start(d.Addr)
+}
+
+// ReloadConfig configures how a Reloader notices configuration changes.
+type ReloadConfig struct {
+	Interval time.Duration `yaml:"interval" env:"CONFIG_RELOAD_INTERVAL" usage:"how often the config file is checked for changed settings; 0 reloads only on SIGHUP"`
+}
+
+// SecretsConfig configures where secret settings given as references are
+// fetched from.
+type SecretsConfig struct {
+	CacheTTL      time.Duration `yaml:"cache_ttl" env:"SECRETS_CACHE_TTL" usage:"how long fetched secrets are cached before being fetched again to pick up rotations"`
+	ConjurURL     string        `yaml:"conjur_url" env:"CONJUR_APPLIANCE_URL" usage:"Conjur server that conjur: references are read from"`
+	ConjurAccount string        `yaml:"conjur_account" env:"CONJUR_ACCOUNT" usage:"Conjur account holding the variables"`
+	ConjurLogin   string        `yaml:"conjur_login" env:"CONJUR_AUTHN_LOGIN" usage:"Conjur host or user to authenticate as, e.g. host/mesh/backend-service"`
+	ConjurAPIKey  string        `yaml:"conjur_api_key" env:"CONJUR_AUTHN_API_KEY" usage:"API key of secrets.conjur_login" secret:"true"`
+	VaultAddr     string        `yaml:"vault_addr" env:"VAULT_ADDR" usage:"Vault server that vault: references are read from"`
+	VaultToken    string        `yaml:"vault_token" env:"VAULT_TOKEN" usage:"Vault token reading the secrets" secret:"true"`
+}
+
+// secretStore resolves secret references for every load, so a Reloader
+// fetches them again only once secrets.cache_ttl has passed.
+var secretStore = secrets.NewStore(secrets.DefaultTTL)
+
+// secretServers is the Conjur and Vault settings secretStore's providers
+// were registered with, so reloads keep their cached secrets and tokens.
+var secretServers SecretsConfig
+
+// resolveSecrets replaces secret settings holding references with the
+// secrets they refer to. Conjur and Vault credentials may themselves be
+// env: or file: references.
+func (c *Config) resolveSecrets() error {
+	secretStore.SetTTL(c.Secrets.CacheTTL)
+
+	resolve := func(f field) error {
+		value := f.value.String()
+		if !f.secret || !secretStore.IsReference(value) {
+			return nil
+		}
+		secret, err := secretStore.Resolve(context.Background(), value)
+		if err != nil {
+			return fmt.Errorf("invalid %s: %w", f.path, err)
+		}
+		f.value.SetString(secret)
+		c.references = true
+		return nil
+	}
+
+	fields := c.fields()
+	for _, f := range fields {
+		if strings.HasPrefix(f.path, "secrets.") {
+			if err := resolve(f); err != nil {
+				return err
+			}
+		}
+	}
+
+	s := c.Secrets
+	s.CacheTTL = 0
+	if s != secretServers {
+		if s.ConjurURL != "" && s.ConjurAccount != "" && s.ConjurLogin != "" && s.ConjurAPIKey != "" {
+			secretStore.Register(secrets.SchemeConjur, secrets.NewConjur(s.ConjurURL, s.ConjurAccount, s.ConjurLogin, s.ConjurAPIKey))
+		}
+		if s.VaultAddr != "" && s.VaultToken != "" {
+			secretStore.Register(secrets.SchemeVault, secrets.NewVault(s.VaultAddr, s.VaultToken))
+		}
+		secretServers = s
+	}
+
+	var errs []error
+	for _, f := range fields {
+		if !strings.HasPrefix(f.path, "secrets.") {
+			if err := resolve(f); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Defaults returns the built-in configuration for service.
+func Defaults(service string) *Config {
+	cfg := &Config{
+		Auth:     AuthConfig{URL: "http://localhost:8080"},
+		Upstream: UpstreamConfig{ID: "backend-service", URL: "http://localhost:8082", WASMMemoryLimit: wasmfilter.DefaultMemoryLimitMiB, WASMTimeout: wasmfilter.DefaultTimeout, CacheMaxEntries: mesh.DefaultCacheMaxEntries, Affinity: mesh.AffinityOff, AffinityHeader: mesh.DefaultAffinityHeader, OutlierConsecutiveFailures: mesh.DefaultOutlierConsecutiveFailures, OutlierErrorPercent: mesh.DefaultOutlierErrorPercent, OutlierMinRequests: mesh.DefaultOutlierMinRequests, OutlierCooldown: mesh.DefaultOutlierCooldown, Retries: httpclient.DefaultRetries},
+		App:      AppConfig{URL: "http://localhost:3000"},
+		TLS:      TLSConfig{ClientAuth: TLSClientAuthNone, MinVersion: "1.2"},
+		Keys:     KeysConfig{Mode: KeysModeFile, Store: pqc.KeyStoreFile, AgentSocket: "mesh-agent.sock", Dir: "keys", Format: pqc.KeyFormatRaw, ExpiryWarning: 7 * 24 * time.Hour},
+		Crypto: CryptoConfig{
+			Signature:     SignatureDilithium3,
+			KEM:           KEMKyber768,
+			SignatureMode: mesh.SignatureModeEnvelope,
+			SessionTTL:    time.Hour,
+
+			VerifyCacheSize: pqc.DefaultVerificationCacheSize,
+			VerifyCacheTTL:  pqc.DefaultVerificationCacheTTL,
+		},
+		Timeouts: TimeoutsConfig{
+			Client:        30 * time.Second,
+			ReadHeader:    10 * time.Second,
+			KeyLookup:     mesh.DefaultKeyLookupTimeout,
+			RequestMaxAge: mesh.DefaultMaxRequestAge,
+			ClockSkew:     mesh.DefaultClockSkew,
+		},
+		Discovery: DiscoveryConfig{
+			Mode:       discovery.ModeStatic,
+			TTL:        discovery.DefaultTTL,
+			ConsulAddr: "http://localhost:8500",
+		},
+		Policy:      PolicyConfig{SPIFFEMode: spiffe.ModeOff, KubeSAMode: kubeauth.ModeOff, CloudAttestMode: cloudattest.ModeOff, BootstrapMode: mesh.BootstrapOff},
+		Messaging:   MessagingConfig{EventsSubject: cloudevents.DefaultSubject},
+		Cluster:     ClusterConfig{Heartbeat: time.Second},
+		Snapshot:    SnapshotConfig{TTL: 24 * time.Hour},
+		Quota:       QuotaConfig{Store: "memory"},
+		Replay:      ReplayConfig{Store: "memory"},
+		Idempotency: IdempotencyConfig{Store: "memory", TTL: mesh.DefaultIdempotencyTTL},
+		Admission:   AdmissionConfig{Enabled: true, Queue: mesh.DefaultAdmissionQueue, QueueTimeout: mesh.DefaultAdmissionQueueTimeout},
+		Pipeline:    PipelineConfig{Routes: "/process=data-transformation|pqc-metadata"},
+		Log:         LogConfig{Format: meshlog.FormatText, Level: "info"},
+		Reload:      ReloadConfig{Interval: 10 * time.Second},
+		Secrets:     SecretsConfig{CacheTTL: secrets.DefaultTTL},
+		Operator: OperatorConfig{
+			SidecarImage: "quantum-safe-mesh/sidecar:latest",
+			Resync:       time.Minute,
+			TokenTTL:     24 * time.Hour,
+		},
+	}
+
+	switch service {
+	case ServiceAuth:
+		cfg.Service = ServiceConfig{ID: mesh.AuthServiceID, ListenAddr: ":8080"}
+	case ServiceGateway:
+		cfg.Service = ServiceConfig{ID: "api-gateway", ListenAddr: ":8081"}
+	case ServiceBackend:
+		cfg.Service = ServiceConfig{ID: "backend-service", ListenAddr: ":8082"}
+	case ServiceSidecar:
+		cfg.Service = ServiceConfig{ListenAddr: ":8083"}
+	case ServiceOperator:
+		cfg.Service = ServiceConfig{ID: "mesh-operator", ListenAddr: ":8084"}
+	case ServiceAgent:
+		cfg.Service = ServiceConfig{ID: "mesh-agent"}
+	}
+	return cfg
+}
+
+// Load builds service's configuration from its defaults, then the YAML file
+// named by -config or CONFIG_FILE, then environment variables, then flags,
+// and validates the result. Callers may define their own flags on flags
+// before calling Load.
+func Load(service string, flags *flag.FlagSet, args []string) (*Config, error) {
+	file := defineFlags(Defaults(service), flags)
+	if err := flags.Parse(args); err != nil {
+		return nil, err
+	}
+	return load(service, *file, flags, false)
+}
+
+// LoadAll builds the configuration of each of services from one file,
+// environment and set of flags, as Load does for one, for a process running
+// them all. The service section is the exception: each service keeps its
+// built-in ID and addresses, so the services run side by side on their
+// default ports.
+func LoadAll(services []string, flags *flag.FlagSet, args []string) ([]*Config, error) {
+	file := defineFlags(Defaults(""), flags)
+	if err := flags.Parse(args); err != nil {
+		return nil, err
+	}
+	configs := make([]*Config, len(services))
+	for i, service := range services {
+		cfg, err := load(service, *file, flags, true)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", service, err)
+		}
+		configs[i] = cfg
+	}
+	return configs, nil
+}
+
+// defineFlags defines -config and a flag for every setting, defaulting to
+// cfg's, on flags, and returns the -config value.
+func defineFlags(cfg *Config, flags *flag.FlagSet) *string {
+	file := flags.String("config", os.Getenv("CONFIG_FILE"), "YAML configuration file (env CONFIG_FILE)")
+	for _, f := range cfg.fields() {
+		flags.String(f.flagName(), f.String(), fmt.Sprintf("%s (env %s)", f.usage, f.env))
+	}
+	return file
+}
+
+// load builds service's configuration from its defaults, file, if set, the
+// environment and the flags set in flags, which Load has parsed. If shared,
+// the service section stays service's default.
+func load(service, file string, flags *flag.FlagSet, shared bool) (*Config, error) {
+	cfg := Defaults(service)
+	if err := cfg.override(file, flags); err != nil {
+		return nil, err
+	}
+	// A profile replaces the defaults, so the settings are applied again
+	// on top of it.
+	if cfg.Profile != "" {
+		profile := cfg.Profile
+		cfg = Defaults(service)
+		if err := cfg.applyProfile(service, profile); err != nil {
+			return nil, err
+		}
+		if err := cfg.override(file, flags); err != nil {
+			return nil, err
+		}
+	}
+
+	if shared {
+		defaults := Defaults(service)
+		if cfg.Profile != "" {
+			if err := defaults.applyProfile(service, cfg.Profile); err != nil {
+				return nil, err
+			}
+		}
+		cfg.Service = defaults.Service
+		cfg.shared = true
+	}
+
+	if err := cfg.resolveSecrets(); err != nil {
+		return nil, err
+	}
+	if err := cfg.Validate(service); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// override sets the settings given in file, if set, the environment and
+// the flags set in flags.
+func (c *Config) override(file string, flags *flag.FlagSet) error {
+	byFlag := make(map[string]field)
+	for _, f := range c.fields() {
+		byFlag[f.flagName()] = f
+	}
+
+	if file != "" {
+		if err := c.loadFile(file); err != nil {
+			return err
+		}
+	}
+
+	for _, f := range c.fields() {
+		if value := os.Getenv(f.env); value != "" {
+			if err := f.set(value); err != nil {
+				return fmt.Errorf("invalid %s: %w", f.env, err)
+			}
+		}
+	}
+
+	var errs []error
+	flags.Visit(func(fl *flag.Flag) {
+		if f, exists := byFlag[fl.Name]; exists {
+			if err := f.set(fl.Value.String()); err != nil {
+				errs = append(errs, fmt.Errorf("invalid -%s: %w", fl.Name, err))
+			}
+		}
+	})
+
+	// A SPHINCS+ name is an alias: the service signs with, names its key
+	// files after and registers the SLH-DSA parameter set it resolves to.
+	if slhdsa, ok := pqc.SLHDSAForSPHINCS(c.Crypto.Signature); ok {
+		c.Crypto.Signature = slhdsa
+	}
+	return errors.Join(errs...)
+}
+
+// applyProfile changes the defaults to those of profile.
+func (c *Config) applyProfile(service, profile string) error {
+	switch profile {
+	case ProfilePublic:
+		// Only the gateway faces clients; the services behind it keep
+		// to plain HTTP.
+		if service == ServiceGateway {
+			c.TLS.ClientAuth = TLSClientAuthRequire
+			c.TLS.MinVersion = "1.3"
+			c.Service.AdminAddr = "localhost:9081"
+		}
+		c.RateLimit.Gateway = "/=120/m"
+		c.Upstream.MaxBodySize = 64 << 10
+		c.Timeouts.ReadHeader = 5 * time.Second
+		c.Timeouts.Request = 30 * time.Second
+		// Signed requests are remembered for as long as they are
+		// accepted, so a short window leaves replays little time.
+		c.Timeouts.RequestMaxAge = time.Minute
+		c.Timeouts.ClockSkew = 30 * time.Second
+	default:
+		return fmt.Errorf("invalid profile %q: expected %q", profile, ProfilePublic)
+	}
+	c.Profile = profile
+	return nil
+}
+
+func (c *Config) loadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(c); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+
+	c.File = path
+	return nil
+}
+
+// Validate checks the settings service relies on.
+func (c *Config) Validate(service string) error {
+	var errs []error
+	check := func(err error) {
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if c.Service.ID == "" {
+		check(fmt.Errorf("service.id (SERVICE_ID) must be set to the mesh identity of the service"))
+	}
+	check(validateListenAddr("service.listen_addr", c.Service.ListenAddr, service != ServiceAgent))
+	check(validateListenAddr("service.channel_addr", c.Service.ChannelAddr, false))
+	check(validateListenAddr("service.admin_addr", c.Service.AdminAddr, false))
+	if c.Profile != "" && c.Profile != ProfilePublic {
+		check(fmt.Errorf("invalid profile %q: expected %q", c.Profile, ProfilePublic))
+	}
+	check(c.TLS.validate())
+	check(validateURL("service.advertise_url", c.Service.AdvertiseURL, false))
+	check(validateURL("auth.url", c.Auth.URL, service != ServiceAuth))
+	if scheme, _, _ := strings.Cut(c.Replay.Store, "://"); scheme != "memory" && scheme != "redis" && scheme != "rediss" {
+		check(fmt.Errorf("invalid replay.store %q: expected redis:// or memory", c.Replay.Store))
+	}
+	check(meshlog.ValidateFormat(c.Log.Format))
+	check(auditsink.Check(c.Audit.Sinks))
+	check(validateURL("telemetry.endpoint", c.Telemetry.Endpoint, false))
+	if c.Debug.Addr != "" {
+		check(diagnostics.CheckLoopback(c.Debug.Addr))
+	}
+	if _, err := meshlog.ParseLevel(c.Log.Level); err != nil {
+		check(err)
+	}
+
+	switch service {
+	case ServiceAuth:
+		if peers := c.ClusterPeers(); len(peers) > 0 {
+			if c.Service.AdvertiseURL == "" {
+				check(fmt.Errorf("service.advertise_url (ADVERTISE_URL) must be set to the URL other auth instances reach this one at when cluster.peers is set"))
+			}
+			for _, peer := range peers {
+				check(validateURL("cluster.peers", peer, true))
+				if peer == c.Service.AdvertiseURL {
+					check(fmt.Errorf("cluster.peers must list the other auth instances, not this one (%s)", peer))
+				}
+			}
+			if c.Cluster.Heartbeat <= 0 {
+				check(fmt.Errorf("cluster.heartbeat must be positive"))
+			}
+		}
+		if c.Snapshot.TTL <= 0 {
+			check(fmt.Errorf("snapshot.ttl must be positive"))
+		}
+		if c.Crypto.SessionTTL <= 0 {
+			check(fmt.Errorf("crypto.session_ttl must be positive"))
+		}
+		if sink := c.Messaging.EventsSink; sink != "" {
+			scheme, _, _ := strings.Cut(sink, "://")
+			switch scheme {
+			case "http", "https":
+				check(validateURL("messaging.events_sink", sink, true))
+			case "nats", "tls", "kafka":
+			default:
+				check(fmt.Errorf("messaging.events_sink must be an http(s), nats:// or kafka:// URL, got %q", sink))
+			}
+			if c.Messaging.EventsSubject == "" {
+				check(fmt.Errorf("messaging.events_subject must not be empty"))
+			}
+		}
+	case ServiceGateway:
+		if c.Upstream.ID == "" {
+			check(fmt.Errorf("upstream.id must not be empty"))
+		}
+		check(validateURL("upstream.url", c.Upstream.URL, true))
+		check(validateListenAddr("upstream.channel_addr", c.Upstream.ChannelAddr, false))
+		wasmModules, err := wasmfilter.ParseModules(c.Upstream.WASMFilters)
+		if err != nil {
+			check(fmt.Errorf("invalid upstream.wasm_filters: %w", err))
+		}
+		wasmNames := make([]string, 0, len(wasmModules))
+		for name, path := range wasmModules {
+			if _, err := os.Stat(path); err != nil {
+				check(fmt.Errorf("invalid upstream.wasm_filters: module %s: %w", name, err))
+			}
+			wasmNames = append(wasmNames, name)
+		}
+		if c.Upstream.WASMMemoryLimit < 1 || c.Upstream.WASMMemoryLimit > 4096 {
+			check(fmt.Errorf("upstream.wasm_memory_limit must be between 1 and 4096 MiB"))
+		}
+		if c.Upstream.WASMTimeout <= 0 {
+			check(fmt.Errorf("upstream.wasm_timeout must be positive"))
+		}
+		if err := mesh.CheckFilterRoutes(c.Upstream.Filters, wasmNames); err != nil {
+			check(fmt.Errorf("invalid upstream.filters: %w", err))
+		} else if c.Upstream.Filters != "" && c.Upstream.ChannelAddr != "" {
+			check(fmt.Errorf("upstream.filters cannot be used with upstream.channel_addr, which forwards without them"))
+		}
+		if _, err := mesh.ParseResponseCache(c.Upstream.Cache, c.Upstream.CacheMaxEntries); err != nil {
+			check(fmt.Errorf("invalid upstream.cache: %w", err))
+		} else if c.Upstream.Cache != "" && c.Upstream.ChannelAddr != "" {
+			check(fmt.Errorf("upstream.cache cannot be used with upstream.channel_addr, whose responses are unsigned"))
+		}
+		if c.Upstream.Coalesce && c.Upstream.ChannelAddr != "" {
+			check(fmt.Errorf("upstream.coalesce cannot be used with upstream.channel_addr, whose responses are unsigned"))
+		}
+		if _, err := mesh.ParseStreamPaths(c.Upstream.StreamPaths); err != nil {
+			check(fmt.Errorf("invalid upstream.stream_paths: %w", err))
+		} else if c.Upstream.StreamPaths != "" && c.Upstream.ChannelAddr != "" {
+			check(fmt.Errorf("upstream.stream_paths cannot be used with upstream.channel_addr, which carries whole messages"))
+		}
+		if c.Upstream.CacheMaxEntries < 1 {
+			check(fmt.Errorf("upstream.cache_max_entries must be positive"))
+		}
+		if versions, err := mesh.CheckAPIVersions(c.Upstream.Versions, c.Upstream.DefaultVersion, wasmNames); err != nil {
+			check(fmt.Errorf("invalid upstream.versions: %w", err))
+		} else if versions != nil && c.Upstream.ChannelAddr != "" {
+			check(fmt.Errorf("upstream.versions cannot be used with upstream.channel_addr, which only reaches upstream.id"))
+		} else if versions != nil && len(c.DiscoveryModes()) == 0 {
+			for _, version := range versions.All() {
+				if version.Upstream != c.Upstream.ID && version.URL == "" {
+					check(fmt.Errorf("invalid upstream.versions: %s needs service-id@url for %s without discovery", version.Name, version.Upstream))
+				}
+			}
+		}
+		for _, replica := range c.UpstreamReplicas() {
+			check(validateURL("upstream.replicas", replica, true))
+		}
+		if c.Upstream.Replicas != "" && c.Upstream.ChannelAddr != "" {
+			check(fmt.Errorf("upstream.replicas cannot be used with upstream.channel_addr"))
+		}
+		switch c.Upstream.Affinity {
+		case mesh.AffinityOff:
+		case mesh.AffinityClient, mesh.AffinitySession:
+			if len(c.UpstreamReplicas()) == 0 {
+				check(fmt.Errorf("upstream.affinity %q requires upstream.replicas", c.Upstream.Affinity))
+			}
+		default:
+			check(fmt.Errorf("invalid upstream.affinity %q: expected %q, %q or %q",
+				c.Upstream.Affinity, mesh.AffinityOff, mesh.AffinityClient, mesh.AffinitySession))
+		}
+		if c.Upstream.Affinity == mesh.AffinitySession && c.Upstream.AffinityHeader == "" {
+			check(fmt.Errorf("upstream.affinity_header must not be empty for session affinity"))
+		}
+		if c.Upstream.OutlierConsecutiveFailures < 0 || c.Upstream.OutlierMinRequests < 0 {
+			check(fmt.Errorf("upstream.outlier_consecutive_failures and upstream.outlier_min_requests must not be negative"))
+		}
+		if c.Upstream.OutlierErrorPercent < 0 || c.Upstream.OutlierErrorPercent > 100 {
+			check(fmt.Errorf("upstream.outlier_error_percent must be between 0 and 100"))
+		}
+		if c.Upstream.OutlierCooldown <= 0 {
+			check(fmt.Errorf("upstream.outlier_cooldown must be positive"))
+		}
+		if c.Upstream.Retries < 0 {
+			check(fmt.Errorf("upstream.retries must not be negative"))
+		}
+		check(c.validateGraphQL())
+		if c.GraphQL.Path != "" && c.Upstream.ChannelAddr != "" {
+			check(fmt.Errorf("graphql.path cannot be used with upstream.channel_addr"))
+		}
+		if c.Quota.RequestsPerDay < 0 || c.Quota.BytesPerMonth < 0 {
+			check(fmt.Errorf("quota.requests_per_day and quota.bytes_per_month must not be negative"))
+		}
+		if c.Quota.RequestsPerDay > 0 || c.Quota.BytesPerMonth > 0 {
+			scheme, _, _ := strings.Cut(c.Quota.Store, "://")
+			switch scheme {
+			case "memory", "redis", "rediss", "bolt":
+			default:
+				check(fmt.Errorf("invalid quota.store %q: expected redis://, bolt:// or memory", c.Quota.Store))
+			}
+		}
+		if _, err := mesh.ParseRateLimits(c.RateLimit.Gateway); err != nil {
+			check(fmt.Errorf("invalid rate_limit.gateway: %w", err))
+		}
+		if c.Upstream.MaxBodySize < 0 {
+			check(fmt.Errorf("upstream.max_body_size must not be negative"))
+		}
+		if c.Profile == ProfilePublic {
+			check(c.validatePublicGateway())
+		}
+	case ServiceBackend:
+		if scheme, _, _ := strings.Cut(c.Idempotency.Store, "://"); scheme != "memory" && scheme != "redis" && scheme != "rediss" {
+			check(fmt.Errorf("invalid idempotency.store %q: expected redis:// or memory", c.Idempotency.Store))
+		}
+		if c.Idempotency.TTL <= 0 {
+			check(fmt.Errorf("idempotency.ttl must be positive"))
+		}
+		if _, err := mesh.ParseRateLimits(c.RateLimit.Limits); err != nil {
+			check(fmt.Errorf("invalid rate_limit.limits: %w", err))
+		}
+		if routes, err := pipeline.ParseRoutes(c.Pipeline.Routes); err != nil {
+			check(fmt.Errorf("invalid pipeline.routes: %w", err))
+		} else if _, ok := routes.Pipeline("/process"); !ok {
+			check(fmt.Errorf("pipeline.routes must include /process, which message bus jobs run through"))
+		}
+	case ServiceSidecar:
+		check(validateURL("app.url", c.App.URL, true))
+		check(c.validateGraphQL())
+	case ServiceAgent:
+		if len(c.AgentServices()) == 0 {
+			check(fmt.Errorf("agent.services (AGENT_SERVICES) must name the services whose keys the agent holds"))
+		}
+		if _, err := agent.ParsePolicy(c.Agent.AllowedUIDs); err != nil {
+			check(fmt.Errorf("invalid agent.allowed_uids: %w", err))
+		}
+	case ServiceOperator:
+		check(validateURL("operator.kube_api_url", c.Operator.KubeAPIURL, false))
+		if c.Operator.SidecarImage == "" {
+			check(fmt.Errorf("operator.sidecar_image must not be empty"))
+		}
+		if c.Operator.Resync <= 0 {
+			check(fmt.Errorf("operator.resync must be positive"))
+		}
+		if c.Operator.TokenTTL <= 0 {
+			check(fmt.Errorf("operator.token_ttl must be positive"))
+		}
+	}
+
+	switch c.Keys.Mode {
+	case KeysModeFile, KeysModeEphemeral:
+	case KeysModeAgent:
+		if service == ServiceAgent {
+			check(fmt.Errorf("the mesh-agent loads keys itself: keys.mode %q does not apply to it", KeysModeAgent))
+		}
+		if c.Keys.AgentSocket == "" {
+			check(fmt.Errorf("keys.agent_socket must not be empty in agent mode"))
+		}
+	default:
+		check(fmt.Errorf("invalid keys.mode %q: expected %q, %q or %q", c.Keys.Mode, KeysModeFile, KeysModeEphemeral, KeysModeAgent))
+	}
+	if c.Keys.Mode == KeysModeEphemeral && c.Keys.AutoRotate {
+		check(fmt.Errorf("keys.auto_rotate does not apply to ephemeral keys, which are new on every start"))
+	}
+	if c.Keys.Shares != "" && (c.Keys.Mode != KeysModeFile || c.Keys.Generate || c.Keys.AutoRotate) {
+		check(fmt.Errorf("keys.shares needs keys.mode %q and rules out keys.generate and keys.auto_rotate, which would write the private key to disk", KeysModeFile))
+	}
+	if c.Keys.Mode == KeysModeAgent && c.Keys.AutoRotate {
+		check(fmt.Errorf("keys.auto_rotate does not apply to keys held by the mesh-agent; rotate them where the agent loads them"))
+	}
+	if c.Keys.Dir == "" {
+		check(fmt.Errorf("keys.dir must not be empty"))
+	}
+	if !slices.Contains(pqc.KeyStores, c.Keys.Store) {
+		check(fmt.Errorf("invalid keys.store %q: expected one of %s", c.Keys.Store, strings.Join(pqc.KeyStores, ", ")))
+	}
+	if c.Keys.Store == pqc.KeyStoreKeychain && c.Keys.Passphrase != "" {
+		check(fmt.Errorf("keys.passphrase cannot be set with keys.store=keychain, which keeps the passphrase in the OS credential store"))
+	}
+	if c.Keys.Keystore != "" && c.Keys.Mode == KeysModeFile && c.Keys.Passphrase == "" && c.Keys.Store != pqc.KeyStoreKeychain {
+		check(fmt.Errorf("keys.keystore is encrypted: set keys.passphrase or keys.store=keychain"))
+	}
+	if c.Keys.Keystore != "" && c.Keys.Shares != "" {
+		check(fmt.Errorf("keys.shares rebuild the signing key from key files in keys.dir and cannot be used with keys.keystore"))
+	}
+	if !slices.Contains(pqc.KeyFormats, c.Keys.Format) {
+		check(fmt.Errorf("invalid keys.format %q: expected one of %s", c.Keys.Format, strings.Join(pqc.KeyFormats, ", ")))
+	}
+	if c.Keys.MaxAge < 0 || c.Keys.ExpiryWarning < 0 {
+		check(fmt.Errorf("keys.max_age and keys.expiry_warning must not be negative"))
+	}
+	if c.Keys.AutoRotate && c.Keys.MaxAge == 0 {
+		check(fmt.Errorf("keys.auto_rotate needs keys.max_age (KEYS_MAX_AGE) to decide when keys are due"))
+	} else if c.Keys.AutoRotate && c.Keys.ExpiryWarning >= c.Keys.MaxAge {
+		check(fmt.Errorf("keys.expiry_warning (%s) must be shorter than keys.max_age (%s), or every restart rotates the key", c.Keys.ExpiryWarning, c.Keys.MaxAge))
+	}
+	signature := c.Crypto.Signature
+	if slhdsa, ok := pqc.SLHDSAForSPHINCS(signature); ok {
+		signature = slhdsa
+	}
+	if !slices.Contains(pqc.SignatureAlgorithms, signature) {
+		check(fmt.Errorf("unsupported crypto.signature %q: expected one of %s", c.Crypto.Signature, strings.Join(pqc.SignatureAlgorithms, ", ")))
+	}
+	if allowed, _, err := c.Crypto.jwsPolicy(); err != nil {
+		check(err)
+	} else if allowed != nil && !slices.Contains(allowed, signature) {
+		check(fmt.Errorf("crypto.jws_algorithms must include crypto.signature %q, or peers refuse this service's tokens", signature))
+	}
+	if c.Crypto.VerifyCacheSize < 0 {
+		check(fmt.Errorf("crypto.verify_cache_size must not be negative"))
+	}
+	if c.Crypto.VerifyCacheSize > 0 && !c.Crypto.VerifyStrict && c.Crypto.VerifyCacheTTL <= 0 {
+		check(fmt.Errorf("crypto.verify_cache_ttl must be positive, or set crypto.verify_cache_size to 0 to turn the cache off"))
+	}
+	if c.Admission.MaxConcurrency < 0 || c.Admission.Queue < 0 || c.Admission.QueueTimeout < 0 {
+		check(fmt.Errorf("admission.max_concurrency, admission.queue and admission.queue_timeout must not be negative"))
+	}
+	if !slices.Contains(pqc.KEMAlgorithms, c.Crypto.KEM) {
+		check(fmt.Errorf("unsupported crypto.kem %q: expected one of %s", c.Crypto.KEM, strings.Join(pqc.KEMAlgorithms, ", ")))
+	}
+	if c.Crypto.SignatureMode != mesh.SignatureModeEnvelope && c.Crypto.SignatureMode != mesh.SignatureModeDetached {
+		check(fmt.Errorf("invalid crypto.signature_mode %q: expected %q or %q",
+			c.Crypto.SignatureMode, mesh.SignatureModeEnvelope, mesh.SignatureModeDetached))
+	}
+
+	if c.Timeouts.KeyLookup <= 0 || c.Timeouts.Request < 0 {
+		check(fmt.Errorf("timeouts.key_lookup must be positive and timeouts.request must not be negative"))
+	}
+	if c.Timeouts.Client <= 0 {
+		check(fmt.Errorf("timeouts.client must be positive"))
+	}
+	if c.Timeouts.ReadHeader <= 0 {
+		check(fmt.Errorf("timeouts.read_header must be positive"))
+	}
+	if c.Timeouts.RequestMaxAge <= 0 || c.Timeouts.ClockSkew < 0 {
+		check(fmt.Errorf("timeouts.request_max_age must be positive and timeouts.clock_skew must not be negative"))
+	}
+	if c.Reload.Interval < 0 {
+		check(fmt.Errorf("reload.interval must not be negative"))
+	}
+
+	modes, err := discovery.ParseModes(c.Discovery.Mode)
+	if err != nil {
+		check(fmt.Errorf("invalid discovery.mode: %w", err))
+	}
+	if c.Discovery.TTL <= 0 {
+		check(fmt.Errorf("discovery.ttl must be positive"))
+	}
+	for _, mode := range modes {
+		switch mode {
+		case discovery.ModeDNS:
+			if c.Discovery.DNSDomain == "" {
+				check(fmt.Errorf("discovery.dns_domain is required for DNS discovery"))
+			}
+		case discovery.ModeConsul:
+			check(validateURL("discovery.consul_addr", c.Discovery.ConsulAddr, true))
+		}
+	}
+
+	if _, err := path.Match(c.Policy.DelegationScope, ""); err != nil {
+		check(fmt.Errorf("invalid policy.delegation_scope %q: %w", c.Policy.DelegationScope, err))
+	}
+	if _, err := mesh.ParseNamespaceAdmins(c.Policy.NamespaceAdmins); err != nil {
+		check(fmt.Errorf("invalid policy.namespace_admins: %w", err))
+	}
+	if _, err := mesh.ParseNamespacePolicy(c.Policy.NamespaceRoutes, c.Policy.NamespaceTrust); err != nil {
+		check(fmt.Errorf("invalid policy.namespace_routes: %w", err))
+	}
+	if _, err := mesh.ParsePublicPaths(c.Policy.PublicPaths); err != nil {
+		check(fmt.Errorf("invalid policy.public_paths: %w", err))
+	} else if c.Policy.PublicPaths != "" && c.Upstream.ChannelAddr != "" {
+		check(fmt.Errorf("policy.public_paths cannot be used with upstream.channel_addr, which only carries mesh requests"))
+	}
+	if c.Debug.Passthrough && c.Upstream.ChannelAddr != "" {
+		check(fmt.Errorf("debug.passthrough cannot be used with upstream.channel_addr, which only carries mesh requests"))
+	}
+	if c.Snapshot.File != "" && c.Snapshot.Signer == "" {
+		check(fmt.Errorf("snapshot.signer (REGISTRY_SNAPSHOT_SIGNER) must be set to the auth service's key fingerprint when snapshot.file is set"))
+	}
+
+	if !spiffe.ValidMode(c.Policy.SPIFFEMode) {
+		check(fmt.Errorf("invalid policy.spiffe_mode %q: expected %q, %q or %q",
+			c.Policy.SPIFFEMode, spiffe.ModeOff, spiffe.ModeOptional, spiffe.ModeRequired))
+	}
+	if _, err := spiffe.ParseIDMap(c.Policy.SPIFFEIDMap); err != nil {
+		check(fmt.Errorf("invalid policy.spiffe_id_map: %w", err))
+	}
+	if !kubeauth.ValidMode(c.Policy.KubeSAMode) {
+		check(fmt.Errorf("invalid policy.k8s_sa_mode %q: expected %q, %q or %q",
+			c.Policy.KubeSAMode, kubeauth.ModeOff, kubeauth.ModeOptional, kubeauth.ModeRequired))
+	}
+	if _, err := kubeauth.ParseIDMap(c.Policy.KubeSAMap); err != nil {
+		check(fmt.Errorf("invalid policy.k8s_sa_map: %w", err))
+	}
+	check(validateURL("policy.k8s_sa_issuer", c.Policy.KubeSAIssuer, false))
+	check(validateURL("policy.k8s_sa_api_url", c.Policy.KubeSAAPIURL, false))
+	if !cloudattest.ValidMode(c.Policy.CloudAttestMode) {
+		check(fmt.Errorf("invalid policy.cloud_attest_mode %q: expected %q, %q or %q",
+			c.Policy.CloudAttestMode, cloudattest.ModeOff, cloudattest.ModeOptional, cloudattest.ModeRequired))
+	}
+	if _, err := cloudattest.ParsePolicy(c.Policy.CloudAttestPolicy); err != nil {
+		check(fmt.Errorf("invalid policy.cloud_attest_policy: %w", err))
+	}
+	if c.Policy.CloudAttestAzureTenant != "" && c.Policy.CloudAttestAzureResource == "" {
+		check(fmt.Errorf("policy.cloud_attest_azure_resource (CLOUD_ATTEST_AZURE_RESOURCE) must be set when policy.cloud_attest_azure_tenant is set"))
+	}
+	if c.Policy.CloudAttestProvider != "" {
+		if !cloudattest.ValidProvider(c.Policy.CloudAttestProvider) {
+			check(fmt.Errorf("invalid policy.cloud_attest_provider %q: expected %q, %q or %q",
+				c.Policy.CloudAttestProvider, cloudattest.ProviderAWS, cloudattest.ProviderGCP, cloudattest.ProviderAzure))
+		}
+		if c.Policy.CloudAttestProvider == cloudattest.ProviderAzure && c.Policy.CloudAttestAzureResource == "" {
+			check(fmt.Errorf("policy.cloud_attest_azure_resource (CLOUD_ATTEST_AZURE_RESOURCE) must be set to attest on Azure"))
+		}
+	}
+	switch c.Policy.BootstrapMode {
+	case mesh.BootstrapOff, mesh.BootstrapOptional, mesh.BootstrapRequired:
+	default:
+		check(fmt.Errorf("invalid policy.bootstrap_mode %q: expected %q, %q or %q",
+			c.Policy.BootstrapMode, mesh.BootstrapOff, mesh.BootstrapOptional, mesh.BootstrapRequired))
+	}
+	check(c.validateSecrets())
+
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid configuration: %w", errors.Join(errs...))
+	}
+	return nil
+}
+
+// AgentServices returns the services whose keys the mesh-agent holds.
+func (c *Config) AgentServices() []string {
+	return splitList(c.Agent.Services)
+}
+
+// UpstreamReplicas returns the base URLs of the upstream's replicas.
+func (c *Config) UpstreamReplicas() []string {
+	return splitList(c.Upstream.Replicas)
+}
+
+// ClusterPeers returns the base URLs of the other auth instances.
+func (c *Config) ClusterPeers() []string {
+	return splitList(c.Cluster.Peers)
+}
+
+// splitList splits a comma-separated setting, dropping empty entries.
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// DiscoveryModes returns the parsed discovery sources.
+func (c *Config) DiscoveryModes() []string {
+	modes, _ := discovery.ParseModes(c.Discovery.Mode)
+	return modes
+}
+
+// Effective returns the configuration as nested maps keyed by YAML name,
+// with durations as strings and secrets redacted.
+func (c *Config) Effective() map[string]interface{} {
+	effective := map[string]interface{}{"config_file": c.File}
+	for _, f := range c.fields() {
+		section, name, found := strings.Cut(f.path, ".")
+		if !found {
+			effective[section] = f.value.Interface()
+			continue
+		}
+		values, exists := effective[section].(map[string]interface{})
+		if !exists {
+			values = make(map[string]interface{})
+			effective[section] = values
+		}
+
+		switch {
+		case f.secret && !f.value.IsZero():
+			values[name] = "[redacted]"
+		case f.value.Type() == durationType:
+			values[name] = f.String()
+		default:
+			values[name] = f.value.Interface()
+		}
+	}
+	return effective
+}
+
+// Handler serves the effective configuration as JSON.
+func (c *Config) Handler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		reloadMutex.RLock()
+		effective := c.Effective()
+		reloadMutex.RUnlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(effective)
+	}
+}
+
+// validateGraphQL checks a GraphQL endpoint has a clean path and a
+// persisted query list that loads.
+func (c *Config) validateGraphQL() error {
+	switch {
+	case c.GraphQL.Path == "" && c.GraphQL.Queries == "":
+		return nil
+	case !strings.HasPrefix(c.GraphQL.Path, "/") || path.Clean(c.GraphQL.Path) != c.GraphQL.Path:
+		return fmt.Errorf("invalid graphql.path %q: expected a clean /path", c.GraphQL.Path)
+	case c.GraphQL.Queries == "":
+		return fmt.Errorf("graphql.queries must name the persisted queries allowed on %s", c.GraphQL.Path)
+	}
+	if _, err := mesh.LoadPersistedQueries(c.GraphQL.Queries); err != nil {
+		return fmt.Errorf("invalid graphql.queries: %w", err)
+	}
+	return nil
+}
+
+// validateSecrets checks the Conjur and Vault settings are complete when
+// either is used.
+func (c *Config) validateSecrets() error {
+	s := c.Secrets
+	if s.CacheTTL < 0 {
+		return fmt.Errorf("secrets.cache_ttl must not be negative")
+	}
+	if s.ConjurURL != "" {
+		if err := validateURL("secrets.conjur_url", s.ConjurURL, true); err != nil {
+			return err
+		}
+		if s.ConjurAccount == "" || s.ConjurLogin == "" || s.ConjurAPIKey == "" {
+			return fmt.Errorf("secrets.conjur_account, secrets.conjur_login and secrets.conjur_api_key must be set to read from Conjur")
+		}
+	}
+	if s.VaultAddr != "" {
+		if err := validateURL("secrets.vault_addr", s.VaultAddr, true); err != nil {
+			return err
+		}
+		if s.VaultToken == "" {
+			return fmt.Errorf("secrets.vault_token (VAULT_TOKEN) must be set to read from Vault")
+		}
+	}
+	return nil
+}
+
+func validateURL(name, value string, required bool) error {
+	if value == "" {
+		if required {
+			return fmt.Errorf("%s must not be empty", name)
+		}
+		return nil
+	}
+
+	u, err := url.Parse(value)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("%s must be an absolute http(s) URL, got %q", name, value)
+	}
+	return nil
+}
+
+// validate checks the TLS settings are whole: a certificate with its key,
+// and a client CA to check client certificates against when they are asked
+// for.
+func (t TLSConfig) validate() error {
+	var errs []error
+	if (t.CertFile == "") != (t.KeyFile == "") {
+		errs = append(errs, fmt.Errorf("tls.cert_file and tls.key_file must be set together"))
+	}
+	switch t.ClientAuth {
+	case TLSClientAuthNone:
+	case TLSClientAuthRequest, TLSClientAuthRequire:
+		if t.CertFile == "" || t.ClientCAFile == "" {
+			errs = append(errs, fmt.Errorf("tls.client_auth %q needs tls.cert_file and tls.client_ca_file", t.ClientAuth))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("invalid tls.client_auth %q: expected %q, %q or %q",
+			t.ClientAuth, TLSClientAuthNone, TLSClientAuthRequest, TLSClientAuthRequire))
+	}
+	if t.MinVersion != "1.2" && t.MinVersion != "1.3" {
+		errs = append(errs, fmt.Errorf("invalid tls.min_version %q: expected 1.2 or 1.3", t.MinVersion))
+	}
+	return errors.Join(errs...)
+}
+
+// validatePublicGateway checks the gateway keeps to the public profile,
+// whatever settings override its defaults.
+func (c *Config) validatePublicGateway() error {
+	var errs []error
+	if c.TLS.CertFile == "" {
+		errs = append(errs, fmt.Errorf("profile public needs tls.cert_file (TLS_CERT_FILE) and tls.key_file: the gateway only serves HTTPS"))
+	}
+	if c.TLS.ClientAuth != TLSClientAuthRequire {
+		errs = append(errs, fmt.Errorf("profile public needs tls.client_auth %q, with tls.client_ca_file (TLS_CLIENT_CA_FILE)", TLSClientAuthRequire))
+	}
+	if c.Service.AdminAddr == c.Service.ListenAddr {
+		errs = append(errs, fmt.Errorf("profile public needs service.admin_addr (ADMIN_LISTEN_ADDR) apart from service.listen_addr, or empty to drop the admin endpoints"))
+	}
+	if c.Debug.Passthrough {
+		errs = append(errs, fmt.Errorf("debug.passthrough serves unsigned traffic and cannot be used with profile public"))
+	}
+	if c.RateLimit.Gateway == "" {
+		errs = append(errs, fmt.Errorf("profile public needs rate_limit.gateway (GATEWAY_RATE_LIMITS)"))
+	}
+	if c.Upstream.MaxBodySize <= 0 {
+		errs = append(errs, fmt.Errorf("profile public needs a positive upstream.max_body_size (GATEWAY_MAX_BODY_SIZE)"))
+	}
+	return errors.Join(errs...)
+}
+
+func validateListenAddr(name, value string, required bool) error {
+	if value == "" {
+		if required {
+			return fmt.Errorf("%s must not be empty", name)
+		}
+		return nil
+	}
+
+	_, port, err := net.SplitHostPort(value)
+	if err != nil {
+		return fmt.Errorf("%s must be host:port, got %q", name, value)
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 0 || n > 65535 {
+		return fmt.Errorf("%s has invalid port %q", name, port)
+	}
+	return nil
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// field is one leaf setting, addressed by its section.name YAML path, or
+// its name for settings outside any section.
+type field struct {
+	path   string
+	env    string
+	usage  string
+	secret bool
+	reload bool // applied by a Reloader while the service runs
+	value  reflect.Value
+}
+
+func (c *Config) fields() []field {
+	var fields []field
+
+	root := reflect.ValueOf(c).Elem()
+	for i := 0; i < root.NumField(); i++ {
+		section := root.Type().Field(i)
+		if section.Type.Kind() != reflect.Struct {
+			// Settings outside any section, like profile, go by their
+			// own name.
+			if env := section.Tag.Get("env"); env != "" {
+				fields = append(fields, field{
+					path:  section.Tag.Get("yaml"),
+					env:   env,
+					usage: section.Tag.Get("usage"),
+					value: root.Field(i),
+				})
+			}
+			continue
+		}
+
+		for j := 0; j < section.Type.NumField(); j++ {
+			leaf := section.Type.Field(j)
+			fields = append(fields, field{
+				path:   section.Tag.Get("yaml") + "." + leaf.Tag.Get("yaml"),
+				env:    leaf.Tag.Get("env"),
+				usage:  leaf.Tag.Get("usage"),
+				secret: leaf.Tag.Get("secret") == "true",
+				reload: leaf.Tag.Get("reload") == "true",
+				value:  root.Field(i).Field(j),
+			})
+		}
+	}
+	return fields
+}
+
+func (f field) flagName() string {
+	return strings.ReplaceAll(f.path, "_", "-")
+}
+
+func (f field) String() string {
+	switch {
+	case f.value.Type() == durationType:
+		return time.Duration(f.value.Int()).String()
+	case f.value.Kind() == reflect.Bool:
+		return strconv.FormatBool(f.value.Bool())
+	case f.value.Kind() == reflect.Int:
+		return strconv.FormatInt(f.value.Int(), 10)
+	}
+	return f.value.String()
+}
+
+func (f field) set(value string) error {
+	if f.value.Type() == durationType {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		f.value.SetInt(int64(d))
+		return nil
+	}
+	if f.value.Kind() == reflect.Bool {
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		f.value.SetBool(b)
+		return nil
+	}
+	if f.value.Kind() == reflect.Int {
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return err
+		}
+		f.value.SetInt(int64(n))
+		return nil
+	}
+
+	f.value.SetString(value)
+	return nil
+}
@@ -0,0 +1,145 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"quantum-safe-mesh/pkg/meshlog"
+)
+
+// reloadMutex guards the settings a Reloader changes against Handler
+// reading them.
+var reloadMutex sync.RWMutex
+
+// Reloader re-reads a service's configuration while it runs, on SIGHUP or
+// when its config file changes, and applies the settings tagged reload.
+// Changes to any other setting are logged as needing a restart. While
+// secret settings are references it also reloads every reload.interval, so
+// rotated secrets are noticed.
+type Reloader struct {
+	service string
+	flags   *flag.FlagSet
+	cfg     *Config
+	hooks   []func(*Config)
+	modTime time.Time
+	pending map[string]string // values already logged as needing a restart, by path
+}
+
+// NewReloader returns a reloader updating cfg, which Load built for service
+// from flags. It applies log.level itself; OnReload adds what else the
+// service changes.
+func NewReloader(cfg *Config, service string, flags *flag.FlagSet) *Reloader {
+	r := &Reloader{service: service, flags: flags, cfg: cfg, modTime: modTime(cfg.File), pending: make(map[string]string)}
+	r.OnReload(func(cfg *Config) {
+		if err := meshlog.SetLevel(cfg.Log.Level); err != nil {
+			log.Printf("⚠️ Failed to change log level: %v", err)
+		}
+	})
+	return r
+}
+
+// OnReload calls fn with the updated configuration after each reload that
+// changes a reloadable setting.
+func (r *Reloader) OnReload(fn func(*Config)) {
+	r.hooks = append(r.hooks, fn)
+}
+
+// Reload builds the configuration again and applies the reloadable
+// settings that changed. An invalid configuration is refused whole and the
+// running one kept.
+func (r *Reloader) Reload() error {
+	return r.reload(false)
+}
+
+// reload is Reload, logging only changes not logged before as needing a
+// restart if quiet.
+func (r *Reloader) reload(quiet bool) error {
+	r.modTime = modTime(r.cfg.File)
+	next, err := load(r.service, r.cfg.File, r.flags, r.cfg.shared)
+	if err != nil {
+		return fmt.Errorf("failed to reload configuration: %w", err)
+	}
+
+	var applied, restart []string
+	reloadMutex.Lock()
+	nextFields := next.fields()
+	for i, f := range r.cfg.fields() {
+		if f.String() == nextFields[i].String() {
+			delete(r.pending, f.path)
+			continue
+		}
+		if !f.reload {
+			value := nextFields[i].String()
+			if !quiet || r.pending[f.path] != value {
+				restart = append(restart, f.path)
+			}
+			r.pending[f.path] = value
+			continue
+		}
+		f.value.Set(nextFields[i].value)
+		applied = append(applied, f.path)
+	}
+	r.cfg.references = next.references
+	reloadMutex.Unlock()
+
+	if len(restart) > 0 {
+		log.Printf("⚠️ Changed settings need a restart to apply: %s", strings.Join(restart, ", "))
+	}
+	if len(applied) == 0 {
+		return nil
+	}
+	log.Printf("🔄 Reloaded configuration: %s", strings.Join(applied, ", "))
+	for _, hook := range r.hooks {
+		hook(r.cfg)
+	}
+	return nil
+}
+
+// Watch reloads the configuration on SIGHUP and, every reload.interval,
+// if the config file has changed since it was last read.
+func (r *Reloader) Watch() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+
+	var poll <-chan time.Time
+	if (r.cfg.File != "" || r.cfg.references) && r.cfg.Reload.Interval > 0 {
+		poll = time.NewTicker(r.cfg.Reload.Interval).C
+	}
+
+	go func() {
+		for {
+			quiet := false
+			select {
+			case <-signals:
+			case <-poll:
+				if modTime(r.cfg.File).Equal(r.modTime) {
+					if !r.cfg.references {
+						continue
+					}
+					quiet = true
+				}
+			}
+			if err := r.reload(quiet); err != nil {
+				log.Printf("⚠️ Keeping the running configuration: %v", err)
+			}
+		}
+	}()
+}
+
+func modTime(path string) time.Time {
+	if path == "" {
+		return time.Time{}
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
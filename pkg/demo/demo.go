@@ -0,0 +1,678 @@
+// Package demo is the mesh's demo client: it walks a running mesh through
+// its endpoints, verifying each signed response, or sends hostile traffic
+// straight to the backend to show each attack is rejected.
+package demo
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"quantum-safe-mesh/pkg/mesh"
+	"quantum-safe-mesh/pkg/models"
+	"quantum-safe-mesh/pkg/pqc"
+)
+
+// Output modes. Text narrates each step; quiet prints only failures and a
+// summary line; json prints a single report for CI to parse.
+const (
+	OutputText  = "text"
+	OutputQuiet = "quiet"
+	OutputJSON  = "json"
+)
+
+// StepResult is the outcome of one demo step. Verified, SignedBy and
+// CountersignedBy describe the client-side check of the response signature;
+// Expected is the rejection an attack step should have met.
+type StepResult struct {
+	Name            string          `json:"name"`
+	Success         bool            `json:"success"`
+	Status          int             `json:"status,omitempty"`
+	LatencyMs       float64         `json:"latency_ms,omitempty"`
+	Expected        string          `json:"expected,omitempty"`
+	Verified        bool            `json:"verified,omitempty"`
+	SignedBy        string          `json:"signed_by,omitempty"`
+	CountersignedBy []string        `json:"countersigned_by,omitempty"`
+	Data            json.RawMessage `json:"data,omitempty"`
+	ErrorCode       string          `json:"error_code,omitempty"`
+	Error           string          `json:"error,omitempty"`
+}
+
+// Report is the json output of a run.
+type Report struct {
+	Success    bool         `json:"success"`
+	GatewayURL string       `json:"gateway_url"`
+	DurationMs float64      `json:"duration_ms"`
+	Steps      []StepResult `json:"steps"`
+}
+
+// Demo walks a running mesh through its endpoints, or attacks its backend,
+// and reports each step.
+type Demo struct {
+	gatewayURL string
+	authURL    string
+	backendURL string
+	backendID  string
+	verify     bool
+	output     string
+	pause      time.Duration
+	client     *http.Client
+	registry   *mesh.RegistryClient
+	attacker   *mesh.Identity // registered throwaway identity in attack mode
+	control    []byte         // the accepted envelope attack mode replays
+	steps      []StepResult
+}
+
+// Options configure a Demo. Empty BackendURL skips the backend health check;
+// Verify checks response signatures against keys from the auth service.
+type Options struct {
+	GatewayURL string
+	AuthURL    string
+	BackendURL string
+	BackendID  string
+	Verify     bool
+	Output     string
+	Pause      time.Duration
+	Timeout    time.Duration
+}
+
+// New returns a Demo against the mesh in opts. It reports an unknown output
+// mode.
+func New(opts Options) (*Demo, error) {
+	if opts.Output != OutputText && opts.Output != OutputQuiet && opts.Output != OutputJSON {
+		return nil, fmt.Errorf("unknown output mode %q: expected text, quiet or json", opts.Output)
+	}
+	d := &Demo{
+		gatewayURL: strings.TrimSuffix(opts.GatewayURL, "/"),
+		authURL:    strings.TrimSuffix(opts.AuthURL, "/"),
+		backendURL: strings.TrimSuffix(opts.BackendURL, "/"),
+		backendID:  opts.BackendID,
+		verify:     opts.Verify,
+		output:     opts.Output,
+		pause:      opts.Pause,
+		client:     &http.Client{Timeout: opts.Timeout},
+	}
+	d.registry = mesh.NewRegistryClient(d.authURL, nil, mesh.NewPeerVersions())
+	d.registry.SetTimeout(opts.Timeout)
+	return d, nil
+}
+
+// demoStep is one titled stage of a run.
+type demoStep struct {
+	title string
+	run   func()
+}
+
+// Run walks through the mesh and reports whether every step succeeded.
+func (d *Demo) Run() bool {
+	return d.runSteps("🌟 Quantum-Safe Service Mesh Demo", []demoStep{
+		{"🔍 Step 1: Checking service health...", d.checkHealth},
+		{"📡 Step 2: Testing Echo Endpoint...", d.testEcho},
+		{"🧠 Step 3: Testing Data Processing...", d.testProcess},
+		{"📊 Step 4: Checking Service Status...", d.testStatus},
+	}, func() {
+		d.printf("\n🔐 Step 5: Performance Summary...\n")
+		showPerformanceSummary()
+		fmt.Println("\n🎉 Demo completed successfully!")
+		if d.verify {
+			fmt.Println("Every response was verified against its post-quantum signature!")
+		} else {
+			fmt.Println("All requests were authenticated using Post-Quantum Cryptography!")
+		}
+	})
+}
+
+// runSteps runs steps in order, then reports in the chosen output mode.
+// conclude prints the text-mode ending of a successful run.
+func (d *Demo) runSteps(title string, steps []demoStep, conclude func()) bool {
+	start := time.Now()
+
+	d.printf("%s\n", title)
+	d.printf("%s\n", strings.Repeat("=", 50))
+
+	for i, step := range steps {
+		if i > 0 {
+			time.Sleep(d.pause)
+		}
+		d.printf("\n%s\n", step.title)
+		step.run()
+	}
+
+	success := true
+	failed := 0
+	for _, step := range d.steps {
+		if !step.Success {
+			success = false
+			failed++
+		}
+	}
+
+	switch d.output {
+	case OutputJSON:
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(Report{
+			Success:    success,
+			GatewayURL: d.gatewayURL,
+			DurationMs: millis(time.Since(start)),
+			Steps:      d.steps,
+		})
+	case OutputQuiet:
+		if success {
+			fmt.Printf("✅ Demo passed: %d steps\n", len(d.steps))
+		} else {
+			fmt.Printf("❌ Demo failed: %d of %d steps failed\n", failed, len(d.steps))
+		}
+	default:
+		if success {
+			conclude()
+		} else {
+			fmt.Printf("\n❌ Demo failed: %d of %d steps failed\n", failed, len(d.steps))
+		}
+	}
+	return success
+}
+
+// printf narrates in text mode only.
+func (d *Demo) printf(format string, args ...interface{}) {
+	if d.output == OutputText {
+		fmt.Printf(format, args...)
+	}
+}
+
+// record stores a step's outcome. Text mode prints every step, quiet mode
+// only failures.
+func (d *Demo) record(result StepResult, hint string) {
+	d.steps = append(d.steps, result)
+
+	if result.Success && result.Expected != "" {
+		d.printf("🛡️  %s rejected: %s (latency: %.3fms)\n", result.Name, result.Expected, result.LatencyMs)
+		return
+	}
+	if result.Success {
+		d.printf("✅ %s successful (latency: %.3fms)\n", result.Name, result.LatencyMs)
+		if result.Status != 0 {
+			d.printf("   Status: %d %s\n", result.Status, http.StatusText(result.Status))
+		}
+		if result.Verified {
+			d.printf("   🔏 Signature verified: signed by %s", result.SignedBy)
+			if len(result.CountersignedBy) > 0 {
+				d.printf(", countersigned by %s", strings.Join(result.CountersignedBy, ", "))
+			}
+			d.printf("\n")
+		}
+		if len(result.Data) > 0 {
+			var pretty bytes.Buffer
+			if json.Indent(&pretty, result.Data, "   ", "  ") == nil {
+				d.printf("   Data: %s\n", pretty.String())
+			}
+		}
+		return
+	}
+
+	if d.output == OutputJSON {
+		return
+	}
+	if result.ErrorCode != "" {
+		fmt.Printf("❌ %s failed (status %d): %s - %s\n", result.Name, result.Status, result.ErrorCode, result.Error)
+	} else {
+		fmt.Printf("❌ %s failed: %s\n", result.Name, result.Error)
+	}
+	if hint != "" {
+		fmt.Printf("   %s\n", hint)
+	}
+}
+
+func (d *Demo) checkHealth() {
+	services := []struct{ name, url string }{
+		{"Auth Service", d.authURL},
+		{"Gateway Service", d.gatewayURL},
+		{"Backend Service", d.backendURL},
+	}
+
+	for _, service := range services {
+		if service.url == "" {
+			continue
+		}
+
+		name := service.name + " health"
+		start := time.Now()
+		resp, err := d.client.Get(service.url + "/health")
+		if err != nil {
+			d.record(StepResult{Name: name, Error: fmt.Sprintf("not responding at %s: %v", service.url, err)}, "")
+			continue
+		}
+		resp.Body.Close()
+
+		result := StepResult{Name: name, Status: resp.StatusCode, LatencyMs: millis(time.Since(start)), Success: resp.StatusCode == http.StatusOK}
+		if !result.Success {
+			result.Error = "unhealthy: " + resp.Status
+		}
+		d.record(result, "")
+	}
+}
+
+func (d *Demo) testEcho() {
+	data := map[string]interface{}{
+		"message":   "Hello Quantum-Safe World!",
+		"demo":      true,
+		"timestamp": time.Now(),
+		"test_data": []string{"quantum", "cryptography", "demo"},
+	}
+
+	d.call("Echo test", "POST", "/echo", data)
+}
+
+func (d *Demo) testProcess() {
+	data := map[string]interface{}{
+		"operation": "quantum_safe_processing",
+		"data": map[string]interface{}{
+			"input":     "Sensitive data for quantum-safe processing",
+			"algorithm": "advanced",
+			"priority":  "high",
+		},
+		"metadata": map[string]interface{}{
+			"client_id":    "demo-client",
+			"request_id":   fmt.Sprintf("req-%d", time.Now().Unix()),
+			"quantum_safe": true,
+		},
+	}
+
+	d.call("Process test", "POST", "/process", data)
+}
+
+func (d *Demo) testStatus() {
+	d.call("Status test", "GET", "/status", nil)
+}
+
+// call sends one request through the gateway and records the outcome,
+// decoding the mesh ErrorResponse on failure so the error code is visible.
+func (d *Demo) call(name, method, path string, payload interface{}) {
+	var body io.Reader
+	if payload != nil {
+		jsonData, _ := json.Marshal(payload)
+		body = bytes.NewReader(jsonData)
+	}
+
+	req, err := http.NewRequest(method, d.gatewayURL+path, body)
+	if err != nil {
+		d.record(StepResult{Name: name, Error: err.Error()}, "")
+		return
+	}
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("X-Client-ID", "demo-client")
+
+	start := time.Now()
+	resp, err := d.client.Do(req)
+	duration := time.Since(start)
+	if err != nil {
+		d.record(StepResult{Name: name, LatencyMs: millis(duration), Error: err.Error()}, "Hint: is the Gateway running at "+d.gatewayURL+"?")
+		return
+	}
+	defer resp.Body.Close()
+
+	result := StepResult{Name: name, Status: resp.StatusCode, LatencyMs: millis(duration)}
+	if resp.StatusCode < http.StatusBadRequest {
+		responseBody, err := io.ReadAll(resp.Body)
+		if err != nil {
+			result.Error = "failed to read response: " + err.Error()
+			d.record(result, "")
+			return
+		}
+
+		if !d.verify {
+			result.Success = true
+			if json.Valid(responseBody) {
+				result.Data = responseBody
+			}
+			d.record(result, "")
+			return
+		}
+
+		if err := d.verifyResponse(&result, resp, responseBody); err != nil {
+			result.Error = "response signature verification failed: " + err.Error()
+			d.record(result, "🚨 Do not trust this response's data")
+			return
+		}
+		result.Success = true
+		d.record(result, "")
+		return
+	}
+
+	errResp := models.DecodeErrorResponse(resp)
+	result.ErrorCode, result.Error = errResp.Code, errResp.Message
+
+	var hint string
+	switch {
+	case errResp.Code == models.ErrCodeUpstreamUnavailable:
+		hint = "Hint: is the Backend Service running?"
+	case errResp.Code == models.ErrCodeUpstreamSignatureInvalid:
+		hint = "Hint: the backend's registered key does not match its signing key"
+	case errResp.Retryable:
+		hint = "This error is retryable; try again shortly"
+	}
+	d.record(result, hint)
+}
+
+// verifyResponse checks a gateway response the way a mesh peer would: a
+// detached response against the backend's JWS, an envelope against the
+// backend's signature and every countersignature in its chain. Keys come
+// from the auth service. On success it fills in the signers and Data.
+func (d *Demo) verifyResponse(result *StepResult, resp *http.Response, body []byte) error {
+	requestID := resp.Header.Get(models.HeaderRequestID)
+
+	if token := resp.Header.Get(models.HeaderMeshSignature); token != "" {
+		header, _, err := pqc.ParseDetachedJWS(token)
+		if err != nil {
+			return err
+		}
+		if header.Kid != d.backendID {
+			return fmt.Errorf("signed by %s, expected %s", header.Kid, d.backendID)
+		}
+		publicKey, err := d.registry.PublicKey(header.Kid)
+		if err != nil {
+			return fmt.Errorf("failed to get %s public key: %w", header.Kid, err)
+		}
+		if _, err := pqc.VerifyDetachedJWS(publicKey, token, body); err != nil {
+			return err
+		}
+		if requestID != "" && header.Rid != requestID {
+			return fmt.Errorf("signed for request %s, expected %s", header.Rid, requestID)
+		}
+
+		result.Verified, result.SignedBy = true, header.Kid
+		if json.Valid(body) {
+			result.Data = body
+		}
+		return nil
+	}
+
+	var envelope models.ServiceResponse
+	if err := json.Unmarshal(body, &envelope); err != nil || envelope.Signature == nil {
+		return fmt.Errorf("response is not signed")
+	}
+	if envelope.ServiceID != d.backendID {
+		return fmt.Errorf("signed by %s, expected %s", envelope.ServiceID, d.backendID)
+	}
+
+	publicKey, err := d.registry.PublicKey(envelope.ServiceID)
+	if err != nil {
+		return fmt.Errorf("failed to get %s public key: %w", envelope.ServiceID, err)
+	}
+	signedPayload, err := envelope.SigningPayload()
+	if err != nil {
+		return fmt.Errorf("failed to reconstruct signing payload: %w", err)
+	}
+	if err := pqc.VerifySignature(envelope.SignatureAlg, publicKey, signedPayload, envelope.Signature); err != nil {
+		return err
+	}
+	if envelope.RequestID != "" && requestID != "" && envelope.RequestID != requestID {
+		return fmt.Errorf("signed for request %s, expected %s", envelope.RequestID, requestID)
+	}
+
+	chainPayload, err := envelope.ChainPayload()
+	if err != nil {
+		return fmt.Errorf("failed to reconstruct chain payload: %w", err)
+	}
+	if err := pqc.VerifySignatureChain(envelope.Chain, chainPayload, d.registry.PublicKey); err != nil {
+		return err
+	}
+
+	result.Verified, result.SignedBy = true, envelope.ServiceID
+	for _, link := range envelope.Chain {
+		result.CountersignedBy = append(result.CountersignedBy, link.SignerID)
+	}
+	if !models.IsEncryptedPayload(envelope.Data) {
+		result.Data = envelope.Data
+	}
+	return nil
+}
+
+// spoofedServiceID is the identity attack mode impersonates.
+const spoofedServiceID = "api-gateway"
+
+// Attack sends hostile traffic straight to the backend, bypassing the
+// gateway, and checks each request is rejected with the expected error. A
+// registered throwaway identity supplies the legitimately signed request the
+// attacks are derived from, and is revoked afterwards.
+func (d *Demo) Attack() bool {
+	defer d.revokeAttacker()
+
+	return d.runSteps("🏴‍☠️ Quantum-Safe Service Mesh Attack Demo", []demoStep{
+		{"🪪 Step 1: Registering a throwaway identity...", d.registerAttacker},
+		{"📨 Step 2: Sending a legitimately signed request...", d.sendControl},
+		{"⚔️  Step 3: Attacking the backend...", d.runAttacks},
+	}, func() {
+		fmt.Println("\n🎉 Attack demo completed successfully!")
+		fmt.Println("Every forged, tampered, stale and replayed request was rejected!")
+	})
+}
+
+func (d *Demo) registerAttacker() {
+	// A fresh ID per run: peers cache keys, so reusing one would make the
+	// backend verify against the previous run's key.
+	identity := &mesh.Identity{ServiceID: "demo-attacker-" + models.NewRequestID()[:8]}
+	dilithiumKeyPair, err := pqc.GenerateDilithiumKeyPair()
+	if err == nil {
+		identity.Signer = dilithiumKeyPair
+		identity.Kyber, err = pqc.GenerateKyberKeyPair()
+	}
+
+	start := time.Now()
+	if err == nil {
+		err = mesh.NewRegistryClient(d.authURL, identity, mesh.NewPeerVersions()).Register()
+	}
+	if err != nil {
+		d.record(StepResult{Name: "Registration", Error: err.Error()}, "Hint: is the Auth Service running at "+d.authURL+"?")
+		return
+	}
+	d.attacker = identity
+	d.record(StepResult{Name: "Registration of " + identity.ServiceID, Success: true, LatencyMs: millis(time.Since(start))}, "")
+}
+
+func (d *Demo) revokeAttacker() {
+	if d.attacker == nil {
+		return
+	}
+	if _, err := mesh.NewRegistryClient(d.authURL, d.attacker, mesh.NewPeerVersions()).Revoke(); err != nil {
+		d.printf("⚠️  Failed to revoke %s: %v\n", d.attacker.ServiceID, err)
+		return
+	}
+	d.printf("\n🧹 Revoked %s\n", d.attacker.ServiceID)
+}
+
+// sendControl shows the backend accepting a correctly signed request, so
+// the rejections that follow are down to the attacks alone.
+func (d *Demo) sendControl() {
+	if d.attacker == nil {
+		return
+	}
+
+	request, err := d.signedEnvelope(d.attacker.Signer, d.attacker.ServiceID, time.Now(), "legitimate request")
+	if err == nil {
+		d.control, err = json.Marshal(request)
+	}
+	if err != nil {
+		d.record(StepResult{Name: "Signed request", Error: err.Error()}, "")
+		return
+	}
+
+	start := time.Now()
+	resp, err := d.postBackend(request.RequestID, d.control, nil)
+	if err != nil {
+		d.record(StepResult{Name: "Signed request", Error: err.Error()}, "Hint: is the Backend Service running at "+d.backendURL+"?")
+		return
+	}
+	defer resp.Body.Close()
+
+	result := StepResult{Name: "Signed request", Status: resp.StatusCode, LatencyMs: millis(time.Since(start))}
+	if resp.StatusCode != http.StatusOK {
+		errResp := models.DecodeErrorResponse(resp)
+		result.ErrorCode, result.Error = errResp.Code, errResp.Message
+		d.record(result, "")
+		return
+	}
+
+	body, _ := io.ReadAll(resp.Body)
+	if err := d.verifyResponse(&result, resp, body); err != nil {
+		result.Error = "response signature verification failed: " + err.Error()
+		d.record(result, "🚨 Do not trust this response's data")
+		return
+	}
+	result.Success = true
+	d.record(result, "")
+}
+
+func (d *Demo) runAttacks() {
+	if d.control == nil {
+		d.printf("⏭️  Skipped: no signed request to derive the attacks from\n")
+		return
+	}
+	identity := d.attacker
+
+	attacks := []struct {
+		name   string
+		status int
+		code   string
+		build  func() (requestID string, body []byte, headers map[string]string, err error)
+	}{
+		{"Unsigned request", http.StatusUnauthorized, models.ErrCodeInvalidSignature, func() (string, []byte, map[string]string, error) {
+			request, err := d.signedEnvelope(identity.Signer, identity.ServiceID, time.Now(), "unsigned request")
+			request.Signature = nil
+			return encodeAttack(request, err)
+		}},
+		{"Tampered payload", http.StatusUnauthorized, models.ErrCodeInvalidSignature, func() (string, []byte, map[string]string, error) {
+			request, err := d.signedEnvelope(identity.Signer, identity.ServiceID, time.Now(), "transfer 10 credits")
+			request.Data = json.RawMessage(`{"message":"transfer 10000 credits"}`)
+			return encodeAttack(request, err)
+		}},
+		{"Replayed request", http.StatusUnauthorized, models.ErrCodeRequestReplayed, func() (string, []byte, map[string]string, error) {
+			var request models.ServiceRequest
+			err := json.Unmarshal(d.control, &request)
+			return request.RequestID, d.control, nil, err
+		}},
+		{"Stale request", http.StatusUnauthorized, models.ErrCodeRequestExpired, func() (string, []byte, map[string]string, error) {
+			return encodeAttack(d.signedEnvelope(identity.Signer, identity.ServiceID, time.Now().Add(-10*time.Minute), "stale request"))
+		}},
+		{"Spoofed service ID", http.StatusUnauthorized, models.ErrCodeInvalidSignature, func() (string, []byte, map[string]string, error) {
+			return encodeAttack(d.signedEnvelope(identity.Signer, spoofedServiceID, time.Now(), "spoofed request"))
+		}},
+		{"Unregistered service", http.StatusUnauthorized, models.ErrCodeInvalidSignature, func() (string, []byte, map[string]string, error) {
+			rogue, err := pqc.GenerateDilithiumKeyPair()
+			if err != nil {
+				return "", nil, nil, err
+			}
+			return encodeAttack(d.signedEnvelope(rogue, "rogue-service", time.Now(), "rogue request"))
+		}},
+		{"Forged detached signature", http.StatusUnauthorized, models.ErrCodeInvalidSignature, func() (string, []byte, map[string]string, error) {
+			requestID := models.NewRequestID()
+			body := []byte(`{"message":"forged request"}`)
+			token, err := pqc.SignDetachedWithHeader(identity.Signer, pqc.JWSHeader{Kid: spoofedServiceID, Rid: requestID}, body)
+			return requestID, body, map[string]string{models.HeaderMeshSignature: token}, err
+		}},
+	}
+
+	for _, attack := range attacks {
+		result := StepResult{Name: attack.name, Expected: fmt.Sprintf("%d %s", attack.status, attack.code)}
+
+		requestID, body, headers, err := attack.build()
+		if err != nil {
+			result.Error = err.Error()
+			d.record(result, "")
+			continue
+		}
+
+		start := time.Now()
+		resp, err := d.postBackend(requestID, body, headers)
+		if err != nil {
+			result.Error = err.Error()
+			d.record(result, "")
+			continue
+		}
+		result.Status, result.LatencyMs = resp.StatusCode, millis(time.Since(start))
+
+		if resp.StatusCode < http.StatusBadRequest {
+			resp.Body.Close()
+			result.Error = "accepted by the backend"
+			d.record(result, "🚨 The backend did not reject this attack")
+			continue
+		}
+
+		errResp := models.DecodeErrorResponse(resp)
+		resp.Body.Close()
+		result.ErrorCode = errResp.Code
+		result.Success = resp.StatusCode == attack.status && errResp.Code == attack.code
+		if !result.Success {
+			result.Error = fmt.Sprintf("expected %s, got %s", result.Expected, errResp.Message)
+		}
+		d.record(result, "")
+	}
+}
+
+// signedEnvelope builds a request claiming to come from serviceID, signed
+// with key at timestamp.
+func (d *Demo) signedEnvelope(key pqc.Signer, serviceID string, timestamp time.Time, message string) (models.ServiceRequest, error) {
+	request := models.ServiceRequest{
+		ProtocolVersion: models.CurrentProtocolVersion,
+		ServiceID:       serviceID,
+		RequestID:       models.NewRequestID(),
+		Timestamp:       timestamp,
+		Data:            json.RawMessage(fmt.Sprintf(`{"message":%q}`, message)),
+		SignatureAlg:    pqc.EnvelopeAlg(key),
+	}
+
+	payload, err := request.SigningPayload()
+	if err != nil {
+		return request, fmt.Errorf("failed to marshal signing payload: %w", err)
+	}
+	request.Signature, err = key.Sign(payload)
+	return request, err
+}
+
+func encodeAttack(request models.ServiceRequest, err error) (string, []byte, map[string]string, error) {
+	if err != nil {
+		return "", nil, nil, err
+	}
+	body, err := json.Marshal(request)
+	return request.RequestID, body, nil, err
+}
+
+// postBackend sends body to the backend's /echo endpoint directly.
+func (d *Demo) postBackend(requestID string, body []byte, headers map[string]string) (*http.Response, error) {
+	req, err := http.NewRequest("POST", d.backendURL+"/echo", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(models.HeaderAcceptVersion, models.AcceptVersionHeader())
+	req.Header.Set(models.HeaderRequestID, requestID)
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+	return d.client.Do(req)
+}
+
+func millis(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+func showPerformanceSummary() {
+	fmt.Println("\n📈 PQC Performance Characteristics:")
+	fmt.Println("   • Dilithium3 Signatures: Quantum-resistant digital signatures")
+	fmt.Println("   • Kyber768 KEM: Quantum-resistant key encapsulation")
+	fmt.Println("   • All inter-service communication is cryptographically verified")
+	fmt.Println("   • Zero-trust architecture with PQC mutual authentication")
+
+	fmt.Println("\n🛡️  Security Benefits:")
+	fmt.Println("   • Protection against quantum computer attacks")
+	fmt.Println("   • Forward secrecy with Kyber key exchange")
+	fmt.Println("   • Mutual authentication between all services")
+	fmt.Println("   • Tamper-evident message signatures")
+}
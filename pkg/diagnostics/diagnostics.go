@@ -0,0 +1,61 @@
+// Package diagnostics serves the Go runtime's profiling and diagnostics
+// endpoints, net/http/pprof and expvar, on a listener of their own bound to
+// localhost, so a running service can be profiled without rebuilding it
+// and without exposing them on its API port.
+package diagnostics
+
+import (
+	"expvar"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+)
+
+func init() {
+	expvar.Publish("goroutines", expvar.Func(func() any { return runtime.NumGoroutine() }))
+}
+
+// Handler serves /debug/pprof/ and /debug/vars.
+func Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.Handle("/debug/vars", expvar.Handler())
+	return mux
+}
+
+// CheckLoopback checks addr is a host:port on localhost.
+func CheckLoopback(addr string) error {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("invalid diagnostics address %q: %w", addr, err)
+	}
+	if ip := net.ParseIP(host); host == "localhost" || (ip != nil && ip.IsLoopback()) {
+		return nil
+	}
+	return fmt.Errorf("diagnostics address %q must be on localhost, e.g. localhost:6060", addr)
+}
+
+// Start serves Handler on addr, which must be on localhost, in the
+// background.
+func Start(addr string) error {
+	if err := CheckLoopback(addr); err != nil {
+		return err
+	}
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", addr, err)
+	}
+
+	log.Printf("🩺 Diagnostics serving pprof and expvar on http://%s/debug/", listener.Addr())
+	go func() {
+		log.Printf("❌ Diagnostics listener stopped: %v", http.Serve(listener, Handler()))
+	}()
+	return nil
+}
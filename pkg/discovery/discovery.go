@@ -0,0 +1,243 @@
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"quantum-safe-mesh/pkg/mesh"
+)
+
+// Discovery sources. Static leaves services on their configured URLs.
+const (
+	ModeStatic   = "static"
+	ModeDNS      = "dns"
+	ModeConsul   = "consul"
+	ModeRegistry = "registry"
+)
+
+// DefaultTTL is how long a resolved address is reused before looking the
+// service up again.
+const DefaultTTL = 30 * time.Second
+
+// Config selects and configures the discovery sources.
+type Config struct {
+	Modes       []string // tried in order until one resolves
+	DNSDomain   string   // SRV records are _<DNSService>._tcp.<service-id>.<DNSDomain>
+	DNSService  string   // defaults to "http"
+	ConsulAddr  string
+	ConsulToken string
+	TTL         time.Duration
+}
+
+// ParseModes parses a comma-separated list of discovery modes.
+func ParseModes(value string) ([]string, error) {
+	var modes []string
+	for _, mode := range strings.Split(value, ",") {
+		mode = strings.TrimSpace(mode)
+		switch mode {
+		case "", ModeStatic:
+		case ModeDNS, ModeConsul, ModeRegistry:
+			modes = append(modes, mode)
+		default:
+			return nil, fmt.Errorf("unknown discovery mode %q: expected %q, %q, %q or %q",
+				mode, ModeStatic, ModeDNS, ModeConsul, ModeRegistry)
+		}
+	}
+	return modes, nil
+}
+
+// New builds a resolver trying each configured source in turn, caching what
+// it finds for cfg.TTL and keeping the last good address while every source
+// fails. The registry source uses addresses services
+// advertised when registering with the auth service. New returns nil when
+// no sources are configured, leaving clients on their static URLs.
+func New(cfg Config, registry *mesh.RegistryClient) (mesh.Resolver, error) {
+	if len(cfg.Modes) == 0 {
+		return nil, nil
+	}
+
+	var sources []namedResolver
+	for _, mode := range cfg.Modes {
+		switch mode {
+		case ModeDNS:
+			if cfg.DNSDomain == "" {
+				return nil, fmt.Errorf("DNS discovery requires a domain")
+			}
+			service := cfg.DNSService
+			if service == "" {
+				service = "http"
+			}
+			sources = append(sources, namedResolver{mode, dnsResolver(service, cfg.DNSDomain)})
+		case ModeConsul:
+			if cfg.ConsulAddr == "" {
+				return nil, fmt.Errorf("Consul discovery requires an address")
+			}
+			sources = append(sources, namedResolver{mode, consulResolver(cfg.ConsulAddr, cfg.ConsulToken)})
+		case ModeRegistry:
+			sources = append(sources, namedResolver{mode, registryResolver(registry)})
+		default:
+			return nil, fmt.Errorf("unknown discovery mode %q", mode)
+		}
+	}
+
+	ttl := cfg.TTL
+	if ttl <= 0 {
+		ttl = DefaultTTL
+	}
+
+	cache := &cache{sources: sources, ttl: ttl, entries: make(map[string]cacheEntry)}
+	return cache.resolve, nil
+}
+
+type namedResolver struct {
+	mode    string
+	resolve mesh.Resolver
+}
+
+type cacheEntry struct {
+	address string
+	expires time.Time
+}
+
+type cache struct {
+	sources []namedResolver
+	ttl     time.Duration
+	mutex   sync.Mutex
+	entries map[string]cacheEntry
+}
+
+func (c *cache) resolve(serviceID string) (string, error) {
+	c.mutex.Lock()
+	entry, exists := c.entries[serviceID]
+	c.mutex.Unlock()
+	if exists && time.Now().Before(entry.expires) {
+		return entry.address, nil
+	}
+
+	var errs []error
+	for _, source := range c.sources {
+		address, err := source.resolve(serviceID)
+		if errors.Is(err, mesh.ErrNotResolvable) {
+			continue
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", source.mode, err))
+			continue
+		}
+
+		if address != entry.address {
+			log.Printf("📍 Resolved %s to %s via %s", serviceID, address, source.mode)
+		}
+
+		c.mutex.Lock()
+		c.entries[serviceID] = cacheEntry{address: address, expires: time.Now().Add(c.ttl)}
+		c.mutex.Unlock()
+		return address, nil
+	}
+
+	if len(errs) == 0 {
+		return "", mesh.ErrNotResolvable
+	}
+	if exists {
+		log.Printf("⚠️  Failed to refresh %s, keeping %s: %v", serviceID, entry.address, errors.Join(errs...))
+		return entry.address, nil
+	}
+	return "", errors.Join(errs...)
+}
+
+// registryResolver returns the address a service advertised to the auth
+// service. The auth service itself has to be found some other way.
+func registryResolver(registry *mesh.RegistryClient) mesh.Resolver {
+	return func(serviceID string) (string, error) {
+		if serviceID == mesh.AuthServiceID {
+			return "", mesh.ErrNotResolvable
+		}
+		return registry.Address(serviceID)
+	}
+}
+
+// dnsResolver looks services up by SRV record, honouring priority and
+// weight as net.LookupSRV orders them.
+func dnsResolver(service, domain string) mesh.Resolver {
+	scheme := "http"
+	if service == "https" {
+		scheme = "https"
+	}
+
+	return func(serviceID string) (string, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		_, records, err := net.DefaultResolver.LookupSRV(ctx, service, "tcp", serviceID+"."+domain)
+		if err != nil {
+			return "", fmt.Errorf("failed to look up SRV records: %w", err)
+		}
+		if len(records) == 0 {
+			return "", fmt.Errorf("no SRV records for %s", serviceID)
+		}
+
+		target := strings.TrimSuffix(records[0].Target, ".")
+		return fmt.Sprintf("%s://%s", scheme, net.JoinHostPort(target, strconv.Itoa(int(records[0].Port)))), nil
+	}
+}
+
+type consulServiceEntry struct {
+	Node struct {
+		Address string `json:"Address"`
+	} `json:"Node"`
+	Service struct {
+		Address string `json:"Address"`
+		Port    int    `json:"Port"`
+	} `json:"Service"`
+}
+
+// consulResolver picks a random healthy instance from Consul's health API.
+func consulResolver(addr, token string) mesh.Resolver {
+	client := &http.Client{Timeout: 5 * time.Second}
+
+	return func(serviceID string) (string, error) {
+		req, err := http.NewRequest("GET", fmt.Sprintf("%s/v1/health/service/%s?passing=true", addr, url.PathEscape(serviceID)), nil)
+		if err != nil {
+			return "", fmt.Errorf("failed to create Consul request: %w", err)
+		}
+		if token != "" {
+			req.Header.Set("X-Consul-Token", token)
+		}
+
+		resp, err := client.Do(req)
+		if err != nil {
+			return "", fmt.Errorf("failed to query Consul: %w", err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			return "", fmt.Errorf("Consul returned status %d", resp.StatusCode)
+		}
+
+		var entries []consulServiceEntry
+		if err := json.NewDecoder(resp.Body).Decode(&entries); err != nil {
+			return "", fmt.Errorf("failed to decode Consul response: %w", err)
+		}
+		if len(entries) == 0 {
+			return "", fmt.Errorf("no healthy instances of %s", serviceID)
+		}
+
+		entry := entries[rand.Intn(len(entries))]
+		host := entry.Service.Address
+		if host == "" {
+			host = entry.Node.Address
+		}
+		return fmt.Sprintf("http://%s", net.JoinHostPort(host, strconv.Itoa(entry.Service.Port))), nil
+	}
+}
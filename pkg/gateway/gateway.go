@@ -0,0 +1,1114 @@
+// Package gateway is the mesh's API gateway: it authenticates callers and
+// forwards their requests to the backend as signed mesh calls, verifying and
+// relaying the backend's signed responses.
+package gateway
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"quantum-safe-mesh/pkg/buildinfo"
+	"quantum-safe-mesh/pkg/channel"
+	"quantum-safe-mesh/pkg/cloudattest"
+	"quantum-safe-mesh/pkg/config"
+	"quantum-safe-mesh/pkg/discovery"
+	"quantum-safe-mesh/pkg/health"
+	"quantum-safe-mesh/pkg/mesh"
+	"quantum-safe-mesh/pkg/meshlog"
+	"quantum-safe-mesh/pkg/meshmsg"
+	"quantum-safe-mesh/pkg/middleware"
+	"quantum-safe-mesh/pkg/models"
+	"quantum-safe-mesh/pkg/pqc"
+	"quantum-safe-mesh/pkg/quota"
+	"quantum-safe-mesh/pkg/telemetry"
+	"quantum-safe-mesh/pkg/wasmfilter"
+)
+
+// workloadDelegationTTL bounds the delegation the gateway signs for each
+// workload it registers, which the auth service checks straight away.
+const workloadDelegationTTL = 5 * time.Minute
+
+// newResolver configures peer discovery from the discovery settings, a list
+// of sources tried in order. It returns nil for static URLs.
+func newResolver(cfg *config.Config, registry *mesh.RegistryClient) (mesh.Resolver, error) {
+	return discovery.New(discovery.Config{
+		Modes:       cfg.DiscoveryModes(),
+		DNSDomain:   cfg.Discovery.DNSDomain,
+		DNSService:  cfg.Discovery.DNSService,
+		ConsulAddr:  cfg.Discovery.ConsulAddr,
+		ConsulToken: cfg.Discovery.ConsulToken,
+		TTL:         cfg.Discovery.TTL,
+	}, registry)
+}
+
+type APIGateway struct {
+	identity *mesh.Identity
+	registry *mesh.RegistryClient
+	backend  *mesh.SignedClient
+	channel  *channel.Client
+	metrics  *mesh.Metrics
+	audit    *mesh.AuditLog
+
+	// delegationScope is the pattern of workloads the gateway registers on
+	// their behalf, if any, and delegation the chain that allows it to.
+	delegationScope string
+	delegation      []models.DelegationAssertion
+
+	// namespaces is set when NAMESPACE_ROUTES is. Routes a namespace owns
+	// are only forwarded for signed requests, which callers verifies.
+	namespaces *mesh.NamespacePolicy
+	callers    *mesh.VerifyingServer
+
+	// public lists the paths forwarded for anyone, unsigned, if any.
+	public *mesh.PathSet
+
+	// streams lists the paths whose uploads are streamed, if any.
+	streams *mesh.PathSet
+
+	// filters holds the filter chains of routes that have them, if any.
+	filters *mesh.FilterRoutes
+
+	// outliers tracks how every upstream answers and ejects failing ones.
+	outliers *mesh.OutlierDetector
+
+	// apiVersions are the versions of the external API, if any, and
+	// upstreams the client of each version's upstream, by version name.
+	apiVersions *mesh.APIVersions
+	upstreams   map[string]*mesh.SignedClient
+
+	// cache keeps verified responses to reads on cached routes, if any.
+	cache *mesh.ResponseCache
+
+	// coalescer shares backend calls among identical reads, if set.
+	coalescer *mesh.Coalescer
+
+	// graphql lists the queries allowed on graphqlPath, if it is set.
+	graphqlPath string
+	graphql     *mesh.PersistedQueries
+
+	// affinity selects what keeps requests on one backend replica, when
+	// there are several; affinityHeader carries session IDs.
+	affinity       string
+	affinityHeader string
+
+	// quotas caps what each client may use, if quotas are set.
+	quotas *quota.Quotas
+
+	// rateLimits caps how often each client may call, by route, and
+	// admin serves the admin endpoints when they have a listener of their
+	// own.
+	rateLimits *mesh.RateLimits
+	admin      http.Handler
+
+	// publisher is set when MESSAGE_BUS_URL is; results holds verified job
+	// results until a client collects them.
+	publisher    *meshmsg.Publisher
+	resultsMutex sync.Mutex
+	results      map[string]*meshmsg.Message
+}
+
+func NewAPIGateway(cfg *config.Config) (*APIGateway, error) {
+	log.Println("🚀 Starting API Gateway...")
+
+	identity, err := cfg.Keys.LoadIdentity(cfg.Service.ID, cfg.Crypto.Signature, cfg.Crypto.KEM)
+	if err != nil {
+		return nil, err
+	}
+
+	versions := mesh.NewPeerVersions()
+	registry := mesh.NewRegistryClient(cfg.Auth.URL, identity, versions)
+	registry.SetTimeout(cfg.Timeouts.Client)
+	registry.SetKeyLookupTimeout(cfg.Timeouts.KeyLookup)
+
+	resolver, err := newResolver(cfg, registry)
+	if err != nil {
+		return nil, err
+	}
+	if resolver != nil {
+		registry.UseResolver(resolver)
+	}
+
+	if cfg.Policy.SPIFFESocket != "" {
+		registry.UseSPIFFE(cfg.Policy.SPIFFESocket)
+	}
+	if cfg.Policy.KubeSATokenFile != "" {
+		registry.UseServiceAccountToken(cfg.Policy.KubeSATokenFile)
+	}
+	if cfg.Policy.CloudAttestProvider != "" {
+		registry.UseCloudAttestation(cloudattest.NewClient(cfg.Policy.CloudAttestProvider, cfg.Policy.CloudAttestAzureResource))
+	}
+	if cfg.Policy.BootstrapToken != "" {
+		registry.UseBootstrapToken(cfg.Policy.BootstrapToken)
+	}
+	if cfg.Policy.PinFile != "" {
+		pins, err := pqc.OpenPinStore(cfg.Policy.PinFile)
+		if err != nil {
+			return nil, err
+		}
+		registry.UsePinStore(pins)
+	}
+	if cfg.Snapshot.File != "" {
+		registry.UseSnapshotFile(cfg.Snapshot.File, cfg.Snapshot.Signer)
+	}
+
+	if err := registry.Register(); err != nil {
+		log.Printf("Warning: failed to register with auth service: %v", err)
+	} else if cfg.Keys.RotationDue(identity) {
+		if err := registry.RotateIdentity(); err != nil {
+			log.Printf("Warning: failed to rotate expiring key: %v", err)
+		}
+	}
+
+	backendID := cfg.Upstream.ID
+	backend, err := mesh.NewSignedClient(identity, registry, versions, backendID, cfg.Upstream.URL, cfg.Crypto.SignatureMode)
+	if err != nil {
+		return nil, err
+	}
+	backend.SetTimeout(cfg.Timeouts.Client)
+	backend.SetRetries(cfg.Upstream.Retries)
+	if resolver != nil {
+		backend.UseResolver(resolver)
+	}
+
+	gw := &APIGateway{
+		identity:       identity,
+		registry:       registry,
+		backend:        backend,
+		metrics:        mesh.NewMetrics(identity.ServiceID),
+		audit:          mesh.NewAuditLog(identity.ServiceID),
+		affinity:       cfg.Upstream.Affinity,
+		affinityHeader: cfg.Upstream.AffinityHeader,
+		rateLimits:     mesh.NewRateLimits(),
+	}
+	if err := gw.setRateLimits(cfg.RateLimit.Gateway); err != nil {
+		return nil, err
+	}
+
+	if replicas := cfg.UpstreamReplicas(); len(replicas) > 0 {
+		backend.UseReplicas(replicas)
+		log.Printf("🎯 Spreading requests over %d backend replicas (affinity: %s)", len(replicas), gw.affinity)
+	}
+
+	if cfg.Policy.DelegationScope != "" {
+		gw.delegationScope = cfg.Policy.DelegationScope
+		if cfg.Policy.DelegationFile != "" {
+			if gw.delegation, err = mesh.LoadDelegation(cfg.Policy.DelegationFile); err != nil {
+				return nil, err
+			}
+		}
+		log.Printf("🤝 Registering workloads matching %q on their behalf", gw.delegationScope)
+	}
+
+	if gw.namespaces, err = mesh.ParseNamespacePolicy(cfg.Policy.NamespaceRoutes, cfg.Policy.NamespaceTrust); err != nil {
+		return nil, err
+	}
+	if gw.namespaces != nil {
+		gw.callers = mesh.NewVerifyingServer(identity, registry.PublicKey)
+		gw.callers.UseKeyResolver(registry)
+		gw.callers.UseFreshness(cfg.Timeouts.RequestMaxAge, cfg.Timeouts.ClockSkew)
+		replays, err := mesh.OpenReplayStore(cfg.Replay.Store, identity.ServiceID)
+		if err != nil {
+			return nil, err
+		}
+		gw.callers.UseReplayStore(replays)
+		gw.callers.UseNamespacePolicy(gw.namespaces)
+		gw.metrics, gw.audit = gw.callers.Metrics(), gw.callers.Audit()
+		log.Println("🏢 Requiring signed callers on namespaced routes")
+	}
+
+	// Callers' requests and backends' responses share one controller.
+	admission := cfg.Admission.Controller(gw.metrics)
+	if gw.callers != nil {
+		gw.callers.UseAdmission(admission)
+	}
+	backend.UseAdmission(admission)
+
+	gw.outliers = mesh.NewOutlierDetector(mesh.OutlierPolicy{
+		ConsecutiveFailures: cfg.Upstream.OutlierConsecutiveFailures,
+		ErrorPercent:        cfg.Upstream.OutlierErrorPercent,
+		MinRequests:         cfg.Upstream.OutlierMinRequests,
+		Cooldown:            cfg.Upstream.OutlierCooldown,
+	}, gw.metrics)
+	backend.UseOutlierDetection(gw.outliers)
+
+	publicPaths := cfg.Policy.PublicPaths
+	if cfg.Debug.Passthrough {
+		publicPaths += "," + mesh.PassthroughPath
+		log.Printf("⚠️  Forwarding %s unsigned for benchmarks; never enable BENCHMARK_PASSTHROUGH in production", mesh.PassthroughPath)
+	}
+	if gw.public, err = mesh.ParsePublicPaths(publicPaths); err != nil {
+		return nil, err
+	}
+	if cfg.Policy.PublicPaths != "" {
+		log.Printf("🌍 Forwarding public paths unsigned: %s", cfg.Policy.PublicPaths)
+	}
+
+	if gw.streams, err = mesh.ParseStreamPaths(cfg.Upstream.StreamPaths); err != nil {
+		return nil, err
+	}
+	if gw.streams != nil {
+		log.Printf("📦 Streaming uploads to %s", cfg.Upstream.StreamPaths)
+	}
+
+	modules, err := wasmfilter.ParseModules(cfg.Upstream.WASMFilters)
+	if err != nil {
+		return nil, err
+	}
+	for name, path := range modules {
+		filter, err := wasmfilter.Load(name, path, wasmfilter.Limits{MemoryMiB: cfg.Upstream.WASMMemoryLimit, Timeout: cfg.Upstream.WASMTimeout}, gw.metrics)
+		if err != nil {
+			return nil, err
+		}
+		mesh.RegisterFilter(name, filter)
+		log.Printf("🧩 Loaded WASM filter %s from %s", name, path)
+	}
+	if gw.filters, err = mesh.ParseFilterRoutes(cfg.Upstream.Filters); err != nil {
+		return nil, err
+	}
+	if gw.filters != nil {
+		log.Printf("🧩 Running gateway filters: %s", cfg.Upstream.Filters)
+	}
+
+	if gw.apiVersions, err = mesh.ParseAPIVersions(cfg.Upstream.Versions, cfg.Upstream.DefaultVersion); err != nil {
+		return nil, err
+	}
+	if gw.apiVersions != nil {
+		gw.upstreams = make(map[string]*mesh.SignedClient)
+		for _, version := range gw.apiVersions.All() {
+			if version.Upstream == backendID && version.URL == "" {
+				gw.upstreams[version.Name] = backend
+				continue
+			}
+			client, err := mesh.NewSignedClient(identity, registry, versions, version.Upstream, version.URL, cfg.Crypto.SignatureMode)
+			if err != nil {
+				return nil, err
+			}
+			client.SetTimeout(cfg.Timeouts.Client)
+			client.SetRetries(cfg.Upstream.Retries)
+			if resolver != nil {
+				client.UseResolver(resolver)
+			}
+			client.UseOutlierDetection(gw.outliers)
+			client.UseAdmission(admission)
+			gw.upstreams[version.Name] = client
+		}
+		log.Printf("🔀 Routing API versions: %s", cfg.Upstream.Versions)
+	}
+
+	if gw.cache, err = mesh.ParseResponseCache(cfg.Upstream.Cache, cfg.Upstream.CacheMaxEntries); err != nil {
+		return nil, err
+	}
+	if gw.cache != nil {
+		log.Printf("💾 Caching verified responses: %s", cfg.Upstream.Cache)
+	}
+
+	if cfg.GraphQL.Path != "" {
+		if gw.graphql, err = mesh.LoadPersistedQueries(cfg.GraphQL.Queries); err != nil {
+			return nil, err
+		}
+		gw.graphqlPath = cfg.GraphQL.Path
+		log.Printf("🔏 Allowing %d persisted GraphQL queries on %s", gw.graphql.Len(), gw.graphqlPath)
+	}
+
+	if cfg.Upstream.Coalesce {
+		gw.coalescer = mesh.NewCoalescer()
+		log.Println("🔗 Coalescing identical concurrent reads")
+	}
+
+	if cfg.Quota.RequestsPerDay > 0 || cfg.Quota.BytesPerMonth > 0 {
+		store, err := quota.Open(cfg.Quota.Store)
+		if err != nil {
+			return nil, err
+		}
+		gw.quotas = quota.New(store, int64(cfg.Quota.RequestsPerDay), int64(cfg.Quota.BytesPerMonth))
+		log.Printf("📊 Enforcing per-client quotas: %d requests/day, %d bytes/month (0 is unlimited)",
+			cfg.Quota.RequestsPerDay, cfg.Quota.BytesPerMonth)
+	}
+
+	if cfg.Upstream.ChannelAddr != "" {
+		gw.channel = channel.NewClient(cfg.Upstream.ChannelAddr, identity, backendID, registry.PublicKey)
+		log.Printf("🔗 Forwarding to backend over PQC channel at %s", cfg.Upstream.ChannelAddr)
+	}
+
+	if cfg.Messaging.BusURL != "" {
+		if err := gw.startMessaging(cfg.Messaging.BusURL); err != nil {
+			return nil, err
+		}
+	}
+
+	log.Printf("✅ API Gateway initialized with ID: %s (signature mode: %s)", identity.ServiceID, backend.Mode())
+	return gw, nil
+}
+
+func (gw *APIGateway) verifyRequest(r *http.Request, serviceID string) error {
+	log.Printf("🔍 Verifying request from service: %s", serviceID)
+
+	signature := r.Header.Get("X-Signature")
+	if signature == "" {
+		return fmt.Errorf("missing signature header")
+	}
+
+	publicKey, err := gw.registry.PublicKeyContext(r.Context(), serviceID)
+	if err != nil {
+		return fmt.Errorf("failed to get public key: %w", err)
+	}
+	algorithm, err := gw.registry.Algorithm(serviceID)
+	if err != nil {
+		return fmt.Errorf("failed to get public key: %w", err)
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read request body: %w", err)
+	}
+	r.Body = io.NopCloser(bytes.NewBuffer(body))
+
+	signatureBytes := []byte(signature)
+	if err := telemetry.Verify(r.Context(), algorithm, publicKey, body, signatureBytes); err != nil {
+		return fmt.Errorf("signature verification failed: %w", err)
+	}
+
+	log.Printf("✅ Request verified successfully from service: %s", serviceID)
+	return nil
+}
+
+// forwardToBackend signs r and sends it to the backend, or to the upstream
+// of the API version it was routed by, running the version's and the
+// route's filters, if any, on the way. caller is the verified envelope of a
+// signed caller, on routes that require one.
+func (gw *APIGateway) forwardToBackend(w http.ResponseWriter, r *http.Request, caller *models.ServiceRequest, version *mesh.APIVersion) {
+	if gw.streams != nil && gw.streams.Match(r.URL.Path) {
+		gw.streamToBackend(w, r, caller, version)
+		return
+	}
+
+	log.Println("🔄 Forwarding request to backend service")
+	backend := gw.upstream(version)
+
+	body, err := mesh.ReadBody(http.MaxBytesReader(w, r.Body, mesh.MaxRequestBodySize), r.ContentLength, mesh.MaxRequestBodySize)
+	if err != nil {
+		log.Printf("❌ Failed to read request body: %v", err)
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			models.WriteError(w, r, http.StatusRequestEntityTooLarge, models.ErrCodeRequestTooLarge, "Request body too large")
+			return
+		}
+		models.WriteError(w, r, http.StatusBadRequest, models.ErrCodeInvalidRequest, "Failed to read request")
+		return
+	}
+
+	// GraphQL operations are replaced by the persisted query they name,
+	// always posted, so the signature covers a reviewed query and its hash.
+	method, requestPath := r.Method, r.URL.RequestURI()
+	if gw.graphql != nil && mesh.OnGraphQLPath(r.URL.Path, gw.graphqlPath) {
+		resolved, errResp := gw.graphql.Resolve(r, body)
+		if errResp != nil {
+			log.Printf("🚫 Refused GraphQL operation: %s", errResp.Message)
+			errResp.RequestID = r.Header.Get(models.HeaderRequestID)
+			w.Header().Set("X-Gateway-Service", gw.identity.ServiceID)
+			models.WriteErrorResponse(w, errResp)
+			return
+		}
+		body, method, requestPath = resolved, http.MethodPost, r.URL.EscapedPath()
+		r.Header.Set("Content-Type", "application/json")
+	}
+
+	if gw.channel != nil {
+		gw.forwardOverChannel(w, r, body)
+		return
+	}
+
+	call := &mesh.Call{
+		Context:        r.Context(),
+		Method:         method,
+		Path:           requestPath,
+		Body:           body,
+		Headers:        make(map[string]string),
+		RequestID:      r.Header.Get(models.HeaderRequestID),
+		ParentID:       r.Header.Get(models.HeaderParentID),
+		IdempotencyKey: r.Header.Get(models.HeaderIdempotencyKey),
+		Affinity:       gw.affinityKey(r, caller),
+	}
+
+	for key, values := range r.Header {
+		if len(values) > 0 {
+			call.Headers[key] = values[0]
+		}
+	}
+
+	filterCtx := &mesh.FilterContext{Request: r, Caller: caller}
+	var filters mesh.FilterChain
+	if version != nil {
+		filterCtx.Version = version.Name
+		filters = append(filters, version.Filters...)
+	}
+	if gw.filters != nil {
+		var chain mesh.FilterChain
+		filterCtx.Route, chain = gw.filters.Chain(r.URL.Path)
+		filters = append(filters, chain...)
+	}
+	if err := filters.OnRequest(filterCtx, call); err != nil {
+		gw.writeFilterError(w, r, err)
+		return
+	}
+
+	resp, errResp := gw.callBackend(w, backend, call, version, caller)
+	if errResp != nil {
+		gw.writeBackendError(w, backend, errResp)
+		return
+	}
+
+	// A response the filters changed is signed by the gateway instead, as
+	// the backend's signature no longer covers it.
+	resigned := false
+	if len(filters) > 0 {
+		before := signedContent(resp)
+		if err := filters.OnResponse(filterCtx, resp); err != nil {
+			gw.writeFilterError(w, r, err)
+			return
+		}
+		if !bytes.Equal(before, signedContent(resp)) {
+			if err := backend.Resign(resp); err != nil {
+				log.Printf("❌ Failed to sign filtered response: %v", err)
+				models.WriteError(w, r, http.StatusInternalServerError, models.ErrCodeInternal, "Internal server error")
+				return
+			}
+			log.Printf("🧩 Filters changed the response to %s; signed by %s", r.URL.Path, gw.identity.ServiceID)
+			resigned = true
+		}
+	}
+
+	// Detached responses pass through unchanged so clients can check the
+	// backend's signature themselves.
+	if resp.Envelope == nil {
+		w.Header().Set("Content-Type", resp.Header.Get("Content-Type"))
+		w.Header().Set("X-Gateway-Service", gw.identity.ServiceID)
+		w.Header().Set(models.HeaderMeshSignature, resp.Token)
+		w.Header().Set(models.HeaderServerTiming, resp.Timing.ServerTiming())
+		w.WriteHeader(resp.StatusCode)
+		w.Write(resp.Body)
+		return
+	}
+
+	gw.writeEnvelope(w, r, backend, resp, resigned)
+}
+
+// streamToBackend forwards r's body to the backend as it arrives, signed by
+// its digest, for uploads too large to buffer. Filters, caching and retries
+// need the body in memory, so streamed paths go without them.
+func (gw *APIGateway) streamToBackend(w http.ResponseWriter, r *http.Request, caller *models.ServiceRequest, version *mesh.APIVersion) {
+	backend := gw.upstream(version)
+	log.Printf("📦 Streaming %s to %s", r.URL.Path, backend.PeerID())
+
+	call := &mesh.Call{
+		Context:        r.Context(),
+		Method:         r.Method,
+		Path:           r.URL.RequestURI(),
+		Headers:        map[string]string{"Content-Type": r.Header.Get("Content-Type")},
+		RequestID:      r.Header.Get(models.HeaderRequestID),
+		ParentID:       r.Header.Get(models.HeaderParentID),
+		IdempotencyKey: r.Header.Get(models.HeaderIdempotencyKey),
+		Affinity:       gw.affinityKey(r, caller),
+	}
+	resp, errResp := backend.Stream(call, http.MaxBytesReader(w, r.Body, mesh.MaxContentSize))
+	if errResp != nil {
+		gw.writeBackendError(w, backend, errResp)
+		return
+	}
+	gw.writeEnvelope(w, r, backend, resp, false)
+}
+
+// writeBackendError relays the error of a backend call, noting a response
+// whose signature failed to verify.
+func (gw *APIGateway) writeBackendError(w http.ResponseWriter, backend *mesh.SignedClient, errResp *models.ErrorResponse) {
+	if errResp.Code == models.ErrCodeUpstreamSignatureInvalid {
+		gw.metrics.CountVerificationFailure(backend.PeerID(), errResp.Code)
+		gw.audit.Record(mesh.AuditEvent{
+			Type:    mesh.AuditVerificationFailed,
+			Subject: backend.PeerID(),
+			Detail:  errResp.Error(),
+		})
+	}
+	w.Header().Set("X-Gateway-Service", gw.identity.ServiceID)
+	models.WriteErrorResponse(w, errResp)
+}
+
+// writeEnvelope writes the backend's verified envelope response,
+// countersigned unless the gateway resigned it, as JSON or, for callers
+// that accept it, CMS SignedData.
+func (gw *APIGateway) writeEnvelope(w http.ResponseWriter, r *http.Request, backend *mesh.SignedClient, resp *mesh.Response, resigned bool) {
+	// Countersign so the client can see the response passed through, and was
+	// verified by, this gateway.
+	if !resigned {
+		countersignStart := time.Now()
+		if err := backend.Countersign(resp.Envelope); err != nil {
+			log.Printf("❌ Failed to countersign backend response: %v", err)
+			models.WriteError(w, r, http.StatusInternalServerError, models.ErrCodeInternal, "Internal server error")
+			return
+		}
+		resp.Timing.Countersign = time.Since(countersignStart)
+	}
+
+	w.Header().Set("X-Gateway-Service", gw.identity.ServiceID)
+	w.Header().Set(models.HeaderProtocolVersion, models.NormalizeProtocolVersion(resp.Envelope.ProtocolVersion))
+	w.Header().Set(models.HeaderServerTiming, resp.Timing.ServerTiming())
+	w.Header().Add("Vary", "Accept")
+
+	// Callers that want SignedData get the envelope wrapped in it, signed by
+	// the gateway: the backend's signature and the countersignature are
+	// still inside.
+	if mesh.AcceptsSignedData(r) {
+		if err := mesh.WriteSignedData(w, gw.identity, resp.StatusCode, resp.Envelope); err != nil {
+			log.Printf("❌ Failed to wrap backend response: %v", err)
+			models.WriteError(w, r, http.StatusInternalServerError, models.ErrCodeInternal, "Internal server error")
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(resp.StatusCode)
+	json.NewEncoder(w).Encode(resp.Envelope)
+}
+
+// affinityKey returns what picks r's backend replica: the signed caller's
+// ID, or else the client ID, for client affinity, and the session ID for
+// session affinity. An empty key spreads requests freely.
+func (gw *APIGateway) affinityKey(r *http.Request, caller *models.ServiceRequest) string {
+	switch gw.affinity {
+	case mesh.AffinityClient:
+		if caller != nil {
+			return caller.ServiceID
+		}
+		return r.Header.Get("X-Client-ID")
+	case mesh.AffinitySession:
+		return r.Header.Get(gw.affinityHeader)
+	}
+	return ""
+}
+
+// callBackend sends call to backend or, on cached routes, answers it with
+// a stored response that still verifies against backend's key.
+func (gw *APIGateway) callBackend(w http.ResponseWriter, backend *mesh.SignedClient, call *mesh.Call, version *mesh.APIVersion, caller *models.ServiceRequest) (*mesh.Response, *models.ErrorResponse) {
+	versionName, callerID := "", ""
+	if version != nil {
+		versionName = version.Name
+	}
+	if caller != nil {
+		callerID = caller.ServiceID
+	}
+	if gw.cache == nil {
+		return gw.send(backend, call, versionName, callerID)
+	}
+	key, cacheable := gw.cache.Key(call, versionName, callerID)
+	if !cacheable {
+		return gw.send(backend, call, versionName, callerID)
+	}
+
+	if resp, age, found := gw.cache.Get(key); found {
+		verifyStart := time.Now()
+		if errResp := backend.Reverify(call, resp); errResp == nil {
+			resp.Timing.Verify = time.Since(verifyStart)
+			gw.metrics.CountCacheLookup(mesh.CacheHit)
+			w.Header().Set(models.HeaderCache, mesh.CacheHit)
+			w.Header().Set("Age", strconv.Itoa(int(age.Seconds())))
+			log.Printf("💾 Answered %s from cache", call.Path)
+			return resp, nil
+		}
+		gw.cache.Drop(key)
+		gw.metrics.CountCacheLookup(mesh.CacheInvalid)
+		log.Printf("⚠️  Dropped cached response to %s, which no longer verifies", call.Path)
+	} else {
+		gw.metrics.CountCacheLookup(mesh.CacheMiss)
+	}
+
+	resp, errResp := gw.send(backend, call, versionName, callerID)
+	if errResp == nil {
+		gw.cache.Put(key, resp)
+		w.Header().Set(models.HeaderCache, mesh.CacheMiss)
+	}
+	return resp, errResp
+}
+
+// send calls backend, joining an identical read already in flight when
+// coalescing is on.
+func (gw *APIGateway) send(backend *mesh.SignedClient, call *mesh.Call, version, caller string) (*mesh.Response, *models.ErrorResponse) {
+	if gw.coalescer == nil {
+		return backend.Call(call)
+	}
+	resp, errResp, shared := gw.coalescer.Call(call, version, caller, backend.Call)
+	if shared {
+		gw.metrics.CountCoalescedCall()
+		log.Printf("🔗 Shared an identical in-flight call to %s", call.Path)
+	}
+	return resp, errResp
+}
+
+// upstream returns the client of version's upstream, or of the backend for
+// requests routed by no version.
+func (gw *APIGateway) upstream(version *mesh.APIVersion) *mesh.SignedClient {
+	if version == nil {
+		return gw.backend
+	}
+	return gw.upstreams[version.Name]
+}
+
+// selectVersion picks the API version r asks for, if versions are set, and
+// strips its prefix from r's path. It answers 406 unsupported_api_version
+// and returns false if the version isn't served.
+func (gw *APIGateway) selectVersion(w http.ResponseWriter, r *http.Request) (*mesh.APIVersion, bool) {
+	if gw.apiVersions == nil {
+		return nil, true
+	}
+	w.Header().Add("Vary", models.HeaderAcceptAPIVersion)
+
+	version, stripped, err := gw.apiVersions.Select(r)
+	if err != nil {
+		log.Printf("⚠️  %v", err)
+		models.WriteError(w, r, http.StatusNotAcceptable, models.ErrCodeUnsupportedAPIVersion, err.Error())
+		return nil, false
+	}
+	if version == nil {
+		return nil, true
+	}
+	if stripped != r.URL.Path {
+		r.URL.Path = stripped
+		r.URL.RawPath = strings.TrimPrefix(r.URL.RawPath, "/"+version.Name)
+	}
+	w.Header().Set(models.HeaderAPIVersion, version.Name)
+	log.Printf("🔀 Routing API %s request for %s to %s", version.Name, r.URL.Path, version.Upstream)
+	return version, true
+}
+
+// signedContent returns what the signature on resp covers.
+func signedContent(resp *mesh.Response) []byte {
+	if resp.Envelope == nil {
+		return bytes.Clone(resp.Body)
+	}
+	payload, _ := resp.Envelope.SigningPayload()
+	return payload
+}
+
+// writeFilterError answers a request a filter stopped with err.
+func (gw *APIGateway) writeFilterError(w http.ResponseWriter, r *http.Request, err error) {
+	w.Header().Set("X-Gateway-Service", gw.identity.ServiceID)
+	middleware.WriteError(w, r, err)
+}
+
+// forwardPublic relays a request on a public path to the backend, or to the
+// upstream of its API version, as it came, without signing it or presenting
+// the gateway's identity, and relays the response unverified.
+func (gw *APIGateway) forwardPublic(w http.ResponseWriter, r *http.Request, version *mesh.APIVersion) {
+	log.Printf("🌍 Forwarding public request to %s", r.URL.Path)
+
+	body, err := mesh.ReadBody(http.MaxBytesReader(w, r.Body, mesh.MaxRequestBodySize), r.ContentLength, mesh.MaxRequestBodySize)
+	if err != nil {
+		log.Printf("❌ Failed to read request body: %v", err)
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			models.WriteError(w, r, http.StatusRequestEntityTooLarge, models.ErrCodeRequestTooLarge, "Request body too large")
+			return
+		}
+		models.WriteError(w, r, http.StatusBadRequest, models.ErrCodeInvalidRequest, "Failed to read request")
+		return
+	}
+
+	call := &mesh.Call{
+		Context:   r.Context(),
+		Method:    r.Method,
+		Path:      r.URL.RequestURI(),
+		Body:      body,
+		Headers:   make(map[string]string),
+		RequestID: r.Header.Get(models.HeaderRequestID),
+		ParentID:  r.Header.Get(models.HeaderParentID),
+		Affinity:  gw.affinityKey(r, nil),
+	}
+	for key, values := range r.Header {
+		if len(values) > 0 {
+			call.Headers[key] = values[0]
+		}
+	}
+
+	resp, err := gw.upstream(version).ForwardPublic(call)
+	if err != nil {
+		log.Printf("❌ Public request to %s failed: %v", r.URL.Path, err)
+		models.WriteError(w, r, http.StatusServiceUnavailable, models.ErrCodeUpstreamUnavailable, "Backend service unavailable")
+		return
+	}
+	defer resp.Body.Close()
+
+	for name, values := range resp.Header {
+		w.Header()[name] = values
+	}
+	w.Header().Set("X-Gateway-Service", gw.identity.ServiceID)
+	w.WriteHeader(resp.StatusCode)
+	io.Copy(w, resp.Body)
+}
+
+// forwardOverChannel sends the request on the persistent channel instead of
+// signing it; the channel's handshake already authenticated both ends.
+func (gw *APIGateway) forwardOverChannel(w http.ResponseWriter, r *http.Request, body []byte) {
+	if len(body) == 0 {
+		body = []byte("{}")
+	}
+	if !json.Valid(body) {
+		models.WriteError(w, r, http.StatusBadRequest, models.ErrCodeInvalidRequest, "Request body must be JSON")
+		return
+	}
+
+	upstreamStart := time.Now()
+	resp, err := gw.channel.Call(&channel.Request{
+		Method:         r.Method,
+		Path:           r.URL.Path,
+		RequestID:      r.Header.Get(models.HeaderRequestID),
+		ParentID:       r.Header.Get(models.HeaderParentID),
+		IdempotencyKey: r.Header.Get(models.HeaderIdempotencyKey),
+		Body:           body,
+	})
+	if err != nil {
+		log.Printf("❌ Channel call to backend failed: %v", err)
+		models.WriteError(w, r, http.StatusServiceUnavailable, models.ErrCodeUpstreamUnavailable, "Backend service unavailable")
+		return
+	}
+
+	timing := mesh.Timing{Upstream: time.Since(upstreamStart)}
+
+	w.Header().Set("X-Gateway-Service", gw.identity.ServiceID)
+	w.Header().Set(models.HeaderServerTiming, timing.ServerTiming())
+	if resp.Error != nil {
+		models.WriteErrorResponse(w, resp.Error)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(resp.Status)
+	w.Write(resp.Body)
+}
+
+// startMessaging connects to the message bus for the asynchronous pipeline:
+// jobs go out on meshmsg.SubjectProcess and verified results are collected
+// from meshmsg.SubjectResults.
+func (gw *APIGateway) startMessaging(busURL string) error {
+	bus, err := meshmsg.Connect(busURL, gw.identity.ServiceID)
+	if err != nil {
+		return fmt.Errorf("failed to connect to message bus: %w", err)
+	}
+
+	gw.publisher = meshmsg.NewPublisher(gw.identity, bus)
+	gw.results = make(map[string]*meshmsg.Message)
+
+	consumer := meshmsg.NewConsumer(bus, gw.registry)
+	err = consumer.Subscribe(meshmsg.SubjectResults, func(msg *meshmsg.Message) error {
+		gw.resultsMutex.Lock()
+		gw.results[msg.RequestID] = msg
+		gw.resultsMutex.Unlock()
+
+		log.Printf("📬 Received result for job %s from %s", msg.RequestID, msg.Envelope.ServiceID)
+		return nil
+	})
+	if err != nil {
+		bus.Close()
+		return err
+	}
+
+	log.Printf("📬 Asynchronous pipeline enabled via %s", busURL)
+	return nil
+}
+
+func (gw *APIGateway) publishJob(w http.ResponseWriter, r *http.Request) {
+	requestID, parentID := models.EdgeRequestIDs(r)
+	r.Header.Set(models.HeaderRequestID, requestID)
+	w.Header().Set(models.HeaderRequestID, requestID)
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		models.WriteError(w, r, http.StatusBadRequest, models.ErrCodeInvalidRequest, "Failed to read request")
+		return
+	}
+	if len(body) == 0 {
+		body = []byte("{}")
+	}
+	if !json.Valid(body) {
+		models.WriteError(w, r, http.StatusBadRequest, models.ErrCodeInvalidRequest, "Request body must be JSON")
+		return
+	}
+
+	// The bus may deliver a job more than once; without a key of the
+	// client's, the request ID keeps redeliveries from running it again.
+	idempotencyKey := r.Header.Get(models.HeaderIdempotencyKey)
+	if idempotencyKey == "" {
+		idempotencyKey = requestID
+	}
+	err = gw.publisher.Publish(meshmsg.SubjectProcess, &meshmsg.Message{
+		RequestID:      requestID,
+		ParentID:       parentID,
+		IdempotencyKey: idempotencyKey,
+		Data:           body,
+	})
+	if err != nil {
+		log.Printf("❌ Failed to publish job: %v", err)
+		models.WriteError(w, r, http.StatusServiceUnavailable, models.ErrCodeUpstreamUnavailable, "Message bus unavailable")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"request_id": requestID,
+		"status":     "queued",
+		"subject":    meshmsg.SubjectProcess,
+		"results":    "/async/results/" + requestID,
+	})
+}
+
+func (gw *APIGateway) getJobResult(w http.ResponseWriter, r *http.Request) {
+	requestID := mux.Vars(r)["requestID"]
+
+	gw.resultsMutex.Lock()
+	msg, exists := gw.results[requestID]
+	delete(gw.results, requestID)
+	gw.resultsMutex.Unlock()
+
+	if !exists {
+		models.WriteError(w, r, http.StatusNotFound, models.ErrCodeNotFound, "No result for request "+requestID)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"request_id":   msg.RequestID,
+		"from_service": msg.Envelope.ServiceID,
+		"timestamp":    msg.Envelope.Timestamp,
+		"result":       msg.Data,
+	})
+}
+
+// registerWorkload registers a workload's key with the auth service on its
+// behalf. The workload proves it holds the key; the gateway vouches that it
+// is one of the workloads it fronts, by its service ID matching the
+// delegation scope.
+func (gw *APIGateway) registerWorkload(w http.ResponseWriter, r *http.Request) {
+	var registration models.WorkloadRegistration
+	if err := json.NewDecoder(r.Body).Decode(&registration); err != nil {
+		models.WriteError(w, r, http.StatusBadRequest, models.ErrCodeInvalidRequest, "Invalid request body")
+		return
+	}
+	if err := mesh.VerifyWorkloadRegistration(&registration, time.Now()); err != nil {
+		log.Printf("❌ Invalid workload registration for %s: %v", registration.ServiceID, err)
+		models.WriteError(w, r, http.StatusUnauthorized, models.ErrCodeInvalidSignature, "Invalid proof of possession")
+		return
+	}
+	if matched, _ := path.Match(gw.delegationScope, registration.ServiceID); !matched {
+		log.Printf("❌ Workload %s is outside the delegation scope %q", registration.ServiceID, gw.delegationScope)
+		models.WriteError(w, r, http.StatusForbidden, models.ErrCodeIdentityMismatch, "Service is not a workload of this gateway")
+		return
+	}
+
+	if err := gw.registry.RegisterDelegated(&registration, gw.delegation, workloadDelegationTTL); err != nil {
+		log.Printf("❌ Failed to register workload %s: %v", registration.ServiceID, err)
+		var errResp *models.ErrorResponse
+		if errors.As(err, &errResp) {
+			models.WriteErrorResponse(w, errResp)
+			return
+		}
+		models.WriteError(w, r, http.StatusBadGateway, models.ErrCodeUpstreamUnavailable, "Auth service unavailable")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":       "success",
+		"service_id":   registration.ServiceID,
+		"fingerprint":  pqc.KeyFingerprint(registration.PublicKey),
+		"delegated_by": gw.identity.ServiceID,
+	})
+}
+
+func (gw *APIGateway) handleRequest(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+
+	requestID, parentID := models.EdgeRequestIDs(r)
+	r.Header.Set(models.HeaderRequestID, requestID)
+	r.Header.Set(models.HeaderParentID, parentID)
+	w.Header().Set(models.HeaderRequestID, requestID)
+
+	ctx := meshlog.With(r.Context(), "request_id", requestID, "parent_id", parentID)
+	r = r.WithContext(ctx)
+	logger := meshlog.FromContext(ctx)
+	logger.Info("🌐 Gateway received request", "method", r.Method, "path", r.URL.Path)
+
+	clientID := r.Header.Get("X-Client-ID")
+	if clientID == "" {
+		clientID = "unknown-client"
+	}
+
+	// Policies below apply to the path without its version prefix, so a
+	// route can't be reached around them through another version.
+	version, ok := gw.selectVersion(w, r)
+	if !ok {
+		return
+	}
+
+	if gw.public != nil && gw.public.Match(r.URL.Path) {
+		gw.metrics.CountRequest(mesh.CallerUnauthenticated)
+		gw.forwardPublic(w, r, version)
+		return
+	}
+
+	caller := mesh.CallerUnauthenticated
+	var envelope *models.ServiceRequest
+	if gw.namespaces != nil && gw.namespaces.Owner(r.URL.Path) != "" {
+		request, ok := gw.callers.Authenticate(w, r)
+		if !ok {
+			return
+		}
+		logger.Info("🏢 Namespace call allowed", "caller", request.ServiceID, "path", r.URL.Path)
+
+		// The backend sees the gateway as the caller; forward the body the
+		// caller signed, without its signature.
+		caller = request.ServiceID
+		envelope = &request
+		r.Body = io.NopCloser(bytes.NewReader(request.Data))
+		r.Header.Del(models.HeaderMeshSignature)
+	}
+
+	gw.metrics.CountRequest(caller)
+	// Quotas are per signed caller. Unsigned requests have no identity to
+	// charge, and sharing one counter would let any client exhaust it for
+	// all the others.
+	if gw.quotas != nil && envelope != nil {
+		if !gw.quotas.Admit(w, r, caller) {
+			return
+		}
+		meter := quota.NewMeter(w, r)
+		gw.forwardToBackend(meter, r, envelope, version)
+		gw.quotas.Record(caller, meter.Bytes())
+	} else {
+		gw.forwardToBackend(w, r, envelope, version)
+	}
+
+	logger.Info("⏱️  Request processed", "duration", time.Since(start))
+}
+
+// Start starts the gateway's audit sinks, exchanges keys with the auth
+// service and returns the gateway's routes.
+func (gw *APIGateway) Start(cfg *config.Config) (http.Handler, error) {
+	if err := cfg.Audit.Apply(gw.audit); err != nil {
+		return nil, fmt.Errorf("failed to start audit sinks: %w", err)
+	}
+	if _, err := gw.registry.KeyExchange(); err != nil {
+		log.Printf("Warning: key exchange failed: %v", err)
+	}
+
+	r := mux.NewRouter()
+	r.Use(telemetry.Middleware, middleware.Recover, middleware.Deadline(cfg.Timeouts.Request),
+		middleware.MaxBodySize(int64(cfg.Upstream.MaxBodySize)), gw.limitClients)
+
+	// The admin endpoints move to a listener of their own when one is
+	// set, and are not served at all by a public gateway without one.
+	admin := r
+	switch {
+	case cfg.Service.AdminAddr != "":
+		admin = mux.NewRouter()
+		admin.Use(telemetry.Middleware, middleware.Recover)
+		gw.admin = admin
+	case cfg.Profile == config.ProfilePublic:
+		admin = nil
+		log.Println("🔒 Not serving /config, /metrics, /audit, /upstreams or /version: no admin address is set")
+	}
+	if admin != nil {
+		admin.HandleFunc("/config", cfg.Handler()).Methods("GET")
+		admin.HandleFunc("/metrics", gw.metrics.Handler()).Methods("GET")
+		admin.HandleFunc("/audit", gw.audit.Handler()).Methods("GET")
+		admin.HandleFunc("/version", buildinfo.Handler(buildinfo.Get(cfg.Crypto.Signature, cfg.Crypto.KEM))).Methods("GET")
+		admin.HandleFunc("/upstreams", gw.outliers.Handler()).Methods("GET")
+	}
+
+	probes := health.New()
+	probes.Add(health.Startup, "registered", gw.registry.CheckRegistered)
+	probes.Add(health.Readiness, "keys", cfg.Keys.CheckKeys(gw.identity))
+	probes.Add(health.Readiness, "auth", gw.registry.CheckAuth)
+	if gw.channel != nil {
+		probes.Add(health.Readiness, "upstream_channel", gw.channel.Check)
+	}
+	probes.Register(r)
+
+	if gw.delegationScope != "" {
+		r.HandleFunc("/workloads/register", gw.registerWorkload).Methods("POST")
+	}
+	if gw.publisher != nil {
+		r.HandleFunc("/async/process", gw.publishJob).Methods("POST")
+		r.HandleFunc("/async/results/{requestID}", gw.getJobResult).Methods("GET")
+	}
+	r.PathPrefix("/").HandlerFunc(gw.handleRequest)
+	return r, nil
+}
+
+// AdminHandler returns the routes to serve on the admin address, or nil if
+// none is set. Start must have been called.
+func (gw *APIGateway) AdminHandler() http.Handler {
+	return gw.admin
+}
+
+// Reload applies the reloadable settings of cfg.
+func (gw *APIGateway) Reload(cfg *config.Config) {
+	if gw.callers != nil {
+		gw.callers.UseFreshness(cfg.Timeouts.RequestMaxAge, cfg.Timeouts.ClockSkew)
+	}
+	if err := gw.setRateLimits(cfg.RateLimit.Gateway); err != nil {
+		log.Printf("⚠️ Failed to change rate limits: %v", err)
+	}
+}
+
+// setRateLimits replaces the limits clients are held to with limits.
+func (gw *APIGateway) setRateLimits(limits string) error {
+	if err := gw.rateLimits.Update(limits); err != nil {
+		return err
+	}
+	if limits != "" {
+		log.Printf("🚦 Rate limiting clients: %s", limits)
+	}
+	return nil
+}
+
+// limitClients refuses a client over its rate limit for the request's path
+// with 429 rate_limited, saying when to retry. Clients are told apart by
+// their TLS client certificate, or by address without one.
+func (gw *APIGateway) limitClients(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		client := clientName(r)
+		if allowed, wait := gw.rateLimits.Allow(client, r.URL.Path); !allowed {
+			meshlog.FromContext(r.Context()).Warn("🚫 Client over its rate limit", "client", client, "path", r.URL.Path)
+			errResp := models.NewErrorResponse(r, http.StatusTooManyRequests, models.ErrCodeRateLimited, "Rate limit exceeded")
+			errResp.RetryAfter = int(math.Ceil(wait.Seconds()))
+			models.WriteErrorResponse(w, errResp)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// clientName names the client making r for rate limiting: the subject of
+// its verified TLS client certificate, or its IP address.
+func clientName(r *http.Request) string {
+	if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+		return "cert:" + r.TLS.VerifiedChains[0][0].Subject.String()
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,223 @@
+package gateway
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"quantum-safe-mesh/pkg/mesh"
+	"quantum-safe-mesh/pkg/meshtest"
+	"quantum-safe-mesh/pkg/models"
+)
+
+// Negative-path scenarios at the gateway: what a client sees when the
+// gateway, or the backend behind it, rejects a request or response.
+
+func TestMain(m *testing.M) {
+	log.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+// newTestGateway serves a gateway acting as gatewayID in front of
+// backendURL, with keys resolved through auth.
+func newTestGateway(t *testing.T, auth *meshtest.AuthServer, gatewayID *mesh.Identity, backendID, backendURL string) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(newAPIGateway(t, auth, gatewayID, backendID, backendURL).handleRequest))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// newAPIGateway wires a gateway acting as gatewayID to backendURL, with
+// keys resolved through auth.
+func newAPIGateway(t *testing.T, auth *meshtest.AuthServer, gatewayID *mesh.Identity, backendID, backendURL string) *APIGateway {
+	t.Helper()
+	versions := mesh.NewPeerVersions()
+	registry := mesh.NewRegistryClient(auth.URL, gatewayID, versions)
+	backend, err := mesh.NewSignedClient(gatewayID, registry, versions, backendID, backendURL, mesh.SignatureModeEnvelope)
+	if err != nil {
+		t.Fatalf("NewSignedClient: %v", err)
+	}
+
+	gw := &APIGateway{
+		identity: gatewayID,
+		registry: registry,
+		backend:  backend,
+		metrics:  mesh.NewMetrics(gatewayID.ServiceID),
+		audit:    mesh.NewAuditLog(gatewayID.ServiceID),
+	}
+	return gw
+}
+
+// newTestBackend serves /echo behind a VerifyingServer, or answers every
+// request with a response altered by tamper when it is set.
+func newTestBackend(t *testing.T, auth *meshtest.AuthServer, identity *mesh.Identity, tamper func(*models.ServiceResponse)) string {
+	t.Helper()
+	server := mesh.NewVerifyingServer(identity, auth.Client(identity).PublicKey)
+
+	r := mux.NewRouter()
+	r.HandleFunc("/echo", server.Verified(func(req *mesh.Request) (interface{}, error) {
+		return req.Envelope.Data, nil
+	}))
+	handler := http.Handler(r)
+
+	if tamper != nil {
+		handler = http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			recorder := httptest.NewRecorder()
+			r.ServeHTTP(recorder, req)
+
+			var response models.ServiceResponse
+			json.Unmarshal(recorder.Body.Bytes(), &response)
+			tamper(&response)
+
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(response)
+		})
+	}
+
+	backend := httptest.NewServer(handler)
+	t.Cleanup(backend.Close)
+	return backend.URL
+}
+
+func TestGatewayNegativePaths(t *testing.T) {
+	gatewayID := meshtest.Identity("api-gateway")
+	backendID := meshtest.Identity("backend-service")
+	strangerID := meshtest.Identity("unregistered-gateway")
+	auth := meshtest.NewAuthServer(gatewayID, backendID)
+	defer auth.Close()
+
+	backendURL := newTestBackend(t, auth, backendID, nil)
+	unreachable := httptest.NewServer(http.NotFoundHandler())
+	unreachable.Close()
+
+	body := []byte(`{"message":"hi"}`)
+	tests := []struct {
+		name    string
+		gateway *httptest.Server
+		body    []byte
+		status  int
+		errCode string
+	}{
+		{"valid", newTestGateway(t, auth, gatewayID, backendID.ServiceID, backendURL), body, http.StatusOK, ""},
+		{"gateway unknown to backend", newTestGateway(t, auth, strangerID, backendID.ServiceID, backendURL), body, http.StatusUnauthorized, models.ErrCodeInvalidSignature},
+		{"oversized body", newTestGateway(t, auth, gatewayID, backendID.ServiceID, backendURL), bytes.Repeat([]byte("x"), mesh.MaxRequestBodySize+1), http.StatusRequestEntityTooLarge, models.ErrCodeRequestTooLarge},
+		{"tampered response signature", newTestGateway(t, auth, gatewayID, backendID.ServiceID, newTestBackend(t, auth, backendID, func(r *models.ServiceResponse) {
+			r.Signature[0] ^= 0x01
+		})), body, http.StatusUnauthorized, models.ErrCodeUpstreamSignatureInvalid},
+		{"tampered response data", newTestGateway(t, auth, gatewayID, backendID.ServiceID, newTestBackend(t, auth, backendID, func(r *models.ServiceResponse) {
+			r.Data = json.RawMessage(`{"message":"bye"}`)
+		})), body, http.StatusUnauthorized, models.ErrCodeUpstreamSignatureInvalid},
+		{"backend unknown to registry", newTestGateway(t, auth, gatewayID, "unregistered-backend", backendURL), body, http.StatusInternalServerError, models.ErrCodeUpstreamAuthenticationFail},
+		{"backend unreachable", newTestGateway(t, auth, gatewayID, backendID.ServiceID, unreachable.URL), body, http.StatusServiceUnavailable, models.ErrCodeUpstreamUnavailable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest("POST", tt.gateway.URL+"/echo", bytes.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("POST: %v", err)
+			}
+			defer resp.Body.Close()
+
+			requestID := resp.Header.Get(models.HeaderRequestID)
+			if requestID == "" {
+				t.Fatal("gateway did not assign a request ID")
+			}
+
+			if tt.status == http.StatusOK {
+				if resp.StatusCode != http.StatusOK {
+					t.Fatalf("got %d: %v", resp.StatusCode, models.DecodeErrorResponse(resp))
+				}
+				var envelope models.ServiceResponse
+				if err := json.NewDecoder(resp.Body).Decode(&envelope); err != nil {
+					t.Fatalf("failed to decode response: %v", err)
+				}
+				if !bytes.Equal(envelope.Data, body) || len(envelope.Chain) != 1 {
+					t.Fatalf("got data %s with %d countersignatures, want the echoed body countersigned once", envelope.Data, len(envelope.Chain))
+				}
+				return
+			}
+
+			errResp := models.DecodeErrorResponse(resp)
+			if errResp.Status != tt.status || errResp.Code != tt.errCode {
+				t.Fatalf("got %d %q, want %d %q", errResp.Status, errResp.Code, tt.status, tt.errCode)
+			}
+			if errResp.RequestID != requestID {
+				t.Fatalf("error tagged with request %q, want %q", errResp.RequestID, requestID)
+			}
+		})
+	}
+}
+
+// TestGatewayDoesNotReplay checks the gateway signs each forwarded request
+// afresh, so a client resending the same body is not taken for a replay.
+func TestGatewayDoesNotReplay(t *testing.T) {
+	gatewayID := meshtest.Identity("api-gateway")
+	backendID := meshtest.Identity("backend-service")
+	auth := meshtest.NewAuthServer(gatewayID, backendID)
+	defer auth.Close()
+
+	gateway := newTestGateway(t, auth, gatewayID, backendID.ServiceID, newTestBackend(t, auth, backendID, nil))
+	for i := 0; i < 3; i++ {
+		resp, err := http.Post(gateway.URL+"/echo", "application/json", bytes.NewReader([]byte(`{"same":true}`)))
+		if err != nil {
+			t.Fatalf("POST: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("request %d: got %d", i+1, resp.StatusCode)
+		}
+	}
+}
+
+// TestGatewayGraphQLPaths checks operations sent to spellings of the
+// GraphQL path a backend may route to the same endpoint are still limited
+// to persisted queries.
+func TestGatewayGraphQLPaths(t *testing.T) {
+	gatewayID := meshtest.Identity("api-gateway")
+	backendID := meshtest.Identity("backend-service")
+	auth := meshtest.NewAuthServer(gatewayID, backendID)
+	defer auth.Close()
+
+	query := "{ hello }"
+	hash := sha256.Sum256([]byte(query))
+	queries := filepath.Join(t.TempDir(), "queries.json")
+	allowed, _ := json.Marshal(map[string]string{hex.EncodeToString(hash[:]): query})
+	if err := os.WriteFile(queries, allowed, 0o600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	gw := newAPIGateway(t, auth, gatewayID, backendID.ServiceID, newTestBackend(t, auth, backendID, nil))
+	var err error
+	if gw.graphql, err = mesh.LoadPersistedQueries(queries); err != nil {
+		t.Fatalf("LoadPersistedQueries: %v", err)
+	}
+	gw.graphqlPath = "/graphql"
+	gateway := httptest.NewServer(http.HandlerFunc(gw.handleRequest))
+	defer gateway.Close()
+
+	for _, requestPath := range []string{"/graphql", "/graphql/", "//graphql", "/graphql/.", "/graphql/batch"} {
+		t.Run(requestPath, func(t *testing.T) {
+			resp, err := http.Post(gateway.URL+requestPath, "application/json", bytes.NewReader([]byte(`{"query":"{ secret }"}`)))
+			if err != nil {
+				t.Fatalf("POST: %v", err)
+			}
+			errResp := models.DecodeErrorResponse(resp)
+			if errResp.Status != http.StatusForbidden || errResp.Code != models.ErrCodePersistedQueryNotAllowed {
+				t.Fatalf("got %d %q, want %d %q", errResp.Status, errResp.Code, http.StatusForbidden, models.ErrCodePersistedQueryNotAllowed)
+			}
+		})
+	}
+}
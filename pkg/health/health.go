@@ -0,0 +1,157 @@
+// Package health serves a service's Kubernetes probes from checks the
+// service registers: /healthz (liveness) says the process is serving,
+// /startupz that it finished starting, and /readyz that it can take
+// traffic now. Each answers JSON naming every check and its result, with
+// 503 if any fails.
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// DefaultTimeout bounds each check of a probe.
+const DefaultTimeout = 2 * time.Second
+
+// Checker reports why a service is not healthy, or nil if it is.
+type Checker func(ctx context.Context) error
+
+// Kinds of checks.
+const (
+	Liveness  = "liveness"
+	Startup   = "startup"
+	Readiness = "readiness"
+)
+
+// Report is a probe's answer.
+type Report struct {
+	Status string                 `json:"status"` // "ok" or "unavailable"
+	Checks map[string]CheckResult `json:"checks,omitempty"`
+}
+
+// CheckResult is the outcome of one check.
+type CheckResult struct {
+	Status   string  `json:"status"` // "ok" or "failing"
+	Error    string  `json:"error,omitempty"`
+	Duration float64 `json:"duration_ms"`
+}
+
+type check struct {
+	name string
+	kind string
+	run  Checker
+}
+
+// Probes holds a service's checks. Startup checks must pass once, after
+// which /startupz stays ok; readiness checks run on every /readyz, which
+// also fails until startup is complete.
+type Probes struct {
+	timeout time.Duration
+	started atomic.Bool
+
+	mutex  sync.RWMutex
+	checks []check
+}
+
+func New() *Probes {
+	return &Probes{timeout: DefaultTimeout}
+}
+
+// Add registers fn as a check of kind, reported as name.
+func (p *Probes) Add(kind, name string, fn Checker) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.checks = append(p.checks, check{name: name, kind: kind, run: fn})
+}
+
+// Register mounts the probes on r, with /health kept as an alias of
+// /healthz for existing probes and scripts.
+func (p *Probes) Register(r *mux.Router) {
+	r.HandleFunc("/healthz", p.Handler(Liveness)).Methods("GET")
+	r.HandleFunc("/health", p.Handler(Liveness)).Methods("GET")
+	r.HandleFunc("/startupz", p.Handler(Startup)).Methods("GET")
+	r.HandleFunc("/readyz", p.Handler(Readiness)).Methods("GET")
+}
+
+// Handler answers with the report of kind.
+func (p *Probes) Handler(kind string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		report := p.Check(r.Context(), kind)
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		if report.Status != "ok" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		json.NewEncoder(w).Encode(report)
+	}
+}
+
+// Check runs the checks of kind, and the startup checks until they have
+// passed once if kind is readiness.
+func (p *Probes) Check(ctx context.Context, kind string) Report {
+	p.mutex.RLock()
+	var checks []check
+	for _, c := range p.checks {
+		if c.kind == kind || (c.kind == Startup && kind == Readiness && !p.started.Load()) {
+			checks = append(checks, c)
+		}
+	}
+	p.mutex.RUnlock()
+	if kind == Startup && p.started.Load() {
+		return Report{Status: "ok"}
+	}
+
+	report := Report{Status: "ok", Checks: make(map[string]CheckResult, len(checks))}
+	results := make([]CheckResult, len(checks))
+	var wg sync.WaitGroup
+	for i, c := range checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = p.run(ctx, c.run)
+		}()
+	}
+	wg.Wait()
+
+	startupPassed := true
+	for i, c := range checks {
+		report.Checks[c.name] = results[i]
+		if results[i].Status != "ok" {
+			report.Status = "unavailable"
+			if c.kind == Startup {
+				startupPassed = false
+			}
+		}
+	}
+	if startupPassed && (kind == Startup || kind == Readiness) {
+		p.started.Store(true)
+	}
+	return report
+}
+
+func (p *Probes) run(ctx context.Context, fn Checker) CheckResult {
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() { done <- fn(ctx) }()
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	result := CheckResult{Status: "ok", Duration: float64(time.Since(start).Microseconds()) / 1000}
+	if err != nil {
+		result.Status = "failing"
+		result.Error = err.Error()
+	}
+	return result
+}
@@ -0,0 +1,161 @@
+// Package httpclient sends the mesh's outbound HTTP requests. A
+// SignedRoundTripper signs each attempt at a request, verifies the
+// response, retries transient failures and traces every attempt, so calls
+// between services and to the auth service all take the same path.
+package httpclient
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"time"
+
+	"quantum-safe-mesh/pkg/models"
+	"quantum-safe-mesh/pkg/telemetry"
+)
+
+// Defaults for a SignedRoundTripper's retries.
+const (
+	DefaultRetries = 2
+	DefaultBackoff = 100 * time.Millisecond
+)
+
+var (
+	ErrSign   = errors.New("failed to sign request")
+	ErrVerify = errors.New("response verification failed")
+)
+
+// SignedRoundTripper sends requests through Base, signing each attempt
+// with Sign and checking a successful response with Verify. Both are
+// optional. Errors they return are wrapped in ErrSign and ErrVerify, and
+// can be told apart with errors.As.
+//
+// A request that fails to connect is retried. One whose outcome is unknown,
+// because the connection broke or the peer answered 502, 503 or 504, is
+// retried only if repeating it is safe: its method is idempotent or it
+// carries an Idempotency-Key. A request whose body can't be read again
+// is not retried.
+type SignedRoundTripper struct {
+	Base    http.RoundTripper // http.DefaultTransport if nil
+	Sign    func(*http.Request) error
+	Verify  func(*http.Response) error
+	Retries int           // retries after the first attempt
+	Backoff time.Duration // wait before the first retry, doubling after each; DefaultBackoff if zero
+}
+
+// NewClient returns a client sending requests through a SignedRoundTripper
+// that retries DefaultRetries times and neither signs nor verifies, with
+// timeout bounding each request and its retries.
+func NewClient(timeout time.Duration) *http.Client {
+	return &http.Client{
+		Transport: &SignedRoundTripper{Retries: DefaultRetries},
+		Timeout:   timeout,
+	}
+}
+
+func (t *SignedRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	retries := t.Retries
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		retries = 0
+	}
+	backoff := t.Backoff
+	if backoff <= 0 {
+		backoff = DefaultBackoff
+	}
+
+	for attempt := 0; ; attempt++ {
+		resp, err := t.attempt(req, attempt)
+		if attempt >= retries || !retryable(req, resp, err) {
+			return resp, err
+		}
+
+		reason := fmt.Sprint(err)
+		if resp != nil {
+			reason = resp.Status
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+		log.Printf("🔁 Retrying %s %s in %s (%d of %d): %s", req.Method, req.URL.Redacted(), backoff, attempt+1, retries, reason)
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		case <-timer.C:
+		}
+		backoff *= 2
+	}
+}
+
+// attempt sends req once, with a fresh body after the first attempt.
+func (t *SignedRoundTripper) attempt(req *http.Request, attempt int) (*http.Response, error) {
+	out := req.Clone(req.Context())
+	if attempt > 0 && req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, fmt.Errorf("failed to rewind request body: %w", err)
+		}
+		out.Body = body
+	}
+
+	if t.Sign != nil {
+		if err := t.Sign(out); err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrSign, err)
+		}
+	}
+
+	done := telemetry.Client(out)
+	resp, err := t.base().RoundTrip(out)
+	done(resp, err)
+	if err != nil {
+		return nil, err
+	}
+
+	if t.Verify != nil && resp.StatusCode < http.StatusBadRequest {
+		if err := t.Verify(resp); err != nil {
+			resp.Body.Close()
+			return nil, fmt.Errorf("%w: %w", ErrVerify, err)
+		}
+	}
+	return resp, nil
+}
+
+func (t *SignedRoundTripper) base() http.RoundTripper {
+	if t.Base != nil {
+		return t.Base
+	}
+	return http.DefaultTransport
+}
+
+// retryable reports whether an attempt at req that ended in resp or err
+// may be repeated.
+func retryable(req *http.Request, resp *http.Response, err error) bool {
+	if req.Context().Err() != nil || errors.Is(err, ErrSign) || errors.Is(err, ErrVerify) {
+		return false
+	}
+
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "dial" {
+		return true
+	}
+	if err == nil {
+		switch resp.StatusCode {
+		case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		default:
+			return false
+		}
+	}
+	return idempotent(req)
+}
+
+func idempotent(req *http.Request) bool {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return req.Header.Get(models.HeaderIdempotencyKey) != ""
+}
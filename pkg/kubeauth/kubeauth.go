@@ -0,0 +1,238 @@
+package kubeauth
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"quantum-safe-mesh/pkg/oidc"
+)
+
+// Audience is the audience of the projected ServiceAccount tokens services
+// present to the auth service.
+const Audience = "quantum-safe-mesh/auth-service"
+
+// Registration modes for the auth service, as for SPIFFE: optional accepts
+// first registrations without a token but requires one to re-register a
+// service bound to a service account; required demands one every time.
+const (
+	ModeOff      = "off"
+	ModeOptional = "optional"
+	ModeRequired = "required"
+)
+
+func ValidMode(mode string) bool {
+	return mode == ModeOff || mode == ModeOptional || mode == ModeRequired
+}
+
+const (
+	serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+	usernamePrefix    = "system:serviceaccount:"
+)
+
+// ParseUsername splits a service account's username,
+// system:serviceaccount:<namespace>:<name>.
+func ParseUsername(username string) (string, string, error) {
+	namespace, name, found := strings.Cut(strings.TrimPrefix(username, usernamePrefix), ":")
+	if !strings.HasPrefix(username, usernamePrefix) || !found || namespace == "" || name == "" || strings.Contains(name, ":") {
+		return "", "", fmt.Errorf("%q is not a service account", username)
+	}
+	return namespace, name, nil
+}
+
+// ReadToken reads the projected token at path. The kubelet refreshes it
+// before it expires, so read it again for every registration.
+func ReadToken(path string) (string, error) {
+	token, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read service account token: %w", err)
+	}
+	return strings.TrimSpace(string(token)), nil
+}
+
+// Validator checks a ServiceAccount token issued for Audience and returns
+// the username of the service account it was issued to.
+type Validator interface {
+	Validate(token string) (string, error)
+}
+
+// TokenReviewer validates tokens with the cluster's TokenReview API.
+type TokenReviewer struct {
+	url       string
+	tokenPath string
+	client    *http.Client
+}
+
+// NewTokenReviewer talks to apiURL without credentials, which suits a
+// kubectl proxy, or uses the pod's service account when apiURL is empty.
+// That service account needs the system:auth-delegator role.
+func NewTokenReviewer(apiURL string) (*TokenReviewer, error) {
+	const path = "/apis/authentication.k8s.io/v1/tokenreviews"
+	if apiURL != "" {
+		return &TokenReviewer{url: strings.TrimSuffix(apiURL, "/") + path, client: &http.Client{Timeout: 10 * time.Second}}, nil
+	}
+
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, fmt.Errorf("not running in a cluster; set K8S_SA_API_URL, e.g. to a kubectl proxy")
+	}
+
+	caCert, err := os.ReadFile(filepath.Join(serviceAccountDir, "ca.crt"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cluster CA: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caCert) {
+		return nil, fmt.Errorf("failed to parse cluster CA")
+	}
+
+	return &TokenReviewer{
+		url:       "https://" + net.JoinHostPort(host, port) + path,
+		tokenPath: filepath.Join(serviceAccountDir, "token"),
+		client: &http.Client{
+			Timeout: 10 * time.Second,
+			Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12},
+			},
+		},
+	}, nil
+}
+
+type tokenReview struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Spec       struct {
+		Token     string   `json:"token"`
+		Audiences []string `json:"audiences"`
+	} `json:"spec"`
+	Status struct {
+		Authenticated bool `json:"authenticated"`
+		User          struct {
+			Username string `json:"username"`
+		} `json:"user"`
+		Audiences []string `json:"audiences"`
+		Error     string   `json:"error"`
+	} `json:"status"`
+}
+
+func (v *TokenReviewer) Validate(token string) (string, error) {
+	review := tokenReview{APIVersion: "authentication.k8s.io/v1", Kind: "TokenReview"}
+	review.Spec.Token = token
+	review.Spec.Audiences = []string{Audience}
+	body, err := json.Marshal(review)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal token review: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "POST", v.url, bytes.NewReader(body))
+	if err != nil {
+		return "", fmt.Errorf("failed to create token review: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if v.tokenPath != "" {
+		ownToken, err := ReadToken(v.tokenPath)
+		if err != nil {
+			return "", err
+		}
+		req.Header.Set("Authorization", "Bearer "+ownToken)
+	}
+
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to call TokenReview API: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+		return "", fmt.Errorf("TokenReview API returned status %d", resp.StatusCode)
+	}
+
+	review = tokenReview{}
+	if err := json.NewDecoder(resp.Body).Decode(&review); err != nil {
+		return "", fmt.Errorf("failed to decode token review: %w", err)
+	}
+	if !review.Status.Authenticated {
+		return "", fmt.Errorf("token not authenticated: %s", review.Status.Error)
+	}
+	// An API server that ignores audiences does not return any; one that
+	// checked them returns the ones the token is valid for.
+	if len(review.Status.Audiences) > 0 && !slices.Contains(review.Status.Audiences, Audience) {
+		return "", fmt.Errorf("token is not valid for audience %s", Audience)
+	}
+	if _, _, err := ParseUsername(review.Status.User.Username); err != nil {
+		return "", err
+	}
+	return review.Status.User.Username, nil
+}
+
+// OIDCVerifier validates tokens itself against the keys the cluster's
+// service account issuer publishes through OIDC discovery, for auth
+// services that cannot reach the API server, such as ones outside the
+// cluster.
+type OIDCVerifier struct {
+	verifier *oidc.Verifier
+}
+
+func NewOIDCVerifier(issuer string) *OIDCVerifier {
+	return &OIDCVerifier{verifier: oidc.NewVerifier(strings.TrimSuffix(issuer, "/"))}
+}
+
+func (v *OIDCVerifier) Validate(token string) (string, error) {
+	claims, err := v.verifier.Verify(token, Audience, nil)
+	if err != nil {
+		return "", err
+	}
+	if _, _, err := ParseUsername(claims.Subject); err != nil {
+		return "", err
+	}
+	return claims.Subject, nil
+}
+
+// IDMap maps service account usernames to mesh service IDs.
+type IDMap map[string]string
+
+// ParseIDMap parses comma-separated username=service-id pairs.
+func ParseIDMap(value string) (IDMap, error) {
+	idMap := make(IDMap)
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		username, serviceID, found := strings.Cut(entry, "=")
+		if !found || serviceID == "" {
+			return nil, fmt.Errorf("invalid service account mapping %q: expected system:serviceaccount:namespace:name=service-id", entry)
+		}
+		if _, _, err := ParseUsername(username); err != nil {
+			return nil, err
+		}
+		idMap[username] = serviceID
+	}
+	return idMap, nil
+}
+
+// ServiceID returns the mesh service ID for username: the mapped value if
+// there is one, otherwise the service account's name, so
+// system:serviceaccount:prod:backend-service maps to backend-service.
+func (m IDMap) ServiceID(username string) (string, error) {
+	if serviceID, exists := m[username]; exists {
+		return serviceID, nil
+	}
+	_, name, err := ParseUsername(username)
+	if err != nil {
+		return "", err
+	}
+	return name, nil
+}
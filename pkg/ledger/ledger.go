@@ -0,0 +1,250 @@
+// Package ledger keeps a service's processing records in an embedded SQLite
+// database: for every request it handles, a digest of the request and of
+// its result, who sent it and when, signed with the service's key and
+// chained by hash to the record before. Anyone holding the service's public
+// key can check a record, and the chain shows none were altered or removed,
+// which makes the mesh's processing provable after the fact.
+package ledger
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	_ "modernc.org/sqlite"
+
+	"quantum-safe-mesh/pkg/mesh"
+	"quantum-safe-mesh/pkg/models"
+	"quantum-safe-mesh/pkg/pqc"
+)
+
+const schema = `
+CREATE TABLE IF NOT EXISTS processing_records (
+	sequence     INTEGER PRIMARY KEY,
+	service      TEXT NOT NULL,
+	request_id   TEXT NOT NULL,
+	caller       TEXT NOT NULL,
+	path         TEXT NOT NULL,
+	request_hash TEXT NOT NULL,
+	result_hash  TEXT NOT NULL,
+	processed_at TEXT NOT NULL,
+	prev_hash    TEXT NOT NULL,
+	hash         TEXT NOT NULL,
+	key_id       TEXT NOT NULL,
+	algorithm    TEXT NOT NULL,
+	signature    BLOB NOT NULL
+);
+CREATE INDEX IF NOT EXISTS processing_records_request_id ON processing_records (request_id);
+`
+
+const columns = `sequence, service, request_id, caller, path, request_hash, result_hash, processed_at, prev_hash, hash, key_id, algorithm, signature`
+
+// Ledger appends signed processing records to a SQLite database. Appends
+// are serialized, so the chain has a single tail.
+type Ledger struct {
+	db       *sql.DB
+	identity *mesh.Identity
+
+	mutex    sync.Mutex
+	sequence uint64 // of the last record
+	lastHash string
+}
+
+// Open opens the ledger database at path, creating it if needed, and checks
+// its chain. A ledger whose chain is broken is refused, rather than
+// appended to as if it were whole.
+func Open(path string, identity *mesh.Identity) (*Ledger, error) {
+	db, err := sql.Open("sqlite", "file:"+path+"?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)")
+	if err != nil {
+		return nil, fmt.Errorf("failed to open ledger %s: %w", path, err)
+	}
+	// SQLite allows one writer at a time, and appends are serialized anyway.
+	db.SetMaxOpenConns(1)
+
+	if _, err := db.Exec(schema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to prepare ledger %s: %w", path, err)
+	}
+
+	l := &Ledger{db: db, identity: identity}
+	count, err := l.check()
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("ledger %s is broken: %w", path, err)
+	}
+	log.Printf("📒 Ledger %s holds %d processing records, chain intact", path, count)
+	return l, nil
+}
+
+// check walks the chain from the first record, checking each is numbered
+// in turn, hashes to its Hash and names the Hash of the one before, and
+// leaves l at its tail. It does not check signatures, which needs the keys
+// of every signer since the ledger was created.
+func (l *Ledger) check() (int, error) {
+	rows, err := l.db.Query(`SELECT ` + columns + ` FROM processing_records ORDER BY sequence`)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read records: %w", err)
+	}
+	defer rows.Close()
+
+	count := 0
+	for rows.Next() {
+		record, err := scanRecord(rows)
+		if err != nil {
+			return count, err
+		}
+		if record.Sequence != l.sequence+1 {
+			return count, fmt.Errorf("record %d follows record %d", record.Sequence, l.sequence)
+		}
+		if record.PrevHash != l.lastHash {
+			return count, fmt.Errorf("record %d does not follow the hash of record %d", record.Sequence, l.sequence)
+		}
+		if hash, err := recordHash(record); err != nil || hash != record.Hash {
+			return count, fmt.Errorf("record %d does not match its hash", record.Sequence)
+		}
+		l.sequence, l.lastHash = record.Sequence, record.Hash
+		count++
+	}
+	return count, rows.Err()
+}
+
+// Append records that this service processed request, received on path,
+// with result as the response payload, and returns the signed record.
+func (l *Ledger) Append(request *models.ServiceRequest, path string, result []byte) (*models.ProcessingRecord, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	record := &models.ProcessingRecord{
+		Sequence:    l.sequence + 1,
+		Service:     l.identity.ServiceID,
+		RequestID:   request.RequestID,
+		Caller:      request.ServiceID,
+		Path:        path,
+		RequestHash: digest(request.Data),
+		ResultHash:  digest(result),
+		ProcessedAt: time.Now().UTC(),
+		PrevHash:    l.lastHash,
+		KeyID:       l.identity.KeyID(),
+		Algorithm:   pqc.EnvelopeAlg(l.identity.Signer),
+	}
+	hash, err := recordHash(record)
+	if err != nil {
+		return nil, err
+	}
+	record.Hash = hash
+
+	payload, err := pqc.CanonicalJSON(record.Unsigned())
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode processing record: %w", err)
+	}
+	if record.Signature, err = l.identity.Signer.Sign(payload); err != nil {
+		return nil, fmt.Errorf("failed to sign processing record: %w", err)
+	}
+
+	_, err = l.db.Exec(`INSERT INTO processing_records (`+columns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		record.Sequence, record.Service, record.RequestID, record.Caller, record.Path, record.RequestHash, record.ResultHash,
+		record.ProcessedAt.Format(time.RFC3339Nano), record.PrevHash, record.Hash, record.KeyID, record.Algorithm, []byte(record.Signature))
+	if err != nil {
+		return nil, fmt.Errorf("failed to store processing record: %w", err)
+	}
+	l.sequence, l.lastHash = record.Sequence, record.Hash
+	return record, nil
+}
+
+// Lookup returns the records of requestID, oldest first; a request retried
+// with the same ID has several.
+func (l *Ledger) Lookup(requestID string) ([]models.ProcessingRecord, error) {
+	rows, err := l.db.Query(`SELECT `+columns+` FROM processing_records WHERE request_id = ? ORDER BY sequence`, requestID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up processing records: %w", err)
+	}
+	defer rows.Close()
+
+	var records []models.ProcessingRecord
+	for rows.Next() {
+		record, err := scanRecord(rows)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, *record)
+	}
+	return records, rows.Err()
+}
+
+// Recording wraps h so every request it answers is recorded, with the
+// payload it answers with. A request whose record can't be stored fails,
+// so no result leaves the service without one.
+func (l *Ledger) Recording(h mesh.Handler) mesh.Handler {
+	return func(req *mesh.Request) (interface{}, error) {
+		result, err := h(req)
+		if err != nil {
+			return result, err
+		}
+
+		payload, err := mesh.MarshalPayload(result)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal response: %w", err)
+		}
+		record, err := l.Append(&req.Envelope, req.URL.Path, payload)
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("📒 Recorded %s from %s as record %d", record.RequestID, record.Caller, record.Sequence)
+		return payload, nil
+	}
+}
+
+func (l *Ledger) Close() error {
+	return l.db.Close()
+}
+
+// Verify checks record hashes to its Hash and is signed with publicKey,
+// which must be the key its KeyID names. Whether it belongs in the chain
+// is for the caller to check against its neighbours' hashes.
+func Verify(record *models.ProcessingRecord, publicKey []byte) error {
+	if hash, err := recordHash(record); err != nil || hash != record.Hash {
+		return fmt.Errorf("processing record %d does not match its hash", record.Sequence)
+	}
+	if err := pqc.CheckKeyID(publicKey, record.KeyID); err != nil {
+		return err
+	}
+	if err := pqc.VerifyCanonical(record.Algorithm, publicKey, record.Unsigned(), record.Signature); err != nil {
+		return fmt.Errorf("processing record %d signature verification failed: %w", record.Sequence, err)
+	}
+	return nil
+}
+
+func scanRecord(rows *sql.Rows) (*models.ProcessingRecord, error) {
+	var record models.ProcessingRecord
+	var processedAt string
+	var signature []byte
+	err := rows.Scan(&record.Sequence, &record.Service, &record.RequestID, &record.Caller, &record.Path, &record.RequestHash, &record.ResultHash,
+		&processedAt, &record.PrevHash, &record.Hash, &record.KeyID, &record.Algorithm, &signature)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read processing record: %w", err)
+	}
+	if record.ProcessedAt, err = time.Parse(time.RFC3339Nano, processedAt); err != nil {
+		return nil, fmt.Errorf("failed to read processing record %d: %w", record.Sequence, err)
+	}
+	record.Signature = signature
+	return &record, nil
+}
+
+// recordHash is the hex SHA-256 of record's canonical JSON, without its
+// hash and signature.
+func recordHash(record *models.ProcessingRecord) (string, error) {
+	encoded, err := pqc.CanonicalJSON(record.Unhashed())
+	if err != nil {
+		return "", fmt.Errorf("failed to encode processing record: %w", err)
+	}
+	return digest(encoded), nil
+}
+
+func digest(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,214 @@
+package mesh
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// Admission control defaults.
+const (
+	DefaultAdmissionQueue        = 128
+	DefaultAdmissionQueueTimeout = 100 * time.Millisecond
+)
+
+// Reasons a verification is shed, as counted in the metrics.
+const (
+	ShedQueueFull = "queue_full"
+	ShedTimeout   = "timeout"
+)
+
+// ErrOverloaded is returned for a verification shed by admission control.
+// Services answer it with 503 overloaded, which callers may retry.
+var ErrOverloaded = errors.New("too many signature verifications in progress")
+
+// AdmissionPolicy bounds signature verifications. At most MaxConcurrency
+// run at once, or four per GOMAXPROCS if it is zero; up to Queue more wait
+// for a slot, each for at most QueueTimeout. Anything beyond is shed.
+type AdmissionPolicy struct {
+	MaxConcurrency int
+	Queue          int
+	QueueTimeout   time.Duration
+}
+
+// Admission is a service's admission controller, shared by everything in
+// it that verifies signatures. Verifications are CPU-bound, so running more
+// at once than there are CPUs only makes each slower: the controller starts
+// at GOMAXPROCS, gives up a slot while the verify latency it observes is
+// well above the best it has seen, and takes one back while verifications
+// queue and latency is near that best. Verifications beyond the limit
+// queue briefly, and once the queue is full, or a verification has waited
+// too long, the request is shed, so overload answers some callers 503
+// quickly instead of every caller late.
+type Admission struct {
+	policy   AdmissionPolicy
+	min, max int
+
+	mutex     sync.Mutex
+	limit     int
+	inFlight  int
+	waiters   []chan struct{} // oldest first
+	saturated bool            // a verification queued since the limit last changed
+	completed int             // verifications since the limit last changed
+
+	// smoothed is a moving average of verify latency, and baseline the
+	// lowest it has been, drifting up slowly so that a service whose
+	// verifications got slower for good doesn't shrink its limit forever.
+	smoothed time.Duration
+	baseline time.Duration
+
+	admitted uint64
+	shed     map[string]uint64
+}
+
+// AdmissionStats is an Admission's state and what it has admitted and shed
+// since the service started.
+type AdmissionStats struct {
+	Limit    int
+	InFlight int
+	Queued   int
+	Latency  time.Duration
+	Admitted uint64
+	Shed     map[string]uint64
+}
+
+// NewAdmission returns an admission controller enforcing policy, reported
+// in metrics if it is not nil.
+func NewAdmission(policy AdmissionPolicy, metrics *Metrics) *Admission {
+	procs := runtime.GOMAXPROCS(0)
+	a := &Admission{
+		policy: policy,
+		max:    policy.MaxConcurrency,
+		shed:   make(map[string]uint64),
+	}
+	if a.max <= 0 {
+		a.max = 4 * procs
+	}
+	a.min = min(max(1, procs/2), a.max)
+	a.limit = min(procs, a.max)
+
+	if metrics != nil {
+		metrics.mutex.Lock()
+		metrics.admission = a
+		metrics.mutex.Unlock()
+	}
+	return a
+}
+
+// Do runs verify once a slot is free, or returns an error wrapping
+// ErrOverloaded without running it if none frees up in time or ctx ends
+// first. A nil Admission runs verify straight away.
+func (a *Admission) Do(ctx context.Context, verify func() error) error {
+	if a == nil {
+		return verify()
+	}
+	if err := a.acquire(ctx); err != nil {
+		return err
+	}
+	start := time.Now()
+	err := verify()
+	a.release(time.Since(start))
+	return err
+}
+
+func (a *Admission) acquire(ctx context.Context) error {
+	a.mutex.Lock()
+	if a.inFlight < a.limit && len(a.waiters) == 0 {
+		a.inFlight++
+		a.admitted++
+		a.mutex.Unlock()
+		return nil
+	}
+	a.saturated = true
+	if len(a.waiters) >= a.policy.Queue {
+		a.shed[ShedQueueFull]++
+		a.mutex.Unlock()
+		return fmt.Errorf("%w: %d running, %d queued", ErrOverloaded, a.inFlight, len(a.waiters))
+	}
+	ready := make(chan struct{})
+	a.waiters = append(a.waiters, ready)
+	a.mutex.Unlock()
+
+	timer := time.NewTimer(a.policy.QueueTimeout)
+	defer timer.Stop()
+	select {
+	case <-ready:
+		return nil
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	for i, waiter := range a.waiters {
+		if waiter == ready {
+			a.waiters = append(a.waiters[:i], a.waiters[i+1:]...)
+			a.shed[ShedTimeout]++
+			return fmt.Errorf("%w: no slot within %s", ErrOverloaded, a.policy.QueueTimeout)
+		}
+	}
+	// Admitted just as the wait ended.
+	return nil
+}
+
+func (a *Admission) release(latency time.Duration) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	a.inFlight--
+	a.observe(latency)
+	for a.inFlight < a.limit && len(a.waiters) > 0 {
+		close(a.waiters[0])
+		a.waiters = a.waiters[1:]
+		a.inFlight++
+		a.admitted++
+	}
+}
+
+// observe folds latency into the moving average and, once per limit
+// verifications, moves the limit a step.
+func (a *Admission) observe(latency time.Duration) {
+	if a.smoothed == 0 {
+		a.smoothed = latency
+	} else {
+		a.smoothed += (latency - a.smoothed) / 8
+	}
+	if a.baseline == 0 || a.smoothed < a.baseline {
+		a.baseline = a.smoothed
+	}
+
+	a.completed++
+	if a.completed < a.limit {
+		return
+	}
+	a.baseline += (a.smoothed - a.baseline) / 64
+	switch {
+	case a.smoothed > 2*a.baseline && a.limit > a.min:
+		a.limit--
+	case a.saturated && a.smoothed < a.baseline*5/4 && a.limit < a.max:
+		a.limit++
+	}
+	a.completed, a.saturated = 0, false
+}
+
+// Statistics returns the controller's current limit, load and counts.
+func (a *Admission) Statistics() AdmissionStats {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	shed := make(map[string]uint64, len(a.shed))
+	for reason, count := range a.shed {
+		shed[reason] = count
+	}
+	return AdmissionStats{
+		Limit:    a.limit,
+		InFlight: a.inFlight,
+		Queued:   len(a.waiters),
+		Latency:  a.smoothed,
+		Admitted: a.admitted,
+		Shed:     shed,
+	}
+}
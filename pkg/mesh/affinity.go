@@ -0,0 +1,87 @@
+package mesh
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"sort"
+	"strconv"
+)
+
+// Session affinity modes for calls spread over several replicas of a peer.
+// Client affinity keys calls on the caller's identity, session affinity on a
+// session ID header; either way a key keeps reaching the same replica, so
+// stateful backends and per-peer sessions are not rebuilt on every call.
+const (
+	AffinityOff     = "off"
+	AffinityClient  = "client"
+	AffinitySession = "session"
+)
+
+// DefaultAffinityHeader carries the session ID for session affinity.
+const DefaultAffinityHeader = "X-Session-ID"
+
+// ringPoints is how many points each replica has on the ring; more points
+// spread keys more evenly.
+const ringPoints = 128
+
+// HashRing maps keys to replicas by consistent hashing: adding or removing
+// a replica only moves the keys on its share of the ring.
+type HashRing struct {
+	points   []uint64
+	replicas map[uint64]string
+}
+
+func NewHashRing(replicas []string) *HashRing {
+	ring := &HashRing{replicas: make(map[uint64]string, len(replicas)*ringPoints)}
+	for _, replica := range replicas {
+		for i := 0; i < ringPoints; i++ {
+			point := ringHash(replica + "#" + strconv.Itoa(i))
+			ring.points = append(ring.points, point)
+			ring.replicas[point] = replica
+		}
+	}
+	sort.Slice(ring.points, func(i, j int) bool { return ring.points[i] < ring.points[j] })
+	return ring
+}
+
+// Pick returns the replica for key: the first point at or after its hash.
+func (r *HashRing) Pick(key string) string {
+	if len(r.points) == 0 {
+		return ""
+	}
+	hash := ringHash(key)
+	i := sort.Search(len(r.points), func(i int) bool { return r.points[i] >= hash })
+	if i == len(r.points) {
+		i = 0
+	}
+	return r.replicas[r.points[i]]
+}
+
+// PickAvailable returns the replica for key among those available reports
+// true for: the first such replica at or after key's hash. If none is
+// available it returns Pick's choice, since a replica that may be down
+// beats none at all.
+func (r *HashRing) PickAvailable(key string, available func(replica string) bool) string {
+	if len(r.points) == 0 {
+		return ""
+	}
+	hash := ringHash(key)
+	start := sort.Search(len(r.points), func(i int) bool { return r.points[i] >= hash })
+	checked := make(map[string]bool)
+	for i := 0; i < len(r.points); i++ {
+		replica := r.replicas[r.points[(start+i)%len(r.points)]]
+		if checked[replica] {
+			continue
+		}
+		if available(replica) {
+			return replica
+		}
+		checked[replica] = true
+	}
+	return r.replicas[r.points[start%len(r.points)]]
+}
+
+func ringHash(value string) uint64 {
+	digest := sha256.Sum256([]byte(value))
+	return binary.BigEndian.Uint64(digest[:8])
+}
@@ -0,0 +1,152 @@
+package mesh
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"quantum-safe-mesh/pkg/models"
+)
+
+// The gateway's external API is versioned apart from the backends behind
+// it. Each version names the upstream that serves it and, optionally,
+// filters that translate between the two, so a new backend can serve an old
+// version and an old backend a new one. Clients pick a version with a path
+// prefix, as in /v2/orders, or the Accept-Version header.
+
+// APIVersion is one version of the gateway's external API.
+type APIVersion struct {
+	Name string
+	// Upstream is the service ID serving the version. URL is its address,
+	// unless it is the gateway's own upstream or found by discovery.
+	Upstream string
+	URL      string
+	// Filters run on the version's requests and responses, outside the
+	// route's own filters.
+	Filters FilterChain
+}
+
+// APIVersions are the versions of the gateway's external API.
+type APIVersions struct {
+	versions map[string]*APIVersion
+	ordered  []*APIVersion
+	fallback *APIVersion // for requests that name no version, if any
+}
+
+// ParseAPIVersions parses comma-separated version=service-id[@url] pairs,
+// each optionally followed by |filter|filter, and the name of the version
+// requests that name none get, if any. It returns nil if there are no
+// versions.
+func ParseAPIVersions(value, fallback string) (*APIVersions, error) {
+	return parseAPIVersions(value, fallback, nil)
+}
+
+// CheckAPIVersions parses value and fallback as ParseAPIVersions would,
+// also accepting the names of filters that are only registered when the
+// gateway starts. The versions it returns are for checking only, as those
+// filters are missing from their chains.
+func CheckAPIVersions(value, fallback string, pending []string) (*APIVersions, error) {
+	names := make(map[string]bool, len(pending))
+	for _, name := range pending {
+		names[name] = true
+	}
+	return parseAPIVersions(value, fallback, names)
+}
+
+func parseAPIVersions(value, fallback string, pending map[string]bool) (*APIVersions, error) {
+	filtersMutex.RLock()
+	defer filtersMutex.RUnlock()
+
+	versions := &APIVersions{versions: make(map[string]*APIVersion)}
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		name, target, found := strings.Cut(entry, "=")
+		name = strings.TrimSpace(name)
+		if !found || !validVersionName(name) {
+			return nil, fmt.Errorf("invalid API version %q: expected version=service-id[@url][|filter|filter]", entry)
+		}
+		if versions.versions[name] != nil {
+			return nil, fmt.Errorf("API version %s is listed twice", name)
+		}
+
+		parts := strings.Split(target, "|")
+		upstream, address, hasURL := strings.Cut(strings.TrimSpace(parts[0]), "@")
+		if err := ValidateServiceID(upstream); err != nil {
+			return nil, fmt.Errorf("invalid upstream for API version %s: %w", name, err)
+		}
+		if hasURL {
+			parsed, err := url.Parse(address)
+			if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+				return nil, fmt.Errorf("invalid upstream URL %q for API version %s", address, name)
+			}
+		}
+
+		version := &APIVersion{Name: name, Upstream: upstream, URL: address}
+		if len(parts) > 1 {
+			chain, err := lookupFilters(parts[1:], pending)
+			if err != nil {
+				return nil, fmt.Errorf("%w for API version %s", err, name)
+			}
+			version.Filters = chain
+		}
+		versions.versions[name] = version
+		versions.ordered = append(versions.ordered, version)
+	}
+
+	if fallback != "" {
+		if versions.fallback = versions.versions[fallback]; versions.fallback == nil {
+			return nil, fmt.Errorf("default API version %q is not listed", fallback)
+		}
+	}
+	if len(versions.ordered) == 0 {
+		return nil, nil
+	}
+	return versions, nil
+}
+
+// validVersionName reports whether name can be a path segment as it is.
+func validVersionName(name string) bool {
+	if name == "" || name == "." || name == ".." {
+		return false
+	}
+	for _, c := range name {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '.' || c == '-' || c == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+// All returns the versions in the order they were listed.
+func (v *APIVersions) All() []*APIVersion {
+	return v.ordered
+}
+
+// Select returns the version r asks for, and r's path without the version
+// prefix. A path prefix takes precedence over the Accept-Version header;
+// requests with neither get the default version, or nil if there is none.
+// Asking for a version that isn't listed is an error.
+func (v *APIVersions) Select(r *http.Request) (*APIVersion, string, error) {
+	segment, rest, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if version := v.versions[segment]; version != nil {
+		return version, "/" + rest, nil
+	}
+
+	if name := strings.TrimSpace(r.Header.Get(models.HeaderAcceptAPIVersion)); name != "" {
+		version := v.versions[name]
+		if version == nil {
+			names := make([]string, len(v.ordered))
+			for i, version := range v.ordered {
+				names[i] = version.Name
+			}
+			return nil, "", fmt.Errorf("unsupported API version %q: expected one of %s", name, strings.Join(names, ", "))
+		}
+		return version, r.URL.Path, nil
+	}
+	return v.fallback, r.URL.Path, nil
+}
@@ -0,0 +1,98 @@
+package mesh
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"mime"
+	"os"
+	"path/filepath"
+	"time"
+
+	"quantum-safe-mesh/pkg/models"
+	"quantum-safe-mesh/pkg/pqc"
+)
+
+// DigestFile returns the SHA-256, size and, from its extension, media type
+// of the file at path, reading it as a stream so artifacts of any size can
+// be attested.
+func DigestFile(path string) (models.ContentDigest, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return models.ContentDigest{}, err
+	}
+	defer file.Close()
+
+	digest := sha256.New()
+	size, err := io.Copy(digest, file)
+	if err != nil {
+		return models.ContentDigest{}, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	return models.ContentDigest{
+		SHA256:      hex.EncodeToString(digest.Sum(nil)),
+		Size:        size,
+		ContentType: mime.TypeByExtension(filepath.Ext(path)),
+	}, nil
+}
+
+// Attest signs an attestation that the artifact named subject has digest,
+// as a statement of predicateType, which may be empty.
+func (id *Identity) Attest(subject string, digest models.ContentDigest, predicateType string) (*models.Attestation, error) {
+	attestation := &models.Attestation{
+		Subject:       subject,
+		Digest:        digest,
+		PredicateType: predicateType,
+		Signer:        id.ServiceID,
+		KeyID:         id.KeyID(),
+		Algorithm:     pqc.EnvelopeAlg(id.Signer),
+		IssuedAt:      time.Now().UTC().Truncate(time.Second),
+	}
+
+	payload, err := pqc.CanonicalJSON(attestation.Unsigned())
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode attestation: %w", err)
+	}
+	if attestation.Signature, err = id.Signer.Sign(payload); err != nil {
+		return nil, fmt.Errorf("failed to sign attestation: %w", err)
+	}
+	return attestation, nil
+}
+
+// VerifyAttestation checks attestation is signed with publicKey, which
+// must be the key it names, and that digest, of the artifact at hand,
+// matches the one it attests to.
+func VerifyAttestation(attestation *models.Attestation, publicKey []byte, digest models.ContentDigest) error {
+	if err := pqc.CheckKeyID(publicKey, attestation.KeyID); err != nil {
+		return err
+	}
+	if err := pqc.VerifyCanonical(attestation.Algorithm, publicKey, attestation.Unsigned(), attestation.Signature); err != nil {
+		return fmt.Errorf("attestation signature verification failed: %w", err)
+	}
+	if digest.SHA256 != attestation.Digest.SHA256 || digest.Size != attestation.Digest.Size {
+		return fmt.Errorf("artifact has SHA-256 %s (%d bytes), but %s attested to %s (%d bytes)",
+			digest.SHA256, digest.Size, attestation.Signer, attestation.Digest.SHA256, attestation.Digest.Size)
+	}
+	return nil
+}
+
+// AttestationKey returns the key keyID names among registration's current
+// key and the keys its rotation records show it used before, which must
+// lead to the current key. Artifacts outlive signing keys, so an
+// attestation signed before a rotation still verifies; superseded is when
+// the key was rotated away from, and zero for the current key.
+func AttestationKey(registration *models.PublicKeyResponse, keyID string) (publicKey []byte, superseded time.Time, err error) {
+	if pqc.KeyFingerprint(registration.PublicKey) == keyID {
+		return registration.PublicKey, time.Time{}, nil
+	}
+	if err := pqc.VerifyRotationChain(registration.ServiceID, registration.Rotations, registration.PublicKey); err != nil {
+		return nil, time.Time{}, fmt.Errorf("rotation records of %s do not verify: %w", registration.ServiceID, err)
+	}
+	for _, record := range registration.Rotations {
+		if pqc.KeyFingerprint(record.OldPublicKey) == keyID {
+			return record.OldPublicKey, record.RotatedAt, nil
+		}
+	}
+	return nil, time.Time{}, fmt.Errorf("key %s is neither %s's registered key %s nor one it rotated from",
+		keyID, registration.ServiceID, pqc.KeyFingerprint(registration.PublicKey))
+}
@@ -0,0 +1,108 @@
+package mesh
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Audit event types.
+const (
+	AuditRegistered           = "registered"
+	AuditRegistrationRejected = "registration_rejected"
+	AuditRotated              = "rotated"
+	AuditRevoked              = "revoked"
+	AuditVerificationFailed   = "verification_failed"
+	AuditExpired              = "expired"
+)
+
+// auditLogSize is how many events an AuditLog keeps.
+const auditLogSize = 256
+
+// AuditEvent is a security-relevant event: a change to the registry, or a
+// signature that was rejected. Subject is the service the event concerns
+// and Actor the one that caused it, when they differ.
+type AuditEvent struct {
+	Time    time.Time `json:"time"`
+	Service string    `json:"service"` // service that recorded the event
+	Type    string    `json:"type"`
+	Subject string    `json:"subject,omitempty"`
+	Actor   string    `json:"actor,omitempty"`
+	KeyID   string    `json:"key_id,omitempty"`
+	Detail  string    `json:"detail,omitempty"`
+}
+
+// AuditLog keeps a service's most recent audit events in memory for the
+// /audit endpoint. Older events are dropped; the service log keeps them all.
+type AuditLog struct {
+	serviceID string
+	mutex     sync.Mutex
+	events    []AuditEvent
+	next      int
+	observers []func(AuditEvent)
+}
+
+func NewAuditLog(serviceID string) *AuditLog {
+	return &AuditLog{serviceID: serviceID, events: make([]AuditEvent, 0, auditLogSize)}
+}
+
+// Observe has observer called with every event recorded from now on, after
+// it is kept. Observers must not block.
+func (a *AuditLog) Observe(observer func(AuditEvent)) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.observers = append(a.observers, observer)
+}
+
+// Record adds event, stamped with the time and this service's ID.
+func (a *AuditLog) Record(event AuditEvent) {
+	event.Time = time.Now().UTC()
+	event.Service = a.serviceID
+
+	a.mutex.Lock()
+	if len(a.events) < auditLogSize {
+		a.events = append(a.events, event)
+	} else {
+		a.events[a.next] = event
+		a.next = (a.next + 1) % auditLogSize
+	}
+	observers := a.observers
+	a.mutex.Unlock()
+
+	for _, observer := range observers {
+		observer(event)
+	}
+}
+
+// Recent returns up to limit of the latest events, oldest first. A limit of
+// zero or less returns every event kept.
+func (a *AuditLog) Recent(limit int) []AuditEvent {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	events := make([]AuditEvent, 0, len(a.events))
+	events = append(events, a.events[a.next:]...)
+	events = append(events, a.events[:a.next]...)
+	if limit > 0 && len(events) > limit {
+		events = events[len(events)-limit:]
+	}
+	return events
+}
+
+// Handler serves the latest events as JSON, as many as the limit query
+// parameter asks for.
+func (a *AuditLog) Handler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+		events := a.Recent(limit)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"service": a.serviceID,
+			"events":  events,
+			"count":   len(events),
+		})
+	}
+}
@@ -0,0 +1,31 @@
+package mesh
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+)
+
+// MaxResponseBodySize bounds a peer's response to a signed call, which is
+// read whole to verify its signature. Responses may be larger than the
+// requests they answer, so it is well above MaxRequestBodySize.
+const MaxResponseBodySize = 64 << 20
+
+// ReadBody reads body whole, failing with an *http.MaxBytesError if it is
+// longer than limit. length, the declared length or -1 if unknown, sizes
+// the buffer up front, so a body is read into one allocation rather than
+// one grown, and copied, as it fills.
+func ReadBody(body io.Reader, length, limit int64) ([]byte, error) {
+	size := int64(bytes.MinRead)
+	if length > 0 {
+		size += min(length, limit)
+	}
+	buf := bytes.NewBuffer(make([]byte, 0, size))
+	if _, err := buf.ReadFrom(io.LimitReader(body, limit+1)); err != nil {
+		return nil, err
+	}
+	if int64(buf.Len()) > limit {
+		return nil, &http.MaxBytesError{Limit: limit}
+	}
+	return buf.Bytes(), nil
+}
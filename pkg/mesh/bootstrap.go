@@ -0,0 +1,98 @@
+package mesh
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"quantum-safe-mesh/pkg/pqc"
+)
+
+// Bootstrap token policies for the auth service. Under BootstrapRequired a
+// service's first registration, and any registration that changes its key,
+// must carry a token from a trusted issuer.
+const (
+	BootstrapOff      = "off"
+	BootstrapOptional = "optional"
+	BootstrapRequired = "required"
+)
+
+// BootstrapClaims is the payload of a bootstrap token: Issuer vouches that
+// Subject may register until ExpiresAt.
+type BootstrapClaims struct {
+	Issuer    string `json:"iss"`
+	Subject   string `json:"sub"`
+	IssuedAt  int64  `json:"iat"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+func (c *BootstrapClaims) Expiry() time.Time {
+	return time.Unix(c.ExpiresAt, 0)
+}
+
+// IssueBootstrapToken returns a token letting serviceID register within ttl.
+// It is a compact JWS with an attached payload, signed by id, so the auth
+// service can check it against id's registered key.
+func (id *Identity) IssueBootstrapToken(serviceID string, ttl time.Duration) (string, error) {
+	now := time.Now()
+	payload, err := json.Marshal(BootstrapClaims{
+		Issuer:    id.ServiceID,
+		Subject:   serviceID,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(ttl).Unix(),
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal bootstrap claims: %w", err)
+	}
+
+	detached, err := pqc.SignDetached(id.Signer, id.ServiceID, payload)
+	if err != nil {
+		return "", err
+	}
+
+	header, signature, _ := strings.Cut(detached, "..")
+	return header + "." + base64.RawURLEncoding.EncodeToString(payload) + "." + signature, nil
+}
+
+// VerifyBootstrapToken checks token's signature against its issuer's key and
+// its expiry, and returns its claims. Callers decide which issuers to trust.
+func VerifyBootstrapToken(token string, lookup pqc.PublicKeyLookup) (*BootstrapClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("malformed bootstrap token: expected 3 segments, got %d", len(parts))
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode bootstrap claims: %w", err)
+	}
+
+	var claims BootstrapClaims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal bootstrap claims: %w", err)
+	}
+	if claims.Issuer == "" || claims.Subject == "" {
+		return nil, fmt.Errorf("bootstrap token is missing its issuer or subject")
+	}
+
+	publicKey, err := lookup(claims.Issuer)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get issuer public key: %w", err)
+	}
+
+	header, err := pqc.VerifyDetachedJWS(publicKey, parts[0]+".."+parts[2], payload)
+	if err != nil {
+		return nil, fmt.Errorf("invalid bootstrap token signature: %w", err)
+	}
+	if header.Kid != claims.Issuer {
+		return nil, fmt.Errorf("bootstrap token signed as %s but issued by %s", header.Kid, claims.Issuer)
+	}
+
+	if time.Now().After(claims.Expiry()) {
+		return nil, fmt.Errorf("bootstrap token expired at %s", claims.Expiry().Format(time.RFC3339))
+	}
+
+	return &claims, nil
+}
@@ -0,0 +1,250 @@
+package mesh
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"quantum-safe-mesh/pkg/models"
+)
+
+// DefaultCacheMaxEntries bounds how many responses a ResponseCache keeps.
+const DefaultCacheMaxEntries = 1024
+
+// Cache lookup results, as counted in the mesh_cache_lookups_total metric.
+const (
+	CacheHit     = "hit"
+	CacheMiss    = "miss"
+	CacheInvalid = "invalid" // the stored signature no longer verifies
+)
+
+// ResponseCache keeps the backend's verified responses to idempotent reads,
+// signatures included, so the gateway can answer repeats without signing a
+// request or waiting for the backend. Entries are re-verified against the
+// backend's current key whenever they are served. Responses stay signed for
+// the request that fetched them.
+type ResponseCache struct {
+	routes     []cacheRoute
+	maxEntries int
+
+	mutex   sync.Mutex
+	entries map[[sha256.Size]byte]*cacheEntry
+}
+
+type cacheRoute struct {
+	prefix string
+	ttl    time.Duration
+}
+
+type cacheEntry struct {
+	resp     *Response
+	storedAt time.Time
+	expires  time.Time
+}
+
+// CacheKey identifies a cacheable call, and carries the TTL of its route.
+type CacheKey struct {
+	hash [sha256.Size]byte
+	ttl  time.Duration
+}
+
+// ParseResponseCache parses comma-separated /path-prefix=ttl pairs naming
+// the routes to cache and for how long at most. It returns nil if no route
+// is cached.
+func ParseResponseCache(value string, maxEntries int) (*ResponseCache, error) {
+	cache := &ResponseCache{maxEntries: maxEntries, entries: make(map[[sha256.Size]byte]*cacheEntry)}
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		prefix, ttl, found := strings.Cut(entry, "=")
+		if !found || !strings.HasPrefix(prefix, "/") {
+			return nil, fmt.Errorf("invalid cache route %q: expected /path-prefix=ttl", entry)
+		}
+		duration, err := time.ParseDuration(strings.TrimSpace(ttl))
+		if err != nil || duration <= 0 {
+			return nil, fmt.Errorf("invalid cache TTL %q for %s: expected a positive duration", ttl, prefix)
+		}
+		cache.routes = append(cache.routes, cacheRoute{prefix: prefix, ttl: duration})
+	}
+	sort.SliceStable(cache.routes, func(i, j int) bool {
+		return len(cache.routes[i].prefix) > len(cache.routes[j].prefix)
+	})
+
+	if len(cache.routes) == 0 {
+		return nil, nil
+	}
+	return cache, nil
+}
+
+// Key returns the key of call, made by caller (empty for unsigned callers)
+// to the given API version (empty for none), and false if the call may not
+// be cached: it is not a GET or HEAD on a cached route, carries
+// credentials, or asks not to be served from cache.
+func (c *ResponseCache) Key(call *Call, version, caller string) (CacheKey, bool) {
+	path, _, _ := strings.Cut(call.Path, "?")
+	ttl := time.Duration(0)
+	for _, route := range c.routes {
+		if path == route.prefix || strings.HasPrefix(path, strings.TrimSuffix(route.prefix, "/")+"/") {
+			ttl = route.ttl
+			break
+		}
+	}
+	if ttl == 0 {
+		return CacheKey{}, false
+	}
+
+	hash, ok := readKey(call, version, caller)
+	return CacheKey{hash: hash, ttl: ttl}, ok
+}
+
+// readKey hashes a GET or HEAD call, with who made it and to which API
+// version, so that identical reads have the same key. It returns false for
+// other methods and for reads that carry credentials or ask not to be
+// served from cache, which must reach the backend themselves.
+func readKey(call *Call, version, caller string) ([sha256.Size]byte, bool) {
+	var key [sha256.Size]byte
+	if call.Method != http.MethodGet && call.Method != http.MethodHead {
+		return key, false
+	}
+
+	names := make([]string, 0, len(call.Headers))
+	for name, value := range call.Headers {
+		switch http.CanonicalHeaderKey(name) {
+		case "Authorization", "Cookie":
+			return key, false
+		case "Cache-Control", "Pragma":
+			if strings.Contains(value, "no-cache") || strings.Contains(value, "no-store") {
+				return key, false
+			}
+		case http.CanonicalHeaderKey(models.HeaderRequestID), http.CanonicalHeaderKey(models.HeaderParentID):
+			continue
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	// Headers are part of the key, since filters may inject ones the
+	// backend answers differently, e.g. a tenant.
+	hash := sha256.New()
+	fmt.Fprintf(hash, "%s\x00%s\x00%s\x00%s\x00", version, caller, call.Method, call.Path)
+	for _, name := range names {
+		fmt.Fprintf(hash, "%s\x00%s\x00", name, call.Headers[name])
+	}
+	hash.Write(call.Body)
+	hash.Sum(key[:0])
+	return key, true
+}
+
+// Get returns a copy of the response stored under key, and its age.
+func (c *ResponseCache) Get(key CacheKey) (*Response, time.Duration, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	entry, found := c.entries[key.hash]
+	if !found {
+		return nil, 0, false
+	}
+	now := time.Now()
+	if !now.Before(entry.expires) {
+		delete(c.entries, key.hash)
+		return nil, 0, false
+	}
+	return cloneResponse(entry.resp), now.Sub(entry.storedAt), true
+}
+
+// Put stores a copy of resp under key for the route's TTL, or less if the
+// backend's Cache-Control asks for it. Responses the backend marks
+// no-store, no-cache or private are not stored.
+func (c *ResponseCache) Put(key CacheKey, resp *Response) {
+	ttl, ok := responseTTL(resp.Header.Get("Cache-Control"), key.ttl)
+	if !ok {
+		return
+	}
+
+	now := time.Now()
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if _, exists := c.entries[key.hash]; !exists && len(c.entries) >= c.maxEntries {
+		c.evict(now)
+	}
+	c.entries[key.hash] = &cacheEntry{resp: cloneResponse(resp), storedAt: now, expires: now.Add(ttl)}
+}
+
+// Drop removes the response stored under key.
+func (c *ResponseCache) Drop(key CacheKey) {
+	c.mutex.Lock()
+	delete(c.entries, key.hash)
+	c.mutex.Unlock()
+}
+
+// evict drops expired entries or, if there are none, the one expiring
+// soonest.
+func (c *ResponseCache) evict(now time.Time) {
+	var soonest [sha256.Size]byte
+	var soonestExpiry time.Time
+	for hash, entry := range c.entries {
+		if !now.Before(entry.expires) {
+			delete(c.entries, hash)
+			continue
+		}
+		if soonestExpiry.IsZero() || entry.expires.Before(soonestExpiry) {
+			soonest, soonestExpiry = hash, entry.expires
+		}
+	}
+	if len(c.entries) >= c.maxEntries {
+		delete(c.entries, soonest)
+	}
+}
+
+// responseTTL applies a backend's Cache-Control header to the route's TTL.
+func responseTTL(cacheControl string, ttl time.Duration) (time.Duration, bool) {
+	for _, directive := range strings.Split(cacheControl, ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(strings.ToLower(directive)), "=")
+		switch name {
+		case "no-store", "no-cache", "private":
+			return 0, false
+		case "max-age", "s-maxage":
+			seconds, err := strconv.Atoi(strings.Trim(value, `"`))
+			if err != nil || seconds <= 0 {
+				return 0, false
+			}
+			if maxAge := time.Duration(seconds) * time.Second; maxAge < ttl {
+				ttl = maxAge
+			}
+		}
+	}
+	return ttl, true
+}
+
+// cloneResponse copies resp deeply enough that filters and countersigning
+// can change the copy without touching the original.
+func cloneResponse(resp *Response) *Response {
+	clone := *resp
+	clone.Header = resp.Header.Clone()
+	clone.Body = bytes.Clone(resp.Body)
+	clone.Timing = Timing{}
+	if resp.Envelope != nil {
+		envelope := *resp.Envelope
+		envelope.Data = bytes.Clone(resp.Envelope.Data)
+		envelope.Signature = bytes.Clone(resp.Envelope.Signature)
+		envelope.Chain = append(models.SignatureChain(nil), resp.Envelope.Chain...)
+		if resp.Envelope.Headers != nil {
+			envelope.Headers = make(map[string]string, len(resp.Envelope.Headers))
+			for name, value := range resp.Envelope.Headers {
+				envelope.Headers[name] = value
+			}
+		}
+		clone.Envelope = &envelope
+	}
+	return &clone
+}
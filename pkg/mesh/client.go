@@ -0,0 +1,722 @@
+package mesh
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"quantum-safe-mesh/pkg/bufpool"
+	"quantum-safe-mesh/pkg/httpclient"
+	"quantum-safe-mesh/pkg/models"
+	"quantum-safe-mesh/pkg/pqc"
+	"quantum-safe-mesh/pkg/telemetry"
+)
+
+// Signing modes for outbound calls. Envelope mode wraps the body in a signed
+// ServiceRequest; detached mode sends it verbatim and carries a compact JWS
+// in X-Mesh-Signature.
+const (
+	SignatureModeEnvelope = "envelope"
+	SignatureModeDetached = "detached"
+)
+
+// Call is one outbound request to a mesh peer. Peers are always called with
+// POST; Method, when set, is the client's original method and travels signed.
+type Call struct {
+	Method    string
+	Path      string
+	Body      []byte
+	Headers   map[string]string // copied into the envelope's Headers
+	RequestID string
+	ParentID  string
+
+	// IdempotencyKey, if set, is signed into the request, so the peer
+	// answers every call with the same key with the first one's result.
+	IdempotencyKey string
+
+	// Affinity, when the client has replicas, picks the replica: calls
+	// with the same key reach the same one.
+	Affinity string
+
+	// Context, if set, is the context of the request the call is made for:
+	// the call is abandoned when it is done, it parents the call's
+	// telemetry spans, and its trace context is sent to the peer.
+	Context context.Context
+
+	// upstream is the base URL the call was last sent to, if it was sent.
+	upstream string
+}
+
+func (call *Call) context() context.Context {
+	if call.Context != nil {
+		return call.Context
+	}
+	return context.Background()
+}
+
+// abandoned reports whether the caller gave up on call, which says nothing
+// about the peer.
+func (call *Call) abandoned() bool {
+	return errors.Is(call.context().Err(), context.Canceled)
+}
+
+// Response is a peer's verified answer to a Call. In envelope mode Envelope
+// is set; in detached mode Body and Token hold the raw body and its JWS.
+type Response struct {
+	StatusCode int
+	Header     http.Header
+	Envelope   *models.ServiceResponse
+	Body       []byte
+	Token      string
+	Timing     Timing
+}
+
+// SignedClient signs requests to a single peer and verifies its responses
+// against the peer's registered key.
+type SignedClient struct {
+	identity  *Identity
+	registry  *RegistryClient
+	versions  *PeerVersions
+	peerID    string
+	baseURL   string
+	mode      string
+	timeout   time.Duration
+	retries   int
+	resolve   Resolver
+	replicas  *HashRing
+	outliers  *OutlierDetector
+	admission *Admission
+}
+
+func NewSignedClient(identity *Identity, registry *RegistryClient, versions *PeerVersions, peerID, baseURL, mode string) (*SignedClient, error) {
+	if mode != SignatureModeEnvelope && mode != SignatureModeDetached {
+		return nil, fmt.Errorf("invalid signature mode %q: expected %q or %q",
+			mode, SignatureModeEnvelope, SignatureModeDetached)
+	}
+
+	return &SignedClient{
+		identity: identity,
+		registry: registry,
+		versions: versions,
+		peerID:   peerID,
+		baseURL:  baseURL,
+		mode:     mode,
+		timeout:  30 * time.Second,
+		retries:  httpclient.DefaultRetries,
+	}, nil
+}
+
+// UseResolver looks the peer up through resolve on each call, falling back
+// to the base URL the client was created with when it fails.
+func (c *SignedClient) UseResolver(resolve Resolver) {
+	c.resolve = resolve
+}
+
+// UseReplicas spreads calls over the base URLs of the peer's replicas by
+// each call's Affinity, in place of the base URL and resolver.
+func (c *SignedClient) UseReplicas(urls []string) {
+	c.replicas = NewHashRing(urls)
+}
+
+// UseOutlierDetection records how each call went in outliers, and spreads
+// calls over replicas that outliers hasn't ejected.
+func (c *SignedClient) UseOutlierDetection(outliers *OutlierDetector) {
+	c.outliers = outliers
+}
+
+// UseAdmission verifies the peer's responses under admission, which fails
+// calls with 503 overloaded when too many verifications are waiting.
+func (c *SignedClient) UseAdmission(admission *Admission) {
+	c.admission = admission
+}
+
+// SetTimeout bounds each request to the peer, retries included.
+func (c *SignedClient) SetTimeout(timeout time.Duration) {
+	c.timeout = timeout
+}
+
+// SetRetries sets how many times a request that failed transiently is
+// retried, if retrying it is safe.
+func (c *SignedClient) SetRetries(retries int) {
+	c.retries = retries
+}
+
+func (c *SignedClient) Mode() string {
+	return c.mode
+}
+
+func (c *SignedClient) PeerID() string {
+	return c.peerID
+}
+
+// Call sends call to the peer and returns its verified response. Errors the
+// peer reported are relayed as-is with the peer's status code.
+func (c *SignedClient) Call(call *Call) (*Response, *models.ErrorResponse) {
+	start := time.Now()
+	call.upstream = ""
+
+	var resp *Response
+	var errResp *models.ErrorResponse
+	if c.mode == SignatureModeDetached {
+		resp, errResp = c.callDetached(call)
+	} else {
+		resp, errResp = c.callEnvelope(call)
+	}
+
+	if c.outliers != nil && call.upstream != "" && !call.abandoned() {
+		c.outliers.Record(c.peerID, call.upstream, time.Since(start), outlierFailure(errResp))
+	}
+	return resp, errResp
+}
+
+// outlierFailure returns why errResp counts against the peer, or empty if
+// it doesn't: the peer answering 4xx is the caller's doing, and failing to
+// get its key the registry's.
+func outlierFailure(errResp *models.ErrorResponse) string {
+	switch {
+	case errResp == nil, errResp.Code == models.ErrCodeUpstreamAuthenticationFail:
+		return ""
+	case errResp.Status >= http.StatusInternalServerError, errResp.Code == models.ErrCodeUpstreamSignatureInvalid:
+		return errResp.Code
+	}
+	return ""
+}
+
+func (c *SignedClient) callEnvelope(call *Call) (*Response, *models.ErrorResponse) {
+	requestBody := json.RawMessage(call.Body)
+	if len(call.Body) == 0 {
+		// For empty bodies (like GET requests), use empty JSON object
+		requestBody = json.RawMessage("{}")
+	}
+
+	var timing Timing
+	var envelope models.ServiceResponse
+	resp, errResp := c.sendEnvelope(call, requestBody, c.verifyEnvelopeResponse(call, &envelope, &timing), &timing)
+	if errResp != nil {
+		return nil, errResp
+	}
+	defer resp.Body.Close()
+
+	return c.readEnvelope(resp, &envelope, &timing)
+}
+
+// verifyEnvelopeResponse returns the Verify of a SignedRoundTripper that
+// decodes the peer's envelope response to call into envelope and verifies
+// it, adding the time verifying took to timing.
+func (c *SignedClient) verifyEnvelopeResponse(call *Call, envelope *models.ServiceResponse, timing *Timing) func(*http.Response) error {
+	return func(resp *http.Response) error {
+		if err := json.NewDecoder(http.MaxBytesReader(nil, resp.Body, MaxResponseBodySize)).Decode(envelope); err != nil {
+			log.Printf("❌ Failed to decode %s response: %v", c.peerID, err)
+			return callError(call, http.StatusInternalServerError, models.ErrCodeUpstreamInvalidResponse, "Invalid backend response")
+		}
+
+		verifyStart := time.Now()
+		if errResp := c.verifyEnvelope(call, envelope); errResp != nil {
+			return errResp
+		}
+		timing.Verify = time.Since(verifyStart)
+		return nil
+	}
+}
+
+// readEnvelope returns the peer's response, verified into envelope, or
+// relays the error the peer answered with.
+func (c *SignedClient) readEnvelope(resp *http.Response, envelope *models.ServiceResponse, timing *Timing) (*Response, *models.ErrorResponse) {
+	if resp.StatusCode >= http.StatusBadRequest {
+		return nil, c.peerError(resp)
+	}
+
+	log.Printf("✅ %s response verified successfully", c.peerID)
+	return &Response{StatusCode: resp.StatusCode, Header: resp.Header, Envelope: envelope, Timing: *timing}, nil
+}
+
+// sendEnvelope posts requestBody signed into a ServiceRequest, signing each
+// attempt afresh, and checks a successful response with verify. A peer
+// that rejects our protocol version is retried once on 1.0, which every
+// service accepts. Time spent signing and waiting is added to timing.
+func (c *SignedClient) sendEnvelope(call *Call, requestBody json.RawMessage, verify func(*http.Response) error, timing *Timing) (*http.Response, *models.ErrorResponse) {
+	for {
+		version := c.versions.Get(c.peerID)
+
+		req, err := c.newRequest(call, nil)
+		if err != nil {
+			log.Printf("❌ Failed to create request: %v", err)
+			return nil, callError(call, http.StatusInternalServerError, models.ErrCodeInternal, "Internal server error")
+		}
+		req.Header.Set("Content-Type", "application/json")
+
+		sign := func(req *http.Request) error {
+			signedPayload, size, errResp := c.signEnvelope(call, version, requestBody, timing)
+			if errResp != nil {
+				return errResp
+			}
+
+			req.Body = io.NopCloser(signedPayload)
+			req.ContentLength = size
+			return nil
+		}
+
+		resp, errResp := c.send(call, req, sign, verify, timing)
+		if errResp != nil {
+			return nil, errResp
+		}
+
+		c.versions.Record(c.peerID, resp)
+
+		if resp.StatusCode != http.StatusBadRequest || version == models.ProtocolVersion10 {
+			return resp, nil
+		}
+
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		var rejection models.ErrorResponse
+		if json.Unmarshal(body, &rejection) == nil && rejection.Code == models.ErrCodeUnsupportedVersion {
+			log.Printf("⚠️  %s rejected protocol version %s, retrying with %s", c.peerID, version, models.ProtocolVersion10)
+			c.versions.Set(c.peerID, models.ProtocolVersion10)
+			continue
+		}
+
+		resp.Body = io.NopCloser(bytes.NewReader(body))
+		return resp, nil
+	}
+}
+
+// signEnvelope signs requestBody into call's ServiceRequest in protocol
+// version and returns it encoded, and its size, adding the time encoding
+// and signing took to timing. The envelope is marshaled once, to sign it,
+// and sent as that signing payload with the signature filled in.
+func (c *SignedClient) signEnvelope(call *Call, version string, requestBody json.RawMessage, timing *Timing) (io.Reader, int64, *models.ErrorResponse) {
+	requestData := models.ServiceRequest{
+		ProtocolVersion: models.WireProtocolVersion(version),
+		ServiceID:       c.identity.ServiceID,
+		Timestamp:       time.Now(),
+		Data:            requestBody,
+		SignatureAlg:    pqc.EnvelopeAlg(c.identity.Signer),
+		Headers:         make(map[string]string),
+	}
+
+	if models.ProtocolVersionAtLeast(version, models.ProtocolVersion12) {
+		requestData.RequestID = call.RequestID
+		requestData.ParentID = call.ParentID
+		requestData.IdempotencyKey = call.IdempotencyKey
+	} else if call.IdempotencyKey != "" {
+		// Before 1.2 the key rides in the signed headers, so the first
+		// call to a peer, made before it has answered, keeps it too.
+		requestData.Headers[models.HeaderIdempotencyKey] = call.IdempotencyKey
+	}
+
+	for key, value := range call.Headers {
+		requestData.Headers[key] = value
+	}
+	if call.Method != "" {
+		requestData.Headers[models.HeaderOriginalMethod] = call.Method
+	}
+	requestData.Headers[models.HeaderKeyID] = c.identity.KeyID()
+
+	marshalStart := time.Now()
+	requestPayload, err := requestData.SigningPayload()
+	if err != nil {
+		log.Printf("❌ Failed to marshal request: %v", err)
+		return nil, 0, callError(call, http.StatusInternalServerError, models.ErrCodeInternal, "Internal server error")
+	}
+	timing.Marshal += time.Since(marshalStart)
+
+	signStart := time.Now()
+	signature, err := telemetry.SignPooled(call.context(), c.identity.Signer, requestPayload)
+	if err != nil {
+		log.Printf("❌ Failed to sign request: %v", err)
+		return nil, 0, callError(call, http.StatusInternalServerError, models.ErrCodeInternal, "Internal server error")
+	}
+	timing.Sign += time.Since(signStart)
+	// SignedWire encodes a copy of the signature.
+	defer pqc.ReleaseSignature(signature)
+
+	requestData.Signature = signature
+	marshalStart = time.Now()
+	signedPayload, size, err := requestData.SignedWire(requestPayload)
+	if err != nil {
+		log.Printf("❌ Failed to encode request: %v", err)
+		return nil, 0, callError(call, http.StatusInternalServerError, models.ErrCodeInternal, "Internal server error")
+	}
+	timing.Marshal += time.Since(marshalStart)
+	return signedPayload, size, nil
+}
+
+// send sends req to the peer through a SignedRoundTripper that signs each
+// attempt with sign and checks a successful response with verify. Time
+// spent waiting, less what encoding, signing and verifying took, is added
+// to timing, along with the peer's account of its own time.
+func (c *SignedClient) send(call *Call, req *http.Request, sign func(*http.Request) error, verify func(*http.Response) error, timing *Timing) (*http.Response, *models.ErrorResponse) {
+	start, spent := time.Now(), timing.Marshal+timing.Sign+timing.Verify
+	resp, err := c.httpClient(sign, verify).Do(req)
+	timing.Upstream += time.Since(start) - (timing.Marshal + timing.Sign + timing.Verify - spent)
+	if err != nil {
+		return nil, c.sendError(call, err)
+	}
+	timing.Peer = ParseServerTiming(resp.Header.Get(models.HeaderServerTiming))
+	return resp, nil
+}
+
+// sendError returns the error to relay for a call that failed with err.
+func (c *SignedClient) sendError(call *Call, err error) *models.ErrorResponse {
+	var errResp *models.ErrorResponse
+	var netErr net.Error
+	switch {
+	case errors.As(err, &errResp):
+		return errResp
+	case errors.Is(err, context.Canceled):
+		log.Printf("⚠️  %s request abandoned: %v", c.peerID, err)
+		return callError(call, http.StatusServiceUnavailable, models.ErrCodeUpstreamUnavailable, "Request cancelled")
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		log.Printf("❌ %s request timed out: %v", c.peerID, err)
+		return callError(call, http.StatusGatewayTimeout, models.ErrCodeTimeout, "Backend service timed out")
+	}
+	log.Printf("❌ %s request failed: %v", c.peerID, err)
+	return callError(call, http.StatusServiceUnavailable, models.ErrCodeUpstreamUnavailable, "Backend service unavailable")
+}
+
+// httpClient returns a client sending to the peer through a
+// SignedRoundTripper with sign and verify.
+func (c *SignedClient) httpClient(sign func(*http.Request) error, verify func(*http.Response) error) *http.Client {
+	return &http.Client{
+		Transport: &httpclient.SignedRoundTripper{Sign: sign, Verify: verify, Retries: c.retries},
+		Timeout:   c.timeout,
+	}
+}
+
+func (c *SignedClient) verifyEnvelope(call *Call, envelope *models.ServiceResponse) *models.ErrorResponse {
+	if errResp := c.verifyEnvelopeSignatures(call, envelope); errResp != nil {
+		return errResp
+	}
+
+	if models.ProtocolVersionAtLeast(envelope.ProtocolVersion, models.ProtocolVersion12) && envelope.RequestID != call.RequestID {
+		log.Printf("❌ %s response is for request %s, expected %s", c.peerID, envelope.RequestID, call.RequestID)
+		return callError(call, http.StatusUnauthorized, models.ErrCodeUpstreamSignatureInvalid, "Backend response does not match request")
+	}
+	return nil
+}
+
+// verifyEnvelopeSignatures checks the peer's signature on envelope and its
+// signature chain, whatever request it answers.
+func (c *SignedClient) verifyEnvelopeSignatures(call *Call, envelope *models.ServiceResponse) *models.ErrorResponse {
+	peerPublicKey, err := c.registry.ResolveKeyContext(call.context(), c.peerID, envelope.Headers[models.HeaderKeyID])
+	if err != nil {
+		log.Printf("❌ Failed to get %s public key: %v", c.peerID, err)
+		return callError(call, http.StatusInternalServerError, models.ErrCodeUpstreamAuthenticationFail, "Authentication error")
+	}
+
+	var errResp *models.ErrorResponse
+	if err := c.admission.Do(call.context(), func() error {
+		errResp = c.checkEnvelopeSignatures(call, envelope, peerPublicKey)
+		return nil
+	}); err != nil {
+		return c.shed(call, err)
+	}
+	return errResp
+}
+
+// checkEnvelopeSignatures checks envelope's signature against
+// peerPublicKey, and its signature chain.
+func (c *SignedClient) checkEnvelopeSignatures(call *Call, envelope *models.ServiceResponse, peerPublicKey []byte) *models.ErrorResponse {
+	payload := bufpool.Get()
+	defer bufpool.Put(payload)
+	if err := envelope.WriteSigningPayload(payload); err != nil {
+		log.Printf("❌ Failed to reconstruct %s signing payload: %v", c.peerID, err)
+		return callError(call, http.StatusInternalServerError, models.ErrCodeUpstreamInvalidResponse, "Invalid backend response")
+	}
+
+	if err := telemetry.Verify(call.context(), envelope.SignatureAlg, peerPublicKey, payload.Bytes(), envelope.Signature); err != nil {
+		log.Printf("❌ %s response signature verification failed: %v", c.peerID, err)
+		return callError(call, http.StatusUnauthorized, models.ErrCodeUpstreamSignatureInvalid, "Invalid backend signature")
+	}
+
+	if len(envelope.Chain) == 0 {
+		return nil
+	}
+	chainPayload := bufpool.Get()
+	defer bufpool.Put(chainPayload)
+	if err := envelope.WriteChainPayload(chainPayload); err != nil {
+		log.Printf("❌ Failed to reconstruct %s chain payload: %v", c.peerID, err)
+		return callError(call, http.StatusInternalServerError, models.ErrCodeInternal, "Internal server error")
+	}
+
+	if err := pqc.VerifySignatureChainWith(envelope.Chain, chainPayload.Bytes(), pqc.ResolverWithContext(call.context(), c.registry)); err != nil {
+		log.Printf("❌ %s response signature chain verification failed: %v", c.peerID, err)
+		return callError(call, http.StatusUnauthorized, models.ErrCodeUpstreamSignatureInvalid, "Invalid backend signature chain")
+	}
+
+	return nil
+}
+
+// Countersign appends this identity to a verified response's signature
+// chain, recording that the response passed through, and was checked by, us.
+func (c *SignedClient) Countersign(envelope *models.ServiceResponse) error {
+	chainPayload := bufpool.Get()
+	defer bufpool.Put(chainPayload)
+	if err := envelope.WriteChainPayload(chainPayload); err != nil {
+		return fmt.Errorf("failed to marshal chain payload: %w", err)
+	}
+
+	var err error
+	envelope.Chain, err = pqc.AppendToChain(c.identity.Signer, envelope.Chain, c.identity.ServiceID, chainPayload.Bytes())
+	return err
+}
+
+// Resign signs a verified response as this identity instead of the peer,
+// after it was changed, e.g. by a gateway filter, so the peer's signature no
+// longer covers it.
+func (c *SignedClient) Resign(resp *Response) error {
+	if resp.Envelope == nil {
+		header, _, err := pqc.ParseDetachedJWS(resp.Token)
+		if err != nil {
+			return fmt.Errorf("failed to parse response signature: %w", err)
+		}
+		resp.Token, err = pqc.SignDetachedWithHeader(c.identity.Signer, pqc.JWSHeader{
+			Kid: c.identity.ServiceID,
+			Rid: header.Rid,
+			Pid: header.Pid,
+		}, resp.Body)
+		return err
+	}
+
+	envelope := resp.Envelope
+	envelope.ServiceID = c.identity.ServiceID
+	envelope.Timestamp = time.Now()
+	envelope.SignatureAlg = pqc.EnvelopeAlg(c.identity.Signer)
+	envelope.Chain = nil
+	if envelope.Headers != nil {
+		envelope.Headers[models.HeaderKeyID] = c.identity.KeyID()
+	}
+
+	signingPayload, err := envelope.SigningPayload()
+	if err != nil {
+		return fmt.Errorf("failed to marshal response for signing: %w", err)
+	}
+	envelope.Signature, err = telemetry.Sign(context.Background(), c.identity.Signer, signingPayload)
+	return err
+}
+
+// callDetached sends the body verbatim with a detached JWS and verifies the
+// peer's detached response signature.
+func (c *SignedClient) callDetached(call *Call) (*Response, *models.ErrorResponse) {
+	var timing Timing
+
+	req, err := c.newRequest(call, bytes.NewReader(call.Body))
+	if err != nil {
+		log.Printf("❌ Failed to create request: %v", err)
+		return nil, callError(call, http.StatusInternalServerError, models.ErrCodeInternal, "Internal server error")
+	}
+	req.Header.Set("Content-Type", call.Headers["Content-Type"])
+
+	sign := func(req *http.Request) error {
+		signStart := time.Now()
+		_, signed := telemetry.Observe(call.context(), telemetry.OpSign, c.identity.Signer.Algorithm())
+		token, err := pqc.SignDetachedWithHeader(c.identity.Signer, pqc.JWSHeader{
+			Kid: c.identity.ServiceID,
+			Rid: call.RequestID,
+			Pid: call.ParentID,
+			Htm: call.Method,
+			Idk: call.IdempotencyKey,
+		}, call.Body)
+		signed(err)
+		if err != nil {
+			log.Printf("❌ Failed to sign request: %v", err)
+			return callError(call, http.StatusInternalServerError, models.ErrCodeInternal, "Internal server error")
+		}
+		timing.Sign += time.Since(signStart)
+
+		req.Header.Set(models.HeaderMeshSignature, token)
+		return nil
+	}
+
+	var responseBody []byte
+	var responseToken string
+	verify := func(resp *http.Response) error {
+		body, err := ReadBody(resp.Body, resp.ContentLength, MaxResponseBodySize)
+		if err != nil {
+			log.Printf("❌ Failed to read %s response: %v", c.peerID, err)
+			return callError(call, http.StatusInternalServerError, models.ErrCodeUpstreamInvalidResponse, "Invalid backend response")
+		}
+
+		token := resp.Header.Get(models.HeaderMeshSignature)
+		if token == "" {
+			log.Printf("❌ %s response missing %s header (status %d)", c.peerID, models.HeaderMeshSignature, resp.StatusCode)
+			return callError(call, http.StatusUnauthorized, models.ErrCodeUpstreamSignatureInvalid, "Invalid backend signature")
+		}
+
+		verifyStart := time.Now()
+		if errResp := c.verifyDetached(call, token, body, call.RequestID); errResp != nil {
+			return errResp
+		}
+		timing.Verify = time.Since(verifyStart)
+
+		responseBody, responseToken = body, token
+		return nil
+	}
+
+	resp, errResp := c.send(call, req, sign, verify, &timing)
+	if errResp != nil {
+		return nil, errResp
+	}
+	defer resp.Body.Close()
+
+	c.versions.Record(c.peerID, resp)
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		return nil, c.peerError(resp)
+	}
+
+	log.Printf("✅ %s response verified successfully", c.peerID)
+	return &Response{StatusCode: resp.StatusCode, Header: resp.Header, Body: responseBody, Token: responseToken, Timing: timing}, nil
+}
+
+// verifyDetached checks token is the peer's signature on body for the
+// request requestID.
+func (c *SignedClient) verifyDetached(call *Call, token string, body []byte, requestID string) *models.ErrorResponse {
+	peerPublicKey, err := c.registry.PublicKeyContext(call.context(), c.peerID)
+	if err != nil {
+		log.Printf("❌ Failed to get %s public key: %v", c.peerID, err)
+		return callError(call, http.StatusInternalServerError, models.ErrCodeUpstreamAuthenticationFail, "Authentication error")
+	}
+
+	// Cached along with the key just looked up.
+	algorithm, _ := c.registry.Algorithm(c.peerID)
+	var header *pqc.JWSHeader
+	if shedErr := c.admission.Do(call.context(), func() error {
+		_, verified := telemetry.Observe(call.context(), telemetry.OpVerify, algorithm)
+		header, err = pqc.VerifyDetachedJWS(peerPublicKey, token, body)
+		verified(err)
+		return nil
+	}); shedErr != nil {
+		return c.shed(call, shedErr)
+	}
+	if err == nil && (header.Kid != c.peerID || header.Rid != requestID) {
+		err = fmt.Errorf("signed for %s/%s, expected %s/%s", header.Kid, header.Rid, c.peerID, requestID)
+	}
+	if err != nil {
+		log.Printf("❌ %s response signature verification failed: %v", c.peerID, err)
+		return callError(call, http.StatusUnauthorized, models.ErrCodeUpstreamSignatureInvalid, "Invalid backend signature")
+	}
+	return nil
+}
+
+// Reverify checks a response kept from an earlier call, e.g. by a response
+// cache, is still signed by the peer's current key. It stays bound to the
+// request that fetched it, not to call.
+func (c *SignedClient) Reverify(call *Call, resp *Response) *models.ErrorResponse {
+	if resp.Envelope != nil {
+		return c.verifyEnvelopeSignatures(call, resp.Envelope)
+	}
+
+	header, _, err := pqc.ParseDetachedJWS(resp.Token)
+	if err != nil {
+		log.Printf("❌ %s cached response signature is malformed: %v", c.peerID, err)
+		return callError(call, http.StatusUnauthorized, models.ErrCodeUpstreamSignatureInvalid, "Invalid backend signature")
+	}
+	return c.verifyDetached(call, resp.Token, resp.Body, header.Rid)
+}
+
+func (c *SignedClient) newRequest(call *Call, body io.Reader) (*http.Request, error) {
+	call.upstream = c.peerURL(call)
+	req, err := http.NewRequestWithContext(call.context(), "POST", call.upstream+call.Path, body)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("X-Service-ID", c.identity.ServiceID)
+	req.Header.Set(models.HeaderAcceptVersion, models.AcceptVersionHeader())
+	req.Header.Set(models.HeaderRequestID, call.RequestID)
+	req.Header.Set(models.HeaderParentID, call.ParentID)
+	if call.IdempotencyKey != "" {
+		// Unsigned, and only read by the transport, to know retrying the
+		// call is safe: the peer answers a repeated key with its first
+		// result.
+		req.Header.Set(models.HeaderIdempotencyKey, call.IdempotencyKey)
+	}
+	return req, nil
+}
+
+// peerURL returns the base URL of the peer, or of the replica, to send
+// call to.
+func (c *SignedClient) peerURL(call *Call) string {
+	if c.replicas == nil {
+		return resolveURL(c.resolve, c.peerID, c.baseURL)
+	}
+	key := call.Affinity
+	if key == "" {
+		key = call.RequestID
+	}
+	if c.outliers != nil {
+		return c.replicas.PickAvailable(key, c.outliers.Available)
+	}
+	return c.replicas.Pick(key)
+}
+
+// ForwardPublic sends call to the peer with its original method and
+// headers, but neither signed nor naming this service, for paths the peer
+// serves to anyone. The response is relayed unverified. Mesh headers the
+// client set are dropped, so it cannot pose as a mesh service.
+func (c *SignedClient) ForwardPublic(call *Call) (*http.Response, error) {
+	upstream := c.peerURL(call)
+	req, err := http.NewRequestWithContext(call.context(), call.Method, upstream+call.Path, bytes.NewReader(call.Body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	for name, value := range call.Headers {
+		name = http.CanonicalHeaderKey(name)
+		if strings.HasPrefix(name, "X-Mesh-") || name == "X-Service-Id" || name == "X-Signature" {
+			continue
+		}
+		req.Header.Set(name, value)
+	}
+	req.Header.Set(models.HeaderRequestID, call.RequestID)
+	req.Header.Set(models.HeaderParentID, call.ParentID)
+
+	start := time.Now()
+	resp, err := c.httpClient(nil, nil).Do(req)
+	if c.outliers != nil && !call.abandoned() {
+		failure := ""
+		if err != nil {
+			failure = models.ErrCodeUpstreamUnavailable
+		} else if resp.StatusCode >= http.StatusInternalServerError {
+			failure = fmt.Sprintf("status %d", resp.StatusCode)
+		}
+		c.outliers.Record(c.peerID, upstream, time.Since(start), failure)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to forward to %s: %w", c.peerID, err)
+	}
+	return resp, nil
+}
+
+// peerError decodes an ErrorResponse the peer returned so it can be relayed
+// with the peer's status, letting clients see the root-cause code.
+func (c *SignedClient) peerError(resp *http.Response) *models.ErrorResponse {
+	errResp := models.DecodeErrorResponse(resp)
+	log.Printf("❌ %s returned error (status %d): %v", c.peerID, resp.StatusCode, errResp)
+	return errResp
+}
+
+// shed is the error for a call whose response admission control shed
+// before verifying it.
+func (c *SignedClient) shed(call *Call, err error) *models.ErrorResponse {
+	log.Printf("⚠️  Shedding %s response: %v", c.peerID, err)
+	errResp := callError(call, http.StatusServiceUnavailable, models.ErrCodeOverloaded, "Service overloaded")
+	errResp.RetryAfter = 1
+	return errResp
+}
+
+func callError(call *Call, status int, code, message string) *models.ErrorResponse {
+	errResp := models.NewError(status, code, message)
+	errResp.RequestID = call.RequestID
+	return errResp
+}
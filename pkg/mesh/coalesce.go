@@ -0,0 +1,68 @@
+package mesh
+
+import (
+	"context"
+	"crypto/sha256"
+	"log"
+	"net/http"
+
+	"quantum-safe-mesh/pkg/models"
+)
+
+// Coalescer shares one backend call among identical reads that are in
+// flight at the same time, so a burst of them, e.g. after a restart, costs
+// the backend one. Readers that join get a copy of the first reader's
+// response, still signed for its request, as cached responses are.
+type Coalescer struct {
+	flights flightGroup[[sha256.Size]byte, coalescedResult]
+}
+
+type coalescedResult struct {
+	resp    *Response
+	errResp *models.ErrorResponse
+}
+
+func NewCoalescer() *Coalescer {
+	return &Coalescer{}
+}
+
+// Call sends call with send, unless an identical read by caller to the
+// given API version is already in flight, in which case it waits for that
+// call's result. It reports whether the result was shared.
+func (c *Coalescer) Call(call *Call, version, caller string, send func(*Call) (*Response, *models.ErrorResponse)) (*Response, *models.ErrorResponse, bool) {
+	key, ok := readKey(call, version, caller)
+	if !ok {
+		resp, errResp := send(call)
+		return resp, errResp, false
+	}
+
+	var own *Response
+	result, err, shared := c.flights.do(call.context(), key, func() (coalescedResult, error) {
+		// The others wait on this call, so this caller going away must not
+		// cancel it; the client timeout still bounds it.
+		detached := *call
+		detached.Context = context.WithoutCancel(call.context())
+		resp, errResp := send(&detached)
+		own = resp
+		if resp != nil {
+			// Keep a copy for the others, which the caller's filters and
+			// countersigning can't touch.
+			resp = cloneResponse(resp)
+		}
+		return coalescedResult{resp: resp, errResp: errResp}, nil
+	})
+	if err != nil {
+		log.Printf("❌ Shared call to %s failed: %v", call.Path, err)
+		return nil, callError(call, http.StatusInternalServerError, models.ErrCodeInternal, "Internal server error"), true
+	}
+	if !shared {
+		return own, result.errResp, false
+	}
+
+	if result.errResp != nil {
+		errResp := *result.errResp
+		errResp.RequestID = call.RequestID
+		return nil, &errResp, true
+	}
+	return cloneResponse(result.resp), nil, true
+}
@@ -0,0 +1,346 @@
+package mesh
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"os"
+	"time"
+
+	"quantum-safe-mesh/pkg/models"
+	"quantum-safe-mesh/pkg/pqc"
+)
+
+// Uploads too large to buffer are streamed instead: the body travels as it
+// is, and the signature covers a ContentDigest of it, its SHA-256, length
+// and media type, in place of an envelope around it. The sender only knows
+// the digest once it has sent the last byte, so the request is a two-part
+// multipart/mixed body: the content, then the signature, a detached JWS.
+// The receiver hashes the content as it arrives, checks the signature
+// against what it hashed, and answers with the digest so the sender can
+// tell the content arrived intact.
+
+const (
+	// MaxContentSize bounds streamed content. It is spooled to disk rather
+	// than held in memory, so it may be far larger than MaxRequestBodySize.
+	MaxContentSize = 1 << 30
+
+	maxContentSignatureSize = 64 << 10
+)
+
+// ParseStreamPaths parses a comma-separated list of gateway routes, written
+// like public paths, whose uploads are streamed to the backend rather than
+// buffered. It returns nil if there are none.
+func ParseStreamPaths(value string) (*PathSet, error) {
+	return parsePathSet(value, "stream path")
+}
+
+// Content is streamed content whose signature verified: its digest, and
+// its bytes spooled to a temporary file, which is removed once the handler
+// returns.
+type Content struct {
+	Digest models.ContentDigest
+	File   *os.File
+}
+
+var errSpoolFailed = errors.New("failed to spool content")
+
+// VerifiedContent wraps h so it only runs for content streamed with a
+// content signature that verifies against the sender's registered key and
+// passes the freshness, replay and namespace checks Verified applies. h
+// sees the sender's IDs in req.Envelope, whose Data is the signed digest,
+// and the content in req.Content. Its result, which must be JSON, is
+// answered as a ContentResult with the digest of the content received.
+func (s *VerifyingServer) VerifiedContent(h Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		protocolVersion, ok := s.NegotiateVersion(w, r)
+		if !ok {
+			return
+		}
+
+		content, token, err := readContent(w, r)
+		if content != nil {
+			defer content.remove()
+		}
+		if err != nil {
+			log.Printf("❌ Failed to receive content: %v", err)
+			var tooLarge *http.MaxBytesError
+			switch {
+			case errors.Is(err, errSpoolFailed):
+				models.WriteError(w, r, http.StatusInternalServerError, models.ErrCodeInternal, "Internal server error")
+			case errors.As(err, &tooLarge):
+				models.WriteError(w, r, http.StatusRequestEntityTooLarge, models.ErrCodeRequestTooLarge, "Content too large")
+			default:
+				models.WriteError(w, r, http.StatusBadRequest, models.ErrCodeInvalidRequest, "Invalid content: "+err.Error())
+			}
+			return
+		}
+
+		digest, err := pqc.CanonicalJSON(content.Digest)
+		if err != nil {
+			log.Printf("❌ Failed to encode content digest: %v", err)
+			models.WriteError(w, r, http.StatusInternalServerError, models.ErrCodeInternal, "Internal server error")
+			return
+		}
+
+		r.Body = io.NopCloser(bytes.NewReader(digest))
+		verifyStart := time.Now()
+		request, err := s.verifyDetachedRequest(r, token, nil)
+		if err == nil && s.namespaces != nil {
+			err = s.namespaces.Allow(request.ServiceID, r.URL.Path)
+		}
+		if err != nil {
+			log.Printf("❌ Content verification failed: %v", err)
+			signer := ""
+			if header, _, parseErr := pqc.ParseDetachedJWS(token); parseErr == nil {
+				signer = header.Kid
+			}
+			s.rejectRequest(w, r, signer, err)
+			return
+		}
+		log.Printf("📦 Received %d bytes from %s (sha256 %s)", content.Digest.Size, request.ServiceID, content.Digest.SHA256)
+
+		s.serve(w, &Request{Request: r, Envelope: request, ProtocolVersion: protocolVersion, Content: content, timing: Timing{Verify: time.Since(verifyStart)}}, echoDigest(h))
+	}
+}
+
+// readContent reads a streamed upload: its content, spooled to disk, and
+// the signature that follows it. It returns the content, to be removed by
+// the caller, once it has started spooling it, even if it then fails.
+func readContent(w http.ResponseWriter, r *http.Request) (*Content, string, error) {
+	mediaType, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/mixed" || params["boundary"] == "" {
+		return nil, "", fmt.Errorf("expected multipart/mixed content and signature")
+	}
+	reader := multipart.NewReader(http.MaxBytesReader(w, r.Body, MaxContentSize+maxContentSignatureSize), params["boundary"])
+
+	part, err := reader.NextPart()
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read content part: %w", err)
+	}
+	content, err := spoolContent(part, part.Header.Get("Content-Type"))
+	if err != nil {
+		return content, "", err
+	}
+
+	part, err = reader.NextPart()
+	if err != nil {
+		return content, "", fmt.Errorf("failed to read signature part: %w", err)
+	}
+	token, err := io.ReadAll(io.LimitReader(part, maxContentSignatureSize+1))
+	if err != nil {
+		return content, "", fmt.Errorf("failed to read signature part: %w", err)
+	}
+	if len(token) > maxContentSignatureSize {
+		return content, "", fmt.Errorf("signature part is too large")
+	}
+
+	if _, err := reader.NextPart(); err != io.EOF {
+		return content, "", fmt.Errorf("expected two parts, content and signature")
+	}
+	return content, string(token), nil
+}
+
+// echoDigest wraps h so its result is answered with the digest of the
+// content it processed.
+func echoDigest(h Handler) Handler {
+	return func(req *Request) (interface{}, error) {
+		result, err := h(req)
+		if err != nil {
+			return nil, err
+		}
+		payload, err := MarshalPayload(result)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal response: %w", err)
+		}
+		return models.ContentResult{Content: req.Content.Digest, Result: payload}, nil
+	}
+}
+
+// spoolContent copies body to a temporary file, hashing it on the way. It
+// returns the content, to be removed by the caller, even if reading body
+// fails; it only returns nil if the file can't be created.
+func spoolContent(body io.Reader, contentType string) (*Content, error) {
+	file, err := os.CreateTemp("", "mesh-content-*")
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", errSpoolFailed, err)
+	}
+	content := &Content{File: file}
+
+	digest := sha256.New()
+	size, err := io.Copy(io.MultiWriter(file, digest), body)
+	if err != nil {
+		return content, err
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return content, fmt.Errorf("%w: %w", errSpoolFailed, err)
+	}
+
+	content.Digest = models.ContentDigest{
+		SHA256:      hex.EncodeToString(digest.Sum(nil)),
+		Size:        size,
+		ContentType: contentType,
+	}
+	return content, nil
+}
+
+func (c *Content) remove() {
+	c.File.Close()
+	os.Remove(c.File.Name())
+}
+
+// Stream sends body to the peer as it is read, rather than buffering it,
+// followed by a signature of its digest, and returns the peer's verified
+// response. The peer must echo the digest of what it received, or the
+// response is rejected. A stream can't be replayed, so it is neither
+// retried nor resent on a lower protocol version. The call's Content-Type
+// header is the content's media type.
+func (c *SignedClient) Stream(call *Call, body io.Reader) (*Response, *models.ErrorResponse) {
+	start := time.Now()
+	call.upstream = ""
+
+	resp, errResp := c.stream(call, body)
+
+	if c.outliers != nil && call.upstream != "" && !call.abandoned() {
+		c.outliers.Record(c.peerID, call.upstream, time.Since(start), outlierFailure(errResp))
+	}
+	return resp, errResp
+}
+
+func (c *SignedClient) stream(call *Call, body io.Reader) (*Response, *models.ErrorResponse) {
+	var timing Timing
+	pipeReader, pipeWriter := io.Pipe()
+	upload := &contentUpload{
+		writer: multipart.NewWriter(pipeWriter),
+		sign: func(digest models.ContentDigest) (string, error) {
+			signStart := time.Now()
+			defer func() { timing.Sign = time.Since(signStart) }()
+			payload, err := pqc.CanonicalJSON(digest)
+			if err != nil {
+				return "", err
+			}
+			return pqc.SignDetachedWithHeader(c.identity.Signer, pqc.JWSHeader{
+				Kid: c.identity.ServiceID,
+				Rid: call.RequestID,
+				Pid: call.ParentID,
+				Htm: call.Method,
+				Idk: call.IdempotencyKey,
+			}, payload)
+		},
+		done: make(chan struct{}),
+	}
+
+	req, err := c.newRequest(call, pipeReader)
+	if err != nil {
+		log.Printf("❌ Failed to create request: %v", err)
+		return nil, callError(call, http.StatusInternalServerError, models.ErrCodeInternal, "Internal server error")
+	}
+	req.ContentLength = -1
+	req.Header.Set("Content-Type", "multipart/mixed; boundary="+upload.writer.Boundary())
+
+	go upload.send(pipeWriter, body, call.Headers["Content-Type"])
+
+	var envelope models.ServiceResponse
+	upstreamStart := time.Now()
+	resp, err := c.httpClient(nil, c.verifyEnvelopeResponse(call, &envelope, &timing)).Do(req)
+	// The peer may answer before taking all of the upload; stop sending it.
+	pipeReader.Close()
+	<-upload.done
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		switch {
+		case errors.As(upload.err, &tooLarge):
+			return nil, callError(call, http.StatusRequestEntityTooLarge, models.ErrCodeRequestTooLarge, "Content too large")
+		case upload.err != nil && call.context().Err() == nil:
+			log.Printf("❌ Failed to read content for %s: %v", c.peerID, upload.err)
+			return nil, callError(call, http.StatusBadRequest, models.ErrCodeInvalidRequest, "Failed to read content")
+		}
+		return nil, c.sendError(call, err)
+	}
+	defer resp.Body.Close()
+	timing.Upstream = time.Since(upstreamStart) - timing.Sign - timing.Verify
+	timing.Peer = ParseServerTiming(resp.Header.Get(models.HeaderServerTiming))
+
+	c.versions.Record(c.peerID, resp)
+
+	response, errResp := c.readEnvelope(resp, &envelope, &timing)
+	if errResp != nil {
+		return nil, errResp
+	}
+
+	var result models.ContentResult
+	if err := json.Unmarshal(response.Envelope.Data, &result); err != nil || result.Content != upload.digest {
+		log.Printf("❌ %s acknowledged content %+v, sent %+v", c.peerID, result.Content, upload.digest)
+		return nil, callError(call, http.StatusInternalServerError, models.ErrCodeUpstreamInvalidResponse, "Backend received different content")
+	}
+	return response, nil
+}
+
+// contentUpload writes a streamed upload: the content, hashed on the way,
+// then the signature of its digest.
+type contentUpload struct {
+	writer *multipart.Writer
+	sign   func(models.ContentDigest) (string, error)
+
+	digest models.ContentDigest
+	err    error // reading the content failed, if set
+	done   chan struct{}
+}
+
+func (u *contentUpload) send(pipe *io.PipeWriter, body io.Reader, contentType string) {
+	defer close(u.done)
+	pipe.CloseWithError(u.write(body, contentType))
+}
+
+func (u *contentUpload) write(body io.Reader, contentType string) error {
+	header := make(textproto.MIMEHeader)
+	if contentType != "" {
+		header.Set("Content-Type", contentType)
+	}
+	part, err := u.writer.CreatePart(header)
+	if err != nil {
+		return err
+	}
+
+	digest := sha256.New()
+	size, err := io.Copy(io.MultiWriter(part, digest), readerFunc(func(p []byte) (int, error) {
+		n, err := body.Read(p)
+		if err != nil && err != io.EOF {
+			u.err = err
+		}
+		return n, err
+	}))
+	if err != nil {
+		return err
+	}
+	u.digest = models.ContentDigest{SHA256: hex.EncodeToString(digest.Sum(nil)), Size: size, ContentType: contentType}
+
+	token, err := u.sign(u.digest)
+	if err != nil {
+		log.Printf("❌ Failed to sign content: %v", err)
+		return err
+	}
+	part, err = u.writer.CreatePart(textproto.MIMEHeader{"Content-Type": {"application/jose"}})
+	if err != nil {
+		return err
+	}
+	if _, err := io.WriteString(part, token); err != nil {
+		return err
+	}
+	return u.writer.Close()
+}
+
+type readerFunc func([]byte) (int, error)
+
+func (f readerFunc) Read(p []byte) (int, error) {
+	return f(p)
+}
@@ -0,0 +1,177 @@
+package mesh
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"time"
+
+	"quantum-safe-mesh/pkg/httpclient"
+	"quantum-safe-mesh/pkg/models"
+	"quantum-safe-mesh/pkg/pqc"
+)
+
+// Workloads such as serverless functions may not live long enough, or hold
+// the credentials, to register themselves. A trusted service registers them
+// instead with a delegation chain: signed assertions leading from a
+// delegator the auth service trusts, possibly through services it allowed
+// to delegate in turn, to the workload's key.
+
+// maxDelegationDepth bounds the links in a delegation chain.
+const maxDelegationDepth = 4
+
+// SignDelegation signs link as this identity, valid for ttl from now.
+func (id *Identity) SignDelegation(link models.DelegationAssertion, ttl time.Duration) (*models.DelegationAssertion, error) {
+	now := time.Now().UTC().Truncate(time.Second)
+	link.Delegator = id.ServiceID
+	link.IssuedAt, link.ExpiresAt = now, now.Add(ttl)
+	link.SignatureAlg = pqc.EnvelopeAlg(id.Signer)
+
+	payload, err := pqc.CanonicalJSON(link.Unsigned())
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode delegation: %w", err)
+	}
+	if link.Signature, err = id.Signer.Sign(payload); err != nil {
+		return nil, fmt.Errorf("failed to sign delegation: %w", err)
+	}
+	return &link, nil
+}
+
+// VerifyDelegation checks chain registers serviceID with publicKey: every
+// link is signed with its delegator's key and valid at now, each delegator
+// is the subject of the link before, and serviceID matches every scope
+// along the way. It returns the delegators, outermost first; whether to
+// trust the outermost is up to the caller.
+func VerifyDelegation(chain []models.DelegationAssertion, serviceID string, publicKey []byte, algorithm string,
+	lookup pqc.PublicKeyLookup, now time.Time) ([]string, error) {
+	if len(chain) == 0 || len(chain) > maxDelegationDepth {
+		return nil, fmt.Errorf("delegation chain must have 1 to %d links, has %d", maxDelegationDepth, len(chain))
+	}
+
+	delegators := make([]string, 0, len(chain))
+	for i := range chain {
+		link := &chain[i]
+		last := i == len(chain)-1
+
+		if link.Delegator == "" || link.Delegator == serviceID {
+			return nil, fmt.Errorf("delegation link %d has no delegator other than %s", i, serviceID)
+		}
+		if i > 0 && link.Delegator != chain[i-1].Subject {
+			return nil, fmt.Errorf("delegation link %d is signed by %s, but link %d delegates to %s", i, link.Delegator, i-1, chain[i-1].Subject)
+		}
+		if last {
+			if link.Subject != serviceID || link.Scope != "" {
+				return nil, fmt.Errorf("delegation chain ends at %s, not %s", link.Subject, serviceID)
+			}
+			if !bytes.Equal(link.PublicKey, publicKey) {
+				return nil, fmt.Errorf("delegation chain vouches for key %s of %s, not %s",
+					pqc.KeyFingerprint(link.PublicKey), serviceID, pqc.KeyFingerprint(publicKey))
+			}
+			linkAlgorithm, err := pqc.SignatureAlgorithm(link.Algorithm)
+			if err != nil {
+				return nil, err
+			}
+			if keyAlgorithm, _ := pqc.SignatureAlgorithm(algorithm); linkAlgorithm != keyAlgorithm {
+				return nil, fmt.Errorf("delegation chain vouches for a %s key, not %s", linkAlgorithm, keyAlgorithm)
+			}
+		} else {
+			if len(link.PublicKey) > 0 {
+				return nil, fmt.Errorf("delegation link %d names a key but is not the last", i)
+			}
+			if matched, err := path.Match(link.Scope, serviceID); err != nil || !matched {
+				return nil, fmt.Errorf("%s lets %s register %q, which does not cover %s", link.Delegator, link.Subject, link.Scope, serviceID)
+			}
+		}
+
+		if now.Before(link.IssuedAt) || !now.Before(link.ExpiresAt) {
+			return nil, fmt.Errorf("delegation by %s is only valid from %s until %s", link.Delegator,
+				link.IssuedAt.Format(time.RFC3339), link.ExpiresAt.Format(time.RFC3339))
+		}
+		delegatorKey, err := lookup(link.Delegator)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get public key of delegator %s: %w", link.Delegator, err)
+		}
+		if err := pqc.VerifyCanonical(link.SignatureAlg, delegatorKey, link.Unsigned(), link.Signature); err != nil {
+			return nil, fmt.Errorf("delegation by %s is not signed by its key: %w", link.Delegator, err)
+		}
+		delegators = append(delegators, link.Delegator)
+	}
+	return delegators, nil
+}
+
+// NewWorkloadRegistration asks a delegating service to register this
+// identity's key, advertised at address if that is set.
+func (id *Identity) NewWorkloadRegistration(address string) (*models.WorkloadRegistration, error) {
+	registration := &models.WorkloadRegistration{
+		ServiceID: id.ServiceID,
+		PublicKey: id.PublicKey(),
+		Algorithm: pqc.EnvelopeAlg(id.Signer),
+		Address:   address,
+		Timestamp: time.Now().UTC(),
+	}
+	payload, err := pqc.CanonicalJSON(registration.Unproven())
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode workload registration: %w", err)
+	}
+	if registration.Proof, err = id.Signer.Sign(payload); err != nil {
+		return nil, fmt.Errorf("failed to sign workload registration: %w", err)
+	}
+	return registration, nil
+}
+
+// VerifyWorkloadRegistration checks registration is signed with the key it
+// names and was made within DefaultMaxRequestAge of now.
+func VerifyWorkloadRegistration(registration *models.WorkloadRegistration, now time.Time) error {
+	if registration.ServiceID == "" || len(registration.PublicKey) == 0 {
+		return fmt.Errorf("workload registration is missing its service or key")
+	}
+	if age := now.Sub(registration.Timestamp); age > DefaultMaxRequestAge || age < -DefaultMaxRequestAge {
+		return fmt.Errorf("workload registration is %s old", age.Round(time.Second))
+	}
+	if err := pqc.VerifyCanonical(registration.Algorithm, registration.PublicKey, registration.Unproven(), registration.Proof); err != nil {
+		return fmt.Errorf("workload registration is not signed by its key: %w", err)
+	}
+	return nil
+}
+
+// RegisterWorkload has the delegating service at delegateURL, such as the
+// gateway, register identity's key on its behalf.
+func RegisterWorkload(delegateURL string, identity *Identity, address string) error {
+	registration, err := identity.NewWorkloadRegistration(address)
+	if err != nil {
+		return err
+	}
+	payload, err := json.Marshal(registration)
+	if err != nil {
+		return fmt.Errorf("failed to marshal workload registration: %w", err)
+	}
+
+	client := httpclient.NewClient(30 * time.Second)
+	resp, err := client.Post(delegateURL+"/workloads/register", "application/json", bytes.NewReader(payload))
+	if err != nil {
+		return fmt.Errorf("failed to reach %s: %w", delegateURL, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("workload registration failed with status %d: %w", resp.StatusCode, models.DecodeErrorResponse(resp))
+	}
+	return nil
+}
+
+// LoadDelegation reads a delegation chain, a JSON array of links, from
+// file.
+func LoadDelegation(file string) ([]models.DelegationAssertion, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read delegation: %w", err)
+	}
+	var chain []models.DelegationAssertion
+	if err := json.Unmarshal(data, &chain); err != nil {
+		return nil, fmt.Errorf("failed to decode delegation %s: %w", file, err)
+	}
+	return chain, nil
+}
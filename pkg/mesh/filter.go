@@ -0,0 +1,175 @@
+package mesh
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"quantum-safe-mesh/pkg/models"
+)
+
+// Filters let the gateway transform what it forwards without changing how
+// it forwards. Each route prefix has an ordered chain: OnRequest runs in
+// order before the request is signed and sent to the backend, and
+// OnResponse in reverse order once the backend's response has been
+// verified. Filters are compiled in and registered by name, typically from
+// an init function in a file added to pkg/gateway.
+
+// FilterContext is what filters see of the request they run for.
+type FilterContext struct {
+	// Request is the request the gateway received; its body has been read
+	// into the Call.
+	Request *http.Request
+	// Route is the prefix whose chain is running.
+	Route string
+	// Version is the API version the request was routed by, if any.
+	Version string
+	// Caller is the verified envelope of a signed caller, on routes that
+	// require one, and nil otherwise.
+	Caller *models.ServiceRequest
+}
+
+// Filter transforms requests on their way to the backend and responses on
+// their way back. Returning an error stops the request: a
+// *models.ErrorResponse selects the status and code, and any other error is
+// reported as internal.
+type Filter interface {
+	// OnRequest may change call, e.g. inject headers or enrich the body,
+	// before it is signed.
+	OnRequest(ctx *FilterContext, call *Call) error
+
+	// OnResponse may change resp, whose Envelope, or Body in detached mode,
+	// holds the backend's verified response. The gateway signs a changed
+	// response itself, since the backend's signature no longer covers it.
+	OnResponse(ctx *FilterContext, resp *Response) error
+}
+
+var (
+	filtersMutex sync.RWMutex
+	filters      = make(map[string]Filter)
+)
+
+// RegisterFilter makes filter available to filter routes as name. It
+// panics if name is already registered.
+func RegisterFilter(name string, filter Filter) {
+	filtersMutex.Lock()
+	defer filtersMutex.Unlock()
+
+	if _, exists := filters[name]; exists {
+		panic(fmt.Sprintf("mesh: filter %q registered twice", name))
+	}
+	filters[name] = filter
+}
+
+// FilterChain is one route's filters, in order.
+type FilterChain []Filter
+
+// OnRequest runs the chain's OnRequest hooks in order.
+func (c FilterChain) OnRequest(ctx *FilterContext, call *Call) error {
+	for _, filter := range c {
+		if err := filter.OnRequest(ctx, call); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// OnResponse runs the chain's OnResponse hooks in reverse order, so the
+// first filter sees the request first and the response last.
+func (c FilterChain) OnResponse(ctx *FilterContext, resp *Response) error {
+	for i := len(c) - 1; i >= 0; i-- {
+		if err := c[i].OnResponse(ctx, resp); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// FilterRoutes assigns filter chains to route prefixes.
+type FilterRoutes struct {
+	routes []filterRoute
+}
+
+type filterRoute struct {
+	prefix string
+	chain  FilterChain
+}
+
+// ParseFilterRoutes parses comma-separated /path-prefix=filter|filter pairs
+// naming registered filters. It returns nil if no route has filters.
+func ParseFilterRoutes(value string) (*FilterRoutes, error) {
+	return parseFilterRoutes(value, nil)
+}
+
+// CheckFilterRoutes checks value as ParseFilterRoutes would, also
+// accepting the names of filters, such as WebAssembly ones, that are only
+// registered when the gateway starts.
+func CheckFilterRoutes(value string, pending []string) error {
+	names := make(map[string]bool, len(pending))
+	for _, name := range pending {
+		names[name] = true
+	}
+	_, err := parseFilterRoutes(value, names)
+	return err
+}
+
+func parseFilterRoutes(value string, pending map[string]bool) (*FilterRoutes, error) {
+	filtersMutex.RLock()
+	defer filtersMutex.RUnlock()
+
+	routes := &FilterRoutes{}
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		prefix, names, found := strings.Cut(entry, "=")
+		if !found || !strings.HasPrefix(prefix, "/") || names == "" {
+			return nil, fmt.Errorf("invalid filter route %q: expected /path-prefix=filter|filter", entry)
+		}
+
+		chain, err := lookupFilters(strings.Split(names, "|"), pending)
+		if err != nil {
+			return nil, fmt.Errorf("%w for %s", err, prefix)
+		}
+		routes.routes = append(routes.routes, filterRoute{prefix: prefix, chain: chain})
+	}
+	sort.SliceStable(routes.routes, func(i, j int) bool {
+		return len(routes.routes[i].prefix) > len(routes.routes[j].prefix)
+	})
+
+	if len(routes.routes) == 0 {
+		return nil, nil
+	}
+	return routes, nil
+}
+
+// lookupFilters returns the chain of registered filters names lists. Names
+// in pending are accepted as nil, to be registered later. filtersMutex
+// must be held.
+func lookupFilters(names []string, pending map[string]bool) (FilterChain, error) {
+	var chain FilterChain
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		filter, ok := filters[name]
+		if !ok && !pending[name] {
+			return nil, fmt.Errorf("unknown filter %q", name)
+		}
+		chain = append(chain, filter)
+	}
+	return chain, nil
+}
+
+// Chain returns the longest route prefix matching path and its chain, or
+// nil if no route matches.
+func (r *FilterRoutes) Chain(path string) (string, FilterChain) {
+	for _, route := range r.routes {
+		if path == route.prefix || strings.HasPrefix(path, strings.TrimSuffix(route.prefix, "/")+"/") {
+			return route.prefix, route.chain
+		}
+	}
+	return "", nil
+}
@@ -0,0 +1,56 @@
+package mesh
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+var errFlightAbandoned = errors.New("in-flight call panicked")
+
+// flightGroup runs one call per key at a time: callers asking for a key
+// that is already in flight wait for its result instead of repeating the
+// work, so a burst of identical requests reaches the other end once.
+type flightGroup[K comparable, T any] struct {
+	mutex sync.Mutex
+	calls map[K]*flight[T]
+}
+
+type flight[T any] struct {
+	done  chan struct{}
+	value T
+	err   error
+}
+
+// do returns fn's result for key, running fn unless a call for key is
+// already in flight, and whether the result came from another caller's
+// call. A caller waiting for another's call stops waiting when ctx is done;
+// the caller running fn is up to fn.
+func (g *flightGroup[K, T]) do(ctx context.Context, key K, fn func() (T, error)) (T, error, bool) {
+	g.mutex.Lock()
+	if g.calls == nil {
+		g.calls = make(map[K]*flight[T])
+	}
+	if f, exists := g.calls[key]; exists {
+		g.mutex.Unlock()
+		select {
+		case <-f.done:
+			return f.value, f.err, true
+		case <-ctx.Done():
+			var zero T
+			return zero, ctx.Err(), true
+		}
+	}
+	f := &flight[T]{done: make(chan struct{}), err: errFlightAbandoned}
+	g.calls[key] = f
+	g.mutex.Unlock()
+
+	defer func() {
+		g.mutex.Lock()
+		delete(g.calls, key)
+		g.mutex.Unlock()
+		close(f.done)
+	}()
+	f.value, f.err = fn()
+	return f.value, f.err, false
+}
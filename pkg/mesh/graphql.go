@@ -0,0 +1,193 @@
+package mesh
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+
+	"quantum-safe-mesh/pkg/models"
+)
+
+// A GraphQL operation is a free-form query, which a signature alone can't
+// vet: whoever may call the endpoint may ask it anything. Persisted queries
+// limit operations to queries that were reviewed ahead of time, known by
+// the SHA-256 hash of their text. The gateway swaps each client operation
+// for the allowed query its hash names and signs that, hash included, and
+// a sidecar in front of the GraphQL server checks the same list, so no
+// query outside it crosses the mesh.
+
+// PersistedQueries is an allow-list of GraphQL queries by the hex SHA-256
+// hash of their text.
+type PersistedQueries struct {
+	queries map[string]string
+}
+
+type graphQLRequest struct {
+	Query         string             `json:"query,omitempty"`
+	OperationName string             `json:"operationName,omitempty"`
+	Variables     json.RawMessage    `json:"variables,omitempty"`
+	Extensions    *graphQLExtensions `json:"extensions,omitempty"`
+}
+
+type graphQLExtensions struct {
+	PersistedQuery *persistedQuery `json:"persistedQuery,omitempty"`
+}
+
+type persistedQuery struct {
+	Version    int    `json:"version"`
+	SHA256Hash string `json:"sha256Hash"`
+}
+
+// apolloManifest is the persisted query manifest Apollo's tooling writes.
+type apolloManifest struct {
+	Format     string `json:"format"`
+	Operations []struct {
+		ID   string `json:"id"`
+		Body string `json:"body"`
+	} `json:"operations"`
+}
+
+// LoadPersistedQueries reads an allow-list from a JSON file: an object
+// mapping hashes to queries, or an Apollo persisted query manifest. Every
+// hash must be its query's.
+func LoadPersistedQueries(path string) (*PersistedQueries, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read persisted queries: %w", err)
+	}
+
+	entries := make(map[string]string)
+	var manifest apolloManifest
+	if err := json.Unmarshal(data, &manifest); err == nil && manifest.Format != "" {
+		for _, operation := range manifest.Operations {
+			entries[operation.ID] = operation.Body
+		}
+	} else if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("failed to parse persisted queries %s: expected a hash-to-query object or an Apollo manifest: %w", path, err)
+	}
+
+	queries := &PersistedQueries{queries: make(map[string]string, len(entries))}
+	for hash, query := range entries {
+		hash = strings.ToLower(hash)
+		if queryHash(query) != hash {
+			return nil, fmt.Errorf("persisted query %s does not match its hash", hash)
+		}
+		queries.queries[hash] = query
+	}
+	if len(queries.queries) == 0 {
+		return nil, fmt.Errorf("no persisted queries in %s", path)
+	}
+	return queries, nil
+}
+
+// OnGraphQLPath reports whether requestPath, once cleaned, is graphqlPath or
+// below it. Servers may route "/graphql/" or "//graphql" to the endpoint
+// too, so those must be vetted like the endpoint itself.
+func OnGraphQLPath(requestPath, graphqlPath string) bool {
+	cleaned := path.Clean("/" + requestPath)
+	return cleaned == graphqlPath || strings.HasPrefix(cleaned, strings.TrimSuffix(graphqlPath, "/")+"/")
+}
+
+// Len returns how many queries are allowed.
+func (q *PersistedQueries) Len() int {
+	return len(q.queries)
+}
+
+// Resolve turns a client's GraphQL operation, posted as JSON or sent as GET
+// parameters, into the JSON body to sign: the allowed query its persisted
+// query hash names, with the hash, operation name and variables. A query
+// sent in full is accepted if its hash is allowed.
+func (q *PersistedQueries) Resolve(r *http.Request, body []byte) ([]byte, *models.ErrorResponse) {
+	var request graphQLRequest
+	if r.Method == http.MethodGet {
+		if err := request.fromValues(r.URL.Query()); err != nil {
+			return nil, models.NewError(http.StatusBadRequest, models.ErrCodeInvalidRequest, "Invalid GraphQL request: "+err.Error())
+		}
+	} else if err := json.Unmarshal(body, &request); err != nil {
+		return nil, models.NewError(http.StatusBadRequest, models.ErrCodeInvalidRequest, "Invalid GraphQL request: expected a JSON object")
+	}
+
+	hash := request.hash()
+	if request.Query != "" {
+		if sum := queryHash(request.Query); hash == "" {
+			hash = sum
+		} else if hash != sum {
+			return nil, models.NewError(http.StatusBadRequest, models.ErrCodeInvalidRequest, "GraphQL query does not match its persisted query hash")
+		}
+	}
+	if hash == "" {
+		return nil, models.NewError(http.StatusBadRequest, models.ErrCodeInvalidRequest, "GraphQL request names no persisted query")
+	}
+	query, allowed := q.queries[hash]
+	if !allowed {
+		return nil, models.NewError(http.StatusForbidden, models.ErrCodePersistedQueryNotAllowed, "Persisted query "+hash+" is not allowed")
+	}
+
+	request.Query = query
+	request.Extensions = &graphQLExtensions{PersistedQuery: &persistedQuery{Version: 1, SHA256Hash: hash}}
+	resolved, err := json.Marshal(request)
+	if err != nil {
+		return nil, models.NewError(http.StatusInternalServerError, models.ErrCodeInternal, "Internal server error")
+	}
+	return resolved, nil
+}
+
+// Check vets a verified GraphQL request before it reaches the GraphQL
+// server: it must be a POST naming an allowed persisted query, with that
+// query's text, if any, unchanged.
+func (q *PersistedQueries) Check(method string, body []byte) *models.ErrorResponse {
+	if method != http.MethodPost {
+		return models.NewError(http.StatusMethodNotAllowed, models.ErrCodeMethodNotAllowed, "GraphQL requests must be posted")
+	}
+	var request graphQLRequest
+	if err := json.Unmarshal(body, &request); err != nil {
+		return models.NewError(http.StatusBadRequest, models.ErrCodeInvalidRequest, "Invalid GraphQL request: expected a JSON object")
+	}
+
+	hash := request.hash()
+	query, allowed := q.queries[hash]
+	if hash == "" || !allowed {
+		return models.NewError(http.StatusForbidden, models.ErrCodePersistedQueryNotAllowed, "Persisted query "+hash+" is not allowed")
+	}
+	if request.Query != "" && request.Query != query {
+		return models.NewError(http.StatusBadRequest, models.ErrCodeInvalidRequest, "GraphQL query does not match its persisted query hash")
+	}
+	return nil
+}
+
+func (r *graphQLRequest) hash() string {
+	if r.Extensions == nil || r.Extensions.PersistedQuery == nil {
+		return ""
+	}
+	return strings.ToLower(r.Extensions.PersistedQuery.SHA256Hash)
+}
+
+// fromValues reads a GraphQL request from GET parameters, where variables
+// and extensions are JSON.
+func (r *graphQLRequest) fromValues(values url.Values) error {
+	r.Query = values.Get("query")
+	r.OperationName = values.Get("operationName")
+	if variables := values.Get("variables"); variables != "" {
+		if !json.Valid([]byte(variables)) {
+			return fmt.Errorf("variables are not JSON")
+		}
+		r.Variables = json.RawMessage(variables)
+	}
+	if extensions := values.Get("extensions"); extensions != "" {
+		if err := json.Unmarshal([]byte(extensions), &r.Extensions); err != nil {
+			return fmt.Errorf("extensions are not JSON")
+		}
+	}
+	return nil
+}
+
+func queryHash(query string) string {
+	sum := sha256.Sum256([]byte(query))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,278 @@
+package mesh
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"quantum-safe-mesh/pkg/models"
+)
+
+// A caller can sign an idempotency key into a mutating request, so it may
+// retry the request, or a bus may deliver it again, without it being
+// processed twice. The first request with a key runs and its result is
+// kept; any later request from the same caller with the same key gets that
+// result, signed afresh for it, instead of running again.
+
+const (
+	// DefaultIdempotencyTTL is how long a result is kept for retries.
+	DefaultIdempotencyTTL = 24 * time.Hour
+
+	// idempotencyLockTTL bounds how long a request holds its key while it
+	// runs, so a service that stops mid-request doesn't hold it forever.
+	idempotencyLockTTL = 10 * time.Minute
+
+	maxIdempotencyKeyLength = 255
+)
+
+// IdempotencyRecord is what a store keeps for a key: the fingerprint of
+// the request that claimed it and, once that request succeeded, its result.
+type IdempotencyRecord struct {
+	Fingerprint string          `json:"fingerprint"`
+	Result      json.RawMessage `json:"result,omitempty"`
+}
+
+// IdempotencyStore keeps idempotency records by key.
+type IdempotencyStore interface {
+	// Claim stores record under key until expiry if the key is free, and
+	// returns nil; otherwise it returns the record already there.
+	Claim(key string, record IdempotencyRecord, expiry time.Time) (*IdempotencyRecord, error)
+	// Complete replaces the record under key, which expires at expiry.
+	Complete(key string, record IdempotencyRecord, expiry time.Time) error
+	// Release frees key, so the next request with it runs.
+	Release(key string) error
+	Close() error
+}
+
+// OpenIdempotencyStore opens a store from a URL: redis://host:6379/0 (or
+// rediss://) for a Redis shared by the service's replicas, or "memory" for
+// results only this process keeps. serviceID scopes the Redis keys, so
+// services can share a Redis.
+func OpenIdempotencyStore(url, serviceID string) (IdempotencyStore, error) {
+	if url == "memory" {
+		return newMemoryIdempotency(), nil
+	}
+
+	scheme, _, found := strings.Cut(url, "://")
+	if !found {
+		return nil, fmt.Errorf("invalid idempotency store URL %q: expected redis:// or memory", url)
+	}
+	switch scheme {
+	case "redis", "rediss":
+		options, err := redis.ParseURL(url)
+		if err != nil {
+			return nil, fmt.Errorf("invalid Redis URL: %w", err)
+		}
+		return &redisIdempotency{client: redis.NewClient(options), prefix: "mesh:idempotency:" + serviceID + ":"}, nil
+	default:
+		return nil, fmt.Errorf("unsupported idempotency store scheme %q", scheme)
+	}
+}
+
+// Idempotent wraps h so a request carrying an idempotency key runs at most
+// once per caller and key while its result is kept, for ttl. A request
+// reusing a key for different data, on a different path or with a
+// different method, is refused, as is one whose key is still held by a
+// request in progress. A request that fails frees its key, so it can be
+// retried.
+func Idempotent(store IdempotencyStore, ttl time.Duration, h Handler) Handler {
+	return func(req *Request) (interface{}, error) {
+		// Envelopes before 1.2 carry the key in their signed headers. A
+		// detached request's HTTP headers are not signed, so only the key
+		// its JWS commits to counts.
+		key := req.Envelope.IdempotencyKey
+		if key == "" && req.Header.Get(models.HeaderMeshSignature) == "" {
+			key = req.Envelope.Headers[models.HeaderIdempotencyKey]
+		}
+		if key == "" {
+			return h(req)
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			return nil, models.NewError(http.StatusBadRequest, models.ErrCodeInvalidRequest,
+				fmt.Sprintf("Idempotency key is longer than %d characters", maxIdempotencyKeyLength))
+		}
+
+		scoped := req.Envelope.ServiceID + ":" + key
+		claim := IdempotencyRecord{Fingerprint: requestFingerprint(req)}
+		existing, err := store.Claim(scoped, claim, time.Now().Add(idempotencyLockTTL))
+		if err != nil {
+			return nil, err
+		}
+		if existing != nil {
+			switch {
+			case existing.Fingerprint != claim.Fingerprint:
+				return nil, models.NewError(http.StatusUnprocessableEntity, models.ErrCodeIdempotencyKeyReused,
+					"Idempotency key was already used for a different request")
+			case existing.Result == nil:
+				return nil, models.NewError(http.StatusConflict, models.ErrCodeIdempotencyKeyInFlight,
+					"A request with this idempotency key is still being processed")
+			}
+			log.Printf("♻️  Answering %s from %s with the result stored for its idempotency key", req.Envelope.RequestID, req.Envelope.ServiceID)
+			return []byte(existing.Result), nil
+		}
+
+		result, err := h(req)
+		if err != nil {
+			if releaseErr := store.Release(scoped); releaseErr != nil {
+				log.Printf("⚠️  Failed to release idempotency key: %v", releaseErr)
+			}
+			return result, err
+		}
+
+		payload, err := MarshalPayload(result)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal response: %w", err)
+		}
+		claim.Result = payload
+		// The request ran, so its key stays held even if the result can't
+		// be stored: a retry is refused as in progress rather than run again.
+		if err := store.Complete(scoped, claim, time.Now().Add(ttl)); err != nil {
+			log.Printf("⚠️  Failed to store result for idempotency key: %v", err)
+		}
+		return payload, nil
+	}
+}
+
+// requestFingerprint identifies what a request asks for, to tell a retry
+// from a different request reusing its key.
+func requestFingerprint(req *Request) string {
+	hash := sha256.New()
+	fmt.Fprintf(hash, "%s\n%s\n", req.Envelope.Headers[models.HeaderOriginalMethod], req.URL.Path)
+	hash.Write(req.Envelope.Data)
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+type idempotencyEntry struct {
+	record IdempotencyRecord
+	expiry time.Time
+}
+
+type memoryIdempotency struct {
+	mutex     sync.Mutex
+	entries   map[string]idempotencyEntry
+	lastSweep time.Time
+}
+
+func newMemoryIdempotency() *memoryIdempotency {
+	return &memoryIdempotency{entries: make(map[string]idempotencyEntry)}
+}
+
+func (s *memoryIdempotency) Claim(key string, record IdempotencyRecord, expiry time.Time) (*IdempotencyRecord, error) {
+	now := time.Now()
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if now.Sub(s.lastSweep) > time.Minute {
+		for key, entry := range s.entries {
+			if now.After(entry.expiry) {
+				delete(s.entries, key)
+			}
+		}
+		s.lastSweep = now
+	}
+
+	if entry, exists := s.entries[key]; exists && now.Before(entry.expiry) {
+		return &entry.record, nil
+	}
+	s.entries[key] = idempotencyEntry{record: record, expiry: expiry}
+	return nil, nil
+}
+
+func (s *memoryIdempotency) Complete(key string, record IdempotencyRecord, expiry time.Time) error {
+	s.mutex.Lock()
+	s.entries[key] = idempotencyEntry{record: record, expiry: expiry}
+	s.mutex.Unlock()
+	return nil
+}
+
+func (s *memoryIdempotency) Release(key string) error {
+	s.mutex.Lock()
+	delete(s.entries, key)
+	s.mutex.Unlock()
+	return nil
+}
+
+func (s *memoryIdempotency) Close() error {
+	return nil
+}
+
+// redisIdempotency keeps records in Redis as JSON, shared by a service's
+// replicas and kept across restarts.
+type redisIdempotency struct {
+	client *redis.Client
+	prefix string
+}
+
+func (s *redisIdempotency) Claim(key string, record IdempotencyRecord, expiry time.Time) (*IdempotencyRecord, error) {
+	value, err := json.Marshal(record)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode idempotency record: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), replayRedisTimeout)
+	defer cancel()
+
+	// The key may expire between a failed claim and reading it back; then
+	// it is free to claim again.
+	for attempt := 0; attempt < 3; attempt++ {
+		err := s.client.SetArgs(ctx, s.prefix+key, value, redis.SetArgs{Mode: "NX", ExpireAt: expiry}).Err()
+		if err == nil {
+			return nil, nil
+		}
+		if !errors.Is(err, redis.Nil) {
+			return nil, fmt.Errorf("failed to claim idempotency key in Redis: %w", err)
+		}
+
+		stored, err := s.client.Get(ctx, s.prefix+key).Bytes()
+		if errors.Is(err, redis.Nil) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read idempotency key from Redis: %w", err)
+		}
+		var existing IdempotencyRecord
+		if err := json.Unmarshal(stored, &existing); err != nil {
+			return nil, fmt.Errorf("failed to decode idempotency record: %w", err)
+		}
+		return &existing, nil
+	}
+	return nil, fmt.Errorf("failed to claim idempotency key in Redis: it keeps expiring")
+}
+
+func (s *redisIdempotency) Complete(key string, record IdempotencyRecord, expiry time.Time) error {
+	value, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("failed to encode idempotency record: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), replayRedisTimeout)
+	defer cancel()
+	if err := s.client.SetArgs(ctx, s.prefix+key, value, redis.SetArgs{ExpireAt: expiry}).Err(); err != nil {
+		return fmt.Errorf("failed to store idempotency record in Redis: %w", err)
+	}
+	return nil
+}
+
+func (s *redisIdempotency) Release(key string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), replayRedisTimeout)
+	defer cancel()
+	if err := s.client.Del(ctx, s.prefix+key).Err(); err != nil {
+		return fmt.Errorf("failed to release idempotency key in Redis: %w", err)
+	}
+	return nil
+}
+
+func (s *redisIdempotency) Close() error {
+	return s.client.Close()
+}
@@ -0,0 +1,113 @@
+package mesh
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+
+	"quantum-safe-mesh/pkg/pqc"
+)
+
+// Identity is a service's long-term PQC key material.
+type Identity struct {
+	ServiceID string
+	Signer    pqc.Signer
+	Kyber     pqc.Decapsulator
+}
+
+// LoadOrCreateIdentity loads serviceID's keys from the keys directory,
+// generating and saving a fresh set, signing with algorithm and with a
+// kemAlgorithm KEM key, if none exist yet. Keys that exist but cannot be loaded, such as encrypted keys without
+// their passphrase, are an error rather than being replaced.
+func LoadOrCreateIdentity(serviceID, algorithm, kemAlgorithm string) (*Identity, error) {
+	signer, kyberKeyPair, err := pqc.LoadKeyPair(serviceID)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("failed to load keys for %s: %w", serviceID, err)
+	}
+	if err == nil {
+		return &Identity{ServiceID: serviceID, Signer: signer, Kyber: kyberKeyPair}, nil
+	}
+
+	log.Printf("Keys not found, generating new ones...")
+	identity, err := GenerateIdentity(serviceID, algorithm, kemAlgorithm)
+	if err != nil {
+		return nil, err
+	}
+	if err := pqc.SaveKeyPair(serviceID, identity.Signer, identity.Kyber.(*pqc.KyberKeyPair)); err != nil {
+		log.Printf("Warning: failed to save keys: %v", err)
+	}
+	return identity, nil
+}
+
+// GenerateIdentity generates a fresh identity for serviceID, signing with
+// algorithm and with a kemAlgorithm KEM key, and keeps it in memory only.
+func GenerateIdentity(serviceID, algorithm, kemAlgorithm string) (*Identity, error) {
+	signer, err := pqc.GenerateSigner(algorithm)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate %s keypair: %w", algorithm, err)
+	}
+
+	kyberKeyPair, err := pqc.GenerateKEMKeyPair(kemAlgorithm)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate %s keypair: %w", kemAlgorithm, err)
+	}
+
+	return &Identity{ServiceID: serviceID, Signer: signer, Kyber: kyberKeyPair}, nil
+}
+
+// LoadIdentity loads serviceID's existing keys from the keys directory.
+func LoadIdentity(serviceID string) (*Identity, error) {
+	signer, kyberKeyPair, err := pqc.LoadKeyPair(serviceID)
+	if err != nil {
+		return nil, err
+	}
+	return &Identity{ServiceID: serviceID, Signer: signer, Kyber: kyberKeyPair}, nil
+}
+
+// LoadSplitIdentity loads serviceID's keys from the keys directory, except
+// its signing key, which it rebuilds from shares.
+func LoadSplitIdentity(serviceID string, shares []*pqc.KeyShare) (*Identity, error) {
+	signer, kyberKeyPair, err := pqc.LoadSplitKeyPair(serviceID, shares)
+	if err != nil {
+		return nil, err
+	}
+	return &Identity{ServiceID: serviceID, Signer: signer, Kyber: kyberKeyPair}, nil
+}
+
+func (id *Identity) PublicKey() []byte {
+	return id.Signer.GetPublicKeyBytes()
+}
+
+// KeyID is the fingerprint of the identity's signing key.
+func (id *Identity) KeyID() string {
+	return pqc.KeyFingerprint(id.PublicKey())
+}
+
+// ReplaceKey saves newKey as the identity's signing key, keeping its Kyber
+// key, and switches the identity to it. The identity must not be in use yet:
+// it is meant for replacing an expiring key at startup. Keys held by a
+// mesh-agent cannot be replaced this way.
+func (id *Identity) ReplaceKey(newKey pqc.Signer) error {
+	kyber, err := id.localKyber()
+	if err != nil {
+		return err
+	}
+	if err := pqc.SaveKeyPair(id.ServiceID, newKey, kyber); err != nil {
+		return fmt.Errorf("failed to save new key %s: %w", pqc.KeyFingerprint(newKey.GetPublicKeyBytes()), err)
+	}
+
+	oldKeyID := id.KeyID()
+	id.Signer = newKey
+	log.Printf("🔄 Signing key for %s replaced: %s -> %s", id.ServiceID, oldKeyID, id.KeyID())
+	return nil
+}
+
+// localKyber returns the identity's Kyber key pair if this process holds it.
+func (id *Identity) localKyber() (*pqc.KyberKeyPair, error) {
+	kyber, local := id.Kyber.(*pqc.KyberKeyPair)
+	if !local {
+		return nil, fmt.Errorf("keys of %s are not held in the local key store", id.ServiceID)
+	}
+	return kyber, nil
+}
@@ -0,0 +1,353 @@
+package mesh
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"quantum-safe-mesh/pkg/pqc"
+)
+
+// CallerUnauthenticated is the caller label of requests that were not
+// signed, such as a gateway's client requests and public key lookups.
+const CallerUnauthenticated = "unauthenticated"
+
+// Metric names served on /metrics.
+const (
+	MetricRequests             = "mesh_requests_total"
+	MetricVerificationFailures = "mesh_verification_failures_total"
+	MetricStartTime            = "mesh_start_time_seconds"
+	MetricFilterCalls          = "mesh_filter_calls_total"
+	MetricFilterSeconds        = "mesh_filter_duration_seconds_total"
+	MetricCacheLookups         = "mesh_cache_lookups_total"
+	MetricCoalescedCalls       = "mesh_coalesced_calls_total"
+	MetricUpstreamCalls        = "mesh_upstream_calls_total"
+	MetricUpstreamEjections    = "mesh_upstream_ejections_total"
+	MetricUpstreamEjected      = "mesh_upstream_ejected"
+	MetricVerificationCache    = "mesh_verification_cache_lookups_total"
+	MetricVerificationEntries  = "mesh_verification_cache_entries"
+	MetricAdmissionLimit       = "mesh_admission_limit"
+	MetricAdmissionInFlight    = "mesh_admission_in_flight"
+	MetricAdmissionQueued      = "mesh_admission_queued"
+	MetricAdmissionLatency     = "mesh_admission_verify_latency_seconds"
+	MetricAdmissionAdmitted    = "mesh_admission_admitted_total"
+	MetricAdmissionShed        = "mesh_admission_shed_total"
+)
+
+type failureKey struct {
+	peer   string
+	reason string
+}
+
+type filterKey struct {
+	filter string
+	hook   string
+}
+
+// Metrics counts the requests a service handles, per caller, and the peer
+// signatures it rejects, per peer and error code. Handler serves them in the
+// Prometheus text format, so any scraper can read them without a client
+// library.
+type Metrics struct {
+	serviceID string
+	started   time.Time
+	mutex     sync.Mutex
+	requests  map[string]uint64
+	failures  map[failureKey]uint64
+
+	// filterCalls counts gateway filter calls by result, and filterTime
+	// totals the time they took.
+	filterCalls map[filterKey]map[string]uint64
+	filterTime  map[filterKey]time.Duration
+
+	// cacheLookups counts response cache lookups by result.
+	cacheLookups map[string]uint64
+
+	// coalescedCalls counts reads answered by another identical read's
+	// backend call.
+	coalescedCalls uint64
+
+	// upstreamCalls counts calls by upstream and result, ejections counts
+	// outlier ejections by upstream, and ejected is whether each is out.
+	upstreamCalls map[string]map[string]uint64
+	ejections     map[string]uint64
+	ejected       map[string]bool
+
+	// admission, if set, is the service's admission controller.
+	admission *Admission
+}
+
+func NewMetrics(serviceID string) *Metrics {
+	return &Metrics{
+		serviceID:   serviceID,
+		started:     time.Now(),
+		requests:    make(map[string]uint64),
+		failures:    make(map[failureKey]uint64),
+		filterCalls: make(map[filterKey]map[string]uint64),
+		filterTime:  make(map[filterKey]time.Duration),
+
+		cacheLookups: make(map[string]uint64),
+
+		upstreamCalls: make(map[string]map[string]uint64),
+		ejections:     make(map[string]uint64),
+		ejected:       make(map[string]bool),
+	}
+}
+
+// CountRequest counts a request handled for caller.
+func (m *Metrics) CountRequest(caller string) {
+	if caller == "" {
+		caller = CallerUnauthenticated
+	}
+	m.mutex.Lock()
+	m.requests[caller]++
+	m.mutex.Unlock()
+}
+
+// CountVerificationFailure counts a signature from peer that was rejected
+// with the error code reason.
+func (m *Metrics) CountVerificationFailure(peer, reason string) {
+	m.mutex.Lock()
+	m.failures[failureKey{peer, reason}]++
+	m.mutex.Unlock()
+}
+
+// CountFilterCall counts a call of filter's hook, on_request or
+// on_response, that ended with result and took duration.
+func (m *Metrics) CountFilterCall(filter, hook, result string, duration time.Duration) {
+	key := filterKey{filter, hook}
+	m.mutex.Lock()
+	if m.filterCalls[key] == nil {
+		m.filterCalls[key] = make(map[string]uint64)
+	}
+	m.filterCalls[key][result]++
+	m.filterTime[key] += duration
+	m.mutex.Unlock()
+}
+
+// CountCacheLookup counts a response cache lookup that ended with result.
+func (m *Metrics) CountCacheLookup(result string) {
+	m.mutex.Lock()
+	m.cacheLookups[result]++
+	m.mutex.Unlock()
+}
+
+// CountCoalescedCall counts a read that shared another's backend call.
+func (m *Metrics) CountCoalescedCall() {
+	m.mutex.Lock()
+	m.coalescedCalls++
+	m.mutex.Unlock()
+}
+
+// CountUpstreamCall counts a call to upstream that ended with result,
+// success or failure.
+func (m *Metrics) CountUpstreamCall(upstream, result string) {
+	m.mutex.Lock()
+	if m.upstreamCalls[upstream] == nil {
+		m.upstreamCalls[upstream] = make(map[string]uint64)
+	}
+	m.upstreamCalls[upstream][result]++
+	m.mutex.Unlock()
+}
+
+// CountUpstreamEjection counts an outlier ejection of upstream.
+func (m *Metrics) CountUpstreamEjection(upstream string) {
+	m.mutex.Lock()
+	m.ejections[upstream]++
+	m.mutex.Unlock()
+}
+
+// SetUpstreamEjected records whether upstream is ejected.
+func (m *Metrics) SetUpstreamEjected(upstream string, ejected bool) {
+	m.mutex.Lock()
+	m.ejected[upstream] = ejected
+	m.mutex.Unlock()
+}
+
+// Handler serves the metrics in the Prometheus text format.
+func (m *Metrics) Handler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		w.Write([]byte(m.String()))
+	}
+}
+
+func (m *Metrics) String() string {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	service := `service="` + escapeLabel(m.serviceID) + `"`
+	var out strings.Builder
+
+	fmt.Fprintf(&out, "# HELP %s Unix time the service started.\n", MetricStartTime)
+	fmt.Fprintf(&out, "# TYPE %s gauge\n", MetricStartTime)
+	fmt.Fprintf(&out, "%s{%s} %d\n", MetricStartTime, service, m.started.Unix())
+
+	fmt.Fprintf(&out, "# HELP %s Requests handled, by caller.\n", MetricRequests)
+	fmt.Fprintf(&out, "# TYPE %s counter\n", MetricRequests)
+	callers := make([]string, 0, len(m.requests))
+	for caller := range m.requests {
+		callers = append(callers, caller)
+	}
+	sort.Strings(callers)
+	for _, caller := range callers {
+		fmt.Fprintf(&out, "%s{%s,caller=\"%s\"} %d\n", MetricRequests, service, escapeLabel(caller), m.requests[caller])
+	}
+
+	fmt.Fprintf(&out, "# HELP %s Peer signatures rejected, by peer and error code.\n", MetricVerificationFailures)
+	fmt.Fprintf(&out, "# TYPE %s counter\n", MetricVerificationFailures)
+	failures := make([]failureKey, 0, len(m.failures))
+	for key := range m.failures {
+		failures = append(failures, key)
+	}
+	sort.Slice(failures, func(i, j int) bool {
+		if failures[i].peer != failures[j].peer {
+			return failures[i].peer < failures[j].peer
+		}
+		return failures[i].reason < failures[j].reason
+	})
+	for _, key := range failures {
+		fmt.Fprintf(&out, "%s{%s,peer=\"%s\",reason=\"%s\"} %d\n",
+			MetricVerificationFailures, service, escapeLabel(key.peer), escapeLabel(key.reason), m.failures[key])
+	}
+
+	// The verification cache is shared by everything in the process that
+	// verifies signatures, so it is counted in pqc rather than here.
+	if stats := pqc.VerificationCacheStatistics(); stats.Enabled {
+		fmt.Fprintf(&out, "# HELP %s Signature verifications answered from the verification cache (hit) or verified in full (miss).\n", MetricVerificationCache)
+		fmt.Fprintf(&out, "# TYPE %s counter\n", MetricVerificationCache)
+		fmt.Fprintf(&out, "%s{%s,result=\"hit\"} %d\n", MetricVerificationCache, service, stats.Hits)
+		fmt.Fprintf(&out, "%s{%s,result=\"miss\"} %d\n", MetricVerificationCache, service, stats.Misses)
+
+		fmt.Fprintf(&out, "# HELP %s Verification outcomes in the verification cache.\n", MetricVerificationEntries)
+		fmt.Fprintf(&out, "# TYPE %s gauge\n", MetricVerificationEntries)
+		fmt.Fprintf(&out, "%s{%s} %d\n", MetricVerificationEntries, service, stats.Entries)
+	}
+
+	if m.admission != nil {
+		stats := m.admission.Statistics()
+		for _, gauge := range []struct {
+			name, help string
+			value      float64
+		}{
+			{MetricAdmissionLimit, "Signature verifications admission control currently lets run at once.", float64(stats.Limit)},
+			{MetricAdmissionInFlight, "Signature verifications running.", float64(stats.InFlight)},
+			{MetricAdmissionQueued, "Signature verifications waiting for a slot.", float64(stats.Queued)},
+			{MetricAdmissionLatency, "Moving average of signature verification latency.", stats.Latency.Seconds()},
+		} {
+			fmt.Fprintf(&out, "# HELP %s %s\n", gauge.name, gauge.help)
+			fmt.Fprintf(&out, "# TYPE %s gauge\n", gauge.name)
+			fmt.Fprintf(&out, "%s{%s} %g\n", gauge.name, service, gauge.value)
+		}
+
+		fmt.Fprintf(&out, "# HELP %s Signature verifications admitted.\n", MetricAdmissionAdmitted)
+		fmt.Fprintf(&out, "# TYPE %s counter\n", MetricAdmissionAdmitted)
+		fmt.Fprintf(&out, "%s{%s} %d\n", MetricAdmissionAdmitted, service, stats.Admitted)
+
+		fmt.Fprintf(&out, "# HELP %s Requests shed with 503 before verification, by reason.\n", MetricAdmissionShed)
+		fmt.Fprintf(&out, "# TYPE %s counter\n", MetricAdmissionShed)
+		for _, reason := range []string{ShedQueueFull, ShedTimeout} {
+			fmt.Fprintf(&out, "%s{%s,reason=\"%s\"} %d\n", MetricAdmissionShed, service, reason, stats.Shed[reason])
+		}
+	}
+
+	if len(m.filterCalls) > 0 {
+		filters := make([]filterKey, 0, len(m.filterCalls))
+		for key := range m.filterCalls {
+			filters = append(filters, key)
+		}
+		sort.Slice(filters, func(i, j int) bool {
+			if filters[i].filter != filters[j].filter {
+				return filters[i].filter < filters[j].filter
+			}
+			return filters[i].hook < filters[j].hook
+		})
+
+		fmt.Fprintf(&out, "# HELP %s Gateway filter calls, by filter, hook and result.\n", MetricFilterCalls)
+		fmt.Fprintf(&out, "# TYPE %s counter\n", MetricFilterCalls)
+		for _, key := range filters {
+			results := make([]string, 0, len(m.filterCalls[key]))
+			for result := range m.filterCalls[key] {
+				results = append(results, result)
+			}
+			sort.Strings(results)
+			for _, result := range results {
+				fmt.Fprintf(&out, "%s{%s,filter=\"%s\",hook=\"%s\",result=\"%s\"} %d\n",
+					MetricFilterCalls, service, escapeLabel(key.filter), key.hook, result, m.filterCalls[key][result])
+			}
+		}
+
+		fmt.Fprintf(&out, "# HELP %s Time spent in gateway filters, by filter and hook.\n", MetricFilterSeconds)
+		fmt.Fprintf(&out, "# TYPE %s counter\n", MetricFilterSeconds)
+		for _, key := range filters {
+			fmt.Fprintf(&out, "%s{%s,filter=\"%s\",hook=\"%s\"} %g\n",
+				MetricFilterSeconds, service, escapeLabel(key.filter), key.hook, m.filterTime[key].Seconds())
+		}
+	}
+
+	if len(m.cacheLookups) > 0 {
+		results := make([]string, 0, len(m.cacheLookups))
+		for result := range m.cacheLookups {
+			results = append(results, result)
+		}
+		sort.Strings(results)
+
+		fmt.Fprintf(&out, "# HELP %s Gateway response cache lookups, by result.\n", MetricCacheLookups)
+		fmt.Fprintf(&out, "# TYPE %s counter\n", MetricCacheLookups)
+		for _, result := range results {
+			fmt.Fprintf(&out, "%s{%s,result=\"%s\"} %d\n", MetricCacheLookups, service, result, m.cacheLookups[result])
+		}
+	}
+
+	if m.coalescedCalls > 0 {
+		fmt.Fprintf(&out, "# HELP %s Gateway reads answered by an identical read's backend call.\n", MetricCoalescedCalls)
+		fmt.Fprintf(&out, "# TYPE %s counter\n", MetricCoalescedCalls)
+		fmt.Fprintf(&out, "%s{%s} %d\n", MetricCoalescedCalls, service, m.coalescedCalls)
+	}
+
+	if len(m.upstreamCalls) > 0 {
+		upstreams := make([]string, 0, len(m.upstreamCalls))
+		for upstream := range m.upstreamCalls {
+			upstreams = append(upstreams, upstream)
+		}
+		sort.Strings(upstreams)
+
+		fmt.Fprintf(&out, "# HELP %s Calls to upstreams, by upstream and result.\n", MetricUpstreamCalls)
+		fmt.Fprintf(&out, "# TYPE %s counter\n", MetricUpstreamCalls)
+		for _, upstream := range upstreams {
+			for _, result := range []string{"failure", "success"} {
+				fmt.Fprintf(&out, "%s{%s,upstream=\"%s\",result=\"%s\"} %d\n",
+					MetricUpstreamCalls, service, escapeLabel(upstream), result, m.upstreamCalls[upstream][result])
+			}
+		}
+
+		fmt.Fprintf(&out, "# HELP %s Outlier ejections, by upstream.\n", MetricUpstreamEjections)
+		fmt.Fprintf(&out, "# TYPE %s counter\n", MetricUpstreamEjections)
+		for _, upstream := range upstreams {
+			fmt.Fprintf(&out, "%s{%s,upstream=\"%s\"} %d\n", MetricUpstreamEjections, service, escapeLabel(upstream), m.ejections[upstream])
+		}
+
+		fmt.Fprintf(&out, "# HELP %s Whether the upstream is ejected as an outlier.\n", MetricUpstreamEjected)
+		fmt.Fprintf(&out, "# TYPE %s gauge\n", MetricUpstreamEjected)
+		for _, upstream := range upstreams {
+			ejected := 0
+			if m.ejected[upstream] {
+				ejected = 1
+			}
+			fmt.Fprintf(&out, "%s{%s,upstream=\"%s\"} %d\n", MetricUpstreamEjected, service, escapeLabel(upstream), ejected)
+		}
+	}
+
+	return out.String()
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// escapeLabel escapes a label value as the Prometheus text format requires.
+// Peer IDs come from requests, so they cannot be trusted to be plain.
+func escapeLabel(value string) string {
+	return labelEscaper.Replace(value)
+}
@@ -0,0 +1,157 @@
+package mesh
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Service IDs may be qualified with a tenant namespace, as in
+// tenant-a/billing. Unqualified IDs are in DefaultNamespace. Namespaces
+// bound what a service may do: a namespace administrator only manages keys
+// in its own namespace, and routes a namespace owns only accept callers
+// from it, or from the namespaces it trusts.
+
+// DefaultNamespace holds every service ID without a namespace.
+const DefaultNamespace = "default"
+
+var errNamespaceForbidden = errors.New("caller's namespace may not call this route")
+
+// Namespace returns the namespace serviceID is in.
+func Namespace(serviceID string) string {
+	if namespace, _, found := strings.Cut(serviceID, "/"); found {
+		return namespace
+	}
+	return DefaultNamespace
+}
+
+// ValidateServiceID checks serviceID is a name, optionally qualified with
+// one namespace. IDs name key files, so they may not climb out of the key
+// store.
+func ValidateServiceID(serviceID string) error {
+	parts := strings.Split(serviceID, "/")
+	if len(parts) > 2 {
+		return fmt.Errorf("service ID %q has more than one namespace", serviceID)
+	}
+	for _, part := range parts {
+		if part == "" || part == "." || part == ".." || strings.ContainsAny(part, `\`) {
+			return fmt.Errorf("invalid service ID %q: expected name or namespace/name", serviceID)
+		}
+	}
+	return nil
+}
+
+// NamespaceAdmins maps each namespace administrator to the namespaces it
+// administers.
+type NamespaceAdmins map[string]map[string]bool
+
+// ParseNamespaceAdmins parses comma-separated namespace=service-id pairs.
+// A namespace may have several administrators.
+func ParseNamespaceAdmins(value string) (NamespaceAdmins, error) {
+	admins := make(NamespaceAdmins)
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		namespace, serviceID, found := strings.Cut(entry, "=")
+		if !found || namespace == "" || strings.Contains(namespace, "/") || serviceID == "" {
+			return nil, fmt.Errorf("invalid namespace administrator %q: expected namespace=service-id", entry)
+		}
+		if admins[serviceID] == nil {
+			admins[serviceID] = make(map[string]bool)
+		}
+		admins[serviceID][namespace] = true
+	}
+	return admins, nil
+}
+
+// Administers reports whether serviceID is an administrator of target's
+// namespace.
+func (a NamespaceAdmins) Administers(serviceID, target string) bool {
+	return a[serviceID][Namespace(target)]
+}
+
+// NamespacePolicy assigns routes to the namespaces that own them.
+type NamespacePolicy struct {
+	routes []namespaceRoute           // longest prefix first
+	trusts map[string]map[string]bool // namespace -> namespaces whose callers it accepts
+}
+
+type namespaceRoute struct {
+	prefix    string
+	namespace string
+}
+
+// ParseNamespacePolicy parses routes, comma-separated path-prefix=namespace
+// pairs, and trust, comma-separated namespace=trusted-namespace pairs
+// letting callers in the trusted namespace call the first one's routes. It
+// returns nil if no routes are assigned.
+func ParseNamespacePolicy(routes, trust string) (*NamespacePolicy, error) {
+	policy := &NamespacePolicy{trusts: make(map[string]map[string]bool)}
+	for _, entry := range strings.Split(routes, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		prefix, namespace, found := strings.Cut(entry, "=")
+		if !found || !strings.HasPrefix(prefix, "/") || namespace == "" || strings.Contains(namespace, "/") {
+			return nil, fmt.Errorf("invalid namespace route %q: expected /path-prefix=namespace", entry)
+		}
+		policy.routes = append(policy.routes, namespaceRoute{prefix: prefix, namespace: namespace})
+	}
+	sort.SliceStable(policy.routes, func(i, j int) bool {
+		return len(policy.routes[i].prefix) > len(policy.routes[j].prefix)
+	})
+
+	for _, entry := range strings.Split(trust, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		namespace, trusted, found := strings.Cut(entry, "=")
+		if !found || namespace == "" || trusted == "" {
+			return nil, fmt.Errorf("invalid namespace trust %q: expected namespace=trusted-namespace", entry)
+		}
+		if policy.trusts[namespace] == nil {
+			policy.trusts[namespace] = make(map[string]bool)
+		}
+		policy.trusts[namespace][trusted] = true
+	}
+
+	if len(policy.routes) == 0 {
+		if len(policy.trusts) > 0 {
+			return nil, fmt.Errorf("namespace trust is set, but no routes are assigned to namespaces")
+		}
+		return nil, nil
+	}
+	return policy, nil
+}
+
+// Owner returns the namespace owning path, or "" if none does.
+func (p *NamespacePolicy) Owner(path string) string {
+	for _, route := range p.routes {
+		if path == route.prefix || strings.HasPrefix(path, strings.TrimSuffix(route.prefix, "/")+"/") {
+			return route.namespace
+		}
+	}
+	return ""
+}
+
+// Allow checks caller, an authenticated service ID, may call path: routes
+// no namespace owns are open to all callers.
+func (p *NamespacePolicy) Allow(caller, path string) error {
+	owner := p.Owner(path)
+	if owner == "" {
+		return nil
+	}
+	namespace := Namespace(caller)
+	if namespace == owner || p.trusts[owner][namespace] {
+		return nil
+	}
+	return fmt.Errorf("%w: %s is in namespace %s, but %s belongs to %s", errNamespaceForbidden, caller, namespace, path, owner)
+}
@@ -0,0 +1,568 @@
+package mesh_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"quantum-safe-mesh/pkg/mesh"
+	"quantum-safe-mesh/pkg/meshtest"
+	"quantum-safe-mesh/pkg/models"
+	"quantum-safe-mesh/pkg/pqc"
+)
+
+// Negative-path scenarios: requests and responses that must be rejected,
+// and the status and error code each hop rejects them with.
+
+func TestMain(m *testing.M) {
+	log.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+// testMesh is an auth server and a backend that verifies callers against it.
+type testMesh struct {
+	auth       *meshtest.AuthServer
+	backend    *httptest.Server
+	server     *mesh.VerifyingServer
+	backendID  *mesh.Identity
+	callerID   *mesh.Identity
+	unknownID  *mesh.Identity
+	revokedID  *mesh.Identity
+	echoCalled atomic.Int32
+}
+
+func newTestMesh(t testing.TB) *testMesh {
+	t.Helper()
+	m := &testMesh{
+		backendID: meshtest.Identity("backend-service"),
+		callerID:  meshtest.Identity("api-gateway"),
+		unknownID: meshtest.Identity("unknown-service"),
+		revokedID: meshtest.Identity("revoked-service"),
+	}
+
+	m.auth = meshtest.NewAuthServer(m.backendID, m.callerID, m.revokedID)
+	t.Cleanup(m.auth.Close)
+	if _, err := m.auth.Client(m.revokedID).Revoke(); err != nil {
+		t.Fatalf("Revoke: %v", err)
+	}
+
+	server := mesh.NewVerifyingServer(m.backendID, m.auth.Client(m.backendID).PublicKey)
+	m.server = server
+	r := mux.NewRouter()
+	r.HandleFunc("/echo", server.Verified(func(req *mesh.Request) (interface{}, error) {
+		m.echoCalled.Add(1)
+		return req.Envelope.Data, nil
+	})).Methods("POST")
+
+	m.backend = httptest.NewServer(r)
+	t.Cleanup(m.backend.Close)
+	return m
+}
+
+// envelope returns a request from identity signed as a SignedClient would.
+func envelope(t *testing.T, identity *mesh.Identity, timestamp time.Time, data string) models.ServiceRequest {
+	t.Helper()
+	request := models.ServiceRequest{
+		ProtocolVersion: models.CurrentProtocolVersion,
+		ServiceID:       identity.ServiceID,
+		RequestID:       models.NewRequestID(),
+		Timestamp:       timestamp,
+		Data:            json.RawMessage(data),
+	}
+	payload, err := request.SigningPayload()
+	if err != nil {
+		t.Fatalf("SigningPayload: %v", err)
+	}
+	request.Signature, err = identity.Signer.Sign(payload)
+	if err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+	return request
+}
+
+func marshal(t *testing.T, v interface{}) []byte {
+	t.Helper()
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	return data
+}
+
+// detachedToken signs body as if at issuedAt, which SignDetachedWithHeader
+// cannot do.
+func detachedToken(t *testing.T, identity *mesh.Identity, issuedAt time.Time, body []byte) string {
+	t.Helper()
+	header := marshal(t, pqc.JWSHeader{Alg: pqc.JWSAlgDilithium3, Kid: identity.ServiceID, Iat: issuedAt.Unix(), Typ: "mesh+jws", Rid: models.NewRequestID()})
+	encodedHeader := base64.RawURLEncoding.EncodeToString(header)
+	signature, err := identity.Signer.Sign([]byte(encodedHeader + "." + base64.RawURLEncoding.EncodeToString(body)))
+	if err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+	return encodedHeader + ".." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+// post sends body and returns the status and, for failures, the error code.
+func post(t *testing.T, url string, body []byte, token string) (int, string) {
+	t.Helper()
+	req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("NewRequest: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(models.HeaderAcceptVersion, models.AcceptVersionHeader())
+	if token != "" {
+		req.Header.Set(models.HeaderMeshSignature, token)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("POST %s: %v", url, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusOK {
+		io.Copy(io.Discard, resp.Body)
+		return resp.StatusCode, ""
+	}
+	return resp.StatusCode, models.DecodeErrorResponse(resp).Code
+}
+
+func flipByte(b []byte) []byte {
+	flipped := append([]byte(nil), b...)
+	flipped[len(flipped)/2] ^= 0x01
+	return flipped
+}
+
+func TestVerifiedRejectsEnvelopes(t *testing.T) {
+	m := newTestMesh(t)
+	now := time.Now()
+	oversized := `{"padding":"` + strings.Repeat("x", mesh.MaxRequestBodySize+100<<10) + `"}`
+
+	tests := []struct {
+		name    string
+		body    func() []byte
+		status  int
+		errCode string
+	}{
+		{"valid", func() []byte { return marshal(t, envelope(t, m.callerID, now, `{}`)) }, http.StatusOK, ""},
+		{"tampered signature", func() []byte {
+			request := envelope(t, m.callerID, now, `{}`)
+			request.Signature = flipByte(request.Signature)
+			return marshal(t, request)
+		}, http.StatusUnauthorized, models.ErrCodeInvalidSignature},
+		{"tampered data", func() []byte {
+			request := envelope(t, m.callerID, now, `{"amount":1}`)
+			request.Data = json.RawMessage(`{"amount":1000}`)
+			return marshal(t, request)
+		}, http.StatusUnauthorized, models.ErrCodeInvalidSignature},
+		{"impersonated service ID", func() []byte {
+			request := envelope(t, m.callerID, now, `{}`)
+			request.ServiceID = m.backendID.ServiceID
+			return marshal(t, request)
+		}, http.StatusUnauthorized, models.ErrCodeInvalidSignature},
+		{"unknown service ID", func() []byte { return marshal(t, envelope(t, m.unknownID, now, `{}`)) }, http.StatusUnauthorized, models.ErrCodeInvalidSignature},
+		{"revoked key", func() []byte { return marshal(t, envelope(t, m.revokedID, now, `{}`)) }, http.StatusUnauthorized, models.ErrCodeInvalidSignature},
+		{"expired timestamp", func() []byte { return marshal(t, envelope(t, m.callerID, now.Add(-10*time.Minute), `{}`)) }, http.StatusUnauthorized, models.ErrCodeRequestExpired},
+		{"future timestamp", func() []byte { return marshal(t, envelope(t, m.callerID, now.Add(10*time.Minute), `{}`)) }, http.StatusUnauthorized, models.ErrCodeClockSkew},
+		{"missing timestamp", func() []byte { return marshal(t, envelope(t, m.callerID, time.Time{}, `{}`)) }, http.StatusUnauthorized, models.ErrCodeRequestExpired},
+		{"unsupported version", func() []byte {
+			request := envelope(t, m.callerID, now, `{}`)
+			request.ProtocolVersion = "9.9"
+			return marshal(t, request)
+		}, http.StatusBadRequest, models.ErrCodeUnsupportedVersion},
+		{"malformed JSON", func() []byte { return []byte(`{"service_id":`) }, http.StatusBadRequest, models.ErrCodeInvalidRequest},
+		{"oversized body", func() []byte { return marshal(t, envelope(t, m.callerID, now, oversized)) }, http.StatusRequestEntityTooLarge, models.ErrCodeRequestTooLarge},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			called := m.echoCalled.Load()
+			status, errCode := post(t, m.backend.URL+"/echo", tt.body(), "")
+			if status != tt.status || errCode != tt.errCode {
+				t.Fatalf("got %d %q, want %d %q", status, errCode, tt.status, tt.errCode)
+			}
+			if tt.status != http.StatusOK && m.echoCalled.Load() != called {
+				t.Fatal("handler ran for a rejected request")
+			}
+		})
+	}
+}
+
+func TestVerifiedRejectsReplayedEnvelopes(t *testing.T) {
+	m := newTestMesh(t)
+	body := marshal(t, envelope(t, m.callerID, time.Now(), `{"transfer":100}`))
+
+	if status, errCode := post(t, m.backend.URL+"/echo", body, ""); status != http.StatusOK {
+		t.Fatalf("first delivery: got %d %q, want 200", status, errCode)
+	}
+	status, errCode := post(t, m.backend.URL+"/echo", body, "")
+	if status != http.StatusUnauthorized || errCode != models.ErrCodeRequestReplayed {
+		t.Fatalf("replay: got %d %q, want 401 %q", status, errCode, models.ErrCodeRequestReplayed)
+	}
+	if m.echoCalled.Load() != 1 {
+		t.Fatalf("handler ran %d times, want 1", m.echoCalled.Load())
+	}
+}
+
+// A request whose verification admission control has no room for is shed
+// with a retryable 503 before it is verified, so it can be sent again as is.
+func TestVerifiedShedsWhenOverloaded(t *testing.T) {
+	m := newTestMesh(t)
+	admission := mesh.NewAdmission(mesh.AdmissionPolicy{MaxConcurrency: 1}, m.server.Metrics())
+	m.server.UseAdmission(admission)
+
+	held, release := make(chan struct{}), make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		admission.Do(context.Background(), func() error {
+			close(held)
+			<-release
+			return nil
+		})
+	}()
+	<-held
+
+	body := marshal(t, envelope(t, m.callerID, time.Now(), `{}`))
+	status, errCode := post(t, m.backend.URL+"/echo", body, "")
+	if status != http.StatusServiceUnavailable || errCode != models.ErrCodeOverloaded {
+		t.Fatalf("overloaded: got %d %q, want 503 %q", status, errCode, models.ErrCodeOverloaded)
+	}
+	if m.echoCalled.Load() != 0 {
+		t.Fatal("handler ran for a shed request")
+	}
+	if metrics := m.server.Metrics().String(); !strings.Contains(metrics, mesh.MetricAdmissionShed+`{service="backend-service",reason="queue_full"} 1`) {
+		t.Fatalf("shed request not counted:\n%s", metrics)
+	}
+
+	close(release)
+	<-done
+	if status, errCode := post(t, m.backend.URL+"/echo", body, ""); status != http.StatusOK {
+		t.Fatalf("retry: got %d %q, want 200", status, errCode)
+	}
+}
+
+func TestVerifiedRejectsDetachedRequests(t *testing.T) {
+	m := newTestMesh(t)
+	body := []byte(`{"message":"hi"}`)
+	now := time.Now()
+
+	tests := []struct {
+		name    string
+		body    []byte
+		token   func() string
+		status  int
+		errCode string
+	}{
+		{"valid", body, func() string { return detachedToken(t, m.callerID, now, body) }, http.StatusOK, ""},
+		{"tampered signature", body, func() string {
+			token := detachedToken(t, m.callerID, now, body)
+			parts := strings.Split(token, ".")
+			signature, _ := base64.RawURLEncoding.DecodeString(parts[2])
+			return parts[0] + ".." + base64.RawURLEncoding.EncodeToString(flipByte(signature))
+		}, http.StatusUnauthorized, models.ErrCodeInvalidSignature},
+		{"tampered body", []byte(`{"message":"bye"}`), func() string { return detachedToken(t, m.callerID, now, body) }, http.StatusUnauthorized, models.ErrCodeInvalidSignature},
+		{"malformed token", body, func() string { return "not-a-jws" }, http.StatusUnauthorized, models.ErrCodeInvalidSignature},
+		{"unknown service ID", body, func() string { return detachedToken(t, m.unknownID, now, body) }, http.StatusUnauthorized, models.ErrCodeInvalidSignature},
+		{"revoked key", body, func() string { return detachedToken(t, m.revokedID, now, body) }, http.StatusUnauthorized, models.ErrCodeInvalidSignature},
+		{"expired iat", body, func() string { return detachedToken(t, m.callerID, now.Add(-10*time.Minute), body) }, http.StatusUnauthorized, models.ErrCodeRequestExpired},
+		{"future iat", body, func() string { return detachedToken(t, m.callerID, now.Add(10*time.Minute), body) }, http.StatusUnauthorized, models.ErrCodeClockSkew},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, errCode := post(t, m.backend.URL+"/echo", tt.body, tt.token())
+			if status != tt.status || errCode != tt.errCode {
+				t.Fatalf("got %d %q, want %d %q", status, errCode, tt.status, tt.errCode)
+			}
+		})
+	}
+
+	t.Run("replayed", func(t *testing.T) {
+		token := detachedToken(t, m.callerID, now, body)
+		if status, errCode := post(t, m.backend.URL+"/echo", body, token); status != http.StatusOK {
+			t.Fatalf("first delivery: got %d %q, want 200", status, errCode)
+		}
+		status, errCode := post(t, m.backend.URL+"/echo", body, token)
+		if status != http.StatusUnauthorized || errCode != models.ErrCodeRequestReplayed {
+			t.Fatalf("replay: got %d %q, want 401 %q", status, errCode, models.ErrCodeRequestReplayed)
+		}
+	})
+
+	t.Run("unsigned headers dropped", func(t *testing.T) {
+		var headers map[string]string
+		server := httptest.NewServer(m.server.Verified(func(req *mesh.Request) (interface{}, error) {
+			headers = req.Envelope.Headers
+			return nil, nil
+		}))
+		t.Cleanup(server.Close)
+
+		req, _ := http.NewRequest("POST", server.URL, bytes.NewReader(body))
+		req.Header.Set(models.HeaderMeshSignature, detachedToken(t, m.callerID, time.Now(), body))
+		req.Header.Set(models.HeaderBootstrapToken, "unsigned")
+		req.Header.Set(models.HeaderIdempotencyKey, "unsigned")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("POST: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("got %d, want 200", resp.StatusCode)
+		}
+		if len(headers) != 0 {
+			t.Fatalf("envelope carries headers the JWS does not cover: %v", headers)
+		}
+	})
+
+	t.Run("oversized body", func(t *testing.T) {
+		oversized := bytes.Repeat([]byte("x"), mesh.MaxRequestBodySize+1)
+		status, errCode := post(t, m.backend.URL+"/echo", oversized, detachedToken(t, m.callerID, now, oversized))
+		if status != http.StatusRequestEntityTooLarge || errCode != models.ErrCodeRequestTooLarge {
+			t.Fatalf("got %d %q, want 413 %q", status, errCode, models.ErrCodeRequestTooLarge)
+		}
+	})
+}
+
+// TestSignedClientRejectsResponses covers the calling hop: responses a
+// SignedClient must refuse, and the error it relays to its own caller.
+func TestSignedClientRejectsResponses(t *testing.T) {
+	m := newTestMesh(t)
+	registry := m.auth.Client(m.callerID)
+
+	// forged answers every request with a response altered by tamper.
+	forged := func(tamper func(response *models.ServiceResponse)) string {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var request models.ServiceRequest
+			json.NewDecoder(r.Body).Decode(&request)
+			if request.RequestID == "" {
+				request.RequestID = r.Header.Get(models.HeaderRequestID)
+			}
+
+			response := models.ServiceResponse{
+				ProtocolVersion: models.CurrentProtocolVersion,
+				ServiceID:       m.backendID.ServiceID,
+				RequestID:       request.RequestID,
+				Timestamp:       time.Now(),
+				Data:            json.RawMessage(`{"balance":100}`),
+				Success:         true,
+			}
+			payload, _ := response.SigningPayload()
+			response.Signature, _ = m.backendID.Signer.Sign(payload)
+			tamper(&response)
+
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(response)
+		}))
+		t.Cleanup(server.Close)
+		return server.URL
+	}
+
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+
+	tests := []struct {
+		name    string
+		peerID  string
+		url     string
+		status  int
+		errCode string
+	}{
+		{"valid", m.backendID.ServiceID, forged(func(*models.ServiceResponse) {}), http.StatusOK, ""},
+		{"tampered signature", m.backendID.ServiceID, forged(func(r *models.ServiceResponse) { r.Signature = flipByte(r.Signature) }), http.StatusUnauthorized, models.ErrCodeUpstreamSignatureInvalid},
+		{"tampered data", m.backendID.ServiceID, forged(func(r *models.ServiceResponse) { r.Data = json.RawMessage(`{"balance":1000000}`) }), http.StatusUnauthorized, models.ErrCodeUpstreamSignatureInvalid},
+		{"response to another request", m.backendID.ServiceID, forged(func(r *models.ServiceResponse) {
+			r.RequestID = "other"
+			payload, _ := r.SigningPayload()
+			r.Signature, _ = m.backendID.Signer.Sign(payload)
+		}), http.StatusUnauthorized, models.ErrCodeUpstreamSignatureInvalid},
+		{"signed by another service", m.backendID.ServiceID, forged(func(r *models.ServiceResponse) {
+			payload, _ := r.SigningPayload()
+			r.Signature, _ = m.callerID.Signer.Sign(payload)
+		}), http.StatusUnauthorized, models.ErrCodeUpstreamSignatureInvalid},
+		{"unregistered peer", m.unknownID.ServiceID, forged(func(*models.ServiceResponse) {}), http.StatusInternalServerError, models.ErrCodeUpstreamAuthenticationFail},
+		{"peer unreachable", m.backendID.ServiceID, closed.URL, http.StatusServiceUnavailable, models.ErrCodeUpstreamUnavailable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, err := mesh.NewSignedClient(m.callerID, registry, mesh.NewPeerVersions(), tt.peerID, tt.url, mesh.SignatureModeEnvelope)
+			if err != nil {
+				t.Fatalf("NewSignedClient: %v", err)
+			}
+
+			requestID := models.NewRequestID()
+			_, errResp := client.Call(&mesh.Call{Path: "/echo", Body: []byte(`{}`), RequestID: requestID})
+			if tt.status == http.StatusOK {
+				if errResp != nil {
+					t.Fatalf("Call: %v", errResp)
+				}
+				return
+			}
+			if errResp == nil {
+				t.Fatal("Call accepted the response")
+			}
+			if errResp.Status != tt.status || errResp.Code != tt.errCode || errResp.RequestID != requestID {
+				t.Fatalf("got %d %q for %q, want %d %q for %q", errResp.Status, errResp.Code, errResp.RequestID, tt.status, tt.errCode, requestID)
+			}
+		})
+	}
+
+	// Errors the peer itself returned are relayed with its status and code.
+	t.Run("peer rejection relayed", func(t *testing.T) {
+		client, err := mesh.NewSignedClient(m.revokedID, m.auth.Client(m.revokedID), mesh.NewPeerVersions(), m.backendID.ServiceID, m.backend.URL, mesh.SignatureModeDetached)
+		if err != nil {
+			t.Fatalf("NewSignedClient: %v", err)
+		}
+		_, errResp := client.Call(&mesh.Call{Path: "/echo", Body: []byte(`{}`), RequestID: models.NewRequestID()})
+		if errResp == nil || errResp.Status != http.StatusUnauthorized || errResp.Code != models.ErrCodeInvalidSignature {
+			t.Fatalf("got %v, want 401 %q relayed from the backend", errResp, models.ErrCodeInvalidSignature)
+		}
+	})
+}
+
+// TestRegistryClientRejectsPublicKeyResponses covers key lookups: a
+// /public-key response the auth service did not sign for the service asked
+// about must not hand out a key.
+func TestRegistryClientRejectsPublicKeyResponses(t *testing.T) {
+	m := newTestMesh(t)
+	authID := m.auth.Identity
+
+	// forged answers lookups of the auth service honestly and every other
+	// lookup with the backend's registration altered by tamper.
+	forged := func(tamper func(response *models.ServiceResponse, registration *models.PublicKeyResponse)) string {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			registration := models.PublicKeyResponse{ServiceID: m.backendID.ServiceID, PublicKey: m.backendID.PublicKey(), Timestamp: time.Now()}
+			if strings.HasSuffix(r.URL.Path, "/"+mesh.AuthServiceID) {
+				registration = models.PublicKeyResponse{ServiceID: mesh.AuthServiceID, PublicKey: authID.PublicKey(), Timestamp: time.Now()}
+			}
+			response := models.ServiceResponse{
+				ProtocolVersion: models.CurrentProtocolVersion,
+				ServiceID:       mesh.AuthServiceID,
+				Timestamp:       time.Now(),
+				SignatureAlg:    pqc.EnvelopeAlg(authID.Signer),
+				Headers:         map[string]string{models.HeaderKeyID: authID.KeyID()},
+				Success:         true,
+			}
+			signer := authID.Signer
+			if registration.ServiceID != mesh.AuthServiceID {
+				tamper(&response, &registration)
+				if response.ServiceID != mesh.AuthServiceID {
+					signer = m.backendID.Signer
+				}
+			}
+			response.Data = marshal(t, registration)
+			payload, _ := response.SigningPayload()
+			response.Signature, _ = signer.Sign(payload)
+
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(response)
+		}))
+		t.Cleanup(server.Close)
+		return server.URL
+	}
+
+	tests := []struct {
+		name   string
+		url    string
+		wantOK bool
+	}{
+		{"valid", forged(func(*models.ServiceResponse, *models.PublicKeyResponse) {}), true},
+		{"signed by another service", forged(func(r *models.ServiceResponse, _ *models.PublicKeyResponse) {
+			r.ServiceID = m.backendID.ServiceID
+		}), false},
+		{"another service's key", forged(func(_ *models.ServiceResponse, k *models.PublicKeyResponse) {
+			k.ServiceID, k.PublicKey = m.callerID.ServiceID, m.callerID.PublicKey()
+		}), false},
+		{"protocol 1.1", forged(func(r *models.ServiceResponse, _ *models.PublicKeyResponse) {
+			r.ProtocolVersion = models.ProtocolVersion11
+		}), false},
+		{"stale", forged(func(r *models.ServiceResponse, _ *models.PublicKeyResponse) {
+			r.Timestamp = time.Now().Add(-time.Hour)
+		}), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			registry := mesh.NewRegistryClient(tt.url, m.callerID, mesh.NewPeerVersions())
+			_, err := registry.PublicKey(m.backendID.ServiceID)
+			if tt.wantOK && err != nil {
+				t.Fatalf("PublicKey: %v", err)
+			}
+			if !tt.wantOK && err == nil {
+				t.Fatal("PublicKey accepted the response")
+			}
+		})
+	}
+
+	// A tampered signature fails even though everything it covers is right.
+	t.Run("tampered signature", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			resp, err := http.Get(m.auth.URL + r.URL.Path)
+			if err != nil {
+				w.WriteHeader(http.StatusBadGateway)
+				return
+			}
+			defer resp.Body.Close()
+			var response models.ServiceResponse
+			json.NewDecoder(resp.Body).Decode(&response)
+			if !strings.HasSuffix(r.URL.Path, "/"+mesh.AuthServiceID) {
+				response.Signature = flipByte(response.Signature)
+			}
+			json.NewEncoder(w).Encode(response)
+		}))
+		t.Cleanup(server.Close)
+
+		registry := mesh.NewRegistryClient(server.URL, m.callerID, mesh.NewPeerVersions())
+		if _, err := registry.PublicKey(m.backendID.ServiceID); err == nil {
+			t.Fatal("PublicKey accepted a response with a tampered signature")
+		}
+	})
+}
+
+// TestIdempotentIgnoresUnsignedKeys checks an idempotency key sent beside a
+// detached signature, rather than in it, cannot tie unrelated requests
+// together.
+func TestIdempotentIgnoresUnsignedKeys(t *testing.T) {
+	m := newTestMesh(t)
+	store, err := mesh.OpenIdempotencyStore("memory", m.backendID.ServiceID)
+	if err != nil {
+		t.Fatalf("OpenIdempotencyStore: %v", err)
+	}
+	defer store.Close()
+
+	var calls atomic.Int32
+	server := httptest.NewServer(m.server.Verified(mesh.Idempotent(store, time.Minute, func(req *mesh.Request) (interface{}, error) {
+		calls.Add(1)
+		return req.Envelope.Data, nil
+	})))
+	t.Cleanup(server.Close)
+
+	for _, body := range [][]byte{[]byte(`{"amount":1}`), []byte(`{"amount":2}`)} {
+		req, _ := http.NewRequest("POST", server.URL, bytes.NewReader(body))
+		req.Header.Set(models.HeaderMeshSignature, detachedToken(t, m.callerID, time.Now(), body))
+		req.Header.Set(models.HeaderIdempotencyKey, "unsigned")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("POST: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("got %d, want 200", resp.StatusCode)
+		}
+	}
+	if got := calls.Load(); got != 2 {
+		t.Fatalf("handler ran %d times, want 2", got)
+	}
+}
@@ -0,0 +1,303 @@
+package mesh
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Outlier detection defaults.
+const (
+	DefaultOutlierConsecutiveFailures = 5
+	DefaultOutlierErrorPercent        = 50
+	DefaultOutlierMinRequests         = 20
+	DefaultOutlierCooldown            = 30 * time.Second
+)
+
+// Outlier events, as kept in the detection history.
+const (
+	OutlierEjected  = "ejected"
+	OutlierReturned = "returned"
+)
+
+// outlierWindow is how many of an upstream's latest calls its error rate and
+// latency percentiles cover.
+const outlierWindow = 100
+
+// outlierHistorySize is how many events an OutlierDetector keeps.
+const outlierHistorySize = 64
+
+// OutlierPolicy says when an upstream is an outlier: after
+// ConsecutiveFailures failed calls in a row, or once ErrorPercent of the
+// calls in its window failed, counting only windows of at least
+// MinRequests calls. A zero threshold turns its check off. Outliers are
+// ejected for Cooldown.
+type OutlierPolicy struct {
+	ConsecutiveFailures int
+	ErrorPercent        int
+	MinRequests         int
+	Cooldown            time.Duration
+}
+
+// OutlierDetector tracks how each upstream, by base URL, answers calls, and
+// ejects those that fail too often. Callers choosing between replicas skip
+// ejected ones, unless every one is ejected; an upstream with no
+// alternative keeps its traffic, and ejecting it only reports it.
+type OutlierDetector struct {
+	policy  OutlierPolicy
+	metrics *Metrics
+
+	mutex     sync.Mutex
+	upstreams map[string]*upstreamHealth
+	history   []OutlierEvent
+	next      int
+}
+
+type upstreamHealth struct {
+	service string
+
+	// window holds the latest calls, of which count are filled and next is
+	// overwritten first.
+	window [outlierWindow]upstreamCall
+	count  int
+	next   int
+
+	requests     uint64
+	failures     uint64
+	consecutive  int
+	lastFailure  string
+	ejections    int
+	ejectedUntil time.Time
+}
+
+type upstreamCall struct {
+	latency time.Duration
+	failed  bool
+}
+
+// OutlierEvent is an upstream being ejected or returned to service.
+type OutlierEvent struct {
+	Time     time.Time `json:"time"`
+	Upstream string    `json:"upstream"`
+	Service  string    `json:"service"`
+	Event    string    `json:"event"`
+	Reason   string    `json:"reason,omitempty"`
+}
+
+// UpstreamState is what an OutlierDetector knows of an upstream. ErrorRate
+// and LatencyMillis, the 50th, 90th and 99th percentiles, cover its latest
+// WindowRequests calls.
+type UpstreamState struct {
+	Upstream            string             `json:"upstream"`
+	Service             string             `json:"service"`
+	Ejected             bool               `json:"ejected"`
+	EjectedUntil        *time.Time         `json:"ejected_until,omitempty"`
+	Ejections           int                `json:"ejections"`
+	Requests            uint64             `json:"requests"`
+	Failures            uint64             `json:"failures"`
+	ConsecutiveFailures int                `json:"consecutive_failures"`
+	LastFailure         string             `json:"last_failure,omitempty"`
+	WindowRequests      int                `json:"window_requests"`
+	ErrorRate           float64            `json:"error_rate"`
+	LatencyMillis       map[string]float64 `json:"latency_ms"`
+}
+
+func NewOutlierDetector(policy OutlierPolicy, metrics *Metrics) *OutlierDetector {
+	return &OutlierDetector{
+		policy:    policy,
+		metrics:   metrics,
+		upstreams: make(map[string]*upstreamHealth),
+		history:   make([]OutlierEvent, 0, outlierHistorySize),
+	}
+}
+
+// Record adds a call to service at upstream that took latency. failure is
+// why the call failed, or empty if the upstream answered.
+func (d *OutlierDetector) Record(service, upstream string, latency time.Duration, failure string) {
+	now := time.Now()
+	result := "success"
+	if failure != "" {
+		result = "failure"
+	}
+	d.metrics.CountUpstreamCall(upstream, result)
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	health := d.health(service, upstream)
+	d.expire(upstream, health, now)
+
+	health.window[health.next] = upstreamCall{latency: latency, failed: failure != ""}
+	health.next = (health.next + 1) % outlierWindow
+	health.count = min(health.count+1, outlierWindow)
+	health.requests++
+	if failure == "" {
+		health.consecutive = 0
+		return
+	}
+	health.failures++
+	health.consecutive++
+	health.lastFailure = failure
+
+	if now.Before(health.ejectedUntil) {
+		return
+	}
+	reason := ""
+	if d.policy.ConsecutiveFailures > 0 && health.consecutive >= d.policy.ConsecutiveFailures {
+		reason = fmt.Sprintf("%d consecutive failures, the last %s", health.consecutive, failure)
+	} else if failed := health.failed(); d.policy.ErrorPercent > 0 && health.count >= max(d.policy.MinRequests, 1) &&
+		failed*100 >= d.policy.ErrorPercent*health.count {
+		reason = fmt.Sprintf("%d of the last %d calls failed", failed, health.count)
+	}
+	if reason == "" {
+		return
+	}
+
+	health.ejections++
+	health.ejectedUntil = now.Add(d.policy.Cooldown)
+	d.metrics.CountUpstreamEjection(upstream)
+	d.metrics.SetUpstreamEjected(upstream, true)
+	d.record(OutlierEvent{Time: now.UTC(), Upstream: upstream, Service: service, Event: OutlierEjected, Reason: reason})
+	log.Printf("🚫 Ejecting %s (%s) for %v: %s", upstream, service, d.policy.Cooldown, reason)
+}
+
+// Available reports whether upstream is in service, i.e. not ejected.
+func (d *OutlierDetector) Available(upstream string) bool {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	health := d.upstreams[upstream]
+	if health == nil {
+		return true
+	}
+	d.expire(upstream, health, time.Now())
+	return health.ejectedUntil.IsZero()
+}
+
+// health returns upstream's record, creating it if needed. d.mutex must be
+// held.
+func (d *OutlierDetector) health(service, upstream string) *upstreamHealth {
+	health := d.upstreams[upstream]
+	if health == nil {
+		health = &upstreamHealth{service: service}
+		d.upstreams[upstream] = health
+	}
+	return health
+}
+
+// expire returns upstream to service once its cooldown is over, with a
+// clean window so its old failures don't eject it again straight away.
+// d.mutex must be held.
+func (d *OutlierDetector) expire(upstream string, health *upstreamHealth, now time.Time) {
+	if health.ejectedUntil.IsZero() || now.Before(health.ejectedUntil) {
+		return
+	}
+	health.ejectedUntil = time.Time{}
+	health.count, health.next, health.consecutive = 0, 0, 0
+	d.metrics.SetUpstreamEjected(upstream, false)
+	d.record(OutlierEvent{Time: now.UTC(), Upstream: upstream, Service: health.service, Event: OutlierReturned})
+	log.Printf("✅ Returning %s (%s) to service", upstream, health.service)
+}
+
+// record keeps event, dropping the oldest once the history is full.
+// d.mutex must be held.
+func (d *OutlierDetector) record(event OutlierEvent) {
+	if len(d.history) < outlierHistorySize {
+		d.history = append(d.history, event)
+	} else {
+		d.history[d.next] = event
+		d.next = (d.next + 1) % outlierHistorySize
+	}
+}
+
+// failed counts the failures in the window.
+func (h *upstreamHealth) failed() int {
+	failed := 0
+	for _, call := range h.window[:h.count] {
+		if call.failed {
+			failed++
+		}
+	}
+	return failed
+}
+
+// State returns what is known of every upstream, sorted by URL, and up to
+// limit of the latest events, oldest first. A limit of zero or less returns
+// every event kept.
+func (d *OutlierDetector) State(limit int) ([]UpstreamState, []OutlierEvent) {
+	now := time.Now()
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	states := make([]UpstreamState, 0, len(d.upstreams))
+	for upstream, health := range d.upstreams {
+		d.expire(upstream, health, now)
+
+		state := UpstreamState{
+			Upstream:            upstream,
+			Service:             health.service,
+			Ejected:             !health.ejectedUntil.IsZero(),
+			Ejections:           health.ejections,
+			Requests:            health.requests,
+			Failures:            health.failures,
+			ConsecutiveFailures: health.consecutive,
+			LastFailure:         health.lastFailure,
+			WindowRequests:      health.count,
+			LatencyMillis:       make(map[string]float64),
+		}
+		if state.Ejected {
+			until := health.ejectedUntil.UTC()
+			state.EjectedUntil = &until
+		}
+		if health.count > 0 {
+			state.ErrorRate = float64(health.failed()) / float64(health.count)
+
+			latencies := make([]time.Duration, health.count)
+			for i, call := range health.window[:health.count] {
+				latencies[i] = call.latency
+			}
+			sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+			for _, percentile := range []int{50, 90, 99} {
+				latency := latencies[(len(latencies)-1)*percentile/100]
+				state.LatencyMillis["p"+strconv.Itoa(percentile)] = float64(latency.Microseconds()) / 1000
+			}
+		}
+		states = append(states, state)
+	}
+	sort.Slice(states, func(i, j int) bool { return states[i].Upstream < states[j].Upstream })
+
+	events := make([]OutlierEvent, 0, len(d.history))
+	events = append(events, d.history[d.next:]...)
+	events = append(events, d.history[:d.next]...)
+	if limit > 0 && len(events) > limit {
+		events = events[len(events)-limit:]
+	}
+	return states, events
+}
+
+// Handler serves every upstream's state and the latest events as JSON, as
+// many events as the limit query parameter asks for.
+func (d *OutlierDetector) Handler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+		states, events := d.State(limit)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"policy": map[string]interface{}{
+				"consecutive_failures": d.policy.ConsecutiveFailures,
+				"error_percent":        d.policy.ErrorPercent,
+				"min_requests":         d.policy.MinRequests,
+				"cooldown":             d.policy.Cooldown.String(),
+			},
+			"upstreams": states,
+			"history":   events,
+		})
+	}
+}
@@ -0,0 +1,206 @@
+package mesh
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+
+	"quantum-safe-mesh/pkg/models"
+	"quantum-safe-mesh/pkg/pqc"
+)
+
+// Large result sets are answered a page at a time, each page a signed
+// response of its own. A signature then vouches for one page but not for
+// the set: a page dropped or answered twice would go unnoticed. So pages
+// are numbered, and the last one carries a digest over every item of the
+// set, which a client checks against the items it read.
+
+const (
+	DefaultPageSize = 100
+	MaxPageSize     = 1000
+)
+
+// ErrNotPaginated is returned by ReadPages when the peer answered with the
+// whole result set rather than a page, as peers that predate pagination do.
+var ErrNotPaginated = errors.New("response is not paginated")
+
+// PageQuery is the page a client asked for: the one after the item keyed
+// After, numbered Page, of at most Size items.
+type PageQuery struct {
+	After string
+	Page  int
+	Size  int
+}
+
+type pageCursor struct {
+	After string `json:"after"`
+	Page  int    `json:"page"`
+}
+
+// ParsePageQuery reads the cursor and page_size query parameters of r. It
+// reports false if r asks for neither, so an endpoint can keep answering
+// clients that predate pagination with the whole set.
+func ParsePageQuery(r *http.Request) (PageQuery, bool, error) {
+	query := PageQuery{Page: 1, Size: DefaultPageSize}
+	values := r.URL.Query()
+	if !values.Has("cursor") && !values.Has("page_size") {
+		return query, false, nil
+	}
+
+	if value := values.Get("page_size"); value != "" {
+		size, err := strconv.Atoi(value)
+		if err != nil || size < 1 || size > MaxPageSize {
+			return query, true, models.NewError(http.StatusBadRequest, models.ErrCodeInvalidRequest,
+				fmt.Sprintf("page_size must be between 1 and %d", MaxPageSize))
+		}
+		query.Size = size
+	}
+
+	if value := values.Get("cursor"); value != "" {
+		var cursor pageCursor
+		decoded, err := base64.RawURLEncoding.DecodeString(value)
+		if err == nil {
+			err = json.Unmarshal(decoded, &cursor)
+		}
+		if err != nil || cursor.Page < 2 {
+			return query, true, models.NewError(http.StatusBadRequest, models.ErrCodeInvalidRequest, "Invalid cursor")
+		}
+		query.After, query.Page = cursor.After, cursor.Page
+	}
+	return query, true, nil
+}
+
+// Paginate answers query with a page of items, which must be sorted by
+// key. Cursors name the last item of a page rather than its position, so
+// items added or removed while a client reads the set shift no page; the
+// digest on the last page, taken over the set as it is then, tells the
+// client that it changed.
+func Paginate[T any](query PageQuery, items []T, key func(T) string) (*models.Page, error) {
+	start := 0
+	if query.After != "" {
+		start = sort.Search(len(items), func(i int) bool { return key(items[i]) > query.After })
+	}
+	end := min(start+query.Size, len(items))
+
+	pageItems := items[start:end]
+	if pageItems == nil {
+		pageItems = []T{}
+	}
+	encoded, err := json.Marshal(pageItems)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal page: %w", err)
+	}
+	page := &models.Page{Items: encoded, Page: query.Page, Total: len(items)}
+
+	if end < len(items) {
+		cursor, err := json.Marshal(pageCursor{After: key(items[end-1]), Page: query.Page + 1})
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal cursor: %w", err)
+		}
+		page.NextCursor = base64.RawURLEncoding.EncodeToString(cursor)
+		return page, nil
+	}
+
+	digest := newResultDigest()
+	for _, item := range items {
+		if err := digest.add(item); err != nil {
+			return nil, err
+		}
+	}
+	page.Digest = digest.sum()
+	return page, nil
+}
+
+// PagePath returns path with the query asking for the page after cursor,
+// or for the first page if cursor is empty.
+func PagePath(path, cursor string, size int) string {
+	query := url.Values{"page_size": {strconv.Itoa(size)}}
+	if cursor != "" {
+		query.Set("cursor", cursor)
+	}
+	return path + "?" + query.Encode()
+}
+
+// ReadPages reads a paginated result set, fetching the Data of each page
+// from its verified response with fetch, and checks that the pages came in
+// order and that the last page's digest matches the items read.
+func ReadPages[T any](fetch func(cursor string) (json.RawMessage, error)) ([]T, error) {
+	var items []T
+	digest := newResultDigest()
+	cursor := ""
+
+	for number := 1; ; number++ {
+		data, err := fetch(cursor)
+		if err != nil {
+			return nil, err
+		}
+		var page models.Page
+		if err := json.Unmarshal(data, &page); err != nil {
+			return nil, fmt.Errorf("failed to decode page: %w", err)
+		}
+		if page.Page == 0 && number == 1 {
+			return nil, ErrNotPaginated
+		}
+		if page.Page != number {
+			return nil, fmt.Errorf("expected page %d, got page %d", number, page.Page)
+		}
+
+		var raw []json.RawMessage
+		if err := json.Unmarshal(page.Items, &raw); err != nil {
+			return nil, fmt.Errorf("failed to decode page %d: %w", number, err)
+		}
+		for _, item := range raw {
+			var decoded T
+			if err := json.Unmarshal(item, &decoded); err != nil {
+				return nil, fmt.Errorf("failed to decode item on page %d: %w", number, err)
+			}
+			if err := digest.add(item); err != nil {
+				return nil, err
+			}
+			items = append(items, decoded)
+		}
+
+		if page.NextCursor == "" {
+			if len(items) != page.Total || page.Digest != digest.sum() {
+				return nil, fmt.Errorf("read %d items that do not match the %d-item result set the last page describes", len(items), page.Total)
+			}
+			return items, nil
+		}
+		if len(items) >= page.Total {
+			return nil, fmt.Errorf("page %d has a cursor but all %d items were read", number, page.Total)
+		}
+		cursor = page.NextCursor
+	}
+}
+
+// resultDigest hashes the canonical JSON of each item of a result set, one
+// per line, so it doesn't depend on how the set was cut into pages.
+type resultDigest struct {
+	hash hash.Hash
+}
+
+func newResultDigest() *resultDigest {
+	return &resultDigest{hash: sha256.New()}
+}
+
+func (d *resultDigest) add(item interface{}) error {
+	encoded, err := pqc.CanonicalJSON(item)
+	if err != nil {
+		return fmt.Errorf("failed to encode item: %w", err)
+	}
+	d.hash.Write(encoded)
+	d.hash.Write([]byte("\n"))
+	return nil
+}
+
+func (d *resultDigest) sum() string {
+	return hex.EncodeToString(d.hash.Sum(nil))
+}
@@ -0,0 +1,66 @@
+package mesh
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// PathSet is a set of gateway routes, each an exact path, or a prefix
+// written as /prefix/* that matches everything below it.
+type PathSet struct {
+	exact    map[string]bool
+	prefixes []string
+}
+
+// ParsePublicPaths parses a comma-separated list of public paths, the
+// gateway routes anyone may call: they skip caller authentication and are
+// forwarded to the backend without a signature or the gateway's identity.
+// It returns nil if there are none.
+func ParsePublicPaths(value string) (*PathSet, error) {
+	return parsePathSet(value, "public path")
+}
+
+func parsePathSet(value, kind string) (*PathSet, error) {
+	paths := &PathSet{exact: make(map[string]bool)}
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		// "/*" would take in the whole gateway, so a prefix must name at
+		// least one segment.
+		prefix, wildcard := strings.CutSuffix(entry, "/*")
+		if !strings.HasPrefix(prefix, "/") || strings.Contains(prefix, "*") || path.Clean(prefix) != prefix {
+			return nil, fmt.Errorf("invalid %s %q: expected a clean /path or /prefix/*", kind, entry)
+		}
+		if wildcard {
+			paths.prefixes = append(paths.prefixes, prefix+"/")
+		} else {
+			paths.exact[entry] = true
+		}
+	}
+
+	if len(paths.exact) == 0 && len(paths.prefixes) == 0 {
+		return nil, nil
+	}
+	return paths, nil
+}
+
+// Match reports whether p holds requestPath. Paths that are not clean, e.g.
+// that climb out of a prefix with "..", never match.
+func (p *PathSet) Match(requestPath string) bool {
+	if path.Clean(requestPath) != requestPath {
+		return false
+	}
+	if p.exact[requestPath] {
+		return true
+	}
+	for _, prefix := range p.prefixes {
+		if strings.HasPrefix(requestPath, prefix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,185 @@
+package mesh
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"quantum-safe-mesh/pkg/models"
+)
+
+// RateLimits caps how often each verified caller may call a service's
+// routes. It is kept by the service itself, apart from any limit in front
+// of it, so a compromised or misbehaving gateway can't flood it. Each
+// caller gets a token bucket per route that holds up to the route's limit
+// and refills at that rate.
+type RateLimits struct {
+	mutex     sync.Mutex
+	routes    []rateRoute
+	buckets   map[rateKey]*rateBucket
+	lastSweep time.Time
+}
+
+type rateRoute struct {
+	prefix string
+	limit  float64
+	per    time.Duration
+}
+
+type rateKey struct {
+	caller string
+	prefix string
+}
+
+type rateBucket struct {
+	tokens  float64
+	updated time.Time
+	per     time.Duration // how long the bucket takes to refill
+}
+
+// NewRateLimits returns limits that limit no route until Update is called.
+func NewRateLimits() *RateLimits {
+	return &RateLimits{buckets: make(map[rateKey]*rateBucket)}
+}
+
+// ParseRateLimits parses comma-separated /path-prefix=rate pairs, where a
+// rate is a number of requests per s, m, h or any duration, as in
+// /process=10/s or /files=100/5m. The longest matching prefix wins. It
+// returns nil if no route is limited.
+func ParseRateLimits(value string) (*RateLimits, error) {
+	limits := NewRateLimits()
+	if err := limits.Update(value); err != nil {
+		return nil, err
+	}
+	if len(limits.routes) == 0 {
+		return nil, nil
+	}
+	return limits, nil
+}
+
+// Update replaces the limits with those value gives, in ParseRateLimits'
+// format. Callers keep what is left in their buckets, up to the new limit.
+func (l *RateLimits) Update(value string) error {
+	var routes []rateRoute
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		prefix, rate, found := strings.Cut(entry, "=")
+		if !found || !strings.HasPrefix(prefix, "/") {
+			return fmt.Errorf("invalid rate limit %q: expected /path-prefix=requests/period", entry)
+		}
+		count, period, found := strings.Cut(strings.TrimSpace(rate), "/")
+		limit, err := strconv.Atoi(count)
+		if !found || err != nil || limit < 1 {
+			return fmt.Errorf("invalid rate %q for %s: expected a positive number of requests per period", rate, prefix)
+		}
+		per, err := parseRatePeriod(period)
+		if err != nil {
+			return fmt.Errorf("invalid rate %q for %s: %w", rate, prefix, err)
+		}
+		routes = append(routes, rateRoute{prefix: prefix, limit: float64(limit), per: per})
+	}
+	sort.SliceStable(routes, func(i, j int) bool {
+		return len(routes[i].prefix) > len(routes[j].prefix)
+	})
+
+	l.mutex.Lock()
+	l.routes = routes
+	l.mutex.Unlock()
+	return nil
+}
+
+func parseRatePeriod(period string) (time.Duration, error) {
+	switch period {
+	case "s":
+		return time.Second, nil
+	case "m":
+		return time.Minute, nil
+	case "h":
+		return time.Hour, nil
+	}
+	duration, err := time.ParseDuration(period)
+	if err != nil || duration <= 0 {
+		return 0, fmt.Errorf("expected a period of s, m, h or a positive duration")
+	}
+	return duration, nil
+}
+
+// Allow takes a token from caller's bucket for path. If the bucket is
+// empty it returns false and how long until it holds a token again.
+// Paths under no limited route are always allowed.
+func (l *RateLimits) Allow(caller, path string) (bool, time.Duration) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	route, limited := l.route(path)
+	if !limited {
+		return true, 0
+	}
+	rate := route.limit / route.per.Seconds()
+	now := time.Now()
+
+	l.sweep(now)
+
+	key := rateKey{caller: caller, prefix: route.prefix}
+	bucket, exists := l.buckets[key]
+	if !exists {
+		bucket = &rateBucket{tokens: route.limit, updated: now, per: route.per}
+		l.buckets[key] = bucket
+	}
+	bucket.tokens = math.Min(route.limit, bucket.tokens+now.Sub(bucket.updated).Seconds()*rate)
+	bucket.updated = now
+
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - bucket.tokens) / rate * float64(time.Second))
+}
+
+func (l *RateLimits) route(path string) (rateRoute, bool) {
+	for _, route := range l.routes {
+		if path == route.prefix || strings.HasPrefix(path, strings.TrimSuffix(route.prefix, "/")+"/") {
+			return route, true
+		}
+	}
+	return rateRoute{}, false
+}
+
+// sweep forgets buckets that have refilled, which a new bucket would
+// start out as anyway.
+func (l *RateLimits) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < time.Minute {
+		return
+	}
+	for key, bucket := range l.buckets {
+		if now.Sub(bucket.updated) >= bucket.per {
+			delete(l.buckets, key)
+		}
+	}
+	l.lastSweep = now
+}
+
+// Limit wraps h so a caller over its limit for the request's path is
+// refused with 429 rate_limited, saying when to retry.
+func (l *RateLimits) Limit(h Handler) Handler {
+	return func(req *Request) (interface{}, error) {
+		caller := req.Envelope.ServiceID
+		if allowed, wait := l.Allow(caller, req.URL.Path); !allowed {
+			log.Printf("🚫 %s is over its rate limit for %s", caller, req.URL.Path)
+			errResp := models.NewError(http.StatusTooManyRequests, models.ErrCodeRateLimited, "Rate limit exceeded")
+			errResp.RetryAfter = int(math.Ceil(wait.Seconds()))
+			return nil, errResp
+		}
+		return h(req)
+	}
+}
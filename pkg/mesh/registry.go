@@ -0,0 +1,921 @@
+package mesh
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"quantum-safe-mesh/pkg/bufpool"
+	"quantum-safe-mesh/pkg/buildinfo"
+	"quantum-safe-mesh/pkg/cloudattest"
+	"quantum-safe-mesh/pkg/httpclient"
+	"quantum-safe-mesh/pkg/kubeauth"
+	"quantum-safe-mesh/pkg/models"
+	"quantum-safe-mesh/pkg/pqc"
+	"quantum-safe-mesh/pkg/spiffe"
+	"quantum-safe-mesh/pkg/telemetry"
+)
+
+// AuthServiceID is the service ID the auth service registers itself under.
+const AuthServiceID = "auth-service"
+
+// keyCacheTTL bounds how long a fetched public key is trusted, so rotated
+// and revoked keys stop verifying without restarting every peer.
+const keyCacheTTL = 5 * time.Minute
+
+// DefaultKeyLookupTimeout bounds fetching a key from the auth service, so a
+// slow auth service holds up requests for at most this long before the
+// registry snapshot, if any, is tried.
+const DefaultKeyLookupTimeout = 5 * time.Second
+
+// keyRefetchInterval is how soon a cached key may be refetched because a
+// signature named another key, so bogus key IDs cannot flood the auth
+// service.
+const keyRefetchInterval = 10 * time.Second
+
+type cachedKey struct {
+	publicKey []byte
+	keyID     string
+	algorithm string
+	fetched   time.Time
+	offline   time.Time // expiry of the registry snapshot the key came from, if it did
+}
+
+// RegistryClient talks to the auth service on behalf of an identity:
+// registering it, resolving peers' public keys, and exchanging Kyber keys.
+type RegistryClient struct {
+	authURL  string
+	identity *Identity
+	versions *PeerVersions
+	client   *http.Client
+	timeout  time.Duration // bounds each key lookup
+	mutex    sync.RWMutex
+	cache    map[string]cachedKey // serviceID -> public signing key
+	fetches  flightGroup[string, cachedKey]
+	svid     func() (string, error)
+	token    string
+	saToken  string // file holding a projected ServiceAccount token
+	cloud    *cloudattest.Client
+	address  string
+	resolve  Resolver
+	pins     *pqc.PinStore
+
+	registered atomic.Bool
+
+	snapshotMutex   sync.Mutex
+	snapshotFile    string
+	snapshotSigner  string
+	snapshotModTime time.Time
+	snapshot        *models.SignedRegistrySnapshot
+}
+
+func NewRegistryClient(authURL string, identity *Identity, versions *PeerVersions) *RegistryClient {
+	return &RegistryClient{
+		authURL:  authURL,
+		identity: identity,
+		versions: versions,
+		client:   httpclient.NewClient(30 * time.Second),
+		timeout:  DefaultKeyLookupTimeout,
+		cache:    make(map[string]cachedKey),
+	}
+}
+
+// UseSPIFFE makes Register authenticate with a JWT-SVID fetched from the
+// SPIRE agent at socketPath, so the auth service can bind this identity's
+// key to its SPIFFE ID.
+func (c *RegistryClient) UseSPIFFE(socketPath string) {
+	c.svid = func() (string, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		token, spiffeID, err := spiffe.FetchJWTSVID(ctx, socketPath, spiffe.Audience)
+		if err != nil {
+			return "", err
+		}
+
+		log.Printf("🪪 Presenting SPIFFE ID %s to Auth Service", spiffeID)
+		return token, nil
+	}
+}
+
+// UseServiceAccountToken makes Register present the projected Kubernetes
+// ServiceAccount token in file, so the auth service can bind this
+// identity's key to its service account.
+func (c *RegistryClient) UseServiceAccountToken(file string) {
+	c.saToken = file
+}
+
+// UseCloudAttestation makes Register present an instance identity
+// attestation from attestor, so the auth service can bind this identity's
+// key to the instance it runs on.
+func (c *RegistryClient) UseCloudAttestation(attestor *cloudattest.Client) {
+	c.cloud = attestor
+}
+
+// UseBootstrapToken makes Register present token, issued by a trusted
+// identity such as the Kubernetes operator, to vouch for this identity.
+func (c *RegistryClient) UseBootstrapToken(token string) {
+	c.token = token
+}
+
+// Advertise makes Register publish address as the base URL peers can reach
+// this identity at.
+func (c *RegistryClient) Advertise(address string) {
+	c.address = address
+}
+
+// UseResolver looks the auth service up through resolve on each request,
+// falling back to the configured URL when it fails.
+func (c *RegistryClient) UseResolver(resolve Resolver) {
+	c.resolve = resolve
+}
+
+// UsePinStore checks every peer key fetched from the auth service against
+// pins, so a key the registry swapped without a rotation record signed by
+// the pinned key is refused.
+func (c *RegistryClient) UsePinStore(pins *pqc.PinStore) {
+	c.pins = pins
+}
+
+// UseSnapshotFile falls back to the signed registry snapshot in path for
+// lookups while the auth service is unreachable, for as long as the
+// snapshot is valid. The snapshot must be signed with the auth service key
+// signerKeyID; the file is read again whenever it changes, so a newer
+// snapshot can be dropped in place.
+func (c *RegistryClient) UseSnapshotFile(path, signerKeyID string) {
+	c.snapshotMutex.Lock()
+	defer c.snapshotMutex.Unlock()
+	c.snapshotFile, c.snapshotSigner = path, signerKeyID
+	c.reloadSnapshot()
+}
+
+// SetTimeout bounds each request to the auth service, retries included.
+func (c *RegistryClient) SetTimeout(timeout time.Duration) {
+	c.client.Timeout = timeout
+}
+
+// SetKeyLookupTimeout bounds fetching a key from the auth service, which
+// the requests waiting on it are held up by.
+func (c *RegistryClient) SetKeyLookupTimeout(timeout time.Duration) {
+	c.timeout = timeout
+}
+
+func (c *RegistryClient) baseURL() string {
+	return resolveURL(c.resolve, AuthServiceID, c.authURL)
+}
+
+func (c *RegistryClient) Register() error {
+	log.Println("📝 Registering with Auth Service...")
+
+	keyPair := models.ServiceKeyPair{
+		ProtocolVersion: models.WireProtocolVersion(c.versions.Get(AuthServiceID)),
+		ServiceID:       c.identity.ServiceID,
+		PublicKey:       c.identity.PublicKey(),
+		Address:         c.address,
+		Algorithm:       c.identity.Signer.Algorithm(),
+		KyberPublicKey:  c.identity.Kyber.GetPublicKeyBytes(),
+	}
+	if kemAlgorithm := c.identity.Kyber.Algorithm(); kemAlgorithm != pqc.AlgorithmKyber768 {
+		keyPair.KEMAlgorithm = kemAlgorithm
+	}
+	if rotations, err := pqc.LoadRotationRecords(c.identity.ServiceID); err != nil {
+		log.Printf("⚠️  Registering without rotation records: %v", err)
+	} else {
+		keyPair.Rotations = rotations
+	}
+
+	headers := make(map[string]string)
+	build := buildinfo.Get(c.identity.Signer.Algorithm(), c.identity.Kyber.Algorithm())
+	if models.ProtocolVersionAtLeast(keyPair.ProtocolVersion, models.ProtocolVersion13) {
+		keyPair.Build = &build
+	} else if encoded, err := json.Marshal(build); err == nil {
+		// Pre-1.3 auth services re-marshal the registration to verify it
+		// and would drop a field they don't know, so until the auth service
+		// has answered the build rides in the signed headers.
+		headers[models.HeaderBuildInfo] = string(encoded)
+	}
+	if c.svid != nil {
+		token, err := c.svid()
+		if err != nil {
+			return fmt.Errorf("failed to obtain SPIFFE credentials: %w", err)
+		}
+		headers["Authorization"] = "Bearer " + token
+	}
+	if c.saToken != "" {
+		token, err := kubeauth.ReadToken(c.saToken)
+		if err != nil {
+			return err
+		}
+		log.Println("☸️  Presenting ServiceAccount token to Auth Service")
+		headers[models.HeaderServiceAccountToken] = token
+	}
+	if c.cloud != nil {
+		attestation, err := c.cloud.Attest(c.identity.PublicKey())
+		if err != nil {
+			return fmt.Errorf("failed to obtain instance identity: %w", err)
+		}
+		log.Printf("☁️  Presenting %s instance identity to Auth Service", c.cloud.Provider())
+		headers[models.HeaderCloudAttestation] = attestation
+	}
+	if c.token != "" {
+		headers[models.HeaderBootstrapToken] = c.token
+	}
+
+	if err := c.register(&keyPair, headers); err != nil {
+		return err
+	}
+	c.registered.Store(true)
+	log.Println("✅ Successfully registered with Auth Service")
+	return nil
+}
+
+// CheckRegistered is a health check failing until Register succeeds.
+func (c *RegistryClient) CheckRegistered(ctx context.Context) error {
+	if !c.registered.Load() {
+		return fmt.Errorf("%s is not registered with the auth service", c.identity.ServiceID)
+	}
+	return nil
+}
+
+// CheckAuth is a health check failing while the auth service does not
+// answer its liveness probe.
+func (c *RegistryClient) CheckAuth(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL()+"/healthz", nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("auth service unreachable: %w", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("auth service unhealthy: %s", resp.Status)
+	}
+	return nil
+}
+
+// RegisterDelegated registers workload's key on its behalf, vouching for it
+// with a delegation valid for ttl. This identity must be a delegator the
+// auth service trusts, or the subject of upstream, the chain by which
+// trusted delegators let it register workloads like this one.
+func (c *RegistryClient) RegisterDelegated(workload *models.WorkloadRegistration, upstream []models.DelegationAssertion, ttl time.Duration) error {
+	link, err := c.identity.SignDelegation(models.DelegationAssertion{
+		Subject:   workload.ServiceID,
+		PublicKey: workload.PublicKey,
+		Algorithm: workload.Algorithm,
+	}, ttl)
+	if err != nil {
+		return err
+	}
+
+	keyPair := models.ServiceKeyPair{
+		ProtocolVersion: models.WireProtocolVersion(c.versions.Get(AuthServiceID)),
+		ServiceID:       workload.ServiceID,
+		PublicKey:       workload.PublicKey,
+		Address:         workload.Address,
+		Algorithm:       workload.Algorithm,
+		Delegation:      append(append([]models.DelegationAssertion(nil), upstream...), *link),
+	}
+	if err := c.register(&keyPair, nil); err != nil {
+		return err
+	}
+	log.Printf("✅ Registered %s with Auth Service on its behalf", workload.ServiceID)
+	return nil
+}
+
+// register sends keyPair in an envelope signed by the client's identity,
+// the key being registered or the delegator vouching for it, with the
+// credentials in headers.
+func (c *RegistryClient) register(keyPair *models.ServiceKeyPair, headers map[string]string) error {
+	if _, err := c.call("/register", keyPair, headers); err != nil {
+		return fmt.Errorf("failed to register with auth service: %w", err)
+	}
+	return nil
+}
+
+// PublicKey returns serviceID's registered public signing key, fetching it
+// from the auth service on first use and again once it is keyCacheTTL old.
+// It satisfies pqc.PublicKeyLookup.
+func (c *RegistryClient) PublicKey(serviceID string) ([]byte, error) {
+	return c.PublicKeyContext(context.Background(), serviceID)
+}
+
+// PublicKeyContext is PublicKey for a request that stops waiting for the
+// key when ctx is done.
+func (c *RegistryClient) PublicKeyContext(ctx context.Context, serviceID string) ([]byte, error) {
+	key, err := c.cachedKey(ctx, serviceID)
+	if err != nil {
+		return nil, err
+	}
+	return key.publicKey, nil
+}
+
+// ResolveKey returns serviceID's registered key, which must be the key
+// keyID if that is set. A keyID naming another key refetches the key, at
+// most every keyRefetchInterval, in case the service has just rotated. It
+// satisfies pqc.ContextKeyResolver.
+func (c *RegistryClient) ResolveKey(serviceID, keyID string) ([]byte, error) {
+	return c.ResolveKeyContext(context.Background(), serviceID, keyID)
+}
+
+// ResolveKeyContext is ResolveKey for a request that stops waiting for the
+// key when ctx is done.
+func (c *RegistryClient) ResolveKeyContext(ctx context.Context, serviceID, keyID string) ([]byte, error) {
+	key, err := c.cachedKey(ctx, serviceID)
+	if err != nil {
+		return nil, err
+	}
+	if keyID != "" && keyID != key.keyID && time.Since(key.fetched) > keyRefetchInterval {
+		if key, err = c.fetchKey(ctx, serviceID); err != nil {
+			return nil, err
+		}
+	}
+	if keyID != "" && keyID != key.keyID {
+		return nil, fmt.Errorf("signed with key %s, registry has %s", keyID, key.keyID)
+	}
+	return key.publicKey, nil
+}
+
+// Algorithm returns the signature algorithm of serviceID's registered key,
+// cached along with the key.
+func (c *RegistryClient) Algorithm(serviceID string) (string, error) {
+	key, err := c.cachedKey(context.Background(), serviceID)
+	if err != nil {
+		return "", err
+	}
+	return key.algorithm, nil
+}
+
+func (c *RegistryClient) cachedKey(ctx context.Context, serviceID string) (cachedKey, error) {
+	c.mutex.RLock()
+	if cached, exists := c.cache[serviceID]; exists && time.Since(cached.fetched) < keyCacheTTL &&
+		(cached.offline.IsZero() || time.Now().Before(cached.offline)) {
+		c.mutex.RUnlock()
+		return cached, nil
+	}
+	c.mutex.RUnlock()
+	return c.fetchKey(ctx, serviceID)
+}
+
+// fetchKey fetches serviceID's key and caches it. Concurrent fetches of the
+// same key share one lookup, so a cold cache doesn't send the auth service
+// a request per caller. The lookup is bounded by the key lookup timeout
+// rather than by ctx, since others may be waiting on it; ctx only stops
+// this caller waiting.
+func (c *RegistryClient) fetchKey(ctx context.Context, serviceID string) (cachedKey, error) {
+	key, err, _ := c.fetches.do(ctx, serviceID, func() (cachedKey, error) {
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), c.timeout)
+		defer cancel()
+		return c.lookupKey(ctx, serviceID)
+	})
+	return key, err
+}
+
+func (c *RegistryClient) lookupKey(ctx context.Context, serviceID string) (cachedKey, error) {
+	log.Printf("🔍 Fetching public key for service: %s", serviceID)
+
+	ctx, fetched := telemetry.Observe(ctx, telemetry.OpKeyFetch, "")
+	registration, offline, err := c.lookup(ctx, serviceID)
+	fetched(err)
+	if err != nil {
+		return cachedKey{}, err
+	}
+	algorithm, err := pqc.SignatureAlgorithm(registration.Algorithm)
+	if err != nil {
+		return cachedKey{}, fmt.Errorf("service %s: %w", serviceID, err)
+	}
+	if c.pins != nil {
+		if err := c.pins.Check(serviceID, algorithm, registration.PublicKey, registration.Rotations); err != nil {
+			return cachedKey{}, err
+		}
+	}
+	key := cachedKey{
+		publicKey: []byte(registration.PublicKey),
+		keyID:     pqc.KeyFingerprint(registration.PublicKey),
+		algorithm: algorithm,
+		fetched:   time.Now(),
+		offline:   offline,
+	}
+
+	c.mutex.Lock()
+	c.cache[serviceID] = key
+	c.mutex.Unlock()
+
+	return key, nil
+}
+
+// Rotate replaces this identity's registered key with newKey. The request is
+// signed with the current key and carries newKey's proof of possession; the
+// caller switches the identity to newKey once it succeeds.
+func (c *RegistryClient) Rotate(newKey pqc.Signer) (map[string]interface{}, error) {
+	return c.RotateService(c.identity.ServiceID, newKey)
+}
+
+// RotateIdentity replaces this identity's key with a new one of the same
+// algorithm: the auth service accepts it via Rotate, then the identity saves
+// and switches to it. See Identity.ReplaceKey for when that is safe.
+func (c *RegistryClient) RotateIdentity() error {
+	if _, err := c.identity.localKyber(); err != nil {
+		return err
+	}
+
+	algorithm := c.identity.Signer.Algorithm()
+	newKey, err := pqc.GenerateSigner(algorithm)
+	if err != nil {
+		return fmt.Errorf("failed to generate %s keypair: %w", algorithm, err)
+	}
+
+	if _, err := c.Rotate(newKey); err != nil {
+		return err
+	}
+
+	// The auth service already trusts only the new key: use it even if it
+	// cannot be saved, though the identity is locked out once it restarts.
+	if err := c.identity.ReplaceKey(newKey); err != nil {
+		c.identity.Signer = newKey
+		return fmt.Errorf("key rotated but not saved: %w", err)
+	}
+	return nil
+}
+
+// RotateService replaces serviceID's registered key with newKey. Unless
+// serviceID is this identity, the auth service must list this identity as
+// an administrator. A service rotating its own key also signs a rotation
+// record with the current key, which is kept in the key store, for peers
+// that pinned it.
+func (c *RegistryClient) RotateService(serviceID string, newKey pqc.Signer) (map[string]interface{}, error) {
+	rotation, err := c.rotationRequest(serviceID, newKey)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := c.callSigned("/rotate", rotation)
+	if err != nil {
+		return nil, err
+	}
+	c.saveRotationRecord(rotation)
+	return result, nil
+}
+
+// MigrateService moves serviceID's registered keys to newKey and
+// kemPublicKey, a key of kemAlgorithm, in one step, e.g. to other
+// algorithms; rollback marks a migration back to the keys an earlier one
+// replaced. A nil newKey or empty kemPublicKey keeps that key. The signing
+// key is rotated as by RotateService.
+func (c *RegistryClient) MigrateService(serviceID string, newKey pqc.Signer, kemAlgorithm string, kemPublicKey []byte, rollback bool) (map[string]interface{}, error) {
+	migration := models.KeyMigrationRequest{
+		ServiceID: serviceID,
+		Rollback:  rollback,
+	}
+	if newKey != nil {
+		rotation, err := c.rotationRequest(serviceID, newKey)
+		if err != nil {
+			return nil, err
+		}
+		migration.Rotation = rotation
+	}
+	if len(kemPublicKey) > 0 {
+		migration.KEMPublicKey = kemPublicKey
+		if kemAlgorithm != pqc.AlgorithmKyber768 {
+			migration.KEMAlgorithm = kemAlgorithm
+		}
+	}
+
+	result, err := c.callSigned("/migrate", migration)
+	if err != nil {
+		return nil, err
+	}
+	if migration.Rotation != nil {
+		c.saveRotationRecord(migration.Rotation)
+	}
+	return result, nil
+}
+
+// rotationRequest builds the request rotating serviceID's key to newKey,
+// with newKey's proof of possession and, for this identity, a rotation
+// record signed with the current key.
+func (c *RegistryClient) rotationRequest(serviceID string, newKey pqc.Signer) (*models.KeyRotationRequest, error) {
+	rotation := &models.KeyRotationRequest{
+		ServiceID:    serviceID,
+		NewPublicKey: newKey.GetPublicKeyBytes(),
+	}
+	if newKey.Algorithm() != pqc.AlgorithmDilithium3 {
+		rotation.Algorithm = newKey.Algorithm()
+	}
+	if serviceID == c.identity.ServiceID {
+		record, err := pqc.SignRotationRecord(serviceID, c.identity.Signer, newKey)
+		if err != nil {
+			return nil, err
+		}
+		rotation.Record = record
+	}
+
+	proofPayload, err := rotation.ProofPayload()
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal rotation request: %w", err)
+	}
+
+	rotation.Proof, err = newKey.Sign(proofPayload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign proof of possession: %w", err)
+	}
+	return rotation, nil
+}
+
+// saveRotationRecord keeps the record of an accepted rotation, if it has
+// one, in the key store.
+func (c *RegistryClient) saveRotationRecord(rotation *models.KeyRotationRequest) {
+	if rotation.Record == nil {
+		return
+	}
+	if err := pqc.SaveRotationRecord(rotation.ServiceID, rotation.Record); err != nil {
+		log.Printf("⚠️  Key rotated but its rotation record was not saved: %v", err)
+	}
+}
+
+// Revoke removes this identity from the registry. Its key stops verifying
+// anywhere once peers' caches expire.
+func (c *RegistryClient) Revoke() (map[string]interface{}, error) {
+	return c.RevokeService(c.identity.ServiceID)
+}
+
+// RevokeService removes serviceID from the registry. Unless serviceID is
+// this identity, the auth service must list this identity as an
+// administrator.
+func (c *RegistryClient) RevokeService(serviceID string) (map[string]interface{}, error) {
+	return c.callSigned("/revoke", models.RevocationRequest{ServiceID: serviceID})
+}
+
+// Services lists the service IDs registered with the auth service, read a
+// page at a time and checked for completeness against the last page's
+// digest.
+func (c *RegistryClient) Services() ([]string, error) {
+	listings, err := ReadPages[models.ServiceListing](func(cursor string) (json.RawMessage, error) {
+		return c.call(PagePath("/services", cursor, DefaultPageSize), nil, nil)
+	})
+	if errors.Is(err, ErrNotPaginated) {
+		return c.allServices()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	services := make([]string, len(listings))
+	for i, listing := range listings {
+		services[i] = listing.ServiceID
+	}
+	return services, nil
+}
+
+// allServices lists services from an auth service that predates
+// pagination and answers with all of them at once.
+func (c *RegistryClient) allServices() ([]string, error) {
+	result, err := c.callSigned("/services", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var services []string
+	if list, ok := result["services"].([]interface{}); ok {
+		for _, service := range list {
+			if serviceID, ok := service.(string); ok {
+				services = append(services, serviceID)
+			}
+		}
+	}
+	return services, nil
+}
+
+// callSigned sends payload to the auth service in a signed envelope and
+// returns the Data of its verified response.
+func (c *RegistryClient) callSigned(path string, payload interface{}) (map[string]interface{}, error) {
+	data, err := c.call(path, payload, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var result map[string]interface{}
+	if err := json.Unmarshal(data, &result); err != nil {
+		return nil, fmt.Errorf("failed to decode %s response: %w", path, err)
+	}
+	return result, nil
+}
+
+// call sends payload to the auth service in a signed envelope carrying
+// headers, and returns the data of its verified response.
+func (c *RegistryClient) call(path string, payload interface{}, headers map[string]string) (json.RawMessage, error) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	client, err := NewSignedClient(c.identity, c, c.versions, AuthServiceID, c.baseURL(), SignatureModeEnvelope)
+	if err != nil {
+		return nil, err
+	}
+	client.SetTimeout(c.client.Timeout)
+
+	resp, errResp := client.Call(&Call{Path: path, Body: body, Headers: headers, RequestID: models.NewRequestID()})
+	if errResp != nil {
+		return nil, fmt.Errorf("auth service %s failed: %w", path, errResp)
+	}
+	return resp.Envelope.Data, nil
+}
+
+// Address returns the base URL serviceID advertised when it registered. It
+// is fetched on every call, since addresses change far more often than keys.
+func (c *RegistryClient) Address(serviceID string) (string, error) {
+	registration, _, err := c.lookup(context.Background(), serviceID)
+	if err != nil {
+		return "", err
+	}
+	if registration.Address == "" {
+		return "", fmt.Errorf("service %s has not advertised an address", serviceID)
+	}
+	return registration.Address, nil
+}
+
+// Registration returns serviceID's registration as the auth service has it
+// now, bypassing the key cache.
+func (c *RegistryClient) Registration(serviceID string) (*models.PublicKeyResponse, error) {
+	registration, _, err := c.lookup(context.Background(), serviceID)
+	return registration, err
+}
+
+// lookup fetches serviceID's registration from the auth service. If the
+// auth service cannot be reached, it comes from the registry snapshot, if
+// there is a valid one, and offline is when the snapshot expires.
+func (c *RegistryClient) lookup(ctx context.Context, serviceID string) (registration *models.PublicKeyResponse, offline time.Time, err error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", fmt.Sprintf("%s/public-key/%s", c.baseURL(), serviceID), nil)
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("failed to create public key request: %w", err)
+	}
+	req.Header.Set(models.HeaderAcceptVersion, models.AcceptVersionHeader())
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return c.lookupOffline(serviceID, fmt.Errorf("failed to fetch public key: %w", err))
+	}
+	defer resp.Body.Close()
+
+	c.versions.Record(AuthServiceID, resp)
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return c.lookupOffline(serviceID, fmt.Errorf("failed to get public key, status %d: %w", resp.StatusCode, models.DecodeErrorResponse(resp)))
+	default:
+		return nil, time.Time{}, fmt.Errorf("failed to get public key, status %d: %w", resp.StatusCode, models.DecodeErrorResponse(resp))
+	}
+
+	var response models.ServiceResponse
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return nil, time.Time{}, fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	if registration, err = decodePublicKeyResponse(response); err != nil {
+		return nil, time.Time{}, err
+	}
+	if err := c.verifyPublicKeyResponse(ctx, serviceID, &response, registration); err != nil {
+		return nil, time.Time{}, err
+	}
+	return registration, time.Time{}, nil
+}
+
+// verifyPublicKeyResponse checks response, the auth service's answer to a
+// lookup of serviceID holding registration, is recent, is about serviceID
+// and is signed with the auth service's key. For serviceID's own lookup
+// that key is the one registration holds, so it must be the snapshot
+// signer key if one is configured; pins are checked as for any other key.
+// Before protocol 1.2 only the data is signed, not who signed it or when,
+// so older auth services are refused.
+func (c *RegistryClient) verifyPublicKeyResponse(ctx context.Context, serviceID string, response *models.ServiceResponse, registration *models.PublicKeyResponse) error {
+	if !models.ProtocolVersionAtLeast(response.ProtocolVersion, models.ProtocolVersion12) {
+		return fmt.Errorf("auth service answered the public key lookup of %s in protocol %s; 1.2 or newer is required", serviceID, models.WireProtocolVersion(response.ProtocolVersion))
+	}
+	if response.ServiceID != AuthServiceID {
+		return fmt.Errorf("public key response for %s is signed by %q, not %s", serviceID, response.ServiceID, AuthServiceID)
+	}
+	if registration.ServiceID != serviceID {
+		return fmt.Errorf("public key response for %s holds the key of %q", serviceID, registration.ServiceID)
+	}
+	if age := time.Since(response.Timestamp); age > DefaultMaxRequestAge || age < -DefaultClockSkew {
+		return fmt.Errorf("public key response for %s was signed at %s, outside the allowed window", serviceID, response.Timestamp.Format(time.RFC3339))
+	}
+
+	keyID := response.Headers[models.HeaderKeyID]
+	var authKey []byte
+	if serviceID == AuthServiceID {
+		authKey = registration.PublicKey
+		fingerprint := pqc.KeyFingerprint(authKey)
+		if keyID != "" && keyID != fingerprint {
+			return fmt.Errorf("auth service signed its own public key response with key %s, not the key %s it holds", keyID, fingerprint)
+		}
+		c.snapshotMutex.Lock()
+		signer := c.snapshotSigner
+		c.snapshotMutex.Unlock()
+		if signer != "" && fingerprint != signer {
+			return fmt.Errorf("auth service key %s is not the trusted key %s", fingerprint, signer)
+		}
+	} else {
+		var err error
+		if authKey, err = c.ResolveKeyContext(ctx, AuthServiceID, keyID); err != nil {
+			return fmt.Errorf("failed to get auth service key to verify the public key of %s: %w", serviceID, err)
+		}
+	}
+
+	payload := bufpool.Get()
+	defer bufpool.Put(payload)
+	if err := response.WriteSigningPayload(payload); err != nil {
+		return fmt.Errorf("failed to reconstruct public key response for %s: %w", serviceID, err)
+	}
+	if err := telemetry.Verify(ctx, response.SignatureAlg, authKey, payload.Bytes(), response.Signature); err != nil {
+		return fmt.Errorf("public key response for %s failed signature verification: %w", serviceID, err)
+	}
+	return nil
+}
+
+// lookupOffline returns serviceID's registration from the registry snapshot
+// while it is valid; cause is why the auth service could not be asked.
+func (c *RegistryClient) lookupOffline(serviceID string, cause error) (*models.PublicKeyResponse, time.Time, error) {
+	c.snapshotMutex.Lock()
+	defer c.snapshotMutex.Unlock()
+
+	if c.snapshotFile == "" {
+		return nil, time.Time{}, cause
+	}
+	if info, err := os.Stat(c.snapshotFile); err == nil && !info.ModTime().Equal(c.snapshotModTime) {
+		c.reloadSnapshot()
+	}
+	if c.snapshot == nil {
+		return nil, time.Time{}, fmt.Errorf("%w (no valid registry snapshot to fall back to)", cause)
+	}
+	if !time.Now().Before(c.snapshot.ExpiresAt) {
+		return nil, time.Time{}, fmt.Errorf("%w (registry snapshot expired at %s)", cause, c.snapshot.ExpiresAt.Format(time.RFC3339))
+	}
+	registration, found := c.snapshot.Service(serviceID)
+	if !found {
+		return nil, time.Time{}, fmt.Errorf("%w (%s is not in the registry snapshot)", cause, serviceID)
+	}
+
+	log.Printf("📴 Auth Service unreachable, using %s's registration from the registry snapshot valid until %s",
+		serviceID, c.snapshot.ExpiresAt.Format(time.RFC3339))
+	copied := *registration
+	return &copied, c.snapshot.ExpiresAt, nil
+}
+
+// reloadSnapshot reads the registry snapshot file, keeping the snapshot
+// already loaded if the file does not hold a valid one. The caller holds
+// snapshotMutex.
+func (c *RegistryClient) reloadSnapshot() {
+	if info, err := os.Stat(c.snapshotFile); err == nil {
+		c.snapshotModTime = info.ModTime()
+	}
+	snapshot, err := LoadRegistrySnapshot(c.snapshotFile, c.snapshotSigner)
+	if err != nil {
+		log.Printf("⚠️  Not using registry snapshot %s: %v", c.snapshotFile, err)
+		return
+	}
+	c.snapshot = snapshot
+	log.Printf("📦 Loaded registry snapshot of %d services, valid until %s",
+		len(snapshot.Registry.Services), snapshot.ExpiresAt.Format(time.RFC3339))
+}
+
+// Snapshot fetches a signed snapshot of the whole registry from the auth
+// service. Check it with VerifyRegistrySnapshot before trusting it.
+func (c *RegistryClient) Snapshot() (*models.SignedRegistrySnapshot, error) {
+	resp, err := c.client.Get(c.baseURL() + "/registry/snapshot")
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch registry snapshot: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to get registry snapshot, status %d: %w", resp.StatusCode, models.DecodeErrorResponse(resp))
+	}
+
+	var snapshot models.SignedRegistrySnapshot
+	if err := json.NewDecoder(resp.Body).Decode(&snapshot); err != nil {
+		return nil, fmt.Errorf("failed to decode registry snapshot: %w", err)
+	}
+	return &snapshot, nil
+}
+
+// KeyExchange performs a key exchange with the auth service, with the
+// identity's KEM key, and returns the decapsulated shared secret.
+func (c *RegistryClient) KeyExchange() ([]byte, error) {
+	log.Println("🤝 Performing key exchange with auth service")
+
+	response, err := c.keyExchange("")
+	if err != nil {
+		return nil, err
+	}
+
+	_, decapsulated := telemetry.Observe(context.Background(), telemetry.OpDecapsulate, c.identity.Kyber.Algorithm())
+	sharedSecret, err := c.identity.Kyber.Decapsulate(response.Ciphertext)
+	decapsulated(err)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decapsulate shared secret: %w", err)
+	}
+
+	log.Println("✅ Key exchange with auth service completed")
+	return sharedSecret, nil
+}
+
+// BrokerSession has the auth service broker a session with peerID. It
+// returns this identity's side of the session and the ticket to hand to the
+// peer, which opens its side with Identity.OpenSessionTicket.
+func (c *RegistryClient) BrokerSession(peerID string) (*Session, *models.SessionTicket, error) {
+	log.Printf("🎫 Requesting a session with %s from auth service", peerID)
+
+	response, err := c.keyExchange(peerID)
+	if err != nil {
+		return nil, nil, err
+	}
+	ticket := response.Ticket
+	if ticket == nil || ticket.Client != c.identity.ServiceID || ticket.Peer != peerID {
+		return nil, nil, fmt.Errorf("auth service did not return a session ticket for %s", peerID)
+	}
+	issuerKey, err := c.PublicKey(ticket.Issuer)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get public key of ticket issuer %s: %w", ticket.Issuer, err)
+	}
+	session, err := c.identity.OpenSessionTicket(ticket, issuerKey, time.Now())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	log.Printf("✅ Session %s with %s brokered, valid until %s", session.ID, peerID, session.ExpiresAt.Format(time.RFC3339))
+	return session, ticket, nil
+}
+
+// keyExchange sends a signed /key-exchange request, brokering a session
+// with peerID if it is set.
+func (c *RegistryClient) keyExchange(peerID string) (*models.KeyExchangeResponse, error) {
+	request := models.KeyExchangeRequest{
+		ProtocolVersion: models.WireProtocolVersion(c.versions.Get(AuthServiceID)),
+		ServiceID:       c.identity.ServiceID,
+		KyberPublicKey:  c.identity.Kyber.GetPublicKeyBytes(),
+		PeerID:          peerID,
+	}
+	kemAlgorithm := c.identity.Kyber.Algorithm()
+	if kemAlgorithm != pqc.AlgorithmKyber768 {
+		request.KEMAlgorithm = kemAlgorithm
+	}
+
+	data, err := c.call("/key-exchange", request, nil)
+	if err != nil {
+		return nil, fmt.Errorf("key exchange failed: %w", err)
+	}
+
+	var response models.KeyExchangeResponse
+	if err := json.Unmarshal(data, &response); err != nil {
+		return nil, fmt.Errorf("failed to decode key exchange response: %w", err)
+	}
+
+	// An auth service that predates ML-KEM ignores kem_algorithm and
+	// encapsulates with Kyber768, which decapsulates to the wrong secret
+	// rather than failing.
+	if served, _ := pqc.KEMAlgorithm(response.KEMAlgorithm); served != kemAlgorithm {
+		return nil, fmt.Errorf("key exchange failed: auth service answered with %s, not %s; it does not support %s keys", served, kemAlgorithm, kemAlgorithm)
+	}
+	return &response, nil
+}
+
+// decodePublicKeyResponse extracts the registration from a /public-key
+// response. Auth services speaking 1.3 or newer send a typed
+// PublicKeyResponse; older ones send a map whose public_key encoding has to
+// be guessed.
+func decodePublicKeyResponse(response models.ServiceResponse) (*models.PublicKeyResponse, error) {
+	var keyResponse models.PublicKeyResponse
+	if models.ProtocolVersionAtLeast(response.ProtocolVersion, models.ProtocolVersion13) {
+		if err := json.Unmarshal(response.Data, &keyResponse); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal public key response: %w", err)
+		}
+		return &keyResponse, nil
+	}
+
+	var keyData map[string]interface{}
+	if err := json.Unmarshal(response.Data, &keyData); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal key data: %w", err)
+	}
+
+	publicKeyBytes, err := pqc.DeserializePublicKeyFromJSON(keyData)
+	if err != nil {
+		return nil, fmt.Errorf("failed to deserialize public key: %w", err)
+	}
+
+	keyResponse.PublicKey = publicKeyBytes
+	keyResponse.ServiceID, _ = keyData["service_id"].(string)
+	keyResponse.SPIFFEID, _ = keyData["spiffe_id"].(string)
+	keyResponse.Address, _ = keyData["address"].(string)
+	return &keyResponse, nil
+}
@@ -0,0 +1,167 @@
+package mesh
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// ReplayStore remembers the signatures of requests accepted within the
+// freshness window. A signature seen twice is a replay: senders sign a new
+// timestamp, or a new iat and request ID, for every request. Entries are
+// kept until the request's timestamp leaves the window, after which the
+// freshness check rejects it anyway. Replicas of a service must share one,
+// or a request accepted by one replica can be replayed to another.
+type ReplayStore interface {
+	// Claim records the digest of an accepted signature, which is too old
+	// to be accepted after expiry, and reports whether it is new.
+	Claim(digest [sha256.Size]byte, expiry time.Time) bool
+	Close() error
+}
+
+// OpenReplayStore opens a replay store from a URL: redis://host:6379/0 (or
+// rediss://) for a Redis shared by the service's replicas, or "memory" for
+// signatures only this process sees. serviceID scopes the Redis keys, so
+// services can share a Redis.
+func OpenReplayStore(url, serviceID string) (ReplayStore, error) {
+	if url == "memory" {
+		return newReplayCache(), nil
+	}
+
+	scheme, _, found := strings.Cut(url, "://")
+	if !found {
+		return nil, fmt.Errorf("invalid replay store URL %q: expected redis:// or memory", url)
+	}
+	switch scheme {
+	case "redis", "rediss":
+		return openRedisReplays(url, serviceID)
+	default:
+		return nil, fmt.Errorf("unsupported replay store scheme %q", scheme)
+	}
+}
+
+// replayCache is a ReplayStore in memory.
+type replayCache struct {
+	mutex     sync.Mutex
+	seen      map[[sha256.Size]byte]time.Time // signature digest -> expiry
+	lastSweep time.Time
+}
+
+func newReplayCache() *replayCache {
+	return &replayCache{seen: make(map[[sha256.Size]byte]time.Time)}
+}
+
+func (c *replayCache) Claim(digest [sha256.Size]byte, expiry time.Time) bool {
+	now := time.Now()
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if now.Sub(c.lastSweep) > time.Minute {
+		for key, expiry := range c.seen {
+			if now.After(expiry) {
+				delete(c.seen, key)
+			}
+		}
+		c.lastSweep = now
+	}
+
+	if seenExpiry, exists := c.seen[digest]; exists && now.Before(seenExpiry) {
+		return false
+	}
+	c.seen[digest] = expiry
+	return true
+}
+
+func (c *replayCache) Close() error {
+	return nil
+}
+
+// replayRedisTimeout bounds each round trip to Redis, so a slow Redis
+// delays requests by no more than this.
+const replayRedisTimeout = time.Second
+
+// replayClient is the part of a Redis client redisReplays uses.
+type replayClient interface {
+	SetArgs(ctx context.Context, key string, value interface{}, a redis.SetArgs) *redis.StatusCmd
+	Close() error
+}
+
+// redisReplays is a ReplayStore in Redis, shared by a service's replicas.
+// Every signature is also claimed in a local cache, which catches replays
+// to this replica on its own while Redis can't be reached.
+type redisReplays struct {
+	client replayClient
+	prefix string
+	local  *replayCache
+
+	mutex       sync.Mutex
+	unreachable bool
+}
+
+func openRedisReplays(url, serviceID string) (*redisReplays, error) {
+	options, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Redis URL: %w", err)
+	}
+	client := redis.NewClient(options)
+	s := newRedisReplays(client, serviceID)
+
+	ctx, cancel := context.WithTimeout(context.Background(), replayRedisTimeout)
+	defer cancel()
+	log.Println("🔁 Sharing replay checks across replicas through Redis")
+	s.reachable(client.Ping(ctx).Err())
+	return s, nil
+}
+
+func newRedisReplays(client replayClient, serviceID string) *redisReplays {
+	return &redisReplays{
+		client: client,
+		prefix: "mesh:replay:" + serviceID + ":",
+		local:  newReplayCache(),
+	}
+}
+
+// Claim sets the digest's key only if it is not set yet, expiring with
+// the signature, so of all replicas only the first to see it accepts it.
+func (s *redisReplays) Claim(digest [sha256.Size]byte, expiry time.Time) bool {
+	if !s.local.Claim(digest, expiry) {
+		return false
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), replayRedisTimeout)
+	defer cancel()
+	err := s.client.SetArgs(ctx, s.prefix+hex.EncodeToString(digest[:]), 1, redis.SetArgs{Mode: "NX", ExpireAt: expiry}).Err()
+	if errors.Is(err, redis.Nil) {
+		s.reachable(nil)
+		return false
+	}
+	s.reachable(err)
+	return true
+}
+
+// reachable logs when Redis stops and starts answering, so running on the
+// local cache alone, which other replicas don't see, never goes unnoticed.
+func (s *redisReplays) reachable(err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if err != nil && !s.unreachable {
+		log.Printf("⚠️  Replay store unreachable, checking replays on this replica only: %v", err)
+	} else if err == nil && s.unreachable {
+		log.Println("🔁 Replay store reachable again, sharing replay checks across replicas")
+	}
+	s.unreachable = err != nil
+}
+
+func (s *redisReplays) Close() error {
+	return s.client.Close()
+}
@@ -0,0 +1,96 @@
+package mesh
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// fakeRedis answers SET NX from a map shared by every replica using it,
+// or fails every command while down.
+type fakeRedis struct {
+	mutex sync.Mutex
+	keys  map[string]time.Time
+	sets  int
+	down  bool
+}
+
+func (r *fakeRedis) SetArgs(ctx context.Context, key string, value interface{}, a redis.SetArgs) *redis.StatusCmd {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.sets++
+	if r.down {
+		return redis.NewStatusResult("", errors.New("dial tcp: connection refused"))
+	}
+	if expiry, exists := r.keys[key]; exists && time.Now().Before(expiry) && a.Mode == "NX" {
+		return redis.NewStatusResult("", redis.Nil)
+	}
+	r.keys[key] = a.ExpireAt
+	return redis.NewStatusResult("OK", nil)
+}
+
+func (r *fakeRedis) Close() error {
+	return nil
+}
+
+func (r *fakeRedis) setDown(down bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.down = down
+}
+
+func (r *fakeRedis) setCount() int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.sets
+}
+
+func TestRedisReplaysSharedAcrossReplicas(t *testing.T) {
+	shared := &fakeRedis{keys: make(map[string]time.Time)}
+	first := newRedisReplays(shared, "backend-service")
+	second := newRedisReplays(shared, "backend-service")
+	expiry := time.Now().Add(time.Minute)
+
+	digest := sha256.Sum256([]byte("signature"))
+	if !first.Claim(digest, expiry) {
+		t.Fatal("first replica refused a new signature")
+	}
+	if second.Claim(digest, expiry) {
+		t.Fatal("second replica accepted a signature the first had claimed")
+	}
+
+	// A replay to the same replica is caught locally, without Redis.
+	sets := shared.setCount()
+	if first.Claim(digest, expiry) {
+		t.Fatal("first replica accepted its own replay")
+	}
+	if shared.setCount() != sets {
+		t.Fatal("a replay the local cache had seen went to Redis")
+	}
+
+	// While Redis is down, each replica still catches replays to itself.
+	shared.setDown(true)
+	offline := sha256.Sum256([]byte("signature sent while Redis is down"))
+	if !first.Claim(offline, expiry) {
+		t.Fatal("first replica refused a new signature while Redis was down")
+	}
+	if !first.unreachable {
+		t.Fatal("Redis failure not noticed")
+	}
+	if first.Claim(offline, expiry) {
+		t.Fatal("first replica accepted a replay while Redis was down")
+	}
+
+	shared.setDown(false)
+	if !first.Claim(sha256.Sum256([]byte("signature after recovery")), expiry) {
+		t.Fatal("first replica refused a new signature after Redis recovered")
+	}
+	if first.unreachable {
+		t.Fatal("Redis recovery not noticed")
+	}
+}
@@ -0,0 +1,31 @@
+package mesh
+
+import (
+	"errors"
+	"log"
+)
+
+// Resolver returns the base URL serviceID is currently reachable at.
+type Resolver func(serviceID string) (string, error)
+
+// ErrNotResolvable is returned by a Resolver that does not handle a service
+// at all, as opposed to failing to find it. Callers fall back silently.
+var ErrNotResolvable = errors.New("service is not resolvable")
+
+// resolveURL asks resolve for serviceID's address, falling back to
+// fallback when there is no resolver or it fails.
+func resolveURL(resolve Resolver, serviceID, fallback string) string {
+	if resolve == nil {
+		return fallback
+	}
+
+	address, err := resolve(serviceID)
+	if errors.Is(err, ErrNotResolvable) {
+		return fallback
+	}
+	if err != nil {
+		log.Printf("⚠️  Failed to resolve %s, using %s: %v", serviceID, fallback, err)
+		return fallback
+	}
+	return address
+}
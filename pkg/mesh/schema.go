@@ -0,0 +1,472 @@
+package mesh
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"quantum-safe-mesh/pkg/models"
+)
+
+// A signature proves who sent a request, not that its payload makes sense.
+// Handlers declare JSON Schemas for the data they take and return, and
+// WithSchema checks both, so a malformed payload from a correctly signed
+// caller is turned away before it reaches business logic.
+
+// schemaKeywords are the keywords a Schema understands. Annotations are
+// accepted and ignored; any other keyword is refused, so a schema never
+// passes data it was written to reject.
+var schemaKeywords = map[string]bool{
+	"type": true, "enum": true, "const": true,
+	"minimum": true, "maximum": true, "exclusiveMinimum": true, "exclusiveMaximum": true,
+	"minLength": true, "maxLength": true, "pattern": true,
+	"properties": true, "required": true, "additionalProperties": true,
+	"items": true, "minItems": true, "maxItems": true,
+	"allOf": true, "anyOf": true, "oneOf": true, "not": true,
+
+	"$schema": true, "$id": true, "$comment": true, "title": true, "description": true,
+	"default": true, "examples": true, "format": true, "deprecated": true,
+	"readOnly": true, "writeOnly": true,
+}
+
+var schemaTypes = map[string]bool{
+	"null": true, "boolean": true, "object": true, "array": true,
+	"number": true, "integer": true, "string": true,
+}
+
+// Schema is a compiled JSON Schema, limited to the keywords payload checks
+// need: type, enum and const; numeric, length and item count bounds;
+// pattern; properties, required and additionalProperties; items; and
+// allOf, anyOf, oneOf and not. References such as $ref are not supported.
+type Schema struct {
+	never bool // the false schema, which nothing matches
+
+	types    []string
+	enum     []interface{}
+	constant *interface{}
+
+	minimum, maximum                   *float64
+	exclusiveMinimum, exclusiveMaximum *float64
+	minLength, maxLength               *int
+	pattern                            *regexp.Regexp
+
+	properties map[string]*Schema
+	required   []string
+	additional *Schema
+
+	items              *Schema
+	minItems, maxItems *int
+
+	allOf, anyOf, oneOf []*Schema
+	not                 *Schema
+}
+
+// CompileSchema parses a JSON Schema document.
+func CompileSchema(data []byte) (*Schema, error) {
+	var raw json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("failed to parse schema: %w", err)
+	}
+	return compileSchema(raw, "")
+}
+
+// MustCompileSchema is CompileSchema for schemas written into the program,
+// panicking if text isn't one.
+func MustCompileSchema(text string) *Schema {
+	schema, err := CompileSchema([]byte(text))
+	if err != nil {
+		panic(err)
+	}
+	return schema
+}
+
+// schemaError is a mistake in a schema, at path within it.
+type schemaError struct {
+	path string
+	err  error
+}
+
+func (e *schemaError) Error() string {
+	return fmt.Sprintf("invalid schema at %q: %v", e.path, e.err)
+}
+
+func compileSchema(raw json.RawMessage, path string) (*Schema, error) {
+	var always bool
+	if err := json.Unmarshal(raw, &always); err == nil {
+		return &Schema{never: !always}, nil
+	}
+
+	var keywords map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &keywords); err != nil {
+		return nil, &schemaError{path, fmt.Errorf("expected an object or a boolean")}
+	}
+
+	schema := &Schema{}
+	names := make([]string, 0, len(keywords))
+	for name := range keywords {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if !schemaKeywords[name] {
+			return nil, &schemaError{path, fmt.Errorf("unsupported keyword %s", name)}
+		}
+		if err := schema.compileKeyword(name, keywords[name], path+"/"+name); err != nil {
+			var nested *schemaError
+			if errors.As(err, &nested) {
+				return nil, err
+			}
+			return nil, &schemaError{path + "/" + name, err}
+		}
+	}
+	return schema, nil
+}
+
+func (s *Schema) compileKeyword(name string, value json.RawMessage, path string) error {
+	switch name {
+	case "type":
+		var single string
+		if err := json.Unmarshal(value, &single); err == nil {
+			s.types = []string{single}
+		} else if err := json.Unmarshal(value, &s.types); err != nil {
+			return fmt.Errorf("expected a type name or a list of them")
+		}
+		for _, name := range s.types {
+			if !schemaTypes[name] {
+				return fmt.Errorf("unknown type %q", name)
+			}
+		}
+	case "enum":
+		if err := json.Unmarshal(value, &s.enum); err != nil {
+			return fmt.Errorf("expected a list of values")
+		}
+	case "const":
+		var constant interface{}
+		json.Unmarshal(value, &constant)
+		s.constant = &constant
+	case "minimum":
+		return unmarshalBound(value, &s.minimum)
+	case "maximum":
+		return unmarshalBound(value, &s.maximum)
+	case "exclusiveMinimum":
+		return unmarshalBound(value, &s.exclusiveMinimum)
+	case "exclusiveMaximum":
+		return unmarshalBound(value, &s.exclusiveMaximum)
+	case "minLength":
+		return unmarshalCount(value, &s.minLength)
+	case "maxLength":
+		return unmarshalCount(value, &s.maxLength)
+	case "minItems":
+		return unmarshalCount(value, &s.minItems)
+	case "maxItems":
+		return unmarshalCount(value, &s.maxItems)
+	case "pattern":
+		var pattern string
+		if err := json.Unmarshal(value, &pattern); err != nil {
+			return fmt.Errorf("expected a regular expression")
+		}
+		compiled, err := regexp.Compile(pattern)
+		if err != nil {
+			return err
+		}
+		s.pattern = compiled
+	case "properties":
+		var properties map[string]json.RawMessage
+		if err := json.Unmarshal(value, &properties); err != nil {
+			return fmt.Errorf("expected an object of schemas")
+		}
+		s.properties = make(map[string]*Schema, len(properties))
+		for property, raw := range properties {
+			compiled, err := compileSchema(raw, path+"/"+escapePointer(property))
+			if err != nil {
+				return err
+			}
+			s.properties[property] = compiled
+		}
+	case "required":
+		if err := json.Unmarshal(value, &s.required); err != nil {
+			return fmt.Errorf("expected a list of property names")
+		}
+	case "additionalProperties":
+		compiled, err := compileSchema(value, path)
+		if err != nil {
+			return err
+		}
+		s.additional = compiled
+	case "items":
+		compiled, err := compileSchema(value, path)
+		if err != nil {
+			return err
+		}
+		s.items = compiled
+	case "allOf", "anyOf", "oneOf":
+		var raws []json.RawMessage
+		if err := json.Unmarshal(value, &raws); err != nil || len(raws) == 0 {
+			return fmt.Errorf("expected a non-empty list of schemas")
+		}
+		schemas := make([]*Schema, len(raws))
+		for i, raw := range raws {
+			compiled, err := compileSchema(raw, fmt.Sprintf("%s/%d", path, i))
+			if err != nil {
+				return err
+			}
+			schemas[i] = compiled
+		}
+		switch name {
+		case "allOf":
+			s.allOf = schemas
+		case "anyOf":
+			s.anyOf = schemas
+		default:
+			s.oneOf = schemas
+		}
+	case "not":
+		compiled, err := compileSchema(value, path)
+		if err != nil {
+			return err
+		}
+		s.not = compiled
+	}
+	return nil
+}
+
+func unmarshalBound(value json.RawMessage, bound **float64) error {
+	var number float64
+	if err := json.Unmarshal(value, &number); err != nil {
+		return fmt.Errorf("expected a number")
+	}
+	*bound = &number
+	return nil
+}
+
+func unmarshalCount(value json.RawMessage, count **int) error {
+	var number int
+	if err := json.Unmarshal(value, &number); err != nil || number < 0 {
+		return fmt.Errorf("expected a non-negative integer")
+	}
+	*count = &number
+	return nil
+}
+
+// Validate checks data, a JSON document, against the schema, and returns
+// every violation, or none if it matches.
+func (s *Schema) Validate(data []byte) []models.SchemaViolation {
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return []models.SchemaViolation{{Message: "is not valid JSON"}}
+	}
+	return s.validate(value, "", nil)
+}
+
+func (s *Schema) validate(value interface{}, path string, violations []models.SchemaViolation) []models.SchemaViolation {
+	violate := func(format string, args ...interface{}) {
+		violations = append(violations, models.SchemaViolation{Path: path, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if s.never {
+		violate("is not allowed")
+		return violations
+	}
+
+	// A value of the wrong type fails every other check, so only the type
+	// is reported.
+	if len(s.types) > 0 && !s.matchesType(value) {
+		violate("must be of type %s", strings.Join(s.types, " or "))
+		return violations
+	}
+	if s.enum != nil && !containsValue(s.enum, value) {
+		violate("must be one of %s", formatValues(s.enum))
+	}
+	if s.constant != nil && !reflect.DeepEqual(*s.constant, value) {
+		violate("must be %s", formatValues([]interface{}{*s.constant}))
+	}
+
+	switch value := value.(type) {
+	case float64:
+		if s.minimum != nil && value < *s.minimum {
+			violate("must be at least %v", *s.minimum)
+		}
+		if s.maximum != nil && value > *s.maximum {
+			violate("must be at most %v", *s.maximum)
+		}
+		if s.exclusiveMinimum != nil && value <= *s.exclusiveMinimum {
+			violate("must be greater than %v", *s.exclusiveMinimum)
+		}
+		if s.exclusiveMaximum != nil && value >= *s.exclusiveMaximum {
+			violate("must be less than %v", *s.exclusiveMaximum)
+		}
+	case string:
+		length := utf8.RuneCountInString(value)
+		if s.minLength != nil && length < *s.minLength {
+			violate("must be at least %d characters long", *s.minLength)
+		}
+		if s.maxLength != nil && length > *s.maxLength {
+			violate("must be at most %d characters long", *s.maxLength)
+		}
+		if s.pattern != nil && !s.pattern.MatchString(value) {
+			violate("must match %s", s.pattern)
+		}
+	case []interface{}:
+		if s.minItems != nil && len(value) < *s.minItems {
+			violate("must have at least %d items", *s.minItems)
+		}
+		if s.maxItems != nil && len(value) > *s.maxItems {
+			violate("must have at most %d items", *s.maxItems)
+		}
+		if s.items != nil {
+			for i, item := range value {
+				violations = s.items.validate(item, fmt.Sprintf("%s/%d", path, i), violations)
+			}
+		}
+	case map[string]interface{}:
+		for _, property := range s.required {
+			if _, found := value[property]; !found {
+				violations = append(violations, models.SchemaViolation{Path: path + "/" + escapePointer(property), Message: "is required"})
+			}
+		}
+		names := make([]string, 0, len(value))
+		for name := range value {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			property := s.properties[name]
+			if property == nil {
+				property = s.additional
+			}
+			if property != nil {
+				violations = property.validate(value[name], path+"/"+escapePointer(name), violations)
+			}
+		}
+	}
+
+	for _, schema := range s.allOf {
+		violations = schema.validate(value, path, violations)
+	}
+	if s.anyOf != nil && matching(s.anyOf, value) == 0 {
+		violate("must match at least one of %d schemas", len(s.anyOf))
+	}
+	if s.oneOf != nil {
+		if matched := matching(s.oneOf, value); matched != 1 {
+			violate("must match exactly one of %d schemas, matched %d", len(s.oneOf), matched)
+		}
+	}
+	if s.not != nil && len(s.not.validate(value, path, nil)) == 0 {
+		violate("must not match the schema it excludes")
+	}
+	return violations
+}
+
+// matching counts the schemas value matches.
+func matching(schemas []*Schema, value interface{}) int {
+	matched := 0
+	for _, schema := range schemas {
+		if len(schema.validate(value, "", nil)) == 0 {
+			matched++
+		}
+	}
+	return matched
+}
+
+func (s *Schema) matchesType(value interface{}) bool {
+	actual := jsonType(value)
+	for _, name := range s.types {
+		if name == actual || (name == "number" && actual == "integer") {
+			return true
+		}
+	}
+	return false
+}
+
+// jsonType names the JSON Schema type of a decoded JSON value. Numbers
+// without a fractional part are integers.
+func jsonType(value interface{}) string {
+	switch value := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		if value == math.Trunc(value) {
+			return "integer"
+		}
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	default:
+		return "object"
+	}
+}
+
+func containsValue(values []interface{}, value interface{}) bool {
+	for _, candidate := range values {
+		if reflect.DeepEqual(candidate, value) {
+			return true
+		}
+	}
+	return false
+}
+
+func formatValues(values []interface{}) string {
+	formatted := make([]string, len(values))
+	for i, value := range values {
+		encoded, _ := json.Marshal(value)
+		formatted[i] = string(encoded)
+	}
+	return strings.Join(formatted, ", ")
+}
+
+// escapePointer escapes name as a JSON Pointer reference token.
+func escapePointer(name string) string {
+	return strings.NewReplacer("~", "~0", "/", "~1").Replace(name)
+}
+
+// EndpointSchema is what a handler's request and response data must look
+// like. Either may be nil to leave it unchecked.
+type EndpointSchema struct {
+	Request  *Schema
+	Response *Schema
+}
+
+// WithSchema wraps h so it only runs for requests whose data matches
+// schema.Request; others are rejected with 422 schema_violation, listing
+// every violation. Wrapped in Verified, the check runs after the signature
+// and freshness checks, so only authenticated callers learn what a route
+// expects. A response that doesn't match schema.Response is a bug in h,
+// reported as an internal error rather than sent.
+func WithSchema(schema EndpointSchema, h Handler) Handler {
+	return func(req *Request) (interface{}, error) {
+		if schema.Request != nil {
+			if violations := schema.Request.Validate(req.Envelope.Data); len(violations) > 0 {
+				log.Printf("🚫 Rejected request %s from %s on %s: %d schema violations", req.Envelope.RequestID, req.Envelope.ServiceID, req.URL.Path, len(violations))
+				errResp := models.NewError(http.StatusUnprocessableEntity, models.ErrCodeSchemaViolation, "Request data does not match the schema for "+req.URL.Path)
+				errResp.Violations = violations
+				return nil, errResp
+			}
+		}
+
+		result, err := h(req)
+		if err != nil || schema.Response == nil {
+			return result, err
+		}
+
+		payload, err := MarshalPayload(result)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal response: %w", err)
+		}
+		if violations := schema.Response.Validate(payload); len(violations) > 0 {
+			return nil, fmt.Errorf("response to %s does not match its schema: %s %s", req.URL.Path, violations[0].Path, violations[0].Message)
+		}
+		return json.RawMessage(payload), nil
+	}
+}
@@ -0,0 +1,657 @@
+package mesh
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"quantum-safe-mesh/pkg/bufpool"
+	"quantum-safe-mesh/pkg/meshlog"
+	"quantum-safe-mesh/pkg/middleware"
+	"quantum-safe-mesh/pkg/models"
+	"quantum-safe-mesh/pkg/pqc"
+	"quantum-safe-mesh/pkg/telemetry"
+)
+
+// DefaultMaxRequestAge bounds how old a signed request's timestamp, or a
+// detached JWS iat, may be, and DefaultClockSkew how far ahead of the
+// receiver's clock. Requests are only remembered for replay detection until
+// they are too old.
+const (
+	DefaultMaxRequestAge = 5 * time.Minute
+	DefaultClockSkew     = 5 * time.Minute
+)
+
+// MaxRequestBodySize bounds the body of a signed request. Envelopes may
+// exceed it by maxEnvelopeOverhead, so a body at the limit can still be
+// wrapped and signed by the hop that forwards it.
+const (
+	MaxRequestBodySize  = 10 << 20
+	maxEnvelopeOverhead = 64 << 10
+)
+
+var (
+	errRequestExpired  = errors.New("request timestamp is older than the maximum request age")
+	errClockSkew       = errors.New("request timestamp is ahead of this service's clock by more than the allowed skew")
+	errRequestReplayed = errors.New("request signature has already been used")
+)
+
+// Request is an inbound mesh request after version negotiation and, for
+// Verified handlers, signature verification.
+type Request struct {
+	*http.Request
+	Envelope        models.ServiceRequest
+	ProtocolVersion string // version the response will be written in
+
+	// Content is the verified upload, for VerifiedContent handlers.
+	Content *Content
+
+	timing Timing
+}
+
+// OriginalMethod is the HTTP method the client used before any hop forwarded
+// the request as POST.
+func (r *Request) OriginalMethod() string {
+	if method := r.Envelope.Headers[models.HeaderOriginalMethod]; method != "" {
+		return method
+	}
+	return r.Method
+}
+
+// Handler produces the response payload for a mesh request. The payload is
+// JSON-marshaled, unless it is already raw bytes, and signed by the server.
+// Returning a *models.ErrorResponse selects the status and code; any other
+// error is reported as internal.
+type Handler func(req *Request) (interface{}, error)
+
+// VerifyingServer authenticates inbound requests against the registry and
+// signs everything it sends back with the service's identity. It counts the
+// requests it serves and records the ones it rejects.
+type VerifyingServer struct {
+	identity   *Identity
+	resolver   pqc.KeyResolver
+	replays    ReplayStore
+	metrics    *Metrics
+	audit      *AuditLog
+	namespaces *NamespacePolicy
+	admission  *Admission
+	freshness  atomic.Pointer[freshness]
+}
+
+// freshness is how old, and how far ahead, a signed request may be.
+type freshness struct {
+	maxAge time.Duration
+	skew   time.Duration
+}
+
+func NewVerifyingServer(identity *Identity, lookup pqc.PublicKeyLookup) *VerifyingServer {
+	s := &VerifyingServer{
+		identity: identity,
+		resolver: pqc.LookupResolver(lookup),
+		replays:  newReplayCache(),
+		metrics:  NewMetrics(identity.ServiceID),
+		audit:    NewAuditLog(identity.ServiceID),
+	}
+	s.UseFreshness(DefaultMaxRequestAge, DefaultClockSkew)
+	return s
+}
+
+// UseKeyResolver verifies requests with keys from resolver instead of the
+// lookup the server was created with, e.g. a RegistryClient, which can
+// resolve a key by the ID the request names.
+func (s *VerifyingServer) UseKeyResolver(resolver pqc.KeyResolver) {
+	s.resolver = resolver
+}
+
+// UseNamespacePolicy rejects verified callers outside the namespace owning
+// the route they call, or one it trusts.
+func (s *VerifyingServer) UseNamespacePolicy(policy *NamespacePolicy) {
+	s.namespaces = policy
+}
+
+// UseFreshness accepts signed requests made at most maxAge ago, and at most
+// skew ahead of this service's clock, instead of the defaults. It may be
+// called while the server is serving.
+func (s *VerifyingServer) UseFreshness(maxAge, skew time.Duration) {
+	s.freshness.Store(&freshness{maxAge: maxAge, skew: skew})
+}
+
+// UseReplayStore remembers accepted signatures in store instead of in
+// memory, e.g. one shared by the service's replicas.
+func (s *VerifyingServer) UseReplayStore(store ReplayStore) {
+	s.replays = store
+}
+
+// UseAdmission runs the server's signature verifications under admission,
+// which sheds requests with 503 overloaded when too many are waiting.
+func (s *VerifyingServer) UseAdmission(admission *Admission) {
+	s.admission = admission
+}
+
+// Metrics returns the server's request and verification failure counts,
+// for the service's /metrics endpoint.
+func (s *VerifyingServer) Metrics() *Metrics {
+	return s.metrics
+}
+
+// Audit returns the server's audit log, for the service's /audit endpoint.
+// Handlers record their own events, such as registry changes, in it too.
+func (s *VerifyingServer) Audit() *AuditLog {
+	return s.audit
+}
+
+// Verified wraps h so it only runs for requests whose envelope or detached
+// signature verifies against the caller's registered key, was made within
+// the freshness window, and has not been seen before.
+func (s *VerifyingServer) Verified(h Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		protocolVersion, ok := s.NegotiateVersion(w, r)
+		if !ok {
+			return
+		}
+
+		verifyStart := time.Now()
+		request, ok := s.Authenticate(w, r)
+		if !ok {
+			return
+		}
+
+		s.serve(w, &Request{Request: r, Envelope: request, ProtocolVersion: protocolVersion, timing: Timing{Verify: time.Since(verifyStart)}}, h)
+	}
+}
+
+// ClaimedKey returns the service a request's payload registers and the
+// public key it claims for it.
+type ClaimedKey func(data []byte) (serviceID string, publicKey []byte)
+
+// VerifiedClaim wraps h as Verified does, except that a request signed as
+// the service claimed names is verified with the claimed key rather than a
+// registered one, so a service can prove possession of the key it is
+// registering before it is in the registry. Other signers, such as a
+// delegator registering a workload, are verified against the registry.
+func (s *VerifyingServer) VerifiedClaim(claimed ClaimedKey, h Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		protocolVersion, ok := s.NegotiateVersion(w, r)
+		if !ok {
+			return
+		}
+
+		verifyStart := time.Now()
+		request, ok := s.authenticate(w, r, claimed)
+		if !ok {
+			return
+		}
+
+		s.serve(w, &Request{Request: r, Envelope: request, ProtocolVersion: protocolVersion, timing: Timing{Verify: time.Since(verifyStart)}}, h)
+	}
+}
+
+// Signed wraps h so its response is signed, without requiring the request
+// to be. The request IDs are taken from headers.
+func (s *VerifyingServer) Signed(h Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		protocolVersion, ok := s.NegotiateVersion(w, r)
+		if !ok {
+			return
+		}
+
+		envelope := models.ServiceRequest{
+			RequestID: r.Header.Get(models.HeaderRequestID),
+			ParentID:  r.Header.Get(models.HeaderParentID),
+		}
+		s.serve(w, &Request{Request: r, Envelope: envelope, ProtocolVersion: protocolVersion}, h)
+	}
+}
+
+// PassthroughPath is where services serve the unsigned echo that
+// benchmarks measure the mesh against.
+const PassthroughPath = "/passthrough/echo"
+
+// Passthrough wraps h so it runs with neither the request nor the response
+// signed: the body is handed to h as the envelope's data and the result
+// written as plain JSON. It is the baseline benchmarks compare signed
+// routes against, and must never serve real traffic.
+func (s *VerifyingServer) Passthrough(h Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, MaxRequestBodySize))
+		if err != nil {
+			models.WriteError(w, r, http.StatusBadRequest, models.ErrCodeInvalidRequest, "Failed to read request")
+			return
+		}
+		if len(body) == 0 {
+			body = []byte("{}")
+		}
+
+		result, err := h(&Request{Request: r, Envelope: models.ServiceRequest{
+			RequestID: r.Header.Get(models.HeaderRequestID),
+			ParentID:  r.Header.Get(models.HeaderParentID),
+			Timestamp: time.Now(),
+			Data:      body,
+		}})
+		if err != nil {
+			models.WriteErrorResponse(w, middleware.ErrorFor(r.Context(), err, r.Header.Get(models.HeaderRequestID)))
+			return
+		}
+		payload, err := MarshalPayload(result)
+		if err != nil {
+			models.WriteError(w, r, http.StatusInternalServerError, models.ErrCodeInternal, "Internal server error")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(payload)
+	}
+}
+
+func (s *VerifyingServer) serve(w http.ResponseWriter, req *Request, h Handler) {
+	s.metrics.CountRequest(req.Envelope.ServiceID)
+
+	// Handlers log through meshlog.FromContext(req.Context()) to tag their
+	// lines with the request.
+	ctx := meshlog.With(req.Context(), "request_id", req.Envelope.RequestID, "caller", req.Envelope.ServiceID)
+	req.Request = req.Request.WithContext(ctx)
+
+	handlerStart := time.Now()
+	result, err := h(req)
+	if err != nil {
+		models.WriteErrorResponse(w, middleware.ErrorFor(ctx, err, req.Envelope.RequestID))
+		return
+	}
+	req.timing.Handler = time.Since(handlerStart)
+
+	marshalStart := time.Now()
+	payload, err := MarshalPayload(result)
+	if err != nil {
+		meshlog.FromContext(ctx).Error("❌ Failed to marshal response", "error", err)
+		models.WriteError(w, req.Request, http.StatusInternalServerError, models.ErrCodeInternal, "Internal server error")
+		return
+	}
+	req.timing.Marshal = time.Since(marshalStart)
+
+	s.WriteResponse(w, req.Request, req.ProtocolVersion, &req.Envelope, payload, req.timing)
+}
+
+// MarshalPayload encodes a Handler's result as the payload the server
+// signs: raw bytes as they are, anything else as JSON.
+func MarshalPayload(result interface{}) ([]byte, error) {
+	switch raw := result.(type) {
+	case json.RawMessage:
+		return raw, nil
+	case []byte:
+		return raw, nil
+	default:
+		return json.Marshal(result)
+	}
+}
+
+// Authenticate verifies r as Verified does, for services such as the
+// gateway that forward requests rather than handle them. It writes the
+// error response and returns false if r is rejected.
+func (s *VerifyingServer) Authenticate(w http.ResponseWriter, r *http.Request) (models.ServiceRequest, bool) {
+	return s.authenticate(w, r, nil)
+}
+
+func (s *VerifyingServer) authenticate(w http.ResponseWriter, r *http.Request, claimed ClaimedKey) (models.ServiceRequest, bool) {
+	request, ok := s.readSignedRequest(w, r, claimed)
+	if !ok {
+		return request, false
+	}
+
+	if s.namespaces != nil {
+		if err := s.namespaces.Allow(request.ServiceID, r.URL.Path); err != nil {
+			log.Printf("❌ %v", err)
+			s.rejectRequest(w, r, request.ServiceID, err)
+			return request, false
+		}
+	}
+	return request, true
+}
+
+// NegotiateVersion picks the envelope version for the response from the
+// caller's Accept-Version header and advertises it back.
+func (s *VerifyingServer) NegotiateVersion(w http.ResponseWriter, r *http.Request) (string, bool) {
+	version, err := models.NegotiateProtocolVersion(r.Header.Get(models.HeaderAcceptVersion))
+	if err != nil {
+		log.Printf("❌ Protocol negotiation failed: %v", err)
+		models.WriteError(w, r, http.StatusNotAcceptable, models.ErrCodeUnsupportedVersion, "Unsupported protocol version")
+		return "", false
+	}
+
+	w.Header().Set(models.HeaderProtocolVersion, version)
+	return version, true
+}
+
+// readSignedRequest decodes and authenticates an inbound request. Callers in
+// detached mode send the body verbatim with an X-Mesh-Signature header;
+// everyone else wraps it in a signed ServiceRequest envelope.
+func (s *VerifyingServer) readSignedRequest(w http.ResponseWriter, r *http.Request, claimed ClaimedKey) (models.ServiceRequest, bool) {
+	if token := r.Header.Get(models.HeaderMeshSignature); token != "" {
+		r.Body = http.MaxBytesReader(w, r.Body, MaxRequestBodySize)
+		request, err := s.verifyDetachedRequest(r, token, claimed)
+		if err != nil {
+			logVerificationError("Detached request verification failed", err)
+			signer := ""
+			if header, _, parseErr := pqc.ParseDetachedJWS(token); parseErr == nil {
+				signer = header.Kid
+			}
+			s.rejectRequest(w, r, signer, err)
+			return models.ServiceRequest{}, false
+		}
+		return request, true
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, MaxRequestBodySize+maxEnvelopeOverhead)
+	var request models.ServiceRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		log.Printf("❌ Invalid request format: %v", err)
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			models.WriteError(w, r, http.StatusRequestEntityTooLarge, models.ErrCodeRequestTooLarge, "Request body too large")
+			return request, false
+		}
+		models.WriteError(w, r, http.StatusBadRequest, models.ErrCodeInvalidRequest, "Invalid request format")
+		return request, false
+	}
+
+	if !models.IsSupportedProtocolVersion(request.ProtocolVersion) {
+		log.Printf("❌ Unsupported protocol version from %s: %s", request.ServiceID, request.ProtocolVersion)
+		models.WriteError(w, r, http.StatusBadRequest, models.ErrCodeUnsupportedVersion, "Unsupported protocol version")
+		return request, false
+	}
+
+	if err := s.verifyRequest(r.Context(), request, s.claimResolver(r.Context(), claimed, request.Data)); err != nil {
+		logVerificationError("Request verification failed", err)
+		s.rejectRequest(w, r, request.ServiceID, err)
+		return request, false
+	}
+
+	// Envelopes from before 1.2 carry no IDs; fall back to the headers.
+	if request.RequestID == "" {
+		request.RequestID = r.Header.Get(models.HeaderRequestID)
+		request.ParentID = r.Header.Get(models.HeaderParentID)
+	}
+
+	return request, true
+}
+
+// rejectRequest reports why a signed request from signer, the service it
+// claims to be from, was rejected, and counts and audits the rejection.
+func (s *VerifyingServer) rejectRequest(w http.ResponseWriter, r *http.Request, signer string, err error) {
+	errResp := verificationError(r, err)
+	if errResp.Code != models.ErrCodeRequestTooLarge && errResp.Code != models.ErrCodeOverloaded {
+		s.metrics.CountVerificationFailure(signer, errResp.Code)
+		s.audit.Record(AuditEvent{
+			Type:    AuditVerificationFailed,
+			Subject: signer,
+			Detail:  errResp.Code + ": " + err.Error(),
+		})
+	}
+	models.WriteErrorResponse(w, errResp)
+}
+
+// logVerificationError logs why a signed request was rejected, as a
+// warning if it was only shed.
+func logVerificationError(message string, err error) {
+	if errors.Is(err, ErrOverloaded) {
+		log.Printf("⚠️  Shedding request: %v", err)
+		return
+	}
+	log.Printf("❌ %s: %v", message, err)
+}
+
+// verificationError is the error response for a signed request rejected
+// with err.
+func verificationError(r *http.Request, err error) *models.ErrorResponse {
+	var tooLarge *http.MaxBytesError
+	switch {
+	case errors.As(err, &tooLarge):
+		return models.NewErrorResponse(r, http.StatusRequestEntityTooLarge, models.ErrCodeRequestTooLarge, "Request body too large")
+	case errors.Is(err, ErrOverloaded):
+		errResp := models.NewErrorResponse(r, http.StatusServiceUnavailable, models.ErrCodeOverloaded, "Service overloaded")
+		errResp.RetryAfter = 1
+		return errResp
+	case errors.Is(err, errRequestExpired):
+		return models.NewErrorResponse(r, http.StatusUnauthorized, models.ErrCodeRequestExpired, "Request timestamp is too old")
+	case errors.Is(err, errClockSkew):
+		return models.NewErrorResponse(r, http.StatusUnauthorized, models.ErrCodeClockSkew, "Request timestamp is ahead of the server's clock")
+	case errors.Is(err, errRequestReplayed):
+		return models.NewErrorResponse(r, http.StatusUnauthorized, models.ErrCodeRequestReplayed, "Request has already been processed")
+	case errors.Is(err, errNamespaceForbidden):
+		return models.NewErrorResponse(r, http.StatusForbidden, models.ErrCodeNamespaceForbidden, "Caller's namespace may not call this route")
+	default:
+		return models.NewErrorResponse(r, http.StatusUnauthorized, models.ErrCodeInvalidSignature, "Request verification failed")
+	}
+}
+
+// checkFreshness rejects requests signed more than maxAge ago, or more than
+// skew ahead of now. The two are told apart so a clock that drifted shows as
+// such, rather than as a stale or replayed request.
+func (s *VerifyingServer) checkFreshness(issuedAt time.Time) error {
+	limits := s.freshness.Load()
+	age := time.Since(issuedAt)
+	switch {
+	case age > limits.maxAge:
+		return fmt.Errorf("%w: issued at %v, %s ago (maximum %s)", errRequestExpired, issuedAt, age.Round(time.Second), limits.maxAge)
+	case -age > limits.skew:
+		return fmt.Errorf("%w: issued at %v, %s ahead (allowed skew %s)", errClockSkew, issuedAt, (-age).Round(time.Second), limits.skew)
+	}
+	return nil
+}
+
+// claimResolver resolves the key of the service claimed names in data to
+// the claimed key, and every other key as the server usually does, for the
+// request ctx belongs to.
+func (s *VerifyingServer) claimResolver(ctx context.Context, claimed ClaimedKey, data []byte) pqc.KeyResolver {
+	resolver := pqc.ResolverWithContext(ctx, s.resolver)
+	if claimed == nil {
+		return resolver
+	}
+	claimedID, claimedKey := claimed(data)
+	if claimedID == "" {
+		return resolver
+	}
+	return pqc.KeyResolverFunc(func(serviceID, keyID string) ([]byte, error) {
+		if serviceID != claimedID {
+			return resolver.ResolveKey(serviceID, keyID)
+		}
+		if len(claimedKey) == 0 {
+			return nil, fmt.Errorf("no public key claimed for %s", serviceID)
+		}
+		return claimedKey, pqc.CheckKeyID(claimedKey, keyID)
+	})
+}
+
+func (s *VerifyingServer) verifyRequest(ctx context.Context, request models.ServiceRequest, resolver pqc.KeyResolver) error {
+	log.Printf("🔍 Verifying request from service: %s", request.ServiceID)
+
+	if err := s.checkFreshness(request.Timestamp); err != nil {
+		return err
+	}
+
+	// Requests relayed through intermediate hops carry their
+	// countersignatures, which Verify checks too.
+	err := s.admission.Do(ctx, func() error {
+		_, verified := telemetry.Observe(ctx, telemetry.OpVerify, telemetry.Algorithm(request.SignatureAlg))
+		err := pqc.Verify(request, resolver)
+		verified(err)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	if !s.replays.Claim(sha256.Sum256(request.Signature), request.Timestamp.Add(s.freshness.Load().maxAge)) {
+		return fmt.Errorf("%w: from %s", errRequestReplayed, request.ServiceID)
+	}
+
+	slog.Info("✅ Request verified", "caller", request.ServiceID, "request_id", request.RequestID, "parent_id", request.ParentID)
+	return nil
+}
+
+func (s *VerifyingServer) verifyDetachedRequest(r *http.Request, token string, claimed ClaimedKey) (models.ServiceRequest, error) {
+	header, signature, err := pqc.ParseDetachedJWS(token)
+	if err != nil {
+		return models.ServiceRequest{}, err
+	}
+
+	log.Printf("🔍 Verifying detached signature from service: %s", header.Kid)
+
+	if err := s.checkFreshness(header.IssuedAt()); err != nil {
+		return models.ServiceRequest{}, err
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return models.ServiceRequest{}, fmt.Errorf("failed to read request body: %w", err)
+	}
+
+	publicKey, err := s.claimResolver(r.Context(), claimed, body).ResolveKey(header.Kid, "")
+	if err != nil {
+		return models.ServiceRequest{}, fmt.Errorf("failed to get public key: %w", err)
+	}
+
+	err = s.admission.Do(r.Context(), func() error {
+		_, verified := telemetry.Observe(r.Context(), telemetry.OpVerify, telemetry.Algorithm(header.Alg))
+		_, err := pqc.VerifyDetachedJWS(publicKey, token, body)
+		verified(err)
+		return err
+	})
+	if errors.Is(err, ErrOverloaded) {
+		return models.ServiceRequest{}, err
+	}
+	if err != nil {
+		return models.ServiceRequest{}, fmt.Errorf("signature verification failed: %w", err)
+	}
+
+	if !s.replays.Claim(sha256.Sum256(signature), header.IssuedAt().Add(s.freshness.Load().maxAge)) {
+		return models.ServiceRequest{}, fmt.Errorf("%w: from %s", errRequestReplayed, header.Kid)
+	}
+
+	if len(body) == 0 {
+		body = []byte("{}")
+	}
+
+	// The JWS covers the body and its protected header, not the request's
+	// HTTP headers, so only what the protected header commits to goes in
+	// the envelope's headers.
+	headers := make(map[string]string)
+	if header.Htm != "" {
+		headers[models.HeaderOriginalMethod] = header.Htm
+	}
+
+	slog.Info("✅ Detached request verified", "caller", header.Kid, "request_id", header.Rid, "parent_id", header.Pid)
+	return models.ServiceRequest{
+		ServiceID:      header.Kid,
+		RequestID:      header.Rid,
+		ParentID:       header.Pid,
+		IdempotencyKey: header.Idk,
+		Timestamp:      header.IssuedAt(),
+		Data:           body,
+		Headers:        headers,
+	}, nil
+}
+
+// WriteResponse signs payload and answers in the mode the request arrived
+// in: a detached JWS header over the raw payload, or an envelope, wrapped
+// in CMS SignedData for callers that accept it. The response carries the
+// request's IDs so callers can bind it to their request, and timing, with
+// the time signing took, in its Server-Timing header.
+func (s *VerifyingServer) WriteResponse(w http.ResponseWriter, r *http.Request, protocolVersion string, request *models.ServiceRequest, payload []byte, timing Timing) {
+	requestID, parentID := request.RequestID, request.ParentID
+	w.Header().Set(models.HeaderRequestID, requestID)
+
+	signStart := time.Now()
+	_, signed := telemetry.Observe(r.Context(), telemetry.OpSign, s.identity.Signer.Algorithm())
+	if r.Header.Get(models.HeaderMeshSignature) != "" {
+		token, err := pqc.SignDetachedWithHeader(s.identity.Signer, pqc.JWSHeader{
+			Kid: s.identity.ServiceID,
+			Rid: requestID,
+			Pid: parentID,
+		}, payload)
+		signed(err)
+		if err != nil {
+			log.Printf("❌ Failed to sign response: %v", err)
+			models.WriteError(w, r, http.StatusInternalServerError, models.ErrCodeInternal, "Internal server error")
+			return
+		}
+		timing.Sign = time.Since(signStart)
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set(models.HeaderMeshSignature, token)
+		w.Header().Set(models.HeaderServerTiming, timing.ServerTiming())
+		w.Write(payload)
+		return
+	}
+
+	// The signature is pooled: it is encoded below, before WriteResponse
+	// returns, and nothing keeps the envelope after that.
+	response, err := s.signResponse(protocolVersion, request, payload, func(data []byte) ([]byte, error) {
+		return pqc.SignPooled(s.identity.Signer, data)
+	})
+	signed(err)
+	if err != nil {
+		log.Printf("❌ Failed to sign response: %v", err)
+		models.WriteError(w, r, http.StatusInternalServerError, models.ErrCodeInternal, "Internal server error")
+		return
+	}
+	defer pqc.ReleaseSignature(response.Signature)
+	timing.Sign = time.Since(signStart)
+
+	w.Header().Set(models.HeaderServerTiming, timing.ServerTiming())
+	w.Header().Add("Vary", "Accept")
+	if AcceptsSignedData(r) {
+		if err := WriteSignedData(w, s.identity, http.StatusOK, response); err != nil {
+			log.Printf("❌ Failed to sign response: %v", err)
+			models.WriteError(w, r, http.StatusInternalServerError, models.ErrCodeInternal, "Internal server error")
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// SignResponse wraps payload in a response envelope for request, signed with
+// the service's identity.
+func (s *VerifyingServer) SignResponse(protocolVersion string, request *models.ServiceRequest, payload []byte) (*models.ServiceResponse, error) {
+	return s.signResponse(protocolVersion, request, payload, s.identity.Signer.Sign)
+}
+
+// signResponse is SignResponse with the envelope signed by sign.
+func (s *VerifyingServer) signResponse(protocolVersion string, request *models.ServiceRequest, payload []byte, sign func([]byte) ([]byte, error)) (*models.ServiceResponse, error) {
+	response := &models.ServiceResponse{
+		ProtocolVersion: models.WireProtocolVersion(protocolVersion),
+		ServiceID:       s.identity.ServiceID,
+		Timestamp:       time.Now(),
+		Data:            payload,
+		SignatureAlg:    pqc.EnvelopeAlg(s.identity.Signer),
+		Success:         true,
+	}
+
+	if models.ProtocolVersionAtLeast(protocolVersion, models.ProtocolVersion12) {
+		response.RequestID = request.RequestID
+		response.ParentID = request.ParentID
+		response.Headers = map[string]string{models.HeaderKeyID: s.identity.KeyID()}
+	}
+
+	signingPayload := bufpool.Get()
+	defer bufpool.Put(signingPayload)
+	if err := response.WriteSigningPayload(signingPayload); err != nil {
+		return nil, fmt.Errorf("failed to marshal response for signing: %w", err)
+	}
+
+	var err error
+	response.Signature, err = sign(signingPayload.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	return response, nil
+}
@@ -0,0 +1,153 @@
+package mesh
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"time"
+
+	"golang.org/x/crypto/hkdf"
+
+	"quantum-safe-mesh/pkg/models"
+	"quantum-safe-mesh/pkg/pqc"
+	"quantum-safe-mesh/pkg/telemetry"
+)
+
+// The auth service can broker a session between two services in one round
+// trip: it seals a fresh session key to the Kyber keys of both and signs a
+// ticket holding the two sealed copies. The client opens its copy and hands
+// the ticket to the peer, which opens the other.
+
+const sessionKeySize = 32
+
+// Session is one party's view of a brokered session.
+type Session struct {
+	ID        string
+	Peer      string // the other party
+	Key       []byte // 32-byte session key
+	ExpiresAt time.Time
+}
+
+// IssueSessionTicket brokers a session between client and peer, sealing a
+// new session key to their KEM public keys, of the KEM algorithms clientKEM
+// and peerKEM, valid for ttl from now.
+func (id *Identity) IssueSessionTicket(client, clientKEM string, clientKyber []byte, peer, peerKEM string, peerKyber []byte, ttl time.Duration) (*models.SessionTicket, error) {
+	ticketID := models.NewRequestID()
+	sessionKey := make([]byte, sessionKeySize)
+	if _, err := rand.Read(sessionKey); err != nil {
+		return nil, fmt.Errorf("failed to generate session key: %w", err)
+	}
+	defer clear(sessionKey)
+
+	now := time.Now().UTC().Truncate(time.Second)
+	ticket := &models.SessionTicket{
+		TicketID:  ticketID,
+		Issuer:    id.ServiceID,
+		Client:    client,
+		Peer:      peer,
+		IssuedAt:  now,
+		ExpiresAt: now.Add(ttl),
+		Algorithm: pqc.EnvelopeAlg(id.Signer),
+	}
+	var err error
+	if ticket.ClientKey, err = sealSessionKey(sessionKey, clientKEM, clientKyber, ticketID, client); err != nil {
+		return nil, err
+	}
+	if ticket.PeerKey, err = sealSessionKey(sessionKey, peerKEM, peerKyber, ticketID, peer); err != nil {
+		return nil, err
+	}
+
+	payload, err := pqc.CanonicalJSON(ticket.Unsigned())
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode session ticket: %w", err)
+	}
+	if ticket.Signature, err = id.Signer.Sign(payload); err != nil {
+		return nil, fmt.Errorf("failed to sign session ticket: %w", err)
+	}
+	return ticket, nil
+}
+
+// OpenSessionTicket checks ticket is signed by issuerKey and valid at now,
+// and opens this identity's copy of the session key. Either party may open
+// it.
+func (id *Identity) OpenSessionTicket(ticket *models.SessionTicket, issuerKey []byte, now time.Time) (*Session, error) {
+	if err := pqc.VerifyCanonical(ticket.Algorithm, issuerKey, ticket.Unsigned(), ticket.Signature); err != nil {
+		return nil, fmt.Errorf("session ticket signature verification failed: %w", err)
+	}
+	if now.Before(ticket.IssuedAt) || !now.Before(ticket.ExpiresAt) {
+		return nil, fmt.Errorf("session ticket is only valid from %s until %s",
+			ticket.IssuedAt.Format(time.RFC3339), ticket.ExpiresAt.Format(time.RFC3339))
+	}
+
+	var sealed models.SealedSessionKey
+	var peer string
+	switch id.ServiceID {
+	case ticket.Client:
+		sealed, peer = ticket.ClientKey, ticket.Peer
+	case ticket.Peer:
+		sealed, peer = ticket.PeerKey, ticket.Client
+	default:
+		return nil, fmt.Errorf("session ticket is between %s and %s, not for %s", ticket.Client, ticket.Peer, id.ServiceID)
+	}
+
+	_, decapsulated := telemetry.Observe(context.Background(), telemetry.OpDecapsulate, id.Kyber.Algorithm())
+	sharedSecret, err := id.Kyber.Decapsulate(sealed.Ciphertext)
+	decapsulated(err)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decapsulate session key: %w", err)
+	}
+	aead, err := sessionAEAD(sharedSecret)
+	if err != nil {
+		return nil, err
+	}
+	key, err := aead.Open(nil, sealed.Nonce, sealed.SealedKey, sessionAAD(ticket.TicketID, id.ServiceID))
+	if err != nil || len(key) != sessionKeySize {
+		return nil, fmt.Errorf("failed to open session key: wrong Kyber key or tampered ticket")
+	}
+	return &Session{ID: ticket.TicketID, Peer: peer, Key: key, ExpiresAt: ticket.ExpiresAt}, nil
+}
+
+func sealSessionKey(sessionKey []byte, kemAlgorithm string, kyberPublicKey []byte, ticketID, serviceID string) (models.SealedSessionKey, error) {
+	_, encapsulated := telemetry.Observe(context.Background(), telemetry.OpEncapsulate, kemAlgorithm)
+	ciphertext, sharedSecret, err := pqc.EncapsulateTo(kemAlgorithm, kyberPublicKey)
+	encapsulated(err)
+	if err != nil {
+		return models.SealedSessionKey{}, fmt.Errorf("failed to encapsulate to %s: %w", serviceID, err)
+	}
+	aead, err := sessionAEAD(sharedSecret)
+	if err != nil {
+		return models.SealedSessionKey{}, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return models.SealedSessionKey{}, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	return models.SealedSessionKey{
+		Ciphertext: ciphertext,
+		Nonce:      nonce,
+		SealedKey:  aead.Seal(nil, nonce, sessionKey, sessionAAD(ticketID, serviceID)),
+	}, nil
+}
+
+// sessionAEAD derives the key-wrapping key from a Kyber shared secret.
+func sessionAEAD(sharedSecret []byte) (cipher.AEAD, error) {
+	key := make([]byte, 32)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, sharedSecret, nil, []byte("quantum-safe-mesh session ticket")), key); err != nil {
+		return nil, fmt.Errorf("failed to derive session wrapping key: %w", err)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+// sessionAAD binds a sealed key to its ticket and party, so it cannot be
+// moved to another ticket or swapped with the other party's copy.
+func sessionAAD(ticketID, serviceID string) []byte {
+	return []byte(ticketID + "\x00" + serviceID)
+}
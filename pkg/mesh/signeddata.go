@@ -0,0 +1,66 @@
+package mesh
+
+import (
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"quantum-safe-mesh/pkg/pqc"
+)
+
+// SignedDataMediaType is the media type of responses wrapped as CMS
+// SignedData, for callers whose tooling expects PKCS #7 rather than the
+// mesh's JSON envelopes.
+const SignedDataMediaType = "application/pkcs7-mime; smime-type=signed-data"
+
+// AcceptsSignedData reports whether r's Accept header prefers SignedData,
+// as application/pkcs7-mime with no smime-type or signed-data, or
+// application/cms, to JSON.
+func AcceptsSignedData(r *http.Request) bool {
+	var signedData, other float64
+	for _, accepted := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(accepted))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if value, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(value, 64); err != nil {
+				continue
+			}
+		}
+
+		switch {
+		case mediaType == "application/pkcs7-mime" && (params["smime-type"] == "" || params["smime-type"] == "signed-data"),
+			mediaType == "application/cms":
+			signedData = max(signedData, q)
+		case mediaType == "application/json", mediaType == "application/*":
+			other = max(other, q)
+		}
+	}
+	return signedData > 0 && signedData >= other
+}
+
+// WriteSignedData answers with v, encoded as JSON and wrapped in CMS
+// SignedData signed with identity's key. The signer is named by the
+// fingerprint of its key, which callers resolve through the auth service as
+// they would an envelope's key ID.
+func WriteSignedData(w http.ResponseWriter, identity *Identity, status int, v interface{}) error {
+	content, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("failed to marshal response: %w", err)
+	}
+	der, err := pqc.SignSignedData(identity.Signer, content, time.Now())
+	if err != nil {
+		return fmt.Errorf("failed to sign SignedData: %w", err)
+	}
+
+	w.Header().Set("Content-Type", SignedDataMediaType)
+	w.WriteHeader(status)
+	w.Write(der)
+	return nil
+}
@@ -0,0 +1,85 @@
+package mesh
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"quantum-safe-mesh/pkg/models"
+	"quantum-safe-mesh/pkg/pqc"
+)
+
+// SignRegistrySnapshot signs registry, which must hold this identity's own
+// entry, as valid for ttl from now.
+func (id *Identity) SignRegistrySnapshot(registry models.RegistrySnapshot, ttl time.Duration) (*models.SignedRegistrySnapshot, error) {
+	now := time.Now().UTC().Truncate(time.Second)
+	snapshot := &models.SignedRegistrySnapshot{
+		Issuer:    id.ServiceID,
+		IssuedAt:  now,
+		ExpiresAt: now.Add(ttl),
+		Registry:  registry,
+		Algorithm: pqc.EnvelopeAlg(id.Signer),
+	}
+	if _, found := snapshot.Service(id.ServiceID); !found {
+		return nil, fmt.Errorf("registry snapshot does not hold the key of its issuer %s", id.ServiceID)
+	}
+
+	payload, err := pqc.CanonicalJSON(snapshot.Unsigned())
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode registry snapshot: %w", err)
+	}
+	if snapshot.Signature, err = id.Signer.Sign(payload); err != nil {
+		return nil, fmt.Errorf("failed to sign registry snapshot: %w", err)
+	}
+	return snapshot, nil
+}
+
+// VerifyRegistrySnapshot checks snapshot is signed by its issuer's key, that
+// this key is the one signerKeyID names, and that snapshot is valid at now.
+// The key ID is the trust anchor: the snapshot names its own signing key, so
+// without it anyone could sign a snapshot.
+func VerifyRegistrySnapshot(snapshot *models.SignedRegistrySnapshot, signerKeyID string, now time.Time) error {
+	if signerKeyID == "" {
+		return fmt.Errorf("no trusted key to verify the registry snapshot with")
+	}
+	issuer, found := snapshot.Service(snapshot.Issuer)
+	if !found {
+		return fmt.Errorf("registry snapshot does not hold the key of its issuer %q", snapshot.Issuer)
+	}
+	if fingerprint := pqc.KeyFingerprint(issuer.PublicKey); fingerprint != signerKeyID {
+		return fmt.Errorf("registry snapshot is signed by %s with key %s, not the trusted key %s", snapshot.Issuer, fingerprint, signerKeyID)
+	}
+	algorithm, err := pqc.SignatureAlgorithm(snapshot.Algorithm)
+	if err != nil {
+		return err
+	}
+	if issuerAlgorithm, _ := pqc.SignatureAlgorithm(issuer.Algorithm); issuerAlgorithm != algorithm {
+		return fmt.Errorf("registry snapshot is signed with %s, but its issuer's key is %s", algorithm, issuerAlgorithm)
+	}
+	if err := pqc.VerifyCanonical(snapshot.Algorithm, issuer.PublicKey, snapshot.Unsigned(), snapshot.Signature); err != nil {
+		return fmt.Errorf("registry snapshot signature verification failed: %w", err)
+	}
+	if now.Before(snapshot.IssuedAt) || !now.Before(snapshot.ExpiresAt) {
+		return fmt.Errorf("registry snapshot is only valid from %s until %s",
+			snapshot.IssuedAt.Format(time.RFC3339), snapshot.ExpiresAt.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// LoadRegistrySnapshot reads the snapshot file at path and verifies it
+// against signerKeyID.
+func LoadRegistrySnapshot(path, signerKeyID string) (*models.SignedRegistrySnapshot, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read registry snapshot: %w", err)
+	}
+	var snapshot models.SignedRegistrySnapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return nil, fmt.Errorf("failed to decode registry snapshot %s: %w", path, err)
+	}
+	if err := VerifyRegistrySnapshot(&snapshot, signerKeyID, time.Now()); err != nil {
+		return nil, err
+	}
+	return &snapshot, nil
+}
@@ -0,0 +1,116 @@
+package mesh
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// peerPrefix marks the Server-Timing entries a service relays from the
+// peer it called, e.g. "peer-verify" for the time the peer spent
+// verifying the request.
+const peerPrefix = "peer-"
+
+// Timing breaks down where a Call spent its time, so the cost of signing
+// and verifying can be told apart from the peer's own latency. Servers use
+// it too, for where the time handling a request went.
+type Timing struct {
+	Marshal     time.Duration // encoding the request envelope, or on a server the handler's result
+	Sign        time.Duration // signing the request, or on a server the response
+	Upstream    time.Duration // waiting for the peer, including its own verify and sign
+	Verify      time.Duration // verifying the response and its signature chain, or on a server the request
+	Countersign time.Duration // set by callers that countersign the response
+	Handler     time.Duration // set by servers: running the handler
+
+	// Peer is what the peer reported of its own time in its Server-Timing
+	// header, if it did.
+	Peer *Timing
+}
+
+// timingMetric is a Timing duration under its Server-Timing name.
+type timingMetric struct {
+	name     string
+	duration *time.Duration
+}
+
+// metrics lists t's durations under their Server-Timing names.
+func (t *Timing) metrics() []timingMetric {
+	return []timingMetric{
+		{"marshal", &t.Marshal},
+		{"sign", &t.Sign},
+		{"upstream", &t.Upstream},
+		{"verify", &t.Verify},
+		{"countersign", &t.Countersign},
+		{"handler", &t.Handler},
+	}
+}
+
+// ServerTiming renders t as a Server-Timing header value with durations in
+// milliseconds, e.g. "sign;dur=0.412, upstream;dur=3.100, verify;dur=0.250",
+// followed by the peer's entries prefixed with "peer-".
+func (t Timing) ServerTiming() string {
+	var parts []string
+	for _, m := range t.metrics() {
+		if *m.duration == 0 {
+			continue
+		}
+		parts = append(parts, fmt.Sprintf("%s;dur=%.3f", m.name, float64(*m.duration)/float64(time.Millisecond)))
+	}
+	if t.Peer != nil {
+		for _, part := range strings.Split(t.Peer.ServerTiming(), ", ") {
+			if part != "" {
+				parts = append(parts, peerPrefix+part)
+			}
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// Total is the time t accounts for on this service, leaving out the peer's
+// share of Upstream.
+func (t Timing) Total() time.Duration {
+	return t.Marshal + t.Sign + t.Upstream + t.Verify + t.Countersign + t.Handler
+}
+
+// ParseServerTiming reads a Server-Timing header written by ServerTiming.
+// Entries it doesn't know are ignored; it returns nil if there are none.
+func ParseServerTiming(header string) *Timing {
+	if strings.TrimSpace(header) == "" {
+		return nil
+	}
+
+	var t Timing
+	var peer []string
+	found := false
+	for _, entry := range strings.Split(header, ",") {
+		entry = strings.TrimSpace(entry)
+		if rest, ok := strings.CutPrefix(entry, peerPrefix); ok {
+			peer = append(peer, rest)
+			continue
+		}
+
+		params := strings.Split(entry, ";")
+		for _, m := range t.metrics() {
+			if m.name != params[0] {
+				continue
+			}
+			for _, param := range params[1:] {
+				value, ok := strings.CutPrefix(strings.TrimSpace(param), "dur=")
+				if !ok {
+					continue
+				}
+				if ms, err := strconv.ParseFloat(value, 64); err == nil {
+					*m.duration = time.Duration(ms * float64(time.Millisecond))
+					found = true
+				}
+			}
+		}
+	}
+
+	t.Peer = ParseServerTiming(strings.Join(peer, ", "))
+	if !found && t.Peer == nil {
+		return nil
+	}
+	return &t
+}
@@ -0,0 +1,51 @@
+package mesh
+
+import (
+	"log"
+	"net/http"
+	"sync"
+
+	"quantum-safe-mesh/pkg/models"
+)
+
+// PeerVersions remembers the protocol version negotiated with each peer.
+// Until a peer has answered we assume 1.0, which every service accepts.
+type PeerVersions struct {
+	mutex    sync.RWMutex
+	versions map[string]string // serviceID -> negotiated protocol version
+}
+
+func NewPeerVersions() *PeerVersions {
+	return &PeerVersions{versions: make(map[string]string)}
+}
+
+func (p *PeerVersions) Get(serviceID string) string {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+
+	if version, exists := p.versions[serviceID]; exists {
+		return version
+	}
+	return models.ProtocolVersion10
+}
+
+func (p *PeerVersions) Set(serviceID, version string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.versions[serviceID] != version {
+		log.Printf("🤝 Negotiated protocol version %s with %s", version, serviceID)
+	}
+	p.versions[serviceID] = version
+}
+
+// Record stores the version a peer chose to answer with. Peers that predate
+// versioning send no header and stay on 1.0.
+func (p *PeerVersions) Record(serviceID string, resp *http.Response) {
+	version := models.NormalizeProtocolVersion(resp.Header.Get(models.HeaderProtocolVersion))
+	if !models.IsSupportedProtocolVersion(version) {
+		log.Printf("⚠️  %s answered with unsupported protocol version %s", serviceID, version)
+		return
+	}
+	p.Set(serviceID, version)
+}
@@ -0,0 +1,156 @@
+// Package meshlog sets up the structured logging every mesh binary shares.
+// It installs a log/slog handler writing text or JSON at a chosen level,
+// routes the standard log package through it, and redacts key material,
+// signatures and tokens from whatever is logged, so they never reach log
+// output however a line was written.
+package meshlog
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+)
+
+// Log formats.
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// Options configures Setup.
+type Options struct {
+	Format  string    // text or json
+	Level   string    // debug, info, warn or error
+	Service string    // identity added to every line, if set
+	Output  io.Writer // defaults to os.Stderr
+}
+
+// ParseLevel parses a level name: debug, info, warn or error.
+func ParseLevel(value string) (slog.Level, error) {
+	switch strings.ToLower(value) {
+	case "debug":
+		return slog.LevelDebug, nil
+	case "", "info":
+		return slog.LevelInfo, nil
+	case "warn", "warning":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	}
+	return 0, fmt.Errorf("invalid log level %q: expected debug, info, warn or error", value)
+}
+
+// ValidateFormat checks format names a log format.
+func ValidateFormat(format string) error {
+	if format != FormatText && format != FormatJSON {
+		return fmt.Errorf("invalid log format %q: expected text or json", format)
+	}
+	return nil
+}
+
+// level is the lowest level logged, which SetLevel changes at runtime.
+var level slog.LevelVar
+
+// Setup makes opts the process's logging: slog's default logger and the
+// standard log package both write through a redacting handler. Lines
+// from the log package get a level from their prefix, so "❌" and
+// "Failed" lines are errors and "⚠️" and "Warning:" lines warnings.
+func Setup(opts Options) error {
+	parsed, err := ParseLevel(opts.Level)
+	if err != nil {
+		return err
+	}
+	if opts.Format == "" {
+		opts.Format = FormatText
+	}
+	if err := ValidateFormat(opts.Format); err != nil {
+		return err
+	}
+	if opts.Output == nil {
+		opts.Output = os.Stderr
+	}
+
+	level.Set(parsed)
+	handlerOpts := &slog.HandlerOptions{Level: &level}
+	var handler slog.Handler
+	if opts.Format == FormatJSON {
+		handler = slog.NewJSONHandler(opts.Output, handlerOpts)
+	} else {
+		handler = slog.NewTextHandler(opts.Output, handlerOpts)
+	}
+	handler = &redactingHandler{next: handler}
+	if opts.Service != "" {
+		handler = handler.WithAttrs([]slog.Attr{slog.String("service", opts.Service)})
+	}
+
+	// SetDefault points the log package at the handler too, at info level;
+	// replace that with the bridge that picks a level per line.
+	slog.SetDefault(slog.New(handler))
+	log.SetFlags(0)
+	log.SetPrefix("")
+	log.SetOutput(&stdBridge{handler: handler})
+	return nil
+}
+
+// SetLevel changes the lowest level logged, e.g. to debug a running
+// service.
+func SetLevel(value string) error {
+	parsed, err := ParseLevel(value)
+	if err != nil {
+		return err
+	}
+	level.Set(parsed)
+	return nil
+}
+
+type contextKey struct{}
+
+// With returns ctx carrying a logger that adds args, as slog key-value
+// pairs, to every line logged through FromContext(ctx): the request's ID
+// and caller, for instance.
+func With(ctx context.Context, args ...any) context.Context {
+	return context.WithValue(ctx, contextKey{}, FromContext(ctx).With(args...))
+}
+
+// FromContext returns the logger With stored in ctx, or slog's default.
+func FromContext(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(contextKey{}).(*slog.Logger); ok {
+		return logger
+	}
+	return slog.Default()
+}
+
+// stdBridge hands lines written by the log package to the handler.
+type stdBridge struct {
+	handler slog.Handler
+}
+
+func (b *stdBridge) Write(p []byte) (int, error) {
+	message := string(bytes.TrimRight(p, "\n"))
+	level := lineLevel(message)
+	ctx := context.Background()
+	if !b.handler.Enabled(ctx, level) {
+		return len(p), nil
+	}
+	if err := b.handler.Handle(ctx, slog.NewRecord(time.Now(), level, message, 0)); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func lineLevel(message string) slog.Level {
+	trimmed := strings.TrimSpace(message)
+	switch {
+	case strings.HasPrefix(trimmed, "❌"), strings.HasPrefix(trimmed, "Failed"):
+		return slog.LevelError
+	case strings.HasPrefix(trimmed, "⚠️"), strings.HasPrefix(trimmed, "Warning:"):
+		return slog.LevelWarn
+	}
+	return slog.LevelInfo
+}
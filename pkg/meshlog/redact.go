@@ -0,0 +1,110 @@
+package meshlog
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strings"
+)
+
+// sensitiveKeys are attribute keys whose values are never logged, other
+// than numbers such as sizes and counts.
+var sensitiveKeys = []string{
+	"signature", "private", "secret", "token", "passphrase", "password", "seed", "ciphertext", "shared_key",
+}
+
+var (
+	pemBlock = regexp.MustCompile(`-----BEGIN [A-Z0-9 ]+-----[\s\S]*?(-----END [A-Z0-9 ]+-----|$)`)
+	// Compact JWS and JWT: a base64url JSON header and two more parts.
+	compactToken = regexp.MustCompile(`eyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*\.[A-Za-z0-9_-]*`)
+	// Long runs of base64 are encoded keys, signatures or ciphertexts.
+	base64Blob = regexp.MustCompile(`[A-Za-z0-9+/_-]{64,}={0,2}`)
+	hexString  = regexp.MustCompile(`^[0-9a-fA-F]+$`)
+)
+
+// Redact replaces PEM blocks, compact JWS tokens and long base64 strings
+// in s. Hex strings up to 128 characters, such as digests and key IDs,
+// are kept.
+func Redact(s string) string {
+	if len(s) < 64 && !strings.Contains(s, "-----BEGIN") && !strings.Contains(s, "eyJ") {
+		return s
+	}
+	s = pemBlock.ReplaceAllString(s, "[REDACTED PEM]")
+	s = compactToken.ReplaceAllString(s, "[REDACTED TOKEN]")
+	return base64Blob.ReplaceAllStringFunc(s, func(blob string) string {
+		if len(blob) <= 128 && hexString.MatchString(blob) {
+			return blob
+		}
+		return "[REDACTED]"
+	})
+}
+
+// redactingHandler redacts each record's message and attributes before
+// passing it on.
+type redactingHandler struct {
+	next slog.Handler
+}
+
+func (h *redactingHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level)
+}
+
+func (h *redactingHandler) Handle(ctx context.Context, record slog.Record) error {
+	redacted := slog.NewRecord(record.Time, record.Level, Redact(record.Message), record.PC)
+	record.Attrs(func(attr slog.Attr) bool {
+		redacted.AddAttrs(redactAttr(attr))
+		return true
+	})
+	return h.next.Handle(ctx, redacted)
+}
+
+func (h *redactingHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	redacted := make([]slog.Attr, len(attrs))
+	for i, attr := range attrs {
+		redacted[i] = redactAttr(attr)
+	}
+	return &redactingHandler{next: h.next.WithAttrs(redacted)}
+}
+
+func (h *redactingHandler) WithGroup(name string) slog.Handler {
+	return &redactingHandler{next: h.next.WithGroup(name)}
+}
+
+func redactAttr(attr slog.Attr) slog.Attr {
+	value := attr.Value.Resolve()
+	switch value.Kind() {
+	case slog.KindInt64, slog.KindUint64, slog.KindFloat64, slog.KindDuration, slog.KindBool, slog.KindTime:
+		return slog.Attr{Key: attr.Key, Value: value}
+	case slog.KindGroup:
+		group := value.Group()
+		redacted := make([]any, len(group))
+		for i, member := range group {
+			redacted[i] = redactAttr(member)
+		}
+		return slog.Group(attr.Key, redacted...)
+	}
+
+	if sensitiveKey(attr.Key) {
+		return slog.String(attr.Key, "[REDACTED]")
+	}
+	switch v := value.Any().(type) {
+	case []byte:
+		return slog.String(attr.Key, fmt.Sprintf("[REDACTED %d bytes]", len(v)))
+	case string:
+		return slog.String(attr.Key, Redact(v))
+	case error:
+		return slog.String(attr.Key, Redact(v.Error()))
+	}
+	return slog.String(attr.Key, Redact(value.String()))
+}
+
+func sensitiveKey(key string) bool {
+	key = strings.ToLower(key)
+	for _, sensitive := range sensitiveKeys {
+		if strings.Contains(key, sensitive) {
+			return true
+		}
+	}
+	return false
+}
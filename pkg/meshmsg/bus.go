@@ -0,0 +1,35 @@
+package meshmsg
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Bus is the broker surface meshmsg needs. Implementations carry opaque
+// bytes; signing and verification happen above them.
+type Bus interface {
+	Publish(subject string, data []byte) error
+	// Subscribe delivers each message on subject to handler. Subscribers
+	// sharing a group split the messages between them.
+	Subscribe(subject string, handler func(data []byte)) error
+	Close() error
+}
+
+// Connect opens a bus from a URL: nats://host:4222 for NATS or
+// kafka://broker1:9092,broker2:9092 for Kafka. group names the queue group
+// or consumer group this process joins, normally its service ID.
+func Connect(url, group string) (Bus, error) {
+	scheme, address, found := strings.Cut(url, "://")
+	if !found {
+		return nil, fmt.Errorf("invalid message bus URL %q: expected nats:// or kafka://", url)
+	}
+
+	switch scheme {
+	case "nats", "tls":
+		return connectNATS(url, group)
+	case "kafka":
+		return connectKafka(strings.Split(address, ","), group)
+	default:
+		return nil, fmt.Errorf("unsupported message bus scheme %q", scheme)
+	}
+}
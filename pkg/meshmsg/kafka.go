@@ -0,0 +1,76 @@
+package meshmsg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// kafkaBus maps subjects to topics and groups to consumer groups.
+type kafkaBus struct {
+	brokers []string
+	group   string
+	writer  *kafka.Writer
+
+	mutex   sync.Mutex
+	readers []*kafka.Reader
+}
+
+func connectKafka(brokers []string, group string) (*kafkaBus, error) {
+	if len(brokers) == 0 || brokers[0] == "" {
+		return nil, fmt.Errorf("no Kafka brokers configured")
+	}
+
+	writer := &kafka.Writer{
+		Addr:                   kafka.TCP(brokers...),
+		Balancer:               &kafka.Hash{},
+		AllowAutoTopicCreation: true,
+	}
+	return &kafkaBus{brokers: brokers, group: group, writer: writer}, nil
+}
+
+func (b *kafkaBus) Publish(subject string, data []byte) error {
+	return b.writer.WriteMessages(context.Background(), kafka.Message{Topic: subject, Value: data})
+}
+
+func (b *kafkaBus) Subscribe(subject string, handler func(data []byte)) error {
+	reader := kafka.NewReader(kafka.ReaderConfig{
+		Brokers: b.brokers,
+		GroupID: b.group,
+		Topic:   subject,
+	})
+
+	b.mutex.Lock()
+	b.readers = append(b.readers, reader)
+	b.mutex.Unlock()
+
+	go func() {
+		for {
+			msg, err := reader.ReadMessage(context.Background())
+			if err != nil {
+				if !errors.Is(err, io.EOF) {
+					log.Printf("❌ Kafka consumer for %s stopped: %v", subject, err)
+				}
+				return
+			}
+			handler(msg.Value)
+		}
+	}()
+	return nil
+}
+
+func (b *kafkaBus) Close() error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	for _, reader := range b.readers {
+		reader.Close()
+	}
+	b.readers = nil
+	return b.writer.Close()
+}
@@ -0,0 +1,145 @@
+package meshmsg
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"quantum-safe-mesh/pkg/mesh"
+	"quantum-safe-mesh/pkg/models"
+	"quantum-safe-mesh/pkg/pqc"
+	"quantum-safe-mesh/pkg/telemetry"
+)
+
+// HeaderSubject is the envelope header binding a message's signature to the
+// subject it was published on, so it cannot be replayed onto another one.
+const HeaderSubject = "X-Mesh-Subject"
+
+// MaxMessageAge bounds how old a message may be when it is consumed.
+const MaxMessageAge = 5 * time.Minute
+
+// Subjects of the example asynchronous pipeline: the gateway publishes
+// processing jobs and the backend publishes their results.
+const (
+	SubjectProcess = "mesh.backend.process"
+	SubjectResults = "mesh.backend.results"
+)
+
+// Message is one signed message. On receipt Envelope holds the verified
+// envelope, including the sender's ServiceID.
+type Message struct {
+	RequestID      string
+	ParentID       string
+	IdempotencyKey string
+	Data           json.RawMessage
+	Envelope       models.ServiceRequest
+}
+
+// Publisher signs messages with a service identity before handing them to
+// the bus.
+type Publisher struct {
+	identity *mesh.Identity
+	bus      Bus
+}
+
+func NewPublisher(identity *mesh.Identity, bus Bus) *Publisher {
+	return &Publisher{identity: identity, bus: bus}
+}
+
+// Publish wraps msg in a signed ServiceRequest envelope and publishes it on
+// subject.
+func (p *Publisher) Publish(subject string, msg *Message) error {
+	envelope := models.ServiceRequest{
+		ProtocolVersion: models.CurrentProtocolVersion,
+		ServiceID:       p.identity.ServiceID,
+		RequestID:       msg.RequestID,
+		ParentID:        msg.ParentID,
+		IdempotencyKey:  msg.IdempotencyKey,
+		Timestamp:       time.Now(),
+		Data:            msg.Data,
+		SignatureAlg:    pqc.EnvelopeAlg(p.identity.Signer),
+		Headers:         map[string]string{HeaderSubject: subject, models.HeaderKeyID: p.identity.KeyID()},
+	}
+
+	payload, err := envelope.SigningPayload()
+	if err != nil {
+		return fmt.Errorf("failed to marshal message: %w", err)
+	}
+
+	envelope.Signature, err = telemetry.Sign(context.Background(), p.identity.Signer, payload)
+	if err != nil {
+		return fmt.Errorf("failed to sign message: %w", err)
+	}
+
+	data, err := json.Marshal(envelope)
+	if err != nil {
+		return fmt.Errorf("failed to marshal message: %w", err)
+	}
+
+	if err := p.bus.Publish(subject, data); err != nil {
+		return fmt.Errorf("failed to publish to %s: %w", subject, err)
+	}
+
+	log.Printf("📤 Published signed message to %s [request_id=%s]", subject, msg.RequestID)
+	return nil
+}
+
+// Handler processes one verified message.
+type Handler func(msg *Message) error
+
+// Consumer verifies messages against the registry before passing them on.
+// Messages that fail verification are logged and dropped.
+type Consumer struct {
+	bus      Bus
+	resolver pqc.KeyResolver
+}
+
+func NewConsumer(bus Bus, resolver pqc.KeyResolver) *Consumer {
+	return &Consumer{bus: bus, resolver: resolver}
+}
+
+func (c *Consumer) Subscribe(subject string, handler Handler) error {
+	return c.bus.Subscribe(subject, func(data []byte) {
+		msg, err := c.verify(subject, data)
+		if err != nil {
+			log.Printf("❌ Rejected message on %s: %v", subject, err)
+			return
+		}
+
+		if err := handler(msg); err != nil {
+			log.Printf("❌ Failed to handle message on %s [request_id=%s]: %v", subject, msg.RequestID, err)
+		}
+	})
+}
+
+func (c *Consumer) verify(subject string, data []byte) (*Message, error) {
+	var envelope models.ServiceRequest
+	if err := json.Unmarshal(data, &envelope); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal message: %w", err)
+	}
+
+	if envelope.Headers[HeaderSubject] != subject {
+		return nil, fmt.Errorf("message was signed for subject %q", envelope.Headers[HeaderSubject])
+	}
+	if age := time.Since(envelope.Timestamp); age > MaxMessageAge || age < -MaxMessageAge {
+		return nil, fmt.Errorf("message timestamp %v is outside the allowed window", envelope.Timestamp)
+	}
+
+	_, verified := telemetry.Observe(context.Background(), telemetry.OpVerify, telemetry.Algorithm(envelope.SignatureAlg))
+	err := pqc.Verify(&envelope, c.resolver)
+	verified(err)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("✅ Message on %s verified from service: %s [request_id=%s]", subject, envelope.ServiceID, envelope.RequestID)
+	return &Message{
+		RequestID:      envelope.RequestID,
+		ParentID:       envelope.ParentID,
+		IdempotencyKey: envelope.IdempotencyKey,
+		Data:           envelope.Data,
+		Envelope:       envelope,
+	}, nil
+}
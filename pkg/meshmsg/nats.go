@@ -0,0 +1,38 @@
+package meshmsg
+
+import (
+	"fmt"
+
+	"github.com/nats-io/nats.go"
+)
+
+type natsBus struct {
+	conn  *nats.Conn
+	group string
+}
+
+func connectNATS(url, group string) (*natsBus, error) {
+	conn, err := nats.Connect(url, nats.Name(group))
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to NATS at %s: %w", url, err)
+	}
+	return &natsBus{conn: conn, group: group}, nil
+}
+
+func (b *natsBus) Publish(subject string, data []byte) error {
+	return b.conn.Publish(subject, data)
+}
+
+func (b *natsBus) Subscribe(subject string, handler func(data []byte)) error {
+	_, err := b.conn.QueueSubscribe(subject, b.group, func(msg *nats.Msg) {
+		handler(msg.Data)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to subscribe to %s: %w", subject, err)
+	}
+	return nil
+}
+
+func (b *natsBus) Close() error {
+	return b.conn.Drain()
+}
@@ -0,0 +1,231 @@
+package meshtest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"quantum-safe-mesh/pkg/mesh"
+	"quantum-safe-mesh/pkg/models"
+	"quantum-safe-mesh/pkg/pqc"
+)
+
+// AuthServer is a stand-in for the auth service on a local httptest
+// server. It speaks the same API, signs its responses with a fixed
+// identity, and keeps its registry in memory. Registration is accepted
+// without SPIFFE or bootstrap checks, but must be signed with the key being
+// registered; rotation, revocation and key exchange need a request signed
+// with the current key.
+type AuthServer struct {
+	URL      string
+	Identity *mesh.Identity
+	Registry *Registry
+
+	server    *httptest.Server
+	verifier  *mesh.VerifyingServer
+	mutex     sync.RWMutex
+	addresses map[string]string
+}
+
+// NewAuthServer starts an AuthServer with the given identities already
+// registered. Callers should Close it when done.
+func NewAuthServer(identities ...*mesh.Identity) *AuthServer {
+	identity := Identity(mesh.AuthServiceID)
+
+	as := &AuthServer{
+		Identity:  identity,
+		Registry:  NewRegistry(identities...),
+		addresses: make(map[string]string),
+	}
+	as.Registry.Add(identity)
+	as.verifier = mesh.NewVerifyingServer(identity, as.Registry.PublicKey)
+
+	r := mux.NewRouter()
+	r.HandleFunc("/register", as.verifier.VerifiedClaim(claimedKey, as.register)).Methods("POST")
+	r.HandleFunc("/public-key/{serviceID:.+}", as.verifier.Signed(as.publicKey)).Methods("GET")
+	r.HandleFunc("/rotate", as.verifier.Verified(as.rotate)).Methods("POST")
+	r.HandleFunc("/revoke", as.verifier.Verified(as.revoke)).Methods("POST")
+	r.HandleFunc("/key-exchange", as.verifier.Verified(as.keyExchange)).Methods("POST")
+	r.HandleFunc("/services", as.verifier.Signed(as.services)).Methods("GET", "POST")
+	r.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("Auth Service OK"))
+	}).Methods("GET")
+
+	as.server = httptest.NewServer(r)
+	as.URL = as.server.URL
+	return as
+}
+
+// Close shuts the server down.
+func (as *AuthServer) Close() {
+	as.server.Close()
+}
+
+// Client returns a registry client acting as identity against this server.
+func (as *AuthServer) Client(identity *mesh.Identity) *mesh.RegistryClient {
+	return mesh.NewRegistryClient(as.URL, identity, mesh.NewPeerVersions())
+}
+
+func claimedKey(data []byte) (string, []byte) {
+	var keyPair models.ServiceKeyPair
+	if err := json.Unmarshal(data, &keyPair); err != nil {
+		return "", nil
+	}
+	return keyPair.ServiceID, keyPair.PublicKey
+}
+
+func (as *AuthServer) register(req *mesh.Request) (interface{}, error) {
+	var keyPair models.ServiceKeyPair
+	if err := json.Unmarshal(req.Envelope.Data, &keyPair); err != nil {
+		return nil, models.NewError(http.StatusBadRequest, models.ErrCodeInvalidRequest, "Invalid request body")
+	}
+	if keyPair.ServiceID == "" || len(keyPair.PublicKey) == 0 {
+		return nil, models.NewError(http.StatusBadRequest, models.ErrCodeInvalidRequest, "service_id and public_key are required")
+	}
+	if req.Envelope.ServiceID != keyPair.ServiceID {
+		return nil, models.NewError(http.StatusForbidden, models.ErrCodeIdentityMismatch, "Services may only register themselves")
+	}
+
+	as.Registry.Set(keyPair.ServiceID, keyPair.PublicKey)
+	as.mutex.Lock()
+	if keyPair.Address != "" {
+		as.addresses[keyPair.ServiceID] = keyPair.Address
+	} else {
+		delete(as.addresses, keyPair.ServiceID)
+	}
+	as.mutex.Unlock()
+
+	return map[string]interface{}{
+		"status":     "success",
+		"service_id": keyPair.ServiceID,
+		"message":    "Service registered successfully",
+		"timestamp":  time.Now(),
+	}, nil
+}
+
+func (as *AuthServer) publicKey(req *mesh.Request) (interface{}, error) {
+	serviceID := mux.Vars(req.Request)["serviceID"]
+
+	publicKey, err := as.Registry.PublicKey(serviceID)
+	if err != nil {
+		return nil, models.NewError(http.StatusNotFound, models.ErrCodeServiceNotFound, "Service not found")
+	}
+
+	as.mutex.RLock()
+	address := as.addresses[serviceID]
+	as.mutex.RUnlock()
+
+	return models.PublicKeyResponse{
+		ServiceID: serviceID,
+		PublicKey: publicKey,
+		Address:   address,
+		Timestamp: time.Now(),
+	}, nil
+}
+
+func (as *AuthServer) rotate(req *mesh.Request) (interface{}, error) {
+	var rotation models.KeyRotationRequest
+	if err := json.Unmarshal(req.Envelope.Data, &rotation); err != nil {
+		return nil, models.NewError(http.StatusBadRequest, models.ErrCodeInvalidRequest, "Invalid request body")
+	}
+	if req.Envelope.ServiceID != rotation.ServiceID {
+		return nil, models.NewError(http.StatusForbidden, models.ErrCodeIdentityMismatch, "Services may only rotate their own key")
+	}
+
+	proofPayload, err := rotation.ProofPayload()
+	if err != nil {
+		return nil, err
+	}
+	if err := pqc.VerifySignature(rotation.Algorithm, rotation.NewPublicKey, proofPayload, rotation.Proof); err != nil {
+		return nil, models.NewError(http.StatusBadRequest, models.ErrCodeInvalidSignature, "Invalid proof of possession for new key")
+	}
+
+	oldPublicKey, _ := as.Registry.PublicKey(rotation.ServiceID)
+	as.Registry.Set(rotation.ServiceID, rotation.NewPublicKey)
+
+	return map[string]interface{}{
+		"status":          "success",
+		"service_id":      rotation.ServiceID,
+		"old_fingerprint": pqc.KeyFingerprint(oldPublicKey),
+		"new_fingerprint": pqc.KeyFingerprint(rotation.NewPublicKey),
+		"timestamp":       time.Now(),
+	}, nil
+}
+
+func (as *AuthServer) revoke(req *mesh.Request) (interface{}, error) {
+	var revocation models.RevocationRequest
+	if err := json.Unmarshal(req.Envelope.Data, &revocation); err != nil {
+		return nil, models.NewError(http.StatusBadRequest, models.ErrCodeInvalidRequest, "Invalid request body")
+	}
+	if req.Envelope.ServiceID != revocation.ServiceID {
+		return nil, models.NewError(http.StatusForbidden, models.ErrCodeIdentityMismatch, "Services may only revoke themselves")
+	}
+	if revocation.ServiceID == as.Identity.ServiceID {
+		return nil, models.NewError(http.StatusBadRequest, models.ErrCodeInvalidRequest, "The auth service cannot be revoked")
+	}
+
+	publicKey, _ := as.Registry.PublicKey(revocation.ServiceID)
+	as.Registry.Remove(revocation.ServiceID)
+	as.mutex.Lock()
+	delete(as.addresses, revocation.ServiceID)
+	as.mutex.Unlock()
+
+	return map[string]interface{}{
+		"status":      "success",
+		"service_id":  revocation.ServiceID,
+		"fingerprint": pqc.KeyFingerprint(publicKey),
+		"timestamp":   time.Now(),
+	}, nil
+}
+
+func (as *AuthServer) services(req *mesh.Request) (interface{}, error) {
+	services := as.Registry.Services()
+	if query, paginated, err := mesh.ParsePageQuery(req.Request); err != nil {
+		return nil, err
+	} else if paginated {
+		listings := make([]models.ServiceListing, len(services))
+		for i, serviceID := range services {
+			listings[i] = models.ServiceListing{ServiceID: serviceID}
+		}
+		return mesh.Paginate(query, listings, func(listing models.ServiceListing) string { return listing.ServiceID })
+	}
+	return map[string]interface{}{
+		"services":  services,
+		"count":     len(services),
+		"timestamp": time.Now(),
+	}, nil
+}
+
+func (as *AuthServer) keyExchange(req *mesh.Request) (interface{}, error) {
+	var request models.KeyExchangeRequest
+	if err := json.Unmarshal(req.Envelope.Data, &request); err != nil {
+		return nil, models.NewError(http.StatusBadRequest, models.ErrCodeInvalidRequest, "Invalid request body")
+	}
+	if req.Envelope.ServiceID != request.ServiceID {
+		return nil, models.NewError(http.StatusForbidden, models.ErrCodeIdentityMismatch, "Services may only exchange their own keys")
+	}
+
+	kemAlgorithm, err := pqc.KEMAlgorithm(request.KEMAlgorithm)
+	if err != nil {
+		return nil, models.NewError(http.StatusBadRequest, models.ErrCodeInvalidRequest, "Unsupported KEM algorithm")
+	}
+	ciphertext, _, err := pqc.EncapsulateTo(kemAlgorithm, request.KyberPublicKey)
+	if err != nil {
+		return nil, models.NewError(http.StatusInternalServerError, models.ErrCodeInternal, "Encapsulation failed")
+	}
+
+	response := models.KeyExchangeResponse{
+		ProtocolVersion: models.WireProtocolVersion(req.ProtocolVersion),
+		Ciphertext:      ciphertext,
+		Timestamp:       time.Now(),
+		KEMAlgorithms:   pqc.KEMAlgorithms,
+	}
+	if kemAlgorithm != pqc.AlgorithmKyber768 {
+		response.KEMAlgorithm = kemAlgorithm
+	}
+	return response, nil
+}
@@ -0,0 +1,109 @@
+// Package meshtest provides fakes for testing code built on the mesh: fixed
+// identities that need no key generation, an in-memory key registry, and
+// an httptest server speaking the auth service's API.
+package meshtest
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"fmt"
+	"sort"
+	"sync"
+
+	"quantum-safe-mesh/pkg/mesh"
+	"quantum-safe-mesh/pkg/pqc"
+)
+
+// seedPrefix separates meshtest's keys from any other deterministic keys.
+const seedPrefix = "quantum-safe-mesh/meshtest/"
+
+var identities sync.Map // serviceID -> *mesh.Identity
+
+// Identity returns a fixed identity for serviceID. Its keys are derived from
+// the service ID, so they are the same in every test run, and are computed
+// once per process. They are public knowledge: never use them outside tests.
+func Identity(serviceID string) *mesh.Identity {
+	if identity, ok := identities.Load(serviceID); ok {
+		return identity.(*mesh.Identity)
+	}
+
+	dilithiumSeed := sha256.Sum256([]byte(seedPrefix + "dilithium/" + serviceID))
+	dilithiumKeyPair, err := pqc.DeriveDilithiumKeyPair(dilithiumSeed[:])
+	if err != nil {
+		panic(fmt.Sprintf("meshtest: %v", err))
+	}
+
+	kyberSeed := sha512.Sum512([]byte(seedPrefix + "kyber/" + serviceID))
+	kyberKeyPair, err := pqc.DeriveKyberKeyPair(kyberSeed[:])
+	if err != nil {
+		panic(fmt.Sprintf("meshtest: %v", err))
+	}
+
+	identity, _ := identities.LoadOrStore(serviceID, &mesh.Identity{
+		ServiceID: serviceID,
+		Signer:    dilithiumKeyPair,
+		Kyber:     kyberKeyPair,
+	})
+	return identity.(*mesh.Identity)
+}
+
+// Registry is an in-memory map of service IDs to Dilithium public keys. Its
+// PublicKey method satisfies pqc.PublicKeyLookup, so it can stand in for a
+// mesh.RegistryClient wherever a lookup is taken.
+type Registry struct {
+	mutex sync.RWMutex
+	keys  map[string][]byte
+}
+
+// NewRegistry returns a registry holding the given identities' keys.
+func NewRegistry(identities ...*mesh.Identity) *Registry {
+	r := &Registry{keys: make(map[string][]byte)}
+	for _, identity := range identities {
+		r.Add(identity)
+	}
+	return r
+}
+
+// Add registers identity's current public key.
+func (r *Registry) Add(identity *mesh.Identity) {
+	r.Set(identity.ServiceID, identity.PublicKey())
+}
+
+// Set registers publicKey for serviceID, replacing any earlier key.
+func (r *Registry) Set(serviceID string, publicKey []byte) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.keys[serviceID] = publicKey
+}
+
+// Remove unregisters serviceID.
+func (r *Registry) Remove(serviceID string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	delete(r.keys, serviceID)
+}
+
+// PublicKey returns serviceID's registered key.
+func (r *Registry) PublicKey(serviceID string) ([]byte, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	publicKey, exists := r.keys[serviceID]
+	if !exists {
+		return nil, fmt.Errorf("service not registered: %s", serviceID)
+	}
+	return publicKey, nil
+}
+
+// Services lists the registered service IDs in sorted order.
+func (r *Registry) Services() []string {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	services := make([]string, 0, len(r.keys))
+	for serviceID := range r.keys {
+		services = append(services, serviceID)
+	}
+	sort.Strings(services)
+	return services
+}
@@ -0,0 +1,146 @@
+// Package middleware holds the HTTP middleware and error mapping the auth
+// service, gateway and backend share, so a failing request is answered the
+// same way wherever in the mesh it fails: with an ErrorResponse carrying
+// the status, code and request ID.
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"runtime/debug"
+	"time"
+
+	"quantum-safe-mesh/pkg/meshlog"
+	"quantum-safe-mesh/pkg/models"
+)
+
+// Recover answers a request whose handler panics with a 500 internal_error
+// ErrorResponse, logging the panic and its stack. If the handler had
+// already started its response, that response is cut short instead, since
+// its status has been sent. http.ErrAbortHandler is let through.
+func Recover(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rw := &responseWriter{ResponseWriter: w}
+		defer func() {
+			recovered := recover()
+			if recovered == nil {
+				return
+			}
+			if recovered == http.ErrAbortHandler {
+				panic(recovered)
+			}
+
+			meshlog.FromContext(r.Context()).Error("❌ Panic serving request",
+				"method", r.Method, "path", r.URL.Path, "request_id", r.Header.Get(models.HeaderRequestID),
+				"panic", fmt.Sprint(recovered), "stack", string(debug.Stack()))
+			if rw.wroteHeader {
+				panic(http.ErrAbortHandler)
+			}
+			models.WriteErrorResponse(w, models.NewErrorResponse(r, http.StatusInternalServerError, models.ErrCodeInternal, "Internal server error"))
+		}()
+		next.ServeHTTP(rw, r)
+	})
+}
+
+// Deadline gives each request timeout to be answered in. Handlers that pass
+// the request's context on, to key lookups and upstream calls, stop
+// waiting on them once it passes, and ErrorFor answers 504 timeout. A zero
+// timeout sets no deadline.
+func Deadline(timeout time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if timeout <= 0 {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx, cancel := context.WithTimeout(r.Context(), timeout)
+			defer cancel()
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// MaxBodySize caps request bodies at limit bytes. A request declaring a
+// longer body is answered 413 request_too_large before its handler runs;
+// one that turns out longer fails reading past the limit, which ErrorFor
+// maps to the same. A limit of zero or less caps nothing.
+func MaxBodySize(limit int64) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if limit <= 0 {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.ContentLength > limit {
+				models.WriteErrorResponse(w, models.NewErrorResponse(r, http.StatusRequestEntityTooLarge, models.ErrCodeRequestTooLarge,
+					fmt.Sprintf("Request body too large: the limit is %d bytes", limit)))
+				return
+			}
+			r.Body = http.MaxBytesReader(w, r.Body, limit)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// ErrorFor maps err, returned by a handler or filter, to the ErrorResponse
+// answering it, tagged with requestID unless it already carries one:
+//
+//   - a *models.ErrorResponse is sent as it is
+//   - a body over its limit (*http.MaxBytesError) is 413 request_too_large
+//   - malformed JSON is 400 invalid_request
+//   - a deadline passing is 504 timeout
+//
+// Any other error is logged through ctx's logger and reported as a 500
+// internal_error, without its details.
+func ErrorFor(ctx context.Context, err error, requestID string) *models.ErrorResponse {
+	var (
+		errResp   *models.ErrorResponse
+		tooLarge  *http.MaxBytesError
+		syntax    *json.SyntaxError
+		wrongType *json.UnmarshalTypeError
+	)
+	switch {
+	case errors.As(err, &errResp):
+	case errors.As(err, &tooLarge):
+		errResp = models.NewError(http.StatusRequestEntityTooLarge, models.ErrCodeRequestTooLarge, "Request body too large")
+	case errors.As(err, &syntax), errors.As(err, &wrongType):
+		errResp = models.NewError(http.StatusBadRequest, models.ErrCodeInvalidRequest, "Malformed JSON: "+err.Error())
+	case errors.Is(err, context.DeadlineExceeded):
+		errResp = models.NewError(http.StatusGatewayTimeout, models.ErrCodeTimeout, "Request timed out")
+	default:
+		meshlog.FromContext(ctx).Error("❌ Handler failed", "error", err)
+		errResp = models.NewError(http.StatusInternalServerError, models.ErrCodeInternal, "Internal server error")
+	}
+
+	if errResp.RequestID == "" {
+		errResp.RequestID = requestID
+	}
+	return errResp
+}
+
+// WriteError answers r with the ErrorResponse for err.
+func WriteError(w http.ResponseWriter, r *http.Request, err error) {
+	models.WriteErrorResponse(w, ErrorFor(r.Context(), err, r.Header.Get(models.HeaderRequestID)))
+}
+
+// responseWriter notes whether the response has started.
+type responseWriter struct {
+	http.ResponseWriter
+	wroteHeader bool
+}
+
+func (w *responseWriter) WriteHeader(status int) {
+	w.wroteHeader = true
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *responseWriter) Write(p []byte) (int, error) {
+	w.wroteHeader = true
+	return w.ResponseWriter.Write(p)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (w *responseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,61 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// MaxSignatureChainLength bounds how many hops may append to a chain, so a
+// hostile peer cannot make verifiers check an unbounded list.
+const MaxSignatureChainLength = 16
+
+// ChainLink is one hop's signature in a SignatureChain.
+type ChainLink struct {
+	SignerID  string    `json:"signer_id"`
+	KeyID     string    `json:"key_id,omitempty"`
+	Alg       string    `json:"alg"`
+	Signature []byte    `json:"signature,omitempty"`
+	SignedAt  time.Time `json:"signed_at"`
+}
+
+// SignatureChain records, in order, every hop that handled a message. Each
+// link signs the message plus all links before it, so hops can neither be
+// removed nor reordered without breaking later signatures.
+type SignatureChain []ChainLink
+
+// SigningInput returns the bytes link must sign when appended after the
+// current chain. envelope is the message's ChainPayload.
+func (c SignatureChain) SigningInput(envelope []byte, link ChainLink) ([]byte, error) {
+	link.Signature = nil
+	// Verifiers pass chain[:i], which is empty but not nil for the first
+	// link; both must marshal as null, as the signer's nil chain does.
+	if len(c) == 0 {
+		c = nil
+	}
+	input, err := json.Marshal(struct {
+		Envelope []byte         `json:"envelope"`
+		Prior    SignatureChain `json:"prior"`
+		Link     ChainLink      `json:"link"`
+	}{envelope, c, link})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal chain signing input: %w", err)
+	}
+	return input, nil
+}
+
+// ChainPayload returns the envelope bytes covered by the signature chain:
+// everything except the signatures themselves.
+func (r ServiceRequest) ChainPayload() ([]byte, error) {
+	r.Signature = nil
+	r.Chain = nil
+	return json.Marshal(r)
+}
+
+// ChainPayload returns the envelope bytes covered by the signature chain:
+// everything except the signatures themselves.
+func (r ServiceResponse) ChainPayload() ([]byte, error) {
+	r.Signature = nil
+	r.Chain = nil
+	return json.Marshal(r)
+}
@@ -0,0 +1,33 @@
+package models
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"regexp"
+)
+
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+// NewRequestID returns a random 128-bit correlation ID.
+func NewRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// IsValidRequestID rejects IDs that could corrupt log lines or headers.
+func IsValidRequestID(id string) bool {
+	return validRequestID.MatchString(id)
+}
+
+// EdgeRequestIDs assigns the correlation and causation IDs for a request
+// entering the mesh. The mesh always mints its own request_id; a well-formed
+// X-Request-ID from the client becomes the parent_id so the client's own
+// trace can still be joined to ours.
+func EdgeRequestIDs(r *http.Request) (requestID, parentID string) {
+	if clientID := r.Header.Get(HeaderRequestID); IsValidRequestID(clientID) {
+		parentID = clientID
+	}
+	return NewRequestID(), parentID
+}
@@ -0,0 +1,287 @@
+package models
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// Base64URL is a byte slice that marshals to JSON as unpadded base64url and
+// unmarshals from either base64 alphabet, padded or not. encoding/json's
+// default for []byte is padded standard base64, which needs escaping in URLs
+// and headers and has repeatedly been mis-decoded by consumers.
+type Base64URL []byte
+
+func (b Base64URL) MarshalJSON() ([]byte, error) {
+	if b == nil {
+		return []byte("null"), nil
+	}
+	// Base64 needs no JSON escaping, so it is quoted as is.
+	encoded := make([]byte, 0, base64.RawURLEncoding.EncodedLen(len(b))+2)
+	encoded = append(encoded, '"')
+	encoded = base64.RawURLEncoding.AppendEncode(encoded, b)
+	return append(encoded, '"'), nil
+}
+
+func (b *Base64URL) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		*b = nil
+		return nil
+	}
+
+	var encoded string
+	if err := json.Unmarshal(data, &encoded); err != nil {
+		return fmt.Errorf("binary field must be a base64 string: %w", err)
+	}
+
+	decoded, err := DecodeBase64(encoded)
+	if err != nil {
+		return err
+	}
+	*b = decoded
+	return nil
+}
+
+// DecodeBase64 accepts standard or URL-safe base64, with or without padding.
+func DecodeBase64(encoded string) ([]byte, error) {
+	encoded = strings.TrimRight(encoded, "=")
+	if strings.ContainsAny(encoded, "+/") {
+		return base64.RawStdEncoding.DecodeString(encoded)
+	}
+	return base64.RawURLEncoding.DecodeString(encoded)
+}
+
+// PublicKeyResponse is the Data of a signed /public-key response from
+// protocol 1.3 on. Earlier versions return an untyped map that has to be
+// picked apart with pqc.DeserializePublicKeyFromJSON.
+type PublicKeyResponse struct {
+	ServiceID string    `json:"service_id"`
+	PublicKey Base64URL `json:"public_key"`
+	Algorithm string    `json:"algorithm,omitempty"` // signature algorithm of PublicKey; empty means dilithium3
+	SPIFFEID  string    `json:"spiffe_id,omitempty"` // workload identity the key is bound to
+	Address   string    `json:"address,omitempty"`   // base URL advertised at registration
+	Timestamp time.Time `json:"timestamp"`
+
+	// ServiceAccount is the Kubernetes service account the key is bound to,
+	// as system:serviceaccount:<namespace>:<name>.
+	ServiceAccount string `json:"service_account,omitempty"`
+
+	// CloudIdentity is the cloud instance the key is bound to, as
+	// <provider>:<account>:<location>:<instance>.
+	CloudIdentity string `json:"cloud_identity,omitempty"`
+
+	// KyberPublicKey is the key sessions with the service are sealed to, if
+	// it registered one.
+	KyberPublicKey Base64URL `json:"kyber_public_key,omitempty"`
+	KEMAlgorithm   string    `json:"kem_algorithm,omitempty"` // of KyberPublicKey; empty means kyber768
+
+	// RegisteredAt is when the current key was registered or rotated in. It
+	// is zero from auth services that do not track it.
+	RegisteredAt time.Time `json:"registered_at"`
+
+	// Rotations are the signed records of the service's recent key
+	// rotations, oldest first, so peers that pinned an earlier key can check
+	// the current one descends from it.
+	Rotations []KeyRotationRecord `json:"rotations,omitempty"`
+
+	// DelegatedBy lists the services that registered the key on the
+	// service's behalf, outermost delegator first.
+	DelegatedBy []string `json:"delegated_by,omitempty"`
+
+	// Build is the build the service reported running when it registered.
+	Build *BuildInfo `json:"build,omitempty"`
+}
+
+// The marshalers below switch binary fields to Base64URL from protocol 1.3.
+// Older envelopes keep encoding/json's standard base64 so that pre-1.3 peers
+// re-marshal exactly the bytes we sent. Unmarshaling is always lenient.
+
+func (k ServiceKeyPair) MarshalJSON() ([]byte, error) {
+	type alias ServiceKeyPair
+	if !ProtocolVersionAtLeast(k.ProtocolVersion, ProtocolVersion13) {
+		return json.Marshal(alias(k))
+	}
+	return json.Marshal(struct {
+		alias
+		PublicKey  Base64URL `json:"public_key"`
+		PrivateKey Base64URL `json:"private_key,omitempty"`
+	}{alias(k), k.PublicKey, k.PrivateKey})
+}
+
+func (k *ServiceKeyPair) UnmarshalJSON(data []byte) error {
+	type alias ServiceKeyPair
+	aux := struct {
+		*alias
+		PublicKey  Base64URL `json:"public_key"`
+		PrivateKey Base64URL `json:"private_key,omitempty"`
+	}{alias: (*alias)(k)}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+	k.PublicKey, k.PrivateKey = aux.PublicKey, aux.PrivateKey
+	return nil
+}
+
+func (r ServiceRequest) MarshalJSON() ([]byte, error) {
+	return json.Marshal(r.wire())
+}
+
+// wire returns what r is encoded as, so it can be encoded straight into a
+// buffer without MarshalJSON's intermediate copy.
+func (r ServiceRequest) wire() interface{} {
+	type alias ServiceRequest
+	if !ProtocolVersionAtLeast(r.ProtocolVersion, ProtocolVersion13) {
+		return alias(r)
+	}
+	return struct {
+		alias
+		Signature Base64URL `json:"signature"`
+	}{alias(r), r.Signature}
+}
+
+// nullSignature is the signature field of a signing payload, which is
+// marshaled with the signature cleared.
+var nullSignature = []byte(`"signature":null`)
+
+// SignedWire returns r's JSON encoding, as MarshalJSON would give it, and
+// its length. It is read from signingPayload with r's signature filled in,
+// so Data is neither marshaled nor copied a second time. signingPayload
+// must be r's SigningPayload, and r must have no signature chain. Only
+// string fields follow the signature in the payload, so its last
+// "signature":null is the envelope's own, not one inside Data.
+func (r ServiceRequest) SignedWire(signingPayload []byte) (io.Reader, int64, error) {
+	i := bytes.LastIndex(signingPayload, nullSignature)
+	if i < 0 {
+		return nil, 0, fmt.Errorf("signing payload has no signature field")
+	}
+
+	// Base64 needs no JSON escaping, so the field is encoded in place.
+	encoding := base64.StdEncoding
+	if ProtocolVersionAtLeast(r.ProtocolVersion, ProtocolVersion13) {
+		encoding = base64.RawURLEncoding
+	}
+	field := []byte(`"signature":null`)
+	if r.Signature != nil {
+		field = make([]byte, 0, len(`"signature":""`)+encoding.EncodedLen(len(r.Signature)))
+		field = append(field, `"signature":"`...)
+		field = encoding.AppendEncode(field, r.Signature)
+		field = append(field, '"')
+	}
+
+	before, after := signingPayload[:i], signingPayload[i+len(nullSignature):]
+	size := int64(len(before) + len(field) + len(after))
+	// Only Read is exposed: a MultiReader's WriteTo allocates a 32KB copy
+	// buffer for every request the transport sends.
+	return struct{ io.Reader }{io.MultiReader(bytes.NewReader(before), bytes.NewReader(field), bytes.NewReader(after))}, size, nil
+}
+
+func (r *ServiceRequest) UnmarshalJSON(data []byte) error {
+	type alias ServiceRequest
+	aux := struct {
+		*alias
+		Signature Base64URL `json:"signature"`
+	}{alias: (*alias)(r)}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+	r.Signature = aux.Signature
+	return nil
+}
+
+func (r ServiceResponse) MarshalJSON() ([]byte, error) {
+	return json.Marshal(r.wire())
+}
+
+// wire returns what r is encoded as, so it can be encoded straight into a
+// buffer without MarshalJSON's intermediate copy.
+func (r ServiceResponse) wire() interface{} {
+	type alias ServiceResponse
+	if !ProtocolVersionAtLeast(r.ProtocolVersion, ProtocolVersion13) {
+		return alias(r)
+	}
+	return struct {
+		alias
+		Signature Base64URL `json:"signature"`
+	}{alias(r), r.Signature}
+}
+
+// encodeTo appends v's JSON encoding to buf, byte for byte what
+// json.Marshal returns.
+func encodeTo(buf *bytes.Buffer, v interface{}) error {
+	if err := json.NewEncoder(buf).Encode(v); err != nil {
+		return err
+	}
+	buf.Truncate(buf.Len() - 1) // Encode ends with a newline; Marshal doesn't
+	return nil
+}
+
+func (r *ServiceResponse) UnmarshalJSON(data []byte) error {
+	type alias ServiceResponse
+	aux := struct {
+		*alias
+		Signature Base64URL `json:"signature"`
+	}{alias: (*alias)(r)}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+	r.Signature = aux.Signature
+	return nil
+}
+
+func (r KeyExchangeRequest) MarshalJSON() ([]byte, error) {
+	type alias KeyExchangeRequest
+	if !ProtocolVersionAtLeast(r.ProtocolVersion, ProtocolVersion13) {
+		return json.Marshal(alias(r))
+	}
+	return json.Marshal(struct {
+		alias
+		KyberPublicKey Base64URL `json:"kyber_public_key"`
+		Signature      Base64URL `json:"signature"`
+	}{alias(r), r.KyberPublicKey, r.Signature})
+}
+
+func (r *KeyExchangeRequest) UnmarshalJSON(data []byte) error {
+	type alias KeyExchangeRequest
+	aux := struct {
+		*alias
+		KyberPublicKey Base64URL `json:"kyber_public_key"`
+		Signature      Base64URL `json:"signature"`
+	}{alias: (*alias)(r)}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+	r.KyberPublicKey, r.Signature = aux.KyberPublicKey, aux.Signature
+	return nil
+}
+
+func (r KeyExchangeResponse) MarshalJSON() ([]byte, error) {
+	type alias KeyExchangeResponse
+	if !ProtocolVersionAtLeast(r.ProtocolVersion, ProtocolVersion13) {
+		return json.Marshal(alias(r))
+	}
+	return json.Marshal(struct {
+		alias
+		Ciphertext   Base64URL `json:"ciphertext"`
+		SharedSecret Base64URL `json:"shared_secret,omitempty"`
+		Signature    Base64URL `json:"signature"`
+	}{alias(r), r.Ciphertext, r.SharedSecret, r.Signature})
+}
+
+func (r *KeyExchangeResponse) UnmarshalJSON(data []byte) error {
+	type alias KeyExchangeResponse
+	aux := struct {
+		*alias
+		Ciphertext   Base64URL `json:"ciphertext"`
+		SharedSecret Base64URL `json:"shared_secret,omitempty"`
+		Signature    Base64URL `json:"signature"`
+	}{alias: (*alias)(r)}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+	r.Ciphertext, r.SharedSecret, r.Signature = aux.Ciphertext, aux.SharedSecret, aux.Signature
+	return nil
+}
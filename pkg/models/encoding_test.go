@@ -0,0 +1,403 @@
+package models
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Contract tests for the JSON form of binary fields. Keys, signatures and
+// ciphertexts have twice been corrupted on the wire by a consumer decoding
+// them with the wrong alphabet or as a number array; these pin down the
+// encoding each protocol version uses and check every model survives a
+// round trip, and a re-marshal for verification, byte for byte.
+
+// binary returns n bytes cycling through every byte value, so encodings
+// contain the characters that differ between the base64 alphabets.
+func binary(n int, offset byte) []byte {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = byte(i) + offset
+	}
+	return b
+}
+
+var fixedTime = time.Date(2025, 3, 14, 15, 9, 26, 535897000, time.UTC)
+
+// binaryField names a binary field by its JSON path, e.g.
+// "signature_chain.0.signature", and the bytes expected there. encoding,
+// when set, overrides the model's.
+type binaryField struct {
+	path     string
+	value    []byte
+	encoding *base64.Encoding
+}
+
+type modelCase struct {
+	name string
+	// versioned models switch to base64url from 1.3; the rest always use
+	// encoding.
+	versioned bool
+	encoding  *base64.Encoding
+	build     func(version string) (value interface{}, fields []binaryField)
+	empty     func() interface{}
+}
+
+func modelCases() []modelCase {
+	publicKey := binary(1952, 0)
+	privateKey := binary(4000, 7)
+	signature := binary(3293, 13)
+	kyberPublicKey := binary(1184, 29)
+	ciphertext := binary(1088, 31)
+	sharedSecret := binary(32, 251)
+	chain := SignatureChain{{SignerID: "api-gateway", KeyID: "k1", Alg: "Dilithium3", Signature: binary(3293, 101), SignedAt: fixedTime}}
+	// Chain links are part of their successors' signing input, so they keep
+	// encoding/json's standard base64 at every version.
+	chainField := binaryField{"signature_chain.0.signature", chain[0].Signature, base64.StdEncoding}
+
+	return []modelCase{
+		{
+			name: "ServiceKeyPair", versioned: true,
+			build: func(version string) (interface{}, []binaryField) {
+				return &ServiceKeyPair{ProtocolVersion: WireProtocolVersion(version), ServiceID: "backend-service", PublicKey: publicKey, PrivateKey: privateKey, Address: "http://backend:8082"},
+					[]binaryField{{"public_key", publicKey, nil}, {"private_key", privateKey, nil}}
+			},
+			empty: func() interface{} { return &ServiceKeyPair{} },
+		},
+		{
+			name: "ServiceRequest", versioned: true,
+			build: func(version string) (interface{}, []binaryField) {
+				return &ServiceRequest{ProtocolVersion: WireProtocolVersion(version), ServiceID: "api-gateway", RequestID: "req-1", ParentID: "req-0", Timestamp: fixedTime, Data: json.RawMessage(`{"message":"hi"}`), Signature: signature, Chain: chain, Headers: map[string]string{"X-Client-ID": "c1"}},
+					[]binaryField{{"signature", signature, nil}, chainField}
+			},
+			empty: func() interface{} { return &ServiceRequest{} },
+		},
+		{
+			name: "ServiceResponse", versioned: true,
+			build: func(version string) (interface{}, []binaryField) {
+				return &ServiceResponse{ProtocolVersion: WireProtocolVersion(version), ServiceID: "backend-service", RequestID: "req-1", Timestamp: fixedTime, Data: json.RawMessage(`{"ok":true}`), Signature: signature, Chain: chain, Success: true},
+					[]binaryField{{"signature", signature, nil}, chainField}
+			},
+			empty: func() interface{} { return &ServiceResponse{} },
+		},
+		{
+			name: "KeyExchangeRequest", versioned: true,
+			build: func(version string) (interface{}, []binaryField) {
+				return &KeyExchangeRequest{ProtocolVersion: WireProtocolVersion(version), ServiceID: "api-gateway", KyberPublicKey: kyberPublicKey, Timestamp: fixedTime, Signature: signature},
+					[]binaryField{{"kyber_public_key", kyberPublicKey, nil}, {"signature", signature, nil}}
+			},
+			empty: func() interface{} { return &KeyExchangeRequest{} },
+		},
+		{
+			name: "KeyExchangeResponse", versioned: true,
+			build: func(version string) (interface{}, []binaryField) {
+				return &KeyExchangeResponse{ProtocolVersion: WireProtocolVersion(version), Ciphertext: ciphertext, SharedSecret: sharedSecret, Timestamp: fixedTime, Signature: signature},
+					[]binaryField{{"ciphertext", ciphertext, nil}, {"shared_secret", sharedSecret, nil}, {"signature", signature, nil}}
+			},
+			empty: func() interface{} { return &KeyExchangeResponse{} },
+		},
+		{
+			name: "AuthToken", encoding: base64.StdEncoding,
+			build: func(version string) (interface{}, []binaryField) {
+				return &AuthToken{ProtocolVersion: WireProtocolVersion(version), ServiceID: "api-gateway", IssuedAt: fixedTime, ExpiresAt: fixedTime.Add(time.Hour), Signature: signature},
+					[]binaryField{{"signature", signature, nil}}
+			},
+			empty: func() interface{} { return &AuthToken{} },
+		},
+		{
+			name: "KeyRotationRequest", encoding: base64.RawURLEncoding,
+			build: func(string) (interface{}, []binaryField) {
+				return &KeyRotationRequest{ServiceID: "backend-service", NewPublicKey: publicKey, Proof: signature},
+					[]binaryField{{"new_public_key", publicKey, nil}, {"proof", signature, nil}}
+			},
+			empty: func() interface{} { return &KeyRotationRequest{} },
+		},
+		{
+			name: "PublicKeyResponse", encoding: base64.RawURLEncoding,
+			build: func(string) (interface{}, []binaryField) {
+				return &PublicKeyResponse{ServiceID: "backend-service", PublicKey: publicKey, SPIFFEID: "spiffe://mesh.local/backend", Address: "http://backend:8082", Timestamp: fixedTime},
+					[]binaryField{{"public_key", publicKey, nil}}
+			},
+			empty: func() interface{} { return &PublicKeyResponse{} },
+		},
+		{
+			name: "EncryptedPayload", encoding: base64.RawURLEncoding,
+			build: func(string) (interface{}, []binaryField) {
+				nonce, aad := binary(aesGCMNonceSize, 250), binary(20, 62)
+				return &EncryptedPayload{Alg: EncryptionAlgAES256GCM, KeyID: "k1", SessionID: "s1", Nonce: nonce, AAD: aad, Ciphertext: ciphertext},
+					[]binaryField{{"nonce", nonce, nil}, {"aad", aad, nil}, {"ciphertext", ciphertext, nil}}
+			},
+			empty: func() interface{} { return &EncryptedPayload{} },
+		},
+	}
+}
+
+// wireEncoding is the encoding binary fields of c are expected to use on
+// the wire at version.
+func (c modelCase) wireEncoding(version string) *base64.Encoding {
+	if !c.versioned {
+		return c.encoding
+	}
+	if ProtocolVersionAtLeast(version, ProtocolVersion13) {
+		return base64.RawURLEncoding
+	}
+	return base64.StdEncoding
+}
+
+// lookupPath walks a decoded JSON document along a dotted path.
+func lookupPath(t *testing.T, doc interface{}, path string) interface{} {
+	t.Helper()
+	for _, key := range strings.Split(path, ".") {
+		switch node := doc.(type) {
+		case map[string]interface{}:
+			doc = node[key]
+		case []interface{}:
+			i, err := strconv.Atoi(key)
+			if err != nil || i >= len(node) {
+				t.Fatalf("path %s: no element %s", path, key)
+			}
+			doc = node[i]
+		default:
+			t.Fatalf("path %s: cannot descend into %T", path, doc)
+		}
+	}
+	return doc
+}
+
+func TestBinaryFieldsWireEncoding(t *testing.T) {
+	for _, c := range modelCases() {
+		for _, version := range SupportedProtocolVersions {
+			t.Run(c.name+"/"+version, func(t *testing.T) {
+				value, fields := c.build(version)
+				wire, err := json.Marshal(value)
+				if err != nil {
+					t.Fatalf("Marshal: %v", err)
+				}
+
+				var doc interface{}
+				if err := json.Unmarshal(wire, &doc); err != nil {
+					t.Fatalf("Unmarshal: %v", err)
+				}
+
+				for _, field := range fields {
+					encoding := c.wireEncoding(version)
+					if field.encoding != nil {
+						encoding = field.encoding
+					}
+					encoded, ok := lookupPath(t, doc, field.path).(string)
+					if !ok {
+						t.Fatalf("%s: expected a base64 string, got %T", field.path, lookupPath(t, doc, field.path))
+					}
+					if want := encoding.EncodeToString(field.value); encoded != want {
+						t.Errorf("%s: not encoded with the expected alphabet and padding", field.path)
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestBinaryFieldsRoundTrip(t *testing.T) {
+	for _, c := range modelCases() {
+		for _, version := range SupportedProtocolVersions {
+			t.Run(c.name+"/"+version, func(t *testing.T) {
+				value, _ := c.build(version)
+				wire, err := json.Marshal(value)
+				if err != nil {
+					t.Fatalf("Marshal: %v", err)
+				}
+
+				decoded := c.empty()
+				if err := json.Unmarshal(wire, decoded); err != nil {
+					t.Fatalf("Unmarshal: %v", err)
+				}
+				if !reflect.DeepEqual(decoded, value) {
+					t.Fatalf("round trip changed the value:\n got %+v\nwant %+v", decoded, value)
+				}
+
+				rewire, err := json.Marshal(decoded)
+				if err != nil {
+					t.Fatalf("re-Marshal: %v", err)
+				}
+				if !bytes.Equal(rewire, wire) {
+					t.Fatalf("re-marshaled JSON differs:\n got %s\nwant %s", rewire, wire)
+				}
+			})
+		}
+	}
+}
+
+// TestSigningPayloadsSurviveTransport checks a receiver recomputes exactly
+// the bytes the sender signed, which is what signature verification rests on.
+func TestSigningPayloadsSurviveTransport(t *testing.T) {
+	cases := map[string]modelCase{}
+	for _, c := range modelCases() {
+		cases[c.name] = c
+	}
+
+	type signer interface{ SigningPayload() ([]byte, error) }
+	for _, name := range []string{"ServiceRequest", "ServiceResponse", "KeyExchangeRequest"} {
+		c := cases[name]
+		for _, version := range SupportedProtocolVersions {
+			t.Run(name+"/"+version, func(t *testing.T) {
+				value, _ := c.build(version)
+				want, err := value.(signer).SigningPayload()
+				if err != nil {
+					t.Fatalf("SigningPayload: %v", err)
+				}
+
+				wire, err := json.Marshal(value)
+				if err != nil {
+					t.Fatalf("Marshal: %v", err)
+				}
+				decoded := c.empty()
+				if err := json.Unmarshal(wire, decoded); err != nil {
+					t.Fatalf("Unmarshal: %v", err)
+				}
+
+				got, err := decoded.(signer).SigningPayload()
+				if err != nil {
+					t.Fatalf("SigningPayload after transport: %v", err)
+				}
+				if !bytes.Equal(got, want) {
+					t.Fatalf("signing payload changed in transport:\n got %s\nwant %s", got, want)
+				}
+
+				// Payloads encoded into pooled buffers must be the same.
+				if writer, ok := decoded.(interface{ WriteSigningPayload(*bytes.Buffer) error }); ok {
+					var buf bytes.Buffer
+					if err := writer.WriteSigningPayload(&buf); err != nil {
+						t.Fatalf("WriteSigningPayload: %v", err)
+					}
+					if !bytes.Equal(buf.Bytes(), want) {
+						t.Fatalf("written signing payload differs:\n got %s\nwant %s", buf.Bytes(), want)
+					}
+				}
+			})
+		}
+	}
+
+	// Chain links sign the envelope's ChainPayload and the links before them.
+	for _, name := range []string{"ServiceRequest", "ServiceResponse"} {
+		c := cases[name]
+		t.Run(name+"/chain", func(t *testing.T) {
+			type chained interface{ ChainPayload() ([]byte, error) }
+			value, _ := c.build(CurrentProtocolVersion)
+			want, _ := value.(chained).ChainPayload()
+
+			wire, _ := json.Marshal(value)
+			decoded := c.empty()
+			if err := json.Unmarshal(wire, decoded); err != nil {
+				t.Fatalf("Unmarshal: %v", err)
+			}
+			got, _ := decoded.(chained).ChainPayload()
+			if !bytes.Equal(got, want) {
+				t.Fatalf("chain payload changed in transport:\n got %s\nwant %s", got, want)
+			}
+
+			var buf bytes.Buffer
+			switch e := decoded.(type) {
+			case *ServiceRequest:
+				e.WriteSigningPayload(&buf)
+			case *ServiceResponse:
+				e.WriteChainPayload(&buf)
+			}
+			if !bytes.Equal(buf.Bytes(), want) {
+				t.Fatalf("written chain payload differs:\n got %s\nwant %s", buf.Bytes(), want)
+			}
+		})
+	}
+}
+
+// TestSignedWireMatchesMarshal checks an envelope spliced from its signing
+// payload is byte for byte the one MarshalJSON writes, including when its
+// data holds a null signature of its own.
+func TestSignedWireMatchesMarshal(t *testing.T) {
+	for _, version := range SupportedProtocolVersions {
+		t.Run(version, func(t *testing.T) {
+			request := ServiceRequest{
+				ProtocolVersion: WireProtocolVersion(version),
+				ServiceID:       "api-gateway",
+				RequestID:       "req-1",
+				Timestamp:       fixedTime,
+				Data:            json.RawMessage(`{"signature":null, "message":"<hi>"}`),
+				Headers:         map[string]string{"X-Note": `"signature":null`},
+			}
+			payload, err := request.SigningPayload()
+			if err != nil {
+				t.Fatalf("SigningPayload: %v", err)
+			}
+			request.Signature = binary(3293, 7)
+
+			reader, size, err := request.SignedWire(payload)
+			if err != nil {
+				t.Fatalf("SignedWire: %v", err)
+			}
+			got, _ := io.ReadAll(reader)
+			want, _ := json.Marshal(request)
+			if !bytes.Equal(got, want) {
+				t.Fatalf("spliced envelope differs:\n got %s\nwant %s", got, want)
+			}
+			if size != int64(len(want)) {
+				t.Fatalf("size = %d, want %d", size, len(want))
+			}
+		})
+	}
+}
+
+func TestBinaryFieldsAcceptEitherAlphabet(t *testing.T) {
+	want := binary(1952, 0)
+	encodings := map[string]*base64.Encoding{
+		"std":     base64.StdEncoding,
+		"raw std": base64.RawStdEncoding,
+		"url":     base64.URLEncoding,
+		"raw url": base64.RawURLEncoding,
+	}
+
+	for name, encoding := range encodings {
+		t.Run(name, func(t *testing.T) {
+			body := []byte(`{"protocol_version":"1.3","service_id":"s","public_key":"` + encoding.EncodeToString(want) + `"}`)
+
+			var keyPair ServiceKeyPair
+			if err := json.Unmarshal(body, &keyPair); err != nil {
+				t.Fatalf("Unmarshal ServiceKeyPair: %v", err)
+			}
+			if !bytes.Equal(keyPair.PublicKey, want) {
+				t.Fatalf("ServiceKeyPair decoded %d bytes that differ from the %d sent", len(keyPair.PublicKey), len(want))
+			}
+
+			var response PublicKeyResponse
+			if err := json.Unmarshal(body, &response); err != nil {
+				t.Fatalf("Unmarshal PublicKeyResponse: %v", err)
+			}
+			if !bytes.Equal(response.PublicKey, want) {
+				t.Fatalf("PublicKeyResponse decoded %d bytes that differ from the %d sent", len(response.PublicKey), len(want))
+			}
+		})
+	}
+}
+
+// TestBinaryFieldsRejectArrays checks a key re-marshaled from an untyped
+// []interface{} fails loudly instead of decoding to the wrong bytes.
+func TestBinaryFieldsRejectArrays(t *testing.T) {
+	body := []byte(`{"service_id":"s","public_key":[1,2,3],"signature":[1,2,3]}`)
+
+	targets := map[string]interface{}{
+		"ServiceKeyPair":     &ServiceKeyPair{},
+		"ServiceRequest":     &ServiceRequest{},
+		"ServiceResponse":    &ServiceResponse{},
+		"KeyExchangeRequest": &KeyExchangeRequest{},
+		"PublicKeyResponse":  &PublicKeyResponse{},
+	}
+	for name, target := range targets {
+		if err := json.Unmarshal(body, target); err == nil {
+			t.Errorf("%s: decoded a number array as binary", name)
+		}
+	}
+}
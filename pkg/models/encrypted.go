@@ -0,0 +1,85 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Content-encryption algorithms for EncryptedPayload.Alg. The 32-byte Kyber
+// shared secret is used directly as the AES-256 key.
+const (
+	EncryptionAlgAES256GCM = "A256GCM"
+
+	aesGCMNonceSize = 12
+)
+
+// EncryptedPayload is the wire form of an encrypted ServiceRequest or
+// ServiceResponse Data field. KeyID names the long-term key the content key
+// was derived from; SessionID names the Kyber session that produced it, when
+// one exists. AAD is authenticated but sent in the clear.
+type EncryptedPayload struct {
+	Alg        string    `json:"alg"`
+	KeyID      string    `json:"key_id,omitempty"`
+	SessionID  string    `json:"session_id,omitempty"`
+	Nonce      Base64URL `json:"nonce"`
+	AAD        Base64URL `json:"aad,omitempty"`
+	Ciphertext Base64URL `json:"ciphertext"`
+}
+
+func (p *EncryptedPayload) Validate() error {
+	switch p.Alg {
+	case EncryptionAlgAES256GCM:
+		if len(p.Nonce) != aesGCMNonceSize {
+			return fmt.Errorf("invalid nonce size for %s: expected %d, got %d", p.Alg, aesGCMNonceSize, len(p.Nonce))
+		}
+	default:
+		return fmt.Errorf("unsupported encryption algorithm: %q", p.Alg)
+	}
+
+	if p.KeyID == "" && p.SessionID == "" {
+		return fmt.Errorf("encrypted payload must name a key_id or session_id")
+	}
+	if len(p.Ciphertext) == 0 {
+		return fmt.Errorf("encrypted payload has no ciphertext")
+	}
+	return nil
+}
+
+// MarshalData validates p and encodes it for use as an envelope's Data.
+func (p *EncryptedPayload) MarshalData() (json.RawMessage, error) {
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+
+	data, err := json.Marshal(p)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal encrypted payload: %w", err)
+	}
+	return data, nil
+}
+
+// UnmarshalEncryptedPayload decodes and validates an envelope's Data field.
+func UnmarshalEncryptedPayload(data json.RawMessage) (*EncryptedPayload, error) {
+	var payload EncryptedPayload
+	if err := json.Unmarshal(data, &payload); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal encrypted payload: %w", err)
+	}
+
+	if err := payload.Validate(); err != nil {
+		return nil, err
+	}
+	return &payload, nil
+}
+
+// IsEncryptedPayload reports whether data looks like an EncryptedPayload, so
+// receivers can tell encrypted Data from plaintext without a separate flag.
+func IsEncryptedPayload(data json.RawMessage) bool {
+	var probe struct {
+		Alg        string          `json:"alg"`
+		Ciphertext json.RawMessage `json:"ciphertext"`
+	}
+	if err := json.Unmarshal(data, &probe); err != nil {
+		return false
+	}
+	return probe.Alg != "" && len(probe.Ciphertext) > 0
+}
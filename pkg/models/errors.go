@@ -0,0 +1,142 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+)
+
+// Error codes returned in ErrorResponse.Code. Clients branch on these rather
+// than on the human-readable message.
+const (
+	ErrCodeInvalidRequest             = "invalid_request"
+	ErrCodeUnsupportedVersion         = "unsupported_protocol_version"
+	ErrCodeServiceNotFound            = "service_not_found"
+	ErrCodeServiceNotRegistered       = "service_not_registered"
+	ErrCodeInvalidSignature           = "invalid_signature"
+	ErrCodeRequestExpired             = "request_expired"
+	ErrCodeClockSkew                  = "clock_skew"
+	ErrCodeRequestReplayed            = "request_replayed"
+	ErrCodeRequestTooLarge            = "request_too_large"
+	ErrCodeInternal                   = "internal_error"
+	ErrCodeUpstreamUnavailable        = "upstream_unavailable"
+	ErrCodeUpstreamInvalidResponse    = "upstream_invalid_response"
+	ErrCodeUpstreamSignatureInvalid   = "upstream_signature_invalid"
+	ErrCodeUpstreamAuthenticationFail = "upstream_authentication_failed"
+	ErrCodeUpstreamError              = "upstream_error"
+	ErrCodeUnauthenticated            = "unauthenticated"
+	ErrCodeIdentityMismatch           = "identity_mismatch"
+	ErrCodeNamespaceForbidden         = "namespace_forbidden"
+	ErrCodeNotFound                   = "not_found"
+	ErrCodeMethodNotAllowed           = "method_not_allowed"
+	ErrCodeQuotaExceeded              = "quota_exceeded"
+	ErrCodeUnsupportedAPIVersion      = "unsupported_api_version"
+	ErrCodePersistedQueryNotAllowed   = "persisted_query_not_allowed"
+	ErrCodeSchemaViolation            = "schema_violation"
+	ErrCodeIdempotencyKeyReused       = "idempotency_key_reused"
+	ErrCodeIdempotencyKeyInFlight     = "idempotency_key_in_flight"
+	ErrCodeRateLimited                = "rate_limited"
+	ErrCodeTimeout                    = "timeout"
+	ErrCodeOverloaded                 = "overloaded"
+)
+
+var retryableErrorCodes = map[string]bool{
+	ErrCodeInternal:                   true,
+	ErrCodeUpstreamUnavailable:        true,
+	ErrCodeUpstreamAuthenticationFail: true,
+	ErrCodeIdempotencyKeyInFlight:     true,
+	ErrCodeTimeout:                    true,
+	ErrCodeOverloaded:                 true,
+}
+
+type ErrorResponse struct {
+	Code      string `json:"code"`
+	Message   string `json:"message"`
+	RequestID string `json:"request_id,omitempty"`
+	Retryable bool   `json:"retryable"`
+	Status    int    `json:"-"`
+
+	// Violations lists what a schema_violation request got wrong.
+	Violations []SchemaViolation `json:"violations,omitempty"`
+
+	// RetryAfter is how many seconds a rate_limited or overloaded caller
+	// should wait before retrying, also sent as the Retry-After header.
+	RetryAfter int `json:"retry_after,omitempty"`
+}
+
+// SchemaViolation is a value that doesn't match its JSON Schema. Path is a
+// JSON Pointer to it within the request's data; it is empty for the data
+// as a whole.
+type SchemaViolation struct {
+	Path    string `json:"path"`
+	Message string `json:"message"`
+}
+
+func (e *ErrorResponse) Error() string {
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
+
+func IsRetryableErrorCode(code string) bool {
+	return retryableErrorCodes[code]
+}
+
+func NewError(status int, code, message string) *ErrorResponse {
+	return &ErrorResponse{
+		Code:      code,
+		Message:   message,
+		Retryable: IsRetryableErrorCode(code),
+		Status:    status,
+	}
+}
+
+// NewErrorResponse is NewError tagged with r's request ID.
+func NewErrorResponse(r *http.Request, status int, code, message string) *ErrorResponse {
+	errResp := NewError(status, code, message)
+	errResp.RequestID = r.Header.Get(HeaderRequestID)
+	return errResp
+}
+
+// WriteError answers r with a JSON ErrorResponse in place of http.Error.
+func WriteError(w http.ResponseWriter, r *http.Request, status int, code, message string) {
+	WriteErrorResponse(w, NewErrorResponse(r, status, code, message))
+}
+
+func WriteErrorResponse(w http.ResponseWriter, errResp *ErrorResponse) {
+	if errResp.RetryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(errResp.RetryAfter))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(errResp.Status)
+	json.NewEncoder(w).Encode(errResp)
+}
+
+// NotFoundHandler and MethodNotAllowedHandler replace the router's plain-text
+// defaults so unknown routes also answer with an ErrorResponse.
+var NotFoundHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	WriteError(w, r, http.StatusNotFound, ErrCodeNotFound, "No route for "+r.URL.Path)
+})
+
+var MethodNotAllowedHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	WriteError(w, r, http.StatusMethodNotAllowed, ErrCodeMethodNotAllowed, r.Method+" not allowed on "+r.URL.Path)
+})
+
+// DecodeErrorResponse reads an error body from a failed response. Bodies
+// that are not an ErrorResponse (older services, proxies) are wrapped so
+// callers always get a code to branch on.
+func DecodeErrorResponse(resp *http.Response) *ErrorResponse {
+	body, _ := io.ReadAll(resp.Body)
+
+	var errResp ErrorResponse
+	if err := json.Unmarshal(body, &errResp); err != nil || errResp.Code == "" {
+		errResp = ErrorResponse{
+			Code:    ErrCodeUpstreamInvalidResponse,
+			Message: fmt.Sprintf("unexpected response (status %d): %s", resp.StatusCode, string(body)),
+		}
+		errResp.Retryable = resp.StatusCode >= http.StatusInternalServerError
+	}
+
+	errResp.Status = resp.StatusCode
+	return &errResp
+}
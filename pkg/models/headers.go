@@ -0,0 +1,51 @@
+package models
+
+// HTTP headers exchanged between mesh services.
+const (
+	// HeaderAcceptVersion lists the protocol versions a caller can speak.
+	HeaderAcceptVersion = "X-Mesh-Accept-Version"
+	// HeaderProtocolVersion carries the version a service chose to respond with.
+	HeaderProtocolVersion = "X-Mesh-Protocol-Version"
+	// HeaderMeshSignature carries a compact JWS with a detached payload; the
+	// HTTP body is the payload, transmitted verbatim.
+	HeaderMeshSignature = "X-Mesh-Signature"
+	// HeaderRequestID and HeaderParentID mirror the envelope's correlation
+	// and causation IDs for callers and proxies that don't parse envelopes.
+	HeaderRequestID = "X-Request-ID"
+	HeaderParentID  = "X-Parent-ID"
+	// HeaderOriginalMethod records the client's HTTP method when a hop is
+	// forwarded as POST. It is only trusted from inside a signature.
+	HeaderOriginalMethod = "X-Mesh-Original-Method"
+	// HeaderKeyID, in a signed envelope's headers, names the fingerprint of
+	// the key that signed it, so verifiers can find keys other than the
+	// signer's current one.
+	HeaderKeyID = "X-Mesh-Key-ID"
+	// HeaderBootstrapToken carries a bootstrap token vouching for a
+	// service's registration.
+	HeaderBootstrapToken = "X-Mesh-Bootstrap-Token"
+	// HeaderServiceAccountToken carries a projected Kubernetes
+	// ServiceAccount token vouching for a service's registration.
+	HeaderServiceAccountToken = "X-Mesh-ServiceAccount-Token"
+	// HeaderCloudAttestation carries a cloud instance identity attestation
+	// vouching for a service's registration.
+	HeaderCloudAttestation = "X-Mesh-Cloud-Attestation"
+	// HeaderServerTiming reports how long the gateway spent signing,
+	// waiting on and verifying the upstream call.
+	HeaderServerTiming = "Server-Timing"
+	// HeaderCache tells whether the gateway answered from its response
+	// cache ("hit") or stored the backend's answer there ("miss").
+	HeaderCache = "X-Mesh-Cache"
+	// HeaderAcceptAPIVersion selects the version of the gateway's external
+	// API a client calls, and HeaderAPIVersion names the version that
+	// answered. These are API versions, not protocol versions.
+	HeaderAcceptAPIVersion = "Accept-Version"
+	HeaderAPIVersion       = "Content-Version"
+	// HeaderIdempotencyKey lets a client retry a mutating request through
+	// the gateway without it being processed twice; the gateway signs it
+	// into the request's envelope.
+	HeaderIdempotencyKey = "Idempotency-Key"
+	// HeaderBuildInfo, in a signed registration's headers, carries the
+	// registering service's BuildInfo as JSON for auth services that
+	// predate protocol 1.3.
+	HeaderBuildInfo = "X-Mesh-Build"
+)
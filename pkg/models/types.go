@@ -1,51 +1,473 @@
 package models
 
 import (
+	"bytes"
 	"encoding/json"
 	"time"
 )
 
 type ServiceKeyPair struct {
-	ServiceID  string `json:"service_id"`
-	PublicKey  []byte `json:"public_key"`
-	PrivateKey []byte `json:"private_key,omitempty"`
+	ProtocolVersion string `json:"protocol_version,omitempty"`
+	ServiceID       string `json:"service_id"`
+	PublicKey       []byte `json:"public_key"`
+	PrivateKey      []byte `json:"private_key,omitempty"`
+	Address         string `json:"address,omitempty"`   // base URL the service is reachable at
+	Algorithm       string `json:"algorithm,omitempty"` // signature algorithm of PublicKey; empty means dilithium3
+
+	// Rotations are the service's rotation records leading to PublicKey,
+	// presented again on each registration so the auth service can serve
+	// them after a restart.
+	Rotations []KeyRotationRecord `json:"rotations,omitempty"`
+
+	// Delegation, if set, registers the service on behalf of the services
+	// that signed it, instead of with its own credentials.
+	Delegation []DelegationAssertion `json:"delegation,omitempty"`
+
+	// KyberPublicKey, if set, lets the auth service broker sessions with
+	// the service, sealing session keys to it.
+	KyberPublicKey Base64URL `json:"kyber_public_key,omitempty"`
+	KEMAlgorithm   string    `json:"kem_algorithm,omitempty"` // of KyberPublicKey; empty means kyber768
+
+	// Build is the build the service is running, sent from protocol 1.3.
+	Build *BuildInfo `json:"build,omitempty"`
+}
+
+// DelegationAssertion is one link of a delegation chain: Delegator vouches,
+// with its registered key, for Subject. In the last link Subject is the
+// service being registered, with PublicKey. In the links before, Subject is
+// the next link's delegator, which may register services matching Scope.
+type DelegationAssertion struct {
+	Delegator    string    `json:"delegator"`
+	Subject      string    `json:"subject"`
+	Scope        string    `json:"scope,omitempty"`      // path.Match pattern of service IDs; links before the last only
+	PublicKey    Base64URL `json:"public_key,omitempty"` // last link only
+	Algorithm    string    `json:"algorithm,omitempty"`  // of PublicKey; empty means dilithium3
+	IssuedAt     time.Time `json:"issued_at"`
+	ExpiresAt    time.Time `json:"expires_at"`
+	SignatureAlg string    `json:"signature_alg,omitempty"` // of the delegator's key; empty means dilithium3
+	Signature    Base64URL `json:"signature"`
+}
+
+// Unsigned returns a without its signature. The signature covers the
+// canonical JSON encoding of the unsigned assertion.
+func (a DelegationAssertion) Unsigned() DelegationAssertion {
+	a.Signature = nil
+	return a
+}
+
+// WorkloadRegistration asks a delegating service, such as the gateway, to
+// register a workload's key on its behalf. Proof is the workload key's
+// signature over the canonical JSON encoding of the registration without
+// it, so only a holder of the key can have it registered.
+type WorkloadRegistration struct {
+	ServiceID string    `json:"service_id"`
+	PublicKey Base64URL `json:"public_key"`
+	Algorithm string    `json:"algorithm,omitempty"` // empty means dilithium3
+	Address   string    `json:"address,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+	Proof     Base64URL `json:"proof"`
+}
+
+// Unproven returns r without its proof of possession.
+func (r WorkloadRegistration) Unproven() WorkloadRegistration {
+	r.Proof = nil
+	return r
 }
 
 type ServiceRequest struct {
-	ServiceID string            `json:"service_id"`
-	Timestamp time.Time         `json:"timestamp"`
-	Data      json.RawMessage   `json:"data"`
-	Signature []byte            `json:"signature"`
-	Headers   map[string]string `json:"headers,omitempty"`
+	ProtocolVersion string            `json:"protocol_version,omitempty"`
+	ServiceID       string            `json:"service_id"`
+	RequestID       string            `json:"request_id,omitempty"`
+	ParentID        string            `json:"parent_id,omitempty"`
+	IdempotencyKey  string            `json:"idempotency_key,omitempty"` // retries with the same key are answered once
+	Timestamp       time.Time         `json:"timestamp"`
+	Data            json.RawMessage   `json:"data"`
+	SignatureAlg    string            `json:"signature_alg,omitempty"` // algorithm ID; empty means DILITHIUM3
+	Signature       []byte            `json:"signature"`
+	Chain           SignatureChain    `json:"signature_chain,omitempty"`
+	Headers         map[string]string `json:"headers,omitempty"`
 }
 
 type ServiceResponse struct {
-	ServiceID string            `json:"service_id"`
-	Timestamp time.Time         `json:"timestamp"`
-	Data      json.RawMessage   `json:"data"`
-	Signature []byte            `json:"signature"`
-	Headers   map[string]string `json:"headers,omitempty"`
-	Success   bool              `json:"success"`
-	Error     string            `json:"error,omitempty"`
+	ProtocolVersion string            `json:"protocol_version,omitempty"`
+	ServiceID       string            `json:"service_id"`
+	RequestID       string            `json:"request_id,omitempty"`
+	ParentID        string            `json:"parent_id,omitempty"`
+	Timestamp       time.Time         `json:"timestamp"`
+	Data            json.RawMessage   `json:"data"`
+	SignatureAlg    string            `json:"signature_alg,omitempty"` // algorithm ID; empty means DILITHIUM3
+	Signature       []byte            `json:"signature"`
+	Chain           SignatureChain    `json:"signature_chain,omitempty"`
+	Headers         map[string]string `json:"headers,omitempty"`
+	Success         bool              `json:"success"`
+	Error           string            `json:"error,omitempty"`
+}
+
+// SigningPayload returns the bytes covered by Signature: the envelope with
+// the signature itself and the signature chain cleared.
+func (r ServiceRequest) SigningPayload() ([]byte, error) {
+	r.Signature = nil
+	r.Chain = nil
+	return json.Marshal(r)
+}
+
+// WriteSigningPayload appends SigningPayload to buf, for callers that
+// encode into pooled buffers. A request's signature chain covers the same
+// bytes, so it is also the ChainPayload.
+func (r ServiceRequest) WriteSigningPayload(buf *bytes.Buffer) error {
+	r.Signature = nil
+	r.Chain = nil
+	return encodeTo(buf, r.wire())
+}
+
+// SigningPayload returns the bytes covered by Signature. Before 1.2 only Data
+// was signed; from 1.2 the whole envelope is, so request_id and parent_id
+// cannot be altered in transit.
+func (r ServiceResponse) SigningPayload() ([]byte, error) {
+	if !ProtocolVersionAtLeast(r.ProtocolVersion, ProtocolVersion12) {
+		return r.Data, nil
+	}
+	r.Signature = nil
+	r.Chain = nil
+	return json.Marshal(r)
+}
+
+// WriteSigningPayload appends SigningPayload to buf, for callers that
+// encode into pooled buffers.
+func (r ServiceResponse) WriteSigningPayload(buf *bytes.Buffer) error {
+	if !ProtocolVersionAtLeast(r.ProtocolVersion, ProtocolVersion12) {
+		buf.Write(r.Data)
+		return nil
+	}
+	return r.WriteChainPayload(buf)
+}
+
+// WriteChainPayload appends ChainPayload to buf, for callers that encode
+// into pooled buffers. From 1.2 it is also the SigningPayload.
+func (r ServiceResponse) WriteChainPayload(buf *bytes.Buffer) error {
+	r.Signature = nil
+	r.Chain = nil
+	return encodeTo(buf, r.wire())
 }
 
 type AuthToken struct {
-	ServiceID string    `json:"service_id"`
-	IssuedAt  time.Time `json:"issued_at"`
-	ExpiresAt time.Time `json:"expires_at"`
-	Signature []byte    `json:"signature"`
+	ProtocolVersion string    `json:"protocol_version,omitempty"`
+	ServiceID       string    `json:"service_id"`
+	IssuedAt        time.Time `json:"issued_at"`
+	ExpiresAt       time.Time `json:"expires_at"`
+	Signature       []byte    `json:"signature"`
 }
 
+// KeyExchangeRequest travels in an envelope signed by ServiceID, which
+// authenticates it; Timestamp and the signature fields are left empty.
 type KeyExchangeRequest struct {
-	ServiceID      string    `json:"service_id"`
-	KyberPublicKey []byte    `json:"kyber_public_key"`
-	Timestamp      time.Time `json:"timestamp"`
-	Signature      []byte    `json:"signature"`
+	ProtocolVersion string    `json:"protocol_version,omitempty"`
+	ServiceID       string    `json:"service_id"`
+	KyberPublicKey  []byte    `json:"kyber_public_key"`
+	KEMAlgorithm    string    `json:"kem_algorithm,omitempty"` // of KyberPublicKey; empty means kyber768
+	Timestamp       time.Time `json:"timestamp"`
+	SignatureAlg    string    `json:"signature_alg,omitempty"`
+	Signature       []byte    `json:"signature"`
+
+	// PeerID, if set, asks the auth service to broker a session with that
+	// service instead: the response carries a SessionTicket.
+	PeerID string `json:"peer_id,omitempty"`
 }
 
+// SigningPayload returns the bytes covered by Signature. protocol_version,
+// kem_algorithm, signature_alg and peer_id are only included when set, so
+// 1.0 peers compute the same payload.
+func (r *KeyExchangeRequest) SigningPayload() ([]byte, error) {
+	payload := map[string]interface{}{
+		"service_id":       r.ServiceID,
+		"kyber_public_key": r.KyberPublicKey,
+		"timestamp":        r.Timestamp,
+	}
+	if r.ProtocolVersion != "" {
+		payload["protocol_version"] = r.ProtocolVersion
+	}
+	if r.KEMAlgorithm != "" {
+		payload["kem_algorithm"] = r.KEMAlgorithm
+	}
+	if r.SignatureAlg != "" {
+		payload["signature_alg"] = r.SignatureAlg
+	}
+	if r.PeerID != "" {
+		payload["peer_id"] = r.PeerID
+	}
+	return json.Marshal(payload)
+}
+
+// KeyExchangeResponse travels in the auth service's signed response
+// envelope; Signature is left empty.
 type KeyExchangeResponse struct {
-	Ciphertext   []byte    `json:"ciphertext"`
-	SharedSecret []byte    `json:"shared_secret,omitempty"`
-	Timestamp    time.Time `json:"timestamp"`
-	Signature    []byte    `json:"signature"`
+	ProtocolVersion string    `json:"protocol_version,omitempty"`
+	Ciphertext      []byte    `json:"ciphertext"`
+	SharedSecret    []byte    `json:"shared_secret,omitempty"`
+	Timestamp       time.Time `json:"timestamp"`
+	Signature       []byte    `json:"signature"`
+
+	// KEMAlgorithm is the KEM Ciphertext was encapsulated with, empty
+	// meaning kyber768, and KEMAlgorithms all those the auth service serves,
+	// so services can tell which they may migrate their keys to.
+	KEMAlgorithm  string   `json:"kem_algorithm,omitempty"`
+	KEMAlgorithms []string `json:"kem_algorithms,omitempty"`
+
+	// Ticket answers a request naming a PeerID, in place of Ciphertext.
+	Ticket *SessionTicket `json:"ticket,omitempty"`
+}
+
+// SessionTicket is a session between Client and Peer brokered by its
+// issuer, the auth service, and valid from IssuedAt until ExpiresAt. The
+// session key is sealed to each party's Kyber key, so the client passes the
+// ticket on to the peer and both hold the key after one round trip to the
+// auth service. The signature covers the canonical JSON encoding of the
+// unsigned ticket.
+type SessionTicket struct {
+	TicketID  string           `json:"ticket_id"`
+	Issuer    string           `json:"issuer"`
+	Client    string           `json:"client"`
+	Peer      string           `json:"peer"`
+	IssuedAt  time.Time        `json:"issued_at"`
+	ExpiresAt time.Time        `json:"expires_at"`
+	ClientKey SealedSessionKey `json:"client_key"`
+	PeerKey   SealedSessionKey `json:"peer_key"`
+	Algorithm string           `json:"algorithm,omitempty"` // of the issuer's key; empty means dilithium3
+	Signature Base64URL        `json:"signature"`
+}
+
+// Unsigned returns t without its signature.
+func (t SessionTicket) Unsigned() SessionTicket {
+	t.Signature = nil
+	return t
+}
+
+// SealedSessionKey is a session key sealed to one party: a Kyber
+// ciphertext for its key, and the session key encrypted under the shared
+// secret with AES-256-GCM.
+type SealedSessionKey struct {
+	Ciphertext Base64URL `json:"ciphertext"`
+	Nonce      Base64URL `json:"nonce"`
+	SealedKey  Base64URL `json:"sealed_key"`
+}
+
+// KeyRotationRequest replaces a service's registered key. It travels in an
+// envelope signed with the current key; Proof is the new key's signature
+// over ProofPayload, showing the caller holds the new private key too.
+type KeyRotationRequest struct {
+	ServiceID    string    `json:"service_id"`
+	NewPublicKey Base64URL `json:"new_public_key"`
+	Algorithm    string    `json:"algorithm,omitempty"` // of NewPublicKey; empty means dilithium3
+	Proof        Base64URL `json:"proof,omitempty"`
+
+	// Record, if set, is the service's own signed statement of the rotation,
+	// which the auth service keeps and serves so that peers that pinned the
+	// old key can follow it. Administrator rotations carry none.
+	Record *KeyRotationRecord `json:"record,omitempty"`
+}
+
+// ProofPayload returns the bytes covered by Proof.
+func (r KeyRotationRequest) ProofPayload() ([]byte, error) {
+	r.Proof = nil
+	return json.Marshal(r)
+}
+
+// KeyRotationRecord is a service's statement, signed with its old key, that
+// NewPublicKey replaces OldPublicKey. Unlike the registry's word, it cannot
+// be forged without the old private key.
+type KeyRotationRecord struct {
+	ServiceID    string    `json:"service_id"`
+	OldPublicKey Base64URL `json:"old_public_key"`
+	OldAlgorithm string    `json:"old_algorithm,omitempty"` // empty means dilithium3
+	NewPublicKey Base64URL `json:"new_public_key"`
+	Algorithm    string    `json:"algorithm,omitempty"` // of NewPublicKey; empty means dilithium3
+	RotatedAt    time.Time `json:"rotated_at"`
+	Signature    Base64URL `json:"signature"`
+}
+
+// SigningPayload returns the bytes covered by Signature.
+func (r KeyRotationRecord) SigningPayload() ([]byte, error) {
+	r.Signature = nil
+	return json.Marshal(r)
+}
+
+// KeyMigrationRequest moves a service's keys to other algorithms in one
+// step: its signing key, rotated as by KeyRotationRequest, and the KEM key
+// sessions are sealed to. Either may be left as it is. It travels in an
+// envelope signed with the current key, or by an administrator.
+type KeyMigrationRequest struct {
+	ServiceID    string              `json:"service_id"`
+	Rotation     *KeyRotationRequest `json:"rotation,omitempty"`       // new signing key; nil keeps the current one
+	KEMPublicKey Base64URL           `json:"kem_public_key,omitempty"` // new KEM key; empty keeps the current one
+	KEMAlgorithm string              `json:"kem_algorithm,omitempty"`  // of KEMPublicKey; empty means kyber768
+
+	// Rollback marks a migration back to the keys an earlier one replaced.
+	Rollback bool `json:"rollback,omitempty"`
+}
+
+// RevocationRequest removes a service from the registry. It travels in an
+// envelope signed with the key being revoked.
+type RevocationRequest struct {
+	ServiceID string `json:"service_id"`
+}
+
+// ClusterStatus is an auth instance's view of its cluster, which clustered
+// instances poll from each other to elect a leader.
+type ClusterStatus struct {
+	Node      string    `json:"node"`             // base URL of the instance
+	Leader    string    `json:"leader,omitempty"` // empty while no leader is elected
+	Live      []string  `json:"live"`             // instances it heard from recently, itself included
+	Term      uint64    `json:"term"`
+	Version   uint64    `json:"version"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// RegistrySnapshot is the auth service's registry as followers copy it from
+// the leader. Term counts elections and Version counts changes: a snapshot
+// is newer than another if its term is, or if its term is the same and its
+// version is.
+type RegistrySnapshot struct {
+	Term     uint64              `json:"term"`
+	Version  uint64              `json:"version"`
+	Services []PublicKeyResponse `json:"services"`
+}
+
+// SignedRegistrySnapshot is the whole registry as its issuer, the auth
+// service, vouches for it from IssuedAt until ExpiresAt, so peers cut off
+// from the auth service can keep verifying each other until then. The
+// issuer's own entry in Registry holds the key that signed it.
+type SignedRegistrySnapshot struct {
+	Issuer    string           `json:"issuer"`
+	IssuedAt  time.Time        `json:"issued_at"`
+	ExpiresAt time.Time        `json:"expires_at"`
+	Registry  RegistrySnapshot `json:"registry"`
+	Algorithm string           `json:"algorithm,omitempty"` // of the issuer's key; empty means dilithium3
+	Signature Base64URL        `json:"signature"`
+}
+
+// Unsigned returns s without its signature. The signature covers the
+// canonical JSON encoding of the unsigned snapshot.
+func (s SignedRegistrySnapshot) Unsigned() SignedRegistrySnapshot {
+	s.Signature = nil
+	return s
+}
+
+// Service returns serviceID's entry in the snapshot.
+func (s *SignedRegistrySnapshot) Service(serviceID string) (*PublicKeyResponse, bool) {
+	for i := range s.Registry.Services {
+		if s.Registry.Services[i].ServiceID == serviceID {
+			return &s.Registry.Services[i], true
+		}
+	}
+	return nil, false
+}
+
+// ProcessingRecord is a service's signed account of processing a request:
+// digests of the request's data and of its result, each the hex SHA-256 of
+// the bytes. Records are numbered and each names the Hash of the one before
+// it, so none can be changed, dropped or reordered without breaking the
+// chain. KeyID names the key that signed it.
+type ProcessingRecord struct {
+	Sequence    uint64    `json:"sequence"`
+	Service     string    `json:"service"`
+	RequestID   string    `json:"request_id"`
+	Caller      string    `json:"caller"`
+	Path        string    `json:"path"`
+	RequestHash string    `json:"request_hash"`
+	ResultHash  string    `json:"result_hash"`
+	ProcessedAt time.Time `json:"processed_at"`
+	PrevHash    string    `json:"prev_hash"` // empty for the first record
+	Hash        string    `json:"hash"`
+	KeyID       string    `json:"key_id"`
+	Algorithm   string    `json:"algorithm,omitempty"` // of the signing key; empty means dilithium3
+	Signature   Base64URL `json:"signature"`
+}
+
+// Unhashed returns r without its hash and signature. Hash is the hex
+// SHA-256 of its canonical JSON encoding.
+func (r ProcessingRecord) Unhashed() ProcessingRecord {
+	r.Hash = ""
+	r.Signature = nil
+	return r
+}
+
+// Unsigned returns r without its signature. The signature covers the
+// canonical JSON encoding of the unsigned record.
+func (r ProcessingRecord) Unsigned() ProcessingRecord {
+	r.Signature = nil
+	return r
+}
+
+// ContentDigest stands in for streamed content where a signature would
+// cover it: a content signature is a detached JWS over its canonical JSON.
+// SHA256 is the hex digest of the content's bytes.
+type ContentDigest struct {
+	SHA256      string `json:"sha256"`
+	Size        int64  `json:"size"`
+	ContentType string `json:"content_type,omitempty"`
+}
+
+// Attestation is a service's signed statement about an artifact, such as a
+// build output or an SBOM, kept beside it rather than wrapping it: the
+// artifact's name and digest, what kind of statement it is, and who signed
+// it, with which key and when. The signature covers the canonical JSON
+// encoding of the unsigned attestation.
+type Attestation struct {
+	Subject       string        `json:"subject"` // file name of the artifact
+	Digest        ContentDigest `json:"digest"`
+	PredicateType string        `json:"predicate_type,omitempty"` // e.g. https://spdx.dev/Document for an SPDX SBOM
+	Signer        string        `json:"signer"`
+	KeyID         string        `json:"key_id"`
+	Algorithm     string        `json:"algorithm,omitempty"` // of the signer's key; empty means dilithium3
+	IssuedAt      time.Time     `json:"issued_at"`
+	Signature     Base64URL     `json:"signature"`
+}
+
+// Unsigned returns a without its signature.
+func (a Attestation) Unsigned() Attestation {
+	a.Signature = nil
+	return a
+}
+
+// ContentResult is the Data of a response to streamed content: the digest
+// of the content received, so the sender can check it arrived intact, and
+// the handler's result.
+type ContentResult struct {
+	Content ContentDigest   `json:"content"`
+	Result  json.RawMessage `json:"result"`
+}
+
+// Page is the Data of one page of a paginated result set. Each page is a
+// response of its own, so its signature covers only its Items; the last
+// page, which has no NextCursor, carries Digest, the hex SHA-256 over the
+// canonical JSON of every item of the set in order, one per line, so a
+// client that read all pages can tell it missed none.
+type Page struct {
+	Items      json.RawMessage `json:"items"`
+	Page       int             `json:"page"`
+	Total      int             `json:"total"`
+	NextCursor string          `json:"next_cursor,omitempty"`
+	Digest     string          `json:"digest,omitempty"`
+}
+
+// ServiceListing is one item of a paginated service listing.
+type ServiceListing struct {
+	ServiceID   string     `json:"service_id"`
+	DelegatedBy []string   `json:"delegated_by,omitempty"`
+	Build       *BuildInfo `json:"build,omitempty"`
+}
+
+// BuildInfo describes the build a mesh binary runs: its version, the
+// commit and date it was built from, and the algorithms it uses and
+// supports. Services answer it on GET /version and report it when they
+// register.
+type BuildInfo struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	BuildDate string `json:"build_date"`
+	GoVersion string `json:"go_version"`
+
+	Signature  string   `json:"signature,omitempty"` // configured signature algorithm
+	KEM        string   `json:"kem,omitempty"`       // configured KEM
+	Algorithms []string `json:"algorithms,omitempty"`
 }
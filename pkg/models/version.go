@@ -0,0 +1,95 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Protocol versions understood by this build, newest first. Version 1.0 is the
+// original envelope, which never carried a protocol_version field; it is kept
+// so that services from before versioning can still verify our signatures.
+//
+// 1.1 adds protocol_version. 1.2 adds request_id/parent_id and moves the
+// ServiceResponse signature from Data alone to the whole envelope. 1.3
+// encodes binary fields as unpadded base64url and types /public-key Data as
+// a PublicKeyResponse.
+const (
+	ProtocolVersion10      = "1.0"
+	ProtocolVersion11      = "1.1"
+	ProtocolVersion12      = "1.2"
+	ProtocolVersion13      = "1.3"
+	CurrentProtocolVersion = ProtocolVersion13
+)
+
+var SupportedProtocolVersions = []string{ProtocolVersion13, ProtocolVersion12, ProtocolVersion11, ProtocolVersion10}
+
+// NormalizeProtocolVersion maps the empty wire value to the legacy version.
+func NormalizeProtocolVersion(version string) string {
+	if version == "" {
+		return ProtocolVersion10
+	}
+	return version
+}
+
+// WireProtocolVersion returns the value to place in an envelope's
+// protocol_version field. Legacy peers drop unknown fields before
+// re-marshaling for verification, so 1.0 must be sent as the empty string.
+func WireProtocolVersion(version string) string {
+	if NormalizeProtocolVersion(version) == ProtocolVersion10 {
+		return ""
+	}
+	return version
+}
+
+func IsSupportedProtocolVersion(version string) bool {
+	version = NormalizeProtocolVersion(version)
+	for _, supported := range SupportedProtocolVersions {
+		if supported == version {
+			return true
+		}
+	}
+	return false
+}
+
+// ProtocolVersionAtLeast reports whether version is min or newer, ordering
+// versions by their position in SupportedProtocolVersions.
+func ProtocolVersionAtLeast(version, min string) bool {
+	return protocolVersionRank(NormalizeProtocolVersion(version)) >= protocolVersionRank(min)
+}
+
+func protocolVersionRank(version string) int {
+	for i, supported := range SupportedProtocolVersions {
+		if supported == version {
+			return len(SupportedProtocolVersions) - i
+		}
+	}
+	return 0
+}
+
+// AcceptVersionHeader is the value services send in X-Mesh-Accept-Version.
+func AcceptVersionHeader() string {
+	return strings.Join(SupportedProtocolVersions, ", ")
+}
+
+// NegotiateProtocolVersion picks the newest version present in both the
+// caller's Accept-Version list and SupportedProtocolVersions. Callers that
+// send no header predate versioning and are answered with 1.0.
+func NegotiateProtocolVersion(accept string) (string, error) {
+	if strings.TrimSpace(accept) == "" {
+		return ProtocolVersion10, nil
+	}
+
+	offered := make(map[string]bool)
+	for _, v := range strings.Split(accept, ",") {
+		offered[strings.TrimSpace(v)] = true
+	}
+
+	for _, supported := range SupportedProtocolVersions {
+		if offered[supported] {
+			return supported, nil
+		}
+	}
+
+	return "", fmt.Errorf("no common protocol version: offered %q, supported %q",
+		accept, AcceptVersionHeader())
+}
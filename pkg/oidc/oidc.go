@@ -0,0 +1,225 @@
+package oidc
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// jwksRefreshInterval bounds how often an unknown key ID makes the
+// verifier fetch the issuer's keys again.
+const jwksRefreshInterval = time.Minute
+
+// Verifier checks RS256 and ES256 JWTs against the keys an issuer publishes
+// through OIDC discovery.
+type Verifier struct {
+	issuer string
+	client *http.Client
+
+	mutex   sync.Mutex
+	keys    map[string]crypto.PublicKey
+	fetched time.Time
+}
+
+func NewVerifier(issuer string) *Verifier {
+	return &Verifier{
+		issuer: issuer,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Issuer returns the issuer tokens must name.
+func (v *Verifier) Issuer() string {
+	return v.issuer
+}
+
+// Claims are the registered claims Verify checks.
+type Claims struct {
+	Issuer    string          `json:"iss"`
+	Subject   string          `json:"sub"`
+	Audience  json.RawMessage `json:"aud"`
+	ExpiresAt int64           `json:"exp"`
+	NotBefore int64           `json:"nbf"`
+}
+
+// Audiences decodes aud, which is a string or an array of them.
+func (c *Claims) Audiences() []string {
+	var audiences []string
+	if json.Unmarshal(c.Audience, &audiences) == nil {
+		return audiences
+	}
+	var audience string
+	json.Unmarshal(c.Audience, &audience)
+	return []string{audience}
+}
+
+// Verify checks token is signed by the issuer, names it, is valid for
+// audience and has not expired, then decodes its claims into claims, if
+// set, for the caller to check the rest. It returns the registered claims.
+func (v *Verifier) Verify(token, audience string, claims interface{}) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("malformed token")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("malformed token header: %w", err)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("malformed token signature: %w", err)
+	}
+
+	key, err := v.key(header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		if header.Alg != "RS256" || rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature) != nil {
+			return nil, fmt.Errorf("token signature verification failed")
+		}
+	case *ecdsa.PublicKey:
+		if header.Alg != "ES256" || len(signature) != 64 ||
+			!ecdsa.Verify(key, digest[:], new(big.Int).SetBytes(signature[:32]), new(big.Int).SetBytes(signature[32:])) {
+			return nil, fmt.Errorf("token signature verification failed")
+		}
+	default:
+		return nil, fmt.Errorf("unsupported key type for key %q", header.Kid)
+	}
+
+	var registered Claims
+	if err := decodeSegment(parts[1], &registered); err != nil {
+		return nil, fmt.Errorf("malformed token claims: %w", err)
+	}
+	now := time.Now().Unix()
+	switch {
+	case registered.Issuer != v.issuer:
+		return nil, fmt.Errorf("token issued by %q, not %q", registered.Issuer, v.issuer)
+	case !slices.Contains(registered.Audiences(), audience):
+		return nil, fmt.Errorf("token is not valid for audience %s", audience)
+	case registered.ExpiresAt == 0 || now >= registered.ExpiresAt:
+		return nil, fmt.Errorf("token expired")
+	case registered.NotBefore != 0 && now < registered.NotBefore:
+		return nil, fmt.Errorf("token not valid yet")
+	}
+	if claims != nil {
+		if err := decodeSegment(parts[1], claims); err != nil {
+			return nil, fmt.Errorf("malformed token claims: %w", err)
+		}
+	}
+	return &registered, nil
+}
+
+// key returns the issuer's key kid, fetching the key set again if it is
+// unknown and the last fetch was long enough ago, e.g. after the issuer
+// rotated its keys.
+func (v *Verifier) key(kid string) (crypto.PublicKey, error) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	if key, exists := v.keys[kid]; exists {
+		return key, nil
+	}
+	if time.Since(v.fetched) < jwksRefreshInterval {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+
+	v.fetched = time.Now()
+	keys, err := v.fetchKeys()
+	if err != nil {
+		return nil, err
+	}
+	v.keys = keys
+	if key, exists := v.keys[kid]; exists {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown signing key %q", kid)
+}
+
+func (v *Verifier) fetchKeys() (map[string]crypto.PublicKey, error) {
+	var discovery struct {
+		Issuer  string `json:"issuer"`
+		JWKSURI string `json:"jwks_uri"`
+	}
+	if err := v.getJSON(strings.TrimSuffix(v.issuer, "/")+"/.well-known/openid-configuration", &discovery); err != nil {
+		return nil, err
+	}
+	if discovery.Issuer != v.issuer || discovery.JWKSURI == "" {
+		return nil, fmt.Errorf("OIDC discovery document of %s names issuer %q", v.issuer, discovery.Issuer)
+	}
+
+	var jwks struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Crv string `json:"crv"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		} `json:"keys"`
+	}
+	if err := v.getJSON(discovery.JWKSURI, &jwks); err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]crypto.PublicKey)
+	for _, jwk := range jwks.Keys {
+		switch {
+		case jwk.Kty == "RSA":
+			n, errN := base64.RawURLEncoding.DecodeString(jwk.N)
+			e, errE := base64.RawURLEncoding.DecodeString(jwk.E)
+			if errN != nil || errE != nil {
+				continue
+			}
+			keys[jwk.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		case jwk.Kty == "EC" && jwk.Crv == "P-256":
+			x, errX := base64.RawURLEncoding.DecodeString(jwk.X)
+			y, errY := base64.RawURLEncoding.DecodeString(jwk.Y)
+			if errX != nil || errY != nil {
+				continue
+			}
+			keys[jwk.Kid] = &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		}
+	}
+	return keys, nil
+}
+
+func (v *Verifier) getJSON(url string, out interface{}) error {
+	resp, err := v.client.Get(url)
+	if err != nil {
+		return fmt.Errorf("failed to fetch %s: %w", url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to fetch %s: status %d", url, resp.StatusCode)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode %s: %w", url, err)
+	}
+	return nil
+}
+
+func decodeSegment(segment string, out interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, out)
+}
@@ -0,0 +1,472 @@
+// Package oqsinterop checks the mesh's post-quantum keys, signatures and
+// KEM ciphertexts against OpenSSL with the oqs-provider, in both
+// directions: openssl reads and verifies what the mesh writes and signs,
+// and the mesh reads, verifies and decapsulates what openssl produces. It
+// runs the openssl command itself, so a divergence between the mesh's
+// oqs key format and the tooling deployments use shows up as a failed
+// check rather than an unreadable key in production.
+package oqsinterop
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"quantum-safe-mesh/pkg/pqc"
+)
+
+// DefaultProvider is the name OpenSSL loads the oqs-provider by.
+const DefaultProvider = "oqsprovider"
+
+// ErrNoKEMCommands is why KEM ciphertext checks are skipped: openssl
+// pkeyutl only encapsulates and decapsulates from OpenSSL 3.5.
+var ErrNoKEMCommands = errors.New("openssl pkeyutl has no -encap and -decap (OpenSSL 3.5 or later)")
+
+// OpenSSL runs an openssl command with the oqs-provider loaded.
+type OpenSSL struct {
+	Path       string // openssl binary; "openssl" if empty
+	Provider   string // DefaultProvider if empty
+	ModulePath string // directory holding the provider; OPENSSL_MODULES or the build's default if empty
+}
+
+// Check reports whether openssl runs and loads the provider.
+func (o OpenSSL) Check(ctx context.Context) error {
+	if _, err := o.run(ctx, "", "list", "-providers"); err != nil {
+		return fmt.Errorf("%s cannot load the oqs-provider: %w", o.path(), err)
+	}
+	return nil
+}
+
+// hasKEMCommands reports whether openssl pkeyutl can encapsulate and
+// decapsulate.
+func (o OpenSSL) hasKEMCommands(ctx context.Context) bool {
+	// pkeyutl -help exits non-zero on some releases, but still lists the
+	// options.
+	out, _ := exec.CommandContext(ctx, o.path(), "pkeyutl", "-help").CombinedOutput()
+	return bytes.Contains(out, []byte("-encap")) && bytes.Contains(out, []byte("-decap"))
+}
+
+// run runs the openssl command in dir with the provider loaded after the
+// command name.
+func (o OpenSSL) run(ctx context.Context, dir, command string, args ...string) ([]byte, error) {
+	provider := o.Provider
+	if provider == "" {
+		provider = DefaultProvider
+	}
+	var providerArgs []string
+	if o.ModulePath != "" {
+		providerArgs = append(providerArgs, "-provider-path", o.ModulePath)
+	}
+	providerArgs = append(providerArgs, "-provider", provider, "-provider", "default")
+
+	cmd := exec.CommandContext(ctx, o.path(), append(append([]string{command}, providerArgs...), args...)...)
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return out, fmt.Errorf("openssl %s %s failed: %w: %s", command, strings.Join(args, " "), err, bytes.TrimSpace(out))
+	}
+	return out, nil
+}
+
+func (o OpenSSL) path() string {
+	if o.Path == "" {
+		return "openssl"
+	}
+	return o.Path
+}
+
+// Algorithms returns the algorithms whose keys have an oqs-provider
+// format: those the checks can run for.
+func Algorithms() []string {
+	var algorithms []string
+	for _, algorithm := range append(slices.Clone(pqc.SignatureAlgorithms), pqc.KEMAlgorithms...) {
+		if pqc.HasOQSFormat(algorithm) {
+			algorithms = append(algorithms, algorithm)
+		}
+	}
+	return algorithms
+}
+
+// Result is the outcome of one check.
+type Result struct {
+	Algorithm string
+	Check     string
+	Err       error // nil if the check passed or was skipped
+	Skipped   error // why the check did not run
+}
+
+// Run runs every check for each of algorithms with openssl, in a temporary
+// directory it removes afterwards.
+func Run(ctx context.Context, openssl OpenSSL, algorithms []string) ([]Result, error) {
+	for _, algorithm := range algorithms {
+		if !pqc.HasOQSFormat(algorithm) {
+			return nil, fmt.Errorf("%s keys have no oqs-provider format", algorithm)
+		}
+	}
+	if err := openssl.Check(ctx); err != nil {
+		return nil, err
+	}
+	dir, err := os.MkdirTemp("", "oqsinterop-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create work directory: %w", err)
+	}
+	defer os.RemoveAll(dir)
+
+	kem := openssl.hasKEMCommands(ctx)
+	var results []Result
+	for _, algorithm := range algorithms {
+		r := &runner{ctx: ctx, openssl: openssl, dir: filepath.Join(dir, algorithm), algorithm: algorithm}
+		if err := os.Mkdir(r.dir, 0700); err != nil {
+			return nil, fmt.Errorf("failed to create work directory: %w", err)
+		}
+
+		var checks []check
+		if slices.Contains(pqc.KEMAlgorithms, algorithm) {
+			checks = r.kemChecks(kem)
+		} else {
+			checks = r.signatureChecks()
+		}
+		for _, c := range checks {
+			result := Result{Algorithm: algorithm, Check: c.name}
+			if c.skip != nil {
+				result.Skipped = c.skip
+			} else {
+				result.Err = c.run()
+			}
+			results = append(results, result)
+		}
+	}
+	return results, nil
+}
+
+type check struct {
+	name string
+	run  func() error
+	skip error
+}
+
+// runner holds one algorithm's keys and files as its checks make them.
+type runner struct {
+	ctx       context.Context
+	openssl   OpenSSL
+	dir       string
+	algorithm string
+
+	meshPublic, meshPrivate       []byte
+	opensslPublic, opensslPrivate []byte
+}
+
+// Files in a runner's directory.
+const (
+	meshKeyFile       = "mesh.key"
+	meshPublicFile    = "mesh.pub"
+	opensslKeyFile    = "openssl.key"
+	opensslPublicFile = "openssl.pub"
+	messageFile       = "message"
+)
+
+func (r *runner) signatureChecks() []check {
+	return []check{
+		{name: "openssl reads the mesh's keys", run: r.opensslReadsMeshKeys},
+		{name: "openssl verifies the mesh's signature", run: r.opensslVerifiesMeshSignature},
+		{name: "the mesh reads openssl's keys", run: r.meshReadsOpenSSLKeys},
+		{name: "the mesh verifies openssl's signature", run: r.meshVerifiesOpenSSLSignature},
+	}
+}
+
+func (r *runner) kemChecks(kem bool) []check {
+	checks := []check{
+		{name: "openssl reads the mesh's keys", run: r.opensslReadsMeshKeys},
+		{name: "the mesh reads openssl's keys", run: r.meshReadsOpenSSLKeys},
+		{name: "openssl decapsulates the mesh's ciphertext", run: r.opensslDecapsulates},
+		{name: "the mesh decapsulates openssl's ciphertext", run: r.meshDecapsulates},
+	}
+	if !kem {
+		for i := 2; i < len(checks); i++ {
+			checks[i].skip = ErrNoKEMCommands
+		}
+	}
+	return checks
+}
+
+// meshKeys generates the mesh's key pair and writes it in the oqs format,
+// once.
+func (r *runner) meshKeys() error {
+	if r.meshPublic != nil {
+		return nil
+	}
+
+	var public, private []byte
+	if slices.Contains(pqc.KEMAlgorithms, r.algorithm) {
+		keyPair, err := pqc.GenerateKyberKeyPair()
+		if err != nil {
+			return err
+		}
+		public, private = keyPair.GetPublicKeyBytes(), keyPair.GetPrivateKeyBytes()
+	} else {
+		signer, err := pqc.GenerateSigner(r.algorithm)
+		if err != nil {
+			return err
+		}
+		public, private = signer.GetPublicKeyBytes(), signer.GetPrivateKeyBytes()
+	}
+
+	publicDER, err := pqc.MarshalOQSPublicKey(r.algorithm, public)
+	if err != nil {
+		return err
+	}
+	privateDER, err := pqc.MarshalOQSPrivateKey(r.algorithm, private)
+	if err != nil {
+		return err
+	}
+	if err := r.writePEM(meshPublicFile, "PUBLIC KEY", publicDER); err != nil {
+		return err
+	}
+	if err := r.writePEM(meshKeyFile, "PRIVATE KEY", privateDER); err != nil {
+		return err
+	}
+	r.meshPublic, r.meshPrivate = public, private
+	return nil
+}
+
+// opensslKeys has openssl generate a key pair and reads it back, once.
+func (r *runner) opensslKeys() error {
+	if r.opensslPublic != nil {
+		return nil
+	}
+
+	if _, err := r.openssl.run(r.ctx, r.dir, "genpkey", "-algorithm", r.algorithm, "-out", opensslKeyFile); err != nil {
+		return err
+	}
+	if _, err := r.openssl.run(r.ctx, r.dir, "pkey", "-in", opensslKeyFile, "-pubout", "-out", opensslPublicFile); err != nil {
+		return err
+	}
+
+	publicDER, err := r.readPEM(opensslPublicFile, "PUBLIC KEY")
+	if err != nil {
+		return err
+	}
+	algorithm, public, err := pqc.ParseOQSPublicKey(publicDER)
+	if err != nil {
+		return err
+	}
+	if algorithm != r.algorithm {
+		return fmt.Errorf("openssl's %s public key parsed as %s", r.algorithm, algorithm)
+	}
+	privateDER, err := r.readPEM(opensslKeyFile, "PRIVATE KEY")
+	if err != nil {
+		return err
+	}
+	if algorithm, r.opensslPrivate, err = pqc.ParseOQSPrivateKey(privateDER); err != nil {
+		return err
+	}
+	if algorithm != r.algorithm {
+		return fmt.Errorf("openssl's %s private key parsed as %s", r.algorithm, algorithm)
+	}
+	r.opensslPublic = public
+	return nil
+}
+
+// opensslReadsMeshKeys has openssl derive the public key from the mesh's
+// private key file and checks it matches the mesh's public key file byte
+// for byte.
+func (r *runner) opensslReadsMeshKeys() error {
+	if err := r.meshKeys(); err != nil {
+		return err
+	}
+	if _, err := r.openssl.run(r.ctx, r.dir, "pkey", "-in", meshKeyFile, "-pubout", "-out", "mesh-derived.pub"); err != nil {
+		return err
+	}
+	if _, err := r.openssl.run(r.ctx, r.dir, "pkey", "-pubin", "-in", meshPublicFile, "-noout"); err != nil {
+		return err
+	}
+
+	derived, err := os.ReadFile(filepath.Join(r.dir, "mesh-derived.pub"))
+	if err != nil {
+		return err
+	}
+	written, err := os.ReadFile(filepath.Join(r.dir, meshPublicFile))
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(derived, written) {
+		return fmt.Errorf("openssl derived a different public key from the mesh's private key")
+	}
+	return nil
+}
+
+func (r *runner) opensslVerifiesMeshSignature() error {
+	if err := r.meshKeys(); err != nil {
+		return err
+	}
+	message, err := r.message()
+	if err != nil {
+		return err
+	}
+	signer, err := pqc.LoadSigner(r.algorithm, r.meshPublic, r.meshPrivate)
+	if err != nil {
+		return err
+	}
+	signature, err := signer.Sign(message)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(r.dir, "mesh.sig"), signature, 0600); err != nil {
+		return err
+	}
+
+	_, err = r.openssl.run(r.ctx, r.dir, "pkeyutl", "-verify", "-rawin", "-pubin", "-inkey", meshPublicFile, "-in", messageFile, "-sigfile", "mesh.sig")
+	return err
+}
+
+// meshReadsOpenSSLKeys parses openssl's key files and checks they are one
+// key pair: a signature made with the private key verifies with the public
+// key, or a ciphertext to the public key decapsulates with the private key.
+func (r *runner) meshReadsOpenSSLKeys() error {
+	if err := r.opensslKeys(); err != nil {
+		return err
+	}
+
+	if slices.Contains(pqc.KEMAlgorithms, r.algorithm) {
+		keyPair, err := pqc.LoadKyberKeyPair(r.opensslPublic, r.opensslPrivate)
+		if err != nil {
+			return err
+		}
+		ciphertext, secret, err := pqc.EncapsulateWithPublicKey(r.opensslPublic)
+		if err != nil {
+			return err
+		}
+		decapsulated, err := keyPair.Decapsulate(ciphertext)
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(secret, decapsulated) {
+			return fmt.Errorf("openssl's private key does not decapsulate ciphertexts to its public key")
+		}
+		return nil
+	}
+
+	signer, err := pqc.LoadSigner(r.algorithm, r.opensslPublic, r.opensslPrivate)
+	if err != nil {
+		return err
+	}
+	signature, err := signer.Sign([]byte("key pair check"))
+	if err != nil {
+		return err
+	}
+	if err := pqc.VerifySignature(r.algorithm, r.opensslPublic, []byte("key pair check"), signature); err != nil {
+		return fmt.Errorf("openssl's private key does not sign for its public key: %w", err)
+	}
+	return nil
+}
+
+func (r *runner) meshVerifiesOpenSSLSignature() error {
+	if err := r.opensslKeys(); err != nil {
+		return err
+	}
+	message, err := r.message()
+	if err != nil {
+		return err
+	}
+	if _, err := r.openssl.run(r.ctx, r.dir, "pkeyutl", "-sign", "-rawin", "-inkey", opensslKeyFile, "-in", messageFile, "-out", "openssl.sig"); err != nil {
+		return err
+	}
+
+	signature, err := os.ReadFile(filepath.Join(r.dir, "openssl.sig"))
+	if err != nil {
+		return err
+	}
+	return pqc.VerifySignature(r.algorithm, r.opensslPublic, message, signature)
+}
+
+func (r *runner) opensslDecapsulates() error {
+	if err := r.opensslKeys(); err != nil {
+		return err
+	}
+	ciphertext, secret, err := pqc.EncapsulateWithPublicKey(r.opensslPublic)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(r.dir, "mesh.ct"), ciphertext, 0600); err != nil {
+		return err
+	}
+	if _, err := r.openssl.run(r.ctx, r.dir, "pkeyutl", "-decap", "-inkey", opensslKeyFile, "-in", "mesh.ct", "-out", "openssl-decap.secret"); err != nil {
+		return err
+	}
+
+	decapsulated, err := os.ReadFile(filepath.Join(r.dir, "openssl-decap.secret"))
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(secret, decapsulated) {
+		return fmt.Errorf("openssl decapsulated a different shared secret")
+	}
+	return nil
+}
+
+func (r *runner) meshDecapsulates() error {
+	if err := r.meshKeys(); err != nil {
+		return err
+	}
+	if _, err := r.openssl.run(r.ctx, r.dir, "pkeyutl", "-encap", "-pubin", "-inkey", meshPublicFile, "-out", "openssl.ct", "-secret", "openssl-encap.secret"); err != nil {
+		return err
+	}
+
+	ciphertext, err := os.ReadFile(filepath.Join(r.dir, "openssl.ct"))
+	if err != nil {
+		return err
+	}
+	secret, err := os.ReadFile(filepath.Join(r.dir, "openssl-encap.secret"))
+	if err != nil {
+		return err
+	}
+	keyPair, err := pqc.LoadKyberKeyPair(r.meshPublic, r.meshPrivate)
+	if err != nil {
+		return err
+	}
+	decapsulated, err := keyPair.Decapsulate(ciphertext)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(secret, decapsulated) {
+		return fmt.Errorf("the mesh decapsulated a different shared secret")
+	}
+	return nil
+}
+
+// message writes a random message for both sides to sign, once.
+func (r *runner) message() ([]byte, error) {
+	path := filepath.Join(r.dir, messageFile)
+	if message, err := os.ReadFile(path); err == nil {
+		return message, nil
+	}
+	message := make([]byte, 1024)
+	if _, err := rand.Read(message); err != nil {
+		return nil, err
+	}
+	return message, os.WriteFile(path, message, 0600)
+}
+
+func (r *runner) writePEM(name, blockType string, der []byte) error {
+	return os.WriteFile(filepath.Join(r.dir, name), pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0600)
+}
+
+func (r *runner) readPEM(name, blockType string) ([]byte, error) {
+	data, err := os.ReadFile(filepath.Join(r.dir, name))
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != blockType {
+		return nil, fmt.Errorf("%s is not a %s PEM file", name, blockType)
+	}
+	return block.Bytes, nil
+}
@@ -0,0 +1,76 @@
+package oqsinterop
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"testing"
+	"time"
+)
+
+// testOpenSSL returns the openssl to check against: OQS_OPENSSL, or openssl
+// on the PATH, with the provider found through OPENSSL_MODULES.
+func testOpenSSL(t *testing.T) OpenSSL {
+	t.Helper()
+	path := os.Getenv("OQS_OPENSSL")
+	if path == "" {
+		var err error
+		if path, err = exec.LookPath("openssl"); err != nil {
+			t.Skip("openssl is not installed")
+		}
+	}
+	return OpenSSL{Path: path}
+}
+
+// TestInterop runs every check against the local openssl when it has the
+// oqs-provider, e.g. in CI with OPENSSL_MODULES pointing at a build of it.
+func TestInterop(t *testing.T) {
+	openssl := testOpenSSL(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+	if err := openssl.Check(ctx); err != nil {
+		t.Skipf("%v", err)
+	}
+
+	results, err := Run(ctx, openssl, Algorithms())
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if len(results) == 0 {
+		t.Fatal("Run ran no checks")
+	}
+	for _, result := range results {
+		switch {
+		case result.Skipped != nil:
+			if !errors.Is(result.Skipped, ErrNoKEMCommands) {
+				t.Errorf("%s: %s skipped for an unexpected reason: %v", result.Algorithm, result.Check, result.Skipped)
+			}
+			t.Logf("%s: %s skipped: %v", result.Algorithm, result.Check, result.Skipped)
+		case result.Err != nil:
+			t.Errorf("%s: %s: %v", result.Algorithm, result.Check, result.Err)
+		}
+	}
+}
+
+// TestMissingProvider checks a provider openssl cannot load fails Run
+// instead of passing with nothing checked.
+func TestMissingProvider(t *testing.T) {
+	openssl := testOpenSSL(t)
+	openssl.Provider = "no-such-provider"
+	openssl.ModulePath = t.TempDir()
+
+	if _, err := Run(context.Background(), openssl, Algorithms()); err == nil {
+		t.Fatal("Run succeeded without the provider")
+	}
+}
+
+func TestAlgorithms(t *testing.T) {
+	algorithms := Algorithms()
+	if len(algorithms) == 0 {
+		t.Fatal("no algorithms have an oqs-provider format")
+	}
+	if _, err := Run(context.Background(), OpenSSL{Path: "/nonexistent/openssl"}, algorithms); err == nil {
+		t.Fatal("Run succeeded without openssl")
+	}
+}
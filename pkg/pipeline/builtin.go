@@ -0,0 +1,47 @@
+package pipeline
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+
+	"quantum-safe-mesh/pkg/models"
+	"quantum-safe-mesh/pkg/pqc"
+)
+
+// ResultSignatureField is where sign-result puts its signature.
+const ResultSignatureField = "result_signature"
+
+func init() {
+	Register(Validate, "json-object", requireObject)
+	Register(Sign, "sign-result", signResult)
+}
+
+// requireObject refuses requests whose data is not a JSON object.
+func requireObject(doc *Document) error {
+	if doc.Input == nil {
+		return models.NewError(http.StatusBadRequest, models.ErrCodeInvalidRequest, "Request data must be a JSON object")
+	}
+	return nil
+}
+
+// signResult signs the canonical JSON of the result with the service's key
+// and adds the signature to it, so the result can be checked on its own
+// once it is stored apart from the response that carried it. To verify,
+// remove ResultSignatureField and check the rest with pqc.VerifyCanonical.
+func signResult(doc *Document) error {
+	payload, err := pqc.CanonicalJSON(doc.Result)
+	if err != nil {
+		return err
+	}
+	signature, err := doc.Service.Signer.Sign(payload)
+	if err != nil {
+		return fmt.Errorf("failed to sign result: %w", err)
+	}
+	doc.Result[ResultSignatureField] = map[string]interface{}{
+		"key_id":    doc.Service.KeyID(),
+		"alg":       pqc.JWSAlg(doc.Service.Signer.Algorithm()),
+		"signature": base64.StdEncoding.EncodeToString(signature),
+	}
+	return nil
+}
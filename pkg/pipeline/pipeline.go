@@ -0,0 +1,187 @@
+// Package pipeline builds the backend's request processing out of named
+// stages, so a workload can be assembled on the backend without touching
+// how requests are verified, signed and answered. A pipeline is an ordered
+// list of processors, each registered by name for one stage; processors
+// run in the order validate, transform, enrich, sign, and in the order
+// configured within a stage. Processors are compiled in and registered
+// from an init function, typically in a file added to pkg/backend.
+package pipeline
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"quantum-safe-mesh/pkg/mesh"
+)
+
+// Stage is where in a pipeline a processor runs.
+type Stage int
+
+const (
+	// Validate processors check the request and refuse it by returning an
+	// error, before anything is built from it.
+	Validate Stage = iota
+	// Transform processors build the result from the request.
+	Transform
+	// Enrich processors add to the result.
+	Enrich
+	// Sign processors seal the finished result.
+	Sign
+)
+
+var stageNames = []string{"validate", "transform", "enrich", "sign"}
+
+func (s Stage) String() string {
+	if int(s) < len(stageNames) {
+		return stageNames[s]
+	}
+	return fmt.Sprintf("stage(%d)", int(s))
+}
+
+// Document is what a pipeline's processors work on.
+type Document struct {
+	// Request is the verified request being processed.
+	Request *mesh.Request
+	// Service is the identity of the service running the pipeline.
+	Service *mesh.Identity
+	// Started is when the pipeline started.
+	Started time.Time
+	// Input is the request's data decoded as a JSON object, or nil if it
+	// is not one.
+	Input map[string]interface{}
+	// Result is answered, as JSON, once every processor has run.
+	Result map[string]interface{}
+}
+
+// ProcessorFunc is one step of a pipeline. Returning an error stops the
+// pipeline: a *models.ErrorResponse selects the status and code, and any
+// other error is reported as internal.
+type ProcessorFunc func(doc *Document) error
+
+type processor struct {
+	name  string
+	stage Stage
+	run   ProcessorFunc
+}
+
+var (
+	processorsMutex sync.RWMutex
+	processors      = make(map[string]processor)
+)
+
+// Register makes fn available to pipelines as name, running in stage. It
+// panics if name is already registered.
+func Register(stage Stage, name string, fn ProcessorFunc) {
+	processorsMutex.Lock()
+	defer processorsMutex.Unlock()
+
+	if _, exists := processors[name]; exists {
+		panic(fmt.Sprintf("pipeline: processor %q registered twice", name))
+	}
+	processors[name] = processor{name: name, stage: stage, run: fn}
+}
+
+// Pipeline is one endpoint's processors, in order.
+type Pipeline struct {
+	processors []processor
+}
+
+// New returns the pipeline of the registered processors names lists. They
+// must be listed in stage order.
+func New(names []string) (*Pipeline, error) {
+	processorsMutex.RLock()
+	defer processorsMutex.RUnlock()
+
+	p := &Pipeline{}
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		proc, ok := processors[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown processor %q", name)
+		}
+		if n := len(p.processors); n > 0 && proc.stage < p.processors[n-1].stage {
+			last := p.processors[n-1]
+			return nil, fmt.Errorf("processor %q (%s) cannot run after %q (%s)", name, proc.stage, last.name, last.stage)
+		}
+		p.processors = append(p.processors, proc)
+	}
+	return p, nil
+}
+
+// Run runs doc through the pipeline's processors.
+func (p *Pipeline) Run(doc *Document) error {
+	for _, proc := range p.processors {
+		if err := proc.run(doc); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Handler returns a handler that runs a Document for each request through
+// the pipeline as service, answering with its Result. begin, if not nil,
+// may prepare each Document first.
+func (p *Pipeline) Handler(service *mesh.Identity, begin func(doc *Document)) mesh.Handler {
+	return func(req *mesh.Request) (interface{}, error) {
+		doc := &Document{
+			Request: req,
+			Service: service,
+			Started: time.Now(),
+			Result:  make(map[string]interface{}),
+		}
+		var input map[string]interface{}
+		if json.Unmarshal(req.Envelope.Data, &input) == nil {
+			doc.Input = input
+		}
+		if begin != nil {
+			begin(doc)
+		}
+
+		if err := p.Run(doc); err != nil {
+			return nil, err
+		}
+		return doc.Result, nil
+	}
+}
+
+// Routes assigns pipelines to endpoints.
+type Routes struct {
+	Paths     []string // in the order configured
+	pipelines map[string]*Pipeline
+}
+
+// ParseRoutes parses comma-separated /path=processor|processor pairs
+// naming registered processors. Each path is an endpoint of its own.
+func ParseRoutes(value string) (*Routes, error) {
+	routes := &Routes{pipelines: make(map[string]*Pipeline)}
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		path, names, found := strings.Cut(entry, "=")
+		if !found || !strings.HasPrefix(path, "/") || names == "" {
+			return nil, fmt.Errorf("invalid pipeline %q: expected /path=processor|processor", entry)
+		}
+		if _, exists := routes.pipelines[path]; exists {
+			return nil, fmt.Errorf("pipeline for %s configured twice", path)
+		}
+		pipeline, err := New(strings.Split(names, "|"))
+		if err != nil {
+			return nil, fmt.Errorf("%w in pipeline for %s", err, path)
+		}
+		routes.Paths = append(routes.Paths, path)
+		routes.pipelines[path] = pipeline
+	}
+	return routes, nil
+}
+
+// Pipeline returns the pipeline for path, if there is one.
+func (r *Routes) Pipeline(path string) (*Pipeline, bool) {
+	pipeline, ok := r.pipelines[path]
+	return pipeline, ok
+}
@@ -0,0 +1,79 @@
+package pqc
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// Signing a value means signing some encoding of it, and a verifier that
+// re-marshals what it received can easily arrive at different bytes: struct
+// fields reordered into a map, numbers turned into float64, HTML escaping
+// toggled. SignCanonical and VerifyCanonical take the value itself and
+// always encode it the same way, so both sides sign and verify one form.
+
+// CanonicalJSON encodes v as JSON with object keys sorted, no insignificant
+// whitespace, no HTML escaping and numbers written exactly as v marshals
+// them. Decoding the result into any type that marshals to the same JSON
+// and encoding it again yields the same bytes.
+func CanonicalJSON(v interface{}) ([]byte, error) {
+	encoded, err := json.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal value: %w", err)
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(encoded))
+	decoder.UseNumber()
+	var generic interface{}
+	if err := decoder.Decode(&generic); err != nil {
+		return nil, fmt.Errorf("failed to decode value: %w", err)
+	}
+
+	var canonical bytes.Buffer
+	encoder := json.NewEncoder(&canonical)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(generic); err != nil {
+		return nil, fmt.Errorf("failed to encode canonical JSON: %w", err)
+	}
+	return bytes.TrimSuffix(canonical.Bytes(), []byte("\n")), nil
+}
+
+// SignCanonical signs the canonical JSON encoding of v.
+func (d *DilithiumKeyPair) SignCanonical(v interface{}) ([]byte, error) {
+	return signCanonical(d, v)
+}
+
+// VerifyCanonical checks signature is d's signature over the canonical JSON
+// encoding of v.
+func (d *DilithiumKeyPair) VerifyCanonical(v interface{}, signature []byte) error {
+	return VerifyCanonical(AlgorithmDilithium3, d.GetPublicKeyBytes(), v, signature)
+}
+
+// SignCanonical signs the canonical JSON encoding of v.
+func (s *SLHDSAKeyPair) SignCanonical(v interface{}) ([]byte, error) {
+	return signCanonical(s, v)
+}
+
+// VerifyCanonical checks signature is s's signature over the canonical JSON
+// encoding of v.
+func (s *SLHDSAKeyPair) VerifyCanonical(v interface{}, signature []byte) error {
+	return VerifyCanonical(s.Algorithm(), s.GetPublicKeyBytes(), v, signature)
+}
+
+// VerifyCanonical checks signature is a signature with publicKeyBytes over
+// the canonical JSON encoding of v.
+func VerifyCanonical(alg string, publicKeyBytes []byte, v interface{}, signature []byte) error {
+	payload, err := CanonicalJSON(v)
+	if err != nil {
+		return err
+	}
+	return VerifySignature(alg, publicKeyBytes, payload, signature)
+}
+
+func signCanonical(signer Signer, v interface{}) ([]byte, error) {
+	payload, err := CanonicalJSON(v)
+	if err != nil {
+		return nil, err
+	}
+	return signer.Sign(payload)
+}
@@ -0,0 +1,80 @@
+package pqc
+
+import (
+	"fmt"
+	"time"
+
+	"quantum-safe-mesh/pkg/models"
+)
+
+// PublicKeyLookup resolves a service ID to its registered public signing
+// key, typically through the auth service's /public-key endpoint.
+type PublicKeyLookup func(serviceID string) ([]byte, error)
+
+// AppendToChain signs envelope plus every existing link as signerID and
+// returns the chain with the new link appended.
+func AppendToChain(signer Signer, chain models.SignatureChain, signerID string, envelope []byte) (models.SignatureChain, error) {
+	if len(chain) >= models.MaxSignatureChainLength {
+		return nil, fmt.Errorf("signature chain already has the maximum %d links", models.MaxSignatureChainLength)
+	}
+
+	link := models.ChainLink{
+		SignerID: signerID,
+		KeyID:    KeyFingerprint(signer.GetPublicKeyBytes()),
+		Alg:      JWSAlg(signer.Algorithm()),
+		SignedAt: time.Now().UTC(),
+	}
+
+	input, err := chain.SigningInput(envelope, link)
+	if err != nil {
+		return nil, err
+	}
+
+	link.Signature, err = signer.Sign(input)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign chain link: %w", err)
+	}
+
+	extended := make(models.SignatureChain, len(chain), len(chain)+1)
+	copy(extended, chain)
+	return append(extended, link), nil
+}
+
+// VerifySignatureChain checks every link of chain in order against the keys
+// returned by lookup. A link whose key_id does not match the registered key
+// is rejected even if the signature would verify, so a chain signed with a
+// since-rotated key is reported as such rather than as a bad signature.
+func VerifySignatureChain(chain models.SignatureChain, envelope []byte, lookup PublicKeyLookup) error {
+	return VerifySignatureChainWith(chain, envelope, LookupResolver(lookup))
+}
+
+// VerifySignatureChainWith checks every link of chain in order against the
+// key resolver returns for the link's signer and key_id.
+func VerifySignatureChainWith(chain models.SignatureChain, envelope []byte, resolver KeyResolver) error {
+	if len(chain) > models.MaxSignatureChainLength {
+		return fmt.Errorf("signature chain has %d links, maximum is %d", len(chain), models.MaxSignatureChainLength)
+	}
+
+	for i, link := range chain {
+		algorithm, err := AcceptJWSAlg(link.Alg)
+		if err != nil {
+			return fmt.Errorf("chain link %d (%s): %w", i, link.SignerID, err)
+		}
+
+		publicKey, err := resolver.ResolveKey(link.SignerID, link.KeyID)
+		if err != nil {
+			return fmt.Errorf("chain link %d (%s): %w", i, link.SignerID, err)
+		}
+
+		input, err := chain[:i].SigningInput(envelope, link)
+		if err != nil {
+			return err
+		}
+
+		if err := VerifySignature(algorithm, publicKey, input, link.Signature); err != nil {
+			return fmt.Errorf("chain link %d (%s): %w", i, link.SignerID, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,56 @@
+package pqc
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"quantum-safe-mesh/pkg/models"
+)
+
+// TestSignatureChainVerifies appends hops to a chain and checks every
+// prefix verifies, both as built and after a JSON round trip.
+func TestSignatureChainVerifies(t *testing.T) {
+	keys := make(map[string]*DilithiumKeyPair)
+	for _, id := range []string{"api-gateway", "edge-proxy"} {
+		keyPair, err := GenerateDilithiumKeyPair()
+		if err != nil {
+			t.Fatalf("GenerateDilithiumKeyPair: %v", err)
+		}
+		keys[id] = keyPair
+	}
+	lookup := func(serviceID string) ([]byte, error) {
+		if keyPair, exists := keys[serviceID]; exists {
+			return keyPair.GetPublicKeyBytes(), nil
+		}
+		return nil, fmt.Errorf("unknown service %s", serviceID)
+	}
+
+	envelope := []byte(`{"service_id":"backend-service","data":{"ok":true}}`)
+	var chain models.SignatureChain
+	for _, id := range []string{"api-gateway", "edge-proxy"} {
+		var err error
+		chain, err = AppendToChain(keys[id], chain, id, envelope)
+		if err != nil {
+			t.Fatalf("AppendToChain(%s): %v", id, err)
+		}
+
+		if err := VerifySignatureChain(chain, envelope, lookup); err != nil {
+			t.Fatalf("%d-link chain does not verify: %v", len(chain), err)
+		}
+
+		encoded, _ := json.Marshal(chain)
+		var decoded models.SignatureChain
+		if err := json.Unmarshal(encoded, &decoded); err != nil {
+			t.Fatalf("Unmarshal: %v", err)
+		}
+		if err := VerifySignatureChain(decoded, envelope, lookup); err != nil {
+			t.Fatalf("%d-link chain does not verify after transport: %v", len(chain), err)
+		}
+	}
+
+	chain[0].SignerID = "edge-proxy"
+	if err := VerifySignatureChain(chain, envelope, lookup); err == nil {
+		t.Fatal("chain with a substituted signer verified")
+	}
+}
@@ -0,0 +1,323 @@
+package pqc
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/subtle"
+	"encoding/asn1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"time"
+
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+)
+
+// CMS (RFC 5652) SignedData lets consumers that expect PKCS #7 envelopes
+// check mesh signatures with their own tooling. The content is carried in
+// the envelope, and the signature covers signed attributes holding its
+// digest. The mesh has no certificates, so the signer is named by subject
+// key identifier: the key's fingerprint, as registered with the auth
+// service.
+
+// CMS object identifiers.
+var (
+	OIDSignedData    = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+	OIDData          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	oidContentType   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 3}
+	oidMessageDigest = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 4}
+	oidSigningTime   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 5}
+	oidSHA256        = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+	oidSHA384        = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 2}
+	oidSHA512        = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 3}
+)
+
+// cmsSignatureOIDs maps signature algorithms to the OIDs SignerInfos name
+// them by: the oqs-provider's for Dilithium3, which has no other, and the
+// NIST ones RFC 9882 and RFC 9814 use for ML-DSA and SLH-DSA.
+var cmsSignatureOIDs = map[string]asn1.ObjectIdentifier{
+	AlgorithmDilithium3:  OIDDilithium3,
+	AlgorithmMLDSA65:     {2, 16, 840, 1, 101, 3, 4, 3, 18},
+	"slh-dsa-sha2-128s":  {2, 16, 840, 1, 101, 3, 4, 3, 20},
+	"slh-dsa-sha2-128f":  {2, 16, 840, 1, 101, 3, 4, 3, 21},
+	"slh-dsa-sha2-192s":  {2, 16, 840, 1, 101, 3, 4, 3, 22},
+	"slh-dsa-sha2-192f":  {2, 16, 840, 1, 101, 3, 4, 3, 23},
+	"slh-dsa-sha2-256s":  {2, 16, 840, 1, 101, 3, 4, 3, 24},
+	"slh-dsa-sha2-256f":  {2, 16, 840, 1, 101, 3, 4, 3, 25},
+	"slh-dsa-shake-128s": {2, 16, 840, 1, 101, 3, 4, 3, 26},
+	"slh-dsa-shake-128f": {2, 16, 840, 1, 101, 3, 4, 3, 27},
+	"slh-dsa-shake-192s": {2, 16, 840, 1, 101, 3, 4, 3, 28},
+	"slh-dsa-shake-192f": {2, 16, 840, 1, 101, 3, 4, 3, 29},
+	"slh-dsa-shake-256s": {2, 16, 840, 1, 101, 3, 4, 3, 30},
+	"slh-dsa-shake-256f": {2, 16, 840, 1, 101, 3, 4, 3, 31},
+}
+
+var cmsDigests = []struct {
+	oid  asn1.ObjectIdentifier
+	hash crypto.Hash
+}{
+	{oidSHA256, crypto.SHA256},
+	{oidSHA384, crypto.SHA384},
+	{oidSHA512, crypto.SHA512},
+}
+
+type contentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"explicit,tag:0"`
+}
+
+type signedData struct {
+	Version          int
+	DigestAlgorithms []algorithmIdentifier `asn1:"set"`
+	EncapContentInfo encapsulatedContentInfo
+	Certificates     asn1.RawValue `asn1:"optional,tag:0"`
+	CRLs             asn1.RawValue `asn1:"optional,tag:1"`
+	SignerInfos      []signerInfo  `asn1:"set"`
+}
+
+type encapsulatedContentInfo struct {
+	EContentType asn1.ObjectIdentifier
+	EContent     []byte `asn1:"explicit,optional,tag:0"`
+}
+
+type signerInfo struct {
+	Version            int
+	SID                asn1.RawValue
+	DigestAlgorithm    algorithmIdentifier
+	SignedAttrs        asn1.RawValue `asn1:"optional,tag:0"`
+	SignatureAlgorithm algorithmIdentifier
+	Signature          []byte
+	UnsignedAttrs      asn1.RawValue `asn1:"optional,tag:1"`
+}
+
+type attribute struct {
+	Type   asn1.ObjectIdentifier
+	Values []asn1.RawValue `asn1:"set"`
+}
+
+// SignedData is a parsed CMS SignedData with a single signer.
+type SignedData struct {
+	// Content is the signed content.
+	Content []byte
+	// KeyID is the fingerprint of the signer's key.
+	KeyID string
+	// Algorithm is the key store name of the signature algorithm.
+	Algorithm   string
+	SigningTime time.Time
+
+	signedAttrs []byte
+	signature   []byte
+}
+
+// SignSignedData signs content with signer into a DER-encoded CMS
+// SignedData ContentInfo, with the content encapsulated and a SHA-512
+// digest of it among the signed attributes.
+func SignSignedData(signer Signer, content []byte, signingTime time.Time) ([]byte, error) {
+	signatureOID, ok := cmsSignatureOIDs[signer.Algorithm()]
+	if !ok {
+		return nil, fmt.Errorf("no CMS algorithm identifier for %s", signer.Algorithm())
+	}
+
+	digest := crypto.SHA512.New()
+	digest.Write(content)
+	attrs, err := marshalAttributes([]attributeValue{
+		{oidContentType, OIDData},
+		{oidMessageDigest, digest.Sum(nil)},
+		{oidSigningTime, signingTime.UTC()},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode signed attributes: %w", err)
+	}
+
+	signed, err := asn1.Marshal(asn1.RawValue{Tag: asn1.TagSet, IsCompound: true, Bytes: attrs})
+	if err != nil {
+		return nil, err
+	}
+	signature, err := signer.Sign(signed)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign attributes: %w", err)
+	}
+
+	keyID, err := hex.DecodeString(KeyFingerprint(signer.GetPublicKeyBytes()))
+	if err != nil {
+		return nil, err
+	}
+	sd := signedData{
+		// Version 3, as a signer named by subject key identifier needs.
+		Version:          3,
+		DigestAlgorithms: []algorithmIdentifier{{Algorithm: oidSHA512}},
+		EncapContentInfo: encapsulatedContentInfo{EContentType: OIDData, EContent: content},
+		SignerInfos: []signerInfo{{
+			Version:            3,
+			SID:                asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, Bytes: keyID},
+			DigestAlgorithm:    algorithmIdentifier{Algorithm: oidSHA512},
+			SignedAttrs:        asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: attrs},
+			SignatureAlgorithm: algorithmIdentifier{Algorithm: signatureOID},
+			Signature:          signature,
+		}},
+	}
+	inner, err := asn1.Marshal(sd)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode SignedData: %w", err)
+	}
+	return asn1.Marshal(contentInfo{
+		ContentType: OIDSignedData,
+		Content:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: inner},
+	})
+}
+
+type attributeValue struct {
+	oid   asn1.ObjectIdentifier
+	value interface{}
+}
+
+// marshalAttributes encodes attrs, each single-valued, as the contents of
+// a DER SET OF Attribute, sorted as DER requires.
+func marshalAttributes(attrs []attributeValue) ([]byte, error) {
+	var encoded [][]byte
+	for _, attr := range attrs {
+		value, err := asn1.Marshal(attr.value)
+		if err != nil {
+			return nil, err
+		}
+		der, err := asn1.Marshal(attribute{Type: attr.oid, Values: []asn1.RawValue{{FullBytes: value}}})
+		if err != nil {
+			return nil, err
+		}
+		encoded = append(encoded, der)
+	}
+	sort.Slice(encoded, func(i, j int) bool { return bytes.Compare(encoded[i], encoded[j]) < 0 })
+	return bytes.Join(encoded, nil), nil
+}
+
+// ParseSignedData parses a DER-encoded CMS SignedData ContentInfo with
+// encapsulated content and one signer named by subject key identifier, and
+// checks the content matches the digest in its signed attributes. The
+// signature itself is checked by Verify.
+func ParseSignedData(der []byte) (*SignedData, error) {
+	var info contentInfo
+	if rest, err := asn1.Unmarshal(der, &info); err != nil {
+		return nil, fmt.Errorf("failed to parse ContentInfo: %w", err)
+	} else if len(rest) > 0 {
+		return nil, errors.New("trailing data after ContentInfo")
+	}
+	if !info.ContentType.Equal(OIDSignedData) {
+		return nil, fmt.Errorf("content type %s is not SignedData", info.ContentType)
+	}
+
+	var sd signedData
+	if _, err := asn1.Unmarshal(info.Content.Bytes, &sd); err != nil {
+		return nil, fmt.Errorf("failed to parse SignedData: %w", err)
+	}
+	if sd.EncapContentInfo.EContent == nil {
+		return nil, errors.New("SignedData has no encapsulated content")
+	}
+	if len(sd.SignerInfos) != 1 {
+		return nil, fmt.Errorf("SignedData has %d signers, expected 1", len(sd.SignerInfos))
+	}
+
+	si := sd.SignerInfos[0]
+	if si.SID.Class != asn1.ClassContextSpecific || si.SID.Tag != 0 {
+		return nil, errors.New("signer is not named by subject key identifier")
+	}
+	if len(si.SignedAttrs.Bytes) == 0 {
+		return nil, errors.New("signer has no signed attributes")
+	}
+	algorithm, err := cmsSignatureAlgorithm(si.SignatureAlgorithm.Algorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	parsed := &SignedData{
+		Content:   sd.EncapContentInfo.EContent,
+		KeyID:     hex.EncodeToString(si.SID.Bytes),
+		Algorithm: algorithm,
+		signature: si.Signature,
+	}
+	parsed.signedAttrs, err = asn1.Marshal(asn1.RawValue{Tag: asn1.TagSet, IsCompound: true, Bytes: si.SignedAttrs.Bytes})
+	if err != nil {
+		return nil, err
+	}
+	if err := parsed.checkAttributes(si.SignedAttrs.Bytes, si.DigestAlgorithm.Algorithm, sd.EncapContentInfo.EContentType); err != nil {
+		return nil, err
+	}
+	return parsed, nil
+}
+
+// checkAttributes checks the signed attributes name eContentType and hold
+// the digest, with the digest algorithm digestOID, of the content, and
+// records the signing time.
+func (sd *SignedData) checkAttributes(attrs []byte, digestOID, eContentType asn1.ObjectIdentifier) error {
+	hash, err := cmsDigest(digestOID)
+	if err != nil {
+		return err
+	}
+
+	var contentType asn1.ObjectIdentifier
+	var messageDigest []byte
+	for len(attrs) > 0 {
+		var attr attribute
+		var err error
+		if attrs, err = asn1.Unmarshal(attrs, &attr); err != nil {
+			return fmt.Errorf("failed to parse signed attribute: %w", err)
+		}
+		if len(attr.Values) != 1 {
+			return fmt.Errorf("signed attribute %s has %d values, expected 1", attr.Type, len(attr.Values))
+		}
+		value := attr.Values[0].FullBytes
+		switch {
+		case attr.Type.Equal(oidContentType):
+			_, err = asn1.Unmarshal(value, &contentType)
+		case attr.Type.Equal(oidMessageDigest):
+			_, err = asn1.Unmarshal(value, &messageDigest)
+		case attr.Type.Equal(oidSigningTime):
+			_, err = asn1.Unmarshal(value, &sd.SigningTime)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to parse signed attribute %s: %w", attr.Type, err)
+		}
+	}
+
+	if !contentType.Equal(eContentType) {
+		return fmt.Errorf("signed content type %s does not match content type %s", contentType, eContentType)
+	}
+	digest := hash.New()
+	digest.Write(sd.Content)
+	if subtle.ConstantTimeCompare(digest.Sum(nil), messageDigest) != 1 {
+		return errors.New("content does not match its signed digest")
+	}
+	return nil
+}
+
+// Verify checks the signature over the signed attributes with publicKey,
+// which must be the key the signer is named by.
+func (sd *SignedData) Verify(publicKey []byte) error {
+	if err := CheckKeyID(publicKey, sd.KeyID); err != nil {
+		return err
+	}
+	if AllowedJWSAlgorithms != nil && !slices.Contains(AllowedJWSAlgorithms, sd.Algorithm) {
+		return fmt.Errorf("signature algorithm %s is not allowed by policy", sd.Algorithm)
+	}
+	return VerifySignature(sd.Algorithm, publicKey, sd.signedAttrs, sd.signature)
+}
+
+func cmsSignatureAlgorithm(oid asn1.ObjectIdentifier) (string, error) {
+	for algorithm, known := range cmsSignatureOIDs {
+		if oid.Equal(known) {
+			return algorithm, nil
+		}
+	}
+	return "", fmt.Errorf("unsupported signature algorithm OID %s", oid)
+}
+
+func cmsDigest(oid asn1.ObjectIdentifier) (crypto.Hash, error) {
+	for _, digest := range cmsDigests {
+		if oid.Equal(digest.oid) {
+			return digest.hash, nil
+		}
+	}
+	return 0, fmt.Errorf("unsupported digest algorithm OID %s", oid)
+}
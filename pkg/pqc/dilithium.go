@@ -2,14 +2,18 @@ package pqc
 
 import (
 	"crypto/rand"
-	"encoding/json"
 	"fmt"
 	"log"
+	"log/slog"
 	"time"
 
 	"github.com/cloudflare/circl/sign/dilithium/mode3"
 )
 
+// DilithiumKeyPair holds its private key expanded: unpacking a circl
+// private key precomputes the matrix A and the NTT forms of s1, s2 and t0
+// that every signature needs, so a key pair loaded once signs without
+// repeating that work. Keep the key pair rather than its bytes.
 type DilithiumKeyPair struct {
 	PublicKey  mode3.PublicKey
 	PrivateKey mode3.PrivateKey
@@ -26,8 +30,7 @@ func GenerateDilithiumKeyPair() (*DilithiumKeyPair, error) {
 
 	duration := time.Since(start)
 	log.Printf("✅ Dilithium3 keypair generated in %v", duration)
-	log.Printf("📏 Public key size: %d bytes, Private key size: %d bytes",
-		mode3.PublicKeySize, mode3.PrivateKeySize)
+	slog.Debug("📏 Dilithium3 key sizes", "public_key_bytes", mode3.PublicKeySize, "private_key_bytes", mode3.PrivateKeySize)
 
 	return &DilithiumKeyPair{
 		PublicKey:  *publicKey,
@@ -35,19 +38,42 @@ func GenerateDilithiumKeyPair() (*DilithiumKeyPair, error) {
 	}, nil
 }
 
-func (d *DilithiumKeyPair) Sign(data []byte) ([]byte, error) {
-	log.Printf("🖊️  Signing data with Dilithium3 (data size: %d bytes)", len(data))
-	start := time.Now()
+// DilithiumSeedSize is the seed length DeriveDilithiumKeyPair expects.
+const DilithiumSeedSize = mode3.SeedSize
 
-	signature := make([]byte, mode3.SignatureSize)
-	mode3.SignTo(&d.PrivateKey, data, signature)
+// DeriveDilithiumKeyPair deterministically expands seed into a keypair. The
+// same seed always yields the same keys, which suits test fixtures and
+// known-answer tests; use GenerateDilithiumKeyPair for real identities.
+func DeriveDilithiumKeyPair(seed []byte) (*DilithiumKeyPair, error) {
+	if len(seed) != DilithiumSeedSize {
+		return nil, fmt.Errorf("invalid seed size: expected %d, got %d", DilithiumSeedSize, len(seed))
+	}
 
-	duration := time.Since(start)
-	log.Printf("✅ Data signed in %v (signature size: %d bytes)", duration, len(signature))
+	var seedArray [mode3.SeedSize]byte
+	copy(seedArray[:], seed)
+	publicKey, privateKey := mode3.NewKeyFromSeed(&seedArray)
+
+	return &DilithiumKeyPair{
+		PublicKey:  *publicKey,
+		PrivateKey: *privateKey,
+	}, nil
+}
 
+func (d *DilithiumKeyPair) Sign(data []byte) ([]byte, error) {
+	signature := make([]byte, mode3.SignatureSize)
+	d.signTo(data, signature)
 	return signature, nil
 }
 
+// signTo signs data into signature, which is mode3.SignatureSize bytes.
+func (d *DilithiumKeyPair) signTo(data, signature []byte) {
+	start := time.Now()
+	mode3.SignTo(&d.PrivateKey, data, signature)
+
+	slog.Debug("🖊️  Data signed", "algorithm", AlgorithmDilithium3, "data_bytes", len(data),
+		"signature_bytes", len(signature), "duration", time.Since(start))
+}
+
 func (d *DilithiumKeyPair) GetPublicKeyBytes() []byte {
 	return d.PublicKey.Bytes()
 }
@@ -56,46 +82,62 @@ func (d *DilithiumKeyPair) GetPrivateKeyBytes() []byte {
 	return d.PrivateKey.Bytes()
 }
 
-func VerifyDilithiumSignature(publicKeyBytes, data, signature []byte) error {
-	log.Printf("🔍 Verifying Dilithium3 signature (data: %d bytes, sig: %d bytes)",
-		len(data), len(signature))
-	start := time.Now()
+// ExpandDilithiumPublicKey unpacks a public key for verification. Unpacking
+// expands the matrix A from the key's seed, which costs nearly as much as a
+// verification, so expanded keys are cached and each peer's key is only
+// expanded once. The returned key must not be modified.
+func ExpandDilithiumPublicKey(publicKeyBytes []byte) (*mode3.PublicKey, error) {
+	return expandPublicKey(AlgorithmDilithium3, publicKeyBytes, func(publicKeyBytes []byte) (*mode3.PublicKey, error) {
+		if len(publicKeyBytes) != mode3.PublicKeySize {
+			return nil, fmt.Errorf("invalid public key size: expected %d, got %d", mode3.PublicKeySize, len(publicKeyBytes))
+		}
+		publicKey := new(mode3.PublicKey)
+		if err := publicKey.UnmarshalBinary(publicKeyBytes); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal public key: %w", err)
+		}
+		return publicKey, nil
+	})
+}
 
-	if len(publicKeyBytes) != mode3.PublicKeySize {
-		return fmt.Errorf("invalid public key size: expected %d, got %d", mode3.PublicKeySize, len(publicKeyBytes))
+// ExpandDilithiumPrivateKey unpacks a private key for signing, precomputing
+// the matrix A and the NTT forms of s1, s2 and t0 once; a key pair keeps
+// the result, so its signatures skip that work.
+func ExpandDilithiumPrivateKey(privateKeyBytes []byte) (*mode3.PrivateKey, error) {
+	if len(privateKeyBytes) != mode3.PrivateKeySize {
+		return nil, fmt.Errorf("invalid private key size: expected %d, got %d", mode3.PrivateKeySize, len(privateKeyBytes))
+	}
+	privateKey := new(mode3.PrivateKey)
+	if err := privateKey.UnmarshalBinary(privateKeyBytes); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal private key: %w", err)
 	}
+	return privateKey, nil
+}
 
-	var publicKey mode3.PublicKey
-	err := publicKey.UnmarshalBinary(publicKeyBytes)
+func VerifyDilithiumSignature(publicKeyBytes, data, signature []byte) error {
+	start := time.Now()
+
+	publicKey, err := ExpandDilithiumPublicKey(publicKeyBytes)
 	if err != nil {
-		return fmt.Errorf("failed to unmarshal public key: %w", err)
+		return err
 	}
 
-	if !mode3.Verify(&publicKey, data, signature) {
-		duration := time.Since(start)
-		log.Printf("❌ Signature verification failed in %v", duration)
+	if !mode3.Verify(publicKey, data, signature) {
+		slog.Debug("🔍 Signature invalid", "algorithm", AlgorithmDilithium3, "data_bytes", len(data), "duration", time.Since(start))
 		return fmt.Errorf("invalid signature")
 	}
 
-	duration := time.Since(start)
-	log.Printf("✅ Signature verified successfully in %v", duration)
+	slog.Debug("🔍 Signature verified", "algorithm", AlgorithmDilithium3, "data_bytes", len(data), "duration", time.Since(start))
 
 	return nil
 }
 
+// SignRequest signs serviceID, the current time and data as canonical JSON.
 func (d *DilithiumKeyPair) SignRequest(serviceID string, data interface{}) ([]byte, error) {
-	requestData := map[string]interface{}{
+	return d.SignCanonical(map[string]interface{}{
 		"service_id": serviceID,
 		"timestamp":  time.Now(),
 		"data":       data,
-	}
-
-	payload, err := json.Marshal(requestData)
-	if err != nil {
-		return nil, fmt.Errorf("failed to marshal request data: %w", err)
-	}
-
-	return d.Sign(payload)
+	})
 }
 
 func LoadDilithiumKeyPair(publicKeyBytes, privateKeyBytes []byte) (*DilithiumKeyPair, error) {
@@ -103,25 +145,18 @@ func LoadDilithiumKeyPair(publicKeyBytes, privateKeyBytes []byte) (*DilithiumKey
 		return nil, fmt.Errorf("invalid public key size: expected %d, got %d", mode3.PublicKeySize, len(publicKeyBytes))
 	}
 
-	if len(privateKeyBytes) != mode3.PrivateKeySize {
-		return nil, fmt.Errorf("invalid private key size: expected %d, got %d", mode3.PrivateKeySize, len(privateKeyBytes))
-	}
-
 	var publicKey mode3.PublicKey
-	var privateKey mode3.PrivateKey
-
-	err := publicKey.UnmarshalBinary(publicKeyBytes)
-	if err != nil {
+	if err := publicKey.UnmarshalBinary(publicKeyBytes); err != nil {
 		return nil, fmt.Errorf("failed to unmarshal public key: %w", err)
 	}
 
-	err = privateKey.UnmarshalBinary(privateKeyBytes)
+	privateKey, err := ExpandDilithiumPrivateKey(privateKeyBytes)
 	if err != nil {
-		return nil, fmt.Errorf("failed to unmarshal private key: %w", err)
+		return nil, err
 	}
 
 	return &DilithiumKeyPair{
 		PublicKey:  publicKey,
-		PrivateKey: privateKey,
+		PrivateKey: *privateKey,
 	}, nil
 }
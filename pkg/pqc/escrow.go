@@ -0,0 +1,260 @@
+package pqc
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+// EscrowKeyFile, when set, names the public half of an offline recovery
+// Kyber key pair. SaveKeyPair then also seals the private keys it writes to
+// that key, so they can be recovered without a plaintext backup.
+var EscrowKeyFile string
+
+const pemTypeKeyEscrow = "MESH KEY ESCROW"
+
+// EscrowFileName returns the file name serviceID's escrowed keys are
+// written to in KeysDir.
+func EscrowFileName(serviceID string) string {
+	return serviceID + "_escrow.pem"
+}
+
+// EscrowedKeys are a service's keys as recovered from escrow.
+type EscrowedKeys struct {
+	ServiceID string
+	Signer    Signer
+	Kyber     *KyberKeyPair
+	Metadata  *KeyMetadata
+}
+
+type escrowPayload struct {
+	Algorithm  string       `json:"algorithm"`
+	SigningKey []byte       `json:"signing_key"`
+	KEMKey     []byte       `json:"kem_key"`
+	Metadata   *KeyMetadata `json:"metadata"`
+}
+
+// saveEscrow seals serviceID's private keys to the recovery key with
+// Kyber768 and AES-256-GCM, like an encrypted key with a KEM in place of the
+// passphrase.
+func saveEscrow(serviceID string, signer Signer, kyberKeyPair *KyberKeyPair, metadata *KeyMetadata) error {
+	recoveryKey, err := readRecoveryPublicKey(EscrowKeyFile)
+	if err != nil {
+		return err
+	}
+
+	payload, err := json.Marshal(escrowPayload{
+		Algorithm:  signer.Algorithm(),
+		SigningKey: signer.GetPrivateKeyBytes(),
+		KEMKey:     kyberKeyPair.GetPrivateKeyBytes(),
+		Metadata:   metadata,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to encode escrowed keys: %w", err)
+	}
+	defer clear(payload)
+
+	encapsulation, sharedSecret, err := EncapsulateWithPublicKey(recoveryKey)
+	if err != nil {
+		return fmt.Errorf("failed to encapsulate to recovery key: %w", err)
+	}
+	aead, err := escrowAEAD(sharedSecret)
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return fmt.Errorf("failed to generate nonce: %w", err)
+	}
+
+	recoveryKeyID := KeyFingerprint(recoveryKey)
+	block := &pem.Block{
+		Type: pemTypeKeyEscrow,
+		Headers: map[string]string{
+			"Service":       serviceID,
+			"Recovery-Key":  recoveryKeyID,
+			"KEM":           AlgorithmKyber768,
+			"Encapsulation": hex.EncodeToString(encapsulation),
+			"Cipher":        "AES-256-GCM",
+			"Nonce":         hex.EncodeToString(nonce),
+		},
+		Bytes: aead.Seal(nil, nonce, payload, escrowAAD(serviceID, recoveryKeyID)),
+	}
+	if err := writeKeyFile(filepath.Join(KeysDir, EscrowFileName(serviceID)), pem.EncodeToMemory(block), 0600); err != nil {
+		return fmt.Errorf("failed to save escrowed keys: %w", err)
+	}
+
+	log.Printf("🗄️  Private keys of %s escrowed to recovery key %s", serviceID, recoveryKeyID)
+	return nil
+}
+
+// OpenEscrow decrypts escrowed keys with the recovery private key.
+func OpenEscrow(data []byte, recoveryKey *KyberKeyPair) (*EscrowedKeys, error) {
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != pemTypeKeyEscrow {
+		return nil, fmt.Errorf("not a %s PEM block", pemTypeKeyEscrow)
+	}
+	serviceID := block.Headers["Service"]
+	recoveryKeyID := KeyFingerprint(recoveryKey.GetPublicKeyBytes())
+	if block.Headers["Recovery-Key"] != recoveryKeyID {
+		return nil, fmt.Errorf("keys of %s are escrowed to recovery key %s, not %s", serviceID, block.Headers["Recovery-Key"], recoveryKeyID)
+	}
+	if block.Headers["KEM"] != AlgorithmKyber768 || block.Headers["Cipher"] != "AES-256-GCM" {
+		return nil, fmt.Errorf("unsupported escrow KEM %q or cipher %q", block.Headers["KEM"], block.Headers["Cipher"])
+	}
+
+	encapsulation, err := hex.DecodeString(block.Headers["Encapsulation"])
+	if err != nil {
+		return nil, fmt.Errorf("invalid escrow encapsulation: %w", err)
+	}
+	nonce, err := hex.DecodeString(block.Headers["Nonce"])
+	if err != nil {
+		return nil, fmt.Errorf("invalid escrow nonce: %w", err)
+	}
+	sharedSecret, err := recoveryKey.Decapsulate(encapsulation)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decapsulate escrow key: %w", err)
+	}
+	aead, err := escrowAEAD(sharedSecret)
+	if err != nil {
+		return nil, err
+	}
+	if len(nonce) != aead.NonceSize() {
+		return nil, fmt.Errorf("invalid escrow nonce size %d", len(nonce))
+	}
+	plaintext, err := aead.Open(nil, nonce, block.Bytes, escrowAAD(serviceID, recoveryKeyID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt escrowed keys: wrong recovery key or corrupted file")
+	}
+	defer clear(plaintext)
+
+	var payload escrowPayload
+	if err := json.Unmarshal(plaintext, &payload); err != nil {
+		return nil, fmt.Errorf("failed to decode escrowed keys: %w", err)
+	}
+	signerPub, err := derivePublicKey(payload.Algorithm, payload.SigningKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid escrowed %s key: %w", payload.Algorithm, err)
+	}
+	signer, err := LoadSigner(payload.Algorithm, signerPub, payload.SigningKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load escrowed %s key: %w", payload.Algorithm, err)
+	}
+	metadata := payload.Metadata
+	if metadata == nil || metadata.Fingerprint != KeyFingerprint(signerPub) {
+		return nil, fmt.Errorf("escrowed key metadata does not match the escrowed key")
+	}
+	kemAlgorithm, err := KEMAlgorithm(metadata.KEMAlgorithm)
+	if err != nil {
+		return nil, fmt.Errorf("invalid escrowed KEM key: %w", err)
+	}
+	kyberPub, err := derivePublicKey(kemAlgorithm, payload.KEMKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid escrowed %s key: %w", kemAlgorithm, err)
+	}
+	kyberKeyPair, err := LoadKEMKeyPair(kemAlgorithm, kyberPub, payload.KEMKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load escrowed %s key: %w", kemAlgorithm, err)
+	}
+	return &EscrowedKeys{ServiceID: serviceID, Signer: signer, Kyber: kyberKeyPair, Metadata: metadata}, nil
+}
+
+// escrowAAD binds the sealed keys to the service and recovery key named in
+// the headers, so an escrow cannot be relabelled as another service's.
+func escrowAAD(serviceID, recoveryKeyID string) []byte {
+	return []byte(pemTypeKeyEscrow + " " + serviceID + " " + recoveryKeyID)
+}
+
+func escrowAEAD(sharedSecret []byte) (cipher.AEAD, error) {
+	key := make([]byte, 32)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, sharedSecret, nil, []byte(pemTypeKeyEscrow)), key); err != nil {
+		return nil, fmt.Errorf("failed to derive escrow key: %w", err)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+// SaveRecoveryKey writes a recovery key pair to path.pub and path.key in
+// KeysFormat, encrypting the private key if passphrase is set. The private
+// key belongs offline; only the public key is given to services.
+func SaveRecoveryKey(path string, kyberKeyPair *KyberKeyPair, passphrase []byte) error {
+	files := []struct {
+		name, kind, description string
+		key                     []byte
+		perm                    os.FileMode
+	}{
+		{path + ".pub", publicKeyKind, "public key", kyberKeyPair.GetPublicKeyBytes(), 0644},
+		{path + ".key", privateKeyKind, "private key", kyberKeyPair.GetPrivateKeyBytes(), 0600},
+	}
+	for _, file := range files {
+		data, err := encodeKey(AlgorithmKyber768, file.kind, file.key, passphrase)
+		if err != nil {
+			return fmt.Errorf("failed to encode recovery %s: %w", file.description, err)
+		}
+		if err := writeKeyFile(file.name, data, file.perm); err != nil {
+			return fmt.Errorf("failed to save recovery %s: %w", file.description, err)
+		}
+	}
+	return nil
+}
+
+// LoadRecoveryKey reads a recovery private key written by SaveRecoveryKey,
+// decrypting it with passphrase if it is encrypted.
+func LoadRecoveryKey(path string, passphrase []byte) (*KyberKeyPair, error) {
+	keyFile, err := readKyberKeyFile(path, true, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	publicKey, err := keyFile.PublicKey()
+	if err != nil {
+		return nil, err
+	}
+	return LoadKyberKeyPair(publicKey, keyFile.Key)
+}
+
+func readRecoveryPublicKey(path string) ([]byte, error) {
+	keyFile, err := readKyberKeyFile(path, false, nil)
+	if err != nil {
+		return nil, err
+	}
+	return keyFile.Key, nil
+}
+
+func readKyberKeyFile(path string, private bool, passphrase []byte) (*KeyFile, error) {
+	kind := "public"
+	if private {
+		kind = "private"
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read recovery %s key: %w", kind, err)
+	}
+	keyFile, err := ParseKeyFile(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse recovery %s key %s: %w", kind, path, err)
+	}
+	if keyFile.Algorithm != AlgorithmKyber768 || keyFile.Private != private {
+		return nil, fmt.Errorf("%s is not a %s %s key", path, AlgorithmKyber768, kind)
+	}
+	if keyFile.Encrypted && len(passphrase) == 0 {
+		return nil, fmt.Errorf("recovery key %s is encrypted and no passphrase was given", path)
+	}
+	if err := keyFile.Decrypt(passphrase); err != nil {
+		return nil, fmt.Errorf("failed to decrypt recovery key %s: %w", path, err)
+	}
+	return keyFile, nil
+}
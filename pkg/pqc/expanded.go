@@ -0,0 +1,47 @@
+package pqc
+
+import "sync"
+
+// maxExpandedPublicKeys bounds the expanded public key cache; a mesh has
+// far fewer peers, and their keys change rarely.
+const maxExpandedPublicKeys = 256
+
+// expandedPublicKeys keeps unpacked public keys of every algorithm, keyed
+// by algorithm and key bytes, oldest evicted first.
+var expandedPublicKeys = struct {
+	sync.Mutex
+	keys  map[string]any
+	order []string
+}{keys: make(map[string]any)}
+
+// expandPublicKey returns algorithm's public key publicKeyBytes as unpack
+// expands it, unpacking it only the first time it is asked for. Unpacked
+// keys are shared, so callers must not modify them.
+func expandPublicKey[K any](algorithm string, publicKeyBytes []byte, unpack func([]byte) (K, error)) (K, error) {
+	key := algorithm + "\x00" + string(publicKeyBytes)
+
+	cache := &expandedPublicKeys
+	cache.Lock()
+	cached, found := cache.keys[key]
+	cache.Unlock()
+	if found {
+		return cached.(K), nil
+	}
+
+	publicKey, err := unpack(publicKeyBytes)
+	if err != nil {
+		return publicKey, err
+	}
+
+	cache.Lock()
+	defer cache.Unlock()
+	if _, found := cache.keys[key]; !found {
+		if len(cache.order) >= maxExpandedPublicKeys {
+			delete(cache.keys, cache.order[0])
+			cache.order = cache.order[1:]
+		}
+		cache.keys[key] = publicKey
+		cache.order = append(cache.order, key)
+	}
+	return publicKey, nil
+}
@@ -0,0 +1,78 @@
+package pqc
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/cloudflare/circl/sign/slhdsa"
+)
+
+// jwsAlgs maps signature algorithms to their JOSE "alg" names. ML-DSA and
+// SLH-DSA keep the FIPS 204 and 205 names, which the IETF JOSE and COSE
+// drafts for them register. Round-3 Dilithium is not ML-DSA and has no
+// registered name, so it keeps the mesh's own.
+var jwsAlgs = joseNames()
+
+func joseNames() map[string]string {
+	names := map[string]string{AlgorithmDilithium3: JWSAlgDilithium3, AlgorithmMLDSA65: "ML-DSA-65"}
+	for id := slhdsa.ID(1); id.IsValid(); id++ {
+		names[strings.ToLower(id.String())] = id.String()
+	}
+	return names
+}
+
+// JWSAliases maps "alg" names that are not an algorithm's JOSE name, but
+// that tokens from other libraries or older mesh versions carry, to the
+// algorithm they stand for. By default it holds the upper-case SLH-DSA
+// names mesh versions before the JOSE names used.
+var JWSAliases = defaultJWSAliases()
+
+func defaultJWSAliases() map[string]string {
+	aliases := make(map[string]string)
+	for _, algorithm := range SLHDSAAlgorithms {
+		aliases[strings.ToUpper(algorithm)] = algorithm
+	}
+	return aliases
+}
+
+// AllowedJWSAlgorithms, if not nil, lists the only signature algorithms
+// JWS, chain links and CMS SignedData are accepted with.
+var AllowedJWSAlgorithms []string
+
+// JWSAlg is algorithm's JOSE name, used in JWS headers, chain links and
+// envelopes.
+func JWSAlg(algorithm string) string {
+	if name, ok := jwsAlgs[algorithm]; ok {
+		return name
+	}
+	return strings.ToUpper(algorithm)
+}
+
+// JWSAlgorithm returns the signature algorithm a JWS "alg" names: one of
+// the JOSE names JWSAlg gives or a JWSAliases entry. Unlike
+// SignatureAlgorithm it is case-sensitive, as JOSE names are.
+func JWSAlgorithm(alg string) (string, error) {
+	for algorithm, name := range jwsAlgs {
+		if name == alg {
+			return algorithm, nil
+		}
+	}
+	if algorithm, ok := JWSAliases[alg]; ok {
+		return algorithm, nil
+	}
+	return "", fmt.Errorf("unknown JWS algorithm %q", alg)
+}
+
+// AcceptJWSAlg is JWSAlgorithm for a JWS or chain link being verified,
+// which also refuses algorithms AllowedJWSAlgorithms leaves out.
+func AcceptJWSAlg(alg string) (string, error) {
+	algorithm, err := JWSAlgorithm(alg)
+	if err != nil {
+		return "", err
+	}
+	if AllowedJWSAlgorithms != nil && !slices.Contains(AllowedJWSAlgorithms, algorithm) {
+		return "", fmt.Errorf("JWS algorithm %s is not allowed by policy", alg)
+	}
+	return algorithm, nil
+}
@@ -0,0 +1,133 @@
+package pqc
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"quantum-safe-mesh/pkg/bufpool"
+)
+
+// JWSAlgDilithium3 is the "alg" value placed in protected headers for
+// signatures produced by DilithiumKeyPair. See JWSAlg for the others.
+const JWSAlgDilithium3 = "DILITHIUM3"
+
+// JWSHeader is the protected header of a mesh JWS.
+type JWSHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+	Iat int64  `json:"iat"`
+	Typ string `json:"typ,omitempty"`
+	Rid string `json:"rid,omitempty"` // mesh request_id
+	Pid string `json:"pid,omitempty"` // mesh parent_id
+	Htm string `json:"htm,omitempty"` // client's original HTTP method
+	Idk string `json:"idk,omitempty"` // mesh idempotency_key
+}
+
+func (h *JWSHeader) IssuedAt() time.Time {
+	return time.Unix(h.Iat, 0)
+}
+
+// SignDetached produces a compact JWS over payload with the payload section
+// left empty (RFC 7515 Appendix F). The payload travels separately, e.g. as
+// the HTTP body, and must be supplied again to VerifyDetachedJWS.
+func SignDetached(signer Signer, kid string, payload []byte) (string, error) {
+	return SignDetachedWithHeader(signer, JWSHeader{Kid: kid}, payload)
+}
+
+// SignDetachedWithHeader is SignDetached with extra protected header fields
+// such as the request IDs. Alg, Iat and Typ are always set by the signer.
+func SignDetachedWithHeader(signer Signer, header JWSHeader, payload []byte) (string, error) {
+	header.Alg = JWSAlg(signer.Algorithm())
+	header.Iat = time.Now().Unix()
+	header.Typ = "mesh+jws"
+
+	headerJSON, err := json.Marshal(header)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal JWS header: %w", err)
+	}
+
+	encodedHeader := base64.RawURLEncoding.EncodeToString(headerJSON)
+	signingInput := bufpool.Get()
+	defer bufpool.Put(signingInput)
+	writeJWSSigningInput(signingInput, encodedHeader, payload)
+
+	signature, err := SignPooled(signer, signingInput.Bytes())
+	if err != nil {
+		return "", fmt.Errorf("failed to sign JWS: %w", err)
+	}
+	defer ReleaseSignature(signature)
+
+	return encodedHeader + ".." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// ParseDetachedJWS decodes the protected header and signature of a compact
+// JWS with a detached payload without verifying it. Callers use the header's
+// kid to decide which public key to verify against.
+func ParseDetachedJWS(token string) (*JWSHeader, []byte, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, nil, fmt.Errorf("malformed JWS: expected 3 segments, got %d", len(parts))
+	}
+	if parts[1] != "" {
+		return nil, nil, fmt.Errorf("malformed JWS: payload segment must be empty for detached signatures")
+	}
+
+	headerJSON, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to decode JWS header: %w", err)
+	}
+
+	var header JWSHeader
+	if err := json.Unmarshal(headerJSON, &header); err != nil {
+		return nil, nil, fmt.Errorf("failed to unmarshal JWS header: %w", err)
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to decode JWS signature: %w", err)
+	}
+
+	return &header, signature, nil
+}
+
+// VerifyDetachedJWS checks token against payload and the signer's public key
+// and returns the verified protected header.
+func VerifyDetachedJWS(publicKeyBytes []byte, token string, payload []byte) (*JWSHeader, error) {
+	header, signature, err := ParseDetachedJWS(token)
+	if err != nil {
+		return nil, err
+	}
+
+	// An empty alg would verify as Dilithium3; a JWS must name its algorithm.
+	if header.Alg == "" {
+		return nil, fmt.Errorf("unsupported JWS algorithm: none given")
+	}
+	algorithm, err := AcceptJWSAlg(header.Alg)
+	if err != nil {
+		return nil, err
+	}
+
+	signingInput := bufpool.Get()
+	defer bufpool.Put(signingInput)
+	writeJWSSigningInput(signingInput, token[:strings.Index(token, ".")], payload)
+	if err := VerifySignature(algorithm, publicKeyBytes, signingInput.Bytes(), signature); err != nil {
+		return nil, err
+	}
+
+	return header, nil
+}
+
+// writeJWSSigningInput writes encodedHeader "." base64url(payload) to buf.
+func writeJWSSigningInput(buf *bytes.Buffer, encodedHeader string, payload []byte) {
+	encodedLen := base64.RawURLEncoding.EncodedLen(len(payload))
+	buf.Grow(len(encodedHeader) + 1 + encodedLen)
+	buf.WriteString(encodedHeader)
+	buf.WriteByte('.')
+	encoded := buf.AvailableBuffer()[:encodedLen]
+	base64.RawURLEncoding.Encode(encoded, payload)
+	buf.Write(encoded)
+}
@@ -0,0 +1,399 @@
+package pqc
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"hash"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cloudflare/circl/kem/kyber/kyber768"
+)
+
+// Known-answer tests. testdata/ holds the first entries of the NIST KAT
+// files for Dilithium3 and Kyber768 (PQCsignKAT_Dilithium3.rsp and
+// PQCkemKAT_2400.rsp from the pq-crystals reference implementations);
+// regenerate them with
+//
+//	go test ./pkg/pqc -run KnownAnswers -update
+//
+// The Reference tests rebuild all 100 entries of each file through this
+// package and compare their SHA-256 with the reference implementation's,
+// so a circl upgrade that changes any key, signature or ciphertext fails
+// here rather than in production.
+
+var update = flag.Bool("update", false, "regenerate testdata vectors")
+
+const (
+	vectorCount = 3
+
+	dilithium3ReferenceKAT = "8196b32212753f525346201ffec1c7a0a852596fa0b57bd4e2746231dab44d55"
+	kyber768ReferenceKAT   = "a1e122cad3c24bc51622e4c242d8b8acbcd3f618fee4220400605ca8f9ea02c2"
+)
+
+type hexBytes []byte
+
+func (h hexBytes) MarshalJSON() ([]byte, error) {
+	return json.Marshal(hex.EncodeToString(h))
+}
+
+func (h *hexBytes) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	decoded, err := hex.DecodeString(s)
+	*h = decoded
+	return err
+}
+
+type dilithiumVector struct {
+	Count     int      `json:"count"`
+	Seed      hexBytes `json:"seed"`     // NIST DRBG seed for this entry
+	KeySeed   hexBytes `json:"key_seed"` // DeriveDilithiumKeyPair input
+	Message   hexBytes `json:"msg"`
+	PublicKey hexBytes `json:"pk"`
+	SecretKey hexBytes `json:"sk"`
+	Signature hexBytes `json:"sig"`
+}
+
+type kyberVector struct {
+	Count        int      `json:"count"`
+	Seed         hexBytes `json:"seed"`
+	KeySeed      hexBytes `json:"key_seed"`    // DeriveKyberKeyPair input
+	EncapsSeed   hexBytes `json:"encaps_seed"` // deterministic encapsulation input
+	PublicKey    hexBytes `json:"pk"`
+	SecretKey    hexBytes `json:"sk"`
+	Ciphertext   hexBytes `json:"ct"`
+	SharedSecret hexBytes `json:"ss"`
+}
+
+func TestMain(m *testing.M) {
+	flag.Parse()
+	log.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+func TestDilithium3KnownAnswers(t *testing.T) {
+	path := filepath.Join("testdata", "dilithium3_kat.json")
+	if *update {
+		var vectors []dilithiumVector
+		generateDilithium3KAT(t, vectorCount, nil, func(v dilithiumVector) { vectors = append(vectors, v) })
+		writeVectors(t, path, vectors)
+	}
+
+	var vectors []dilithiumVector
+	readVectors(t, path, &vectors)
+
+	for _, v := range vectors {
+		t.Run(fmt.Sprintf("count=%d", v.Count), func(t *testing.T) {
+			keyPair, err := DeriveDilithiumKeyPair(v.KeySeed)
+			if err != nil {
+				t.Fatal(err)
+			}
+			assertBytes(t, "public key", keyPair.GetPublicKeyBytes(), v.PublicKey)
+			assertBytes(t, "private key", keyPair.GetPrivateKeyBytes(), v.SecretKey)
+
+			loaded, err := LoadDilithiumKeyPair(v.PublicKey, v.SecretKey)
+			if err != nil {
+				t.Fatalf("LoadDilithiumKeyPair: %v", err)
+			}
+			signature, err := loaded.Sign(v.Message)
+			if err != nil {
+				t.Fatal(err)
+			}
+			assertBytes(t, "signature", signature, v.Signature)
+
+			if err := VerifyDilithiumSignature(v.PublicKey, v.Message, v.Signature); err != nil {
+				t.Errorf("reference signature rejected: %v", err)
+			}
+
+			tampered := append([]byte(nil), v.Message...)
+			tampered[0] ^= 0x01
+			if err := VerifyDilithiumSignature(v.PublicKey, tampered, v.Signature); err == nil {
+				t.Error("signature verified over a tampered message")
+			}
+		})
+	}
+}
+
+func TestKyber768KnownAnswers(t *testing.T) {
+	path := filepath.Join("testdata", "kyber768_kat.json")
+	if *update {
+		var vectors []kyberVector
+		generateKyber768KAT(t, vectorCount, nil, func(v kyberVector) { vectors = append(vectors, v) })
+		writeVectors(t, path, vectors)
+	}
+
+	var vectors []kyberVector
+	readVectors(t, path, &vectors)
+
+	for _, v := range vectors {
+		t.Run(fmt.Sprintf("count=%d", v.Count), func(t *testing.T) {
+			keyPair, err := DeriveKyberKeyPair(v.KeySeed)
+			if err != nil {
+				t.Fatal(err)
+			}
+			assertBytes(t, "public key", keyPair.GetPublicKeyBytes(), v.PublicKey)
+			assertBytes(t, "private key", keyPair.GetPrivateKeyBytes(), v.SecretKey)
+
+			loaded, err := LoadKyberKeyPair(v.PublicKey, v.SecretKey)
+			if err != nil {
+				t.Fatalf("LoadKyberKeyPair: %v", err)
+			}
+			sharedSecret, err := loaded.Decapsulate(v.Ciphertext)
+			if err != nil {
+				t.Fatal(err)
+			}
+			assertBytes(t, "decapsulated shared secret", sharedSecret, v.SharedSecret)
+
+			ciphertext, sharedSecret := encapsulateDeterministically(t, v.PublicKey, v.EncapsSeed)
+			assertBytes(t, "ciphertext", ciphertext, v.Ciphertext)
+			assertBytes(t, "encapsulated shared secret", sharedSecret, v.SharedSecret)
+
+			ciphertext, sharedSecret, err = EncapsulateWithPublicKey(v.PublicKey)
+			if err != nil {
+				t.Fatal(err)
+			}
+			decapsulated, err := loaded.Decapsulate(ciphertext)
+			if err != nil {
+				t.Fatal(err)
+			}
+			assertBytes(t, "random encapsulation round trip", decapsulated, sharedSecret)
+		})
+	}
+}
+
+func TestDilithium3ReferenceKAT(t *testing.T) {
+	f := sha256.New()
+	generateDilithium3KAT(t, 100, f, nil)
+	if got := hex.EncodeToString(f.Sum(nil)); got != dilithium3ReferenceKAT {
+		t.Fatalf("Dilithium3 KAT digest = %s, reference implementation gives %s", got, dilithium3ReferenceKAT)
+	}
+}
+
+func TestKyber768ReferenceKAT(t *testing.T) {
+	f := sha256.New()
+	generateKyber768KAT(t, 100, f, nil)
+	if got := hex.EncodeToString(f.Sum(nil)); got != kyber768ReferenceKAT {
+		t.Fatalf("Kyber768 KAT digest = %s, reference implementation gives %s", got, kyber768ReferenceKAT)
+	}
+}
+
+// generateDilithium3KAT reproduces PQCsignKAT_Dilithium3.rsp through this
+// package, writing the file to rsp and passing each entry to emit.
+func generateDilithium3KAT(t *testing.T, count int, rsp hash.Hash, emit func(dilithiumVector)) {
+	var seed [48]byte
+	for i := range seed {
+		seed[i] = byte(i)
+	}
+	drbg := newNISTDRBG(&seed)
+
+	if rsp != nil {
+		fmt.Fprintf(rsp, "# Dilithium3\n\n")
+	}
+	for i := 0; i < count; i++ {
+		drbg.Fill(seed[:])
+		msg := make([]byte, 33*(i+1))
+		drbg.Fill(msg)
+
+		keySeed := make([]byte, DilithiumSeedSize)
+		entropy := newNISTDRBG(&seed)
+		entropy.Fill(keySeed)
+
+		keyPair, err := DeriveDilithiumKeyPair(keySeed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		signature, err := keyPair.Sign(msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if rsp != nil {
+			fmt.Fprintf(rsp, "count = %d\n", i)
+			fmt.Fprintf(rsp, "seed = %X\n", seed)
+			fmt.Fprintf(rsp, "mlen = %d\n", len(msg))
+			fmt.Fprintf(rsp, "msg = %X\n", msg)
+			fmt.Fprintf(rsp, "pk = %X\n", keyPair.GetPublicKeyBytes())
+			fmt.Fprintf(rsp, "sk = %X\n", keyPair.GetPrivateKeyBytes())
+			fmt.Fprintf(rsp, "smlen = %d\n", len(msg)+len(signature))
+			fmt.Fprintf(rsp, "sm = %X%X\n\n", signature, msg)
+		}
+		if emit != nil {
+			emit(dilithiumVector{
+				Count:     i,
+				Seed:      append(hexBytes(nil), seed[:]...),
+				KeySeed:   keySeed,
+				Message:   msg,
+				PublicKey: keyPair.GetPublicKeyBytes(),
+				SecretKey: keyPair.GetPrivateKeyBytes(),
+				Signature: signature,
+			})
+		}
+	}
+}
+
+// generateKyber768KAT reproduces the Kyber768 PQCkemKAT file the same way.
+func generateKyber768KAT(t *testing.T, count int, rsp hash.Hash, emit func(kyberVector)) {
+	var seed [48]byte
+	for i := range seed {
+		seed[i] = byte(i)
+	}
+	drbg := newNISTDRBG(&seed)
+
+	if rsp != nil {
+		fmt.Fprintf(rsp, "# Kyber768\n\n")
+	}
+	for i := 0; i < count; i++ {
+		drbg.Fill(seed[:])
+
+		// The reference keypair draws its randomness in two 32-byte calls.
+		keySeed := make([]byte, KyberSeedSize)
+		encapsSeed := make([]byte, kyber768.EncapsulationSeedSize)
+		entropy := newNISTDRBG(&seed)
+		entropy.Fill(keySeed[:32])
+		entropy.Fill(keySeed[32:])
+		entropy.Fill(encapsSeed)
+
+		keyPair, err := DeriveKyberKeyPair(keySeed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ciphertext, sharedSecret := encapsulateDeterministically(t, keyPair.GetPublicKeyBytes(), encapsSeed)
+
+		decapsulated, err := keyPair.Decapsulate(ciphertext)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(decapsulated, sharedSecret) {
+			t.Fatalf("count %d: decapsulated shared secret does not match", i)
+		}
+
+		if rsp != nil {
+			fmt.Fprintf(rsp, "count = %d\n", i)
+			fmt.Fprintf(rsp, "seed = %X\n", seed)
+			fmt.Fprintf(rsp, "pk = %X\n", keyPair.GetPublicKeyBytes())
+			fmt.Fprintf(rsp, "sk = %X\n", keyPair.GetPrivateKeyBytes())
+			fmt.Fprintf(rsp, "ct = %X\n", ciphertext)
+			fmt.Fprintf(rsp, "ss = %X\n\n", sharedSecret)
+		}
+		if emit != nil {
+			emit(kyberVector{
+				Count:        i,
+				Seed:         append(hexBytes(nil), seed[:]...),
+				KeySeed:      keySeed,
+				EncapsSeed:   encapsSeed,
+				PublicKey:    keyPair.GetPublicKeyBytes(),
+				SecretKey:    keyPair.GetPrivateKeyBytes(),
+				Ciphertext:   ciphertext,
+				SharedSecret: sharedSecret,
+			})
+		}
+	}
+}
+
+// encapsulateDeterministically encapsulates to publicKey with a fixed seed.
+// This package only encapsulates with fresh randomness, so it goes to circl
+// directly.
+func encapsulateDeterministically(t *testing.T, publicKey, seed []byte) ([]byte, []byte) {
+	t.Helper()
+
+	var pk kyber768.PublicKey
+	pk.Unpack(publicKey)
+
+	ciphertext := make([]byte, kyber768.CiphertextSize)
+	sharedSecret := make([]byte, kyber768.SharedKeySize)
+	pk.EncapsulateTo(ciphertext, sharedSecret, seed)
+	return ciphertext, sharedSecret
+}
+
+// nistDRBG is the AES-256 CTR DRBG behind randombytes() in NIST's
+// PQCgenKAT harness.
+type nistDRBG struct {
+	key [32]byte
+	v   [16]byte
+}
+
+func newNISTDRBG(seed *[48]byte) *nistDRBG {
+	g := &nistDRBG{}
+	g.update(seed)
+	return g
+}
+
+func (g *nistDRBG) incrementV() {
+	for j := 15; j >= 0; j-- {
+		g.v[j]++
+		if g.v[j] != 0 {
+			return
+		}
+	}
+}
+
+func (g *nistDRBG) update(data *[48]byte) {
+	var buf [48]byte
+	block, _ := aes.NewCipher(g.key[:])
+	for i := 0; i < 3; i++ {
+		g.incrementV()
+		block.Encrypt(buf[i*16:(i+1)*16], g.v[:])
+	}
+	if data != nil {
+		for i := range buf {
+			buf[i] ^= data[i]
+		}
+	}
+	copy(g.key[:], buf[:32])
+	copy(g.v[:], buf[32:])
+}
+
+// Fill is randombytes(x, len(x)).
+func (g *nistDRBG) Fill(x []byte) {
+	var out [16]byte
+	block, _ := aes.NewCipher(g.key[:])
+	for len(x) > 0 {
+		g.incrementV()
+		block.Encrypt(out[:], g.v[:])
+		x = x[copy(x, out[:]):]
+	}
+	g.update(nil)
+}
+
+func readVectors(t *testing.T, path string, vectors interface{}) {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read vectors (run with -update to generate them): %v", err)
+	}
+	if err := json.Unmarshal(data, vectors); err != nil {
+		t.Fatalf("failed to parse %s: %v", path, err)
+	}
+}
+
+func writeVectors(t *testing.T, path string, vectors interface{}) {
+	t.Helper()
+	data, err := json.MarshalIndent(vectors, "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func assertBytes(t *testing.T, what string, got, want []byte) {
+	t.Helper()
+	if !bytes.Equal(got, want) {
+		t.Errorf("%s mismatch: got %d bytes %.16x..., want %d bytes %.16x...", what, len(got), got, len(want), want)
+	}
+}
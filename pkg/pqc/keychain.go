@@ -0,0 +1,64 @@
+package pqc
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"path/filepath"
+)
+
+// Key stores: where the passphrase encrypting private keys comes from.
+// With the file store it is KeysPassphrase, if any, and private keys are
+// protected by their file permissions alone without one. With the keychain
+// store a random passphrase is generated for each key directory and kept
+// in the OS credential store, so private keys are always encrypted on disk
+// and only the user who owns them can decrypt them. The keys themselves
+// stay in files: credential stores limit their items to a few KB (Windows
+// Credential Manager to 2560 bytes), less than a Dilithium private key.
+const (
+	KeyStoreFile     = "file"
+	KeyStoreKeychain = "keychain"
+)
+
+// KeyStores lists the supported key stores.
+var KeyStores = []string{KeyStoreFile, KeyStoreKeychain}
+
+// keychainService names the mesh's items in the OS credential store; each
+// item's account is the absolute path of the key directory it protects.
+const keychainService = "quantum-safe-mesh"
+
+// errKeychainItemNotFound is returned by keychainGet for a missing item.
+var errKeychainItemNotFound = errors.New("not found in the OS keychain")
+
+// KeychainPassphrase returns the passphrase protecting the private keys in
+// dir, held in the OS credential store: the macOS Keychain, the Secret
+// Service (GNOME Keyring, KWallet) through secret-tool on Linux, or a DPAPI
+// blob only the current Windows user can decrypt. If there is none yet, one
+// is generated and stored when create is set.
+func KeychainPassphrase(dir string, create bool) ([]byte, error) {
+	account, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve key directory: %w", err)
+	}
+
+	passphrase, err := keychainGet(account)
+	if err == nil {
+		return passphrase, nil
+	}
+	if !errors.Is(err, errKeychainItemNotFound) || !create {
+		return nil, fmt.Errorf("failed to read the passphrase for %s from the %s: %w", account, keychainName, err)
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, fmt.Errorf("failed to generate passphrase: %w", err)
+	}
+	passphrase = []byte(hex.EncodeToString(secret))
+	if err := keychainSet(account, passphrase); err != nil {
+		return nil, fmt.Errorf("failed to store the passphrase for %s in the %s: %w", account, keychainName, err)
+	}
+	log.Printf("🔐 Generated the passphrase for keys in %s and stored it in the %s", account, keychainName)
+	return passphrase, nil
+}
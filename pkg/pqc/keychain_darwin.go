@@ -0,0 +1,44 @@
+//go:build darwin
+
+package pqc
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+const keychainName = "macOS Keychain"
+
+// security exits with this status when an item does not exist.
+const securityItemNotFound = 44
+
+func keychainGet(account string) ([]byte, error) {
+	out, err := exec.Command("/usr/bin/security", "find-generic-password", "-s", keychainService, "-a", account, "-w").Output()
+	if err != nil {
+		var exit *exec.ExitError
+		if errors.As(err, &exit) && exit.ExitCode() == securityItemNotFound {
+			return nil, errKeychainItemNotFound
+		}
+		return nil, err
+	}
+	return bytes.TrimRight(out, "\n"), nil
+}
+
+// keychainSet adds the item through security's interactive mode, so the
+// secret is read from stdin rather than appearing in its arguments.
+func keychainSet(account string, secret []byte) error {
+	cmd := exec.Command("/usr/bin/security", "-i")
+	cmd.Stdin = strings.NewReader(fmt.Sprintf("add-generic-password -U -s %s -a %s -w %s\n",
+		shellQuote(keychainService), shellQuote(account), shellQuote(string(secret))))
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%w: %s", err, bytes.TrimSpace(out))
+	}
+	return nil
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,43 @@
+//go:build linux
+
+package pqc
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os/exec"
+)
+
+const keychainName = "Secret Service"
+
+func keychainGet(account string) ([]byte, error) {
+	out, err := exec.Command("secret-tool", "lookup", "service", keychainService, "account", account).Output()
+	if errors.Is(err, exec.ErrNotFound) {
+		return nil, errSecretToolMissing
+	}
+	// secret-tool exits 1 without output for a missing item.
+	var exit *exec.ExitError
+	if errors.As(err, &exit) && len(exit.Stderr) == 0 && len(out) == 0 {
+		return nil, errKeychainItemNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func keychainSet(account string, secret []byte) error {
+	cmd := exec.Command("secret-tool", "store", "--label", "Quantum-safe mesh keys in "+account, "service", keychainService, "account", account)
+	cmd.Stdin = bytes.NewReader(secret)
+	out, err := cmd.CombinedOutput()
+	if errors.Is(err, exec.ErrNotFound) {
+		return errSecretToolMissing
+	}
+	if err != nil {
+		return fmt.Errorf("%w: %s", err, bytes.TrimSpace(out))
+	}
+	return nil
+}
+
+var errSecretToolMissing = errors.New("secret-tool not found: install libsecret-tools and run a Secret Service such as GNOME Keyring")
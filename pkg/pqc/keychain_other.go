@@ -0,0 +1,18 @@
+//go:build !darwin && !linux && !windows
+
+package pqc
+
+import (
+	"fmt"
+	"runtime"
+)
+
+const keychainName = "OS keychain"
+
+func keychainGet(string) ([]byte, error) {
+	return nil, fmt.Errorf("no OS keychain support on %s", runtime.GOOS)
+}
+
+func keychainSet(string, []byte) error {
+	return fmt.Errorf("no OS keychain support on %s", runtime.GOOS)
+}
@@ -0,0 +1,62 @@
+//go:build windows
+
+package pqc
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+const keychainName = "Windows Data Protection API"
+
+// keychainFile is the DPAPI blob holding a key directory's passphrase,
+// kept in the directory itself: only the Windows user who wrote it can
+// decrypt it, on this machine.
+const keychainFile = "keystore.dpapi"
+
+func keychainGet(account string) ([]byte, error) {
+	blob, err := os.ReadFile(filepath.Join(account, keychainFile))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, errKeychainItemNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	in := dataBlob(blob)
+	entropy := dataBlob([]byte(account))
+	var out windows.DataBlob
+	if err := windows.CryptUnprotectData(&in, nil, &entropy, 0, nil, windows.CRYPTPROTECT_UI_FORBIDDEN, &out); err != nil {
+		return nil, fmt.Errorf("failed to decrypt %s: %w", keychainFile, err)
+	}
+	defer windows.LocalFree(windows.Handle(unsafe.Pointer(out.Data)))
+	return append([]byte(nil), unsafe.Slice(out.Data, out.Size)...), nil
+}
+
+func keychainSet(account string, secret []byte) error {
+	in := dataBlob(secret)
+	entropy := dataBlob([]byte(account))
+	var out windows.DataBlob
+	if err := windows.CryptProtectData(&in, nil, &entropy, 0, nil, windows.CRYPTPROTECT_UI_FORBIDDEN, &out); err != nil {
+		return fmt.Errorf("failed to encrypt passphrase: %w", err)
+	}
+	defer windows.LocalFree(windows.Handle(unsafe.Pointer(out.Data)))
+
+	if err := os.MkdirAll(account, 0700); err != nil {
+		return err
+	}
+	return writeKeyFile(filepath.Join(account, keychainFile), unsafe.Slice(out.Data, out.Size), 0600)
+}
+
+func dataBlob(data []byte) windows.DataBlob {
+	if len(data) == 0 {
+		return windows.DataBlob{}
+	}
+	return windows.DataBlob{Size: uint32(len(data)), Data: &data[0]}
+}
@@ -0,0 +1,116 @@
+package pqc
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// KeyMaxAge is how long keys SaveKeyPair writes are valid for; zero keeps
+// them forever.
+var KeyMaxAge time.Duration
+
+// KeyMetadata records when a service's signing key was created and when it
+// expires, and the algorithms of its keys. SaveKeyPair writes it next to
+// the keys.
+type KeyMetadata struct {
+	Algorithm    string    `json:"algorithm"`
+	KEMAlgorithm string    `json:"kem_algorithm,omitempty"` // empty means kyber768, in metadata written before it was recorded
+	Fingerprint  string    `json:"fingerprint"`
+	CreatedAt    time.Time `json:"created_at"`
+	ExpiresAt    time.Time `json:"expires_at,omitempty"`
+}
+
+// KeyMetadataFileName returns the file name serviceID's key metadata is
+// stored under.
+func KeyMetadataFileName(serviceID string) string {
+	return serviceID + "_key.json"
+}
+
+// Expiry returns when the key expires: its recorded expiry or maxAge after
+// its creation, whichever comes first. The zero time means never.
+func (m *KeyMetadata) Expiry(maxAge time.Duration) time.Time {
+	expiry := m.ExpiresAt
+	if maxAge > 0 {
+		if byAge := m.CreatedAt.Add(maxAge); expiry.IsZero() || byAge.Before(expiry) {
+			expiry = byAge
+		}
+	}
+	return expiry
+}
+
+// newKeyMetadata dates a key created now.
+func newKeyMetadata(signer Signer) *KeyMetadata {
+	metadata := &KeyMetadata{
+		Algorithm:   signer.Algorithm(),
+		Fingerprint: KeyFingerprint(signer.GetPublicKeyBytes()),
+		CreatedAt:   time.Now().UTC().Truncate(time.Second),
+	}
+	if KeyMaxAge > 0 {
+		metadata.ExpiresAt = metadata.CreatedAt.Add(KeyMaxAge)
+	}
+	return metadata
+}
+
+func saveKeyMetadata(serviceID string, metadata *KeyMetadata) error {
+	data, err := json.MarshalIndent(metadata, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode key metadata: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(KeysDir, KeyMetadataFileName(serviceID)), append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to save key metadata: %w", err)
+	}
+	return nil
+}
+
+// LoadKeyMetadata reads the metadata of serviceID's signing key, whose
+// public key is publicKey. Keys saved before metadata was recorded, or put
+// in place by other means, have none or a stale one; their public key file's
+// modification time stands in for the creation time. Keys in KeystoreFile
+// always have theirs.
+func LoadKeyMetadata(serviceID string, publicKey []byte) (*KeyMetadata, error) {
+	fingerprint := KeyFingerprint(publicKey)
+	if KeystoreFile != "" {
+		entry, err := keystoreEntryFor(serviceID)
+		if err != nil {
+			return nil, err
+		}
+		if entry.Metadata == nil || entry.Metadata.Fingerprint != fingerprint {
+			return nil, fmt.Errorf("keystore %s has no metadata for the key %s of %s", KeystoreFile, fingerprint, serviceID)
+		}
+		return entry.Metadata, nil
+	}
+
+	data, err := os.ReadFile(filepath.Join(KeysDir, KeyMetadataFileName(serviceID)))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("failed to read key metadata: %w", err)
+	}
+	if err == nil {
+		var metadata KeyMetadata
+		if err := json.Unmarshal(data, &metadata); err != nil {
+			return nil, fmt.Errorf("failed to decode key metadata: %w", err)
+		}
+		if metadata.Fingerprint == fingerprint {
+			return &metadata, nil
+		}
+	}
+
+	algorithm, err := StoredSignatureAlgorithm(serviceID)
+	if err != nil {
+		return nil, err
+	}
+	signerPub, _ := SignatureKeyFileNames(serviceID, algorithm)
+	info, err := os.Stat(filepath.Join(KeysDir, signerPub))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s public key: %w", algorithm, err)
+	}
+	return &KeyMetadata{
+		Algorithm:   algorithm,
+		Fingerprint: fingerprint,
+		CreatedAt:   info.ModTime().UTC().Truncate(time.Second),
+	}, nil
+}
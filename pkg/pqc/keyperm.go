@@ -0,0 +1,16 @@
+package pqc
+
+import (
+	"os"
+)
+
+// writeKeyFile writes data to path, restricting it to perm even if the file
+// already existed with looser permissions. On Windows, where file modes are
+// not enforced, a file perm keeps from other users gets an ACL granting only
+// its owner (and SYSTEM) access.
+func writeKeyFile(path string, data []byte, perm os.FileMode) error {
+	if err := os.WriteFile(path, data, perm); err != nil {
+		return err
+	}
+	return restrictFile(path, perm)
+}
@@ -0,0 +1,24 @@
+//go:build !windows
+
+package pqc
+
+import (
+	"log"
+	"os"
+)
+
+func restrictFile(path string, perm os.FileMode) error {
+	return os.Chmod(path, perm)
+}
+
+// checkPrivateKeyFile warns about a private key file other users can read
+// or write.
+func checkPrivateKeyFile(path string) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return
+	}
+	if mode := info.Mode().Perm(); mode&0o077 != 0 {
+		log.Printf("⚠️  Private key %s is accessible to other users (mode %04o); restrict it with chmod 600", path, mode)
+	}
+}
@@ -0,0 +1,62 @@
+//go:build windows
+
+package pqc
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// restrictFile replaces the inherited ACL of a file perm keeps from other
+// users with one granting full control to the current user and SYSTEM only.
+func restrictFile(path string, perm os.FileMode) error {
+	if perm&0o077 != 0 {
+		return nil
+	}
+	dacl, err := ownerOnlyACL()
+	if err != nil {
+		return err
+	}
+	err = windows.SetNamedSecurityInfo(path, windows.SE_FILE_OBJECT,
+		windows.DACL_SECURITY_INFORMATION|windows.PROTECTED_DACL_SECURITY_INFORMATION, nil, nil, dacl, nil)
+	if err != nil {
+		return fmt.Errorf("failed to restrict access to %s: %w", path, err)
+	}
+	return nil
+}
+
+func ownerOnlyACL() (*windows.ACL, error) {
+	user, err := windows.GetCurrentProcessToken().GetTokenUser()
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up the current user: %w", err)
+	}
+	sd, err := windows.SecurityDescriptorFromString("D:P(A;;FA;;;" + user.User.Sid.String() + ")(A;;FA;;;SY)")
+	if err != nil {
+		return nil, fmt.Errorf("failed to build key file ACL: %w", err)
+	}
+	dacl, _, err := sd.DACL()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build key file ACL: %w", err)
+	}
+	return dacl, nil
+}
+
+// checkPrivateKeyFile warns about a private key file whose ACL was not
+// restricted to its owner, as writeKeyFile leaves it: one that still
+// inherits access from its directory.
+func checkPrivateKeyFile(path string) {
+	sd, err := windows.GetNamedSecurityInfo(path, windows.SE_FILE_OBJECT, windows.DACL_SECURITY_INFORMATION)
+	if err != nil {
+		return
+	}
+	control, _, err := sd.Control()
+	if err != nil {
+		return
+	}
+	if control&windows.SE_DACL_PROTECTED == 0 {
+		log.Printf("⚠️  Private key %s inherits its directory's ACL and may be readable by other users; rewrite it with 'meshctl convert' to restrict it", path)
+	}
+}
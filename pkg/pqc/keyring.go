@@ -0,0 +1,207 @@
+package pqc
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// KeyState is what a keyring key may be used for.
+type KeyState int
+
+const (
+	// KeyActive keys sign and verify.
+	KeyActive KeyState = iota
+	// KeyRetiring keys are being rotated out: they still verify, and only
+	// sign if the keyring's selector picks them.
+	KeyRetiring
+)
+
+func (s KeyState) String() string {
+	if s == KeyRetiring {
+		return "retiring"
+	}
+	return "active"
+}
+
+// KeyringEntry is one signing key in a keyring.
+type KeyringEntry struct {
+	Name    string // identity the key belongs to, e.g. a service or tenant ID
+	KeyID   string // fingerprint of the public key
+	Signer  Signer
+	State   KeyState
+	AddedAt time.Time
+}
+
+// KeySelector picks the key to sign with from a name's keys, oldest first,
+// or returns nil if none may sign.
+type KeySelector func(entries []*KeyringEntry) *KeyringEntry
+
+// SelectActive signs with the newest active key. It is the default.
+func SelectActive(entries []*KeyringEntry) *KeyringEntry {
+	for i := len(entries) - 1; i >= 0; i-- {
+		if entries[i].State == KeyActive {
+			return entries[i]
+		}
+	}
+	return nil
+}
+
+// SelectRetiring keeps signing with the oldest retiring key while there is
+// one, and with the newest active key after that. It suits staged rollouts,
+// where a new key is added before every peer trusts it.
+func SelectRetiring(entries []*KeyringEntry) *KeyringEntry {
+	for _, entry := range entries {
+		if entry.State == KeyRetiring {
+			return entry
+		}
+	}
+	return SelectActive(entries)
+}
+
+// Keyring holds the signing keys of one or more identities in a process,
+// such as a service's active and retiring keys during a rotation, or the
+// keys of each virtual service a gateway signs for. Keys are found by name
+// to sign and by key ID to verify.
+type Keyring struct {
+	mutex    sync.RWMutex
+	byName   map[string][]*KeyringEntry // oldest first
+	byKeyID  map[string]*KeyringEntry
+	selector KeySelector
+}
+
+func NewKeyring() *Keyring {
+	return &Keyring{
+		byName:   make(map[string][]*KeyringEntry),
+		byKeyID:  make(map[string]*KeyringEntry),
+		selector: SelectActive,
+	}
+}
+
+// LoadKeyring loads the current signing key of each of names from the key
+// store as an active key.
+func LoadKeyring(names ...string) (*Keyring, error) {
+	keyring := NewKeyring()
+	for _, name := range names {
+		signer, _, err := LoadKeyPair(name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load keys for %s: %w", name, err)
+		}
+		keyring.Add(name, signer, KeyActive)
+	}
+	return keyring, nil
+}
+
+// SetSelector changes how the key to sign with is picked.
+func (k *Keyring) SetSelector(selector KeySelector) {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	k.selector = selector
+}
+
+// Add adds signer to name's keys in state. A key already in the keyring
+// only has its state updated.
+func (k *Keyring) Add(name string, signer Signer, state KeyState) *KeyringEntry {
+	keyID := KeyFingerprint(signer.GetPublicKeyBytes())
+
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	if entry, exists := k.byKeyID[keyID]; exists {
+		entry.State = state
+		return entry
+	}
+	entry := &KeyringEntry{Name: name, KeyID: keyID, Signer: signer, State: state, AddedAt: time.Now()}
+	k.byName[name] = append(k.byName[name], entry)
+	k.byKeyID[keyID] = entry
+	return entry
+}
+
+// Rotate adds newKey as name's active key and marks its other keys
+// retiring.
+func (k *Keyring) Rotate(name string, newKey Signer) *KeyringEntry {
+	k.mutex.Lock()
+	for _, entry := range k.byName[name] {
+		entry.State = KeyRetiring
+	}
+	k.mutex.Unlock()
+	return k.Add(name, newKey, KeyActive)
+}
+
+// Retire marks the key keyID retiring.
+func (k *Keyring) Retire(keyID string) error {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	entry, exists := k.byKeyID[keyID]
+	if !exists {
+		return fmt.Errorf("key %s is not in the keyring", keyID)
+	}
+	entry.State = KeyRetiring
+	return nil
+}
+
+// Remove drops the key keyID, e.g. once a retiring key's grace period is
+// over.
+func (k *Keyring) Remove(keyID string) {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	entry, exists := k.byKeyID[keyID]
+	if !exists {
+		return
+	}
+	delete(k.byKeyID, keyID)
+	entries := k.byName[entry.Name]
+	for i, other := range entries {
+		if other == entry {
+			entries = append(entries[:i:i], entries[i+1:]...)
+			break
+		}
+	}
+	if len(entries) == 0 {
+		delete(k.byName, entry.Name)
+	} else {
+		k.byName[entry.Name] = entries
+	}
+}
+
+// Lookup returns the key keyID, whatever its state.
+func (k *Keyring) Lookup(keyID string) (*KeyringEntry, bool) {
+	k.mutex.RLock()
+	defer k.mutex.RUnlock()
+	entry, exists := k.byKeyID[keyID]
+	return entry, exists
+}
+
+// Signer returns the key the selector picks to sign as name.
+func (k *Keyring) Signer(name string) (Signer, error) {
+	k.mutex.RLock()
+	defer k.mutex.RUnlock()
+	entries := k.byName[name]
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("no keys for %s in the keyring", name)
+	}
+	entry := k.selector(entries)
+	if entry == nil {
+		return nil, fmt.Errorf("no key of %s may sign", name)
+	}
+	return entry.Signer, nil
+}
+
+// Entries returns name's keys, oldest first.
+func (k *Keyring) Entries(name string) []*KeyringEntry {
+	k.mutex.RLock()
+	defer k.mutex.RUnlock()
+	return append([]*KeyringEntry(nil), k.byName[name]...)
+}
+
+// Names returns the identities with keys in the keyring, sorted.
+func (k *Keyring) Names() []string {
+	k.mutex.RLock()
+	defer k.mutex.RUnlock()
+	names := make([]string, 0, len(k.byName))
+	for name := range k.byName {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
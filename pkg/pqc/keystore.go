@@ -0,0 +1,354 @@
+package pqc
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/cloudflare/circl/kem/kyber/kyber768"
+	"github.com/cloudflare/circl/sign/dilithium/mode3"
+	"github.com/cloudflare/circl/sign/mldsa/mldsa65"
+	"github.com/cloudflare/circl/sign/slhdsa"
+	"golang.org/x/crypto/scrypt"
+)
+
+// Key store formats. Raw files hold the bare key bytes. PEM files wrap them
+// in a block naming the algorithm, and are the only format that can hold an
+// encrypted private key or say which SLH-DSA parameter set a key is for:
+// such keys are written as PEM whatever KeysFormat says. OQS files are the
+// PEM SubjectPublicKeyInfo and PKCS #8 keys OpenSSL's oqs-provider uses, so
+// openssl can use the mesh's keys and the mesh keys openssl generated.
+const (
+	KeyFormatRaw = "raw"
+	KeyFormatPEM = "pem"
+	KeyFormatOQS = "oqs"
+)
+
+// KeyFormats lists the supported key store formats.
+var KeyFormats = []string{KeyFormatRaw, KeyFormatPEM, KeyFormatOQS}
+
+// Algorithms keys can be generated for.
+const (
+	AlgorithmDilithium3 = "dilithium3"
+	AlgorithmKyber768   = "kyber768"
+)
+
+// SignatureAlgorithms and KEMAlgorithms list the algorithms of each kind.
+var (
+	SignatureAlgorithms = append([]string{AlgorithmDilithium3, AlgorithmMLDSA65}, SLHDSAAlgorithms...)
+	KEMAlgorithms       = []string{AlgorithmKyber768, AlgorithmMLKEM768}
+)
+
+// KeysFormat is the format SaveKeyPair writes. LoadKeyPair reads any format.
+var KeysFormat = KeyFormatRaw
+
+// KeysPassphrase, when set, encrypts the private keys SaveKeyPair writes and
+// decrypts the encrypted keys LoadKeyPair reads.
+var KeysPassphrase []byte
+
+// Key kinds, the suffix of a PEM block type.
+const (
+	publicKeyKind  = "PUBLIC KEY"
+	privateKeyKind = "PRIVATE KEY"
+)
+
+const encryptedPrefix = "ENCRYPTED "
+
+// scrypt parameters for new encrypted keys. They are recorded in each key's
+// KDF header, so they can be raised without breaking existing keys.
+const (
+	scryptN       = 1 << 15
+	scryptR       = 8
+	scryptP       = 1
+	scryptMaxN    = 1 << 22
+	scryptSaltLen = 16
+)
+
+func pemBlockType(algorithm, kind string) string {
+	return strings.ToUpper(algorithm) + " " + kind
+}
+
+// encodeKey encodes key for its file: a private key is encrypted if
+// passphrase is set, and otherwise the key is written in KeysFormat.
+// ML-DSA, SLH-DSA and ML-KEM keys are always PEM: ML-DSA-65 and ML-KEM-768
+// keys are the sizes of Dilithium3's and Kyber768's, SLH-DSA parameter sets
+// share key sizes, and none has an oqs-provider format here.
+func encodeKey(algorithm, kind string, key, passphrase []byte) ([]byte, error) {
+	blockType := pemBlockType(algorithm, kind)
+	if kind == privateKeyKind && len(passphrase) > 0 {
+		block, err := encryptKey(blockType, key, passphrase)
+		if err != nil {
+			return nil, err
+		}
+		return pem.EncodeToMemory(block), nil
+	}
+
+	format := KeysFormat
+	if algorithm == AlgorithmMLDSA65 || algorithm == AlgorithmMLKEM768 || IsSLHDSA(algorithm) {
+		format = KeyFormatPEM
+	}
+	switch format {
+	case KeyFormatRaw:
+		return key, nil
+	case KeyFormatOQS:
+		return encodeOQSKey(algorithm, kind, key)
+	case KeyFormatPEM:
+		return pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: key}), nil
+	}
+	return nil, fmt.Errorf("unknown key format %q", KeysFormat)
+}
+
+// decodeKey reverses encodeKey, recognising PEM by its armor. A PEM key
+// must be the algorithm and kind expected of its file.
+func decodeKey(algorithm, kind string, data []byte) ([]byte, error) {
+	if !bytes.HasPrefix(bytes.TrimSpace(data), []byte("-----BEGIN ")) {
+		return data, nil
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("invalid PEM key")
+	}
+
+	blockType := pemBlockType(algorithm, kind)
+	switch block.Type {
+	case blockType:
+		return block.Bytes, nil
+	case kind:
+		keyAlgorithm, key, err := decodeOQSKey(block, kind)
+		if err != nil {
+			return nil, err
+		}
+		if keyAlgorithm != algorithm {
+			return nil, fmt.Errorf("key is a %s %s, expected a %s", keyAlgorithm, strings.ToLower(kind), algorithm)
+		}
+		return key, nil
+	case encryptedPrefix + blockType:
+		if len(KeysPassphrase) == 0 {
+			return nil, fmt.Errorf("key is encrypted and no passphrase is set (KEYS_PASSPHRASE or KEYS_STORE=keychain)")
+		}
+		return decryptKey(block, blockType, KeysPassphrase)
+	}
+	return nil, fmt.Errorf("key is a %s, expected a %s", block.Type, blockType)
+}
+
+// encryptKey seals key with AES-256-GCM under a key derived from passphrase
+// by scrypt. The block type is authenticated so an encrypted key cannot be
+// relabelled as another algorithm's.
+func encryptKey(blockType string, key, passphrase []byte) (*pem.Block, error) {
+	salt := make([]byte, scryptSaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, fmt.Errorf("failed to generate salt: %w", err)
+	}
+
+	aead, err := keyEncryptionAEAD(passphrase, salt, scryptN, scryptR, scryptP)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+
+	return &pem.Block{
+		Type: encryptedPrefix + blockType,
+		Headers: map[string]string{
+			"KDF":    fmt.Sprintf("scrypt N=%d r=%d p=%d", scryptN, scryptR, scryptP),
+			"Salt":   hex.EncodeToString(salt),
+			"Cipher": "AES-256-GCM",
+			"Nonce":  hex.EncodeToString(nonce),
+		},
+		Bytes: aead.Seal(nil, nonce, key, []byte(blockType)),
+	}, nil
+}
+
+func decryptKey(block *pem.Block, blockType string, passphrase []byte) ([]byte, error) {
+	var n, r, p int
+	if _, err := fmt.Sscanf(block.Headers["KDF"], "scrypt N=%d r=%d p=%d", &n, &r, &p); err != nil {
+		return nil, fmt.Errorf("unsupported key KDF %q", block.Headers["KDF"])
+	}
+	if n > scryptMaxN {
+		return nil, fmt.Errorf("key KDF cost N=%d exceeds the maximum %d", n, scryptMaxN)
+	}
+	if cipherName := block.Headers["Cipher"]; cipherName != "AES-256-GCM" {
+		return nil, fmt.Errorf("unsupported key cipher %q", cipherName)
+	}
+
+	salt, err := hex.DecodeString(block.Headers["Salt"])
+	if err != nil {
+		return nil, fmt.Errorf("invalid key salt: %w", err)
+	}
+	nonce, err := hex.DecodeString(block.Headers["Nonce"])
+	if err != nil {
+		return nil, fmt.Errorf("invalid key nonce: %w", err)
+	}
+
+	aead, err := keyEncryptionAEAD(passphrase, salt, n, r, p)
+	if err != nil {
+		return nil, err
+	}
+	if len(nonce) != aead.NonceSize() {
+		return nil, fmt.Errorf("invalid key nonce size %d", len(nonce))
+	}
+
+	key, err := aead.Open(nil, nonce, block.Bytes, []byte(blockType))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt key: wrong passphrase or corrupted file")
+	}
+	return key, nil
+}
+
+func keyEncryptionAEAD(passphrase, salt []byte, n, r, p int) (cipher.AEAD, error) {
+	derived, err := scrypt.Key(passphrase, salt, n, r, p, 32)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive key encryption key: %w", err)
+	}
+	block, err := aes.NewCipher(derived)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+// KeyFile describes a key file as found on disk. PEM files name their
+// algorithm; raw files are identified by size, which is why ML-DSA and
+// SLH-DSA keys are never raw.
+type KeyFile struct {
+	Algorithm string
+	Private   bool
+	Format    string
+	Encrypted bool
+	Headers   map[string]string // PEM headers, e.g. an encrypted key's KDF
+	Key       []byte            // nil until an encrypted key is decrypted
+
+	block *pem.Block
+}
+
+var rawKeySizes = map[int]KeyFile{
+	mode3.PublicKeySize:     {Algorithm: AlgorithmDilithium3},
+	mode3.PrivateKeySize:    {Algorithm: AlgorithmDilithium3, Private: true},
+	kyber768.PublicKeySize:  {Algorithm: AlgorithmKyber768},
+	kyber768.PrivateKeySize: {Algorithm: AlgorithmKyber768, Private: true},
+}
+
+// ParseKeyFile identifies the key in data. Encrypted keys are left
+// encrypted; see Decrypt.
+func ParseKeyFile(data []byte) (*KeyFile, error) {
+	if !bytes.HasPrefix(bytes.TrimSpace(data), []byte("-----BEGIN ")) {
+		keyFile, known := rawKeySizes[len(data)]
+		if !known {
+			return nil, fmt.Errorf("not a key: %d bytes is not the size of any supported raw key", len(data))
+		}
+		keyFile.Format = KeyFormatRaw
+		keyFile.Key = data
+		return &keyFile, nil
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("invalid PEM key")
+	}
+
+	if block.Type == pemTypePublicKey || block.Type == pemTypePrivateKey {
+		algorithm, key, err := decodeOQSKey(block, block.Type)
+		if err != nil {
+			return nil, err
+		}
+		return &KeyFile{Algorithm: algorithm, Private: block.Type == pemTypePrivateKey, Format: KeyFormatOQS, Key: key}, nil
+	}
+
+	keyFile := &KeyFile{Format: KeyFormatPEM, Headers: block.Headers, block: block}
+	blockType, encrypted := strings.CutPrefix(block.Type, encryptedPrefix)
+	algorithm, private := strings.CutSuffix(blockType, " "+privateKeyKind)
+	if !private {
+		var public bool
+		if algorithm, public = strings.CutSuffix(blockType, " "+publicKeyKind); !public {
+			return nil, fmt.Errorf("not a key: PEM block is a %s", block.Type)
+		}
+	}
+	keyFile.Algorithm = strings.ToLower(algorithm)
+	keyFile.Private = private
+	keyFile.Encrypted = encrypted
+	if !encrypted {
+		keyFile.Key = block.Bytes
+	}
+
+	if !slices.Contains(SignatureAlgorithms, keyFile.Algorithm) && !slices.Contains(KEMAlgorithms, keyFile.Algorithm) {
+		return nil, fmt.Errorf("unsupported key algorithm %q", keyFile.Algorithm)
+	}
+	return keyFile, nil
+}
+
+// Decrypt decrypts an encrypted key with passphrase, setting Key.
+func (k *KeyFile) Decrypt(passphrase []byte) error {
+	if !k.Encrypted || k.Key != nil {
+		return nil
+	}
+	key, err := decryptKey(k.block, strings.TrimPrefix(k.block.Type, encryptedPrefix), passphrase)
+	if err != nil {
+		return err
+	}
+	k.Key = key
+	return nil
+}
+
+// PublicKey returns the key's public key, deriving it from a private key.
+func (k *KeyFile) PublicKey() ([]byte, error) {
+	if k.Key == nil {
+		return nil, fmt.Errorf("key is encrypted")
+	}
+	if !k.Private {
+		return k.Key, nil
+	}
+
+	return derivePublicKey(k.Algorithm, k.Key)
+}
+
+// derivePublicKey returns the public key of an algorithm private key.
+func derivePublicKey(algorithm string, privateKeyBytes []byte) ([]byte, error) {
+	switch algorithm {
+	case AlgorithmDilithium3:
+		var privateKey mode3.PrivateKey
+		if err := privateKey.UnmarshalBinary(privateKeyBytes); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal private key: %w", err)
+		}
+		return privateKey.Public().(*mode3.PublicKey).Bytes(), nil
+	case AlgorithmMLDSA65:
+		var privateKey mldsa65.PrivateKey
+		if err := privateKey.UnmarshalBinary(privateKeyBytes); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal private key: %w", err)
+		}
+		return privateKey.Public().(*mldsa65.PublicKey).Bytes(), nil
+	}
+	if provider, ok := kemProviders[algorithm]; ok {
+		return provider.publicKeyOf(privateKeyBytes)
+	}
+	if IsSLHDSA(algorithm) {
+		id, _ := slhdsaID(algorithm)
+		privateKey := slhdsa.PrivateKey{ID: id}
+		if err := privateKey.UnmarshalBinary(privateKeyBytes); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal private key: %w", err)
+		}
+		return privateKey.PublicKey().MarshalBinary()
+	}
+	return nil, fmt.Errorf("unsupported key algorithm %q", algorithm)
+}
+
+// oqsKeySizes returns the private and public key sizes of an algorithm
+// with an oqs-provider format.
+func oqsKeySizes(algorithm string) (privateSize, publicSize int) {
+	switch algorithm {
+	case AlgorithmDilithium3:
+		return mode3.PrivateKeySize, mode3.PublicKeySize
+	case AlgorithmKyber768:
+		return kyber768.PrivateKeySize, kyber768.PublicKeySize
+	}
+	return 0, 0
+}
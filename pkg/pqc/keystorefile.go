@@ -0,0 +1,203 @@
+package pqc
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// KeystoreFile, when set, names an encrypted keystore holding the keys of
+// any number of services in one file. SaveKeyPair and LoadKeyPair then use
+// it instead of the key files in KeysDir. It is encrypted under
+// KeysPassphrase, which it cannot do without.
+var KeystoreFile string
+
+const pemTypeKeystore = "MESH KEYSTORE"
+
+// keystoreMu serialises the read-modify-write of saving to the keystore
+// within a process, such as cmd/mesh generating keys for several services.
+var keystoreMu sync.Mutex
+
+type keystoreContents struct {
+	Services map[string]*keystoreEntry `json:"services"`
+}
+
+type keystoreEntry struct {
+	Algorithm     string       `json:"algorithm"`
+	PublicKey     []byte       `json:"public_key"`
+	PrivateKey    []byte       `json:"private_key"`
+	KEMAlgorithm  string       `json:"kem_algorithm"`
+	KEMPublicKey  []byte       `json:"kem_public_key"`
+	KEMPrivateKey []byte       `json:"kem_private_key"`
+	Metadata      *KeyMetadata `json:"metadata"`
+}
+
+// readKeystore decrypts KeystoreFile. A keystore that does not exist yet is
+// empty.
+func readKeystore() (*keystoreContents, error) {
+	contents := &keystoreContents{Services: map[string]*keystoreEntry{}}
+	data, err := os.ReadFile(KeystoreFile)
+	if errors.Is(err, fs.ErrNotExist) {
+		return contents, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read keystore: %w", err)
+	}
+	checkPrivateKeyFile(KeystoreFile)
+
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != encryptedPrefix+pemTypeKeystore {
+		return nil, fmt.Errorf("%s is not an encrypted %s", KeystoreFile, pemTypeKeystore)
+	}
+	if len(KeysPassphrase) == 0 {
+		return nil, fmt.Errorf("keystore %s is encrypted and no passphrase is set (KEYS_PASSPHRASE or KEYS_STORE=keychain)", KeystoreFile)
+	}
+	payload, err := decryptKey(block, pemTypeKeystore, KeysPassphrase)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open keystore %s: %w", KeystoreFile, err)
+	}
+	defer clear(payload)
+
+	if err := json.Unmarshal(payload, contents); err != nil {
+		return nil, fmt.Errorf("failed to decode keystore: %w", err)
+	}
+	if contents.Services == nil {
+		contents.Services = map[string]*keystoreEntry{}
+	}
+	return contents, nil
+}
+
+// writeKeystore encrypts contents to KeystoreFile, replacing it in one
+// rename so a failed write leaves the previous keystore intact.
+func writeKeystore(contents *keystoreContents) error {
+	if len(KeysPassphrase) == 0 {
+		return fmt.Errorf("keystore %s needs a passphrase to be encrypted with (KEYS_PASSPHRASE or KEYS_STORE=keychain)", KeystoreFile)
+	}
+	payload, err := json.Marshal(contents)
+	if err != nil {
+		return fmt.Errorf("failed to encode keystore: %w", err)
+	}
+	defer clear(payload)
+
+	block, err := encryptKey(pemTypeKeystore, payload, KeysPassphrase)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(KeystoreFile), 0700); err != nil {
+		return fmt.Errorf("failed to create keystore directory: %w", err)
+	}
+	temp := KeystoreFile + ".tmp"
+	if err := writeKeyFile(temp, pem.EncodeToMemory(block), 0600); err != nil {
+		return fmt.Errorf("failed to save keystore: %w", err)
+	}
+	if err := os.Rename(temp, KeystoreFile); err != nil {
+		os.Remove(temp)
+		return fmt.Errorf("failed to save keystore: %w", err)
+	}
+	return nil
+}
+
+// keystoreEntryFor returns serviceID's keys in KeystoreFile, wrapping
+// fs.ErrNotExist if it has none so callers treat it as missing key files.
+func keystoreEntryFor(serviceID string) (*keystoreEntry, error) {
+	contents, err := readKeystore()
+	if err != nil {
+		return nil, err
+	}
+	entry, ok := contents.Services[serviceID]
+	if !ok {
+		return nil, fmt.Errorf("no keys for %s in keystore %s: %w", serviceID, KeystoreFile, fs.ErrNotExist)
+	}
+	return entry, nil
+}
+
+func loadKeystoreKeyPair(serviceID string) (Signer, *KyberKeyPair, error) {
+	entry, err := keystoreEntryFor(serviceID)
+	if err != nil {
+		return nil, nil, err
+	}
+	signer, err := LoadSigner(entry.Algorithm, entry.PublicKey, entry.PrivateKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load %s keypair: %w", entry.Algorithm, err)
+	}
+	kyberKeyPair, err := LoadKEMKeyPair(entry.KEMAlgorithm, entry.KEMPublicKey, entry.KEMPrivateKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load %s keypair: %w", entry.KEMAlgorithm, err)
+	}
+
+	log.Printf("✅ Keys loaded for service %s (%s) from keystore %s", serviceID, entry.Algorithm, KeystoreFile)
+	return signer, kyberKeyPair, nil
+}
+
+func saveKeystoreKeyPair(serviceID string, signer Signer, kyberKeyPair *KyberKeyPair, metadata *KeyMetadata) error {
+	keystoreMu.Lock()
+	defer keystoreMu.Unlock()
+
+	contents, err := readKeystore()
+	if err != nil {
+		return err
+	}
+	contents.Services[serviceID] = &keystoreEntry{
+		Algorithm:     signer.Algorithm(),
+		PublicKey:     signer.GetPublicKeyBytes(),
+		PrivateKey:    signer.GetPrivateKeyBytes(),
+		KEMAlgorithm:  metadata.KEMAlgorithm,
+		KEMPublicKey:  kyberKeyPair.GetPublicKeyBytes(),
+		KEMPrivateKey: kyberKeyPair.GetPrivateKeyBytes(),
+		Metadata:      metadata,
+	}
+	if err := writeKeystore(contents); err != nil {
+		return err
+	}
+
+	log.Printf("✅ Keys saved for service %s in keystore %s", serviceID, KeystoreFile)
+	return nil
+}
+
+// CheckKeyPair checks that each public key belongs to its private key and
+// that the keys work: a signature verifies and an encapsulated secret
+// decapsulates to the same secret.
+func CheckKeyPair(signer Signer, kyberKeyPair *KyberKeyPair) error {
+	derived, err := derivePublicKey(signer.Algorithm(), signer.GetPrivateKeyBytes())
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(derived, signer.GetPublicKeyBytes()) {
+		return fmt.Errorf("%s public key does not belong to the private key", signer.Algorithm())
+	}
+	probe := []byte("quantum-safe-mesh key check")
+	signature, err := signer.Sign(probe)
+	if err != nil {
+		return fmt.Errorf("failed to sign with the %s key: %w", signer.Algorithm(), err)
+	}
+	if err := VerifySignature(signer.Algorithm(), signer.GetPublicKeyBytes(), probe, signature); err != nil {
+		return fmt.Errorf("%s signature does not verify: %w", signer.Algorithm(), err)
+	}
+
+	derived, err = derivePublicKey(kyberKeyPair.Algorithm(), kyberKeyPair.GetPrivateKeyBytes())
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(derived, kyberKeyPair.GetPublicKeyBytes()) {
+		return fmt.Errorf("Kyber public key does not belong to the private key")
+	}
+	ciphertext, sharedSecret, err := kyberKeyPair.Encapsulate()
+	if err != nil {
+		return fmt.Errorf("failed to encapsulate to the Kyber key: %w", err)
+	}
+	decapsulated, err := kyberKeyPair.Decapsulate(ciphertext)
+	if err != nil {
+		return fmt.Errorf("failed to decapsulate with the Kyber key: %w", err)
+	}
+	if !bytes.Equal(decapsulated, sharedSecret) {
+		return fmt.Errorf("Kyber key decapsulates to the wrong shared secret")
+	}
+	return nil
+}
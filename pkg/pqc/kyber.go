@@ -3,37 +3,77 @@ package pqc
 import (
 	"crypto/rand"
 	"fmt"
-	"log"
+	"log/slog"
+	"slices"
+	"strings"
 	"time"
 
+	"github.com/cloudflare/circl/kem"
 	"github.com/cloudflare/circl/kem/kyber/kyber768"
 )
 
+// KEMAlgorithm returns the KEM algorithm alg names, as found in
+// registrations and key metadata, where empty means Kyber768.
+func KEMAlgorithm(alg string) (string, error) {
+	algorithm := strings.ToLower(alg)
+	if algorithm == "" {
+		return AlgorithmKyber768, nil
+	}
+	if !slices.Contains(KEMAlgorithms, algorithm) {
+		return "", fmt.Errorf("unsupported KEM algorithm %q", alg)
+	}
+	return algorithm, nil
+}
+
+// CheckKEMPublicKey reports whether publicKey can be a public key of the
+// KEM algorithm.
+func CheckKEMPublicKey(algorithm string, publicKey []byte) error {
+	provider, err := KEM(algorithm)
+	if err != nil {
+		return err
+	}
+	return provider.CheckPublicKey(publicKey)
+}
+
+// KyberKeyPair is a KEM key pair of any of KEMAlgorithms, not only Kyber768;
+// it keeps the name it had when Kyber768 was the only one.
 type KyberKeyPair struct {
-	PublicKey  kyber768.PublicKey
-	PrivateKey kyber768.PrivateKey
+	PublicKey  kem.PublicKey
+	PrivateKey kem.PrivateKey
+
+	algorithm string
 }
 
+// GenerateKyberKeyPair generates a Kyber768 key; see GenerateKEMKeyPair for
+// the others.
 func GenerateKyberKeyPair() (*KyberKeyPair, error) {
-	log.Println("🔐 Generating Kyber768 keypair...")
-	start := time.Now()
+	return kemProviders[AlgorithmKyber768].GenerateKeyPair()
+}
 
-	publicKey, privateKey, err := kyber768.GenerateKeyPair(rand.Reader)
-	if err != nil {
-		return nil, fmt.Errorf("failed to generate Kyber keypair: %w", err)
+// KyberSeedSize is the seed length DeriveKyberKeyPair expects.
+const KyberSeedSize = kyber768.KeySeedSize
+
+// DeriveKyberKeyPair deterministically expands seed into a Kyber768
+// keypair, like DeriveDilithiumKeyPair.
+func DeriveKyberKeyPair(seed []byte) (*KyberKeyPair, error) {
+	if len(seed) != KyberSeedSize {
+		return nil, fmt.Errorf("invalid seed size: expected %d, got %d", KyberSeedSize, len(seed))
 	}
 
-	duration := time.Since(start)
-	log.Printf("✅ Kyber768 keypair generated in %v", duration)
-	log.Printf("📏 Public key size: %d bytes, Private key size: %d bytes",
-		1184, 2400)
+	publicKey, privateKey := kyber768.Scheme().DeriveKeyPair(seed)
 
 	return &KyberKeyPair{
-		PublicKey:  *publicKey,
-		PrivateKey: *privateKey,
+		PublicKey:  publicKey,
+		PrivateKey: privateKey,
+		algorithm:  AlgorithmKyber768,
 	}, nil
 }
 
+// Algorithm returns which of KEMAlgorithms the key is of.
+func (k *KyberKeyPair) Algorithm() string {
+	return k.algorithm
+}
+
 func (k *KyberKeyPair) GetPublicKeyBytes() []byte {
 	bytes, _ := k.PublicKey.MarshalBinary()
 	return bytes
@@ -45,82 +85,60 @@ func (k *KyberKeyPair) GetPrivateKeyBytes() []byte {
 }
 
 func (k *KyberKeyPair) Encapsulate() ([]byte, []byte, error) {
-	log.Println("🔒 Performing Kyber768 encapsulation...")
-	start := time.Now()
-
-	ciphertext := make([]byte, 1088)
-	sharedSecret := make([]byte, 32)
-	seed := make([]byte, 32)
-	_, err := rand.Read(seed)
-	if err != nil {
-		return nil, nil, fmt.Errorf("failed to generate random seed: %w", err)
-	}
-	k.PublicKey.EncapsulateTo(ciphertext, sharedSecret, seed)
-
-	duration := time.Since(start)
-	log.Printf("✅ Encapsulation completed in %v (ciphertext: %d bytes, shared secret: %d bytes)",
-		duration, len(ciphertext), len(sharedSecret))
-
-	return ciphertext, sharedSecret, nil
+	return kemProviders[k.algorithm].encapsulate(k.PublicKey)
 }
 
 func (k *KyberKeyPair) Decapsulate(ciphertext []byte) ([]byte, error) {
-	log.Printf("🔓 Performing Kyber768 decapsulation (ciphertext: %d bytes)", len(ciphertext))
 	start := time.Now()
 
-	sharedSecret := make([]byte, 32)
-	k.PrivateKey.DecapsulateTo(sharedSecret, ciphertext)
+	provider := kemProviders[k.algorithm]
+	sharedSecret, err := provider.scheme.Decapsulate(k.PrivateKey, ciphertext)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decapsulate with %s: %w", provider.name, err)
+	}
 
-	duration := time.Since(start)
-	log.Printf("✅ Decapsulation completed in %v (shared secret: %d bytes)",
-		duration, len(sharedSecret))
+	slog.Debug("🔓 "+provider.name+" decapsulation completed", "ciphertext_bytes", len(ciphertext), "duration", time.Since(start))
 
 	return sharedSecret, nil
 }
 
+// EncapsulateWithPublicKey encapsulates to a Kyber768 public key; see
+// EncapsulateTo for the others.
 func EncapsulateWithPublicKey(publicKeyBytes []byte) ([]byte, []byte, error) {
-	log.Printf("🔒 Encapsulating with provided public key (%d bytes)", len(publicKeyBytes))
+	ciphertext := make([]byte, 1088)
+	sharedSecret, err := encapsulateTo(publicKeyBytes, ciphertext)
+	if err != nil {
+		return nil, nil, err
+	}
+	return ciphertext, sharedSecret, nil
+}
 
+// encapsulateTo encapsulates to publicKeyBytes into ciphertext, which is
+// 1088 bytes, and returns the shared secret.
+func encapsulateTo(publicKeyBytes, ciphertext []byte) ([]byte, error) {
 	if len(publicKeyBytes) != 1184 {
-		return nil, nil, fmt.Errorf("invalid public key size: expected %d, got %d", 1184, len(publicKeyBytes))
+		return nil, fmt.Errorf("invalid public key size: expected %d, got %d", 1184, len(publicKeyBytes))
 	}
 
 	var publicKey kyber768.PublicKey
 	publicKey.Unpack(publicKeyBytes)
 
 	start := time.Now()
-	ciphertext := make([]byte, 1088)
 	sharedSecret := make([]byte, 32)
 	seed := make([]byte, 32)
 	_, err := rand.Read(seed)
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed to generate random seed: %w", err)
+		return nil, fmt.Errorf("failed to generate random seed: %w", err)
 	}
 	publicKey.EncapsulateTo(ciphertext, sharedSecret, seed)
 
-	duration := time.Since(start)
-	log.Printf("✅ Encapsulation completed in %v", duration)
+	slog.Debug("🔒 Kyber768 encapsulation completed", "ciphertext_bytes", len(ciphertext), "duration", time.Since(start))
 
-	return ciphertext, sharedSecret, nil
+	return sharedSecret, nil
 }
 
+// LoadKyberKeyPair loads a Kyber768 key pair; see LoadKEMKeyPair for the
+// others.
 func LoadKyberKeyPair(publicKeyBytes, privateKeyBytes []byte) (*KyberKeyPair, error) {
-	if len(publicKeyBytes) != 1184 {
-		return nil, fmt.Errorf("invalid public key size: expected %d, got %d", 1184, len(publicKeyBytes))
-	}
-
-	if len(privateKeyBytes) != 2400 {
-		return nil, fmt.Errorf("invalid private key size: expected %d, got %d", 2400, len(privateKeyBytes))
-	}
-
-	var publicKey kyber768.PublicKey
-	var privateKey kyber768.PrivateKey
-
-	publicKey.Unpack(publicKeyBytes)
-	privateKey.Unpack(privateKeyBytes)
-
-	return &KyberKeyPair{
-		PublicKey:  publicKey,
-		PrivateKey: privateKey,
-	}, nil
+	return kemProviders[AlgorithmKyber768].LoadKeyPair(publicKeyBytes, privateKeyBytes)
 }
@@ -0,0 +1,85 @@
+package pqc
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// serviceKeyFiles lists every file in KeysDir serviceID's keys and their
+// metadata may be stored under, whichever algorithms they use.
+func serviceKeyFiles(serviceID string) []string {
+	var files []string
+	for _, algorithm := range signatureKeyFileAlgorithms {
+		pub, priv := SignatureKeyFileNames(serviceID, algorithm)
+		files = append(files, pub, priv)
+	}
+	_, _, kyberPub, kyberPriv := KeyFileNames(serviceID)
+	return append(files, kyberPub, kyberPriv, KeyMetadataFileName(serviceID))
+}
+
+// BackupKeyFiles copies serviceID's keys, key metadata and rotation records
+// from KeysDir to dir, laid out as in KeysDir, and returns the names of the
+// files it copied.
+func BackupKeyFiles(serviceID, dir string) ([]string, error) {
+	var copied []string
+	for _, name := range append(serviceKeyFiles(serviceID), RotationRecordsFileName(serviceID)) {
+		err := copyKeyFile(filepath.Join(KeysDir, name), filepath.Join(dir, name))
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return copied, fmt.Errorf("failed to back up %s: %w", name, err)
+		}
+		copied = append(copied, name)
+	}
+	if len(copied) == 0 {
+		return nil, fmt.Errorf("no keys for %s in %s", serviceID, KeysDir)
+	}
+	return copied, nil
+}
+
+// RestoreKeyFiles puts the keys and key metadata BackupKeyFiles copied to
+// dir back in KeysDir, removing those of serviceID's key files the backup
+// has no copy of, such as a signing key of another algorithm. Rotation
+// records are left alone: those written since the backup still lead to the
+// registered key.
+func RestoreKeyFiles(serviceID, dir string) error {
+	_, _, kyberPub, _ := KeyFileNames(serviceID)
+	if _, err := os.Stat(filepath.Join(dir, kyberPub)); err != nil {
+		return fmt.Errorf("no keys for %s in %s: %w", serviceID, dir, err)
+	}
+
+	for _, name := range serviceKeyFiles(serviceID) {
+		err := copyKeyFile(filepath.Join(dir, name), filepath.Join(KeysDir, name))
+		if errors.Is(err, fs.ErrNotExist) {
+			err = os.Remove(filepath.Join(KeysDir, name))
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("failed to restore %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// copyKeyFile copies src to dst with the same permissions, creating dst's
+// directory readable only by its owner if it does not exist.
+func copyKeyFile(src, dst string) error {
+	info, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0700); err != nil {
+		return err
+	}
+	return writeKeyFile(dst, data, info.Mode().Perm())
+}
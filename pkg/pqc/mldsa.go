@@ -0,0 +1,137 @@
+package pqc
+
+import (
+	"crypto/rand"
+	"fmt"
+	"log"
+	"log/slog"
+	"time"
+
+	"github.com/cloudflare/circl/sign/mldsa/mldsa65"
+)
+
+// AlgorithmMLDSA65 is ML-DSA-65, the FIPS 204 standard that Dilithium3 was
+// finalised as. The two are not compatible: FIPS 204 changed the key and
+// signature encodings and how messages are hashed, so a Dilithium3 key
+// cannot be read as an ML-DSA-65 one or the other way round.
+const AlgorithmMLDSA65 = "ml-dsa-65"
+
+// MLDSAKeyPair is an ML-DSA-65 signing key. Like DilithiumKeyPair it holds
+// its private key expanded, so keep the key pair rather than its bytes.
+type MLDSAKeyPair struct {
+	PublicKey  mldsa65.PublicKey
+	PrivateKey mldsa65.PrivateKey
+}
+
+func GenerateMLDSAKeyPair() (*MLDSAKeyPair, error) {
+	log.Println("🔑 Generating ML-DSA-65 keypair...")
+	start := time.Now()
+
+	publicKey, privateKey, err := mldsa65.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate ML-DSA-65 keypair: %w", err)
+	}
+
+	log.Printf("✅ ML-DSA-65 keypair generated in %v", time.Since(start))
+	slog.Debug("📏 ML-DSA-65 key sizes", "public_key_bytes", mldsa65.PublicKeySize,
+		"private_key_bytes", mldsa65.PrivateKeySize, "signature_bytes", mldsa65.SignatureSize)
+
+	return &MLDSAKeyPair{PublicKey: *publicKey, PrivateKey: *privateKey}, nil
+}
+
+func LoadMLDSAKeyPair(publicKeyBytes, privateKeyBytes []byte) (*MLDSAKeyPair, error) {
+	if len(publicKeyBytes) != mldsa65.PublicKeySize {
+		return nil, fmt.Errorf("invalid public key size: expected %d, got %d", mldsa65.PublicKeySize, len(publicKeyBytes))
+	}
+	if len(privateKeyBytes) != mldsa65.PrivateKeySize {
+		return nil, fmt.Errorf("invalid private key size: expected %d, got %d", mldsa65.PrivateKeySize, len(privateKeyBytes))
+	}
+
+	keyPair := &MLDSAKeyPair{}
+	if err := keyPair.PublicKey.UnmarshalBinary(publicKeyBytes); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal public key: %w", err)
+	}
+	privateKey, err := ExpandMLDSAPrivateKey(privateKeyBytes)
+	if err != nil {
+		return nil, err
+	}
+	keyPair.PrivateKey = *privateKey
+	if !keyPair.PrivateKey.Public().(*mldsa65.PublicKey).Equal(&keyPair.PublicKey) {
+		return nil, fmt.Errorf("public key does not belong to private key")
+	}
+	return keyPair, nil
+}
+
+func (m *MLDSAKeyPair) Algorithm() string {
+	return AlgorithmMLDSA65
+}
+
+// Sign signs data with the hedged variant FIPS 204 recommends, mixing fresh
+// randomness into every signature, and an empty context string.
+func (m *MLDSAKeyPair) Sign(data []byte) ([]byte, error) {
+	start := time.Now()
+
+	signature := make([]byte, mldsa65.SignatureSize)
+	if err := mldsa65.SignTo(&m.PrivateKey, data, nil, true, signature); err != nil {
+		return nil, fmt.Errorf("failed to sign with ML-DSA-65: %w", err)
+	}
+
+	slog.Debug("🖊️  Data signed", "algorithm", AlgorithmMLDSA65, "data_bytes", len(data),
+		"signature_bytes", len(signature), "duration", time.Since(start))
+	return signature, nil
+}
+
+func (m *MLDSAKeyPair) GetPublicKeyBytes() []byte {
+	return m.PublicKey.Bytes()
+}
+
+func (m *MLDSAKeyPair) GetPrivateKeyBytes() []byte {
+	return m.PrivateKey.Bytes()
+}
+
+// ExpandMLDSAPublicKey unpacks a public key for verification, expanding
+// its matrix A, and caches it as ExpandDilithiumPublicKey does. The
+// returned key must not be modified.
+func ExpandMLDSAPublicKey(publicKeyBytes []byte) (*mldsa65.PublicKey, error) {
+	return expandPublicKey(AlgorithmMLDSA65, publicKeyBytes, func(publicKeyBytes []byte) (*mldsa65.PublicKey, error) {
+		if len(publicKeyBytes) != mldsa65.PublicKeySize {
+			return nil, fmt.Errorf("invalid public key size for ML-DSA-65: expected %d, got %d", mldsa65.PublicKeySize, len(publicKeyBytes))
+		}
+		publicKey := new(mldsa65.PublicKey)
+		if err := publicKey.UnmarshalBinary(publicKeyBytes); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal public key: %w", err)
+		}
+		return publicKey, nil
+	})
+}
+
+// ExpandMLDSAPrivateKey unpacks a private key for signing, precomputing the
+// matrix A and the NTT forms of its secrets once, as
+// ExpandDilithiumPrivateKey does.
+func ExpandMLDSAPrivateKey(privateKeyBytes []byte) (*mldsa65.PrivateKey, error) {
+	if len(privateKeyBytes) != mldsa65.PrivateKeySize {
+		return nil, fmt.Errorf("invalid private key size: expected %d, got %d", mldsa65.PrivateKeySize, len(privateKeyBytes))
+	}
+	privateKey := new(mldsa65.PrivateKey)
+	if err := privateKey.UnmarshalBinary(privateKeyBytes); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal private key: %w", err)
+	}
+	return privateKey, nil
+}
+
+func VerifyMLDSASignature(publicKeyBytes, data, signature []byte) error {
+	start := time.Now()
+
+	publicKey, err := ExpandMLDSAPublicKey(publicKeyBytes)
+	if err != nil {
+		return err
+	}
+
+	if !mldsa65.Verify(publicKey, data, nil, signature) {
+		slog.Debug("🔍 Signature invalid", "algorithm", AlgorithmMLDSA65, "data_bytes", len(data), "duration", time.Since(start))
+		return fmt.Errorf("invalid signature")
+	}
+
+	slog.Debug("🔍 Signature verified", "algorithm", AlgorithmMLDSA65, "data_bytes", len(data), "duration", time.Since(start))
+	return nil
+}
@@ -0,0 +1,176 @@
+package pqc
+
+import (
+	"crypto/subtle"
+	"encoding/asn1"
+	"encoding/pem"
+	"fmt"
+)
+
+// OpenSSL oqs-provider object identifiers. Keys for them are written as a
+// SubjectPublicKeyInfo or a PKCS #8 PrivateKeyInfo holding the liboqs key
+// bytes, which for these round 3 algorithms are the bytes circl uses.
+var (
+	OIDDilithium3 = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 2, 267, 7, 6, 5}
+	OIDKyber768   = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 22554, 5, 6, 2}
+)
+
+var oqsOIDs = map[string]asn1.ObjectIdentifier{
+	AlgorithmDilithium3: OIDDilithium3,
+	AlgorithmKyber768:   OIDKyber768,
+}
+
+// PEM block types OpenSSL uses for unencrypted keys of any algorithm.
+const (
+	pemTypePublicKey  = "PUBLIC KEY"
+	pemTypePrivateKey = "PRIVATE KEY"
+)
+
+type algorithmIdentifier struct {
+	Algorithm  asn1.ObjectIdentifier
+	Parameters asn1.RawValue `asn1:"optional"`
+}
+
+type subjectPublicKeyInfo struct {
+	Algorithm algorithmIdentifier
+	PublicKey asn1.BitString
+}
+
+type privateKeyInfo struct {
+	Version    int
+	Algorithm  algorithmIdentifier
+	PrivateKey []byte
+}
+
+// HasOQSFormat reports whether algorithm's keys can be written in the
+// oqs-provider format. SLH-DSA cannot: oqs-provider implements SPHINCS+,
+// whose keys and signatures differ from the final FIPS 205 standard.
+func HasOQSFormat(algorithm string) bool {
+	_, exists := oqsOIDs[algorithm]
+	return exists
+}
+
+func oqsAlgorithm(oid asn1.ObjectIdentifier) (string, error) {
+	for algorithm, known := range oqsOIDs {
+		if oid.Equal(known) {
+			return algorithm, nil
+		}
+	}
+	return "", fmt.Errorf("unsupported key algorithm OID %s", oid)
+}
+
+// MarshalOQSPublicKey encodes an algorithm public key as the DER
+// SubjectPublicKeyInfo oqs-provider reads and writes.
+func MarshalOQSPublicKey(algorithm string, publicKey []byte) ([]byte, error) {
+	oid, exists := oqsOIDs[algorithm]
+	if !exists {
+		return nil, fmt.Errorf("%s keys have no oqs-provider format", algorithm)
+	}
+	return asn1.Marshal(subjectPublicKeyInfo{
+		Algorithm: algorithmIdentifier{Algorithm: oid},
+		PublicKey: asn1.BitString{Bytes: publicKey, BitLength: 8 * len(publicKey)},
+	})
+}
+
+// ParseOQSPublicKey decodes a DER SubjectPublicKeyInfo as written by
+// oqs-provider, returning the key's algorithm and bytes.
+func ParseOQSPublicKey(der []byte) (algorithm string, publicKey []byte, err error) {
+	var info subjectPublicKeyInfo
+	if rest, err := asn1.Unmarshal(der, &info); err != nil {
+		return "", nil, fmt.Errorf("invalid SubjectPublicKeyInfo: %w", err)
+	} else if len(rest) > 0 {
+		return "", nil, fmt.Errorf("invalid SubjectPublicKeyInfo: trailing data")
+	}
+	if algorithm, err = oqsAlgorithm(info.Algorithm.Algorithm); err != nil {
+		return "", nil, err
+	}
+	return algorithm, info.PublicKey.RightAlign(), nil
+}
+
+// MarshalOQSPrivateKey encodes an algorithm private key as the DER PKCS #8
+// PrivateKeyInfo oqs-provider writes: its private key field is an OCTET
+// STRING holding the private key followed by the public key.
+func MarshalOQSPrivateKey(algorithm string, privateKey []byte) ([]byte, error) {
+	oid, exists := oqsOIDs[algorithm]
+	if !exists {
+		return nil, fmt.Errorf("%s keys have no oqs-provider format", algorithm)
+	}
+	publicKey, err := derivePublicKey(algorithm, privateKey)
+	if err != nil {
+		return nil, err
+	}
+
+	keyPair, err := asn1.Marshal(append(append([]byte{}, privateKey...), publicKey...))
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(privateKeyInfo{
+		Algorithm:  algorithmIdentifier{Algorithm: oid},
+		PrivateKey: keyPair,
+	})
+}
+
+// ParseOQSPrivateKey decodes a DER PKCS #8 PrivateKeyInfo as written by
+// oqs-provider, returning the key's algorithm and private key bytes. The
+// private key field may hold the private key alone or followed by its public
+// key, with or without the OCTET STRING wrapping newer oqs-provider releases
+// add; an included public key must belong to the private key.
+func ParseOQSPrivateKey(der []byte) (algorithm string, privateKey []byte, err error) {
+	var info privateKeyInfo
+	if rest, err := asn1.Unmarshal(der, &info); err != nil {
+		return "", nil, fmt.Errorf("invalid PKCS #8 private key: %w", err)
+	} else if len(rest) > 0 {
+		return "", nil, fmt.Errorf("invalid PKCS #8 private key: trailing data")
+	}
+	if algorithm, err = oqsAlgorithm(info.Algorithm.Algorithm); err != nil {
+		return "", nil, err
+	}
+
+	key := info.PrivateKey
+	var inner []byte
+	if rest, err := asn1.Unmarshal(key, &inner); err == nil && len(rest) == 0 {
+		key = inner
+	}
+
+	privateSize, publicSize := oqsKeySizes(algorithm)
+	switch len(key) {
+	case privateSize:
+		return algorithm, key, nil
+	case privateSize + publicSize:
+		privateKey = key[:privateSize]
+		publicKey, err := derivePublicKey(algorithm, privateKey)
+		if err != nil {
+			return "", nil, err
+		}
+		if subtle.ConstantTimeCompare(publicKey, key[privateSize:]) != 1 {
+			return "", nil, fmt.Errorf("public key in %s private key does not belong to it", algorithm)
+		}
+		return algorithm, privateKey, nil
+	}
+	return "", nil, fmt.Errorf("invalid %s private key size %d", algorithm, len(key))
+}
+
+// decodeOQSKey decodes an unencrypted OpenSSL PEM block of kind holding a
+// key in the oqs-provider format, returning its algorithm and key bytes.
+func decodeOQSKey(block *pem.Block, kind string) (algorithm string, key []byte, err error) {
+	if kind == privateKeyKind {
+		return ParseOQSPrivateKey(block.Bytes)
+	}
+	return ParseOQSPublicKey(block.Bytes)
+}
+
+// encodeOQSKey encodes an algorithm key of kind as an OpenSSL PEM block.
+func encodeOQSKey(algorithm, kind string, key []byte) ([]byte, error) {
+	if kind == privateKeyKind {
+		der, err := MarshalOQSPrivateKey(algorithm, key)
+		if err != nil {
+			return nil, err
+		}
+		return pem.EncodeToMemory(&pem.Block{Type: pemTypePrivateKey, Bytes: der}), nil
+	}
+	der, err := MarshalOQSPublicKey(algorithm, key)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: pemTypePublicKey, Bytes: der}), nil
+}
@@ -0,0 +1,193 @@
+package pqc
+
+import (
+	"bytes"
+	"encoding/asn1"
+	"encoding/hex"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// DER headers of the keys oqs-provider writes, up to the key bytes: the
+// outer SEQUENCE, the AlgorithmIdentifier with the algorithm's OID and no
+// parameters, and the BIT STRING, or the version and nested OCTET STRINGs
+// of a PKCS #8 private key. Matching them byte for byte keeps the mesh's
+// files readable by openssl.
+var oqsHeaders = map[string]struct{ public, private string }{
+	AlgorithmDilithium3: {
+		public:  "308207b4300d060b2b0601040102820b070605038207a100",
+		private: "3082175a020100300d060b2b0601040102820b0706050482174404821740",
+	},
+	AlgorithmKyber768: {
+		public:  "308204b4300d060b2b0601040181b01a050602038204a100",
+		private: "30820e1a020100300d060b2b0601040181b01a05060204820e0404820e00",
+	},
+}
+
+func testOQSKeys(t *testing.T) map[string][2][]byte {
+	t.Helper()
+	dilithium, err := GenerateDilithiumKeyPair()
+	if err != nil {
+		t.Fatalf("GenerateDilithiumKeyPair: %v", err)
+	}
+	kyber, err := GenerateKyberKeyPair()
+	if err != nil {
+		t.Fatalf("GenerateKyberKeyPair: %v", err)
+	}
+	return map[string][2][]byte{
+		AlgorithmDilithium3: {dilithium.GetPublicKeyBytes(), dilithium.GetPrivateKeyBytes()},
+		AlgorithmKyber768:   {kyber.GetPublicKeyBytes(), kyber.GetPrivateKeyBytes()},
+	}
+}
+
+func TestOQSKeysRoundTrip(t *testing.T) {
+	for algorithm, keys := range testOQSKeys(t) {
+		publicKey, privateKey := keys[0], keys[1]
+		header := oqsHeaders[algorithm]
+
+		der, err := MarshalOQSPublicKey(algorithm, publicKey)
+		if err != nil {
+			t.Fatalf("MarshalOQSPublicKey(%s): %v", algorithm, err)
+		}
+		assertDERLayout(t, algorithm+" public key", der, header.public, publicKey)
+		parsedAlgorithm, parsed, err := ParseOQSPublicKey(der)
+		if err != nil {
+			t.Fatalf("ParseOQSPublicKey(%s): %v", algorithm, err)
+		}
+		if parsedAlgorithm != algorithm || !bytes.Equal(parsed, publicKey) {
+			t.Fatalf("%s public key came back as a different %s key", algorithm, parsedAlgorithm)
+		}
+
+		der, err = MarshalOQSPrivateKey(algorithm, privateKey)
+		if err != nil {
+			t.Fatalf("MarshalOQSPrivateKey(%s): %v", algorithm, err)
+		}
+		assertDERLayout(t, algorithm+" private key", der, header.private, append(append([]byte{}, privateKey...), publicKey...))
+		parsedAlgorithm, parsed, err = ParseOQSPrivateKey(der)
+		if err != nil {
+			t.Fatalf("ParseOQSPrivateKey(%s): %v", algorithm, err)
+		}
+		if parsedAlgorithm != algorithm || !bytes.Equal(parsed, privateKey) {
+			t.Fatalf("%s private key came back as a different %s key", algorithm, parsedAlgorithm)
+		}
+	}
+}
+
+func assertDERLayout(t *testing.T, what string, der []byte, header string, key []byte) {
+	t.Helper()
+	want, err := hex.DecodeString(header)
+	if err != nil {
+		t.Fatalf("bad header for %s: %v", what, err)
+	}
+	if !bytes.Equal(der[:len(want)], want) {
+		t.Fatalf("%s DER header:\n got %x\nwant %x", what, der[:len(want)], want)
+	}
+	if !bytes.Equal(der[len(want):], key) {
+		t.Fatalf("%s DER does not end with the key bytes", what)
+	}
+}
+
+// TestOQSPrivateKeyLayouts parses the private key layouts oqs-provider
+// releases have written, and rejects a public key from another key pair.
+func TestOQSPrivateKeyLayouts(t *testing.T) {
+	keys := testOQSKeys(t)
+	publicKey, privateKey := keys[AlgorithmDilithium3][0], keys[AlgorithmDilithium3][1]
+	wrap := func(content []byte) []byte {
+		der, err := asn1.Marshal(content)
+		if err != nil {
+			t.Fatalf("Marshal: %v", err)
+		}
+		return der
+	}
+	pkcs8 := func(field []byte) []byte {
+		der, err := asn1.Marshal(privateKeyInfo{Algorithm: algorithmIdentifier{Algorithm: OIDDilithium3}, PrivateKey: field})
+		if err != nil {
+			t.Fatalf("Marshal: %v", err)
+		}
+		return der
+	}
+	keyPair := append(append([]byte{}, privateKey...), publicKey...)
+
+	for name, der := range map[string][]byte{
+		"private and public key in an OCTET STRING": pkcs8(wrap(keyPair)),
+		"private key in an OCTET STRING":            pkcs8(wrap(privateKey)),
+		"private and public key":                    pkcs8(keyPair),
+		"private key":                               pkcs8(privateKey),
+	} {
+		algorithm, parsed, err := ParseOQSPrivateKey(der)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if algorithm != AlgorithmDilithium3 || !bytes.Equal(parsed, privateKey) {
+			t.Fatalf("%s: parsed as a different %s key", name, algorithm)
+		}
+	}
+
+	other := testOQSKeys(t)[AlgorithmDilithium3][0]
+	mismatched := append(append([]byte{}, privateKey...), other...)
+	if _, _, err := ParseOQSPrivateKey(pkcs8(wrap(mismatched))); err == nil {
+		t.Fatal("private key with another key pair's public key was accepted")
+	}
+}
+
+// TestOQSKeyStore saves and loads a service's keys in the oqs format,
+// converts PEM keys to it without changing them, and checks openssl's PEM
+// labels are what the files hold.
+func TestOQSKeyStore(t *testing.T) {
+	keysDir, keysFormat := KeysDir, KeysFormat
+	t.Cleanup(func() { KeysDir, KeysFormat = keysDir, keysFormat })
+	KeysDir = t.TempDir()
+
+	dilithium, err := GenerateDilithiumKeyPair()
+	if err != nil {
+		t.Fatalf("GenerateDilithiumKeyPair: %v", err)
+	}
+	kyber, err := GenerateKyberKeyPair()
+	if err != nil {
+		t.Fatalf("GenerateKyberKeyPair: %v", err)
+	}
+
+	KeysFormat = KeyFormatPEM
+	if err := SaveKeyPair("orders", dilithium, kyber); err != nil {
+		t.Fatalf("SaveKeyPair: %v", err)
+	}
+	KeysFormat = KeyFormatOQS
+	if err := RewriteKeyFiles("orders", nil); err != nil {
+		t.Fatalf("RewriteKeyFiles: %v", err)
+	}
+
+	dilithiumPub, dilithiumPriv, kyberPub, kyberPriv := KeyFileNames("orders")
+	for name, blockType := range map[string]string{
+		dilithiumPub: "PUBLIC KEY", dilithiumPriv: "PRIVATE KEY",
+		kyberPub: "PUBLIC KEY", kyberPriv: "PRIVATE KEY",
+	} {
+		data, err := os.ReadFile(filepath.Join(KeysDir, name))
+		if err != nil {
+			t.Fatalf("ReadFile: %v", err)
+		}
+		if block, _ := pem.Decode(data); block == nil || block.Type != blockType {
+			t.Fatalf("%s is not a %s PEM block", name, blockType)
+		}
+	}
+
+	signer, loadedKyber, err := LoadKeyPair("orders")
+	if err != nil {
+		t.Fatalf("LoadKeyPair: %v", err)
+	}
+	if !bytes.Equal(signer.GetPrivateKeyBytes(), dilithium.GetPrivateKeyBytes()) ||
+		!bytes.Equal(loadedKyber.GetPrivateKeyBytes(), kyber.GetPrivateKeyBytes()) {
+		t.Fatal("keys changed on conversion to the oqs format")
+	}
+
+	// A Kyber key where the Dilithium key belongs is refused, even though
+	// both are generic PRIVATE KEY blocks.
+	kyberData, _ := os.ReadFile(filepath.Join(KeysDir, kyberPriv))
+	if err := os.WriteFile(filepath.Join(KeysDir, dilithiumPriv), kyberData, 0600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	if _, _, err := LoadKeyPair("orders"); err == nil {
+		t.Fatal("a Kyber private key loaded as the Dilithium key")
+	}
+}
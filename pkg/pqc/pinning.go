@@ -0,0 +1,246 @@
+package pqc
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"quantum-safe-mesh/pkg/models"
+)
+
+// A compromised auth service could hand out its own key for any service.
+// Peers guard against that by pinning the key they first see for each
+// service and only moving the pin along rotation records signed by the
+// pinned key, trust on first use as SSH does with host keys.
+
+// maxRotationRecords bounds the rotation records kept for a service. A peer
+// whose pin is older than all of them has to be re-pinned by hand.
+const maxRotationRecords = 16
+
+// RotationRecordsFileName returns the file name serviceID's rotation
+// records are kept under in KeysDir.
+func RotationRecordsFileName(serviceID string) string {
+	return serviceID + "_rotations.json"
+}
+
+// SignRotationRecord has oldKey vouch that newKey replaces it as
+// serviceID's signing key.
+func SignRotationRecord(serviceID string, oldKey, newKey Signer) (*models.KeyRotationRecord, error) {
+	record := &models.KeyRotationRecord{
+		ServiceID:    serviceID,
+		OldPublicKey: oldKey.GetPublicKeyBytes(),
+		NewPublicKey: newKey.GetPublicKeyBytes(),
+		RotatedAt:    time.Now().UTC(),
+	}
+	if oldKey.Algorithm() != AlgorithmDilithium3 {
+		record.OldAlgorithm = oldKey.Algorithm()
+	}
+	if newKey.Algorithm() != AlgorithmDilithium3 {
+		record.Algorithm = newKey.Algorithm()
+	}
+
+	payload, err := record.SigningPayload()
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal rotation record: %w", err)
+	}
+	if record.Signature, err = oldKey.Sign(payload); err != nil {
+		return nil, fmt.Errorf("failed to sign rotation record: %w", err)
+	}
+	return record, nil
+}
+
+// VerifyRotationRecord checks record is signed by the old key it names.
+func VerifyRotationRecord(record *models.KeyRotationRecord) error {
+	if record.ServiceID == "" || len(record.OldPublicKey) == 0 || len(record.NewPublicKey) == 0 {
+		return fmt.Errorf("rotation record is missing its service or keys")
+	}
+	if _, err := SignatureAlgorithm(record.Algorithm); err != nil {
+		return err
+	}
+	payload, err := record.SigningPayload()
+	if err != nil {
+		return fmt.Errorf("failed to marshal rotation record: %w", err)
+	}
+	if err := VerifySignature(record.OldAlgorithm, record.OldPublicKey, payload, record.Signature); err != nil {
+		return fmt.Errorf("rotation record of %s is not signed by key %s: %w", record.ServiceID, KeyFingerprint(record.OldPublicKey), err)
+	}
+	return nil
+}
+
+// LoadRotationRecords reads serviceID's rotation records from KeysDir,
+// oldest first. A service that never rotated has none.
+func LoadRotationRecords(serviceID string) ([]models.KeyRotationRecord, error) {
+	data, err := os.ReadFile(filepath.Join(KeysDir, RotationRecordsFileName(serviceID)))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read rotation records: %w", err)
+	}
+	var records []models.KeyRotationRecord
+	if err := json.Unmarshal(data, &records); err != nil {
+		return nil, fmt.Errorf("failed to decode rotation records: %w", err)
+	}
+	return records, nil
+}
+
+// SaveRotationRecord appends record to serviceID's rotation records in
+// KeysDir, dropping the oldest beyond maxRotationRecords.
+func SaveRotationRecord(serviceID string, record *models.KeyRotationRecord) error {
+	records, err := LoadRotationRecords(serviceID)
+	if err != nil {
+		return err
+	}
+	records = AppendRotationRecord(records, *record)
+
+	data, err := json.MarshalIndent(records, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode rotation records: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(KeysDir, RotationRecordsFileName(serviceID)), append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to save rotation records: %w", err)
+	}
+	return nil
+}
+
+// AppendRotationRecord appends record to records, dropping the oldest
+// beyond maxRotationRecords.
+func AppendRotationRecord(records []models.KeyRotationRecord, record models.KeyRotationRecord) []models.KeyRotationRecord {
+	records = append(records, record)
+	if len(records) > maxRotationRecords {
+		records = records[len(records)-maxRotationRecords:]
+	}
+	return records
+}
+
+// VerifyRotationChain checks every record is validly signed and that,
+// taken in order, each starts from the key the previous one rotated to and
+// the last ends at publicKey.
+func VerifyRotationChain(serviceID string, records []models.KeyRotationRecord, publicKey []byte) error {
+	for i := range records {
+		record := &records[i]
+		if record.ServiceID != serviceID {
+			return fmt.Errorf("rotation record %d is for %s, not %s", i, record.ServiceID, serviceID)
+		}
+		if i > 0 && KeyFingerprint(record.OldPublicKey) != KeyFingerprint(records[i-1].NewPublicKey) {
+			return fmt.Errorf("rotation record %d does not follow from record %d", i, i-1)
+		}
+		if err := VerifyRotationRecord(record); err != nil {
+			return err
+		}
+	}
+	if len(records) > 0 && KeyFingerprint(records[len(records)-1].NewPublicKey) != KeyFingerprint(publicKey) {
+		return fmt.Errorf("rotation records of %s end at key %s, not %s", serviceID,
+			KeyFingerprint(records[len(records)-1].NewPublicKey), KeyFingerprint(publicKey))
+	}
+	return nil
+}
+
+// Pin is the key a peer has accepted for a service.
+type Pin struct {
+	Fingerprint string    `json:"fingerprint"`
+	Algorithm   string    `json:"algorithm,omitempty"`
+	FirstSeen   time.Time `json:"first_seen"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// PinStore records the first key seen for each peer service in a JSON file
+// and refuses a different key later unless signed rotation records lead to
+// it from the pinned one. To accept a service's new key by hand, e.g. after
+// an administrator replaced a lost one, remove its entry from the file.
+type PinStore struct {
+	path  string
+	mutex sync.Mutex
+	pins  map[string]*Pin
+}
+
+// OpenPinStore loads the pins in the file at path, which is created on the
+// first pin if it does not exist.
+func OpenPinStore(path string) (*PinStore, error) {
+	store := &PinStore{path: path, pins: make(map[string]*Pin)}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return store, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key pins: %w", err)
+	}
+	if err := json.Unmarshal(data, &store.pins); err != nil {
+		return nil, fmt.Errorf("failed to decode key pins %s: %w", path, err)
+	}
+	log.Printf("📌 Loaded %d key pins from %s", len(store.pins), path)
+	return store, nil
+}
+
+// Check accepts publicKey as serviceID's key if nothing is pinned for it
+// yet, which pins it, if it is the pinned key, or if rotations lead to it
+// from the pinned key, which moves the pin.
+func (s *PinStore) Check(serviceID, algorithm string, publicKey []byte, rotations []models.KeyRotationRecord) error {
+	fingerprint := KeyFingerprint(publicKey)
+	now := time.Now().UTC().Truncate(time.Second)
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	pin, pinned := s.pins[serviceID]
+	if !pinned {
+		s.pins[serviceID] = &Pin{Fingerprint: fingerprint, Algorithm: algorithm, FirstSeen: now, UpdatedAt: now}
+		log.Printf("📌 Pinned %s to key %s on first use", serviceID, fingerprint)
+		s.save()
+		return nil
+	}
+	if pin.Fingerprint == fingerprint {
+		return nil
+	}
+
+	// Follow the records from the pinned key; any that do not continue the
+	// chain are skipped rather than trusted.
+	current := pin.Fingerprint
+	for i := range rotations {
+		record := &rotations[i]
+		if record.ServiceID != serviceID || KeyFingerprint(record.OldPublicKey) != current {
+			continue
+		}
+		if err := VerifyRotationRecord(record); err != nil {
+			log.Printf("❌ Invalid rotation record for %s: %v", serviceID, err)
+			break
+		}
+		current = KeyFingerprint(record.NewPublicKey)
+		if current != fingerprint {
+			continue
+		}
+		if recordAlgorithm, _ := SignatureAlgorithm(record.Algorithm); recordAlgorithm != algorithm {
+			break
+		}
+
+		log.Printf("📌 Pin for %s moved from %s to %s along its signed rotation records", serviceID, pin.Fingerprint, fingerprint)
+		pin.Fingerprint, pin.Algorithm, pin.UpdatedAt = fingerprint, algorithm, now
+		s.save()
+		return nil
+	}
+
+	log.Printf("❌ Key of %s changed from pinned %s to %s without a signed rotation record", serviceID, pin.Fingerprint, fingerprint)
+	return fmt.Errorf("key %s of %s does not match pinned key %s and no rotation record signed by the pinned key leads to it", fingerprint, serviceID, pin.Fingerprint)
+}
+
+// save writes the pins to a temporary file and renames it into place, so a
+// crash never leaves a truncated pin file. A pin that cannot be saved still
+// holds until the process exits. The caller holds the mutex.
+func (s *PinStore) save() {
+	data, err := json.MarshalIndent(s.pins, "", "  ")
+	if err == nil {
+		tmp := s.path + ".tmp"
+		if err = os.WriteFile(tmp, append(data, '\n'), 0644); err == nil {
+			err = os.Rename(tmp, s.path)
+		}
+	}
+	if err != nil {
+		log.Printf("⚠️  Failed to save key pins to %s: %v", s.path, err)
+	}
+}
@@ -0,0 +1,62 @@
+package pqc
+
+import (
+	"sync"
+
+	"github.com/cloudflare/circl/kem/kyber/kyber768"
+	"github.com/cloudflare/circl/sign/dilithium/mode3"
+)
+
+// Every request is signed at least once, and every channel handshake
+// encapsulates, so their signatures and ciphertexts are written into
+// pooled buffers on the hot paths: at high request rates that leaves the
+// garbage collector thousands fewer short-lived allocations a second to
+// trace and free. A pooled buffer is only handed back once nothing refers
+// to it, typically right after it has been encoded.
+
+var (
+	signatureBuffers  = sync.Pool{New: func() any { return new([mode3.SignatureSize]byte) }}
+	ciphertextBuffers = sync.Pool{New: func() any { return new([kyber768.CiphertextSize]byte) }}
+)
+
+// SignPooled is signer.Sign, except that a Dilithium3 signature is written
+// into a pooled buffer, which the caller hands back with ReleaseSignature
+// once it has encoded it. Other signers allocate as Sign does.
+func SignPooled(signer Signer, data []byte) ([]byte, error) {
+	dilithium, ok := signer.(*DilithiumKeyPair)
+	if !ok {
+		return signer.Sign(data)
+	}
+	signature := signatureBuffers.Get().(*[mode3.SignatureSize]byte)[:]
+	dilithium.signTo(data, signature)
+	return signature, nil
+}
+
+// ReleaseSignature returns a signature from SignPooled to the pool; it
+// must not be used afterwards. Signatures that were not pooled are left to
+// the garbage collector.
+func ReleaseSignature(signature []byte) {
+	if len(signature) == mode3.SignatureSize && cap(signature) == mode3.SignatureSize {
+		signatureBuffers.Put((*[mode3.SignatureSize]byte)(signature))
+	}
+}
+
+// EncapsulatePooled is EncapsulateWithPublicKey, except that the ciphertext
+// is written into a pooled buffer, which the caller hands back with
+// ReleaseCiphertext once it has encoded it.
+func EncapsulatePooled(publicKeyBytes []byte) (ciphertext, sharedSecret []byte, err error) {
+	ciphertext = ciphertextBuffers.Get().(*[kyber768.CiphertextSize]byte)[:]
+	if sharedSecret, err = encapsulateTo(publicKeyBytes, ciphertext); err != nil {
+		ReleaseCiphertext(ciphertext)
+		return nil, nil, err
+	}
+	return ciphertext, sharedSecret, nil
+}
+
+// ReleaseCiphertext returns a ciphertext from EncapsulatePooled to the
+// pool; it must not be used afterwards.
+func ReleaseCiphertext(ciphertext []byte) {
+	if len(ciphertext) == kyber768.CiphertextSize && cap(ciphertext) == kyber768.CiphertextSize {
+		ciphertextBuffers.Put((*[kyber768.CiphertextSize]byte)(ciphertext))
+	}
+}
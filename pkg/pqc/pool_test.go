@@ -0,0 +1,103 @@
+package pqc
+
+import (
+	"runtime"
+	"sync/atomic"
+	"testing"
+)
+
+// escaped keeps the benchmarks' results on the heap, as envelopes keep
+// theirs, so the compiler cannot allocate them on the stack instead.
+var escaped atomic.Pointer[[]byte]
+
+// reportGCs reports the garbage collections run since before, per
+// operation: the pressure pooled buffers are meant to take off.
+func reportGCs(b *testing.B, before *runtime.MemStats) {
+	var after runtime.MemStats
+	runtime.ReadMemStats(&after)
+	b.ReportMetric(float64(after.NumGC-before.NumGC)/float64(b.N), "GCs/op")
+}
+
+// BenchmarkSignatureBuffers signs a request-sized payload with a fresh
+// signature each time, as Sign does, and with pooled ones.
+func BenchmarkSignatureBuffers(b *testing.B) {
+	keyPair, err := GenerateDilithiumKeyPair()
+	if err != nil {
+		b.Fatal(err)
+	}
+	data := make([]byte, 512)
+
+	for _, bench := range []struct {
+		name string
+		sign func() error
+	}{
+		{"allocated", func() error {
+			signature, err := keyPair.Sign(data)
+			escaped.Store(&signature)
+			return err
+		}},
+		{"pooled", func() error {
+			signature, err := SignPooled(keyPair, data)
+			escaped.Store(&signature)
+			ReleaseSignature(signature)
+			return err
+		}},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			var before runtime.MemStats
+			runtime.ReadMemStats(&before)
+			b.ReportAllocs()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					if err := bench.sign(); err != nil {
+						b.Error(err)
+						return
+					}
+				}
+			})
+			reportGCs(b, &before)
+		})
+	}
+}
+
+// BenchmarkCiphertextBuffers encapsulates to a channel peer's key with a
+// fresh ciphertext each time and with pooled ones.
+func BenchmarkCiphertextBuffers(b *testing.B) {
+	keyPair, err := GenerateKyberKeyPair()
+	if err != nil {
+		b.Fatal(err)
+	}
+	publicKey := keyPair.GetPublicKeyBytes()
+
+	for _, bench := range []struct {
+		name        string
+		encapsulate func() error
+	}{
+		{"allocated", func() error {
+			ciphertext, _, err := EncapsulateWithPublicKey(publicKey)
+			escaped.Store(&ciphertext)
+			return err
+		}},
+		{"pooled", func() error {
+			ciphertext, _, err := EncapsulatePooled(publicKey)
+			escaped.Store(&ciphertext)
+			ReleaseCiphertext(ciphertext)
+			return err
+		}},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			var before runtime.MemStats
+			runtime.ReadMemStats(&before)
+			b.ReportAllocs()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					if err := bench.encapsulate(); err != nil {
+						b.Error(err)
+						return
+					}
+				}
+			})
+			reportGCs(b, &before)
+		})
+	}
+}
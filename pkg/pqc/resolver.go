@@ -0,0 +1,217 @@
+package pqc
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"quantum-safe-mesh/pkg/bufpool"
+	"quantum-safe-mesh/pkg/models"
+)
+
+// KeyResolver finds the public key serviceID signed with. keyID, the key's
+// fingerprint, is empty when the signer did not name its key; resolvers then
+// return the service's current key. A resolver must never return a key of
+// another service, nor a key whose fingerprint is not keyID.
+type KeyResolver interface {
+	ResolveKey(serviceID, keyID string) ([]byte, error)
+}
+
+// KeyResolverFunc adapts a function to a KeyResolver.
+type KeyResolverFunc func(serviceID, keyID string) ([]byte, error)
+
+func (f KeyResolverFunc) ResolveKey(serviceID, keyID string) ([]byte, error) {
+	return f(serviceID, keyID)
+}
+
+// ContextKeyResolver is a KeyResolver that can stop waiting for a key when
+// the request needing it is done, as a registry fetching keys over the
+// network does.
+type ContextKeyResolver interface {
+	KeyResolver
+	ResolveKeyContext(ctx context.Context, serviceID, keyID string) ([]byte, error)
+}
+
+// ResolverWithContext returns resolver resolving keys for the request ctx
+// belongs to, if it is a ContextKeyResolver, or resolver itself otherwise.
+func ResolverWithContext(ctx context.Context, resolver KeyResolver) KeyResolver {
+	contextual, ok := resolver.(ContextKeyResolver)
+	if !ok {
+		return resolver
+	}
+	return KeyResolverFunc(func(serviceID, keyID string) ([]byte, error) {
+		return contextual.ResolveKeyContext(ctx, serviceID, keyID)
+	})
+}
+
+// LookupResolver resolves keys with a PublicKeyLookup, which only knows each
+// service's current key: a keyID naming any other key is an error.
+func LookupResolver(lookup PublicKeyLookup) KeyResolver {
+	return KeyResolverFunc(func(serviceID, keyID string) ([]byte, error) {
+		publicKey, err := lookup(serviceID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve public key: %w", err)
+		}
+		return publicKey, CheckKeyID(publicKey, keyID)
+	})
+}
+
+// CheckKeyID reports whether publicKey is the key keyID names; an empty
+// keyID names any key.
+func CheckKeyID(publicKey []byte, keyID string) error {
+	if keyID != "" && keyID != KeyFingerprint(publicKey) {
+		return fmt.Errorf("signed with key %s, registry has %s", keyID, KeyFingerprint(publicKey))
+	}
+	return nil
+}
+
+// CachingResolver remembers the keys another resolver returns for ttl, by
+// key ID as well as each service's current key, so verification only waits
+// on the other resolver for keys it has not seen recently. Keys a service
+// rotated away from keep resolving by ID until they expire.
+type CachingResolver struct {
+	next    KeyResolver
+	ttl     time.Duration
+	mutex   sync.RWMutex
+	byID    map[string]cachedResolvedKey // serviceID + "/" + keyID
+	current map[string]cachedResolvedKey // serviceID
+}
+
+type cachedResolvedKey struct {
+	publicKey []byte
+	expires   time.Time
+}
+
+func NewCachingResolver(next KeyResolver, ttl time.Duration) *CachingResolver {
+	return &CachingResolver{
+		next:    next,
+		ttl:     ttl,
+		byID:    make(map[string]cachedResolvedKey),
+		current: make(map[string]cachedResolvedKey),
+	}
+}
+
+func (c *CachingResolver) ResolveKey(serviceID, keyID string) ([]byte, error) {
+	c.mutex.RLock()
+	cached, found := c.current[serviceID]
+	if keyID != "" {
+		cached, found = c.byID[serviceID+"/"+keyID]
+	}
+	c.mutex.RUnlock()
+	if found && time.Now().Before(cached.expires) {
+		return cached.publicKey, nil
+	}
+
+	publicKey, err := c.next.ResolveKey(serviceID, keyID)
+	if err != nil {
+		return nil, err
+	}
+	c.Add(serviceID, publicKey, keyID == "")
+	return publicKey, nil
+}
+
+// Add caches publicKey as one of serviceID's keys, and as its current key
+// if current is set, e.g. to seed the cache from the local key store.
+func (c *CachingResolver) Add(serviceID string, publicKey []byte, current bool) {
+	entry := cachedResolvedKey{publicKey: publicKey, expires: time.Now().Add(c.ttl)}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.byID[serviceID+"/"+KeyFingerprint(publicKey)] = entry
+	if current {
+		c.current[serviceID] = entry
+	}
+	for key, cached := range c.byID {
+		if time.Now().After(cached.expires) {
+			delete(c.byID, key)
+		}
+	}
+}
+
+// Resolver checks every key next resolves against the pins, pinning keys of
+// services seen for the first time. It cannot follow rotation records, so
+// put it behind a RegistryClient with the same pin store, and in front of a
+// CachingResolver, which spares it hashing every key on every request.
+func (s *PinStore) Resolver(next KeyResolver) KeyResolver {
+	return KeyResolverFunc(func(serviceID, keyID string) ([]byte, error) {
+		publicKey, err := next.ResolveKey(serviceID, keyID)
+		if err != nil {
+			return nil, err
+		}
+		if err := s.Check(serviceID, "", publicKey, nil); err != nil {
+			return nil, err
+		}
+		return publicKey, nil
+	})
+}
+
+// ResolveKey returns the key keyID of the identity serviceID, whatever its
+// state, or the key the selector would sign with if keyID is empty.
+func (k *Keyring) ResolveKey(serviceID, keyID string) ([]byte, error) {
+	if keyID == "" {
+		signer, err := k.Signer(serviceID)
+		if err != nil {
+			return nil, err
+		}
+		return signer.GetPublicKeyBytes(), nil
+	}
+	entry, found := k.Lookup(keyID)
+	if !found || entry.Name != serviceID {
+		return nil, fmt.Errorf("key %s of %s is not in the keyring", keyID, serviceID)
+	}
+	return entry.Signer.GetPublicKeyBytes(), nil
+}
+
+// Verify checks a signed envelope, a models.ServiceRequest or
+// models.ServiceResponse: its signature, with the key its signer names in
+// the HeaderKeyID header or else the signer's current key, and every link
+// of its signature chain, with the key the link names. It does not check
+// freshness or replay, which depend on the receiver.
+func Verify(envelope interface{}, resolver KeyResolver) error {
+	var serviceID, alg string
+	var signature []byte
+	var headers map[string]string
+	var chain models.SignatureChain
+	var payload, chainPayload []byte
+	var err error
+
+	switch e := envelope.(type) {
+	case *models.ServiceRequest:
+		return Verify(*e, resolver)
+	case *models.ServiceResponse:
+		return Verify(*e, resolver)
+	case models.ServiceRequest:
+		serviceID, alg, signature, headers, chain = e.ServiceID, e.SignatureAlg, e.Signature, e.Headers, e.Chain
+		buf := bufpool.Get()
+		defer bufpool.Put(buf)
+		err = e.WriteSigningPayload(buf)
+		payload, chainPayload = buf.Bytes(), buf.Bytes()
+	case models.ServiceResponse:
+		serviceID, alg, signature, headers, chain = e.ServiceID, e.SignatureAlg, e.Signature, e.Headers, e.Chain
+		buf := bufpool.Get()
+		defer bufpool.Put(buf)
+		err = e.WriteChainPayload(buf)
+		chainPayload = buf.Bytes()
+		payload = chainPayload
+		if !models.ProtocolVersionAtLeast(e.ProtocolVersion, models.ProtocolVersion12) {
+			payload = e.Data
+		}
+	default:
+		return fmt.Errorf("cannot verify a %T", envelope)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to marshal envelope for verification: %w", err)
+	}
+
+	publicKey, err := resolver.ResolveKey(serviceID, headers[models.HeaderKeyID])
+	if err != nil {
+		return fmt.Errorf("failed to get public key: %w", err)
+	}
+	if err := VerifySignature(alg, publicKey, payload, signature); err != nil {
+		return fmt.Errorf("signature verification failed: %w", err)
+	}
+	if err := VerifySignatureChainWith(chain, chainPayload, resolver); err != nil {
+		return fmt.Errorf("signature chain verification failed: %w", err)
+	}
+	return nil
+}
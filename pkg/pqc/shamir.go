@@ -0,0 +1,263 @@
+package pqc
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// Signing keys too valuable to keep whole on one host, such as the auth
+// service's, can be split with Shamir's secret sharing into shares held by
+// different custodians, any threshold of which rebuild the key.
+
+const pemTypeKeyShare = "MESH KEY SHARE"
+
+// KeyShare is one share of a split signing key.
+type KeyShare struct {
+	ServiceID   string
+	Algorithm   string
+	Fingerprint string // of the public key, to check the rebuilt key
+	SplitID     string // shares of one split only combine with each other
+	Threshold   int
+	Total       int
+	Index       int // the share's x coordinate, 1 to Total
+	Value       []byte
+}
+
+// KeyShareFileName returns the file name the index'th share of serviceID's
+// signing key is written to.
+func KeyShareFileName(serviceID string, index int) string {
+	return fmt.Sprintf("%s_share_%d.pem", serviceID, index)
+}
+
+// SplitKey splits signer's private key into total shares, any threshold of
+// which rebuild it.
+func SplitKey(serviceID string, signer Signer, threshold, total int) ([]*KeyShare, error) {
+	if threshold < 2 || threshold > total || total > 255 {
+		return nil, fmt.Errorf("invalid split: need 2 <= threshold <= shares <= 255, got %d of %d", threshold, total)
+	}
+
+	splitID := make([]byte, 8)
+	if _, err := rand.Read(splitID); err != nil {
+		return nil, fmt.Errorf("failed to generate split ID: %w", err)
+	}
+
+	secret := signer.GetPrivateKeyBytes()
+	shares := make([]*KeyShare, total)
+	for i := range shares {
+		shares[i] = &KeyShare{
+			ServiceID:   serviceID,
+			Algorithm:   signer.Algorithm(),
+			Fingerprint: KeyFingerprint(signer.GetPublicKeyBytes()),
+			SplitID:     hex.EncodeToString(splitID),
+			Threshold:   threshold,
+			Total:       total,
+			Index:       i + 1,
+			Value:       make([]byte, len(secret)),
+		}
+	}
+
+	// Each secret byte is the constant term of its own random polynomial of
+	// degree threshold-1 over GF(2^8); share i holds every polynomial at x=i.
+	coefficients := make([]byte, threshold)
+	for b, secretByte := range secret {
+		if _, err := rand.Read(coefficients[1:]); err != nil {
+			return nil, fmt.Errorf("failed to generate share coefficients: %w", err)
+		}
+		coefficients[0] = secretByte
+		for _, share := range shares {
+			x := byte(share.Index)
+			var y byte
+			for c := threshold - 1; c >= 0; c-- {
+				y = gfMul(y, x) ^ coefficients[c]
+			}
+			share.Value[b] = y
+		}
+	}
+	clear(coefficients)
+	return shares, nil
+}
+
+// CombineKeyShares rebuilds a signing key from at least the threshold of
+// shares of one split, checking it against the fingerprint they record.
+func CombineKeyShares(shares []*KeyShare) (Signer, error) {
+	if len(shares) == 0 {
+		return nil, fmt.Errorf("no key shares given")
+	}
+	first := shares[0]
+	seen := make(map[int]bool)
+	for _, share := range shares {
+		if share.SplitID != first.SplitID || share.ServiceID != first.ServiceID || share.Algorithm != first.Algorithm ||
+			share.Fingerprint != first.Fingerprint || share.Threshold != first.Threshold || len(share.Value) != len(first.Value) {
+			return nil, fmt.Errorf("share %d is from a different split than share %d", share.Index, first.Index)
+		}
+		if share.Index < 1 || share.Index > 255 || seen[share.Index] {
+			return nil, fmt.Errorf("share %d is invalid or given twice", share.Index)
+		}
+		seen[share.Index] = true
+	}
+	if len(shares) < first.Threshold {
+		return nil, fmt.Errorf("%d of the %d shares needed to rebuild the key of %s were given", len(shares), first.Threshold, first.ServiceID)
+	}
+	shares = shares[:first.Threshold]
+
+	// Lagrange interpolation at x=0.
+	secret := make([]byte, len(first.Value))
+	for j, share := range shares {
+		xj := byte(share.Index)
+		basis := byte(1)
+		for m, other := range shares {
+			if m != j {
+				xm := byte(other.Index)
+				basis = gfMul(basis, gfMul(xm, gfInv(xm^xj)))
+			}
+		}
+		for b := range secret {
+			secret[b] ^= gfMul(share.Value[b], basis)
+		}
+	}
+
+	publicKey, err := derivePublicKey(first.Algorithm, secret)
+	if err != nil {
+		return nil, fmt.Errorf("shares did not rebuild a %s key: %w", first.Algorithm, err)
+	}
+	if KeyFingerprint(publicKey) != first.Fingerprint {
+		return nil, fmt.Errorf("shares rebuilt the wrong key for %s: a share is corrupt", first.ServiceID)
+	}
+	return LoadSigner(first.Algorithm, publicKey, secret)
+}
+
+// gfMul multiplies in GF(2^8) with the AES polynomial, without branching on
+// its operands.
+func gfMul(a, b byte) byte {
+	var product byte
+	for i := 0; i < 8; i++ {
+		product ^= -(b & 1) & a
+		a = (a << 1) ^ (-(a >> 7) & 0x1b)
+		b >>= 1
+	}
+	return product
+}
+
+// gfInv returns a's multiplicative inverse, a^254.
+func gfInv(a byte) byte {
+	result := byte(1)
+	for i := 0; i < 7; i++ {
+		a = gfMul(a, a)
+		result = gfMul(result, a)
+	}
+	return result
+}
+
+// Encode writes the share as a PEM block whose headers describe the split.
+func (s *KeyShare) Encode() []byte {
+	return pem.EncodeToMemory(&pem.Block{
+		Type: pemTypeKeyShare,
+		Headers: map[string]string{
+			"Service":     s.ServiceID,
+			"Algorithm":   s.Algorithm,
+			"Fingerprint": s.Fingerprint,
+			"Split":       s.SplitID,
+			"Threshold":   strconv.Itoa(s.Threshold),
+			"Shares":      strconv.Itoa(s.Total),
+			"Index":       strconv.Itoa(s.Index),
+		},
+		Bytes: s.Value,
+	})
+}
+
+// ParseKeyShare reads a share written by Encode.
+func ParseKeyShare(data []byte) (*KeyShare, error) {
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != pemTypeKeyShare {
+		return nil, fmt.Errorf("not a %s PEM block", pemTypeKeyShare)
+	}
+
+	share := &KeyShare{
+		ServiceID:   block.Headers["Service"],
+		Algorithm:   block.Headers["Algorithm"],
+		Fingerprint: block.Headers["Fingerprint"],
+		SplitID:     block.Headers["Split"],
+		Value:       block.Bytes,
+	}
+	for header, field := range map[string]*int{"Threshold": &share.Threshold, "Shares": &share.Total, "Index": &share.Index} {
+		value, err := strconv.Atoi(block.Headers[header])
+		if err != nil {
+			return nil, fmt.Errorf("invalid key share %s header %q", header, block.Headers[header])
+		}
+		*field = value
+	}
+	if share.ServiceID == "" || share.Fingerprint == "" || share.SplitID == "" {
+		return nil, fmt.Errorf("key share is missing its Service, Fingerprint or Split header")
+	}
+	return share, nil
+}
+
+// ReadKeyShares reads the share files at paths.
+func ReadKeyShares(paths []string) ([]*KeyShare, error) {
+	var shares []*KeyShare
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read key share: %w", err)
+		}
+		share, err := ParseKeyShare(data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse key share %s: %w", path, err)
+		}
+		shares = append(shares, share)
+	}
+	return shares, nil
+}
+
+// LoadSplitKeyPair is LoadKeyPair for a service whose private signing key
+// is not on disk but split into shares: it rebuilds the key from shares and
+// checks it against the public key in KeysDir.
+func LoadSplitKeyPair(serviceID string, shares []*KeyShare) (Signer, *KyberKeyPair, error) {
+	for _, share := range shares {
+		if share.ServiceID != serviceID {
+			return nil, nil, fmt.Errorf("key share %d is for %s, not %s", share.Index, share.ServiceID, serviceID)
+		}
+	}
+	signer, err := CombineKeyShares(shares)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	publicKey, err := LoadPublicKey(serviceID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if KeyFingerprint(publicKey) != KeyFingerprint(signer.GetPublicKeyBytes()) {
+		return nil, nil, fmt.Errorf("key shares are for key %s but the public key of %s is %s",
+			KeyFingerprint(signer.GetPublicKeyBytes()), serviceID, KeyFingerprint(publicKey))
+	}
+
+	kyberKeyPair, err := loadKEMKeyFiles(serviceID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	log.Printf("🧩 Signing key for %s rebuilt from %d of %d shares", serviceID, shares[0].Threshold, shares[0].Total)
+	return signer, kyberKeyPair, nil
+}
+
+// SavePrivateKey writes signer's private key back to serviceID's key file,
+// e.g. after rebuilding it from shares, leaving its other files as they are.
+func SavePrivateKey(serviceID string, signer Signer) error {
+	algorithm := signer.Algorithm()
+	_, signerPriv := SignatureKeyFileNames(serviceID, algorithm)
+	data, err := encodeKey(algorithm, privateKeyKind, signer.GetPrivateKeyBytes(), KeysPassphrase)
+	if err != nil {
+		return fmt.Errorf("failed to encode %s private key: %w", algorithm, err)
+	}
+	if err := writeKeyFile(filepath.Join(KeysDir, signerPriv), data, 0600); err != nil {
+		return fmt.Errorf("failed to save %s private key: %w", algorithm, err)
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+package pqc
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Signer is a service's signing key, whatever its algorithm. Everything
+// that signs for an identity goes through it, so a deployment can choose
+// ML-DSA or hash-based signatures without the rest of the mesh knowing.
+type Signer interface {
+	// Algorithm is the key store name of the algorithm, e.g. dilithium3.
+	Algorithm() string
+	Sign(data []byte) ([]byte, error)
+	GetPublicKeyBytes() []byte
+	GetPrivateKeyBytes() []byte
+}
+
+// Decapsulator is a service's long-term KEM key, wherever its private half
+// is held.
+type Decapsulator interface {
+	Algorithm() string
+	GetPublicKeyBytes() []byte
+	Decapsulate(ciphertext []byte) ([]byte, error)
+}
+
+func (d *DilithiumKeyPair) Algorithm() string {
+	return AlgorithmDilithium3
+}
+
+// GenerateSigner generates a signing key for algorithm, one of
+// SignatureAlgorithms or a SPHINCS+ alias of an SLH-DSA one.
+func GenerateSigner(algorithm string) (Signer, error) {
+	if slhdsa, ok := SLHDSAForSPHINCS(algorithm); ok {
+		algorithm = slhdsa
+	}
+	if algorithm == AlgorithmDilithium3 {
+		return GenerateDilithiumKeyPair()
+	}
+	if algorithm == AlgorithmMLDSA65 {
+		return GenerateMLDSAKeyPair()
+	}
+	if IsSLHDSA(algorithm) {
+		return GenerateSLHDSAKeyPair(algorithm)
+	}
+	return nil, fmt.Errorf("unsupported signature algorithm %q", algorithm)
+}
+
+// LoadSigner restores an algorithm signing key from its key bytes.
+func LoadSigner(algorithm string, publicKeyBytes, privateKeyBytes []byte) (Signer, error) {
+	if slhdsa, ok := SLHDSAForSPHINCS(algorithm); ok {
+		algorithm = slhdsa
+	}
+	if algorithm == AlgorithmDilithium3 {
+		return LoadDilithiumKeyPair(publicKeyBytes, privateKeyBytes)
+	}
+	if algorithm == AlgorithmMLDSA65 {
+		return LoadMLDSAKeyPair(publicKeyBytes, privateKeyBytes)
+	}
+	if IsSLHDSA(algorithm) {
+		return LoadSLHDSAKeyPair(algorithm, publicKeyBytes, privateKeyBytes)
+	}
+	return nil, fmt.Errorf("unsupported signature algorithm %q", algorithm)
+}
+
+// SignatureAlgorithm returns the key store name of alg, which may be in any
+// case, so JWS "alg" values and envelope algorithm IDs are accepted too. An
+// empty alg is Dilithium3: peers that predate algorithm IDs sign with it.
+// SPHINCS+ names resolve to their SLH-DSA parameter set.
+func SignatureAlgorithm(alg string) (string, error) {
+	algorithm := strings.ToLower(alg)
+	if algorithm == "" {
+		return AlgorithmDilithium3, nil
+	}
+	if slhdsa, ok := SLHDSAForSPHINCS(algorithm); ok {
+		return slhdsa, nil
+	}
+	if !slices.Contains(SignatureAlgorithms, algorithm) {
+		return "", fmt.Errorf("unsupported signature algorithm %q", alg)
+	}
+	return algorithm, nil
+}
+
+// EnvelopeAlg is the algorithm ID signer's envelopes carry. Dilithium3
+// envelopes carry none, so they stay byte-for-byte what older peers sign
+// and verify.
+func EnvelopeAlg(signer Signer) string {
+	if signer.Algorithm() == AlgorithmDilithium3 {
+		return ""
+	}
+	return JWSAlg(signer.Algorithm())
+}
+
+// VerifySignature checks signature over data with publicKeyBytes, using the
+// algorithm alg names; see SignatureAlgorithm for the names accepted.
+// Outcomes are remembered for a while, so a payload signed once and sent
+// again, as retries and status polls are, is only verified once; see
+// SetVerificationCache.
+func VerifySignature(alg string, publicKeyBytes, data, signature []byte) error {
+	algorithm, err := SignatureAlgorithm(alg)
+	if err != nil {
+		return err
+	}
+	return verifications.verify(algorithm, publicKeyBytes, data, signature, func() error {
+		switch algorithm {
+		case AlgorithmDilithium3:
+			return VerifyDilithiumSignature(publicKeyBytes, data, signature)
+		case AlgorithmMLDSA65:
+			return VerifyMLDSASignature(publicKeyBytes, data, signature)
+		}
+		return VerifySLHDSASignature(algorithm, publicKeyBytes, data, signature)
+	})
+}
@@ -0,0 +1,182 @@
+package pqc
+
+import (
+	"crypto/rand"
+	"fmt"
+	"log"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/cloudflare/circl/sign/slhdsa"
+)
+
+// AlgorithmSLHDSASHA2128s is the SLH-DSA parameter set with the smallest
+// signatures, and the one to choose unless a policy names another.
+const AlgorithmSLHDSASHA2128s = "slh-dsa-sha2-128s"
+
+// SLHDSAAlgorithms lists every SLH-DSA parameter set, named as in FIPS 205
+// but in lower case, e.g. slh-dsa-shake-256f.
+var SLHDSAAlgorithms = slhdsaAlgorithms()
+
+func slhdsaAlgorithms() []string {
+	var algorithms []string
+	for id := slhdsa.ID(1); id.IsValid(); id++ {
+		algorithms = append(algorithms, strings.ToLower(id.String()))
+	}
+	return algorithms
+}
+
+// IsSLHDSA reports whether algorithm is an SLH-DSA parameter set.
+func IsSLHDSA(algorithm string) bool {
+	_, err := slhdsaID(algorithm)
+	return err == nil
+}
+
+func slhdsaID(algorithm string) (slhdsa.ID, error) {
+	if !strings.HasPrefix(strings.ToLower(algorithm), "slh-dsa-") {
+		return 0, fmt.Errorf("not an SLH-DSA algorithm: %s", algorithm)
+	}
+	return slhdsa.IDByName(algorithm)
+}
+
+// SLHDSAForSPHINCS returns the SLH-DSA parameter set FIPS 205 standardised
+// a SPHINCS+ one as, e.g. slh-dsa-sha2-128s for sphincs+-sha2-128s-simple
+// or oqs-provider's sphincssha2128ssimple. SPHINCS+ names are aliases of
+// that parameter set: keys and signatures are SLH-DSA's, as FIPS 205
+// changed them, and the robust variants, which it dropped, have none.
+func SLHDSAForSPHINCS(algorithm string) (string, bool) {
+	name := strings.NewReplacer("-", "", "_", "", "+", "").Replace(strings.ToLower(algorithm))
+	name, sphincs := strings.CutPrefix(strings.Replace(name, "sphincsplus", "sphincs", 1), "sphincs")
+	if !sphincs {
+		return "", false
+	}
+	name = strings.TrimSuffix(name, "simple")
+	for _, slhdsa := range SLHDSAAlgorithms {
+		if strings.ReplaceAll(strings.TrimPrefix(slhdsa, "slh-dsa-"), "-", "") == name {
+			return slhdsa, true
+		}
+	}
+	return "", false
+}
+
+// SLHDSAKeyPair is a stateless hash-based (FIPS 205) signing key. Its
+// security rests only on the hash function, not on lattice assumptions,
+// at the cost of signatures several times larger than Dilithium's and much
+// slower signing. Its keys are seeds and a root hash with nothing to
+// precompute: every signature rebuilds the hypertree path it needs.
+type SLHDSAKeyPair struct {
+	PublicKey  slhdsa.PublicKey
+	PrivateKey slhdsa.PrivateKey
+}
+
+func GenerateSLHDSAKeyPair(algorithm string) (*SLHDSAKeyPair, error) {
+	id, err := slhdsaID(algorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("🔑 Generating %s keypair...", id)
+	start := time.Now()
+
+	publicKey, privateKey, err := slhdsa.GenerateKey(rand.Reader, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate %s keypair: %w", id, err)
+	}
+
+	scheme := id.Scheme()
+	log.Printf("✅ %s keypair generated in %v", id, time.Since(start))
+	slog.Debug("📏 SLH-DSA key sizes", "algorithm", strings.ToLower(id.String()), "public_key_bytes", scheme.PublicKeySize(),
+		"private_key_bytes", scheme.PrivateKeySize(), "signature_bytes", scheme.SignatureSize())
+
+	return &SLHDSAKeyPair{PublicKey: publicKey, PrivateKey: privateKey}, nil
+}
+
+func LoadSLHDSAKeyPair(algorithm string, publicKeyBytes, privateKeyBytes []byte) (*SLHDSAKeyPair, error) {
+	id, err := slhdsaID(algorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	scheme := id.Scheme()
+	if len(publicKeyBytes) != scheme.PublicKeySize() {
+		return nil, fmt.Errorf("invalid public key size: expected %d, got %d", scheme.PublicKeySize(), len(publicKeyBytes))
+	}
+	if len(privateKeyBytes) != scheme.PrivateKeySize() {
+		return nil, fmt.Errorf("invalid private key size: expected %d, got %d", scheme.PrivateKeySize(), len(privateKeyBytes))
+	}
+
+	keyPair := &SLHDSAKeyPair{
+		PublicKey:  slhdsa.PublicKey{ID: id},
+		PrivateKey: slhdsa.PrivateKey{ID: id},
+	}
+	if err := keyPair.PublicKey.UnmarshalBinary(publicKeyBytes); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal public key: %w", err)
+	}
+	if err := keyPair.PrivateKey.UnmarshalBinary(privateKeyBytes); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal private key: %w", err)
+	}
+	if !keyPair.PrivateKey.PublicKey().Equal(keyPair.PublicKey) {
+		return nil, fmt.Errorf("public key does not belong to private key")
+	}
+	return keyPair, nil
+}
+
+func (s *SLHDSAKeyPair) Algorithm() string {
+	return strings.ToLower(s.PrivateKey.ID.String())
+}
+
+func (s *SLHDSAKeyPair) Sign(data []byte) ([]byte, error) {
+	start := time.Now()
+
+	signature, err := slhdsa.SignRandomized(&s.PrivateKey, rand.Reader, slhdsa.NewMessage(data), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign with %s: %w", s.PrivateKey.ID, err)
+	}
+
+	slog.Debug("🖊️  Data signed", "algorithm", s.Algorithm(), "data_bytes", len(data),
+		"signature_bytes", len(signature), "duration", time.Since(start))
+	return signature, nil
+}
+
+func (s *SLHDSAKeyPair) GetPublicKeyBytes() []byte {
+	key, _ := s.PublicKey.MarshalBinary()
+	return key
+}
+
+func (s *SLHDSAKeyPair) GetPrivateKeyBytes() []byte {
+	key, _ := s.PrivateKey.MarshalBinary()
+	return key
+}
+
+func VerifySLHDSASignature(algorithm string, publicKeyBytes, data, signature []byte) error {
+	id, err := slhdsaID(algorithm)
+	if err != nil {
+		return err
+	}
+
+	start := time.Now()
+
+	publicKey, err := expandPublicKey(strings.ToLower(id.String()), publicKeyBytes, func(publicKeyBytes []byte) (*slhdsa.PublicKey, error) {
+		scheme := id.Scheme()
+		if len(publicKeyBytes) != scheme.PublicKeySize() {
+			return nil, fmt.Errorf("invalid public key size for %s: expected %d, got %d", id, scheme.PublicKeySize(), len(publicKeyBytes))
+		}
+		publicKey := &slhdsa.PublicKey{ID: id}
+		if err := publicKey.UnmarshalBinary(publicKeyBytes); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal public key: %w", err)
+		}
+		return publicKey, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if !slhdsa.Verify(publicKey, slhdsa.NewMessage(data), signature, nil) {
+		slog.Debug("🔍 Signature invalid", "algorithm", algorithm, "data_bytes", len(data), "duration", time.Since(start))
+		return fmt.Errorf("invalid signature")
+	}
+
+	slog.Debug("🔍 Signature verified", "algorithm", algorithm, "data_bytes", len(data), "duration", time.Since(start))
+	return nil
+}
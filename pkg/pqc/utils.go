@@ -6,90 +6,333 @@ import (
 	"crypto/sha256"
 	"crypto/x509"
 	"encoding/base64"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"io/fs"
 	"log"
+	"log/slog"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 	"time"
 )
 
-func SaveKeyPair(serviceID string, dilithiumKeyPair *DilithiumKeyPair, kyberKeyPair *KyberKeyPair) error {
-	keysDir := "keys"
-	if err := os.MkdirAll(keysDir, 0755); err != nil {
-		return fmt.Errorf("failed to create keys directory: %w", err)
+// KeysDir is the directory SaveKeyPair and LoadKeyPair use.
+var KeysDir = "keys"
+
+// KeyFileNames returns the file names serviceID's Dilithium public and
+// private keys and Kyber public and private keys are stored under.
+func KeyFileNames(serviceID string) (dilithiumPub, dilithiumPriv, kyberPub, kyberPriv string) {
+	return serviceID + "_dilithium.pub", serviceID + "_dilithium.key",
+		serviceID + "_kyber.pub", serviceID + "_kyber.key"
+}
+
+// SignatureKeyFileNames returns the file names serviceID's public and
+// private signing keys of algorithm are stored under. Dilithium keys keep the
+// names KeyFileNames gives them; SLH-DSA keys of every parameter set share
+// one pair of names, as their PEM blocks name the parameter set.
+func SignatureKeyFileNames(serviceID, algorithm string) (pub, priv string) {
+	if algorithm == AlgorithmMLDSA65 {
+		return serviceID + "_mldsa.pub", serviceID + "_mldsa.key"
+	}
+	if IsSLHDSA(algorithm) {
+		return serviceID + "_slhdsa.pub", serviceID + "_slhdsa.key"
 	}
+	pub, priv, _, _ = KeyFileNames(serviceID)
+	return pub, priv
+}
 
-	dilithiumPubPath := filepath.Join(keysDir, fmt.Sprintf("%s_dilithium.pub", serviceID))
-	dilithiumPrivPath := filepath.Join(keysDir, fmt.Sprintf("%s_dilithium.key", serviceID))
-	kyberPubPath := filepath.Join(keysDir, fmt.Sprintf("%s_kyber.pub", serviceID))
-	kyberPrivPath := filepath.Join(keysDir, fmt.Sprintf("%s_kyber.key", serviceID))
+// SaveKeyPair writes serviceID's keys to KeysDir in KeysFormat, encrypting
+// the private keys if KeysPassphrase is set, and records their creation and
+// expiry in the key metadata. A signing key of another
+// algorithm left from before is removed, so a service only ever has one.
+// With EscrowKeyFile set, the private keys are also escrowed to it. With
+// KeystoreFile set, the keys are saved there instead of in KeysDir.
+func SaveKeyPair(serviceID string, signer Signer, kyberKeyPair *KyberKeyPair) error {
+	return saveKeyPair(serviceID, signer, kyberKeyPair, newKeyMetadata(signer))
+}
 
-	dilithiumPubBytes := dilithiumKeyPair.GetPublicKeyBytes()
-	dilithiumPrivBytes := dilithiumKeyPair.GetPrivateKeyBytes()
-	kyberPubBytes := kyberKeyPair.GetPublicKeyBytes()
-	kyberPrivBytes := kyberKeyPair.GetPrivateKeyBytes()
+// RestoreKeyPair is SaveKeyPair for keys recovered from a backup, which keep
+// the metadata recorded when they were created.
+func RestoreKeyPair(serviceID string, signer Signer, kyberKeyPair *KyberKeyPair, metadata *KeyMetadata) error {
+	return saveKeyPair(serviceID, signer, kyberKeyPair, metadata)
+}
 
-	if err := os.WriteFile(dilithiumPubPath, dilithiumPubBytes, 0644); err != nil {
-		return fmt.Errorf("failed to save Dilithium public key: %w", err)
+func saveKeyPair(serviceID string, signer Signer, kyberKeyPair *KyberKeyPair, metadata *KeyMetadata) error {
+	metadata.KEMAlgorithm = kyberKeyPair.Algorithm()
+	if KeystoreFile != "" {
+		if err := saveKeystoreKeyPair(serviceID, signer, kyberKeyPair, metadata); err != nil {
+			return err
+		}
+		if EscrowKeyFile != "" {
+			return saveEscrow(serviceID, signer, kyberKeyPair, metadata)
+		}
+		return nil
 	}
 
-	if err := os.WriteFile(dilithiumPrivPath, dilithiumPrivBytes, 0600); err != nil {
-		return fmt.Errorf("failed to save Dilithium private key: %w", err)
+	// Namespaced services, tenant/service, keep their keys in a directory
+	// per namespace.
+	keysDir := KeysDir
+	if err := os.MkdirAll(filepath.Join(keysDir, filepath.Dir(serviceID)), 0755); err != nil {
+		return fmt.Errorf("failed to create keys directory: %w", err)
 	}
 
-	if err := os.WriteFile(kyberPubPath, kyberPubBytes, 0644); err != nil {
-		return fmt.Errorf("failed to save Kyber public key: %w", err)
+	algorithm, kemAlgorithm := signer.Algorithm(), kyberKeyPair.Algorithm()
+	signerPub, signerPriv := SignatureKeyFileNames(serviceID, algorithm)
+	_, _, kyberPub, kyberPriv := KeyFileNames(serviceID)
+	files := []struct {
+		name, description, algorithm, kind string
+		key                                []byte
+		perm                               os.FileMode
+	}{
+		{signerPub, algorithm + " public key", algorithm, publicKeyKind, signer.GetPublicKeyBytes(), 0644},
+		{signerPriv, algorithm + " private key", algorithm, privateKeyKind, signer.GetPrivateKeyBytes(), 0600},
+		{kyberPub, kemAlgorithm + " public key", kemAlgorithm, publicKeyKind, kyberKeyPair.GetPublicKeyBytes(), 0644},
+		{kyberPriv, kemAlgorithm + " private key", kemAlgorithm, privateKeyKind, kyberKeyPair.GetPrivateKeyBytes(), 0600},
 	}
 
-	if err := os.WriteFile(kyberPrivPath, kyberPrivBytes, 0600); err != nil {
-		return fmt.Errorf("failed to save Kyber private key: %w", err)
+	for _, file := range files {
+		data, err := encodeKey(file.algorithm, file.kind, file.key, KeysPassphrase)
+		if err != nil {
+			return fmt.Errorf("failed to encode %s: %w", file.description, err)
+		}
+		if err := writeKeyFile(filepath.Join(keysDir, file.name), data, file.perm); err != nil {
+			return fmt.Errorf("failed to save %s: %w", file.description, err)
+		}
+	}
+
+	for _, other := range signatureKeyFileAlgorithms {
+		otherPub, otherPriv := SignatureKeyFileNames(serviceID, other)
+		if otherPub == signerPub {
+			continue
+		}
+		for _, name := range []string{otherPub, otherPriv} {
+			if err := os.Remove(filepath.Join(keysDir, name)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return fmt.Errorf("failed to remove old signing key %s: %w", name, err)
+			}
+		}
+	}
+
+	if err := saveKeyMetadata(serviceID, metadata); err != nil {
+		return err
+	}
+	if EscrowKeyFile != "" {
+		if err := saveEscrow(serviceID, signer, kyberKeyPair, metadata); err != nil {
+			return err
+		}
 	}
 
 	log.Printf("✅ Keys saved for service %s in %s directory", serviceID, keysDir)
 	return nil
 }
 
-func LoadKeyPair(serviceID string) (*DilithiumKeyPair, *KyberKeyPair, error) {
-	keysDir := "keys"
+// LoadKeyPair reads serviceID's keys from KeysDir in any key store format,
+// whichever algorithm its signing key uses, or from KeystoreFile if set.
+func LoadKeyPair(serviceID string) (Signer, *KyberKeyPair, error) {
+	if KeystoreFile != "" {
+		return loadKeystoreKeyPair(serviceID)
+	}
+	algorithm, err := StoredSignatureAlgorithm(serviceID)
+	if err != nil {
+		return nil, nil, err
+	}
+	signerPub, signerPriv := SignatureKeyFileNames(serviceID, algorithm)
+
+	signerPubBytes, err := readKeyFile(signerPub, algorithm+" public key", algorithm, publicKeyKind)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	signerPrivBytes, err := readKeyFile(signerPriv, algorithm+" private key", algorithm, privateKeyKind)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	signer, err := LoadSigner(algorithm, signerPubBytes, signerPrivBytes)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load %s keypair: %w", algorithm, err)
+	}
+
+	kyberKeyPair, err := loadKEMKeyFiles(serviceID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	log.Printf("✅ Keys loaded for service %s (%s)", serviceID, algorithm)
+	return signer, kyberKeyPair, nil
+}
+
+// RewriteKeyFiles re-encodes serviceID's key files in KeysFormat, e.g. to
+// hand them to OpenSSL, without changing the keys or their metadata. The
+// files are read with KeysPassphrase and the private keys written encrypted
+// under passphrase, or unencrypted if it is empty.
+func RewriteKeyFiles(serviceID string, passphrase []byte) error {
+	algorithm, err := StoredSignatureAlgorithm(serviceID)
+	if err != nil {
+		return err
+	}
+	kemAlgorithm, err := StoredKEMAlgorithm(serviceID)
+	if err != nil {
+		return err
+	}
+	signerPub, signerPriv := SignatureKeyFileNames(serviceID, algorithm)
+	_, _, kyberPub, kyberPriv := KeyFileNames(serviceID)
+	files := []struct {
+		name, description, algorithm, kind string
+		key                                []byte
+	}{
+		{name: signerPub, description: algorithm + " public key", algorithm: algorithm, kind: publicKeyKind},
+		{name: signerPriv, description: algorithm + " private key", algorithm: algorithm, kind: privateKeyKind},
+		{name: kyberPub, description: kemAlgorithm + " public key", algorithm: kemAlgorithm, kind: publicKeyKind},
+		{name: kyberPriv, description: kemAlgorithm + " private key", algorithm: kemAlgorithm, kind: privateKeyKind},
+	}
 
-	dilithiumPubPath := filepath.Join(keysDir, fmt.Sprintf("%s_dilithium.pub", serviceID))
-	dilithiumPrivPath := filepath.Join(keysDir, fmt.Sprintf("%s_dilithium.key", serviceID))
-	kyberPubPath := filepath.Join(keysDir, fmt.Sprintf("%s_kyber.pub", serviceID))
-	kyberPrivPath := filepath.Join(keysDir, fmt.Sprintf("%s_kyber.key", serviceID))
+	// Read every file before writing any, so a bad one leaves all intact.
+	for i := range files {
+		if files[i].key, err = readKeyFile(files[i].name, files[i].description, files[i].algorithm, files[i].kind); err != nil {
+			return err
+		}
+	}
+	for _, file := range files {
+		data, err := encodeKey(file.algorithm, file.kind, file.key, passphrase)
+		if err != nil {
+			return fmt.Errorf("failed to encode %s: %w", file.description, err)
+		}
+		if err := writeKeyFile(filepath.Join(KeysDir, file.name), data, 0600); err != nil {
+			return fmt.Errorf("failed to save %s: %w", file.description, err)
+		}
+	}
 
-	dilithiumPubBytes, err := os.ReadFile(dilithiumPubPath)
+	log.Printf("✅ Keys for service %s rewritten in %s format", serviceID, KeysFormat)
+	return nil
+}
+
+// LoadPublicKey reads only serviceID's public signing key from KeysDir,
+// or KeystoreFile, which is all verifying its signatures needs.
+func LoadPublicKey(serviceID string) ([]byte, error) {
+	if KeystoreFile != "" {
+		entry, err := keystoreEntryFor(serviceID)
+		if err != nil {
+			return nil, err
+		}
+		return entry.PublicKey, nil
+	}
+	algorithm, err := StoredSignatureAlgorithm(serviceID)
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed to read Dilithium public key: %w", err)
+		return nil, err
 	}
+	signerPub, _ := SignatureKeyFileNames(serviceID, algorithm)
+	return readKeyFile(signerPub, algorithm+" public key", algorithm, publicKeyKind)
+}
 
-	dilithiumPrivBytes, err := os.ReadFile(dilithiumPrivPath)
+// signatureKeyFileAlgorithms holds an algorithm for each pair of names
+// SignatureKeyFileNames gives signing keys.
+var signatureKeyFileAlgorithms = []string{AlgorithmDilithium3, AlgorithmMLDSA65, AlgorithmSLHDSASHA2128s}
+
+// StoredSignatureAlgorithm returns the algorithm of serviceID's signing key
+// in KeysDir, going by which public key file exists and, for PEM-only
+// algorithms, the algorithm its PEM block names. With no key at all it
+// returns Dilithium3, and reading the key then fails as not found.
+func StoredSignatureAlgorithm(serviceID string) (string, error) {
+	algorithm, found := AlgorithmDilithium3, ""
+	dilithiumPub, _ := SignatureKeyFileNames(serviceID, AlgorithmDilithium3)
+	if _, err := os.Stat(filepath.Join(KeysDir, dilithiumPub)); err == nil {
+		found = dilithiumPub
+	}
+
+	for _, other := range signatureKeyFileAlgorithms[1:] {
+		pub, _ := SignatureKeyFileNames(serviceID, other)
+		data, err := os.ReadFile(filepath.Join(KeysDir, pub))
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return "", fmt.Errorf("failed to read signing public key: %w", err)
+		}
+		if found != "" {
+			return "", fmt.Errorf("both %s and %s exist: remove the signing key %s does not use", found, pub, serviceID)
+		}
+
+		keyFile, err := ParseKeyFile(data)
+		if err != nil || keyFile.Format != KeyFormatPEM || keyFile.Private {
+			return "", fmt.Errorf("%s is not a signing public key in PEM format", pub)
+		}
+		if expected, _ := SignatureKeyFileNames(serviceID, keyFile.Algorithm); expected != pub {
+			return "", fmt.Errorf("%s holds a %s key, which belongs in %s", pub, keyFile.Algorithm, expected)
+		}
+		algorithm, found = keyFile.Algorithm, pub
+	}
+	return algorithm, nil
+}
+
+// StoredKEMAlgorithm returns the algorithm of serviceID's KEM key in
+// KeysDir. Raw and oqs-provider keys are Kyber768; a PEM key is the
+// algorithm its block names. With no key it returns Kyber768, and reading
+// the key then fails as not found.
+func StoredKEMAlgorithm(serviceID string) (string, error) {
+	_, _, kyberPub, _ := KeyFileNames(serviceID)
+	data, err := os.ReadFile(filepath.Join(KeysDir, kyberPub))
+	if errors.Is(err, fs.ErrNotExist) {
+		return AlgorithmKyber768, nil
+	}
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed to read Dilithium private key: %w", err)
+		return "", fmt.Errorf("failed to read KEM public key: %w", err)
 	}
 
-	kyberPubBytes, err := os.ReadFile(kyberPubPath)
+	keyFile, err := ParseKeyFile(data)
+	if err != nil || keyFile.Private || !slices.Contains(KEMAlgorithms, keyFile.Algorithm) {
+		return "", fmt.Errorf("%s is not a KEM public key", kyberPub)
+	}
+	return keyFile.Algorithm, nil
+}
+
+// loadKEMKeyFiles reads serviceID's KEM key from KeysDir.
+func loadKEMKeyFiles(serviceID string) (*KyberKeyPair, error) {
+	algorithm, err := StoredKEMAlgorithm(serviceID)
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed to read Kyber public key: %w", err)
+		return nil, err
 	}
+	_, _, kyberPub, kyberPriv := KeyFileNames(serviceID)
 
-	kyberPrivBytes, err := os.ReadFile(kyberPrivPath)
+	kyberPubBytes, err := readKeyFile(kyberPub, algorithm+" public key", algorithm, publicKeyKind)
+	if err != nil {
+		return nil, err
+	}
+	kyberPrivBytes, err := readKeyFile(kyberPriv, algorithm+" private key", algorithm, privateKeyKind)
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed to read Kyber private key: %w", err)
+		return nil, err
 	}
 
-	dilithiumKeyPair, err := LoadDilithiumKeyPair(dilithiumPubBytes, dilithiumPrivBytes)
+	kyberKeyPair, err := LoadKEMKeyPair(algorithm, kyberPubBytes, kyberPrivBytes)
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed to load Dilithium keypair: %w", err)
+		return nil, fmt.Errorf("failed to load %s keypair: %w", algorithm, err)
 	}
+	return kyberKeyPair, nil
+}
 
-	kyberKeyPair, err := LoadKyberKeyPair(kyberPubBytes, kyberPrivBytes)
+func readKeyFile(name, description, algorithm, kind string) ([]byte, error) {
+	path := filepath.Join(KeysDir, name)
+	data, err := os.ReadFile(path)
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed to load Kyber keypair: %w", err)
+		return nil, fmt.Errorf("failed to read %s: %w", description, err)
+	}
+	if kind == privateKeyKind {
+		checkPrivateKeyFile(path)
 	}
+	key, err := decodeKey(algorithm, kind, data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode %s: %w", description, err)
+	}
+	return key, nil
+}
 
-	log.Printf("✅ Keys loaded for service %s", serviceID)
-	return dilithiumKeyPair, kyberKeyPair, nil
+// KeyFingerprint identifies a public key by the first 16 bytes of its SHA-256
+// digest, hex encoded. It is used as the key ID in signatures and logs.
+func KeyFingerprint(publicKey []byte) string {
+	digest := sha256.Sum256(publicKey)
+	return hex.EncodeToString(digest[:16])
 }
 
 // DeserializePublicKeyFromJSON converts a JSON-marshaled public key back to []byte.
@@ -97,6 +340,9 @@ func LoadKeyPair(serviceID string) (*DilithiumKeyPair, *KyberKeyPair, error) {
 // 1. Base64-encoded string (most common with []byte JSON marshaling)
 // 2. Array of numbers ([]interface{} from JSON unmarshaling)
 // 3. Direct []byte (fallback, rarely occurs with JSON)
+//
+// Deprecated: auth services speaking protocol 1.3 or newer return a typed
+// models.PublicKeyResponse; decode that instead. This remains for pre-1.3 peers.
 func DeserializePublicKeyFromJSON(keyData map[string]interface{}) ([]byte, error) {
 	// Try to get the public_key field from the JSON data
 	publicKeyRaw, exists := keyData["public_key"]
@@ -110,7 +356,7 @@ func DeserializePublicKeyFromJSON(keyData map[string]interface{}) ([]byte, error
 		if err != nil {
 			return nil, fmt.Errorf("failed to decode base64 public key: %w", err)
 		}
-		log.Printf("🔄 Successfully deserialized public key (%d bytes) from base64 string", len(pubKey))
+		slog.Debug("🔄 Deserialized public key from base64 string", "public_key_bytes", len(pubKey))
 		return pubKey, nil
 	}
 
@@ -125,13 +371,13 @@ func DeserializePublicKeyFromJSON(keyData map[string]interface{}) ([]byte, error
 				return nil, fmt.Errorf("invalid public key byte format at index %d: expected float64 but got %T", i, v)
 			}
 		}
-		log.Printf("🔄 Successfully deserialized public key (%d bytes) from JSON array", len(pubKey))
+		slog.Debug("🔄 Deserialized public key from JSON array", "public_key_bytes", len(pubKey))
 		return pubKey, nil
 	}
 
 	// Case 3: Direct []byte (fallback, shouldn't happen with JSON but be safe)
 	if directBytes, ok := publicKeyRaw.([]byte); ok {
-		log.Printf("🔄 Using direct byte slice for public key (%d bytes)", len(directBytes))
+		slog.Debug("🔄 Using direct byte slice for public key", "public_key_bytes", len(directBytes))
 		return directBytes, nil
 	}
 
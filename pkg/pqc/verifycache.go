@@ -0,0 +1,140 @@
+package pqc
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Verification cache defaults: enough entries for every peer's retries and
+// status polls, kept briefly.
+const (
+	DefaultVerificationCacheSize = 4096
+	DefaultVerificationCacheTTL  = 30 * time.Second
+)
+
+// verificationKey identifies one verification: a signature by a key over a
+// payload, each by its SHA-256.
+type verificationKey struct {
+	algorithm string
+	publicKey [sha256.Size]byte
+	payload   [sha256.Size]byte
+	signature [sha256.Size]byte
+}
+
+type verificationEntry struct {
+	key     verificationKey
+	err     error
+	expires time.Time
+}
+
+// VerificationCacheStats counts verification cache lookups since the
+// process started.
+type VerificationCacheStats struct {
+	Enabled bool
+	Hits    uint64
+	Misses  uint64
+	Entries int
+}
+
+// verificationCache remembers recent verification outcomes, valid or not,
+// in least recently used order. An outcome depends on nothing but the key,
+// payload and signature, so a hit is as good as verifying again.
+type verificationCache struct {
+	mutex   sync.Mutex
+	size    int
+	ttl     time.Duration
+	entries map[verificationKey]*list.Element
+	order   *list.List // most recently used first
+
+	hits   atomic.Uint64
+	misses atomic.Uint64
+}
+
+var verifications = &verificationCache{
+	size:    DefaultVerificationCacheSize,
+	ttl:     DefaultVerificationCacheTTL,
+	entries: make(map[verificationKey]*list.Element),
+	order:   list.New(),
+}
+
+// SetVerificationCache has VerifySignature remember up to size outcomes for
+// ttl each. A size of 0 turns the cache off, so every signature is verified
+// in full; remembered outcomes are dropped either way.
+func SetVerificationCache(size int, ttl time.Duration) {
+	cache := verifications
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	cache.size, cache.ttl = size, ttl
+	cache.entries = make(map[verificationKey]*list.Element)
+	cache.order.Init()
+}
+
+// VerificationCacheStatistics reports the verification cache's hits,
+// misses and size.
+func VerificationCacheStatistics() VerificationCacheStats {
+	cache := verifications
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	return VerificationCacheStats{
+		Enabled: cache.size > 0,
+		Hits:    cache.hits.Load(),
+		Misses:  cache.misses.Load(),
+		Entries: cache.order.Len(),
+	}
+}
+
+// verify returns the remembered outcome of verifying signature over data
+// with publicKey, or runs verify and remembers its outcome.
+func (c *verificationCache) verify(algorithm string, publicKey, data, signature []byte, verify func() error) error {
+	c.mutex.Lock()
+	enabled := c.size > 0
+	c.mutex.Unlock()
+	if !enabled {
+		return verify()
+	}
+
+	key := verificationKey{
+		algorithm: algorithm,
+		publicKey: sha256.Sum256(publicKey),
+		payload:   sha256.Sum256(data),
+		signature: sha256.Sum256(signature),
+	}
+
+	c.mutex.Lock()
+	if element, ok := c.entries[key]; ok {
+		entry := element.Value.(*verificationEntry)
+		if time.Now().Before(entry.expires) {
+			c.order.MoveToFront(element)
+			c.mutex.Unlock()
+			c.hits.Add(1)
+			return entry.err
+		}
+		c.order.Remove(element)
+		delete(c.entries, key)
+	}
+	c.mutex.Unlock()
+
+	c.misses.Add(1)
+	err := verify()
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.size == 0 {
+		return err
+	}
+	if element, ok := c.entries[key]; ok {
+		c.order.Remove(element)
+	}
+	c.entries[key] = c.order.PushFront(&verificationEntry{key: key, err: err, expires: time.Now().Add(c.ttl)})
+	for c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*verificationEntry).key)
+	}
+	return err
+}
@@ -0,0 +1,139 @@
+package pqc
+
+import (
+	"container/list"
+	"errors"
+	"testing"
+	"time"
+)
+
+func newTestVerificationCache(size int, ttl time.Duration) *verificationCache {
+	return &verificationCache{size: size, ttl: ttl, entries: make(map[verificationKey]*list.Element), order: list.New()}
+}
+
+// countingVerify returns a verify function for the cache that answers
+// with err and counts its calls in calls.
+func countingVerify(calls *int, err error) func() error {
+	return func() error {
+		*calls++
+		return err
+	}
+}
+
+func TestVerificationCacheKeys(t *testing.T) {
+	cache := newTestVerificationCache(16, time.Minute)
+	key, payload, signature := []byte("key"), []byte("payload"), []byte("signature")
+
+	calls := 0
+	for range 2 {
+		if err := cache.verify(AlgorithmDilithium3, key, payload, signature, countingVerify(&calls, nil)); err != nil {
+			t.Fatalf("verify: %v", err)
+		}
+	}
+	if calls != 1 {
+		t.Fatalf("same verification ran %d times, want once", calls)
+	}
+
+	for _, tc := range []struct {
+		name                    string
+		algorithm               string
+		key, payload, signature []byte
+	}{
+		{"different key", AlgorithmDilithium3, []byte("other key"), payload, signature},
+		{"different payload", AlgorithmDilithium3, key, []byte("other payload"), signature},
+		{"different signature", AlgorithmDilithium3, key, payload, []byte("other signature")},
+		{"different algorithm", AlgorithmMLDSA65, key, payload, signature},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			calls := 0
+			cache.verify(tc.algorithm, tc.key, tc.payload, tc.signature, countingVerify(&calls, nil))
+			if calls != 1 {
+				t.Fatal("verification was answered from another one's outcome")
+			}
+		})
+	}
+
+	// Failures are remembered too, and answered as they were.
+	invalid := errors.New("invalid signature")
+	calls = 0
+	forged := []byte("forged")
+	for range 2 {
+		if err := cache.verify(AlgorithmDilithium3, key, payload, forged, countingVerify(&calls, invalid)); err != invalid {
+			t.Fatalf("got %v, want the remembered failure", err)
+		}
+	}
+	if calls != 1 {
+		t.Fatalf("failed verification ran %d times, want once", calls)
+	}
+}
+
+func TestVerificationCacheExpiry(t *testing.T) {
+	cache := newTestVerificationCache(16, 10*time.Millisecond)
+	calls := 0
+	cache.verify(AlgorithmDilithium3, []byte("key"), []byte("payload"), []byte("signature"), countingVerify(&calls, nil))
+	time.Sleep(20 * time.Millisecond)
+	cache.verify(AlgorithmDilithium3, []byte("key"), []byte("payload"), []byte("signature"), countingVerify(&calls, nil))
+	if calls != 2 {
+		t.Fatalf("expired outcome was used: verification ran %d times, want twice", calls)
+	}
+}
+
+func TestVerificationCacheEviction(t *testing.T) {
+	cache := newTestVerificationCache(2, time.Minute)
+	calls := 0
+	verify := func(signature string) {
+		cache.verify(AlgorithmDilithium3, []byte("key"), []byte("payload"), []byte(signature), countingVerify(&calls, nil))
+	}
+
+	verify("a")
+	verify("b")
+	verify("a") // a is now the most recently used
+	verify("c") // evicts b
+	if calls != 3 {
+		t.Fatalf("verifications ran %d times, want 3", calls)
+	}
+	verify("a")
+	if calls != 3 {
+		t.Fatal("most recently used outcome was evicted")
+	}
+	verify("b")
+	if calls != 4 {
+		t.Fatal("least recently used outcome was not evicted")
+	}
+	if got := cache.order.Len(); got != 2 {
+		t.Fatalf("cache holds %d outcomes, want 2", got)
+	}
+}
+
+// TestVerificationCacheStrict checks turning the cache off, as strict
+// verification does, drops remembered outcomes and verifies every
+// signature in full.
+func TestVerificationCacheStrict(t *testing.T) {
+	t.Cleanup(func() { SetVerificationCache(DefaultVerificationCacheSize, DefaultVerificationCacheTTL) })
+	SetVerificationCache(16, time.Minute)
+
+	keyPair, err := GenerateDilithiumKeyPair()
+	if err != nil {
+		t.Fatalf("GenerateDilithiumKeyPair: %v", err)
+	}
+	payload := []byte("payload")
+	signature, _ := keyPair.Sign(payload)
+	if err := VerifySignature(AlgorithmDilithium3, keyPair.GetPublicKeyBytes(), payload, signature); err != nil {
+		t.Fatalf("VerifySignature: %v", err)
+	}
+	if stats := VerificationCacheStatistics(); stats.Entries != 1 {
+		t.Fatalf("cache holds %d outcomes, want 1", stats.Entries)
+	}
+
+	SetVerificationCache(0, 0)
+	if stats := VerificationCacheStatistics(); stats.Enabled || stats.Entries != 0 {
+		t.Fatalf("strict mode kept the cache: %+v", stats)
+	}
+	calls := 0
+	for range 2 {
+		verifications.verify(AlgorithmDilithium3, keyPair.GetPublicKeyBytes(), payload, signature, countingVerify(&calls, nil))
+	}
+	if calls != 2 {
+		t.Fatalf("strict mode answered from the cache: verification ran %d times, want twice", calls)
+	}
+}
@@ -0,0 +1,76 @@
+package quota
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var counterBucket = []byte("quota_counters")
+
+// boltStore keeps counters in a BoltDB file, as an 8-byte value followed by
+// an 8-byte expiry in Unix seconds. Only one process can open the file, so
+// it suits a single gateway that must keep its counters across restarts.
+type boltStore struct {
+	db *bolt.DB
+}
+
+func openBolt(path string) (*boltStore, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open quota database %s: %w", path, err)
+	}
+
+	// Drop the counters of windows that ended while the gateway was down.
+	now := time.Now().Unix()
+	err = db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists(counterBucket)
+		if err != nil {
+			return err
+		}
+		var expired [][]byte
+		bucket.ForEach(func(key, value []byte) error {
+			if len(value) != 16 || int64(binary.BigEndian.Uint64(value[8:])) < now {
+				expired = append(expired, key)
+			}
+			return nil
+		})
+		for _, key := range expired {
+			if err := bucket.Delete(key); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to prepare quota database %s: %w", path, err)
+	}
+	return &boltStore{db: db}, nil
+}
+
+func (s *boltStore) Add(key string, delta int64, expires time.Time) (int64, error) {
+	var value int64
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(counterBucket)
+		if stored := bucket.Get([]byte(key)); len(stored) == 16 && int64(binary.BigEndian.Uint64(stored[8:])) >= time.Now().Unix() {
+			value = int64(binary.BigEndian.Uint64(stored[:8]))
+		}
+		value += delta
+
+		record := make([]byte, 16)
+		binary.BigEndian.PutUint64(record[:8], uint64(value))
+		binary.BigEndian.PutUint64(record[8:], uint64(expires.Unix()))
+		return bucket.Put([]byte(key), record)
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to update quota counter: %w", err)
+	}
+	return value, nil
+}
+
+func (s *boltStore) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,143 @@
+package quota
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"quantum-safe-mesh/pkg/models"
+)
+
+// Quota status headers on every response to a client with quotas. Reset is
+// the number of seconds until the window starts over.
+const (
+	HeaderRequestsLimit     = "X-Quota-Requests-Limit"
+	HeaderRequestsRemaining = "X-Quota-Requests-Remaining"
+	HeaderRequestsReset     = "X-Quota-Requests-Reset"
+	HeaderBytesLimit        = "X-Quota-Bytes-Limit"
+	HeaderBytesRemaining    = "X-Quota-Bytes-Remaining"
+	HeaderBytesReset        = "X-Quota-Bytes-Reset"
+)
+
+// Quotas caps how much each client may use: requests per UTC day and bytes,
+// request and response bodies together, per UTC calendar month. A limit of
+// zero is no limit. Counters live in a Store, so they survive restarts and,
+// in Redis, are shared by every gateway replica.
+type Quotas struct {
+	store          Store
+	requestsPerDay int64
+	bytesPerMonth  int64
+}
+
+func New(store Store, requestsPerDay, bytesPerMonth int64) *Quotas {
+	return &Quotas{store: store, requestsPerDay: requestsPerDay, bytesPerMonth: bytesPerMonth}
+}
+
+// Admit counts a request by client and reports its quota status in w's
+// headers. It answers 429 quota_exceeded and returns false once client has
+// used up either quota. If the store fails, the request is let through.
+func (q *Quotas) Admit(w http.ResponseWriter, r *http.Request, client string) bool {
+	now := time.Now().UTC()
+
+	if q.requestsPerDay > 0 {
+		day := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+		reset := day.AddDate(0, 0, 1)
+		used, err := q.store.Add(counterKey(client, "requests", day.Format(time.DateOnly)), 1, reset)
+		if err != nil {
+			log.Printf("⚠️  Failed to count request quota for %s, letting the request through: %v", client, err)
+		} else if !q.report(w, r, client, "requests", q.requestsPerDay-used, used > q.requestsPerDay, q.requestsPerDay, reset.Sub(now),
+			HeaderRequestsLimit, HeaderRequestsRemaining, HeaderRequestsReset) {
+			return false
+		}
+	}
+
+	if q.bytesPerMonth > 0 {
+		month := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+		reset := month.AddDate(0, 1, 0)
+		used, err := q.store.Add(counterKey(client, "bytes", month.Format("2006-01")), 0, reset)
+		if err != nil {
+			log.Printf("⚠️  Failed to read byte quota for %s, letting the request through: %v", client, err)
+		} else if !q.report(w, r, client, "bytes", q.bytesPerMonth-used, used >= q.bytesPerMonth, q.bytesPerMonth, reset.Sub(now),
+			HeaderBytesLimit, HeaderBytesRemaining, HeaderBytesReset) {
+			return false
+		}
+	}
+	return true
+}
+
+// report sets the headers for one quota, of which client has remaining
+// left, and rejects the request if it is exceeded.
+func (q *Quotas) report(w http.ResponseWriter, r *http.Request, client, quota string, remaining int64, exceeded bool, limit int64, reset time.Duration,
+	limitHeader, remainingHeader, resetHeader string) bool {
+	seconds := strconv.FormatInt(int64(reset.Round(time.Second)/time.Second), 10)
+	w.Header().Set(limitHeader, strconv.FormatInt(limit, 10))
+	w.Header().Set(remainingHeader, strconv.FormatInt(max(remaining, 0), 10))
+	w.Header().Set(resetHeader, seconds)
+	if !exceeded {
+		return true
+	}
+
+	log.Printf("🚫 %s has used up its %s quota of %d", client, quota, limit)
+	w.Header().Set("Retry-After", seconds)
+	models.WriteError(w, r, http.StatusTooManyRequests, models.ErrCodeQuotaExceeded, "Quota exceeded: "+quota)
+	return false
+}
+
+// Record adds the bytes a request by client moved to its monthly quota.
+func (q *Quotas) Record(client string, bytes int64) {
+	if q.bytesPerMonth <= 0 || bytes == 0 {
+		return
+	}
+	now := time.Now().UTC()
+	month := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	if _, err := q.store.Add(counterKey(client, "bytes", month.Format("2006-01")), bytes, month.AddDate(0, 1, 0)); err != nil {
+		log.Printf("⚠️  Failed to record %d bytes against %s's quota: %v", bytes, client, err)
+	}
+}
+
+func (q *Quotas) Close() error {
+	return q.store.Close()
+}
+
+func counterKey(client, quota, window string) string {
+	return "mesh:quota:" + client + ":" + quota + ":" + window
+}
+
+// Meter counts the bytes of a request body and of the response written to
+// it.
+type Meter struct {
+	http.ResponseWriter
+	bytes atomic.Int64
+}
+
+// NewMeter wraps w, and r's body, to count what passes through them.
+func NewMeter(w http.ResponseWriter, r *http.Request) *Meter {
+	m := &Meter{ResponseWriter: w}
+	r.Body = &meteredBody{ReadCloser: r.Body, meter: m}
+	return m
+}
+
+func (m *Meter) Write(data []byte) (int, error) {
+	n, err := m.ResponseWriter.Write(data)
+	m.bytes.Add(int64(n))
+	return n, err
+}
+
+// Bytes returns how many bytes were read and written so far.
+func (m *Meter) Bytes() int64 {
+	return m.bytes.Load()
+}
+
+type meteredBody struct {
+	io.ReadCloser
+	meter *Meter
+}
+
+func (b *meteredBody) Read(data []byte) (int, error) {
+	n, err := b.ReadCloser.Read(data)
+	b.meter.bytes.Add(int64(n))
+	return n, err
+}
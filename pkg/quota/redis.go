@@ -0,0 +1,47 @@
+package quota
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// redisTimeout bounds each round trip to Redis, so a slow Redis delays
+// requests by no more than this.
+const redisTimeout = 2 * time.Second
+
+type redisStore struct {
+	client *redis.Client
+}
+
+func openRedis(url string) (*redisStore, error) {
+	options, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Redis URL: %w", err)
+	}
+	return &redisStore{client: redis.NewClient(options)}, nil
+}
+
+// Add increments and sets the expiry in one transaction, so counters never
+// outlive their window even if the gateway stops in between.
+func (s *redisStore) Add(key string, delta int64, expires time.Time) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	var value *redis.IntCmd
+	_, err := s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		value = pipe.IncrBy(ctx, key, delta)
+		pipe.ExpireAt(ctx, key, expires)
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to update quota counter in Redis: %w", err)
+	}
+	return value.Val(), nil
+}
+
+func (s *redisStore) Close() error {
+	return s.client.Close()
+}
@@ -0,0 +1,84 @@
+package quota
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Store keeps quota counters. Counters are created at zero and forgotten
+// once they expire.
+type Store interface {
+	// Add adds delta to the counter key, which expires at expires, and
+	// returns its new value.
+	Add(key string, delta int64, expires time.Time) (int64, error)
+	Close() error
+}
+
+// Open opens a store from a URL: redis://host:6379/0 (or rediss://) for a
+// Redis shared by every gateway replica, bolt:///path/to/quota.db for a
+// BoltDB file, or "memory" for counters that only live as long as the
+// process.
+func Open(url string) (Store, error) {
+	if url == "memory" {
+		return newMemoryStore(), nil
+	}
+
+	scheme, path, found := strings.Cut(url, "://")
+	if !found {
+		return nil, fmt.Errorf("invalid quota store URL %q: expected redis://, bolt:// or memory", url)
+	}
+	switch scheme {
+	case "redis", "rediss":
+		return openRedis(url)
+	case "bolt":
+		return openBolt(path)
+	default:
+		return nil, fmt.Errorf("unsupported quota store scheme %q", scheme)
+	}
+}
+
+type memoryCounter struct {
+	value   int64
+	expires time.Time
+}
+
+type memoryStore struct {
+	mutex     sync.Mutex
+	counters  map[string]memoryCounter
+	lastSweep time.Time
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{counters: make(map[string]memoryCounter)}
+}
+
+func (s *memoryStore) Add(key string, delta int64, expires time.Time) (int64, error) {
+	now := time.Now()
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if now.Sub(s.lastSweep) > time.Hour {
+		for key, counter := range s.counters {
+			if now.After(counter.expires) {
+				delete(s.counters, key)
+			}
+		}
+		s.lastSweep = now
+	}
+
+	counter := s.counters[key]
+	if now.After(counter.expires) {
+		counter = memoryCounter{}
+	}
+	counter.value += delta
+	counter.expires = expires
+	s.counters[key] = counter
+	return counter.value, nil
+}
+
+func (s *memoryStore) Close() error {
+	return nil
+}
@@ -0,0 +1,111 @@
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"quantum-safe-mesh/pkg/httpclient"
+)
+
+// conjurTokenTTL is how long a Conjur access token is reused. Conjur issues
+// them for eight minutes.
+const conjurTokenTTL = 6 * time.Minute
+
+// Conjur fetches variables from a CyberArk Conjur server, authenticating
+// with a host or user's API key. References name the variable ID, e.g.
+// conjur:mesh/keys/passphrase.
+type Conjur struct {
+	url     string
+	account string
+	login   string
+	apiKey  string
+	client  *http.Client
+
+	mutex   sync.Mutex
+	token   string
+	expires time.Time
+}
+
+// NewConjur returns a provider reading variables in account from the
+// Conjur server at baseURL, as login.
+func NewConjur(baseURL, account, login, apiKey string) *Conjur {
+	return &Conjur{
+		url:     strings.TrimRight(baseURL, "/"),
+		account: account,
+		login:   login,
+		apiKey:  apiKey,
+		client:  httpclient.NewClient(10 * time.Second),
+	}
+}
+
+func (c *Conjur) Fetch(ctx context.Context, id string) (string, error) {
+	token, err := c.accessToken(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	endpoint := fmt.Sprintf("%s/secrets/%s/variable/%s", c.url, url.PathEscape(c.account), url.PathEscape(id))
+	value, status, err := c.do(ctx, http.MethodGet, endpoint, nil, token)
+	if status == http.StatusUnauthorized {
+		c.mutex.Lock()
+		c.token = ""
+		c.mutex.Unlock()
+	}
+	if err != nil {
+		return "", err
+	}
+	return string(value), nil
+}
+
+// accessToken authenticates to Conjur, or returns the token it got last
+// time while it is still fresh.
+func (c *Conjur) accessToken(ctx context.Context) (string, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.token != "" && time.Now().Before(c.expires) {
+		return c.token, nil
+	}
+
+	endpoint := fmt.Sprintf("%s/authn/%s/%s/authenticate", c.url, url.PathEscape(c.account), url.PathEscape(c.login))
+	token, _, err := c.do(ctx, http.MethodPost, endpoint, strings.NewReader(c.apiKey), "")
+	if err != nil {
+		return "", fmt.Errorf("failed to authenticate to Conjur as %s: %w", c.login, err)
+	}
+
+	c.token = base64.StdEncoding.EncodeToString(token)
+	c.expires = time.Now().Add(conjurTokenTTL)
+	return c.token, nil
+}
+
+func (c *Conjur) do(ctx context.Context, method, endpoint string, body io.Reader, token string) ([]byte, int, error) {
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, body)
+	if err != nil {
+		return nil, 0, err
+	}
+	if token != "" {
+		req.Header.Set("Authorization", fmt.Sprintf("Token token=%q", token))
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, resp.StatusCode, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, resp.StatusCode, fmt.Errorf("Conjur returned %s: %s", resp.Status, bytes.TrimSpace(data))
+	}
+	return data, resp.StatusCode, nil
+}
@@ -0,0 +1,170 @@
+// Package secrets resolves the passphrases, tokens and credentials mesh
+// services are configured with. A setting holds either the secret itself
+// or a reference to where it is kept, scheme:path, e.g.
+// env:KEYS_PASSPHRASE, file:/run/secrets/passphrase,
+// conjur:mesh/keys/passphrase or vault:secret/data/mesh#passphrase.
+package secrets
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Reference schemes.
+const (
+	SchemeEnv    = "env"
+	SchemeFile   = "file"
+	SchemeConjur = "conjur"
+	SchemeVault  = "vault"
+)
+
+// DefaultTTL is how long a Store serves a fetched secret before fetching it
+// again.
+const DefaultTTL = 5 * time.Minute
+
+// Provider fetches the secret at path, the part of a reference after its
+// scheme.
+type Provider interface {
+	Fetch(ctx context.Context, path string) (string, error)
+}
+
+// ProviderFunc adapts a function to a Provider.
+type ProviderFunc func(ctx context.Context, path string) (string, error)
+
+func (f ProviderFunc) Fetch(ctx context.Context, path string) (string, error) {
+	return f(ctx, path)
+}
+
+// Env reads secrets from environment variables.
+var Env = ProviderFunc(func(_ context.Context, name string) (string, error) {
+	value, exists := os.LookupEnv(name)
+	if !exists {
+		return "", fmt.Errorf("environment variable %s is not set", name)
+	}
+	return value, nil
+})
+
+// File reads secrets from files, such as those Docker and Kubernetes mount,
+// dropping a trailing newline.
+var File = ProviderFunc(func(_ context.Context, path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+})
+
+// Store resolves references through the providers registered for their
+// schemes, caching what it fetches. Once a cached secret is older than the
+// TTL it is fetched again, which picks up rotated secrets; if that fails
+// the cached value is kept until a fetch succeeds.
+type Store struct {
+	mutex     sync.Mutex
+	ttl       time.Duration
+	providers map[string]Provider
+	cache     map[string]cached
+}
+
+type cached struct {
+	value   string
+	fetched time.Time
+}
+
+// NewStore returns a store resolving env: and file: references, caching
+// secrets for ttl. Conjur and Vault references fail until a provider is
+// registered for them.
+func NewStore(ttl time.Duration) *Store {
+	return &Store{
+		ttl: ttl,
+		providers: map[string]Provider{
+			SchemeEnv:    Env,
+			SchemeFile:   File,
+			SchemeConjur: unconfigured(SchemeConjur),
+			SchemeVault:  unconfigured(SchemeVault),
+		},
+		cache: make(map[string]cached),
+	}
+}
+
+func unconfigured(scheme string) Provider {
+	return ProviderFunc(func(context.Context, string) (string, error) {
+		return "", fmt.Errorf("no %s server is configured", scheme)
+	})
+}
+
+// Register resolves references with scheme through provider, replacing any
+// provider registered for it before, and drops the secrets cached for it.
+func (s *Store) Register(scheme string, provider Provider) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.providers[scheme] = provider
+	for ref := range s.cache {
+		if strings.HasPrefix(ref, scheme+":") {
+			delete(s.cache, ref)
+		}
+	}
+}
+
+// SetTTL changes how long secrets are cached.
+func (s *Store) SetTTL(ttl time.Duration) {
+	s.mutex.Lock()
+	s.ttl = ttl
+	s.mutex.Unlock()
+}
+
+// IsReference reports whether value is a reference to a secret, rather than
+// the secret itself: it starts with a scheme a provider is registered for.
+func (s *Store) IsReference(value string) bool {
+	scheme, _, found := strings.Cut(value, ":")
+	if !found {
+		return false
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	_, exists := s.providers[scheme]
+	return exists
+}
+
+// Resolve returns the secret value refers to, or value itself if it is not
+// a reference.
+func (s *Store) Resolve(ctx context.Context, value string) (string, error) {
+	scheme, path, found := strings.Cut(value, ":")
+	if !found {
+		return value, nil
+	}
+
+	s.mutex.Lock()
+	provider, exists := s.providers[scheme]
+	entry, hit := s.cache[value]
+	ttl := s.ttl
+	s.mutex.Unlock()
+	if !exists {
+		return value, nil
+	}
+	if hit && time.Since(entry.fetched) < ttl {
+		return entry.value, nil
+	}
+
+	secret, err := provider.Fetch(ctx, path)
+	if err != nil {
+		if hit {
+			log.Printf("⚠️ Failed to refresh secret %s, keeping the cached value: %v", value, err)
+			return entry.value, nil
+		}
+		return "", fmt.Errorf("failed to fetch secret %s: %w", value, err)
+	}
+	if secret == "" {
+		return "", fmt.Errorf("secret %s is empty", value)
+	}
+
+	s.mutex.Lock()
+	s.cache[value] = cached{value: secret, fetched: time.Now()}
+	s.mutex.Unlock()
+	return secret, nil
+}
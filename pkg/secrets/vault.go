@@ -0,0 +1,72 @@
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"quantum-safe-mesh/pkg/httpclient"
+)
+
+// Vault fetches secrets from HashiCorp Vault with a token. References name
+// the secret's API path and the field to read, e.g.
+// vault:secret/data/mesh#passphrase for a KV version 2 engine mounted at
+// secret/.
+type Vault struct {
+	addr   string
+	token  string
+	client *http.Client
+}
+
+// NewVault returns a provider reading from the Vault server at addr.
+func NewVault(addr, token string) *Vault {
+	return &Vault{
+		addr:   strings.TrimRight(addr, "/"),
+		token:  token,
+		client: httpclient.NewClient(10 * time.Second),
+	}
+}
+
+func (v *Vault) Fetch(ctx context.Context, ref string) (string, error) {
+	path, field, found := strings.Cut(ref, "#")
+	if !found || path == "" || field == "" {
+		return "", fmt.Errorf("expected path#field, got %q", ref)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, v.addr+"/v1/"+strings.TrimPrefix(path, "/"), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-Vault-Token", v.token)
+
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		Data   map[string]interface{} `json:"data"`
+		Errors []string               `json:"errors"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil && resp.StatusCode == http.StatusOK {
+		return "", fmt.Errorf("failed to decode Vault response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("Vault returned %s: %s", resp.Status, strings.Join(body.Errors, "; "))
+	}
+
+	// KV version 2 nests the secret's fields under data.data.
+	data := body.Data
+	if nested, ok := data["data"].(map[string]interface{}); ok && data["metadata"] != nil {
+		data = nested
+	}
+	value, ok := data[field].(string)
+	if !ok {
+		return "", fmt.Errorf("%s has no string field %q", path, field)
+	}
+	return value, nil
+}
@@ -0,0 +1,121 @@
+package spiffe
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/spiffe/go-spiffe/v2/spiffeid"
+	"github.com/spiffe/go-spiffe/v2/svid/jwtsvid"
+	"github.com/spiffe/go-spiffe/v2/workloadapi"
+)
+
+// Audience is the JWT-SVID audience services present to the auth service.
+const Audience = "quantum-safe-mesh/auth-service"
+
+// Registration modes for the auth service. Optional accepts unauthenticated
+// first registrations but requires an SVID to re-register a service that
+// was bound to a SPIFFE ID; required demands one on every registration.
+const (
+	ModeOff      = "off"
+	ModeOptional = "optional"
+	ModeRequired = "required"
+)
+
+func ValidMode(mode string) bool {
+	return mode == ModeOff || mode == ModeOptional || mode == ModeRequired
+}
+
+// clientOptions points the Workload API client at socketPath. An empty path
+// leaves go-spiffe to read SPIFFE_ENDPOINT_SOCKET.
+func clientOptions(socketPath string) []workloadapi.ClientOption {
+	if socketPath == "" {
+		return nil
+	}
+	if strings.HasPrefix(socketPath, "/") {
+		socketPath = "unix://" + socketPath
+	}
+	return []workloadapi.ClientOption{workloadapi.WithAddr(socketPath)}
+}
+
+// FetchJWTSVID asks the local SPIRE agent for a JWT-SVID for audience and
+// returns the serialized token and the SPIFFE ID it asserts.
+func FetchJWTSVID(ctx context.Context, socketPath, audience string) (string, string, error) {
+	svid, err := workloadapi.FetchJWTSVID(ctx, jwtsvid.Params{Audience: audience}, clientOptions(socketPath)...)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to fetch JWT-SVID: %w", err)
+	}
+	return svid.Marshal(), svid.ID.String(), nil
+}
+
+// Validator checks JWT-SVIDs against the trust bundles served by the local
+// SPIRE agent, which it keeps up to date in the background.
+type Validator struct {
+	source   *workloadapi.JWTSource
+	audience string
+}
+
+func NewValidator(ctx context.Context, socketPath, audience string) (*Validator, error) {
+	source, err := workloadapi.NewJWTSource(ctx, workloadapi.WithClientOptions(clientOptions(socketPath)...))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create JWT bundle source: %w", err)
+	}
+	return &Validator{source: source, audience: audience}, nil
+}
+
+// Validate verifies token and returns the SPIFFE ID it asserts.
+func (v *Validator) Validate(token string) (string, error) {
+	svid, err := jwtsvid.ParseAndValidate(token, v.source, []string{v.audience})
+	if err != nil {
+		return "", fmt.Errorf("invalid JWT-SVID: %w", err)
+	}
+	return svid.ID.String(), nil
+}
+
+func (v *Validator) Close() error {
+	return v.source.Close()
+}
+
+// IDMap maps SPIFFE IDs to mesh service IDs.
+type IDMap map[string]string
+
+// ParseIDMap parses "spiffe://td/path=service-id" pairs separated by commas.
+func ParseIDMap(value string) (IDMap, error) {
+	idMap := make(IDMap)
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		id, serviceID, found := strings.Cut(entry, "=")
+		if !found || serviceID == "" {
+			return nil, fmt.Errorf("invalid SPIFFE ID mapping %q: expected spiffe-id=service-id", entry)
+		}
+		if _, err := spiffeid.FromString(id); err != nil {
+			return nil, fmt.Errorf("invalid SPIFFE ID %q: %w", id, err)
+		}
+		idMap[id] = serviceID
+	}
+	return idMap, nil
+}
+
+// ServiceID returns the mesh service ID for spiffeID: the mapped value if
+// there is one, otherwise the last segment of its path, so
+// spiffe://example.org/ns/prod/backend-service maps to backend-service.
+func (m IDMap) ServiceID(spiffeID string) (string, error) {
+	if serviceID, exists := m[spiffeID]; exists {
+		return serviceID, nil
+	}
+
+	id, err := spiffeid.FromString(spiffeID)
+	if err != nil {
+		return "", fmt.Errorf("invalid SPIFFE ID %q: %w", spiffeID, err)
+	}
+
+	path := id.Path()
+	if path == "" {
+		return "", fmt.Errorf("SPIFFE ID %q has no path to derive a service ID from", spiffeID)
+	}
+	return path[strings.LastIndex(path, "/")+1:], nil
+}
@@ -0,0 +1,276 @@
+// Package telemetry exports OpenTelemetry traces and metrics of the mesh's
+// cryptographic operations over OTLP/HTTP, so what post-quantum signatures
+// and key exchange really cost shows per request in any tracing backend.
+//
+// Each signature, verification, encapsulation, decapsulation, key load
+// and key fetch is a span named after it, e.g. "pqc.verify", and an
+// observation of the mesh.crypto.duration histogram by operation,
+// algorithm and outcome. Until Setup is given an endpoint, both go to
+// OpenTelemetry's no-op providers.
+package telemetry
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/propagation"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	"quantum-safe-mesh/pkg/pqc"
+)
+
+const instrumentationName = "quantum-safe-mesh"
+
+// Crypto operations.
+const (
+	OpSign        = "sign"
+	OpVerify      = "verify"
+	OpEncapsulate = "encapsulate"
+	OpDecapsulate = "decapsulate"
+	OpKeyLoad     = "key_load"
+	OpKeyFetch    = "key_fetch"
+)
+
+// Buckets of mesh.crypto.duration in seconds, from the tens of microseconds
+// a Dilithium verification takes to an SLH-DSA signature or a key fetch.
+var durationBuckets = []float64{
+	0.00001, 0.000025, 0.00005, 0.0001, 0.00025, 0.0005,
+	0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1,
+}
+
+// The global tracer and meter forward to the providers Setup installs, so
+// these work whether or not Setup has run yet.
+var (
+	tracer      = otel.Tracer(instrumentationName)
+	durations   metric.Float64Histogram
+	propagators = propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{})
+)
+
+func init() {
+	var err error
+	durations, err = otel.Meter(instrumentationName).Float64Histogram("mesh.crypto.duration",
+		metric.WithUnit("s"),
+		metric.WithDescription("Duration of post-quantum cryptographic operations"),
+		metric.WithExplicitBucketBoundaries(durationBuckets...))
+	if err != nil {
+		panic(err)
+	}
+}
+
+// Options configures Setup.
+type Options struct {
+	Endpoint string // OTLP/HTTP collector base URL, e.g. http://otel-collector:4318
+	Service  string // service.name of the exported telemetry
+	Version  string // service.version
+}
+
+// Setup exports traces and metrics to opts.Endpoint, and accepts and sends
+// W3C trace context. The returned function flushes and stops the
+// exporters. With no endpoint, Setup does nothing.
+func Setup(ctx context.Context, opts Options) (func(context.Context) error, error) {
+	if opts.Endpoint == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+	endpoint, err := url.Parse(opts.Endpoint)
+	if err != nil || endpoint.Host == "" || (endpoint.Scheme != "http" && endpoint.Scheme != "https") {
+		return nil, fmt.Errorf("invalid OTLP endpoint %q: expected an http(s) URL", opts.Endpoint)
+	}
+
+	traceOptions := []otlptracehttp.Option{
+		otlptracehttp.WithEndpoint(endpoint.Host),
+		otlptracehttp.WithURLPath(path.Join("/", endpoint.Path, "v1/traces")),
+	}
+	metricOptions := []otlpmetrichttp.Option{
+		otlpmetrichttp.WithEndpoint(endpoint.Host),
+		otlpmetrichttp.WithURLPath(path.Join("/", endpoint.Path, "v1/metrics")),
+	}
+	if endpoint.Scheme == "http" {
+		traceOptions = append(traceOptions, otlptracehttp.WithInsecure())
+		metricOptions = append(metricOptions, otlpmetrichttp.WithInsecure())
+	}
+
+	traceExporter, err := otlptracehttp.New(ctx, traceOptions...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create OTLP trace exporter: %w", err)
+	}
+	metricExporter, err := otlpmetrichttp.New(ctx, metricOptions...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create OTLP metric exporter: %w", err)
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(
+		attribute.String("service.name", opts.Service),
+		attribute.String("service.version", opts.Version),
+	))
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe telemetry resource: %w", err)
+	}
+
+	tracerProvider := sdktrace.NewTracerProvider(sdktrace.WithBatcher(traceExporter), sdktrace.WithResource(res))
+	meterProvider := sdkmetric.NewMeterProvider(sdkmetric.WithReader(sdkmetric.NewPeriodicReader(metricExporter)), sdkmetric.WithResource(res))
+	otel.SetTracerProvider(tracerProvider)
+	otel.SetMeterProvider(meterProvider)
+	otel.SetTextMapPropagator(propagators)
+
+	return func(ctx context.Context) error {
+		traceErr := tracerProvider.Shutdown(ctx)
+		if err := meterProvider.Shutdown(ctx); err != nil {
+			return err
+		}
+		return traceErr
+	}, nil
+}
+
+// Observe starts the span of crypto operation op with algorithm, a child
+// of ctx's span if it has one. The returned function ends the span with
+// the operation's error and records its duration. Key fetches, whose
+// algorithm is only known once fetched, pass an empty algorithm.
+func Observe(ctx context.Context, op, algorithm string) (context.Context, func(error)) {
+	attrs := []attribute.KeyValue{attribute.String("pqc.operation", op)}
+	if algorithm != "" {
+		attrs = append(attrs, attribute.String("pqc.algorithm", algorithm))
+	}
+	start := time.Now()
+	ctx, span := tracer.Start(ctx, "pqc."+op, trace.WithAttributes(attrs...))
+
+	return ctx, func(err error) {
+		elapsed := time.Since(start)
+		outcome := "ok"
+		if err != nil {
+			outcome = "error"
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+		durations.Record(ctx, elapsed.Seconds(), metric.WithAttributes(append(attrs, attribute.String("outcome", outcome))...))
+	}
+}
+
+// Sign signs data with signer, observed as OpSign.
+func Sign(ctx context.Context, signer pqc.Signer, data []byte) ([]byte, error) {
+	_, done := Observe(ctx, OpSign, signer.Algorithm())
+	signature, err := signer.Sign(data)
+	done(err)
+	return signature, err
+}
+
+// SignPooled is pqc.SignPooled observed as OpSign. The caller hands the
+// signature back with pqc.ReleaseSignature.
+func SignPooled(ctx context.Context, signer pqc.Signer, data []byte) ([]byte, error) {
+	_, done := Observe(ctx, OpSign, signer.Algorithm())
+	signature, err := pqc.SignPooled(signer, data)
+	done(err)
+	return signature, err
+}
+
+// Verify is pqc.VerifySignature observed as OpVerify.
+func Verify(ctx context.Context, alg string, publicKey, data, signature []byte) error {
+	_, done := Observe(ctx, OpVerify, Algorithm(alg))
+	err := pqc.VerifySignature(alg, publicKey, data, signature)
+	done(err)
+	return err
+}
+
+// Algorithm is the key store name of the signature algorithm alg, as the
+// telemetry reports it, so envelopes without an algorithm ID count as
+// dilithium3.
+func Algorithm(alg string) string {
+	if algorithm, err := pqc.SignatureAlgorithm(alg); err == nil {
+		return algorithm
+	}
+	return alg
+}
+
+// Middleware runs each request in a server span named after its route,
+// continuing the trace its traceparent header names, so the crypto spans
+// of handlers that use the request's context show under the caller's
+// request.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := r.URL.Path
+		if current := mux.CurrentRoute(r); current != nil {
+			if template, err := current.GetPathTemplate(); err == nil {
+				route = template
+			}
+		}
+
+		ctx := propagators.Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := tracer.Start(ctx, r.Method+" "+route,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				attribute.String("http.request.method", r.Method),
+				attribute.String("url.path", r.URL.Path),
+			))
+		defer span.End()
+
+		rw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rw, r.WithContext(ctx))
+
+		span.SetAttributes(attribute.Int("http.response.status_code", rw.status))
+		if rw.status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(rw.status))
+		}
+	})
+}
+
+// Client starts the client span of an outbound request, a child of the
+// span of the request's context, and adds its trace context to the
+// request's headers. The returned function ends the span with the
+// request's outcome.
+func Client(req *http.Request) func(*http.Response, error) {
+	ctx, span := tracer.Start(req.Context(), req.Method,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("http.request.method", req.Method),
+			attribute.String("server.address", req.URL.Host),
+			attribute.String("url.path", req.URL.Path),
+		))
+	Inject(ctx, req.Header)
+
+	return func(resp *http.Response, err error) {
+		switch {
+		case err != nil:
+			span.SetStatus(codes.Error, err.Error())
+		case resp.StatusCode >= http.StatusInternalServerError:
+			span.SetAttributes(attribute.Int("http.response.status_code", resp.StatusCode))
+			span.SetStatus(codes.Error, http.StatusText(resp.StatusCode))
+		default:
+			span.SetAttributes(attribute.Int("http.response.status_code", resp.StatusCode))
+		}
+		span.End()
+	}
+}
+
+// Inject adds ctx's trace context to the headers of an outbound request,
+// so the peer's spans join the trace.
+func Inject(ctx context.Context, header http.Header) {
+	propagators.Inject(ctx, propagation.HeaderCarrier(header))
+}
+
+// statusWriter notes the status of the response.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,337 @@
+package wasmfilter
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+
+	"quantum-safe-mesh/pkg/mesh"
+	"quantum-safe-mesh/pkg/models"
+)
+
+// WebAssembly filters let operators deploy gateway filters without
+// recompiling the gateway. A module is a WASI reactor exporting:
+//
+//	memory
+//	malloc(size i32) i32
+//	on_request(ptr i32, len i32) i64   (optional)
+//	on_response(ptr i32, len i32) i64  (optional)
+//
+// The gateway writes a JSON Request or ResponseDocument into memory from
+// malloc and calls the hook, which returns 0 to leave it unchanged or
+// ptr<<32|len of the JSON document to replace it with. Setting the
+// document's error rejects the request. Every call runs in a fresh
+// instance, under a memory limit and a timeout.
+
+const (
+	DefaultMemoryLimitMiB = 16
+	DefaultTimeout        = 100 * time.Millisecond
+
+	// pagesPerMiB is how many 64 KiB WebAssembly pages make a MiB.
+	pagesPerMiB = 16
+)
+
+// Call results, as counted in the mesh_filter_calls_total metric.
+const (
+	ResultOK       = "ok"
+	ResultRejected = "rejected"
+	ResultFailed   = "failed"
+)
+
+const (
+	hookRequest  = "on_request"
+	hookResponse = "on_response"
+)
+
+// Limits bound what a filter call may use.
+type Limits struct {
+	MemoryMiB int
+	Timeout   time.Duration
+}
+
+// RequestDocument is what on_request sees of a request. Filters may change
+// its headers and body.
+type RequestDocument struct {
+	Route   string            `json:"route"`
+	Caller  string            `json:"caller,omitempty"`
+	Method  string            `json:"method"`
+	Path    string            `json:"path"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Body    []byte            `json:"body,omitempty"`
+	Error   *Rejection        `json:"error,omitempty"`
+}
+
+// ResponseDocument is what on_response sees of a response. Filters may
+// change its body and, in envelope mode, its envelope headers; the status
+// is read-only. In envelope mode the body must stay valid JSON.
+type ResponseDocument struct {
+	Route   string            `json:"route"`
+	Caller  string            `json:"caller,omitempty"`
+	Status  int               `json:"status"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Body    []byte            `json:"body,omitempty"`
+	Error   *Rejection        `json:"error,omitempty"`
+
+	envelope bool
+}
+
+// Rejection is set by a filter to stop the request with an error response.
+// Status must be a 4xx or 5xx status; Code defaults to invalid_request.
+type Rejection struct {
+	Status  int    `json:"status"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// ParseModules parses comma-separated name=path.wasm pairs.
+func ParseModules(value string) (map[string]string, error) {
+	modules := make(map[string]string)
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, path, found := strings.Cut(entry, "=")
+		name, path = strings.TrimSpace(name), strings.TrimSpace(path)
+		if !found || name == "" || path == "" {
+			return nil, fmt.Errorf("invalid WASM filter %q: expected name=path.wasm", entry)
+		}
+		if _, exists := modules[name]; exists {
+			return nil, fmt.Errorf("WASM filter %q listed twice", name)
+		}
+		modules[name] = path
+	}
+	return modules, nil
+}
+
+// Filter is a gateway filter backed by a compiled WebAssembly module.
+type Filter struct {
+	name    string
+	runtime wazero.Runtime
+	module  wazero.CompiledModule
+	hooks   map[string]bool
+	timeout time.Duration
+	metrics *mesh.Metrics
+}
+
+// Load compiles the module at path as the filter name. Calls are counted
+// in metrics, if it is not nil.
+func Load(name, path string, limits Limits, metrics *mesh.Metrics) (*Filter, error) {
+	code, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read WASM filter %s: %w", name, err)
+	}
+
+	ctx := context.Background()
+	runtime := wazero.NewRuntimeWithConfig(ctx, wazero.NewRuntimeConfig().
+		WithMemoryLimitPages(uint32(limits.MemoryMiB*pagesPerMiB)).
+		WithCloseOnContextDone(true))
+	if _, err := wasi_snapshot_preview1.Instantiate(ctx, runtime); err != nil {
+		runtime.Close(ctx)
+		return nil, fmt.Errorf("failed to instantiate WASI for WASM filter %s: %w", name, err)
+	}
+	module, err := runtime.CompileModule(ctx, code)
+	if err != nil {
+		runtime.Close(ctx)
+		return nil, fmt.Errorf("failed to compile WASM filter %s: %w", name, err)
+	}
+
+	f := &Filter{
+		name:    name,
+		runtime: runtime,
+		module:  module,
+		hooks:   make(map[string]bool),
+		timeout: limits.Timeout,
+		metrics: metrics,
+	}
+	exports := module.ExportedFunctions()
+	for _, hook := range []string{hookRequest, hookResponse} {
+		if _, ok := exports[hook]; ok {
+			f.hooks[hook] = true
+		}
+	}
+	if _, ok := module.ExportedMemories()["memory"]; !ok {
+		err = fmt.Errorf("WASM filter %s does not export memory", name)
+	} else if _, ok := exports["malloc"]; !ok {
+		err = fmt.Errorf("WASM filter %s does not export malloc", name)
+	} else if len(f.hooks) == 0 {
+		err = fmt.Errorf("WASM filter %s exports neither %s nor %s", name, hookRequest, hookResponse)
+	}
+	if err != nil {
+		runtime.Close(ctx)
+		return nil, err
+	}
+	return f, nil
+}
+
+// Close releases the filter's runtime.
+func (f *Filter) Close() error {
+	return f.runtime.Close(context.Background())
+}
+
+// OnRequest runs the module's on_request hook, if it has one.
+func (f *Filter) OnRequest(ctx *mesh.FilterContext, call *mesh.Call) error {
+	if !f.hooks[hookRequest] {
+		return nil
+	}
+
+	doc := RequestDocument{
+		Route:   ctx.Route,
+		Caller:  callerID(ctx),
+		Method:  call.Method,
+		Path:    call.Path,
+		Headers: call.Headers,
+		Body:    call.Body,
+	}
+	var out RequestDocument
+	changed, err := f.run(hookRequest, &doc, &out)
+	if err != nil || !changed {
+		return err
+	}
+	call.Headers, call.Body = out.Headers, out.Body
+	return nil
+}
+
+// OnResponse runs the module's on_response hook, if it has one.
+func (f *Filter) OnResponse(ctx *mesh.FilterContext, resp *mesh.Response) error {
+	if !f.hooks[hookResponse] {
+		return nil
+	}
+
+	doc := ResponseDocument{
+		Route:  ctx.Route,
+		Caller: callerID(ctx),
+		Status: resp.StatusCode,
+		Body:   resp.Body,
+	}
+	out := ResponseDocument{envelope: resp.Envelope != nil}
+	if out.envelope {
+		doc.Headers, doc.Body = resp.Envelope.Headers, resp.Envelope.Data
+	}
+	changed, err := f.run(hookResponse, &doc, &out)
+	if err != nil || !changed {
+		return err
+	}
+
+	if !out.envelope {
+		resp.Body = out.Body
+		return nil
+	}
+	resp.Envelope.Headers, resp.Envelope.Data = out.Headers, out.Body
+	return nil
+}
+
+// run calls hook with doc and, if the module returns a replacement, decodes
+// it into out. A rejection is returned as a *models.ErrorResponse.
+func (f *Filter) run(hook string, doc, out any) (bool, error) {
+	start := time.Now()
+	output, err := f.call(hook, doc)
+	if err != nil {
+		f.count(hook, ResultFailed, time.Since(start))
+		return false, err
+	}
+	if output == nil {
+		f.count(hook, ResultOK, time.Since(start))
+		return false, nil
+	}
+
+	if err := json.Unmarshal(output, out); err != nil {
+		f.count(hook, ResultFailed, time.Since(start))
+		return false, fmt.Errorf("WASM filter %s returned a malformed document from %s: %w", f.name, hook, err)
+	}
+	var rejection *Rejection
+	switch out := out.(type) {
+	case *RequestDocument:
+		rejection = out.Error
+	case *ResponseDocument:
+		rejection = out.Error
+		if rejection == nil && out.envelope && !json.Valid(out.Body) {
+			f.count(hook, ResultFailed, time.Since(start))
+			return false, fmt.Errorf("WASM filter %s returned a response body that is not JSON", f.name)
+		}
+	}
+	if rejection != nil {
+		if rejection.Status < 400 || rejection.Status > 599 {
+			f.count(hook, ResultFailed, time.Since(start))
+			return false, fmt.Errorf("WASM filter %s rejected the request with invalid status %d", f.name, rejection.Status)
+		}
+		f.count(hook, ResultRejected, time.Since(start))
+		code := rejection.Code
+		if code == "" {
+			code = models.ErrCodeInvalidRequest
+		}
+		return false, models.NewError(rejection.Status, code, rejection.Message)
+	}
+	f.count(hook, ResultOK, time.Since(start))
+	return true, nil
+}
+
+// call runs hook in a fresh instance of the module and returns a copy of
+// the document it returned, or nil if it returned none.
+func (f *Filter) call(hook string, doc any) ([]byte, error) {
+	input, err := json.Marshal(doc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal %s document: %w", hook, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), f.timeout)
+	defer cancel()
+
+	instance, err := f.runtime.InstantiateModule(ctx, f.module, wazero.NewModuleConfig().
+		WithName("").
+		WithStartFunctions("_initialize"))
+	if err != nil {
+		return nil, f.callError(ctx, "instantiate", err)
+	}
+	defer instance.Close(context.Background())
+
+	results, err := instance.ExportedFunction("malloc").Call(ctx, uint64(len(input)))
+	if err != nil {
+		return nil, f.callError(ctx, "malloc", err)
+	}
+	ptr := uint32(results[0])
+	memory := instance.Memory()
+	if !memory.Write(ptr, input) {
+		return nil, fmt.Errorf("WASM filter %s: malloc returned memory out of range", f.name)
+	}
+
+	results, err = instance.ExportedFunction(hook).Call(ctx, uint64(ptr), uint64(len(input)))
+	if err != nil {
+		return nil, f.callError(ctx, hook, err)
+	}
+	if results[0] == 0 {
+		return nil, nil
+	}
+	output, ok := memory.Read(uint32(results[0]>>32), uint32(results[0]))
+	if !ok {
+		return nil, fmt.Errorf("WASM filter %s: %s returned memory out of range", f.name, hook)
+	}
+	return append([]byte(nil), output...), nil
+}
+
+func (f *Filter) callError(ctx context.Context, step string, err error) error {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("WASM filter %s timed out after %s in %s", f.name, f.timeout, step)
+	}
+	return fmt.Errorf("WASM filter %s failed in %s: %w", f.name, step, err)
+}
+
+func (f *Filter) count(hook, result string, duration time.Duration) {
+	if f.metrics != nil {
+		f.metrics.CountFilterCall(f.name, hook, result, duration)
+	}
+}
+
+func callerID(ctx *mesh.FilterContext) string {
+	if ctx.Caller == nil {
+		return ""
+	}
+	return ctx.Caller.ServiceID
+}